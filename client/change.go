@@ -65,6 +65,11 @@ type Task struct {
 
 	SpawnTime time.Time `json:"spawn-time,omitempty"`
 	ReadyTime time.Time `json:"ready-time,omitempty"`
+
+	// Retries is the number of failed attempts to run the task that were
+	// retried so far, and NextRetryTime when it will be retried next.
+	Retries       int       `json:"retries,omitempty"`
+	NextRetryTime time.Time `json:"next-retry-time,omitempty"`
 }
 
 type TaskProgress struct {
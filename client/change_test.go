@@ -61,6 +61,24 @@ func (cs *clientSuite) TestClientChange(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestClientChangeTaskRetries(c *check.C) {
+	cs.rsp = `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "foo",
+  "summary": "...",
+  "status": "Doing",
+  "ready": false,
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "tasks": [{"kind": "bar", "summary": "...", "status": "Doing", "progress": {"done": 0, "total": 1}, "spawn-time": "2016-04-21T01:02:03Z", "retries": 2, "next-retry-time": "2016-04-21T01:04:03Z"}]
+}}`
+
+	chg, err := cs.cli.Change("uno")
+	c.Assert(err, check.IsNil)
+	c.Assert(chg.Tasks, check.HasLen, 1)
+	c.Check(chg.Tasks[0].Retries, check.Equals, 2)
+	c.Check(chg.Tasks[0].NextRetryTime, check.DeepEquals, time.Date(2016, 04, 21, 1, 4, 3, 0, time.UTC))
+}
+
 func (cs *clientSuite) TestClientChangeData(c *check.C) {
 	cs.rsp = `{"type": "sync", "result": {
   "id":   "uno",
@@ -670,6 +670,23 @@ type RefreshInfo struct {
 	Last     string `json:"last,omitempty"`
 	Hold     string `json:"hold,omitempty"`
 	Next     string `json:"next,omitempty"`
+	// Window is the refresh window of the system, if any.
+	Window *RefreshWindow `json:"window,omitempty"`
+	// SnapWindows holds the refresh windows of the snaps with their own.
+	SnapWindows map[string]*RefreshWindow `json:"snap-windows,omitempty"`
+}
+
+// RefreshWindow describes when auto-refreshes may happen.
+type RefreshWindow struct {
+	// MaintenanceWindow contains the schedule outside of which
+	// auto-refreshes do not happen, in the refresh.timer format.
+	MaintenanceWindow string `json:"maintenance-window,omitempty"`
+	// Blackout contains the dates on which auto-refreshes do not happen.
+	Blackout string `json:"blackout,omitempty"`
+	// Status is "open", "closed" or "blackout".
+	Status string `json:"status"`
+	// NextOpen is when auto-refreshes may happen next, if not now.
+	NextOpen string `json:"next-open,omitempty"`
 }
 
 // SysInfo holds system information
@@ -111,6 +111,13 @@ const (
 	// ongoing change.
 	ErrorKindQuotaChangeConflict ErrorKind = "quota-change-conflict"
 
+	// ErrorKindRegistryChangeConflict: the requested operation would
+	// conflict with a currently ongoing change affecting the same
+	// paths in the registry. This is a temporary error. The error
+	// `value` is an object with fields `account`, `registry-name`
+	// and optionally `change-kind` of the ongoing change.
+	ErrorKindRegistryChangeConflict ErrorKind = "registry-change-conflict"
+
 	// ErrorKindNotSnap: the given snap or directory does not
 	// look like a snap.
 	ErrorKindNotSnap ErrorKind = "snap-not-a-snap"
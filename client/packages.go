@@ -84,6 +84,9 @@ type Snap struct {
 	Tracks []string `json:"tracks,omitempty"`
 
 	Health *SnapHealth `json:"health,omitempty"`
+	// HealthHistory holds the last health reports of the snap, oldest
+	// first. It is only set when querying a single snap.
+	HealthHistory []SnapHealth `json:"health-history,omitempty"`
 
 	// Hold is the time until which the snap's refreshes are held by the user.
 	Hold *time.Time `json:"hold,omitempty"`
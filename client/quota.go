@@ -35,6 +35,7 @@ type postQuotaData struct {
 	Snaps       []string     `json:"snaps,omitempty"`
 	Services    []string     `json:"services,omitempty"`
 	Constraints *QuotaValues `json:"constraints,omitempty"`
+	UserApps    *bool        `json:"user-apps,omitempty"`
 }
 
 type QuotaGroupResult struct {
@@ -45,6 +46,24 @@ type QuotaGroupResult struct {
 	Services    []string     `json:"services,omitempty"`
 	Constraints *QuotaValues `json:"constraints,omitempty"`
 	Current     *QuotaValues `json:"current,omitempty"`
+	// UserApps is set when the apps of the snaps in the group, and not
+	// only their services, are subject to the limits of the group.
+	UserApps bool `json:"user-apps,omitempty"`
+	// History holds the statistics of the sampled usage of the limited
+	// resources of the group, by resource ("memory" or "threads"), only
+	// when getting a single group. The usage of the CPU, IO and network is
+	// not sampled.
+	History map[string][]QuotaUsageStats `json:"history,omitempty"`
+}
+
+// QuotaUsageStats are the statistics of the usage of a resource of a quota
+// group over a window of time, in bytes for the memory.
+type QuotaUsageStats struct {
+	Window  time.Duration `json:"window"`
+	Samples int           `json:"samples"`
+	Min     uint64        `json:"min"`
+	Avg     uint64        `json:"avg"`
+	Max     uint64        `json:"max"`
 }
 
 type QuotaCPUValues struct {
@@ -66,12 +85,38 @@ type QuotaJournalValues struct {
 	*QuotaJournalRate
 }
 
+// QuotaIOValues are the IO limits for a device, with bandwidths in bytes
+// per second.
+type QuotaIOValues struct {
+	Device         string        `json:"device"`
+	ReadBandwidth  quantity.Size `json:"read-bandwidth,omitempty"`
+	WriteBandwidth quantity.Size `json:"write-bandwidth,omitempty"`
+	ReadIOPS       int           `json:"read-iops,omitempty"`
+	WriteIOPS      int           `json:"write-iops,omitempty"`
+}
+
+// QuotaNetworkValues are the network limits, with the egress bandwidth in
+// bytes per second, or the network usage, with the egress in bytes.
+type QuotaNetworkValues struct {
+	EgressBandwidth quantity.Size `json:"egress-bandwidth,omitempty"`
+	Egress          quantity.Size `json:"egress,omitempty"`
+}
+
 type QuotaValues struct {
-	Memory  quantity.Size       `json:"memory,omitempty"`
+	Memory quantity.Size `json:"memory,omitempty"`
+	// MemoryHigh, MemorySwap and OOMPolicy are only set along with, or
+	// for a group that has, a memory limit. A zero MemorySwap disables
+	// swap.
+	MemoryHigh quantity.Size  `json:"memory-high,omitempty"`
+	MemorySwap *quantity.Size `json:"memory-swap,omitempty"`
+	OOMPolicy  string         `json:"oom-policy,omitempty"`
+
 	CPU     *QuotaCPUValues     `json:"cpu,omitempty"`
 	CPUSet  *QuotaCPUSetValues  `json:"cpu-set,omitempty"`
 	Threads int                 `json:"threads,omitempty"`
 	Journal *QuotaJournalValues `json:"journal,omitempty"`
+	IO      []QuotaIOValues     `json:"io,omitempty"`
+	Network *QuotaNetworkValues `json:"network,omitempty"`
 }
 
 type EnsureQuotaOptions struct {
@@ -84,6 +129,9 @@ type EnsureQuotaOptions struct {
 	// Constraints are the resource limits that should be applied to the quota group,
 	// these are added or modified, not removed.
 	Constraints *QuotaValues
+	// UserApps, if not nil, sets whether the apps of the snaps in the quota
+	// group, and not only their services, are subject to its limits
+	UserApps *bool
 }
 
 // EnsureQuota creates a quota group or updates an existing group with the options
@@ -105,6 +153,7 @@ func (client *Client) EnsureQuota(groupName string, opts *EnsureQuotaOptions) (c
 		Snaps:       opts.Snaps,
 		Services:    opts.Services,
 		Constraints: opts.Constraints,
+		UserApps:    opts.UserApps,
 	}
 
 	var body bytes.Buffer
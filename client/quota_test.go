@@ -109,6 +109,90 @@ func (cs *clientSuite) TestEnsureQuotaGroup(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestEnsureQuotaGroupUserApps(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"change": "42"
+	}`
+
+	userApps := false
+	chgID, err := cs.cli.EnsureQuota("foo", &client.EnsureQuotaOptions{
+		UserApps: &userApps,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(chgID, check.Equals, "42")
+	body, err := io.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var req map[string]interface{}
+	err = jsonutil.DecodeWithNumber(bytes.NewReader(body), &req)
+	c.Assert(err, check.IsNil)
+	c.Assert(req, check.DeepEquals, map[string]interface{}{
+		"action":     "ensure",
+		"group-name": "foo",
+		"user-apps":  false,
+	})
+}
+
+func (cs *clientSuite) TestEnsureQuotaGroupMemoryHighSwapOOMPolicy(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"change": "42"
+	}`
+
+	swap := quantity.SizeGiB
+	chgID, err := cs.cli.EnsureQuota("foo", &client.EnsureQuotaOptions{
+		Constraints: &client.QuotaValues{
+			MemoryHigh: quantity.SizeMiB,
+			MemorySwap: &swap,
+			OOMPolicy:  "kill-group",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(chgID, check.Equals, "42")
+	body, err := io.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var req map[string]interface{}
+	err = jsonutil.DecodeWithNumber(bytes.NewReader(body), &req)
+	c.Assert(err, check.IsNil)
+	c.Assert(req, check.DeepEquals, map[string]interface{}{
+		"action":     "ensure",
+		"group-name": "foo",
+		"constraints": map[string]interface{}{
+			"memory-high": json.Number("1048576"),
+			"memory-swap": json.Number("1073741824"),
+			"oom-policy":  "kill-group",
+		},
+	})
+}
+
+func (cs *clientSuite) TestEnsureQuotaGroupMemorySwapZero(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"type": "async",
+		"status-code": 202,
+		"change": "42"
+	}`
+
+	// a zero swap limit is sent, as it disables swap
+	var swap quantity.Size
+	_, err := cs.cli.EnsureQuota("foo", &client.EnsureQuotaOptions{
+		Constraints: &client.QuotaValues{MemorySwap: &swap},
+	})
+	c.Assert(err, check.IsNil)
+	body, err := io.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	var req map[string]interface{}
+	err = jsonutil.DecodeWithNumber(bytes.NewReader(body), &req)
+	c.Assert(err, check.IsNil)
+	c.Check(req["constraints"], check.DeepEquals, map[string]interface{}{
+		"memory-swap": json.Number("0"),
+	})
+}
+
 func (cs *clientSuite) TestEnsureQuotaGroupError(c *check.C) {
 	cs.status = 500
 	cs.rsp = `{"type": "error"}`
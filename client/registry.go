@@ -25,19 +25,44 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+
+	"github.com/snapcore/snapd/jsonutil"
 )
 
-func (c *Client) RegistryGetViaView(viewID string, requests []string) (result map[string]interface{}, err error) {
+// RegistryGetViaView gets the values of the requested fields through the view.
+// If the view's data must first be loaded by the snaps that store it, the
+// values aren't returned directly and, instead, the ID of the change loading
+// them is returned. Once the change is ready, the values are available in its
+// "values" data entry.
+func (c *Client) RegistryGetViaView(viewID string, requests []string) (result map[string]interface{}, changeID string, err error) {
 	query := url.Values{}
 	query.Add("fields", strings.Join(requests, ","))
 
 	endpoint := fmt.Sprintf("/v2/registry/%s", viewID)
-	_, err = c.doSync("GET", endpoint, query, nil, nil, &result)
+
+	var rsp response
+	statusCode, err := c.do("GET", endpoint, query, nil, nil, &rsp, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if err := rsp.err(c, statusCode); err != nil {
+		return nil, "", err
 	}
 
-	return result, nil
+	switch rsp.Type {
+	case "async":
+		if rsp.Change == "" {
+			return nil, "", fmt.Errorf("async response without change reference")
+		}
+		return nil, rsp.Change, nil
+	case "sync":
+		if err := jsonutil.DecodeWithNumber(bytes.NewReader(rsp.Result), &result); err != nil {
+			return nil, "", fmt.Errorf("cannot unmarshal: %v", err)
+		}
+		return result, "", nil
+	default:
+		return nil, "", fmt.Errorf("unexpected response type %q", rsp.Type)
+	}
 }
 
 func (c *Client) RegistrySetViaView(viewID string, requestValues map[string]interface{}) (changeID string, err error) {
@@ -30,8 +30,9 @@ import (
 func (cs *clientSuite) TestRegistryGet(c *C) {
 	cs.rsp = `{"type": "sync", "result":{"foo":"baz","bar":1}}`
 
-	res, err := cs.cli.RegistryGetViaView("a/b/c", []string{"foo", "bar"})
+	res, chgID, err := cs.cli.RegistryGetViaView("a/b/c", []string{"foo", "bar"})
 	c.Check(err, IsNil)
+	c.Check(chgID, Equals, "")
 	c.Check(res, DeepEquals, map[string]interface{}{"foo": "baz", "bar": json.Number("1")})
 	c.Assert(cs.reqs, HasLen, 1)
 	c.Check(cs.reqs[0].Method, Equals, "GET")
@@ -39,6 +40,29 @@ func (cs *clientSuite) TestRegistryGet(c *C) {
 	c.Check(cs.reqs[0].URL.Query(), DeepEquals, url.Values{"fields": []string{"foo,bar"}})
 }
 
+func (cs *clientSuite) TestRegistryGetLoadedByChange(c *C) {
+	cs.status = 202
+	cs.rsp = `{"type": "async", "status-code": 202, "change": "123"}`
+
+	res, chgID, err := cs.cli.RegistryGetViaView("a/b/c", []string{"foo"})
+	c.Check(err, IsNil)
+	c.Check(res, IsNil)
+	c.Check(chgID, Equals, "123")
+	c.Assert(cs.reqs, HasLen, 1)
+	c.Check(cs.reqs[0].Method, Equals, "GET")
+	c.Check(cs.reqs[0].URL.Path, Equals, "/v2/registry/a/b/c")
+}
+
+func (cs *clientSuite) TestRegistryGetError(c *C) {
+	cs.status = 404
+	cs.rsp = `{"type": "error", "status-code": 404, "result": {"message": "cannot find view"}}`
+
+	res, chgID, err := cs.cli.RegistryGetViaView("a/b/c", []string{"foo"})
+	c.Check(err, ErrorMatches, "cannot find view")
+	c.Check(res, IsNil)
+	c.Check(chgID, Equals, "")
+}
+
 func (cs *clientSuite) TestRegistrySet(c *C) {
 	cs.status = 202
 	cs.rsp = `{"type": "async", "status-code": 202, "change": "123"}`
@@ -63,6 +63,9 @@ type SnapOptions struct {
 	Time             string          `json:"time,omitempty"`
 	HoldLevel        string          `json:"hold-level,omitempty"`
 	Users            []string        `json:"users,omitempty"`
+	// SoakPeriod is how long to watch the health of snaps refreshed
+	// as a group, reverting all of them if any fails.
+	SoakPeriod string `json:"soak-period,omitempty"`
 }
 
 func writeFieldBool(mw *multipart.Writer, key string, val bool) error {
@@ -135,6 +138,9 @@ type multiActionData struct {
 	Time           string              `json:"time,omitempty"`
 	HoldLevel      string              `json:"hold-level,omitempty"`
 	Components     map[string][]string `json:"components,omitempty"`
+	SoakPeriod     string              `json:"soak-period,omitempty"`
+
+	SnapshotPassphrase string `json:"snapshot-passphrase,omitempty"`
 }
 
 // Install adds the snap with the given name from the given channel (or
@@ -205,7 +211,33 @@ func (client *Client) Switch(name string, options *SnapOptions) (changeID string
 
 // SnapshotMany snapshots many snaps (all, if names empty) for many users (all, if users is empty).
 func (client *Client) SnapshotMany(names []string, users []string) (setID uint64, changeID string, err error) {
-	result, changeID, err := client.doMultiSnapActionFull("snapshot", names, nil, &SnapOptions{Users: users})
+	return client.snapshotMany(names, users, "")
+}
+
+// SnapshotManyWithPassphrase snapshots many snaps like SnapshotMany, with the
+// snapshots encrypted with a key derived from the passphrase.
+func (client *Client) SnapshotManyWithPassphrase(names []string, users []string, passphrase string) (setID uint64, changeID string, err error) {
+	if passphrase == "" {
+		return 0, "", fmt.Errorf("cannot encrypt snapshots with an empty passphrase")
+	}
+	return client.snapshotMany(names, users, passphrase)
+}
+
+func (client *Client) snapshotMany(names []string, users []string, passphrase string) (setID uint64, changeID string, err error) {
+	action := multiActionData{
+		Action:             "snapshot",
+		Snaps:              names,
+		Users:              users,
+		SnapshotPassphrase: passphrase,
+	}
+	data, err := json.Marshal(&action)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot marshal multi-snap action: %s", err)
+	}
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	result, changeID, err := client.doAsyncFull("POST", "/v2/snaps", nil, headers, bytes.NewBuffer(data), nil)
 	if err != nil {
 		return 0, "", err
 	}
@@ -268,6 +300,7 @@ func (client *Client) doMultiSnapActionFull(actionName string, snaps []string, c
 		action.ValidationSets = options.ValidationSets
 		action.Time = options.Time
 		action.HoldLevel = options.HoldLevel
+		action.SoakPeriod = options.SoakPeriod
 	}
 
 	data, err := json.Marshal(&action)
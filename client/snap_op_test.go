@@ -242,6 +242,32 @@ func (cs *clientSuite) TestClientMultiOpSnapTransactional(c *check.C) {
 	}
 }
 
+func (cs *clientSuite) TestClientRefreshManySoakPeriod(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	id, err := cs.cli.RefreshMany([]string{"foo", "bar"}, &client.SnapOptions{
+		Transaction: client.TransactionAllSnaps,
+		SoakPeriod:  "30m",
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "d728")
+
+	body, err := io.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	jsonBody := make(map[string]interface{})
+	c.Assert(json.Unmarshal(body, &jsonBody), check.IsNil)
+	c.Check(jsonBody, check.DeepEquals, map[string]interface{}{
+		"action":      "refresh",
+		"snaps":       []interface{}{"foo", "bar"},
+		"transaction": "all-snaps",
+		"soak-period": "30m",
+	})
+}
+
 func (cs *clientSuite) TestClientMultiOpSnapIgnoreRunning(c *check.C) {
 	cs.status = 202
 	cs.rsp = `{
@@ -299,6 +325,32 @@ func (cs *clientSuite) TestClientMultiSnapshot(c *check.C) {
 	c.Check(changeID, check.Equals, "d728")
 }
 
+func (cs *clientSuite) TestClientMultiSnapshotWithPassphrase(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"result": {"set-id": 42},
+		"change": "d728",
+		"status-code": 202,
+		"type": "async"
+	}`
+	setID, changeID, err := cs.cli.SnapshotManyWithPassphrase([]string{pkgName}, nil, "sekrit")
+	c.Assert(err, check.IsNil)
+
+	body, err := io.ReadAll(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	jsonBody := make(map[string]interface{})
+	err = json.Unmarshal(body, &jsonBody)
+	c.Assert(err, check.IsNil)
+	c.Check(jsonBody["action"], check.Equals, "snapshot")
+	c.Check(jsonBody["snaps"], check.DeepEquals, []interface{}{pkgName})
+	c.Check(jsonBody["snapshot-passphrase"], check.Equals, "sekrit")
+	c.Check(setID, check.Equals, uint64(42))
+	c.Check(changeID, check.Equals, "d728")
+
+	_, _, err = cs.cli.SnapshotManyWithPassphrase([]string{pkgName}, nil, "")
+	c.Check(err, check.ErrorMatches, "cannot encrypt snapshots with an empty passphrase")
+}
+
 func (cs *clientSuite) TestClientOpInstallPath(c *check.C) {
 	cs.status = 202
 	cs.rsp = `{
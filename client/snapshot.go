@@ -28,6 +28,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -50,6 +51,31 @@ type snapshotAction struct {
 	Action string   `json:"action"`
 	Snaps  []string `json:"snaps,omitempty"`
 	Users  []string `json:"users,omitempty"`
+	// Passphrase unlocks snapshots encrypted with one
+	Passphrase string `json:"passphrase,omitempty"`
+	// Paths and Target select the data to restore, and where to
+	Paths  []string `json:"paths,omitempty"`
+	Target string   `json:"target,omitempty"`
+}
+
+const (
+	// SnapshotEncryptionDevice is the encryption scheme of snapshots
+	// encrypted with a key bound to the device they were saved on. The
+	// key is kept unsealed in a root-only file on the device, so it only
+	// protects the snapshots copied off the device.
+	SnapshotEncryptionDevice = "device"
+	// SnapshotEncryptionPassphrase is the encryption scheme of snapshots
+	// encrypted with a key derived from a passphrase.
+	SnapshotEncryptionPassphrase = "passphrase"
+)
+
+// SnapshotEncryption describes how the archives of a snapshot are encrypted.
+type SnapshotEncryption struct {
+	Scheme string `json:"scheme"`
+	// the salt the key was derived from the passphrase with
+	Salt []byte `json:"salt,omitempty"`
+	// a check value to tell whether a key is the right one
+	KeyCheck string `json:"key-check"`
 }
 
 // A Snapshot is a collection of archives with a simple metadata json file
@@ -81,6 +107,9 @@ type Snapshot struct {
 	// dynamic snapshot options
 	Options *snap.SnapshotOptions `json:"options,omitempty"`
 
+	// set if the archives are encrypted
+	Encryption *SnapshotEncryption `json:"encryption,omitempty"`
+
 	// if the snapshot failed to open this will be the reason why
 	Broken string `json:"broken,omitempty"`
 
@@ -89,6 +118,10 @@ type Snapshot struct {
 	// newer snapd just updates this flag on the fly for snapshots
 	// returned by List().
 	Auto bool `json:"auto,omitempty"`
+
+	// set if the snapshot was saved on schedule; like Auto, this
+	// is only set on the fly for snapshots returned by List().
+	Scheduled bool `json:"scheduled,omitempty"`
 }
 
 // IsValid checks whether the snapshot is missing information that
@@ -104,6 +137,7 @@ func (sh *Snapshot) ContentHash() ([]byte, error) {
 	sh2.SetID = 0
 	sh2.Time = time.Time{}
 	sh2.Auto = false
+	sh2.Scheduled = false
 	sh2.Options = nil
 	h := sha256.New()
 	enc := json.NewEncoder(h)
@@ -113,6 +147,22 @@ func (sh *Snapshot) ContentHash() ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+// A SnapshotFile is a file or directory in the data of a snapshot.
+type SnapshotFile struct {
+	Snap string `json:"snap"`
+	// User is the user the data belongs to, or empty for the system
+	// data of the snap
+	User string `json:"user,omitempty"`
+	// Path is relative to the data directories of the snap, as in
+	// "common/foo.conf" or "x1/foo.conf"
+	Path string      `json:"path"`
+	Mode os.FileMode `json:"mode"`
+	Size int64       `json:"size"`
+	Time time.Time   `json:"time"`
+	// Link is the target of symlinks
+	Link string `json:"link,omitempty"`
+}
+
 // A SnapshotSet is a set of snapshots created by a single "snap save".
 type SnapshotSet struct {
 	ID        uint64      `json:"id"`
@@ -192,6 +242,15 @@ func (client *Client) ForgetSnapshots(setID uint64, snaps []string) (changeID st
 	})
 }
 
+// PushSnapshots exports the snapshot set to the snapshot sink configured
+// on the system.
+func (client *Client) PushSnapshots(setID uint64) (changeID string, err error) {
+	return client.snapshotAction(&snapshotAction{
+		SetID:  setID,
+		Action: "push",
+	})
+}
+
 // CheckSnapshots verifies the archive checksums in the given snapshot set.
 //
 // If snaps or users are non-empty, limit to checking only those
@@ -218,6 +277,46 @@ func (client *Client) RestoreSnapshots(setID uint64, snaps []string, users []str
 	})
 }
 
+// CheckSnapshotsWithPassphrase verifies the archive checksums in the given
+// snapshot set like CheckSnapshots, also decrypting the archives encrypted
+// with the passphrase.
+func (client *Client) CheckSnapshotsWithPassphrase(setID uint64, snaps []string, users []string, passphrase string) (changeID string, err error) {
+	return client.snapshotAction(&snapshotAction{
+		SetID:      setID,
+		Action:     "check",
+		Snaps:      snaps,
+		Users:      users,
+		Passphrase: passphrase,
+	})
+}
+
+// RestoreSnapshotsWithPassphrase extracts the given snapshot set like
+// RestoreSnapshots, decrypting the archives encrypted with the passphrase.
+func (client *Client) RestoreSnapshotsWithPassphrase(setID uint64, snaps []string, users []string, passphrase string) (changeID string, err error) {
+	return client.snapshotAction(&snapshotAction{
+		SetID:      setID,
+		Action:     "restore",
+		Snaps:      snaps,
+		Users:      users,
+		Passphrase: passphrase,
+	})
+}
+
+// RestoreSnapshotFiles extracts only the files and directories of the
+// given snapshot set that match the paths, shell patterns relative to the
+// data directories of the snaps such as "common/*.conf". If target is not
+// empty, the data is extracted under it instead of into place.
+func (client *Client) RestoreSnapshotFiles(setID uint64, snaps []string, users []string, paths []string, target string) (changeID string, err error) {
+	return client.snapshotAction(&snapshotAction{
+		SetID:  setID,
+		Action: "restore",
+		Snaps:  snaps,
+		Users:  users,
+		Paths:  paths,
+		Target: target,
+	})
+}
+
 func (client *Client) snapshotAction(action *snapshotAction) (changeID string, err error) {
 	data, err := json.Marshal(action)
 	if err != nil {
@@ -231,6 +330,23 @@ func (client *Client) snapshotAction(action *snapshotAction) (changeID string, e
 	return client.doAsync("POST", "/v2/snapshots", nil, headers, bytes.NewBuffer(data))
 }
 
+// SnapshotContents lists the files and directories in the given snapshot
+// set, limited to the given snaps and users (if non-empty), without
+// restoring them.
+func (client *Client) SnapshotContents(setID uint64, snaps []string, users []string) ([]SnapshotFile, error) {
+	q := make(url.Values)
+	if len(snaps) > 0 {
+		q.Add("snaps", strings.Join(snaps, ","))
+	}
+	if len(users) > 0 {
+		q.Add("users", strings.Join(users, ","))
+	}
+
+	var files []SnapshotFile
+	_, err := client.doSync("GET", fmt.Sprintf("/v2/snapshots/%v/contents", setID), q, nil, nil, &files)
+	return files, err
+}
+
 // SnapshotExport streams the requested snapshot set.
 //
 // The return value includes the length of the returned stream.
@@ -24,6 +24,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -129,6 +130,25 @@ func (cs *clientSuite) TestClientForgetSnapshot(c *check.C) {
 	})
 }
 
+func (cs *clientSuite) TestClientPushSnapshots(c *check.C) {
+	cs.status = 202
+	cs.rsp = `{
+		"status-code": 202,
+		"type": "async",
+		"change": "1too3"
+	}`
+	id, err := cs.cli.PushSnapshots(42)
+	c.Assert(err, check.IsNil)
+	c.Check(id, check.Equals, "1too3")
+
+	act, err := client.UnmarshalSnapshotAction(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	c.Check(act.SetID, check.Equals, uint64(42))
+	c.Check(act.Action, check.Equals, "push")
+	c.Check(act.Snaps, check.HasLen, 0)
+	c.Check(act.Users, check.HasLen, 0)
+}
+
 func (cs *clientSuite) testClientSnapshotAction(c *check.C, action string, f func(uint64, []string, []string) (string, error)) {
 	cs.testClientSnapshotActionFull(c, action, []string{"auser", "buser"}, func() (string, error) {
 		return f(42, []string{"asnap", "bsnap"}, []string{"auser", "buser"})
@@ -143,6 +163,60 @@ func (cs *clientSuite) TestClientRestoreSnapshots(c *check.C) {
 	cs.testClientSnapshotAction(c, "restore", cs.cli.RestoreSnapshots)
 }
 
+func (cs *clientSuite) TestClientCheckSnapshotsWithPassphrase(c *check.C) {
+	cs.testClientSnapshotAction(c, "check", func(setID uint64, snaps, users []string) (string, error) {
+		return cs.cli.CheckSnapshotsWithPassphrase(setID, snaps, users, "sekrit")
+	})
+}
+
+func (cs *clientSuite) TestClientRestoreSnapshotsWithPassphrase(c *check.C) {
+	cs.testClientSnapshotAction(c, "restore", func(setID uint64, snaps, users []string) (string, error) {
+		return cs.cli.RestoreSnapshotsWithPassphrase(setID, snaps, users, "sekrit")
+	})
+}
+
+func (cs *clientSuite) TestClientRestoreSnapshotFiles(c *check.C) {
+	cs.testClientSnapshotAction(c, "restore", func(setID uint64, snaps, users []string) (string, error) {
+		return cs.cli.RestoreSnapshotFiles(setID, snaps, users, []string{"common/*.conf"}, "/tmp/restored")
+	})
+
+	cs.cli.RestoreSnapshotFiles(42, nil, nil, []string{"common/*.conf", "x1"}, "")
+	act, err := client.UnmarshalSnapshotAction(cs.req.Body)
+	c.Assert(err, check.IsNil)
+	c.Check(act.Action, check.Equals, "restore")
+	c.Check(act.Paths, check.DeepEquals, []string{"common/*.conf", "x1"})
+	c.Check(act.Target, check.Equals, "")
+}
+
+func (cs *clientSuite) TestClientSnapshotContents(c *check.C) {
+	cs.rsp = `{
+		"type": "sync",
+		"result": [
+			{"snap": "foo", "path": "common", "mode": 2147484141, "size": 0, "time": "2024-01-02T03:04:05Z"},
+			{"snap": "foo", "user": "bar", "path": "x1/foo.conf", "mode": 420, "size": 42, "time": "2024-01-02T03:04:05Z"},
+			{"snap": "foo", "user": "bar", "path": "x1/link", "mode": 134218239, "size": 0, "time": "2024-01-02T03:04:05Z", "link": "foo.conf"}
+		]
+}`
+	files, err := cs.cli.SnapshotContents(42, []string{"foo", "baz"}, []string{"bar"})
+	c.Assert(err, check.IsNil)
+	tm := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.Check(files, check.DeepEquals, []client.SnapshotFile{
+		{Snap: "foo", Path: "common", Mode: os.ModeDir | 0755, Time: tm},
+		{Snap: "foo", User: "bar", Path: "x1/foo.conf", Mode: 0644, Size: 42, Time: tm},
+		{Snap: "foo", User: "bar", Path: "x1/link", Mode: os.ModeSymlink | 0777, Time: tm, Link: "foo.conf"},
+	})
+	c.Check(cs.req.Method, check.Equals, "GET")
+	c.Check(cs.req.URL.Path, check.Equals, "/v2/snapshots/42/contents")
+	c.Check(cs.req.URL.Query(), check.DeepEquals, url.Values{
+		"snaps": []string{"foo,baz"},
+		"users": []string{"bar"},
+	})
+
+	_, err = cs.cli.SnapshotContents(42, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(cs.req.URL.Query(), check.HasLen, 0)
+}
+
 func (cs *clientSuite) TestClientExportSnapshotSpecificErr(c *check.C) {
 	content := `{"type":"error","status-code":400,"result":{"message":"boom","kind":"err-kind","value":"err-value"}}`
 	cs.contentLength = int64(len(content))
@@ -173,6 +173,14 @@ func (c *cmdTasks) showChange(chid string) error {
 		if t.Status == "Doing" && t.Progress.Total > 1 {
 			summary = fmt.Sprintf("%s (%.2f%%)", summary, float64(t.Progress.Done)/float64(t.Progress.Total)*100.0)
 		}
+		if t.Retries > 0 {
+			retries := fmt.Sprintf(i18n.NG("%d failed attempt", "%d failed attempts", t.Retries), t.Retries)
+			if !t.NextRetryTime.IsZero() {
+				// TRANSLATORS: the first %s is the number of failed attempts, the second the time of the next attempt
+				retries = fmt.Sprintf(i18n.G("%s, next retry %s"), retries, c.fmtTime(t.NextRetryTime))
+			}
+			summary = fmt.Sprintf("%s (%s)", summary, retries)
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Status, spawnTime, readyTime, summary)
 	}
 
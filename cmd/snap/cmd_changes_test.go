@@ -232,6 +232,41 @@ Doing +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +some summary \(50.00%\)
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *SnapSuite) TestChangeRetries(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/changes/42")
+			fmt.Fprintln(w, `{"type": "sync", "result": {
+  "id":   "uno",
+  "kind": "foo",
+  "summary": "...",
+  "status": "Doing",
+  "ready": false,
+  "spawn-time": "2016-04-21T01:02:03Z",
+  "tasks": [
+    {"kind": "bar", "summary": "some summary", "status": "Doing", "progress": {"done": 0, "total": 1}, "spawn-time": "2016-04-21T01:02:03Z", "retries": 2, "next-retry-time": "2016-04-21T01:04:03Z"},
+    {"kind": "baz", "summary": "other summary", "status": "Done", "progress": {"done": 1, "total": 1}, "spawn-time": "2016-04-21T01:02:03Z", "ready-time": "2016-04-21T01:02:04Z", "retries": 1}
+  ]
+}}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"tasks", "--abs-time", "42"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Matches, `(?ms)Status +Spawn +Ready +Summary
+Doing +2016-04-21T01:02:03Z +- +some summary \(2 failed attempts, next retry 2016-04-21T01:04:03Z\)
+Done +2016-04-21T01:02:03Z +2016-04-21T01:02:04Z +other summary \(1 failed attempt\)
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *SnapSuite) TestNoChanges(c *check.C) {
 	n := 0
 	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
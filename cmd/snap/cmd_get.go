@@ -55,7 +55,17 @@ var longRegistryGetHelp = i18n.G(`
 If the first argument passed into get is a registry identifier matching the
 format <account-id>/<registry>/<view>, get will use the registry API. In this
 case, the command returns the data retrieved from the requested dot-separated
-view paths.
+view paths. Paths may refer to view requests with placeholders by providing
+their values in place of the placeholders:
+
+    $ snap get my-acc/network/wifi-setup ssid
+    my-network
+    $ snap get -d my-acc/network/wifi-setup snaps.firefox.status
+    {
+    	"snaps.firefox.status": "active"
+    }
+
+If no paths are provided, all the data accessible through the view is returned.
 `)
 
 type cmdGet struct {
@@ -267,7 +277,12 @@ func (x *cmdGet) Execute(args []string) error {
 			return err
 		}
 
-		conf, err = x.client.RegistryGetViaView(registryViewID, confKeys)
+		var chgID string
+		conf, chgID, err = x.client.RegistryGetViaView(registryViewID, confKeys)
+		if err == nil && chgID != "" {
+			// the values are loaded by the snaps storing the view's data
+			conf, err = x.waitForRegistryValues(chgID)
+		}
 	} else {
 		conf, err = x.client.Conf(snapName, confKeys)
 	}
@@ -286,6 +301,20 @@ func (x *cmdGet) Execute(args []string) error {
 	}
 }
 
+func (x *cmdGet) waitForRegistryValues(chgID string) (map[string]interface{}, error) {
+	wmx := waitMixin{clientMixin: x.clientMixin}
+	chg, err := wmx.wait(chgID)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]interface{}
+	if err := chg.Get("values", &values); err != nil {
+		return nil, fmt.Errorf("cannot get registry values from change %s: %v", chgID, err)
+	}
+	return values, nil
+}
+
 func validateRegistryFeatureFlag() error {
 	if !features.Registries.IsEnabled() {
 		_, confName := features.Registries.ConfigOption()
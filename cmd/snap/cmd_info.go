@@ -49,8 +49,9 @@ type infoCmd struct {
 	colorMixin
 	timeMixin
 
-	Verbose    bool `long:"verbose"`
-	Positional struct {
+	Verbose       bool `long:"verbose"`
+	HealthHistory bool `long:"health-history"`
+	Positional    struct {
 		Snaps []anySnapName `positional-arg-name:"<snap>" required:"1"`
 	} `positional-args:"yes" required:"yes"`
 }
@@ -74,6 +75,8 @@ func init() {
 		}, colorDescs.also(timeDescs).also(map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"verbose": i18n.G("Include more details on the snap (expanded notes, base, etc.)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"health-history": i18n.G("Include the last health reports of installed snaps"),
 		}), nil)
 }
 
@@ -163,6 +166,8 @@ type infoWriter struct {
 	fmtTime   func(time.Time) string
 	absTime   bool
 	verbose   bool
+
+	healthHistory bool
 }
 
 func (iw *infoWriter) setupDiskSnap(path string, diskSnap *client.Snap) {
@@ -250,6 +255,28 @@ func (iw *infoWriter) maybePrintHealth() {
 	iw.Flush()
 }
 
+func (iw *infoWriter) maybePrintHealthHistory() {
+	if !iw.healthHistory || iw.localSnap == nil || len(iw.localSnap.HealthHistory) == 0 {
+		return
+	}
+
+	fmt.Fprintln(iw, "health-history:")
+	for _, health := range iw.localSnap.HealthHistory {
+		fmt.Fprintf(iw, "  - checked:\t%s\n", iw.fmtTime(health.Timestamp))
+		fmt.Fprintf(iw, "    status:\t%s\n", health.Status)
+		if health.Message != "" {
+			strutil.WordWrap(iw, quotedIfNeeded(health.Message), "    message:\t", "      ", iw.termWidth)
+		}
+		if health.Code != "" {
+			fmt.Fprintf(iw, "    code:\t%s\n", health.Code)
+		}
+		if !health.Revision.Unset() {
+			fmt.Fprintf(iw, "    revision:\t%s\n", health.Revision)
+		}
+	}
+	iw.Flush()
+}
+
 func (iw *infoWriter) maybePrintTrackingChannel() {
 	if iw.localSnap == nil {
 		return
@@ -634,6 +661,8 @@ func (x *infoCmd) Execute([]string) error {
 		verbose:      x.Verbose,
 		fmtTime:      x.fmtTime,
 		absTime:      x.AbsTime,
+
+		healthHistory: x.HealthHistory,
 	}
 
 	noneOK := true
@@ -671,6 +700,7 @@ func (x *infoCmd) Execute([]string) error {
 		iw.printName()
 		iw.printSummary()
 		iw.maybePrintHealth()
+		iw.maybePrintHealthHistory()
 		iw.maybePrintPublisher()
 		iw.maybePrintStoreURL()
 		iw.maybePrintStandaloneVersion()
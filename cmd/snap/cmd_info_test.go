@@ -1163,6 +1163,54 @@ func (infoSuite) TestMaybePrintHealth(c *check.C) {
 	}
 }
 
+func (infoSuite) TestMaybePrintHealthHistory(c *check.C) {
+	t0 := time.Date(1970, 1, 1, 10, 24, 0, 0, time.UTC)
+	history := []client.SnapHealth{
+		{Status: "okay", Revision: snaplib.R("41"), Timestamp: t0},
+		{
+			Status:    "error",
+			Message:   "godot is not coming",
+			Code:      "godot-is-a-lie",
+			Revision:  snaplib.R("42"),
+			Timestamp: t0.Add(time.Hour),
+		},
+	}
+
+	type T struct {
+		snap          *client.Snap
+		healthHistory bool
+		expected      string
+	}
+	tests := []T{
+		{snap: nil, healthHistory: true, expected: ""},
+		{snap: &client.Snap{}, healthHistory: true, expected: ""},
+		{snap: &client.Snap{HealthHistory: history}, healthHistory: false, expected: ""},
+		{snap: &client.Snap{HealthHistory: history}, healthHistory: true, expected: `health-history:
+  - checked:	10:24AM
+    status:	okay
+    revision:	41
+  - checked:	11:24AM
+    status:	error
+    message:	godot
+      is not coming
+    code:	godot-is-a-lie
+    revision:	42
+`},
+	}
+
+	var buf flushBuffer
+	iw := snap.NewInfoWriter(&buf)
+	defer snap.MockIsStdoutTTY(false)()
+
+	for i, t := range tests {
+		buf.Reset()
+		snap.SetupSnap(iw, t.snap, nil, nil)
+		snap.SetHealthHistory(iw, t.healthHistory)
+		snap.MaybePrintHealthHistory(iw)
+		c.Check(buf.String(), check.Equals, t.expected, check.Commentf("%d", i))
+	}
+}
+
 func (infoSuite) TestBug1828425(c *check.C) {
 	const s = `This is a description
                                   that has
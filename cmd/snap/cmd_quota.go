@@ -85,6 +85,17 @@ with the remove-quota command and recreated with a lower limit. Increasing the
 memory limit for a quota group does not restart any services associated with 
 snaps in the quota group.
 
+The memory high limit is a soft limit below the memory limit: above it the
+snaps in the group are throttled and have memory reclaimed from them, instead
+of being killed. The memory swap limit caps the swap space the snaps in the
+group can use, with a limit of 0 disabling swap for them.
+Both can only be set on a group with a memory limit, be increased and decreased
+after being set, and cannot exceed those of the parent group. They require
+cgroup v2. The OOM policy decides what is killed when the memory limit is
+reached: with kill-group a service of the group is killed as a whole, while
+with kill-process only the process chosen by the kernel is. Sub-groups without
+an OOM policy use that of their parent group.
+
 The CPU limit for a quota group can be both increased and decreased after being
 set on a quota group. The CPU limit can be specified as a single percentage which
 means that the quota group is allowed an overall percentage of the CPU resources. Setting
@@ -103,6 +114,26 @@ The journal limits can be increased and decreased after being set on a group.
 Setting a journal limit will cause the snaps in the group to be put into the same
 journal namespace. This will affect the behaviour of the log command.
 
+The IO limits are set per block device, as <device>:<limit>, for instance
+--io-write-bandwidth=/dev/mmcblk0:10MB, and the bandwidths are per second. The
+options can be repeated to set limits for several devices. IO limits can be
+increased and decreased after being set on a group; the limits of devices that
+are not given are kept. IO limits require cgroup v2.
+
+The network egress bandwidth limit caps the rate at which the snaps in the group
+can send data over the network, per second, as for instance
+--network-egress-bandwidth=5MB. It can be increased and decreased after being
+set on a group. Changing it restarts the services of the snaps in the group.
+Network limits require cgroup v2 and nftables.
+
+By default only the services of the snaps in a quota group are subject to its
+limits. With --user-apps the apps of the snaps are too, when started with
+"snap run" or as user daemons; they are placed in a slice of the group in the
+instance of systemd of each user, so the limits apply per user. Network limits
+do not apply to them. It can be turned off again with --user-apps=false, which
+applies to apps started from then on. Groups of individual services cannot
+include user apps.
+
 New quotas can be set on existing quota groups, but existing quotas cannot be removed
 from a quota group, without removing and recreating the entire group.
 
@@ -116,13 +147,22 @@ func init() {
 	addCommand("set-quota", shortSetQuotaHelp, longSetQuotaHelp,
 		func() flags.Commander { return &cmdSetQuota{} },
 		waitDescs.also(map[string]string{
-			"memory":             i18n.G("Memory quota"),
-			"cpu":                i18n.G("CPU quota"),
-			"cpu-set":            i18n.G("CPU set quota"),
-			"threads":            i18n.G("Threads quota"),
-			"journal-size":       i18n.G("Journal size quota"),
-			"journal-rate-limit": i18n.G("Journal rate limit as <message count>/<message period>"),
-			"parent":             i18n.G("Parent quota group"),
+			"memory":                   i18n.G("Memory quota"),
+			"memory-high":              i18n.G("Memory high quota, above which the snaps are throttled"),
+			"memory-swap":              i18n.G("Memory swap quota"),
+			"oom-policy":               i18n.G("What is killed when the memory quota is reached (kill-group or kill-process)"),
+			"cpu":                      i18n.G("CPU quota"),
+			"cpu-set":                  i18n.G("CPU set quota"),
+			"threads":                  i18n.G("Threads quota"),
+			"journal-size":             i18n.G("Journal size quota"),
+			"journal-rate-limit":       i18n.G("Journal rate limit as <message count>/<message period>"),
+			"io-read-bandwidth":        i18n.G("IO read bandwidth quota per second as <device>:<size>"),
+			"io-write-bandwidth":       i18n.G("IO write bandwidth quota per second as <device>:<size>"),
+			"io-read-iops":             i18n.G("IO read operations per second quota as <device>:<count>"),
+			"io-write-iops":            i18n.G("IO write operations per second quota as <device>:<count>"),
+			"network-egress-bandwidth": i18n.G("Network egress bandwidth quota per second"),
+			"user-apps":                i18n.G("Whether the apps of the snaps, and not only their services, are subject to the quota (true or false)"),
+			"parent":                   i18n.G("Parent quota group"),
 		}), nil)
 	addCommand("quota", shortQuotaHelp, longQuotaHelp, func() flags.Commander { return &cmdQuota{} }, nil, nil)
 	addCommand("quotas", shortQuotasHelp, longQuotasHelp, func() flags.Commander { return &cmdQuotas{} }, nil, nil)
@@ -132,13 +172,22 @@ func init() {
 type cmdSetQuota struct {
 	waitMixin
 
-	MemoryMax        string `long:"memory" optional:"true"`
-	CPUMax           string `long:"cpu" optional:"true"`
-	CPUSet           string `long:"cpu-set" optional:"true"`
-	ThreadsMax       string `long:"threads" optional:"true"`
-	JournalSizeMax   string `long:"journal-size" optional:"true"`
-	JournalRateLimit string `long:"journal-rate-limit" optional:"true"`
-	Parent           string `long:"parent" optional:"true"`
+	MemoryMax        string   `long:"memory" optional:"true"`
+	MemoryHigh       string   `long:"memory-high" optional:"true"`
+	MemorySwap       string   `long:"memory-swap" optional:"true"`
+	OOMPolicy        string   `long:"oom-policy" optional:"true" choice:"kill-group" choice:"kill-process"`
+	CPUMax           string   `long:"cpu" optional:"true"`
+	CPUSet           string   `long:"cpu-set" optional:"true"`
+	ThreadsMax       string   `long:"threads" optional:"true"`
+	JournalSizeMax   string   `long:"journal-size" optional:"true"`
+	JournalRateLimit string   `long:"journal-rate-limit" optional:"true"`
+	IOReadBandwidth  []string `long:"io-read-bandwidth" optional:"true"`
+	IOWriteBandwidth []string `long:"io-write-bandwidth" optional:"true"`
+	IOReadIOPS       []string `long:"io-read-iops" optional:"true"`
+	IOWriteIOPS      []string `long:"io-write-iops" optional:"true"`
+	NetworkEgress    string   `long:"network-egress-bandwidth" optional:"true"`
+	UserApps         string   `long:"user-apps" optional:"true" optional-value:"true"`
+	Parent           string   `long:"parent" optional:"true"`
 	Positional       struct {
 		GroupName string        `positional-arg-name:"<group-name>" required:"true"`
 		Snaps     []serviceName `positional-arg-name:"<snap-or-service>" optional:"true"`
@@ -194,6 +243,68 @@ func parseJournalRateQuota(journalRateLimit string) (count int, period time.Dura
 	return count, period, nil
 }
 
+// parseIOQuota parses an IO quota string of the form <device>:<limit>; as
+// device paths can contain colons themselves, the limit is what follows the
+// last one.
+func parseIOQuota(ioLimit string) (device, limit string, err error) {
+	idx := strings.LastIndex(ioLimit, ":")
+	if idx <= 0 || idx == len(ioLimit)-1 {
+		return "", "", fmt.Errorf("io limit must be of the form <device>:<limit>")
+	}
+	return ioLimit[:idx], ioLimit[idx+1:], nil
+}
+
+func (x *cmdSetQuota) parseIOQuotas() ([]client.QuotaIOValues, error) {
+	var ioValues []client.QuotaIOValues
+	ioDevice := func(device string) *client.QuotaIOValues {
+		for i := range ioValues {
+			if ioValues[i].Device == device {
+				return &ioValues[i]
+			}
+		}
+		ioValues = append(ioValues, client.QuotaIOValues{Device: device})
+		return &ioValues[len(ioValues)-1]
+	}
+
+	for _, opt := range []struct {
+		name   string
+		values []string
+		set    func(dev *client.QuotaIOValues, limit string) error
+	}{
+		{"read bandwidth", x.IOReadBandwidth, func(dev *client.QuotaIOValues, limit string) error {
+			value, err := strutil.ParseByteSize(limit)
+			dev.ReadBandwidth = quantity.Size(value)
+			return err
+		}},
+		{"write bandwidth", x.IOWriteBandwidth, func(dev *client.QuotaIOValues, limit string) error {
+			value, err := strutil.ParseByteSize(limit)
+			dev.WriteBandwidth = quantity.Size(value)
+			return err
+		}},
+		{"read iops", x.IOReadIOPS, func(dev *client.QuotaIOValues, limit string) error {
+			value, err := strconv.ParseUint(limit, 10, 32)
+			dev.ReadIOPS = int(value)
+			return err
+		}},
+		{"write iops", x.IOWriteIOPS, func(dev *client.QuotaIOValues, limit string) error {
+			value, err := strconv.ParseUint(limit, 10, 32)
+			dev.WriteIOPS = int(value)
+			return err
+		}},
+	} {
+		for _, ioLimit := range opt.values {
+			device, limit, err := parseIOQuota(ioLimit)
+			if err == nil {
+				err = opt.set(ioDevice(device), limit)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse io %s %q: %v", opt.name, ioLimit, err)
+			}
+		}
+	}
+	return ioValues, nil
+}
+
 func (x *cmdSetQuota) parseQuotas() (*client.QuotaValues, error) {
 	var quotaValues client.QuotaValues
 
@@ -205,6 +316,24 @@ func (x *cmdSetQuota) parseQuotas() (*client.QuotaValues, error) {
 		quotaValues.Memory = quantity.Size(value)
 	}
 
+	if x.MemoryHigh != "" {
+		value, err := strutil.ParseByteSize(x.MemoryHigh)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse memory high limit %q: %v", x.MemoryHigh, err)
+		}
+		quotaValues.MemoryHigh = quantity.Size(value)
+	}
+
+	if x.MemorySwap != "" {
+		value, err := strutil.ParseByteSize(x.MemorySwap)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse memory swap limit %q: %v", x.MemorySwap, err)
+		}
+		swap := quantity.Size(value)
+		quotaValues.MemorySwap = &swap
+	}
+	quotaValues.OOMPolicy = x.OOMPolicy
+
 	if x.CPUMax != "" {
 		countValue, percentageValue, err := parseCpuQuota(x.CPUMax)
 		if err != nil {
@@ -266,12 +395,31 @@ func (x *cmdSetQuota) parseQuotas() (*client.QuotaValues, error) {
 		}
 	}
 
+	ioValues, err := x.parseIOQuotas()
+	if err != nil {
+		return nil, err
+	}
+	quotaValues.IO = ioValues
+
+	if x.NetworkEgress != "" {
+		value, err := strutil.ParseByteSize(x.NetworkEgress)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse network egress bandwidth %q: %v", x.NetworkEgress, err)
+		}
+		quotaValues.Network = &client.QuotaNetworkValues{
+			EgressBandwidth: quantity.Size(value),
+		}
+	}
+
 	return &quotaValues, nil
 }
 
 func (x *cmdSetQuota) hasQuotaSet() bool {
-	return x.MemoryMax != "" || x.CPUMax != "" || x.CPUSet != "" ||
-		x.ThreadsMax != "" || x.JournalSizeMax != "" || x.JournalRateLimit != ""
+	return x.MemoryMax != "" || x.MemoryHigh != "" || x.MemorySwap != "" || x.OOMPolicy != "" ||
+		x.CPUMax != "" || x.CPUSet != "" ||
+		x.ThreadsMax != "" || x.JournalSizeMax != "" || x.JournalRateLimit != "" ||
+		len(x.IOReadBandwidth) != 0 || len(x.IOWriteBandwidth) != 0 ||
+		len(x.IOReadIOPS) != 0 || len(x.IOWriteIOPS) != 0 || x.NetworkEgress != ""
 }
 
 func (x *cmdSetQuota) splitSnapsAndServices() (snaps []string, services []string) {
@@ -289,6 +437,14 @@ func (x *cmdSetQuota) splitSnapsAndServices() (snaps []string, services []string
 func (x *cmdSetQuota) Execute(args []string) (err error) {
 	quotaProvided := x.hasQuotaSet()
 	snaps, services := x.splitSnapsAndServices()
+	var userApps *bool
+	if x.UserApps != "" {
+		val, err := strconv.ParseBool(x.UserApps)
+		if err != nil {
+			return fmt.Errorf("cannot parse --user-apps value %q: expected true or false", x.UserApps)
+		}
+		userApps = &val
+	}
 
 	// figure out if the group exists or not to make error messages more useful
 	groupExists := false
@@ -299,7 +455,7 @@ func (x *cmdSetQuota) Execute(args []string) (err error) {
 	var chgID string
 
 	switch {
-	case !quotaProvided && x.Parent == "" && len(x.Positional.Snaps) == 0:
+	case !quotaProvided && x.Parent == "" && len(x.Positional.Snaps) == 0 && userApps == nil:
 		// no snaps or services were specified, no memory limit was specified, and no parent
 		// was specified, so just the group name was provided - this is not
 		// supported since there is nothing to change/create
@@ -342,6 +498,7 @@ func (x *cmdSetQuota) Execute(args []string) (err error) {
 			Snaps:       snaps,
 			Services:    services,
 			Constraints: quotaValues,
+			UserApps:    userApps,
 		})
 		if err != nil {
 			return err
@@ -359,6 +516,19 @@ func (x *cmdSetQuota) Execute(args []string) (err error) {
 			Parent:   x.Parent,
 			Snaps:    snaps,
 			Services: services,
+			UserApps: userApps,
+		})
+		if err != nil {
+			return err
+		}
+	case userApps != nil:
+		// only whether the group includes user apps is changed, so the
+		// group must already exist
+		if !groupExists {
+			return fmt.Errorf("cannot create quota group without any limit")
+		}
+		chgID, err = x.client.EnsureQuota(x.Positional.GroupName, &client.EnsureQuotaOptions{
+			UserApps: userApps,
 		})
 		if err != nil {
 			return err
@@ -403,6 +573,9 @@ func (x *cmdQuota) Execute(args []string) (err error) {
 	if group.Parent != "" {
 		fmt.Fprintf(w, "parent:\t%s\n", group.Parent)
 	}
+	if group.UserApps {
+		fmt.Fprintf(w, "user-apps:\ttrue\n")
+	}
 
 	// Constraints should always be non-nil, since a quota group always needs to
 	// have at least one limit set
@@ -416,6 +589,17 @@ func (x *cmdQuota) Execute(args []string) (err error) {
 		val := strings.TrimSpace(fmtSize(int64(group.Constraints.Memory)))
 		fmt.Fprintf(w, "  memory:\t%s\n", val)
 	}
+	if group.Constraints.MemoryHigh != 0 {
+		val := strings.TrimSpace(fmtSize(int64(group.Constraints.MemoryHigh)))
+		fmt.Fprintf(w, "  memory-high:\t%s\n", val)
+	}
+	if group.Constraints.MemorySwap != nil {
+		val := strings.TrimSpace(fmtSize(int64(*group.Constraints.MemorySwap)))
+		fmt.Fprintf(w, "  memory-swap:\t%s\n", val)
+	}
+	if group.Constraints.OOMPolicy != "" {
+		fmt.Fprintf(w, "  oom-policy:\t%s\n", group.Constraints.OOMPolicy)
+	}
 	if group.Constraints.CPU != nil {
 		fmt.Fprintf(w, "  cpu-count:\t%d\n", group.Constraints.CPU.Count)
 		fmt.Fprintf(w, "  cpu-percentage:\t%d\n", group.Constraints.CPU.Percentage)
@@ -439,11 +623,30 @@ func (x *cmdQuota) Execute(args []string) (err error) {
 		}
 	}
 
+	if len(group.Constraints.IO) > 0 {
+		fmt.Fprintf(w, "  io:\n")
+		for _, io := range group.Constraints.IO {
+			fmt.Fprintf(w, "    %s:\n", io.Device)
+			for _, limit := range fmtIOLimits(io) {
+				fmt.Fprintf(w, "      %s:\t%s\n", limit[0], limit[1])
+			}
+		}
+	}
+
+	if group.Constraints.Network != nil && group.Constraints.Network.EgressBandwidth != 0 {
+		val := strings.TrimSpace(fmtSize(int64(group.Constraints.Network.EgressBandwidth)))
+		fmt.Fprintf(w, "  network-egress-bandwidth:\t%s/s\n", val)
+	}
+
 	memoryUsage := "0B"
 	currentThreads := 0
+	networkEgress := "0B"
 	if group.Current != nil {
 		memoryUsage = strings.TrimSpace(fmtSize(int64(group.Current.Memory)))
 		currentThreads = group.Current.Threads
+		if group.Current.Network != nil {
+			networkEgress = strings.TrimSpace(fmtSize(int64(group.Current.Network.Egress)))
+		}
 	}
 
 	fmt.Fprintf(w, "current:\n")
@@ -453,6 +656,9 @@ func (x *cmdQuota) Execute(args []string) (err error) {
 	if group.Constraints.Threads != 0 {
 		fmt.Fprintf(w, "  threads:\t%d\n", currentThreads)
 	}
+	if group.Constraints.Network != nil && group.Constraints.Network.EgressBandwidth != 0 {
+		fmt.Fprintf(w, "  network-egress:\t%s\n", networkEgress)
+	}
 
 	if len(group.Subgroups) > 0 {
 		fmt.Fprint(w, "subgroups:\n")
@@ -528,6 +734,17 @@ func (x *cmdQuotas) Execute(args []string) (err error) {
 			grpConstraints = append(grpConstraints, "memory="+strings.TrimSpace(fmtSize(int64(q.Constraints.Memory))))
 		}
 
+		// format the other memory constraints as memory-high=N,memory-swap=N,oom-policy=P
+		if q.Constraints.MemoryHigh != 0 {
+			grpConstraints = append(grpConstraints, "memory-high="+strings.TrimSpace(fmtSize(int64(q.Constraints.MemoryHigh))))
+		}
+		if q.Constraints.MemorySwap != nil {
+			grpConstraints = append(grpConstraints, "memory-swap="+strings.TrimSpace(fmtSize(int64(*q.Constraints.MemorySwap))))
+		}
+		if q.Constraints.OOMPolicy != "" {
+			grpConstraints = append(grpConstraints, "oom-policy="+q.Constraints.OOMPolicy)
+		}
+
 		// format cpu constraint as cpu=NxM%,cpu-set=x,y,z
 		if q.Constraints.CPU != nil {
 			if q.Constraints.CPU.Count != 0 {
@@ -560,7 +777,19 @@ func (x *cmdQuotas) Execute(args []string) (err error) {
 			}
 		}
 
-		// format current resource values as memory=N,threads=N
+		// format io constraints as io-read-bandwidth=<device>:N,...
+		for _, io := range q.Constraints.IO {
+			for _, limit := range fmtIOLimits(io) {
+				grpConstraints = append(grpConstraints, fmt.Sprintf("io-%s=%s:%s", limit[0], io.Device, limit[1]))
+			}
+		}
+
+		// format network constraint as network-egress-bandwidth=N/s
+		if q.Constraints.Network != nil && q.Constraints.Network.EgressBandwidth != 0 {
+			grpConstraints = append(grpConstraints, "network-egress-bandwidth="+strings.TrimSpace(fmtSize(int64(q.Constraints.Network.EgressBandwidth)))+"/s")
+		}
+
+		// format current resource values as memory=N,threads=N,network-egress=N
 		var grpCurrent []string
 		if q.Current != nil {
 			if q.Constraints.Memory != 0 && q.Current.Memory != 0 {
@@ -569,6 +798,9 @@ func (x *cmdQuotas) Execute(args []string) (err error) {
 			if q.Constraints.Threads != 0 && q.Current.Threads != 0 {
 				grpCurrent = append(grpCurrent, "threads="+fmt.Sprintf("%d", q.Current.Threads))
 			}
+			if q.Current.Network != nil && q.Current.Network.Egress != 0 {
+				grpCurrent = append(grpCurrent, "network-egress="+strings.TrimSpace(fmtSize(int64(q.Current.Network.Egress))))
+			}
 		}
 
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", q.GroupName, q.Parent, strings.Join(grpConstraints, ","), strings.Join(grpCurrent, ","))
@@ -582,6 +814,25 @@ func (x *cmdQuotas) Execute(args []string) (err error) {
 	return nil
 }
 
+// fmtIOLimits returns the name and formatted value of each of the IO limits
+// of the device that is set.
+func fmtIOLimits(io client.QuotaIOValues) [][2]string {
+	var limits [][2]string
+	if io.ReadBandwidth != 0 {
+		limits = append(limits, [2]string{"read-bandwidth", strings.TrimSpace(fmtSize(int64(io.ReadBandwidth))) + "/s"})
+	}
+	if io.WriteBandwidth != 0 {
+		limits = append(limits, [2]string{"write-bandwidth", strings.TrimSpace(fmtSize(int64(io.WriteBandwidth))) + "/s"})
+	}
+	if io.ReadIOPS != 0 {
+		limits = append(limits, [2]string{"read-iops", strconv.Itoa(io.ReadIOPS)})
+	}
+	if io.WriteIOPS != 0 {
+		limits = append(limits, [2]string{"write-iops", strconv.Itoa(io.WriteIOPS)})
+	}
+	return limits
+}
+
 type quotaGroup struct {
 	res       *client.QuotaGroupResult
 	subGroups []*quotaGroup
@@ -109,6 +109,10 @@ type fakeQuotaGroupPostHandlerOpts struct {
 	cpuCount      int
 	cpuPercentage int
 	cpuSet        []int
+	userApps      *bool
+	memoryHigh    int64
+	memorySwap    *int64
+	oomPolicy     string
 }
 
 type quotasEnsureBodyConstraintsCPU struct {
@@ -121,10 +125,13 @@ type quotasEnsureBodyConstraintsCPUSet struct {
 }
 
 type quotasEnsureBodyConstraints struct {
-	Memory  int64                             `json:"memory,omitempty"`
-	Threads int                               `json:"threads,omitempty"`
-	CPU     quotasEnsureBodyConstraintsCPU    `json:"cpu,omitempty"`
-	CPUSet  quotasEnsureBodyConstraintsCPUSet `json:"cpu-set,omitempty"`
+	Memory     int64                             `json:"memory,omitempty"`
+	MemoryHigh int64                             `json:"memory-high,omitempty"`
+	MemorySwap *int64                            `json:"memory-swap,omitempty"`
+	OOMPolicy  string                            `json:"oom-policy,omitempty"`
+	Threads    int                               `json:"threads,omitempty"`
+	CPU        quotasEnsureBodyConstraintsCPU    `json:"cpu,omitempty"`
+	CPUSet     quotasEnsureBodyConstraintsCPUSet `json:"cpu-set,omitempty"`
 }
 
 type quotasEnsureBody struct {
@@ -134,6 +141,7 @@ type quotasEnsureBody struct {
 	Snaps       []string                    `json:"snaps,omitempty"`
 	Services    []string                    `json:"services,omitempty"`
 	Constraints quotasEnsureBodyConstraints `json:"constraints,omitempty"`
+	UserApps    *bool                       `json:"user-apps,omitempty"`
 }
 
 func (s *quotaSuite) makeFakeQuotaPostHandler(c *check.C, opts fakeQuotaGroupPostHandlerOpts) func(w http.ResponseWriter, r *http.Request) {
@@ -156,10 +164,14 @@ func (s *quotaSuite) makeFakeQuotaPostHandler(c *check.C, opts fakeQuotaGroupPos
 				Snaps:       opts.snaps,
 				Services:    opts.services,
 				Constraints: quotasEnsureBodyConstraints{},
+				UserApps:    opts.userApps,
 			}
 			if opts.maxMemory != 0 {
 				exp.Constraints.Memory = opts.maxMemory
 			}
+			exp.Constraints.MemoryHigh = opts.memoryHigh
+			exp.Constraints.MemorySwap = opts.memorySwap
+			exp.Constraints.OOMPolicy = opts.oomPolicy
 			if opts.maxThreads != 0 {
 				exp.Constraints.Threads = opts.maxThreads
 			}
@@ -259,6 +271,57 @@ func (s *quotaSuite) TestParseQuotas(c *check.C) {
 	}
 }
 
+func (s *quotaSuite) TestParseIOQuotas(c *check.C) {
+	for _, testData := range []struct {
+		readBandwidth  []string
+		writeBandwidth []string
+		readIOPS       []string
+		writeIOPS      []string
+
+		quotas string
+		err    string
+	}{
+		{readBandwidth: []string{"/dev/sda:10MB"}, quotas: `{"io":[{"device":"/dev/sda","read-bandwidth":10000000}]}`},
+		{
+			writeBandwidth: []string{"/dev/sda:1MB", "/dev/mmcblk0:2MB"},
+			writeIOPS:      []string{"/dev/sda:100"},
+			readIOPS:       []string{"/dev/disk/by-path/pci-0000:00:1f.2-ata-1:50"},
+			quotas:         `{"io":[{"device":"/dev/sda","write-bandwidth":1000000,"write-iops":100},{"device":"/dev/mmcblk0","write-bandwidth":2000000},{"device":"/dev/disk/by-path/pci-0000:00:1f.2-ata-1","read-iops":50}]}`,
+		},
+
+		// Error cases
+		{readBandwidth: []string{"/dev/sda"}, err: `cannot parse io read bandwidth "/dev/sda": io limit must be of the form <device>:<limit>`},
+		{readBandwidth: []string{":10MB"}, err: `cannot parse io read bandwidth ":10MB": io limit must be of the form <device>:<limit>`},
+		{writeBandwidth: []string{"/dev/sda:"}, err: `cannot parse io write bandwidth "/dev/sda:": io limit must be of the form <device>:<limit>`},
+		{writeBandwidth: []string{"/dev/sda:10"}, err: `cannot parse io write bandwidth "/dev/sda:10": cannot parse "10": need a number with a unit as input`},
+		{readIOPS: []string{"/dev/sda:many"}, err: `cannot parse io read iops "/dev/sda:many": strconv.ParseUint: parsing "many": invalid syntax`},
+		{writeIOPS: []string{"/dev/sda:-1"}, err: `cannot parse io write iops "/dev/sda:-1": strconv.ParseUint: parsing "-1": invalid syntax`},
+	} {
+		quotas, err := main.ParseIOQuotaValues(testData.readBandwidth, testData.writeBandwidth, testData.readIOPS, testData.writeIOPS)
+		testLabel := check.Commentf("%v", testData)
+		if testData.err == "" {
+			c.Check(err, check.IsNil, testLabel)
+			var jsonQuota bytes.Buffer
+			err := json.NewEncoder(&jsonQuota).Encode(quotas)
+			c.Assert(err, check.IsNil, testLabel)
+			c.Check(strings.TrimSpace(jsonQuota.String()), check.Equals, testData.quotas, testLabel)
+		} else {
+			c.Check(err, check.ErrorMatches, testData.err, testLabel)
+		}
+	}
+}
+
+func (s *quotaSuite) TestParseNetworkQuotas(c *check.C) {
+	quotas, err := main.ParseNetworkQuotaValues("5MB")
+	c.Assert(err, check.IsNil)
+	jsonQuota, err := json.Marshal(quotas)
+	c.Assert(err, check.IsNil)
+	c.Check(string(jsonQuota), check.Equals, `{"network":{"egress-bandwidth":5000000}}`)
+
+	_, err = main.ParseNetworkQuotaValues("5MB/s")
+	c.Check(err, check.ErrorMatches, `cannot parse network egress bandwidth "5MB/s": .*`)
+}
+
 func (s *quotaSuite) TestSetQuotaInvalidArgs(c *check.C) {
 	const json = `{
 		"type": "sync",
@@ -517,6 +580,252 @@ current:
 	c.Check(s.quotaGetGroupHandlerCalls, check.Equals, 1)
 }
 
+func (s *quotaSuite) TestIOQuotaGroupSimple(c *check.C) {
+	const jsonTemplate = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name": "foo",
+			"constraints": {"io":[{"device":"/dev/sda","read-bandwidth":10000000,"write-iops":100},{"device":"/dev/mmcblk0","write-bandwidth":1000000}]}
+		}
+	}`
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupHandler(c, jsonTemplate))
+
+	outputTemplate := `
+name:  foo
+constraints:
+  io:
+    /dev/sda:
+      read-bandwidth:  10.0MB/s
+      write-iops:      100
+    /dev/mmcblk0:
+      write-bandwidth:  1.00MB/s
+current:
+`[1:]
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quota", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, outputTemplate)
+	c.Check(s.quotaGetGroupHandlerCalls, check.Equals, 1)
+}
+
+func (s *quotaSuite) TestNetworkQuotaGroupSimple(c *check.C) {
+	const jsonTemplate = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name": "foo",
+			"constraints": {"network":{"egress-bandwidth":5000000}},
+			"current": {"network":{"egress":12000000}}
+		}
+	}`
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupHandler(c, jsonTemplate))
+
+	outputTemplate := `
+name:  foo
+constraints:
+  network-egress-bandwidth:  5.00MB/s
+current:
+  network-egress:  12.0MB
+`[1:]
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quota", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, outputTemplate)
+	c.Check(s.quotaGetGroupHandlerCalls, check.Equals, 1)
+}
+
+func (s *quotaSuite) TestMemoryHighSwapOOMPolicyQuotaGroupSimple(c *check.C) {
+	const jsonTemplate = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name": "foo",
+			"constraints": {"memory": 2000000, "memory-high": 1000000, "memory-swap": 500000, "oom-policy": "kill-group"},
+			"current": {"memory": 500}
+		}
+	}`
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupHandler(c, jsonTemplate))
+
+	outputTemplate := `
+name:  foo
+constraints:
+  memory:       2.00MB
+  memory-high:  1.00MB
+  memory-swap:  500kB
+  oom-policy:   kill-group
+current:
+  memory:  500B
+`[1:]
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quota", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, outputTemplate)
+	c.Check(s.quotaGetGroupHandlerCalls, check.Equals, 1)
+}
+
+func (s *quotaSuite) TestSetQuotaGroupMemoryHighSwapOOMPolicy(c *check.C) {
+	memorySwap := int64(500000)
+	const postJSON = `{"type": "async", "status-code": 202,"change":"42", "result": []}`
+	const getJSON = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name":"foo",
+			"constraints": { "memory": 2000000 },
+			"current": { "memory": 500 }
+		}
+	}`
+	routes := map[string]http.HandlerFunc{
+		"/v2/quotas": s.makeFakeQuotaPostHandler(c, fakeQuotaGroupPostHandlerOpts{
+			action:     "ensure",
+			body:       postJSON,
+			groupName:  "foo",
+			memoryHigh: 1000000,
+			memorySwap: &memorySwap,
+			oomPolicy:  "kill-process",
+		}),
+		"/v2/quotas/foo": s.makeFakeGetQuotaGroupHandler(c, getJSON),
+		"/v2/changes/42": makeChangesHandler(c),
+	}
+	s.RedirectClientToTestServer(dispatchFakeHandlers(c, routes))
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--memory-high=1MB", "--memory-swap=500kB", "--oom-policy=kill-process"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.quotaPostHandlerCalls, check.Equals, 1)
+}
+
+func (s *quotaSuite) TestSetQuotaGroupMemorySwapZero(c *check.C) {
+	const postJSON = `{"type": "async", "status-code": 202,"change":"42", "result": []}`
+	const getJSON = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name":"foo",
+			"constraints": { "memory": 2000000 },
+			"current": { "memory": 500 }
+		}
+	}`
+	// a zero swap limit is sent to disable swap
+	memorySwap := int64(0)
+	routes := map[string]http.HandlerFunc{
+		"/v2/quotas": s.makeFakeQuotaPostHandler(c, fakeQuotaGroupPostHandlerOpts{
+			action:     "ensure",
+			body:       postJSON,
+			groupName:  "foo",
+			memorySwap: &memorySwap,
+		}),
+		"/v2/quotas/foo": s.makeFakeGetQuotaGroupHandler(c, getJSON),
+		"/v2/changes/42": makeChangesHandler(c),
+	}
+	s.RedirectClientToTestServer(dispatchFakeHandlers(c, routes))
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--memory-swap=0B"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.quotaPostHandlerCalls, check.Equals, 1)
+}
+
+func (s *quotaSuite) TestSetQuotaGroupMemoryHighSwapOOMPolicyUnhappy(c *check.C) {
+	_, err := main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--oom-policy=stop"})
+	c.Assert(err, check.ErrorMatches, `Invalid value .stop. for option .--oom-policy.. Allowed values are: kill-group or kill-process`)
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupNotFoundHandler(c, "foo"))
+	_, err = main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--memory-high=lots"})
+	c.Assert(err, check.ErrorMatches, `cannot parse memory high limit "lots": .*`)
+	_, err = main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--memory-swap=lots"})
+	c.Assert(err, check.ErrorMatches, `cannot parse memory swap limit "lots": .*`)
+}
+
+func (s *quotaSuite) TestQuotaGroupUserApps(c *check.C) {
+	const jsonTemplate = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name": "foo",
+			"user-apps": true,
+			"constraints": {"memory": 1000},
+			"current": {"memory": 500}
+		}
+	}`
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupHandler(c, jsonTemplate))
+
+	outputTemplate := `
+name:       foo
+user-apps:  true
+constraints:
+  memory:  1000B
+current:
+  memory:  500B
+`[1:]
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quota", "foo"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, outputTemplate)
+}
+
+func (s *quotaSuite) TestSetQuotaGroupUserApps(c *check.C) {
+	const postJSON = `{"type": "async", "status-code": 202,"change":"42", "result": []}`
+	const getJSON = `{
+		"type": "sync",
+		"status-code": 200,
+		"result": {
+			"group-name":"foo",
+			"constraints": { "memory": 1000 },
+			"current": { "memory": 500 }
+		}
+	}`
+
+	for _, t := range []struct {
+		arg      string
+		userApps bool
+	}{
+		{"--user-apps", true},
+		{"--user-apps=true", true},
+		{"--user-apps=false", false},
+	} {
+		s.quotaPostHandlerCalls = 0
+		userApps := t.userApps
+		routes := map[string]http.HandlerFunc{
+			"/v2/quotas": s.makeFakeQuotaPostHandler(c, fakeQuotaGroupPostHandlerOpts{
+				action:    "ensure",
+				body:      postJSON,
+				groupName: "foo",
+				userApps:  &userApps,
+			}),
+			"/v2/quotas/foo": s.makeFakeGetQuotaGroupHandler(c, getJSON),
+			"/v2/changes/42": makeChangesHandler(c),
+		}
+		s.RedirectClientToTestServer(dispatchFakeHandlers(c, routes))
+
+		rest, err := main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", t.arg})
+		c.Assert(err, check.IsNil, check.Commentf(t.arg))
+		c.Check(rest, check.HasLen, 0)
+		c.Check(s.quotaPostHandlerCalls, check.Equals, 1)
+	}
+}
+
+func (s *quotaSuite) TestSetQuotaGroupUserAppsUnhappy(c *check.C) {
+	const exists = false
+	s.testSetQuotaGroupUpdateExistingUnhappy(c, "cannot create quota group without any limit", exists, "--user-apps")
+
+	_, err := main.Parser(main.Client()).ParseArgs([]string{"set-quota", "foo", "--user-apps=maybe"})
+	c.Assert(err, check.ErrorMatches, `cannot parse --user-apps value "maybe": expected true or false`)
+}
+
 func (s *quotaSuite) TestSetQuotaGroupCreateNew(c *check.C) {
 	const postJSON = `{"type": "async", "status-code": 202,"change":"42", "result": []}`
 	fakeHandlerOpts := fakeQuotaGroupPostHandlerOpts{
@@ -747,6 +1056,67 @@ bbb      zzz     memory=1000B                              memory=400B
 	c.Check(s.quotaGetGroupsHandlerCalls, check.Equals, 1)
 }
 
+func (s *quotaSuite) TestGetAllIOQuotaGroups(c *check.C) {
+	restore := main.MockIsStdinTTY(true)
+	defer restore()
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupsHandler(c,
+		`{"type": "sync", "status-code": 200, "result": [
+			{"group-name":"io0","subgroups":["io1"],"constraints":{"io":[{"device":"/dev/sda","read-bandwidth":10000000,"write-iops":100}]}},
+			{"group-name":"io1","parent":"io0","constraints":{"memory":1000,"io":[{"device":"/dev/sda","write-iops":50},{"device":"/dev/sdb","read-iops":10}]}}
+			]}`))
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quotas"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `
+Quota  Parent  Constraints                                                      Current
+io0            io-read-bandwidth=/dev/sda:10.0MB/s,io-write-iops=/dev/sda:100   
+io1    io0     memory=1000B,io-write-iops=/dev/sda:50,io-read-iops=/dev/sdb:10  
+`[1:])
+}
+
+func (s *quotaSuite) TestGetAllNetworkQuotaGroups(c *check.C) {
+	restore := main.MockIsStdinTTY(true)
+	defer restore()
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupsHandler(c,
+		`{"type": "sync", "status-code": 200, "result": [
+			{"group-name":"net0","subgroups":["net1"],"constraints":{"network":{"egress-bandwidth":5000000}},"current":{"network":{"egress":2000}}},
+			{"group-name":"net1","parent":"net0","constraints":{"memory":1000,"network":{"egress-bandwidth":1000000}}}
+			]}`))
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quotas"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `
+Quota  Parent  Constraints                                     Current
+net0           network-egress-bandwidth=5.00MB/s               network-egress=2000B
+net1   net0    memory=1000B,network-egress-bandwidth=1.00MB/s  
+`[1:])
+}
+
+func (s *quotaSuite) TestGetAllMemoryHighSwapOOMPolicyQuotaGroups(c *check.C) {
+	restore := main.MockIsStdinTTY(true)
+	defer restore()
+
+	s.RedirectClientToTestServer(s.makeFakeGetQuotaGroupsHandler(c,
+		`{"type": "sync", "status-code": 200, "result": [
+			{"group-name":"mem0","constraints":{"memory":2000,"memory-high":1000,"memory-swap":500,"oom-policy":"kill-group"},"current":{"memory":100}}
+			]}`))
+
+	rest, err := main.Parser(main.Client()).ParseArgs([]string{"quotas"})
+	c.Assert(err, check.IsNil)
+	c.Check(rest, check.HasLen, 0)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(s.Stdout(), check.Equals, `
+Quota  Parent  Constraints                                                            Current
+mem0           memory=2000B,memory-high=1000B,memory-swap=500B,oom-policy=kill-group  memory=100B
+`[1:])
+}
+
 func (s *quotaSuite) TestGetAllQuotaGroupsInconsistencyError(c *check.C) {
 	restore := main.MockIsStdinTTY(true)
 	defer restore()
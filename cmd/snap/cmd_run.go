@@ -52,6 +52,7 @@ import (
 	"github.com/snapcore/snapd/sandbox/cgroup"
 	"github.com/snapcore/snapd/sandbox/selinux"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/quota"
 	"github.com/snapcore/snapd/snap/snapenv"
 	"github.com/snapcore/snapd/strutil/shlex"
 	"github.com/snapcore/snapd/timeutil"
@@ -1458,6 +1459,15 @@ func (x *cmdRun) runSnapConfine(info *snap.Info, runner runnable, beforeExec fun
 	}
 	if needsTracking {
 		opts := &cgroup.TrackingOptions{AllowSessionBus: allowSessionBus}
+		if !runner.IsHook() {
+			// The apps of snaps in a quota group including user
+			// apps are placed in the slice of the group.
+			slice, err := quota.UserAppsSlice(info.InstanceName())
+			if err != nil {
+				logger.Debugf("cannot get the quota group slice of snap %q: %v", info.InstanceName(), err)
+			}
+			opts.Slice = slice
+		}
 		if err = cgroupCreateTransientScopeForTracking(securityTag, opts); err != nil {
 			if err != cgroup.ErrCannotTrackProcess {
 				return err
@@ -50,6 +50,19 @@ var longRegistrySetHelp = i18n.G(`
 If the first argument passed into set is a registry identifier matching the
 format <account-id>/<registry>/<view>, set will use the registry API. In this
 case, the command sets the values as provided for the dot-separated view paths.
+Values are parsed as JSON documents, if possible, and paths may refer to view
+requests with placeholders by providing their values in place of the
+placeholders:
+
+    $ snap set my-acc/network/wifi-setup ssids='["home", "work"]'
+    $ snap set my-acc/network/wifi-setup snaps.firefox.status=active
+
+Values may be unset with an exclamation mark:
+
+    $ snap set my-acc/network/wifi-setup ssids!
+
+The changes are applied in a change which may involve snaps that use the
+registry, so the command waits for it unless --no-wait is used.
 `)
 
 type cmdSet struct {
@@ -261,6 +261,21 @@ func (s *registrySuite) TestRegistrySetMany(c *check.C) {
 	c.Check(s.Stderr(), check.Equals, "")
 }
 
+func (s *registrySuite) TestRegistrySetDottedPaths(c *check.C) {
+	restore := s.mockRegistryFlag(c)
+	defer restore()
+
+	// paths matching requests with placeholders are sent as they are
+	s.mockRegistryServer(c, `{"snaps.firefox.status":"active","ssids":["home","work"]}`, false)
+
+	rest, err := snapset.Parser(snapset.Client()).ParseArgs([]string{"set", "foo/bar/baz", "snaps.firefox.status=active", `ssids=["home", "work"]`})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.HasLen, 0)
+
+	c.Check(s.Stdout(), check.Equals, "")
+	c.Check(s.Stderr(), check.Equals, "")
+}
+
 func (s *registrySuite) TestRegistrySetInvalidAspectID(c *check.C) {
 	restore := s.mockRegistryFlag(c)
 	defer restore()
@@ -106,6 +106,12 @@ requests from 'snap refresh target-snap'.
 When snaps are specified --hold is effective on both their auto-refreshes
 and general refresh requests from 'snap refresh'. However, specific snap
 requests from 'snap refresh target-snap' remain unblocked and will proceed.
+
+With --soak-period the snaps are refreshed as a group, implying
+--transaction=all-snaps. Their health is then watched for the given duration,
+and if any of them reports an error, fails its check-health hook, or has a
+service that stops running, all of them are reverted to their previous
+revisions.
 `)
 
 var longTryHelp = i18n.G(`
@@ -922,6 +928,7 @@ type cmdRefresh struct {
 	Transaction      client.TransactionType `long:"transaction" default:"per-snap" choice:"all-snaps" choice:"per-snap"`
 	Hold             string                 `long:"hold" optional:"yes" optional-value:"forever"`
 	Unhold           bool                   `long:"unhold"`
+	SoakPeriod       string                 `long:"soak-period"`
 	Positional       struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -1031,9 +1038,50 @@ func (x *cmdRefresh) showRefreshTimes() error {
 	} else {
 		fmt.Fprintf(Stdout, "next: n/a\n")
 	}
+
+	if sysinfo.Refresh.Window != nil {
+		x.showRefreshWindow("", sysinfo.Refresh.Window)
+	}
+	if len(sysinfo.Refresh.SnapWindows) > 0 {
+		names := make([]string, 0, len(sysinfo.Refresh.SnapWindows))
+		for name := range sysinfo.Refresh.SnapWindows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(Stdout, "snap-windows:\n")
+		for _, name := range names {
+			fmt.Fprintf(Stdout, "  %s:\n", name)
+			x.showRefreshWindow("    ", sysinfo.Refresh.SnapWindows[name])
+		}
+	}
 	return nil
 }
 
+// showRefreshWindow shows the refresh window and whether it currently
+// allows auto-refreshes.
+func (x *cmdRefresh) showRefreshWindow(indent string, w *client.RefreshWindow) {
+	if w.MaintenanceWindow != "" {
+		fmt.Fprintf(Stdout, "%smaintenance-window: %s\n", indent, w.MaintenanceWindow)
+	}
+	if w.Blackout != "" {
+		fmt.Fprintf(Stdout, "%sblackout: %s\n", indent, w.Blackout)
+	}
+
+	var status string
+	switch w.Status {
+	case "open":
+		status = "open"
+	case "blackout":
+		status = "closed by blackout"
+	default:
+		status = "closed"
+	}
+	if next := parseSysinfoTime(w.NextOpen); w.Status != "open" && !next.IsZero() {
+		status += fmt.Sprintf(", opens %s", x.fmtTime(next))
+	}
+	fmt.Fprintf(Stdout, "%swindow: %s\n", indent, status)
+}
+
 func (x *cmdRefresh) listRefresh() error {
 	snaps, _, err := x.client.Find(&client.FindOptions{
 		Refresh: true,
@@ -1091,7 +1139,7 @@ func (x *cmdRefresh) Execute([]string) error {
 
 	otherFlags := x.Amend || x.Revision != "" || x.Cohort != "" ||
 		x.LeaveCohort || x.List || x.Time || x.IgnoreValidation || x.IgnoreRunning ||
-		x.Transaction != client.TransactionPerSnap
+		x.Transaction != client.TransactionPerSnap || x.SoakPeriod != ""
 
 	if x.Hold != "" && (x.Unhold || otherFlags) {
 		return errors.New(i18n.G("cannot use --hold with other flags"))
@@ -1104,6 +1152,17 @@ func (x *cmdRefresh) Execute([]string) error {
 	}
 
 	names := installedSnapNames(x.Positional.Snaps)
+	if x.SoakPeriod != "" {
+		if x.Amend || x.Revision != "" || x.Cohort != "" || x.LeaveCohort || x.IgnoreValidation || x.asksForMode() || x.asksForChannel() {
+			return errors.New(i18n.G("cannot use --soak-period with flags for a single snap"))
+		}
+		opts := &client.SnapOptions{
+			IgnoreRunning: x.IgnoreRunning,
+			Transaction:   client.TransactionAllSnaps,
+			SoakPeriod:    x.SoakPeriod,
+		}
+		return x.refreshMany(names, opts)
+	}
 	if len(names) == 1 {
 		opts := &client.SnapOptions{
 			Amend:            x.Amend,
@@ -1543,6 +1602,8 @@ func init() {
 			"hold": i18n.G("Hold refreshes for a specified duration (or forever, if no value is specified)"),
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"unhold": i18n.G("Remove refresh hold"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"soak-period": i18n.G("Refresh the snaps as a group and revert all of them if any fails its health checks within the given duration"),
 		}), nil)
 	addCommand("try", shortTryHelp, longTryHelp, func() flags.Commander { return &cmdTry{} }, waitDescs.also(modeDescs), nil)
 	addCommand("enable", shortEnableHelp, longEnableHelp, func() flags.Commander { return &cmdEnable{} }, waitDescs, nil)
@@ -1822,6 +1822,47 @@ next: 2017-04-26T00:58:00+02:00
 	c.Check(n, check.Equals, 1)
 }
 
+func (s *SnapSuite) TestRefreshTimeShowsWindows(c *check.C) {
+	n := 0
+	s.RedirectClientToTestServer(func(w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 0:
+			c.Check(r.Method, check.Equals, "GET")
+			c.Check(r.URL.Path, check.Equals, "/v2/system-info")
+			fmt.Fprintln(w, `{"type": "sync", "status-code": 200, "result": {"refresh": {
+"timer": "0:00-24:00/4", "last": "2017-04-25T17:35:00+02:00", "next": "2017-04-26T18:00:00+02:00",
+"window": {"maintenance-window": "mon-fri,18:00-23:00", "status": "closed", "next-open": "2017-04-26T18:00:00+02:00"},
+"snap-windows": {
+  "foo": {"maintenance-window": "mon-fri,18:00-23:00", "blackout": "04-20..04-30", "status": "blackout", "next-open": "2017-05-01T18:00:00+02:00"},
+  "critical": {"maintenance-window": "0:00-24:00", "status": "open"}
+}}}}`)
+		default:
+			c.Fatalf("expected to get 1 requests, now on %d", n+1)
+		}
+
+		n++
+	})
+	rest, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--time", "--abs-time"})
+	c.Assert(err, check.IsNil)
+	c.Assert(rest, check.DeepEquals, []string{})
+	c.Check(s.Stdout(), check.Equals, `timer: 0:00-24:00/4
+last: 2017-04-25T17:35:00+02:00
+next: 2017-04-26T18:00:00+02:00
+maintenance-window: mon-fri,18:00-23:00
+window: closed, opens 2017-04-26T18:00:00+02:00
+snap-windows:
+  critical:
+    maintenance-window: 0:00-24:00
+    window: open
+  foo:
+    maintenance-window: mon-fri,18:00-23:00
+    blackout: 04-20..04-30
+    window: closed by blackout, opens 2017-05-01T18:00:00+02:00
+`)
+	c.Check(s.Stderr(), check.Equals, "")
+	c.Check(n, check.Equals, 1)
+}
+
 func (s *SnapSuite) TestRefreshTimeShowsHolds(c *check.C) {
 	type testcase struct {
 		in  string
@@ -2518,6 +2559,39 @@ func (s *SnapOpSuite) TestRefreshManyIgnoreRunning(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *SnapOpSuite) TestRefreshSoakPeriod(c *check.C) {
+	s.RedirectClientToTestServer(s.srv.handle)
+	s.srv.checker = func(r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.URL.Path, check.Equals, "/v2/snaps")
+		c.Check(DecodedRequestBody(c, r), check.DeepEquals, map[string]interface{}{
+			"action":      "refresh",
+			"snaps":       []interface{}{"one"},
+			"transaction": "all-snaps",
+			"soak-period": "30m",
+		})
+	}
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--soak-period=30m", "one"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *SnapOpSuite) TestRefreshSoakPeriodSingleSnapFlags(c *check.C) {
+	s.RedirectClientToTestServer(nil)
+	for _, args := range [][]string{
+		{"--beta"},
+		{"--revision=1"},
+		{"--devmode"},
+		{"--ignore-validation"},
+	} {
+		args = append([]string{"refresh", "--soak-period=1h"}, append(args, "one", "two")...)
+		_, err := snap.Parser(snap.Client()).ParseArgs(args)
+		c.Check(err, check.ErrorMatches, `cannot use --soak-period with flags for a single snap`, check.Commentf("%v", args))
+	}
+
+	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--soak-period=1h", "--hold"})
+	c.Check(err, check.ErrorMatches, `cannot use --hold with other flags`)
+}
+
 func (s *SnapOpSuite) TestRefreshManyChannel(c *check.C) {
 	s.RedirectClientToTestServer(nil)
 	_, err := snap.Parser(snap.Client()).ParseArgs([]string{"refresh", "--beta", "one", "two"})
@@ -50,6 +50,9 @@ var (
 var longSavedHelp = i18n.G(`
 The saved command displays a list of snapshots that have been created
 previously with the 'save' command.
+
+With --files, the files and directories in the data of the snapshot given
+with --id are listed instead, without restoring them.
 `)
 var longSaveHelp = i18n.G(`
 The save command creates a snapshot of the current user, system and
@@ -100,6 +103,13 @@ for which users, or a combination of these.
 If a snap is included in a restore operation, excluding its system and
 configuration data from the restore is not currently possible. This
 restriction may be lifted in the future.
+
+With --path, only the files and directories matching the given shell
+patterns are restored, leaving the rest of the data and the configuration
+of the snaps alone. Patterns are relative to the data directories of the
+snaps, as in "common/*.conf" or "x1/db", and can be listed with
+'snap saved --id=<id> --files'. With --target, the data is restored under
+the given directory instead of into place.
 `)
 
 var longExportSnapshotHelp = i18n.G(`
@@ -115,6 +125,7 @@ type savedCmd struct {
 	clientMixin
 	durationMixin
 	ID         snapshotID `long:"id"`
+	Files      bool       `long:"files"`
 	Positional struct {
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
 	} `positional-args:"yes"`
@@ -130,6 +141,12 @@ func (x *savedCmd) Execute([]string) error {
 		}
 	}
 	snaps := installedSnapNames(x.Positional.Snaps)
+	if x.Files {
+		if setID == 0 {
+			return fmt.Errorf(i18n.G("cannot list the files of snapshots without --id"))
+		}
+		return x.showFiles(setID, snaps)
+	}
 	list, err := x.client.SnapshotSets(setID, snaps)
 	if err != nil {
 		return err
@@ -160,6 +177,9 @@ func (x *savedCmd) Execute([]string) error {
 			if sh.Auto {
 				notes = append(notes, "auto")
 			}
+			if sh.Scheduled {
+				notes = append(notes, "scheduled")
+			}
 			if sh.Broken != "" {
 				notes = append(notes, "broken: "+sh.Broken)
 			}
@@ -280,9 +300,48 @@ func (x *checkSnapshotCmd) Execute([]string) error {
 	return nil
 }
 
+func (x *savedCmd) showFiles(setID uint64, snaps []string) error {
+	files, err := x.client.SnapshotContents(setID, snaps, nil)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(Stdout, i18n.G("No files found."))
+		return nil
+	}
+
+	w := tabWriter()
+	defer w.Flush()
+
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+		"Snap",
+		i18n.G("User"),
+		i18n.G("Mode"),
+		i18n.G("Size"),
+		i18n.G("Path"))
+	for _, f := range files {
+		user := f.User
+		if user == "" {
+			user = "-"
+		}
+		size := "-"
+		if f.Mode.IsRegular() {
+			size = fmtSize(f.Size)
+		}
+		path := f.Path
+		if f.Link != "" {
+			path += " -> " + f.Link
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", f.Snap, user, f.Mode, size, path)
+	}
+	return nil
+}
+
 type restoreCmd struct {
 	waitMixin
-	Users      string `long:"users"`
+	Users      string   `long:"users"`
+	Paths      []string `long:"path"`
+	Target     string   `long:"target"`
 	Positional struct {
 		ID    snapshotID          `positional-arg-name:"<id>"`
 		Snaps []installedSnapName `positional-arg-name:"<snap>"`
@@ -296,7 +355,15 @@ func (x *restoreCmd) Execute([]string) error {
 	}
 	snaps := installedSnapNames(x.Positional.Snaps)
 	users := strutil.CommaSeparatedList(x.Users)
-	changeID, err := x.client.RestoreSnapshots(setID, snaps, users)
+	if x.Target != "" && len(x.Paths) == 0 {
+		return fmt.Errorf(i18n.G("cannot use --target without --path"))
+	}
+	var changeID string
+	if len(x.Paths) > 0 {
+		changeID, err = x.client.RestoreSnapshotFiles(setID, snaps, users, x.Paths, x.Target)
+	} else {
+		changeID, err = x.client.RestoreSnapshots(setID, snaps, users)
+	}
 	if err != nil {
 		return err
 	}
@@ -308,6 +375,14 @@ func (x *restoreCmd) Execute([]string) error {
 		return err
 	}
 
+	if len(x.Paths) > 0 {
+		if x.Target != "" {
+			fmt.Fprintf(Stdout, i18n.G("Restored files of snapshot #%s into %q.\n"), x.Positional.ID, x.Target)
+		} else {
+			fmt.Fprintf(Stdout, i18n.G("Restored files of snapshot #%s.\n"), x.Positional.ID)
+		}
+		return nil
+	}
 	// TODO: also mention the home archives that were actually restored
 	if len(snaps) > 0 {
 		// TRANSLATORS: the %s is a comma-separated list of quoted snap names
@@ -329,6 +404,8 @@ func init() {
 		durationDescs.also(map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"id": i18n.G("Show only a specific snapshot."),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"files": i18n.G("List the files in the snapshot given with --id"),
 		}),
 		nil)
 
@@ -350,6 +427,10 @@ func init() {
 		}, waitDescs.also(map[string]string{
 			// TRANSLATORS: This should not start with a lowercase letter.
 			"users": i18n.G("Restore data of only specific users (comma-separated) (default: all users)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"path": i18n.G("Restore only the files matching the pattern, relative to the data directories of the snap (can be repeated)"),
+			// TRANSLATORS: This should not start with a lowercase letter.
+			"target": i18n.G("Restore the files under the given directory instead of into place"),
 		}), []argDesc{
 			{
 				name: "<id>",
@@ -44,6 +44,9 @@ var snapshotsTests = []getCmdArgs{{
 }, {
 	args:   "saved --id=3",
 	stdout: "Set  Snap  Age    Version  Rev   Size    Notes\n3    htop  .*  2        1168      1B  auto\n",
+}, {
+	args:   "saved --id=5",
+	stdout: "Set  Snap  Age    Version  Rev   Size    Notes\n5    htop  .*  2        1168      1B  scheduled\n",
 }, {
 	args:   "saved",
 	stdout: "Set  Snap  Age    Version  Rev   Size    Notes\n1    htop  .*  2        1168      1B  -\n",
@@ -56,6 +59,27 @@ var snapshotsTests = []getCmdArgs{{
 }, {
 	args:   "restore 1",
 	stdout: "Restored snapshot #1.\n",
+}, {
+	args:   "restore 1 --path=common/*.conf --path=x1",
+	stdout: "Restored files of snapshot #1.\n",
+}, {
+	args:   "restore 1 --path=common --target=/tmp/restored",
+	stdout: "Restored files of snapshot #1 into \"/tmp/restored\".\n",
+}, {
+	args:  "restore 1 --target=/tmp/restored",
+	error: "cannot use --target without --path",
+}, {
+	args:  "saved --files",
+	error: "cannot list the files of snapshots without --id",
+}, {
+	args: "saved --id=1 --files",
+	stdout: "Snap  User  Mode        Size  Path\n" +
+		"htop  -     drwxr-xr-x     -  common\n" +
+		"htop  -     -rw-r--r--   42B  common/foo.conf\n" +
+		"htop  bob   Lrwxrwxrwx     -  x1/link -> foo.conf\n",
+}, {
+	args:   "saved --id=2 --files",
+	stdout: "No files found.\n",
 }, {
 	args:   "forget 2",
 	stdout: "Snapshot #2 forgotten.\n",
@@ -124,6 +148,10 @@ func (s *SnapSuite) mockSnapshotsServer(c *C) {
 					fmt.Fprintf(w, `{"type":"sync","status-code":200,"status":"OK","result":[{"id":3,"snapshots":[{"set":3,"time":%q,"snap":"htop","revision":"1168","snap-id":"Z","auto":true,"epoch":{"read":[0],"write":[0]},"summary":"","version":"2","sha3-384":{"archive.tgz":""},"size":1}]}]}`, snapshotTime)
 					return
 				}
+				if r.URL.Query().Get("set") == "5" {
+					fmt.Fprintf(w, `{"type":"sync","status-code":200,"status":"OK","result":[{"id":5,"snapshots":[{"set":5,"time":%q,"snap":"htop","revision":"1168","snap-id":"Z","scheduled":true,"epoch":{"read":[0],"write":[0]},"summary":"","version":"2","sha3-384":{"archive.tgz":""},"size":1}]}]}`, snapshotTime)
+					return
+				}
 				fmt.Fprintf(w, `{"type":"sync","status-code":200,"status":"OK","result":[{"id":1,"snapshots":[{"set":1,"time":%q,"snap":"htop","revision":"1168","snap-id":"Z","epoch":{"read":[0],"write":[0]},"summary":"","version":"2","sha3-384":{"archive.tgz":""},"size":1}]}]}`, snapshotTime)
 			}
 			if r.Method == "POST" {
@@ -135,6 +163,15 @@ func (s *SnapSuite) mockSnapshotsServer(c *C) {
 					fmt.Fprintln(w, `{"type":"async", "status-code": 202, "change": "9"}`)
 				}
 			}
+		case "/v2/snapshots/1/contents":
+			c.Check(r.Method, Equals, "GET")
+			fmt.Fprintln(w, `{"type": "sync", "result": [
+				{"snap": "htop", "path": "common", "mode": 2147484141, "size": 0, "time": "2024-01-02T03:04:05Z"},
+				{"snap": "htop", "path": "common/foo.conf", "mode": 420, "size": 42, "time": "2024-01-02T03:04:05Z"},
+				{"snap": "htop", "user": "bob", "path": "x1/link", "mode": 134218239, "size": 0, "time": "2024-01-02T03:04:05Z", "link": "foo.conf"}
+			]}`)
+		case "/v2/snapshots/2/contents":
+			fmt.Fprintln(w, `{"type": "sync", "result": []}`)
 		case "/v2/changes/9":
 			fmt.Fprintln(w, `{"type": "sync", "result": {"ready": true, "status": "Done", "data": {}}}`)
 		case "/v2/snapshots/1/export":
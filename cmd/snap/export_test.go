@@ -132,6 +132,10 @@ func SetVerbose(iw *infoWriter, verbose bool) {
 	iw.verbose = verbose
 }
 
+func SetHealthHistory(iw *infoWriter, healthHistory bool) {
+	iw.healthHistory = healthHistory
+}
+
 var (
 	ClientSnapFromPath          = clientSnapFromPath
 	SetupDiskSnap               = (*infoWriter).setupDiskSnap
@@ -150,6 +154,7 @@ var (
 	MaybePrintSum               = (*infoWriter).maybePrintSum
 	MaybePrintCohortKey         = (*infoWriter).maybePrintCohortKey
 	MaybePrintHealth            = (*infoWriter).maybePrintHealth
+	MaybePrintHealthHistory     = (*infoWriter).maybePrintHealthHistory
 	MaybePrintRefreshInfo       = (*infoWriter).maybePrintRefreshInfo
 	WaitWhileInhibited          = waitWhileInhibited
 	NewInhibitionFlow           = newInhibitionFlow
@@ -466,6 +471,25 @@ func ParseQuotaValues(maxMemory, cpuMax, cpuSet, threadsMax, journalSizeMax, jou
 	return quotas.parseQuotas()
 }
 
+func ParseIOQuotaValues(readBandwidth, writeBandwidth, readIOPS, writeIOPS []string) (*client.QuotaValues, error) {
+	var quotas cmdSetQuota
+
+	quotas.IOReadBandwidth = readBandwidth
+	quotas.IOWriteBandwidth = writeBandwidth
+	quotas.IOReadIOPS = readIOPS
+	quotas.IOWriteIOPS = writeIOPS
+
+	return quotas.parseQuotas()
+}
+
+func ParseNetworkQuotaValues(egressBandwidth string) (*client.QuotaValues, error) {
+	var quotas cmdSetQuota
+
+	quotas.NetworkEgress = egressBandwidth
+
+	return quotas.parseQuotas()
+}
+
 func MockSeedWriterReadManifest(f func(manifestFile string) (*seedwriter.Manifest, error)) (restore func()) {
 	restore = testutil.Backup(&seedwriterReadManifest)
 	seedwriterReadManifest = f
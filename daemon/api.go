@@ -30,6 +30,7 @@ import (
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate"
+	"github.com/snapcore/snapd/overlord/healthstate"
 	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
@@ -68,6 +69,7 @@ var api = []*Command{
 	debugCmd,
 	snapshotCmd,
 	snapshotExportCmd,
+	snapshotContentsCmd,
 	connectionsCmd,
 	modelCmd,
 	cohortsCmd,
@@ -83,8 +85,13 @@ var api = []*Command{
 	quotaGroupsCmd,
 	quotaGroupInfoCmd,
 	registryCmd,
+	registryHistoryCmd,
 	noticesCmd,
 	noticeCmd,
+	requestsPromptsCmd,
+	requestsPromptCmd,
+	requestsRulesCmd,
+	requestsRuleCmd,
 }
 
 const (
@@ -162,11 +169,16 @@ var (
 
 	configstateConfigureInstalled = configstate.ConfigureInstalled
 
+	healthstateRefreshGroup = healthstate.RefreshGroup
+
 	assertstateRefreshSnapAssertions         = assertstate.RefreshSnapAssertions
 	assertstateRestoreValidationSetsTracking = assertstate.RestoreValidationSetsTracking
 
-	registrystateGetViaView = registrystate.GetViaView
-	registrystateSetViaView = registrystate.SetViaView
+	registrystateGetViaView  = registrystate.GetViaView
+	registrystateSetViaView  = registrystate.SetViaView
+	registrystateLoadViaView = registrystate.LoadViaView
+	registrystateHistory     = registrystate.History
+	registrystateRevert      = registrystate.Revert
 )
 
 func ensureStateSoonImpl(st *state.State) {
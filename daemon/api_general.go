@@ -116,6 +116,10 @@ func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 	if err != nil {
 		return InternalError("cannot get refresh schedule: %s", err)
 	}
+	refreshWindow, snapRefreshWindows, err := snapMgr.RefreshWindows()
+	if err != nil {
+		return InternalError("cannot get refresh windows: %s", err)
+	}
 	users, err := auth.Users(st)
 	if err != nil && !errors.Is(err, state.ErrNoState) {
 		return InternalError("cannot get user auth data: %s", err)
@@ -131,6 +135,15 @@ func sysInfo(c *Command, r *http.Request, user *auth.UserState) Response {
 	} else {
 		refreshInfo.Schedule = refreshScheduleStr
 	}
+	if refreshWindow != nil {
+		refreshInfo.Window = clientRefreshWindow(refreshWindow)
+	}
+	for name, w := range snapRefreshWindows {
+		if refreshInfo.SnapWindows == nil {
+			refreshInfo.SnapWindows = make(map[string]*client.RefreshWindow, len(snapRefreshWindows))
+		}
+		refreshInfo.SnapWindows[name] = clientRefreshWindow(w)
+	}
 
 	m := map[string]interface{}{
 		"series":         release.Series,
@@ -179,6 +192,22 @@ func formatRefreshTime(t time.Time) string {
 	return t.Truncate(time.Minute).Format(time.RFC3339)
 }
 
+func clientRefreshWindow(w *snapstate.RefreshWindowStatus) *client.RefreshWindow {
+	status := "closed"
+	switch {
+	case w.Open:
+		status = "open"
+	case w.InBlackout:
+		status = "blackout"
+	}
+	return &client.RefreshWindow{
+		MaintenanceWindow: w.MaintenanceWindow,
+		Blackout:          w.Blackout,
+		Status:            status,
+		NextOpen:          formatRefreshTime(w.NextOpen),
+	}
+}
+
 func sandboxFeatures(backends []interfaces.SecurityBackend) map[string][]string {
 	result := make(map[string][]string, len(backends)+1)
 	for _, backend := range backends {
@@ -338,6 +367,9 @@ type taskInfo struct {
 	SpawnTime time.Time  `json:"spawn-time,omitempty"`
 	ReadyTime *time.Time `json:"ready-time,omitempty"`
 
+	Retries       int        `json:"retries,omitempty"`
+	NextRetryTime *time.Time `json:"next-retry-time,omitempty"`
+
 	Data map[string]*json.RawMessage `json:"data,omitempty"`
 }
 
@@ -388,6 +420,20 @@ func change2changeInfo(chg *state.Change) *changeInfo {
 		if !readyTime.IsZero() {
 			taskInfo.ReadyTime = &readyTime
 		}
+		switch t.Status() {
+		case state.UndoStatus, state.UndoingStatus, state.UndoneStatus:
+			taskInfo.Retries = t.UndoingRetries()
+		default:
+			taskInfo.Retries = t.DoingRetries()
+		}
+		// only tasks that ran already are being retried, the schedule of
+		// those yet to run is when they run for the first time
+		switch t.Status() {
+		case state.DoingStatus, state.UndoingStatus:
+			if atTime := t.AtTime(); !atTime.IsZero() {
+				taskInfo.NextRetryTime = &atTime
+			}
+		}
 		if data, err := taskApiData(t); err == nil {
 			taskInfo.Data = data
 		}
@@ -30,9 +30,11 @@ import (
 	"time"
 
 	"gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/arch"
 	"github.com/snapcore/snapd/boot"
+	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/daemon"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/features"
@@ -397,6 +399,38 @@ func (s *generalSuite) TestSysInfoIsManaged(c *check.C) {
 	c.Check(rsp.Result.(map[string]interface{})["managed"], check.Equals, true)
 }
 
+func (s *generalSuite) TestSysInfoRefreshWindows(c *check.C) {
+	s.expectSystemInfoReadAccess()
+	d := s.daemon(c)
+
+	st := d.Overlord().State()
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "refresh.maintenance-window", "00:00-24:00")
+	// a blackout covering the whole year is never open
+	tr.Set("core", "refresh.snaps.critical.blackout", "01-01..12-31")
+	tr.Commit()
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/system-info", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Status, check.Equals, 200)
+	refreshInfo := rsp.Result.(map[string]interface{})["refresh"].(client.RefreshInfo)
+	c.Check(refreshInfo.Window, check.DeepEquals, &client.RefreshWindow{
+		MaintenanceWindow: "00:00-24:00",
+		Status:            "open",
+	})
+	c.Check(refreshInfo.SnapWindows, check.DeepEquals, map[string]*client.RefreshWindow{
+		"critical": {
+			MaintenanceWindow: "00:00-24:00",
+			Blackout:          "01-01..12-31",
+			Status:            "blackout",
+		},
+	})
+}
+
 func (s *generalSuite) TestSysInfoWorksDegraded(c *check.C) {
 	s.expectSystemInfoReadAccess()
 	d := s.daemon(c)
@@ -686,6 +720,55 @@ func (s *generalSuite) TestStateChange(c *check.C) {
 	})
 }
 
+func (s *generalSuite) TestStateChangeTaskRetries(c *check.C) {
+	restore := state.MockTime(time.Date(2016, 04, 21, 1, 2, 3, 0, time.UTC))
+	defer restore()
+
+	// Setup
+	s.expectChangesReadAccess()
+	d := s.daemonWithOverlordMock()
+	st := d.Overlord().State()
+	st.Lock()
+	ids := setupChanges(st)
+	st.Unlock()
+
+	// fail the download task once so that it's retried later
+	r := state.NewTaskRunner(st)
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		return fmt.Errorf("boom")
+	}, nil, state.WithRetryPolicy(state.RetryPolicy{Backoff: time.Minute}))
+	r.Ensure()
+	r.Wait()
+	r.Stop()
+
+	// the activate task is only scheduled, not retried
+	st.Lock()
+	st.Task(ids[3]).At(time.Date(2016, 04, 21, 2, 0, 0, 0, time.UTC))
+	st.Unlock()
+
+	// Execute
+	req, err := http.NewRequest("GET", "/v2/changes/"+ids[0], nil)
+	c.Assert(err, check.IsNil)
+	rsp := s.syncReq(c, req, nil)
+	rec := httptest.NewRecorder()
+	rsp.ServeHTTP(rec, req)
+
+	// Verify
+	c.Check(rec.Code, check.Equals, 200)
+	var body struct {
+		Result struct {
+			Tasks []map[string]interface{} `json:"tasks"`
+		} `json:"result"`
+	}
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &body), check.IsNil)
+	c.Assert(body.Result.Tasks, check.HasLen, 2)
+	c.Check(body.Result.Tasks[0]["status"], check.Equals, "Doing")
+	c.Check(body.Result.Tasks[0]["retries"], check.Equals, 1.)
+	c.Check(body.Result.Tasks[0]["next-retry-time"], check.Equals, "2016-04-21T01:03:03Z")
+	c.Check(body.Result.Tasks[1]["retries"], check.IsNil)
+	c.Check(body.Result.Tasks[1]["next-retry-time"], check.IsNil)
+}
+
 func (s *generalSuite) expectManageAccess() {
 	s.expectWriteAccess(daemon.AuthenticatedAccess{Polkit: "io.snapcraft.snapd.manage"})
 }
@@ -0,0 +1,373 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/requestprompts"
+	"github.com/snapcore/snapd/interfaces/prompting/requestrules"
+	"github.com/snapcore/snapd/overlord/auth"
+)
+
+var (
+	requestsPromptsCmd = &Command{
+		Path:       "/v2/interfaces/requests/prompts",
+		GET:        getPrompts,
+		ReadAccess: interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+	}
+
+	requestsPromptCmd = &Command{
+		Path:        "/v2/interfaces/requests/prompts/{id}",
+		GET:         getPrompt,
+		POST:        postPrompt,
+		ReadAccess:  interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+		WriteAccess: interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+	}
+
+	requestsRulesCmd = &Command{
+		Path:        "/v2/interfaces/requests/rules",
+		GET:         getRules,
+		POST:        postRules,
+		ReadAccess:  interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+		WriteAccess: interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+	}
+
+	requestsRuleCmd = &Command{
+		Path:        "/v2/interfaces/requests/rules/{id}",
+		GET:         getRule,
+		POST:        postRule,
+		ReadAccess:  interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+		WriteAccess: interfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}},
+	}
+)
+
+// interfacesRequestsManager is the subset of the interfaces requests manager
+// which is used by the API.
+type interfacesRequestsManager interface {
+	Prompts(userID uint32) ([]*requestprompts.Prompt, error)
+	PromptWithID(userID uint32, promptID prompting.IDType) (*requestprompts.Prompt, error)
+	HandleReply(userID uint32, promptID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) ([]prompting.IDType, error)
+	Rules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error)
+	AddRule(userID uint32, snap string, iface string, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error)
+	RemoveRules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error)
+	RuleWithID(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error)
+	PatchRule(userID uint32, ruleID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error)
+	RemoveRule(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error)
+}
+
+var getInterfacesRequestsManager = func(c *Command) interfacesRequestsManager {
+	mgr := c.d.overlord.InterfacesRequestsManager()
+	if mgr == nil {
+		// Avoid returning a non-nil interface holding a nil pointer
+		return nil
+	}
+	return mgr
+}
+
+func promptingNotRunningError() *apiError {
+	return InternalError("AppArmor prompting is not running")
+}
+
+// promptingError converts an error returned by the interfaces requests
+// manager into an API error.
+func promptingError(err error) *apiError {
+	switch {
+	case errors.Is(err, requestprompts.ErrNotFound),
+		errors.Is(err, requestrules.ErrNotFound),
+		errors.Is(err, requestrules.ErrUserNotAllowed):
+		return NotFound(err.Error())
+	case errors.Is(err, requestprompts.ErrClosed),
+		errors.Is(err, requestrules.ErrClosed):
+		return promptingNotRunningError()
+	default:
+		// Errors from the manager other than the above are caused by
+		// invalid or conflicting request contents.
+		return BadRequest(err.Error())
+	}
+}
+
+func parseIDVar(r *http.Request) (prompting.IDType, *apiError) {
+	idStr := muxVars(r)["id"]
+	id, err := prompting.IDFromString(idStr)
+	if err != nil {
+		return 0, BadRequest("invalid ID %q: %v", idStr, err)
+	}
+	return id, nil
+}
+
+func getPrompts(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	prompts, err := mgr.Prompts(userID)
+	if err != nil {
+		return promptingError(err)
+	}
+	if prompts == nil {
+		prompts = []*requestprompts.Prompt{}
+	}
+	return SyncResponse(prompts)
+}
+
+func getPrompt(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	id, apiErr := parseIDVar(r)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	prompt, err := mgr.PromptWithID(userID, id)
+	if err != nil {
+		return promptingError(err)
+	}
+	return SyncResponse(prompt)
+}
+
+type postPromptBody struct {
+	Outcome     prompting.OutcomeType  `json:"outcome"`
+	Lifespan    prompting.LifespanType `json:"lifespan"`
+	Duration    string                 `json:"duration,omitempty"`
+	Constraints *prompting.Constraints `json:"constraints"`
+}
+
+func postPrompt(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	id, apiErr := parseIDVar(r)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	var reply postPromptBody
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reply); err != nil {
+		return BadRequest("cannot decode request body into prompt reply: %v", err)
+	}
+	if reply.Constraints == nil {
+		return BadRequest("cannot reply to prompt without constraints")
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	satisfiedPromptIDs, err := mgr.HandleReply(userID, id, reply.Constraints, reply.Outcome, reply.Lifespan, reply.Duration)
+	if err != nil {
+		return promptingError(err)
+	}
+	if satisfiedPromptIDs == nil {
+		satisfiedPromptIDs = []prompting.IDType{}
+	}
+	return SyncResponse(satisfiedPromptIDs)
+}
+
+func getRules(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	query := r.URL.Query()
+	snap := query.Get("snap")
+	iface := query.Get("interface")
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	rules, err := mgr.Rules(userID, snap, iface)
+	if err != nil {
+		return promptingError(err)
+	}
+	if rules == nil {
+		rules = []*requestrules.Rule{}
+	}
+	return SyncResponse(rules)
+}
+
+type addRuleContents struct {
+	Snap        string                 `json:"snap"`
+	Interface   string                 `json:"interface"`
+	Constraints *prompting.Constraints `json:"constraints"`
+	Outcome     prompting.OutcomeType  `json:"outcome"`
+	Lifespan    prompting.LifespanType `json:"lifespan"`
+	Duration    string                 `json:"duration,omitempty"`
+}
+
+type removeRulesSelector struct {
+	Snap      string `json:"snap"`
+	Interface string `json:"interface"`
+}
+
+type postRulesRequestBody struct {
+	Action         string               `json:"action"`
+	AddRule        *addRuleContents     `json:"rule,omitempty"`
+	RemoveSelector *removeRulesSelector `json:"selector,omitempty"`
+}
+
+func postRules(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	var postBody postRulesRequestBody
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&postBody); err != nil {
+		return BadRequest("cannot decode request body into request rule operation: %v", err)
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	switch postBody.Action {
+	case "add":
+		if postBody.AddRule == nil {
+			return BadRequest(`must include "rule" field in request body when action is "add"`)
+		}
+		if postBody.AddRule.Constraints == nil {
+			return BadRequest("cannot add rule without constraints")
+		}
+		newRule, err := mgr.AddRule(userID, postBody.AddRule.Snap, postBody.AddRule.Interface, postBody.AddRule.Constraints, postBody.AddRule.Outcome, postBody.AddRule.Lifespan, postBody.AddRule.Duration)
+		if err != nil {
+			return promptingError(err)
+		}
+		return SyncResponse(newRule)
+	case "remove":
+		if postBody.RemoveSelector == nil {
+			return BadRequest(`must include "selector" field in request body when action is "remove"`)
+		}
+		removedRules, err := mgr.RemoveRules(userID, postBody.RemoveSelector.Snap, postBody.RemoveSelector.Interface)
+		if err != nil {
+			return promptingError(err)
+		}
+		if removedRules == nil {
+			removedRules = []*requestrules.Rule{}
+		}
+		return SyncResponse(removedRules)
+	default:
+		return BadRequest("invalid action %q: must be %q or %q", postBody.Action, "add", "remove")
+	}
+}
+
+func getRule(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	id, apiErr := parseIDVar(r)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	rule, err := mgr.RuleWithID(userID, id)
+	if err != nil {
+		return promptingError(err)
+	}
+	return SyncResponse(rule)
+}
+
+type patchRuleContents struct {
+	Constraints *prompting.Constraints `json:"constraints,omitempty"`
+	Outcome     prompting.OutcomeType  `json:"outcome,omitempty"`
+	Lifespan    prompting.LifespanType `json:"lifespan,omitempty"`
+	Duration    string                 `json:"duration,omitempty"`
+}
+
+type postRuleRequestBody struct {
+	Action    string             `json:"action"`
+	PatchRule *patchRuleContents `json:"rule,omitempty"`
+}
+
+func postRule(c *Command, r *http.Request, user *auth.UserState) Response {
+	userID, err := uidFromRequest(r)
+	if err != nil {
+		return Forbidden("cannot get remote user: %v", err)
+	}
+
+	id, apiErr := parseIDVar(r)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	var postBody postRuleRequestBody
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&postBody); err != nil {
+		return BadRequest("cannot decode request body into request rule modification: %v", err)
+	}
+
+	mgr := getInterfacesRequestsManager(c)
+	if mgr == nil {
+		return promptingNotRunningError()
+	}
+
+	switch postBody.Action {
+	case "patch":
+		if postBody.PatchRule == nil {
+			return BadRequest(`must include "rule" field in request body when action is "patch"`)
+		}
+		patchedRule, err := mgr.PatchRule(userID, id, postBody.PatchRule.Constraints, postBody.PatchRule.Outcome, postBody.PatchRule.Lifespan, postBody.PatchRule.Duration)
+		if err != nil {
+			return promptingError(err)
+		}
+		return SyncResponse(patchedRule)
+	case "remove":
+		removedRule, err := mgr.RemoveRule(userID, id)
+		if err != nil {
+			return promptingError(err)
+		}
+		return SyncResponse(removedRule)
+	default:
+		return BadRequest("invalid action %q: must be %q or %q", postBody.Action, "patch", "remove")
+	}
+}
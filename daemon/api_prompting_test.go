@@ -0,0 +1,384 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package daemon_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/patterns"
+	"github.com/snapcore/snapd/interfaces/prompting/requestprompts"
+	"github.com/snapcore/snapd/interfaces/prompting/requestrules"
+)
+
+var _ = Suite(&promptingSuite{})
+
+type fakeInterfacesRequestsManager struct {
+	// Values to return
+	prompts            []*requestprompts.Prompt
+	prompt             *requestprompts.Prompt
+	satisfiedPromptIDs []prompting.IDType
+	rules              []*requestrules.Rule
+	rule               *requestrules.Rule
+	err                error
+
+	// Store most recent received values
+	userID      uint32
+	snap        string
+	iface       string
+	id          prompting.IDType
+	constraints *prompting.Constraints
+	outcome     prompting.OutcomeType
+	lifespan    prompting.LifespanType
+	duration    string
+}
+
+func (m *fakeInterfacesRequestsManager) Prompts(userID uint32) ([]*requestprompts.Prompt, error) {
+	m.userID = userID
+	return m.prompts, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) PromptWithID(userID uint32, promptID prompting.IDType) (*requestprompts.Prompt, error) {
+	m.userID = userID
+	m.id = promptID
+	return m.prompt, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) HandleReply(userID uint32, promptID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) ([]prompting.IDType, error) {
+	m.userID = userID
+	m.id = promptID
+	m.constraints = constraints
+	m.outcome = outcome
+	m.lifespan = lifespan
+	m.duration = duration
+	return m.satisfiedPromptIDs, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) Rules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error) {
+	m.userID = userID
+	m.snap = snap
+	m.iface = iface
+	return m.rules, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) AddRule(userID uint32, snap string, iface string, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error) {
+	m.userID = userID
+	m.snap = snap
+	m.iface = iface
+	m.constraints = constraints
+	m.outcome = outcome
+	m.lifespan = lifespan
+	m.duration = duration
+	return m.rule, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) RemoveRules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error) {
+	m.userID = userID
+	m.snap = snap
+	m.iface = iface
+	return m.rules, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) RuleWithID(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error) {
+	m.userID = userID
+	m.id = ruleID
+	return m.rule, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) PatchRule(userID uint32, ruleID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error) {
+	m.userID = userID
+	m.id = ruleID
+	m.constraints = constraints
+	m.outcome = outcome
+	m.lifespan = lifespan
+	m.duration = duration
+	return m.rule, m.err
+}
+
+func (m *fakeInterfacesRequestsManager) RemoveRule(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error) {
+	m.userID = userID
+	m.id = ruleID
+	return m.rule, m.err
+}
+
+type promptingSuite struct {
+	apiBaseSuite
+
+	manager *fakeInterfacesRequestsManager
+}
+
+func (s *promptingSuite) SetUpTest(c *C) {
+	s.apiBaseSuite.SetUpTest(c)
+
+	s.manager = &fakeInterfacesRequestsManager{}
+	s.AddCleanup(daemon.MockInterfacesRequestsManager(s.manager))
+
+	s.expectReadAccess(daemon.InterfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}})
+	s.expectWriteAccess(daemon.InterfaceOpenAccess{Interfaces: []string{"snap-interfaces-requests-control"}})
+}
+
+func (s *promptingSuite) makeRequest(c *C, method string, path string, uid uint32, body []byte) *http.Request {
+	req, err := http.NewRequest(method, path, bytes.NewReader(body))
+	c.Assert(err, IsNil)
+	req.RemoteAddr = fmt.Sprintf("pid=100;uid=%d;socket=%s;", uid, dirs.SnapdSocket)
+	return req
+}
+
+func mustParsePathPattern(c *C, pattern string) *patterns.PathPattern {
+	pathPattern, err := patterns.ParsePathPattern(pattern)
+	c.Assert(err, IsNil)
+	return pathPattern
+}
+
+func (s *promptingSuite) TestPromptingNotRunning(c *C) {
+	restore := daemon.MockInterfacesRequestsManager(nil)
+	defer restore()
+	s.daemon(c)
+
+	for _, testCase := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/v2/interfaces/requests/prompts", ""},
+		{"GET", "/v2/interfaces/requests/prompts/0000000000000001", ""},
+		{"POST", "/v2/interfaces/requests/prompts/0000000000000001", `{"outcome":"allow","lifespan":"single","constraints":{"path-pattern":"/foo","permissions":["read"]}}`},
+		{"GET", "/v2/interfaces/requests/rules", ""},
+		{"POST", "/v2/interfaces/requests/rules", `{"action":"remove","selector":{"snap":"foo"}}`},
+		{"GET", "/v2/interfaces/requests/rules/0000000000000001", ""},
+		{"POST", "/v2/interfaces/requests/rules/0000000000000001", `{"action":"remove"}`},
+	} {
+		req := s.makeRequest(c, testCase.method, testCase.path, 1000, []byte(testCase.body))
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, 500, Commentf("%s %s", testCase.method, testCase.path))
+		c.Check(rspe.Message, Equals, "AppArmor prompting is not running")
+	}
+}
+
+func (s *promptingSuite) TestGetPrompts(c *C) {
+	s.daemon(c)
+
+	s.manager.prompts = []*requestprompts.Prompt{{ID: 0x1234, Snap: "firefox", Interface: "home"}}
+
+	req := s.makeRequest(c, "GET", "/v2/interfaces/requests/prompts", 1000, nil)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, DeepEquals, s.manager.prompts)
+	c.Check(s.manager.userID, Equals, uint32(1000))
+
+	// No prompts yields an empty list rather than null
+	s.manager.prompts = nil
+	rsp = s.syncReq(c, req, nil)
+	c.Check(rsp.Result, DeepEquals, []*requestprompts.Prompt{})
+}
+
+func (s *promptingSuite) TestGetPrompt(c *C) {
+	s.daemon(c)
+
+	s.manager.prompt = &requestprompts.Prompt{ID: 0x1234, Snap: "firefox", Interface: "home"}
+
+	req := s.makeRequest(c, "GET", "/v2/interfaces/requests/prompts/0000000000001234", 1000, nil)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, Equals, s.manager.prompt)
+	c.Check(s.manager.userID, Equals, uint32(1000))
+	c.Check(s.manager.id, Equals, prompting.IDType(0x1234))
+
+	req = s.makeRequest(c, "GET", "/v2/interfaces/requests/prompts/foo", 1000, nil)
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 400)
+	c.Check(rspe.Message, Matches, `invalid ID "foo": .*`)
+
+	s.manager.err = requestprompts.ErrNotFound
+	req = s.makeRequest(c, "GET", "/v2/interfaces/requests/prompts/0000000000001234", 1000, nil)
+	rspe = s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 404)
+	c.Check(rspe.Message, Equals, requestprompts.ErrNotFound.Error())
+}
+
+func (s *promptingSuite) TestPostPrompt(c *C) {
+	s.daemon(c)
+
+	s.manager.satisfiedPromptIDs = []prompting.IDType{0x5678}
+
+	body := []byte(`{"outcome":"allow","lifespan":"timespan","duration":"10m","constraints":{"path-pattern":"/home/test/**","permissions":["read","write"]}}`)
+	req := s.makeRequest(c, "POST", "/v2/interfaces/requests/prompts/0000000000001234", 1000, body)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, DeepEquals, s.manager.satisfiedPromptIDs)
+	c.Check(s.manager.userID, Equals, uint32(1000))
+	c.Check(s.manager.id, Equals, prompting.IDType(0x1234))
+	c.Check(s.manager.outcome, Equals, prompting.OutcomeAllow)
+	c.Check(s.manager.lifespan, Equals, prompting.LifespanTimespan)
+	c.Check(s.manager.duration, Equals, "10m")
+	c.Check(s.manager.constraints, DeepEquals, &prompting.Constraints{
+		PathPattern: mustParsePathPattern(c, "/home/test/**"),
+		Permissions: []string{"read", "write"},
+	})
+
+	// Satisfied prompt IDs are marshalled as strings
+	marshalled, err := json.Marshal(rsp.Result)
+	c.Assert(err, IsNil)
+	c.Check(string(marshalled), Equals, `["0000000000005678"]`)
+
+	for _, testCase := range []struct {
+		body   string
+		errStr string
+	}{
+		{`{"outcome":"foo"}`, `cannot decode request body into prompt reply: cannot have outcome other than "allow" or "deny": "foo"`},
+		{`{"outcome":"allow","lifespan":"forever"}`, `cannot reply to prompt without constraints`},
+	} {
+		req := s.makeRequest(c, "POST", "/v2/interfaces/requests/prompts/0000000000001234", 1000, []byte(testCase.body))
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, 400)
+		c.Check(rspe.Message, Equals, testCase.errStr)
+	}
+
+	s.manager.err = fmt.Errorf("boom")
+	req = s.makeRequest(c, "POST", "/v2/interfaces/requests/prompts/0000000000001234", 1000, body)
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 400)
+	c.Check(rspe.Message, Equals, "boom")
+}
+
+func (s *promptingSuite) TestGetRules(c *C) {
+	s.daemon(c)
+
+	s.manager.rules = []*requestrules.Rule{{ID: 0x1234, Snap: "firefox", Interface: "home"}}
+
+	query := url.Values{"snap": {"firefox"}, "interface": {"home"}}
+	req := s.makeRequest(c, "GET", "/v2/interfaces/requests/rules?"+query.Encode(), 1000, nil)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, DeepEquals, s.manager.rules)
+	c.Check(s.manager.userID, Equals, uint32(1000))
+	c.Check(s.manager.snap, Equals, "firefox")
+	c.Check(s.manager.iface, Equals, "home")
+}
+
+func (s *promptingSuite) TestPostRulesAdd(c *C) {
+	s.daemon(c)
+
+	s.manager.rule = &requestrules.Rule{ID: 0x1234, Snap: "firefox", Interface: "home"}
+
+	body := []byte(`{"action":"add","rule":{"snap":"firefox","interface":"home","constraints":{"path-pattern":"/home/test/**","permissions":["read"]},"outcome":"deny","lifespan":"forever"}}`)
+	req := s.makeRequest(c, "POST", "/v2/interfaces/requests/rules", 1000, body)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, Equals, s.manager.rule)
+	c.Check(s.manager.userID, Equals, uint32(1000))
+	c.Check(s.manager.snap, Equals, "firefox")
+	c.Check(s.manager.iface, Equals, "home")
+	c.Check(s.manager.outcome, Equals, prompting.OutcomeDeny)
+	c.Check(s.manager.lifespan, Equals, prompting.LifespanForever)
+	c.Check(s.manager.duration, Equals, "")
+
+	s.manager.err = requestrules.ErrPathPatternConflict
+	rspe := s.errorReq(c, s.makeRequest(c, "POST", "/v2/interfaces/requests/rules", 1000, body), nil)
+	c.Check(rspe.Status, Equals, 400)
+	c.Check(rspe.Message, Equals, requestrules.ErrPathPatternConflict.Error())
+}
+
+func (s *promptingSuite) TestPostRulesRemove(c *C) {
+	s.daemon(c)
+
+	s.manager.rules = []*requestrules.Rule{{ID: 0x1234, Snap: "firefox", Interface: "home"}}
+
+	body := []byte(`{"action":"remove","selector":{"snap":"firefox"}}`)
+	req := s.makeRequest(c, "POST", "/v2/interfaces/requests/rules", 1000, body)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, DeepEquals, s.manager.rules)
+	c.Check(s.manager.snap, Equals, "firefox")
+	c.Check(s.manager.iface, Equals, "")
+}
+
+func (s *promptingSuite) TestPostRulesErrors(c *C) {
+	s.daemon(c)
+
+	for _, testCase := range []struct {
+		body   string
+		errStr string
+	}{
+		{`{"action":"foo"}`, `invalid action "foo": must be "add" or "remove"`},
+		{`{"action":"add"}`, `must include "rule" field in request body when action is "add"`},
+		{`{"action":"add","rule":{"snap":"firefox"}}`, `cannot add rule without constraints`},
+		{`{"action":"remove"}`, `must include "selector" field in request body when action is "remove"`},
+		{`not json`, `cannot decode request body into request rule operation: .*`},
+	} {
+		req := s.makeRequest(c, "POST", "/v2/interfaces/requests/rules", 1000, []byte(testCase.body))
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, 400)
+		c.Check(rspe.Message, Matches, testCase.errStr)
+	}
+}
+
+func (s *promptingSuite) TestGetRule(c *C) {
+	s.daemon(c)
+
+	s.manager.rule = &requestrules.Rule{ID: 0x1234, Snap: "firefox", Interface: "home"}
+
+	req := s.makeRequest(c, "GET", "/v2/interfaces/requests/rules/0000000000001234", 1000, nil)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, Equals, s.manager.rule)
+	c.Check(s.manager.id, Equals, prompting.IDType(0x1234))
+
+	s.manager.err = requestrules.ErrUserNotAllowed
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 404)
+}
+
+func (s *promptingSuite) TestPostRule(c *C) {
+	s.daemon(c)
+
+	s.manager.rule = &requestrules.Rule{ID: 0x1234, Snap: "firefox", Interface: "home"}
+
+	body := []byte(`{"action":"patch","rule":{"outcome":"allow","lifespan":"timespan","duration":"1h"}}`)
+	req := s.makeRequest(c, "POST", "/v2/interfaces/requests/rules/0000000000001234", 1000, body)
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Result, Equals, s.manager.rule)
+	c.Check(s.manager.id, Equals, prompting.IDType(0x1234))
+	c.Check(s.manager.constraints, IsNil)
+	c.Check(s.manager.outcome, Equals, prompting.OutcomeAllow)
+	c.Check(s.manager.lifespan, Equals, prompting.LifespanTimespan)
+	c.Check(s.manager.duration, Equals, "1h")
+
+	body = []byte(`{"action":"remove"}`)
+	req = s.makeRequest(c, "POST", "/v2/interfaces/requests/rules/0000000000004321", 1000, body)
+	rsp = s.syncReq(c, req, nil)
+	c.Check(rsp.Result, Equals, s.manager.rule)
+	c.Check(s.manager.id, Equals, prompting.IDType(0x4321))
+
+	for _, testCase := range []struct {
+		body   string
+		errStr string
+	}{
+		{`{"action":"foo"}`, `invalid action "foo": must be "patch" or "remove"`},
+		{`{"action":"patch"}`, `must include "rule" field in request body when action is "patch"`},
+	} {
+		req := s.makeRequest(c, "POST", "/v2/interfaces/requests/rules/0000000000001234", 1000, []byte(testCase.body))
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, 400)
+		c.Check(rspe.Message, Equals, testCase.errStr)
+	}
+}
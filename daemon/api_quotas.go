@@ -55,12 +55,14 @@ type postQuotaGroupData struct {
 	Snaps       []string           `json:"snaps,omitempty"`
 	Services    []string           `json:"services,omitempty"`
 	Constraints client.QuotaValues `json:"constraints,omitempty"`
+	UserApps    *bool              `json:"user-apps,omitempty"`
 }
 
 var (
-	servicestateCreateQuota = servicestate.CreateQuota
-	servicestateUpdateQuota = servicestate.UpdateQuota
-	servicestateRemoveQuota = servicestate.RemoveQuota
+	servicestateCreateQuota       = servicestate.CreateQuota
+	servicestateUpdateQuota       = servicestate.UpdateQuota
+	servicestateRemoveQuota       = servicestate.RemoveQuota
+	servicestateQuotaUsageHistory = (*servicestate.ServiceManager).QuotaUsageHistory
 )
 
 var getQuotaUsage = func(grp *quota.Group) (*client.QuotaValues, error) {
@@ -82,12 +84,37 @@ var getQuotaUsage = func(grp *quota.Group) (*client.QuotaValues, error) {
 		currentUsage.Threads = threads
 	}
 
+	if grp.NetworkEgressLimit != 0 {
+		egress, err := grp.CurrentNetworkEgressUsage()
+		if err != nil {
+			return nil, err
+		}
+		currentUsage.Network = &client.QuotaNetworkValues{Egress: egress}
+	}
+
 	return &currentUsage, nil
 }
 
+func getQuotaUsageHistory(mgr *servicestate.ServiceManager, grp *quota.Group) map[string][]client.QuotaUsageStats {
+	stats := servicestateQuotaUsageHistory(mgr, grp.Name)
+	if len(stats) == 0 {
+		return nil
+	}
+	history := make(map[string][]client.QuotaUsageStats, len(stats))
+	for resource, windows := range stats {
+		for _, st := range windows {
+			history[resource] = append(history[resource], client.QuotaUsageStats(st))
+		}
+	}
+	return history
+}
+
 func createQuotaValues(grp *quota.Group) *client.QuotaValues {
 	var constraints client.QuotaValues
 	constraints.Memory = grp.MemoryLimit
+	constraints.MemoryHigh = grp.MemoryHighLimit
+	constraints.MemorySwap = grp.MemorySwapLimit
+	constraints.OOMPolicy = grp.OOMPolicy
 	constraints.Threads = grp.ThreadLimit
 
 	if grp.CPULimit != nil {
@@ -110,6 +137,14 @@ func createQuotaValues(grp *quota.Group) *client.QuotaValues {
 			}
 		}
 	}
+	for _, io := range grp.IOLimits {
+		constraints.IO = append(constraints.IO, client.QuotaIOValues(io))
+	}
+	if grp.NetworkEgressLimit != 0 {
+		constraints.Network = &client.QuotaNetworkValues{
+			EgressBandwidth: grp.NetworkEgressLimit,
+		}
+	}
 	return &constraints
 }
 
@@ -149,6 +184,7 @@ func getQuotaGroups(c *Command, r *http.Request, _ *auth.UserState) Response {
 			Services:    group.Services,
 			Constraints: createQuotaValues(group),
 			Current:     currentUsage,
+			UserApps:    group.UserApps,
 		}
 	}
 	return SyncResponse(results)
@@ -187,6 +223,8 @@ func getQuotaGroupInfo(c *Command, r *http.Request, _ *auth.UserState) Response
 		Subgroups:   group.SubGroups,
 		Constraints: createQuotaValues(group),
 		Current:     currentUsage,
+		UserApps:    group.UserApps,
+		History:     getQuotaUsageHistory(c.d.overlord.ServiceManager(), group),
 	}
 	return SyncResponse(res)
 }
@@ -196,6 +234,15 @@ func quotaValuesToResources(values client.QuotaValues) quota.Resources {
 	if values.Memory != 0 {
 		resourcesBuilder.WithMemoryLimit(values.Memory)
 	}
+	if values.MemoryHigh != 0 {
+		resourcesBuilder.WithMemoryHigh(values.MemoryHigh)
+	}
+	if values.MemorySwap != nil {
+		resourcesBuilder.WithMemorySwapMax(*values.MemorySwap)
+	}
+	if values.OOMPolicy != "" {
+		resourcesBuilder.WithOOMPolicy(values.OOMPolicy)
+	}
 	if values.CPU != nil {
 		if values.CPU.Count != 0 {
 			resourcesBuilder.WithCPUCount(values.CPU.Count)
@@ -219,6 +266,18 @@ func quotaValuesToResources(values client.QuotaValues) quota.Resources {
 			resourcesBuilder.WithJournalRate(values.Journal.RateCount, values.Journal.RatePeriod)
 		}
 	}
+	for _, io := range values.IO {
+		// a zero value means no limit, and a device without any limit
+		// is caught by the validation
+		resourcesBuilder.
+			WithIOReadBandwidth(io.Device, io.ReadBandwidth).
+			WithIOWriteBandwidth(io.Device, io.WriteBandwidth).
+			WithIOReadIOPS(io.Device, io.ReadIOPS).
+			WithIOWriteIOPS(io.Device, io.WriteIOPS)
+	}
+	if values.Network != nil {
+		resourcesBuilder.WithNetworkEgressBandwidth(values.Network.EgressBandwidth)
+	}
 	return resourcesBuilder.Build()
 }
 
@@ -260,6 +319,7 @@ func postQuotaGroup(c *Command, r *http.Request, _ *auth.UserState) Response {
 				Snaps:          data.Snaps,
 				Services:       data.Services,
 				ResourceLimits: resourceLimits,
+				UserApps:       data.UserApps != nil && *data.UserApps,
 			})
 			if err != nil {
 				return errToResponse(err, nil, BadRequest, "cannot create quota group: %v")
@@ -271,6 +331,7 @@ func postQuotaGroup(c *Command, r *http.Request, _ *auth.UserState) Response {
 				AddSnaps:          data.Snaps,
 				AddServices:       data.Services,
 				NewResourceLimits: resourceLimits,
+				UserApps:          data.UserApps,
 			}
 			ts, err = servicestateUpdateQuota(st, data.GroupName, updateOpts)
 			if err != nil {
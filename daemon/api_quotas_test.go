@@ -90,6 +90,9 @@ func (s *apiQuotaSuite) TestCreateQuotaValues(c *check.C) {
 			WithCPUSet([]int{0, 1}).
 			WithJournalRate(150, time.Second).
 			WithJournalSize(quantity.SizeMiB).
+			WithIOReadBandwidth("/dev/sda", 10*quantity.SizeMiB).
+			WithIOWriteIOPS("/dev/sda", 100).
+			WithNetworkEgressBandwidth(quantity.SizeMiB).
 			Build())
 	allGroups, err2 := servicestate.AllQuotas(st)
 	st.Unlock()
@@ -118,6 +121,12 @@ func (s *apiQuotaSuite) TestCreateQuotaValues(c *check.C) {
 			RatePeriod: time.Second,
 		},
 	})
+	c.Check(quotaValues.IO, check.DeepEquals, []client.QuotaIOValues{
+		{Device: "/dev/sda", ReadBandwidth: 10 * quantity.SizeMiB, WriteIOPS: 100},
+	})
+	c.Check(quotaValues.Network, check.DeepEquals, &client.QuotaNetworkValues{
+		EgressBandwidth: quantity.SizeMiB,
+	})
 }
 
 func (s *apiQuotaSuite) TestPostQuotaUnknownAction(c *check.C) {
@@ -328,6 +337,192 @@ func (s *apiQuotaSuite) TestPostEnsureQuotaCreateJournalRateZeroHappy(c *check.C
 	c.Assert(s.ensureSoonCalled, check.Equals, 1)
 }
 
+func (s *apiQuotaSuite) TestPostEnsureQuotaCreateIOHappy(c *check.C) {
+	var createCalled int
+	r := daemon.MockServicestateCreateQuota(func(st *state.State, name string, createOpts servicestate.CreateQuotaOptions) (*state.TaskSet, error) {
+		createCalled++
+		c.Check(name, check.Equals, "booze")
+		c.Check(createOpts.ResourceLimits, check.DeepEquals, quota.NewResourcesBuilder().
+			WithIOWriteBandwidth("/dev/mmcblk0", 5*quantity.SizeMiB).
+			WithIOReadIOPS("/dev/mmcblk0", 1000).
+			WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).
+			Build())
+		ts := state.NewTaskSet(st.NewTask("foo-quota", "..."))
+		return ts, nil
+	})
+	defer r()
+
+	data, err := json.Marshal(daemon.PostQuotaGroupData{
+		Action:    "ensure",
+		GroupName: "booze",
+		Snaps:     []string{"some-snap"},
+		Constraints: client.QuotaValues{
+			IO: []client.QuotaIOValues{
+				{Device: "/dev/mmcblk0", WriteBandwidth: 5 * quantity.SizeMiB, ReadIOPS: 1000},
+				{Device: "/dev/sda", ReadBandwidth: quantity.SizeMiB},
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	req, err := http.NewRequest("POST", "/v2/quotas", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	rsp := s.asyncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(createCalled, check.Equals, 1)
+}
+
+func (s *apiQuotaSuite) TestPostEnsureQuotaCreateNetworkHappy(c *check.C) {
+	var createCalled int
+	r := daemon.MockServicestateCreateQuota(func(st *state.State, name string, createOpts servicestate.CreateQuotaOptions) (*state.TaskSet, error) {
+		createCalled++
+		c.Check(name, check.Equals, "booze")
+		c.Check(createOpts.ResourceLimits, check.DeepEquals, quota.NewResourcesBuilder().
+			WithNetworkEgressBandwidth(5*quantity.SizeMiB).
+			Build())
+		ts := state.NewTaskSet(st.NewTask("foo-quota", "..."))
+		return ts, nil
+	})
+	defer r()
+
+	data, err := json.Marshal(daemon.PostQuotaGroupData{
+		Action:    "ensure",
+		GroupName: "booze",
+		Snaps:     []string{"some-snap"},
+		Constraints: client.QuotaValues{
+			Network: &client.QuotaNetworkValues{EgressBandwidth: 5 * quantity.SizeMiB},
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	req, err := http.NewRequest("POST", "/v2/quotas", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	rsp := s.asyncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(createCalled, check.Equals, 1)
+}
+
+func (s *apiQuotaSuite) TestPostEnsureQuotaCreateMemoryHighSwapOOMPolicyHappy(c *check.C) {
+	var createCalled int
+	r := daemon.MockServicestateCreateQuota(func(st *state.State, name string, createOpts servicestate.CreateQuotaOptions) (*state.TaskSet, error) {
+		createCalled++
+		c.Check(name, check.Equals, "booze")
+		c.Check(createOpts.ResourceLimits, check.DeepEquals, quota.NewResourcesBuilder().
+			WithMemoryLimit(quantity.SizeGiB).
+			WithMemoryHigh(512*quantity.SizeMiB).
+			// a zero swap limit disables swap
+			WithMemorySwapMax(0).
+			WithOOMPolicy(quota.OOMPolicyKillGroup).
+			Build())
+		ts := state.NewTaskSet(st.NewTask("foo-quota", "..."))
+		return ts, nil
+	})
+	defer r()
+
+	var noSwap quantity.Size
+	data, err := json.Marshal(daemon.PostQuotaGroupData{
+		Action:    "ensure",
+		GroupName: "booze",
+		Snaps:     []string{"some-snap"},
+		Constraints: client.QuotaValues{
+			Memory:     quantity.SizeGiB,
+			MemoryHigh: 512 * quantity.SizeMiB,
+			MemorySwap: &noSwap,
+			OOMPolicy:  "kill-group",
+		},
+	})
+	c.Assert(err, check.IsNil)
+
+	req, err := http.NewRequest("POST", "/v2/quotas", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	rsp := s.asyncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(createCalled, check.Equals, 1)
+}
+
+func (s *apiQuotaSuite) TestPostEnsureQuotaUserAppsHappy(c *check.C) {
+	var createCalled int
+	r := daemon.MockServicestateCreateQuota(func(st *state.State, name string, createOpts servicestate.CreateQuotaOptions) (*state.TaskSet, error) {
+		createCalled++
+		c.Check(name, check.Equals, "booze")
+		c.Check(createOpts.UserApps, check.Equals, true)
+		ts := state.NewTaskSet(st.NewTask("foo-quota", "..."))
+		return ts, nil
+	})
+	defer r()
+
+	userApps := true
+	data, err := json.Marshal(daemon.PostQuotaGroupData{
+		Action:      "ensure",
+		GroupName:   "booze",
+		Snaps:       []string{"some-snap"},
+		Constraints: client.QuotaValues{Memory: quantity.SizeMiB},
+		UserApps:    &userApps,
+	})
+	c.Assert(err, check.IsNil)
+
+	req, err := http.NewRequest("POST", "/v2/quotas", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	rsp := s.asyncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(createCalled, check.Equals, 1)
+
+	// and the group can be updated to not include them anymore
+	st := s.d.Overlord().State()
+	st.Lock()
+	err = servicestatetest.MockQuotaInState(st, "booze", "", nil, nil, quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).Build())
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	var updateCalled int
+	r = daemon.MockServicestateUpdateQuota(func(st *state.State, name string, opts servicestate.UpdateQuotaOptions) (*state.TaskSet, error) {
+		updateCalled++
+		c.Assert(opts.UserApps, check.NotNil)
+		c.Check(*opts.UserApps, check.Equals, false)
+		ts := state.NewTaskSet(st.NewTask("foo-quota", "..."))
+		return ts, nil
+	})
+	defer r()
+
+	data, err = json.Marshal(map[string]interface{}{
+		"action":     "ensure",
+		"group-name": "booze",
+		"user-apps":  false,
+	})
+	c.Assert(err, check.IsNil)
+
+	req, err = http.NewRequest("POST", "/v2/quotas", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	rsp = s.asyncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 202)
+	c.Assert(updateCalled, check.Equals, 1)
+}
+
+func (s *apiQuotaSuite) TestGetQuotaUsageNetwork(c *check.C) {
+	r := systemd.MockSystemctl(func(args ...string) ([]byte, error) {
+		switch args[0] {
+		case "is-active":
+			c.Check(args, check.DeepEquals, []string{"is-active", "snap.booze.slice"})
+			return []byte("active"), nil
+		case "show":
+			c.Check(args, check.DeepEquals, []string{"show", "--property", "IPEgressBytes", "snap.booze.slice"})
+			return []byte("IPEgressBytes=4096"), nil
+		}
+		c.Errorf("unexpected systemctl call %v", args)
+		return nil, fmt.Errorf("broken test")
+	})
+	defer r()
+
+	grp, err := quota.NewGroup("booze", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, check.IsNil)
+
+	usage, err := daemon.GetQuotaUsage(grp)
+	c.Assert(err, check.IsNil)
+	c.Check(usage, check.DeepEquals, &client.QuotaValues{
+		Network: &client.QuotaNetworkValues{Egress: 4 * quantity.SizeKiB},
+	})
+}
+
 func (s *apiQuotaSuite) TestPostEnsureQuotaUpdateCpuHappy(c *check.C) {
 	st := s.d.Overlord().State()
 	st.Lock()
@@ -846,6 +1041,74 @@ func (s *apiQuotaSuite) TestGetQuota(c *check.C) {
 	c.Check(s.ensureSoonCalled, check.Equals, 0)
 }
 
+func (s *apiQuotaSuite) TestGetQuotaMemoryHighSwapOOMPolicy(c *check.C) {
+	st := s.d.Overlord().State()
+	st.Lock()
+	err := servicestatetest.MockQuotaInState(st, "booze", "", nil, nil,
+		quota.NewResourcesBuilder().
+			WithMemoryLimit(quantity.SizeGiB).
+			WithMemoryHigh(512*quantity.SizeMiB).
+			WithMemorySwapMax(quantity.SizeMiB).
+			WithOOMPolicy(quota.OOMPolicyKillProcess).
+			Build())
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+
+	r := daemon.MockGetQuotaUsage(func(grp *quota.Group) (*client.QuotaValues, error) {
+		return &client.QuotaValues{}, nil
+	})
+	defer r()
+
+	req, err := http.NewRequest("GET", "/v2/quotas/booze", nil)
+	c.Assert(err, check.IsNil)
+	rsp := s.syncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 200)
+	c.Assert(rsp.Result, check.FitsTypeOf, client.QuotaGroupResult{})
+	res := rsp.Result.(client.QuotaGroupResult)
+	swap := quantity.SizeMiB
+	c.Check(res.Constraints, check.DeepEquals, &client.QuotaValues{
+		Memory:     quantity.SizeGiB,
+		MemoryHigh: 512 * quantity.SizeMiB,
+		MemorySwap: &swap,
+		OOMPolicy:  "kill-process",
+	})
+}
+
+func (s *apiQuotaSuite) TestGetQuotaUsageHistory(c *check.C) {
+	st := s.d.Overlord().State()
+	st.Lock()
+	mockQuotas(st, c)
+	st.Unlock()
+
+	r := daemon.MockGetQuotaUsage(func(grp *quota.Group) (*client.QuotaValues, error) {
+		return &client.QuotaValues{Memory: quantity.Size(500)}, nil
+	})
+	defer r()
+	r = daemon.MockServicestateQuotaUsageHistory(func(mgr *servicestate.ServiceManager, name string) map[string][]servicestate.QuotaUsageStats {
+		c.Check(mgr, check.Equals, s.d.Overlord().ServiceManager())
+		c.Check(name, check.Equals, "bar")
+		return map[string][]servicestate.QuotaUsageStats{
+			"memory": {
+				{Window: 5 * time.Minute, Samples: 5, Min: 100, Avg: 300, Max: 500},
+				{Window: time.Hour, Samples: 10, Min: 50, Avg: 200, Max: 500},
+			},
+		}
+	})
+	defer r()
+
+	req, err := http.NewRequest("GET", "/v2/quotas/bar", nil)
+	c.Assert(err, check.IsNil)
+	rsp := s.syncReq(c, req, nil)
+	c.Assert(rsp.Status, check.Equals, 200)
+	res := rsp.Result.(client.QuotaGroupResult)
+	c.Check(res.History, check.DeepEquals, map[string][]client.QuotaUsageStats{
+		"memory": {
+			{Window: 5 * time.Minute, Samples: 5, Min: 100, Avg: 300, Max: 500},
+			{Window: time.Hour, Samples: 10, Min: 50, Avg: 200, Max: 500},
+		},
+	})
+}
+
 func (s *apiQuotaSuite) TestGetQuotaInvalidName(c *check.C) {
 	st := s.d.Overlord().State()
 	st.Lock()
@@ -27,6 +27,7 @@ import (
 	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/overlord/auth"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/registry"
 	"github.com/snapcore/snapd/strutil"
@@ -40,6 +41,14 @@ var (
 		ReadAccess:  authenticatedAccess{Polkit: polkitActionManage},
 		WriteAccess: authenticatedAccess{Polkit: polkitActionManage},
 	}
+
+	registryHistoryCmd = &Command{
+		Path:        "/v2/registry-history/{account}/{registry}",
+		GET:         getRegistryHistory,
+		POST:        postRegistryHistory,
+		ReadAccess:  authenticatedAccess{Polkit: polkitActionManage},
+		WriteAccess: authenticatedAccess{Polkit: polkitActionManage},
+	}
 )
 
 func getView(c *Command, r *http.Request, _ *auth.UserState) Response {
@@ -60,6 +69,20 @@ func getView(c *Command, r *http.Request, _ *auth.UserState) Response {
 		fields = strutil.CommaSeparatedList(fieldStr)
 	}
 
+	// views stored by custodian snaps must be loaded by them before being read
+	ts, err := registrystateLoadViaView(st, account, registryName, view, fields)
+	if err != nil {
+		return toAPIError(err)
+	}
+
+	if ts != nil {
+		summary := fmt.Sprintf("Get registry view %s/%s/%s", account, registryName, view)
+		chg := newChange(st, "get-registry-view", summary, []*state.TaskSet{ts}, nil)
+		ensureStateSoon(st)
+
+		return AsyncResponse(nil, chg.ID())
+	}
+
 	results, err := registrystateGetViaView(st, account, registryName, view, fields)
 	if err != nil {
 		return toAPIError(err)
@@ -86,15 +109,89 @@ func setView(c *Command, r *http.Request, _ *auth.UserState) Response {
 		return BadRequest("cannot decode registry request body: %v", err)
 	}
 
-	err := registrystateSetViaView(st, account, registryName, view, values)
+	ts, err := registrystateSetViaView(st, account, registryName, view, values, authorFromRequest(r))
 	if err != nil {
 		return toAPIError(err)
 	}
 
-	// NOTE: could be sync but this is closer to the final version and the conf API
 	summary := fmt.Sprintf("Set registry view %s/%s/%s", account, registryName, view)
-	chg := newChange(st, "set-registry-view", summary, nil, nil)
-	chg.SetStatus(state.DoneStatus)
+	chg := newChange(st, "set-registry-view", summary, []*state.TaskSet{ts}, nil)
+	ensureStateSoon(st)
+
+	return AsyncResponse(nil, chg.ID())
+}
+
+// authorFromRequest returns the author of registry changes made through the
+// request.
+func authorFromRequest(r *http.Request) registrystate.Author {
+	var author registrystate.Author
+	if uid, err := uidFromRequest(r); err == nil {
+		author.UID = &uid
+	}
+	return author
+}
+
+func getRegistryHistory(c *Command, r *http.Request, _ *auth.UserState) Response {
+	st := c.d.state
+	st.Lock()
+	defer st.Unlock()
+
+	if err := validateRegistryFeatureFlag(st); err != nil {
+		return err
+	}
+
+	vars := muxVars(r)
+	account, registryName := vars["account"], vars["registry"]
+
+	revs, err := registrystateHistory(st, account, registryName)
+	if err != nil {
+		return toAPIError(err)
+	}
+
+	if revs == nil {
+		revs = []*registrystate.DatabagRevision{}
+	}
+	return SyncResponse(revs)
+}
+
+type registryHistoryAction struct {
+	Action   string `json:"action"`
+	Revision int    `json:"revision"`
+}
+
+func postRegistryHistory(c *Command, r *http.Request, _ *auth.UserState) Response {
+	st := c.d.state
+	st.Lock()
+	defer st.Unlock()
+
+	if err := validateRegistryFeatureFlag(st); err != nil {
+		return err
+	}
+
+	vars := muxVars(r)
+	account, registryName := vars["account"], vars["registry"]
+
+	var action registryHistoryAction
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&action); err != nil {
+		return BadRequest("cannot decode request body into registry history action: %v", err)
+	}
+
+	if action.Action != "revert" {
+		return BadRequest("unsupported registry history action %q", action.Action)
+	}
+
+	if action.Revision <= 0 {
+		return BadRequest("cannot revert registry: invalid revision %d", action.Revision)
+	}
+
+	ts, err := registrystateRevert(st, account, registryName, action.Revision, authorFromRequest(r))
+	if err != nil {
+		return toAPIError(err)
+	}
+
+	summary := fmt.Sprintf("Revert registry %s/%s to revision %d", account, registryName, action.Revision)
+	chg := newChange(st, "revert-registry", summary, []*state.TaskSet{ts}, nil)
 	ensureStateSoon(st)
 
 	return AsyncResponse(nil, chg.ID())
@@ -102,12 +199,17 @@ func setView(c *Command, r *http.Request, _ *auth.UserState) Response {
 
 func toAPIError(err error) *apiError {
 	switch {
-	case errors.Is(err, &registry.NotFoundError{}):
+	case errors.Is(err, &registry.NotFoundError{}), errors.Is(err, &registrystate.RevisionNotFoundError{}):
 		return NotFound(err.Error())
 
 	case errors.Is(err, &registry.BadRequestError{}):
 		return BadRequest(err.Error())
 
+	case errors.Is(err, &registrystate.ChangeConflictError{}):
+		var conflErr *registrystate.ChangeConflictError
+		errors.As(err, &conflErr)
+		return RegistryChangeConflict(conflErr)
+
 	default:
 		return InternalError(err.Error())
 	}
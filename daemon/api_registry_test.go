@@ -24,14 +24,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/daemon"
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/registry"
 )
@@ -60,6 +63,11 @@ func (s *registrySuite) SetUpTest(c *C) {
 	}
 	s.st.Set("registry-databags", databags)
 	s.st.Unlock()
+
+	// by default, views are stored by snapd so nothing needs to be loaded
+	s.AddCleanup(daemon.MockRegistrystateLoadViaView(func(*state.State, string, string, string, []string) (*state.TaskSet, error) {
+		return nil, nil
+	}))
 }
 
 func (s *registrySuite) setFeatureFlag(c *C) {
@@ -242,7 +250,7 @@ func (s *registrySuite) testViewSetMany(c *C) {
 	s.setFeatureFlag(c)
 
 	var calls int
-	restore := daemon.MockRegistrystateSetViaView(func(st *state.State, account, registryName, viewName string, requests map[string]interface{}) error {
+	restore := daemon.MockRegistrystateSetViaView(func(st *state.State, account, registryName, viewName string, requests map[string]interface{}, _ registrystate.Author) (*state.TaskSet, error) {
 		calls++
 		switch calls {
 		case 1:
@@ -258,10 +266,10 @@ func (s *registrySuite) testViewSetMany(c *C) {
 		default:
 			err := fmt.Errorf("expected 1 call, now on %d", calls)
 			c.Error(err)
-			return err
+			return nil, err
 		}
 
-		return nil
+		return state.NewTaskSet(st.NewTask("commit-registry-tx", "")), nil
 	})
 	defer restore()
 
@@ -279,7 +287,7 @@ func (s *registrySuite) testViewSetMany(c *C) {
 	chg := st.Change(rspe.Change)
 	c.Check(chg.Kind(), Equals, "set-registry-view")
 	c.Check(chg.Summary(), Equals, `Set registry view system/network/wifi-setup`)
-	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(chg.Status(), Equals, state.DoStatus)
 
 	var databags map[string]map[string]registry.JSONDataBag
 	err = st.Get("registry-databags", &databags)
@@ -361,7 +369,7 @@ func (s *registrySuite) TestSetView(c *C) {
 		{name: "map", value: map[string]interface{}{"foo": "bar"}},
 	} {
 		cmt := Commentf("%s test", t.name)
-		restore := daemon.MockRegistrystateSetViaView(func(st *state.State, acc, registryName, view string, requests map[string]interface{}) error {
+		restore := daemon.MockRegistrystateSetViaView(func(st *state.State, acc, registryName, view string, requests map[string]interface{}, _ registrystate.Author) (*state.TaskSet, error) {
 			c.Check(acc, Equals, "system", cmt)
 			c.Check(registryName, Equals, "network", cmt)
 			c.Check(view, Equals, "wifi-setup", cmt)
@@ -372,7 +380,7 @@ func (s *registrySuite) TestSetView(c *C) {
 			c.Check(err, IsNil)
 			st.Set("registry-databags", map[string]map[string]registry.JSONDataBag{acc: {registryName: bag}})
 
-			return nil
+			return state.NewTaskSet(st.NewTask("commit-registry-tx", "")), nil
 		})
 		jsonVal, err := json.Marshal(t.value)
 		c.Check(err, IsNil, cmt)
@@ -394,7 +402,7 @@ func (s *registrySuite) TestSetView(c *C) {
 		c.Check(chg.Summary(), Equals, `Set registry view system/network/wifi-setup`, cmt)
 
 		st.Lock()
-		c.Check(chg.Status(), Equals, state.DoneStatus)
+		c.Check(chg.Status(), Equals, state.DoStatus)
 
 		var databags map[string]map[string]registry.JSONDataBag
 		err = st.Get("registry-databags", &databags)
@@ -412,12 +420,12 @@ func (s *registrySuite) TestSetView(c *C) {
 func (s *registrySuite) TestUnsetView(c *C) {
 	s.setFeatureFlag(c)
 
-	restore := daemon.MockRegistrystateSetViaView(func(_ *state.State, acc, registryName, view string, requests map[string]interface{}) error {
+	restore := daemon.MockRegistrystateSetViaView(func(st *state.State, acc, registryName, view string, requests map[string]interface{}, _ registrystate.Author) (*state.TaskSet, error) {
 		c.Check(acc, Equals, "system")
 		c.Check(registryName, Equals, "network")
 		c.Check(view, Equals, "wifi-setup")
 		c.Check(requests, DeepEquals, map[string]interface{}{"ssid": nil})
-		return nil
+		return state.NewTaskSet(st.NewTask("commit-registry-tx", "")), nil
 	})
 	defer restore()
 
@@ -435,10 +443,42 @@ func (s *registrySuite) TestUnsetView(c *C) {
 
 	c.Check(chg.Kind(), Equals, "set-registry-view")
 	c.Check(chg.Summary(), Equals, `Set registry view system/network/wifi-setup`)
-	c.Check(chg.Status(), Equals, state.DoneStatus)
+	c.Check(chg.Status(), Equals, state.DoStatus)
 	st.Unlock()
 }
 
+func (s *registrySuite) TestSetViewWithHooks(c *C) {
+	s.setFeatureFlag(c)
+
+	var taskID string
+	restore := daemon.MockRegistrystateSetViaView(func(st *state.State, _, _, _ string, _ map[string]interface{}, _ registrystate.Author) (*state.TaskSet, error) {
+		t := st.NewTask("commit-registry-tx", "")
+		taskID = t.ID()
+		return state.NewTaskSet(t), nil
+	})
+	defer restore()
+
+	buf := bytes.NewBufferString(`{"ssid": "foo"}`)
+	req, err := http.NewRequest("PUT", "/v2/registry/system/network/wifi-setup", buf)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	rspe := s.asyncReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 202)
+
+	st := s.d.Overlord().State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rspe.Change)
+
+	c.Check(chg.Kind(), Equals, "set-registry-view")
+	c.Check(chg.Summary(), Equals, `Set registry view system/network/wifi-setup`)
+	c.Check(chg.Status(), Equals, state.DoStatus)
+	tasks := chg.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].ID(), Equals, taskID)
+}
+
 func (s *registrySuite) TestSetViewError(c *C) {
 	s.setFeatureFlag(c)
 
@@ -450,10 +490,11 @@ func (s *registrySuite) TestSetViewError(c *C) {
 
 	for _, t := range []test{
 		{name: "not found", err: &registry.NotFoundError{}, code: 404},
+		{name: "conflict", err: &registrystate.ChangeConflictError{}, code: 409},
 		{name: "internal", err: errors.New("internal"), code: 500},
 	} {
-		restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}) error {
-			return t.err
+		restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
+			return nil, t.err
 		})
 		cmt := Commentf("%s test", t.name)
 
@@ -468,13 +509,43 @@ func (s *registrySuite) TestSetViewError(c *C) {
 	}
 }
 
+func (s *registrySuite) TestSetViewConflict(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
+		return nil, &registrystate.ChangeConflictError{
+			Account:      "system",
+			RegistryName: "network",
+			Path:         "wifi.ssid",
+			ChangeKind:   "set-registry-view",
+			ChangeID:     "1",
+		}
+	})
+	defer restore()
+
+	buf := bytes.NewBufferString(`{"ssid": "foo"}`)
+	req, err := http.NewRequest("PUT", "/v2/registry/system/network/wifi-setup", buf)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/json")
+
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 409)
+	c.Check(rspe.Kind, Equals, client.ErrorKindRegistryChangeConflict)
+	c.Check(rspe.Message, Equals, `cannot change "wifi.ssid" in registry system/network: "set-registry-view" change in progress (change 1)`)
+	c.Check(rspe.Value, DeepEquals, map[string]interface{}{
+		"account":       "system",
+		"registry-name": "network",
+		"change-kind":   "set-registry-view",
+	})
+}
+
 func (s *registrySuite) TestSetViewEmptyBody(c *C) {
 	s.setFeatureFlag(c)
 
-	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}) error {
+	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
 		err := errors.New("unexpected call to registrystate.Set")
 		c.Error(err)
-		return err
+		return nil, err
 	})
 	defer restore()
 
@@ -525,8 +596,8 @@ func (s *registrySuite) TestGetBadRequest(c *C) {
 func (s *registrySuite) TestSetBadRequest(c *C) {
 	s.setFeatureFlag(c)
 
-	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}) error {
-		return &registry.BadRequestError{
+	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
+		return nil, &registry.BadRequestError{
 			Account:      "acc",
 			RegistryName: "reg",
 			View:         "foo",
@@ -549,10 +620,10 @@ func (s *registrySuite) TestSetBadRequest(c *C) {
 }
 
 func (s *registrySuite) TestSetFailUnsetFeatureFlag(c *C) {
-	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}) error {
+	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
 		err := fmt.Errorf("unexpected call to registrystate")
 		c.Error(err)
-		return err
+		return nil, err
 	})
 	defer restore()
 
@@ -568,10 +639,10 @@ func (s *registrySuite) TestSetFailUnsetFeatureFlag(c *C) {
 }
 
 func (s *registrySuite) TestGetFailUnsetFeatureFlag(c *C) {
-	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}) error {
+	restore := daemon.MockRegistrystateSetViaView(func(*state.State, string, string, string, map[string]interface{}, registrystate.Author) (*state.TaskSet, error) {
 		err := fmt.Errorf("unexpected call to registrystate")
 		c.Error(err)
-		return err
+		return nil, err
 	})
 	defer restore()
 
@@ -601,3 +672,246 @@ func (s *registrySuite) TestGetNoFields(c *C) {
 	c.Check(rspe.Status, Equals, 200)
 	c.Check(rspe.Result, DeepEquals, value)
 }
+
+func (s *registrySuite) TestSetViewPassesAuthor(c *C) {
+	s.setFeatureFlag(c)
+
+	var author registrystate.Author
+	restore := daemon.MockRegistrystateSetViaView(func(st *state.State, _, _, _ string, _ map[string]interface{}, a registrystate.Author) (*state.TaskSet, error) {
+		author = a
+		return state.NewTaskSet(st.NewTask("commit-registry-tx", "")), nil
+	})
+	defer restore()
+
+	buf := bytes.NewBufferString(`{"ssid": "foo"}`)
+	req, err := http.NewRequest("PUT", "/v2/registry/system/network/wifi-setup", buf)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = fmt.Sprintf("pid=100;uid=1000;socket=%s;", dirs.SnapdSocket)
+
+	rspe := s.asyncReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 202)
+
+	c.Assert(author.UID, NotNil)
+	c.Check(*author.UID, Equals, uint32(1000))
+	c.Check(author.Snap, Equals, "")
+	c.Check(author.Hook, Equals, "")
+}
+
+func (s *registrySuite) TestGetHistory(c *C) {
+	s.setFeatureFlag(c)
+
+	uid := uint32(0)
+	restore := daemon.MockRegistrystateHistory(func(_ *state.State, account, registryName string) ([]*registrystate.DatabagRevision, error) {
+		c.Check(account, Equals, "system")
+		c.Check(registryName, Equals, "network")
+
+		bag := registry.NewJSONDataBag()
+		c.Assert(bag.Set("wifi.ssid", "foo"), IsNil)
+		return []*registrystate.DatabagRevision{
+			{
+				Revision: 1,
+				Time:     time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				Author:   registrystate.Author{UID: &uid},
+				Databag:  bag,
+			},
+			{
+				Revision: 2,
+				Time:     time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC),
+				Author:   registrystate.Author{Snap: "some-snap", Hook: "configure"},
+				Databag:  registry.NewJSONDataBag(),
+			},
+		}, nil
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/registry-history/system/network", nil)
+	c.Assert(err, IsNil)
+
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Status, Equals, 200)
+
+	// check the JSON representation of the revisions
+	data, err := json.Marshal(rsp.Result)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `[`+
+		`{"revision":1,"time":"2024-05-01T10:00:00Z","author":{"uid":0},"databag":{"wifi":{"ssid":"foo"}}},`+
+		`{"revision":2,"time":"2024-05-01T11:00:00Z","author":{"snap":"some-snap","hook":"configure"},"databag":{}}]`)
+}
+
+func (s *registrySuite) TestGetHistoryEmpty(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateHistory(func(*state.State, string, string) ([]*registrystate.DatabagRevision, error) {
+		return nil, nil
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/registry-history/system/network", nil)
+	c.Assert(err, IsNil)
+
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Status, Equals, 200)
+	c.Check(rsp.Result, DeepEquals, []*registrystate.DatabagRevision{})
+}
+
+func (s *registrySuite) TestGetHistoryError(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateHistory(func(*state.State, string, string) ([]*registrystate.DatabagRevision, error) {
+		return nil, errors.New("boom")
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/registry-history/system/network", nil)
+	c.Assert(err, IsNil)
+
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 500)
+	c.Check(rspe.Message, Equals, "boom")
+}
+
+func (s *registrySuite) TestRevert(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateRevert(func(st *state.State, account, registryName string, revision int, author registrystate.Author) (*state.TaskSet, error) {
+		c.Check(account, Equals, "system")
+		c.Check(registryName, Equals, "network")
+		c.Check(revision, Equals, 2)
+		c.Assert(author.UID, NotNil)
+		c.Check(*author.UID, Equals, uint32(0))
+		return state.NewTaskSet(st.NewTask("commit-registry-tx", "")), nil
+	})
+	defer restore()
+
+	buf := bytes.NewBufferString(`{"action": "revert", "revision": 2}`)
+	req, err := http.NewRequest("POST", "/v2/registry-history/system/network", buf)
+	c.Assert(err, IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = fmt.Sprintf("pid=100;uid=0;socket=%s;", dirs.SnapdSocket)
+
+	rspe := s.asyncReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 202)
+
+	st := s.d.Overlord().State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rspe.Change)
+	c.Check(chg.Kind(), Equals, "revert-registry")
+	c.Check(chg.Summary(), Equals, "Revert registry system/network to revision 2")
+	c.Check(chg.Status(), Equals, state.DoStatus)
+}
+
+func (s *registrySuite) TestRevertBadRequest(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateRevert(func(*state.State, string, string, int, registrystate.Author) (*state.TaskSet, error) {
+		err := errors.New("unexpected call to registrystate.Revert")
+		c.Error(err)
+		return nil, err
+	})
+	defer restore()
+
+	for _, t := range []struct {
+		body string
+		err  string
+	}{
+		{body: `{`, err: "cannot decode request body into registry history action: unexpected EOF"},
+		{body: `{"action": "foo", "revision": 1}`, err: `unsupported registry history action "foo"`},
+		{body: `{"action": "revert"}`, err: "cannot revert registry: invalid revision 0"},
+		{body: `{"action": "revert", "revision": -1}`, err: "cannot revert registry: invalid revision -1"},
+	} {
+		cmt := Commentf("body: %s", t.body)
+		req, err := http.NewRequest("POST", "/v2/registry-history/system/network", bytes.NewBufferString(t.body))
+		c.Assert(err, IsNil, cmt)
+		req.Header.Set("Content-Type", "application/json")
+
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, 400, cmt)
+		c.Check(rspe.Message, Equals, t.err, cmt)
+	}
+}
+
+func (s *registrySuite) TestRevertError(c *C) {
+	s.setFeatureFlag(c)
+
+	for _, t := range []struct {
+		err  error
+		code int
+	}{
+		{err: &registrystate.RevisionNotFoundError{Account: "system", RegistryName: "network", Revision: 5}, code: 404},
+		{err: &registrystate.ChangeConflictError{}, code: 409},
+		{err: errors.New("boom"), code: 500},
+	} {
+		cmt := Commentf("%s test", t.err)
+		restore := daemon.MockRegistrystateRevert(func(*state.State, string, string, int, registrystate.Author) (*state.TaskSet, error) {
+			return nil, t.err
+		})
+
+		buf := bytes.NewBufferString(`{"action": "revert", "revision": 5}`)
+		req, err := http.NewRequest("POST", "/v2/registry-history/system/network", buf)
+		c.Assert(err, IsNil, cmt)
+		req.Header.Set("Content-Type", "application/json")
+
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, Equals, t.code, cmt)
+		restore()
+	}
+}
+
+func (s *registrySuite) TestHistoryFailUnsetFeatureFlag(c *C) {
+	req, err := http.NewRequest("GET", "/v2/registry-history/system/network", nil)
+	c.Assert(err, IsNil)
+
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 400)
+	c.Check(rspe.Message, Equals, `"registries" feature flag is disabled: set 'experimental.registries' to true`)
+}
+
+func (s *registrySuite) TestGetViewLoadedByCustodians(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateLoadViaView(func(st *state.State, acc, registryName, view string, fields []string) (*state.TaskSet, error) {
+		c.Check(acc, Equals, "system")
+		c.Check(registryName, Equals, "network")
+		c.Check(view, Equals, "wifi-setup")
+		c.Check(fields, DeepEquals, []string{"ssid"})
+		return state.NewTaskSet(st.NewTask("read-registry-view", "")), nil
+	})
+	defer restore()
+
+	restore = daemon.MockRegistrystateGetViaView(func(*state.State, string, string, string, []string) (interface{}, error) {
+		err := errors.New("unexpected call to registrystate.GetViaView")
+		c.Error(err)
+		return nil, err
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/registry/system/network/wifi-setup?fields=ssid", nil)
+	c.Assert(err, IsNil)
+
+	rspe := s.asyncReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 202)
+
+	st := s.d.Overlord().State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rspe.Change)
+	c.Check(chg.Kind(), Equals, "get-registry-view")
+	c.Check(chg.Summary(), Equals, "Get registry view system/network/wifi-setup")
+}
+
+func (s *registrySuite) TestGetViewLoadError(c *C) {
+	s.setFeatureFlag(c)
+
+	restore := daemon.MockRegistrystateLoadViaView(func(*state.State, string, string, string, []string) (*state.TaskSet, error) {
+		return nil, &registry.NotFoundError{}
+	})
+	defer restore()
+
+	req, err := http.NewRequest("GET", "/v2/registry/system/network/wifi-setup?fields=ssid", nil)
+	c.Assert(err, IsNil)
+
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, Equals, 404)
+}
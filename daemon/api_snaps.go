@@ -145,6 +145,9 @@ func postSnap(c *Command, r *http.Request, user *auth.UserState) Response {
 	if err := inst.validate(); err != nil {
 		return BadRequest("%s", err)
 	}
+	if inst.SoakPeriod != "" {
+		return BadRequest("soak-period can only be specified for refreshes of multiple snaps")
+	}
 
 	impl := inst.dispatch()
 	if impl == nil {
@@ -230,10 +233,12 @@ type snapInstruction struct {
 	Snaps                  []string                         `json:"snaps"`
 	Users                  []string                         `json:"users"`
 	SnapshotOptions        map[string]*snap.SnapshotOptions `json:"snapshot-options"`
+	SnapshotPassphrase     string                           `json:"snapshot-passphrase"`
 	ValidationSets         []string                         `json:"validation-sets"`
 	QuotaGroupName         string                           `json:"quota-group"`
 	Time                   string                           `json:"time"`
 	HoldLevel              string                           `json:"hold-level"`
+	SoakPeriod             string                           `json:"soak-period"`
 
 	// The fields below should not be unmarshalled into. Do not export them.
 	userID int
@@ -365,6 +370,17 @@ func (inst *snapInstruction) validate() error {
 	default:
 		return fmt.Errorf("invalid value for transaction type: %s", inst.Transaction)
 	}
+	if inst.SoakPeriod != "" {
+		if inst.Action != "refresh" {
+			return fmt.Errorf("soak-period can only be specified for refresh")
+		}
+		if inst.Transaction == client.TransactionPerSnap {
+			return fmt.Errorf("soak-period cannot be used with a per-snap transaction")
+		}
+		if d, err := time.ParseDuration(inst.SoakPeriod); err != nil || d <= 0 {
+			return fmt.Errorf("invalid soak-period %q: must be a positive duration", inst.SoakPeriod)
+		}
+	}
 	if inst.QuotaGroupName != "" && inst.Action != "install" {
 		return fmt.Errorf("quota-group can only be specified on install")
 	}
@@ -953,11 +969,20 @@ func snapUpdateMany(ctx context.Context, inst *snapInstruction, st *state.State)
 		return nil, err
 	}
 
-	transaction := inst.Transaction
-	updated, tasksets, err := snapstateUpdateMany(ctx, st, inst.Snaps, nil, inst.userID, &snapstate.Flags{
+	flags := &snapstate.Flags{
 		IgnoreRunning: inst.IgnoreRunning,
-		Transaction:   transaction,
-	})
+		Transaction:   inst.Transaction,
+	}
+	var updated []string
+	var tasksets []*state.TaskSet
+	var err error
+	if inst.SoakPeriod != "" {
+		// the soak period was validated already
+		soakPeriod, _ := time.ParseDuration(inst.SoakPeriod)
+		updated, tasksets, err = healthstateRefreshGroup(ctx, st, inst.Snaps, soakPeriod, inst.userID, flags)
+	} else {
+		updated, tasksets, err = snapstateUpdateMany(ctx, st, inst.Snaps, nil, inst.userID, flags)
+	}
 	if err != nil {
 		if opts.IsRefreshOfAllSnaps {
 			if err := assertstateRestoreValidationSetsTracking(st); err != nil && !errors.Is(err, state.ErrNoState) {
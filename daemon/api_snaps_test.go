@@ -848,6 +848,70 @@ func (s *snapsSuite) TestRefreshManyTransactionally(c *check.C) {
 	c.Check(calledFlags.Transaction, check.Equals, client.TransactionAllSnaps)
 }
 
+func (s *snapsSuite) TestRefreshManySoakPeriod(c *check.C) {
+	defer daemon.MockAssertstateRefreshSnapAssertions(func(s *state.State, userID int, opts *assertstate.RefreshAssertionsOptions) error {
+		return nil
+	})()
+	defer daemon.MockSnapstateUpdateMany(func(_ context.Context, s *state.State, names []string, _ []*snapstate.RevisionOptions, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		c.Fatalf("unexpected call to snapstate.UpdateMany")
+		return nil, nil, nil
+	})()
+
+	var calledSoakPeriod time.Duration
+	var calledFlags *snapstate.Flags
+	defer daemon.MockHealthstateRefreshGroup(func(_ context.Context, s *state.State, names []string, soakPeriod time.Duration, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		calledSoakPeriod = soakPeriod
+		calledFlags = flags
+		c.Check(names, check.DeepEquals, []string{"foo", "bar"})
+		t := s.NewTask("fake-refresh-group", "Refreshing two as a group")
+		return names, []*state.TaskSet{state.NewTaskSet(t)}, nil
+	})()
+
+	d := s.daemon(c)
+	inst := &daemon.SnapInstruction{
+		Action:        "refresh",
+		Snaps:         []string{"foo", "bar"},
+		SoakPeriod:    "30m",
+		IgnoreRunning: true,
+	}
+	st := d.Overlord().State()
+	st.Lock()
+	res, err := inst.DispatchForMany()(context.Background(), inst, st)
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(res.Summary, check.Equals, `Refresh snaps "foo", "bar"`)
+	c.Check(res.Affected, check.DeepEquals, inst.Snaps)
+	c.Check(calledSoakPeriod, check.Equals, 30*time.Minute)
+	c.Check(calledFlags.IgnoreRunning, check.Equals, true)
+}
+
+func (s *snapsSuite) TestPostSnapsSoakPeriodErrors(c *check.C) {
+	s.daemonWithOverlordMock()
+
+	for _, t := range []struct {
+		body, err string
+	}{
+		{`{"action": "remove", "snaps": ["foo"], "soak-period": "1h"}`, `soak-period can only be specified for refresh`},
+		{`{"action": "refresh", "snaps": ["foo"], "soak-period": "1h", "transaction": "per-snap"}`, `soak-period cannot be used with a per-snap transaction`},
+		{`{"action": "refresh", "snaps": ["foo"], "soak-period": "soon"}`, `invalid soak-period "soon": must be a positive duration`},
+		{`{"action": "refresh", "snaps": ["foo"], "soak-period": "-1h"}`, `invalid soak-period "-1h": must be a positive duration`},
+	} {
+		req, err := http.NewRequest("POST", "/v2/snaps", strings.NewReader(t.body))
+		c.Assert(err, check.IsNil)
+		req.Header.Set("Content-Type", "application/json")
+
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, check.Equals, 400, check.Commentf("%s", t.body))
+		c.Check(rspe.Message, check.Equals, t.err, check.Commentf("%s", t.body))
+	}
+
+	req, err := http.NewRequest("POST", "/v2/snaps/foo", strings.NewReader(`{"action": "refresh", "soak-period": "1h"}`))
+	c.Assert(err, check.IsNil)
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, check.Equals, 400)
+	c.Check(rspe.Message, check.Equals, `soak-period can only be specified for refreshes of multiple snaps`)
+}
+
 func (s *snapsSuite) TestRefreshMany(c *check.C) {
 	refreshSnapAssertions := false
 	var refreshAssertionsOpts *assertstate.RefreshAssertionsOptions
@@ -1369,6 +1433,60 @@ func (s *snapsSuite) TestSnapInfoReturnsHolds(c *check.C) {
 	c.Check(snapInfo.GatingHold.Equal(gatingHold), check.Equals, true, testCmt)
 }
 
+func (s *snapsSuite) TestSnapInfoReturnsHealthHistory(c *check.C) {
+	s.expectSnapsReadAccess()
+	d := s.daemon(c)
+	s.mkInstalledInState(c, d, "foo", "bar", "v0", snap.R(5), true, "")
+
+	t0 := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	st := d.Overlord().State()
+	st.Lock()
+	st.Set("health", map[string]healthstate.HealthState{
+		"foo": {Revision: snap.R(5), Timestamp: t0.Add(time.Hour), Status: healthstate.ErrorStatus, Message: "broken"},
+	})
+	st.Set("health-history", map[string][]healthstate.HealthState{
+		"foo": {
+			{Revision: snap.R(5), Timestamp: t0, Status: healthstate.OkayStatus},
+			{Revision: snap.R(5), Timestamp: t0.Add(time.Hour), Status: healthstate.ErrorStatus, Message: "broken"},
+		},
+	})
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/snaps/foo", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := s.syncReq(c, req, nil)
+
+	c.Assert(rsp.Result, check.FitsTypeOf, &client.Snap{})
+	snapInfo := rsp.Result.(*client.Snap)
+	c.Check(snapInfo.HealthHistory, check.DeepEquals, []client.SnapHealth{
+		{Revision: snap.R(5), Timestamp: t0, Status: "okay"},
+		{Revision: snap.R(5), Timestamp: t0.Add(time.Hour), Status: "error", Message: "broken"},
+	})
+}
+
+func (s *snapsSuite) TestSnapManyInfosNoHealthHistory(c *check.C) {
+	s.expectSnapsReadAccess()
+	d := s.daemon(c)
+	s.mkInstalledInState(c, d, "foo", "bar", "v0", snap.R(5), true, "")
+
+	st := d.Overlord().State()
+	st.Lock()
+	st.Set("health-history", map[string][]healthstate.HealthState{
+		"foo": {{Revision: snap.R(5), Timestamp: time.Now(), Status: healthstate.OkayStatus}},
+	})
+	st.Unlock()
+
+	req, err := http.NewRequest("GET", "/v2/snaps", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := s.jsonReq(c, req, nil)
+	snaps := snapList(rsp.Result)
+	c.Assert(snaps, check.HasLen, 1)
+	_, ok := snaps[0]["health-history"]
+	c.Check(ok, check.Equals, false)
+}
+
 func (s *snapsSuite) TestSnapManyInfosReturnsHolds(c *check.C) {
 	s.expectSnapsReadAccess()
 	d := s.daemon(c)
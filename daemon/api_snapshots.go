@@ -45,6 +45,12 @@ var snapshotCmd = &Command{
 	WriteAccess: authenticatedAccess{Polkit: polkitActionManage},
 }
 
+var snapshotContentsCmd = &Command{
+	Path:       "/v2/snapshots/{id}/contents",
+	GET:        getSnapshotContents,
+	ReadAccess: authenticatedAccess{},
+}
+
 var snapshotExportCmd = &Command{
 	Path:       "/v2/snapshots/{id}/export",
 	GET:        getSnapshotExport,
@@ -52,13 +58,18 @@ var snapshotExportCmd = &Command{
 }
 
 var (
-	snapshotList    = snapshotstate.List
-	snapshotCheck   = snapshotstate.Check
-	snapshotForget  = snapshotstate.Forget
-	snapshotRestore = snapshotstate.Restore
-	snapshotSave    = snapshotstate.Save
-	snapshotExport  = snapshotstate.Export
-	snapshotImport  = snapshotstate.Import
+	snapshotList               = snapshotstate.List
+	snapshotCheck              = snapshotstate.Check
+	snapshotForget             = snapshotstate.Forget
+	snapshotPush               = snapshotstate.Push
+	snapshotRestore            = snapshotstate.Restore
+	snapshotRestoreFiles       = snapshotstate.RestoreFiles
+	snapshotContents           = snapshotstate.Contents
+	snapshotSave               = snapshotstate.Save
+	snapshotSaveWithPassphrase = snapshotstate.SaveWithPassphrase
+	snapshotProvidePassphrase  = snapshotstate.ProvidePassphrase
+	snapshotExport             = snapshotstate.Export
+	snapshotImport             = snapshotstate.Import
 )
 
 func listSnapshots(c *Command, r *http.Request, user *auth.UserState) Response {
@@ -89,6 +100,11 @@ type snapshotAction struct {
 	Action string   `json:"action"`
 	Snaps  []string `json:"snaps,omitempty"`
 	Users  []string `json:"users,omitempty"`
+	// Passphrase unlocks snapshots encrypted with one
+	Passphrase string `json:"passphrase,omitempty"`
+	// Paths and Target select the data to restore, and where to
+	Paths  []string `json:"paths,omitempty"`
+	Target string   `json:"target,omitempty"`
 }
 
 func (action snapshotAction) String() string {
@@ -135,16 +151,38 @@ func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response
 	st.Lock()
 	defer st.Unlock()
 
+	if action.Action != "restore" && (len(action.Paths) != 0 || action.Target != "") {
+		return BadRequest("snapshot %q operation cannot specify paths or a target", action.Action)
+	}
+
 	switch action.Action {
 	case "check":
 		affected, ts, err = snapshotCheck(st, action.SetID, action.Snaps, action.Users)
 	case "restore":
-		affected, ts, err = snapshotRestore(st, action.SetID, action.Snaps, action.Users)
+		if len(action.Paths) == 0 && action.Target == "" {
+			affected, ts, err = snapshotRestore(st, action.SetID, action.Snaps, action.Users)
+			break
+		}
+		if err := snapshotstate.ValidateRestoreFiles(action.Paths, action.Target); err != nil {
+			return BadRequest("%v", err)
+		}
+		affected, ts, err = snapshotRestoreFiles(st, action.SetID, action.Snaps, action.Users, action.Paths, action.Target)
 	case "forget":
 		if len(action.Users) != 0 {
 			return BadRequest(`snapshot "forget" operation cannot specify users`)
 		}
+		if action.Passphrase != "" {
+			return BadRequest(`snapshot "forget" operation cannot specify a passphrase`)
+		}
 		affected, ts, err = snapshotForget(st, action.SetID, action.Snaps)
+	case "push":
+		if len(action.Snaps) != 0 || len(action.Users) != 0 {
+			return BadRequest(`snapshot "push" operation cannot specify snaps or users`)
+		}
+		if action.Passphrase != "" {
+			return BadRequest(`snapshot "push" operation cannot specify a passphrase`)
+		}
+		affected, ts, err = snapshotPush(st, action.SetID)
 	default:
 		return BadRequest("unknown snapshot operation %q", action.Action)
 	}
@@ -154,10 +192,16 @@ func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response
 		// woo
 	case client.ErrSnapshotSetNotFound, client.ErrSnapshotSnapsNotFound:
 		return NotFound("%v", err)
+	case snapshotstate.ErrNoSink:
+		return BadRequest("%v", err)
 	default:
 		return InternalError("%v", err)
 	}
 
+	if action.Passphrase != "" {
+		snapshotProvidePassphrase(st, action.SetID, action.Passphrase)
+	}
+
 	chg := newChange(st, action.Action+"-snapshot", action.String(), []*state.TaskSet{ts}, affected)
 	chg.Set("api-data", map[string]interface{}{"snap-names": affected})
 	ensureStateSoon(st)
@@ -165,6 +209,31 @@ func changeSnapshots(c *Command, r *http.Request, user *auth.UserState) Response
 	return AsyncResponse(nil, chg.ID())
 }
 
+// getSnapshotContents lists the files and directories in the data of the
+// snapshots of a set, without restoring them.
+func getSnapshotContents(c *Command, r *http.Request, user *auth.UserState) Response {
+	vars := muxVars(r)
+	sid := vars["id"]
+	setID, err := strconv.ParseUint(sid, 10, 64)
+	if err != nil {
+		return BadRequest("'id' must be a positive base 10 number; got %q", sid)
+	}
+	query := r.URL.Query()
+	snaps := strutil.CommaSeparatedList(query.Get("snaps"))
+	users := strutil.CommaSeparatedList(query.Get("users"))
+
+	// reading the archives can be slow, so the state is not locked
+	files, err := snapshotContents(r.Context(), c.d.overlord.State(), setID, snaps, users)
+	switch err {
+	case nil:
+		return SyncResponse(files)
+	case client.ErrSnapshotSetNotFound, client.ErrSnapshotSnapsNotFound:
+		return NotFound("%v", err)
+	default:
+		return InternalError("%v", err)
+	}
+}
+
 // getSnapshotExport streams an archive containing an export of existing snapshots.
 //
 // The snapshots are re-packaged into a single uncompressed tar archive and
@@ -218,7 +287,15 @@ func doSnapshotImport(c *Command, r *http.Request, user *auth.UserState) Respons
 }
 
 func snapshotMany(_ context.Context, inst *snapInstruction, st *state.State) (*snapInstructionResult, error) {
-	setID, snapshotted, ts, err := snapshotSave(st, inst.Snaps, inst.Users, inst.SnapshotOptions)
+	var setID uint64
+	var snapshotted []string
+	var ts *state.TaskSet
+	var err error
+	if inst.SnapshotPassphrase != "" {
+		setID, snapshotted, ts, err = snapshotSaveWithPassphrase(st, inst.Snaps, inst.Users, inst.SnapshotOptions, inst.SnapshotPassphrase)
+	} else {
+		setID, snapshotted, ts, err = snapshotSave(st, inst.Snaps, inst.Users, inst.SnapshotOptions)
+	}
 	if err != nil {
 		return nil, err
 	}
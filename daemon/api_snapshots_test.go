@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 
@@ -36,6 +37,7 @@ import (
 	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
 )
 
 var _ = check.Suite(&snapshotSuite{})
@@ -99,6 +101,30 @@ func (s *snapshotSuite) TestSnapshotManyOptionsFull(c *check.C) {
 	c.Check(snapshotSaveCalled, check.Equals, 1)
 }
 
+func (s *snapshotSuite) TestSnapshotManyPassphrase(c *check.C) {
+	defer daemon.MockSnapshotSave(func(*state.State, []string, []string, map[string]*snap.SnapshotOptions) (uint64, []string, *state.TaskSet, error) {
+		c.Error("unexpected call to snapshotstate.Save")
+		return 0, nil, nil, nil
+	})()
+	defer daemon.MockSnapshotSaveWithPassphrase(func(s *state.State, snaps, users []string,
+		options map[string]*snap.SnapshotOptions, passphrase string) (uint64, []string, *state.TaskSet, error) {
+		c.Check(snaps, check.DeepEquals, []string{"foo"})
+		c.Check(passphrase, check.Equals, "sekrit")
+		t := s.NewTask("fake-snapshot", "Snapshot")
+		return 1, snaps, state.NewTaskSet(t), nil
+	})()
+
+	inst := daemon.MustUnmarshalSnapInstruction(c, `{"action": "snapshot", "snaps": ["foo"], "snapshot-passphrase": "sekrit"}`)
+
+	st := s.d.Overlord().State()
+	st.Lock()
+	res, err := inst.DispatchForMany()(context.Background(), inst, st)
+	st.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(res.Affected, check.DeepEquals, []string{"foo"})
+	c.Check(res.Result, check.DeepEquals, map[string]interface{}{"set-id": uint64(1)})
+}
+
 func (s *snapshotSuite) TestSnapshotManyError(c *check.C) {
 	defer daemon.MockSnapshotSave(func(s *state.State, snaps, users []string,
 		options map[string]*snap.SnapshotOptions) (uint64, []string, *state.TaskSet, error) {
@@ -236,6 +262,33 @@ func (s *snapshotSuite) TestChangeSnapshots400(c *check.C) {
 		}, {
 			body:  `{"set": 42, "action": "forget", "users": ["foo"]}`,
 			error: `snapshot "forget" operation cannot specify users`,
+		}, {
+			body:  `{"set": 42, "action": "forget", "passphrase": "sekrit"}`,
+			error: `snapshot "forget" operation cannot specify a passphrase`,
+		}, {
+			body:  `{"set": 42, "action": "push", "snaps": ["foo"]}`,
+			error: `snapshot "push" operation cannot specify snaps or users`,
+		}, {
+			body:  `{"set": 42, "action": "push", "users": ["foo"]}`,
+			error: `snapshot "push" operation cannot specify snaps or users`,
+		}, {
+			body:  `{"set": 42, "action": "push", "passphrase": "sekrit"}`,
+			error: `snapshot "push" operation cannot specify a passphrase`,
+		}, {
+			body:  `{"set": 42, "action": "check", "paths": ["common/*"]}`,
+			error: `snapshot "check" operation cannot specify paths or a target`,
+		}, {
+			body:  `{"set": 42, "action": "forget", "target": "/tmp/foo"}`,
+			error: `snapshot "forget" operation cannot specify paths or a target`,
+		}, {
+			body:  `{"set": 42, "action": "restore", "target": "/tmp/foo"}`,
+			error: `cannot restore files of snapshot: no paths given`,
+		}, {
+			body:  `{"set": 42, "action": "restore", "paths": ["../foo"]}`,
+			error: `invalid snapshot path pattern "../foo": must not refer to parent directories`,
+		}, {
+			body:  `{"set": 42, "action": "restore", "paths": ["common"], "target": "foo"}`,
+			error: `cannot restore files of snapshot into "foo": not an absolute path`,
 		},
 	}
 
@@ -265,8 +318,12 @@ func (s *snapshotSuite) TestChangeSnapshots404(c *check.C) {
 		done = "forget"
 		return nil, nil, expectedError
 	})()
+	defer daemon.MockSnapshotPush(func(*state.State, uint64) ([]string, *state.TaskSet, error) {
+		done = "push"
+		return nil, nil, expectedError
+	})()
 	for _, expectedError = range []error{client.ErrSnapshotSetNotFound, client.ErrSnapshotSnapsNotFound} {
-		for _, action := range []string{"check", "restore", "forget"} {
+		for _, action := range []string{"check", "restore", "forget", "push"} {
 			done = ""
 			comm := check.Commentf("%s/%s", action, expectedError)
 			body := fmt.Sprintf(`{"set": 42, "action": "%s"}`, action)
@@ -296,7 +353,11 @@ func (s *snapshotSuite) TestChangeSnapshots500(c *check.C) {
 		done = "forget"
 		return nil, nil, expectedError
 	})()
-	for _, action := range []string{"check", "restore", "forget"} {
+	defer daemon.MockSnapshotPush(func(*state.State, uint64) ([]string, *state.TaskSet, error) {
+		done = "push"
+		return nil, nil, expectedError
+	})()
+	for _, action := range []string{"check", "restore", "forget", "push"} {
 		comm := check.Commentf("%s", action)
 		body := fmt.Sprintf(`{"set": 42, "action": "%s"}`, action)
 		req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(body))
@@ -323,11 +384,15 @@ func (s *snapshotSuite) TestChangeSnapshot(c *check.C) {
 		done = "forget"
 		return []string{"foo"}, state.NewTaskSet(), nil
 	})()
+	defer daemon.MockSnapshotPush(func(*state.State, uint64) ([]string, *state.TaskSet, error) {
+		done = "push"
+		return []string{"foo"}, state.NewTaskSet(), nil
+	})()
 
 	st := s.d.Overlord().State()
 	st.Lock()
 	defer st.Unlock()
-	for _, action := range []string{"check", "restore", "forget"} {
+	for _, action := range []string{"check", "restore", "forget", "push"} {
 		comm := check.Commentf("%s", action)
 		body := fmt.Sprintf(`{"set": 42, "action": "%s"}`, action)
 		req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(body))
@@ -355,6 +420,130 @@ func (s *snapshotSuite) TestChangeSnapshot(c *check.C) {
 	}
 }
 
+func (s *snapshotSuite) TestChangeSnapshotPushNoSink(c *check.C) {
+	defer daemon.MockSnapshotPush(func(*state.State, uint64) ([]string, *state.TaskSet, error) {
+		return nil, nil, snapshotstate.ErrNoSink
+	})()
+
+	req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(`{"set": 42, "action": "push"}`))
+	c.Assert(err, check.IsNil)
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, check.Equals, 400)
+	c.Check(rspe.Message, check.Equals, `no snapshot sink configured (see snapshots.export.target)`)
+}
+
+func (s *snapshotSuite) TestChangeSnapshotPassphrase(c *check.C) {
+	defer daemon.MockSnapshotCheck(func(*state.State, uint64, []string, []string) ([]string, *state.TaskSet, error) {
+		return []string{"foo"}, state.NewTaskSet(), nil
+	})()
+	defer daemon.MockSnapshotRestore(func(*state.State, uint64, []string, []string) ([]string, *state.TaskSet, error) {
+		return []string{"foo"}, state.NewTaskSet(), nil
+	})()
+	var provided []string
+	defer daemon.MockSnapshotProvidePassphrase(func(_ *state.State, setID uint64, passphrase string) {
+		provided = append(provided, fmt.Sprintf("%d:%s", setID, passphrase))
+	})()
+
+	for _, action := range []string{"check", "restore"} {
+		comm := check.Commentf("%s", action)
+		body := fmt.Sprintf(`{"set": 42, "action": "%s", "passphrase": "sekrit"}`, action)
+		req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(body))
+		c.Assert(err, check.IsNil, comm)
+
+		rsp := s.asyncReq(c, req, nil)
+		c.Check(rsp.Status, check.Equals, 202, comm)
+
+		st := s.d.Overlord().State()
+		st.Lock()
+		chg := st.Change(rsp.Change)
+		c.Check(chg.Summary(), check.Not(testutil.Contains), "sekrit", comm)
+		st.Unlock()
+	}
+	c.Check(provided, check.DeepEquals, []string{"42:sekrit", "42:sekrit"})
+}
+
+func (s *snapshotSuite) TestChangeSnapshotRestoreFiles(c *check.C) {
+	defer daemon.MockSnapshotRestore(func(*state.State, uint64, []string, []string) ([]string, *state.TaskSet, error) {
+		c.Fatalf("unexpected full restore")
+		return nil, nil, nil
+	})()
+	defer daemon.MockSnapshotRestoreFiles(func(st *state.State, setID uint64, snaps, users, paths []string, target string) ([]string, *state.TaskSet, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(snaps, check.DeepEquals, []string{"foo"})
+		c.Check(users, check.DeepEquals, []string{"bar"})
+		c.Check(paths, check.DeepEquals, []string{"common/*.conf", "x1"})
+		c.Check(target, check.Equals, "/tmp/restored")
+		return []string{"foo"}, state.NewTaskSet(), nil
+	})()
+
+	body := `{"set": 42, "action": "restore", "snaps": ["foo"], "users": ["bar"], "paths": ["common/*.conf", "x1"], "target": "/tmp/restored"}`
+	req, err := http.NewRequest("POST", "/v2/snapshots", strings.NewReader(body))
+	c.Assert(err, check.IsNil)
+
+	rsp := s.asyncReq(c, req, nil)
+	c.Check(rsp.Status, check.Equals, 202)
+
+	st := s.d.Overlord().State()
+	st.Lock()
+	defer st.Unlock()
+	chg := st.Change(rsp.Change)
+	c.Check(chg.Summary(), check.Equals, `Restore of snapshot set #42 for snaps "foo" for users "bar"`)
+	var apiData map[string]interface{}
+	c.Check(chg.Get("api-data", &apiData), check.IsNil)
+	c.Check(apiData, check.DeepEquals, map[string]interface{}{
+		"snap-names": []interface{}{"foo"},
+	})
+}
+
+func (s *snapshotSuite) TestSnapshotContents(c *check.C) {
+	files := []client.SnapshotFile{
+		{Snap: "foo", Path: "common", Mode: os.ModeDir | 0755},
+		{Snap: "foo", User: "bar", Path: "x1/foo.conf", Mode: 0644, Size: 42},
+	}
+	defer daemon.MockSnapshotContents(func(ctx context.Context, st *state.State, setID uint64, snaps, users []string) ([]client.SnapshotFile, error) {
+		c.Check(setID, check.Equals, uint64(42))
+		c.Check(snaps, check.DeepEquals, []string{"foo"})
+		c.Check(users, check.DeepEquals, []string{"bar", "baz"})
+		return files, nil
+	})()
+
+	req, err := http.NewRequest("GET", "/v2/snapshots/42/contents?snaps=foo&users=bar,baz", nil)
+	c.Assert(err, check.IsNil)
+
+	rsp := s.syncReq(c, req, nil)
+	c.Check(rsp.Status, check.Equals, 200)
+	c.Check(rsp.Result, check.DeepEquals, files)
+}
+
+func (s *snapshotSuite) TestSnapshotContentsErrors(c *check.C) {
+	var contentsErr error
+	defer daemon.MockSnapshotContents(func(context.Context, *state.State, uint64, []string, []string) ([]client.SnapshotFile, error) {
+		return nil, contentsErr
+	})()
+
+	req, err := http.NewRequest("GET", "/v2/snapshots/xxx/contents", nil)
+	c.Assert(err, check.IsNil)
+	rspe := s.errorReq(c, req, nil)
+	c.Check(rspe.Status, check.Equals, 400)
+	c.Check(rspe.Message, check.Equals, `'id' must be a positive base 10 number; got "xxx"`)
+
+	for _, t := range []struct {
+		err    error
+		status int
+	}{
+		{client.ErrSnapshotSetNotFound, 404},
+		{client.ErrSnapshotSnapsNotFound, 404},
+		{errors.New("bzzt"), 500},
+	} {
+		contentsErr = t.err
+		req, err := http.NewRequest("GET", "/v2/snapshots/42/contents", nil)
+		c.Assert(err, check.IsNil)
+		rspe := s.errorReq(c, req, nil)
+		c.Check(rspe.Status, check.Equals, t.status, check.Commentf("%v", t.err))
+		c.Check(rspe.Message, check.Equals, t.err.Error())
+	}
+}
+
 func (s *snapshotSuite) TestExportSnapshots(c *check.C) {
 	var snapshotExportCalled int
 
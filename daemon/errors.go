@@ -27,6 +27,7 @@ import (
 
 	"github.com/snapcore/snapd/arch"
 	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/servicestate"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/snap"
@@ -238,6 +239,25 @@ func QuotaChangeConflict(qce *servicestate.QuotaChangeConflictError) *apiError {
 	}
 }
 
+// RegistryChangeConflict is an error responder used when an operation would
+// conflict with another ongoing change to the same registry.
+func RegistryChangeConflict(rce *registrystate.ChangeConflictError) *apiError {
+	value := map[string]interface{}{
+		"account":       rce.Account,
+		"registry-name": rce.RegistryName,
+	}
+	if rce.ChangeKind != "" {
+		value["change-kind"] = rce.ChangeKind
+	}
+
+	return &apiError{
+		Status:  409,
+		Message: rce.Error(),
+		Kind:    client.ErrorKindRegistryChangeConflict,
+		Value:   value,
+	}
+}
+
 // InsufficientSpace is an error responder used when an operation cannot
 // be performed due to low disk space.
 func InsufficientSpace(dserr *snapstate.InsufficientSpaceError) *apiError {
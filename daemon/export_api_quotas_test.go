@@ -61,3 +61,15 @@ func MockGetQuotaUsage(f func(grp *quota.Group) (*client.QuotaValues, error)) (r
 		getQuotaUsage = old
 	}
 }
+
+func GetQuotaUsage(grp *quota.Group) (*client.QuotaValues, error) {
+	return getQuotaUsage(grp)
+}
+
+func MockServicestateQuotaUsageHistory(f func(mgr *servicestate.ServiceManager, name string) map[string][]servicestate.QuotaUsageStats) (restore func()) {
+	old := servicestateQuotaUsageHistory
+	servicestateQuotaUsageHistory = f
+	return func() {
+		servicestateQuotaUsageHistory = old
+	}
+}
@@ -40,6 +40,22 @@ func MockSnapshotSave(newSave func(*state.State, []string, []string, map[string]
 	}
 }
 
+func MockSnapshotSaveWithPassphrase(newSave func(*state.State, []string, []string, map[string]*snap.SnapshotOptions, string) (uint64, []string, *state.TaskSet, error)) (restore func()) {
+	oldSave := snapshotSaveWithPassphrase
+	snapshotSaveWithPassphrase = newSave
+	return func() {
+		snapshotSaveWithPassphrase = oldSave
+	}
+}
+
+func MockSnapshotProvidePassphrase(newProvide func(*state.State, uint64, string)) (restore func()) {
+	oldProvide := snapshotProvidePassphrase
+	snapshotProvidePassphrase = newProvide
+	return func() {
+		snapshotProvidePassphrase = oldProvide
+	}
+}
+
 func MockSnapshotList(newList func(context.Context, *state.State, uint64, []string) ([]client.SnapshotSet, error)) (restore func()) {
 	oldList := snapshotList
 	snapshotList = newList
@@ -105,3 +121,27 @@ func MustUnmarshalSnapshotAction(c *check.C, jact string) *snapshotAction {
 }
 
 type SnapshotExportResponse = snapshotExportResponse
+
+func MockSnapshotPush(newPush func(*state.State, uint64) ([]string, *state.TaskSet, error)) (restore func()) {
+	oldPush := snapshotPush
+	snapshotPush = newPush
+	return func() {
+		snapshotPush = oldPush
+	}
+}
+
+func MockSnapshotRestoreFiles(newRestore func(*state.State, uint64, []string, []string, []string, string) ([]string, *state.TaskSet, error)) (restore func()) {
+	oldRestore := snapshotRestoreFiles
+	snapshotRestoreFiles = newRestore
+	return func() {
+		snapshotRestoreFiles = oldRestore
+	}
+}
+
+func MockSnapshotContents(newContents func(context.Context, *state.State, uint64, []string, []string) ([]client.SnapshotFile, error)) (restore func()) {
+	oldContents := snapshotContents
+	snapshotContents = newContents
+	return func() {
+		snapshotContents = oldContents
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/snapcore/snapd/client/clientutil"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
+	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/restart"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
@@ -387,7 +388,7 @@ func MockRegistrystateGetViaView(f func(_ *state.State, _, _, _ string, _ []stri
 	}
 }
 
-func MockRegistrystateSetViaView(f func(_ *state.State, _, _, _ string, _ map[string]interface{}) error) (restore func()) {
+func MockRegistrystateSetViaView(f func(_ *state.State, _, _, _ string, _ map[string]interface{}, _ registrystate.Author) (*state.TaskSet, error)) (restore func()) {
 	old := registrystateSetViaView
 	registrystateSetViaView = f
 	return func() {
@@ -395,6 +396,30 @@ func MockRegistrystateSetViaView(f func(_ *state.State, _, _, _ string, _ map[st
 	}
 }
 
+func MockRegistrystateLoadViaView(f func(_ *state.State, _, _, _ string, _ []string) (*state.TaskSet, error)) (restore func()) {
+	old := registrystateLoadViaView
+	registrystateLoadViaView = f
+	return func() {
+		registrystateLoadViaView = old
+	}
+}
+
+func MockRegistrystateHistory(f func(_ *state.State, _, _ string) ([]*registrystate.DatabagRevision, error)) (restore func()) {
+	old := registrystateHistory
+	registrystateHistory = f
+	return func() {
+		registrystateHistory = old
+	}
+}
+
+func MockRegistrystateRevert(f func(_ *state.State, _, _ string, _ int, _ registrystate.Author) (*state.TaskSet, error)) (restore func()) {
+	old := registrystateRevert
+	registrystateRevert = f
+	return func() {
+		registrystateRevert = old
+	}
+}
+
 func MockRebootNoticeWait(d time.Duration) (restore func()) {
 	restore = testutil.Backup(&rebootNoticeWait)
 	rebootNoticeWait = d
@@ -420,3 +445,11 @@ func MockNewStatusDecorator(f func(ctx context.Context, isGlobal bool, uid strin
 	newStatusDecorator = f
 	return restore
 }
+
+func MockHealthstateRefreshGroup(mock func(context.Context, *state.State, []string, time.Duration, int, *snapstate.Flags) ([]string, []*state.TaskSet, error)) (restore func()) {
+	old := healthstateRefreshGroup
+	healthstateRefreshGroup = mock
+	return func() {
+		healthstateRefreshGroup = old
+	}
+}
@@ -52,6 +52,8 @@ var (
 	SnapSeccompDir       string
 	SnapMountPolicyDir   string
 	SnapCgroupPolicyDir  string
+	SnapNetworkQuotaDir  string
+	SnapUserAppsQuotaDir string
 	SnapUdevRulesDir     string
 	SnapKModModulesDir   string
 	SnapKModModprobeDir  string
@@ -77,9 +79,10 @@ var (
 	SnapAssertsSpoolDir   string
 	SnapSeqDir            string
 
-	SnapStateFile     string
-	SnapStateLockFile string
-	SnapSystemKeyFile string
+	SnapStateFile        string
+	SnapStateJournalFile string
+	SnapStateLockFile    string
+	SnapSystemKeyFile    string
 
 	SnapRepairConfigFile string
 	SnapRepairDir        string
@@ -138,6 +141,8 @@ var (
 
 	SnapshotsDir string
 
+	SnapInterfacesRequestsStateDir string
+
 	SysfsDir string
 
 	FeaturesDir string
@@ -460,6 +465,8 @@ func SetRootDir(rootdir string) {
 	SnapSeccompDir = filepath.Join(SnapSeccompBase, "bpf")
 	SnapMountPolicyDir = filepath.Join(rootdir, snappyDir, "mount")
 	SnapCgroupPolicyDir = filepath.Join(rootdir, snappyDir, "cgroup")
+	SnapNetworkQuotaDir = filepath.Join(rootdir, snappyDir, "quota", "network")
+	SnapUserAppsQuotaDir = filepath.Join(rootdir, snappyDir, "quota", "user-apps")
 	SnapdMaintenanceFile = filepath.Join(rootdir, snappyDir, "maintenance.json")
 	SnapBlobDir = SnapBlobDirUnder(rootdir)
 	SnapVoidDir = filepath.Join(rootdir, snappyDir, "void")
@@ -487,6 +494,7 @@ func SetRootDir(rootdir string) {
 	SnapSeqDir = filepath.Join(rootdir, snappyDir, "sequence")
 
 	SnapStateFile = SnapStateFileUnder(rootdir)
+	SnapStateJournalFile = filepath.Join(rootdir, snappyDir, "state.journal")
 	SnapStateLockFile = SnapStateLockFileUnder(rootdir)
 	SnapSystemKeyFile = filepath.Join(rootdir, snappyDir, "system-key")
 
@@ -515,6 +523,8 @@ func SetRootDir(rootdir string) {
 
 	SnapRollbackDir = filepath.Join(rootdir, snappyDir, "rollback")
 
+	SnapInterfacesRequestsStateDir = filepath.Join(rootdir, snappyDir, "interfaces-requests")
+
 	SnapBinariesDir = filepath.Join(SnapMountDir, "bin")
 	SnapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
 	SnapRuntimeServicesDir = filepath.Join(rootdir, "/run/systemd/system")
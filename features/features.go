@@ -77,6 +77,8 @@ const (
 	Registries
 	// AppArmorPrompting enables AppArmor to prompt the user for permission when apps perform certain operations.
 	AppArmorPrompting
+	// IncrementalSnapshots enables saving snapshots with their data deduplicated against previous snapshots.
+	IncrementalSnapshots
 
 	// lastFeature is the final known feature, it is only used for testing.
 	lastFeature
@@ -122,6 +124,8 @@ var featureNames = map[SnapdFeature]string{
 	Registries:            "registries",
 
 	AppArmorPrompting: "apparmor-prompting",
+
+	IncrementalSnapshots: "incremental-snapshots",
 }
 
 // featuresEnabledWhenUnset contains a set of features that are enabled when not explicitly configured.
@@ -67,6 +67,7 @@ func (*featureSuite) TestName(c *C) {
 	check(features.RefreshAppAwarenessUX, "refresh-app-awareness-ux")
 	check(features.Registries, "registries")
 	check(features.AppArmorPrompting, "apparmor-prompting")
+	check(features.IncrementalSnapshots, "incremental-snapshots")
 
 	c.Check(tested, Equals, features.NumberOfFeatures())
 	c.Check(func() { _ = features.SnapdFeature(1000).String() }, PanicMatches, "unknown feature flag code 1000")
@@ -109,6 +110,7 @@ func (*featureSuite) TestIsExported(c *C) {
 	check(features.RefreshAppAwarenessUX, true)
 	check(features.Registries, true)
 	check(features.AppArmorPrompting, true)
+	check(features.IncrementalSnapshots, false)
 
 	c.Check(tested, Equals, features.NumberOfFeatures())
 }
@@ -212,11 +214,8 @@ func (*featureSuite) TestAppArmorPromptingSupportedCallback(c *C) {
 	restore := apparmor.MockFeatures(kernelFeatures, nil, parserFeatures, nil)
 	defer restore()
 	supported, reason := callback()
-	//c.Check(supported, Equals, true)
-	//c.Check(reason, Equals, "")
-	// TODO: change once prompting is fully supported
-	c.Check(supported, Equals, false)
-	c.Check(reason, Equals, "requires newer version of snapd")
+	c.Check(supported, Equals, true)
+	c.Check(reason, Equals, "")
 }
 
 func (*featureSuite) TestIsSupported(c *C) {
@@ -300,6 +299,7 @@ func (*featureSuite) TestIsEnabledWhenUnset(c *C) {
 	check(features.RefreshAppAwarenessUX, false)
 	check(features.Registries, false)
 	check(features.AppArmorPrompting, false)
+	check(features.IncrementalSnapshots, false)
 
 	c.Check(tested, Equals, features.NumberOfFeatures())
 }
@@ -39,8 +39,14 @@ type Metadata struct {
 
 type IDType uint64
 
+// String returns the ID as a 16-character hexadecimal string, which is the
+// format in which IDs are presented to clients and used as notice keys.
+func (i IDType) String() string {
+	return fmt.Sprintf("%016X", uint64(i))
+}
+
 func (i *IDType) MarshalJSON() ([]byte, error) {
-	return json.Marshal(fmt.Sprintf("%016X", *i))
+	return json.Marshal(i.String())
 }
 
 func (i *IDType) UnmarshalJSON(b []byte) error {
@@ -48,14 +54,23 @@ func (i *IDType) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &s); err != nil {
 		return fmt.Errorf("cannot read ID into string: %w", err)
 	}
-	value, err := strconv.ParseUint(s, 16, 64)
+	id, err := IDFromString(s)
 	if err != nil {
-		return fmt.Errorf("cannot parse ID as uint64: %w", err)
+		return err
 	}
-	*i = IDType(value)
+	*i = id
 	return nil
 }
 
+// IDFromString parses the given hexadecimal string into an ID.
+func IDFromString(s string) (IDType, error) {
+	value, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse ID as uint64: %w", err)
+	}
+	return IDType(value), nil
+}
+
 // OutcomeType describes the outcome associated with a reply or rule.
 type OutcomeType string
 
@@ -47,6 +47,7 @@ func (s *promptingSuite) TestIDTypeMarshalUnmarshalJSON(c *C) {
 		{0xDEADBEEFDEADBEEF, []byte(`"DEADBEEFDEADBEEF"`)},
 		{0xFFFFFFFFFFFFFFFF, []byte(`"FFFFFFFFFFFFFFFF"`)},
 	} {
+		c.Check(testCase.id.String(), Equals, string(testCase.marshalled[1:len(testCase.marshalled)-1]))
 		marshalled, err := testCase.id.MarshalJSON()
 		c.Check(err, IsNil)
 		c.Check(marshalled, DeepEquals, testCase.marshalled)
@@ -57,6 +58,19 @@ func (s *promptingSuite) TestIDTypeMarshalUnmarshalJSON(c *C) {
 	}
 }
 
+func (s *promptingSuite) TestIDFromString(c *C) {
+	id, err := prompting.IDFromString("0000000000001234")
+	c.Check(err, IsNil)
+	c.Check(id, Equals, prompting.IDType(0x1234))
+	id, err = prompting.IDFromString("deadbeef")
+	c.Check(err, IsNil)
+	c.Check(id, Equals, prompting.IDType(0xdeadbeef))
+	_, err = prompting.IDFromString("foo")
+	c.Check(err, ErrorMatches, `cannot parse ID as uint64: .*`)
+	_, err = prompting.IDFromString("")
+	c.Check(err, ErrorMatches, `cannot parse ID as uint64: .*`)
+}
+
 func (s *promptingSuite) TestOutcomeAsBool(c *C) {
 	result, err := prompting.OutcomeAllow.AsBool()
 	c.Check(err, IsNil)
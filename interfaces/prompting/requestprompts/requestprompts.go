@@ -20,6 +20,7 @@
 package requestprompts
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -55,6 +56,41 @@ type Prompt struct {
 	listenerReqs []*listener.Request
 }
 
+// promptJSON is the representation of a prompt which is exposed to clients.
+type promptJSON struct {
+	ID          prompting.IDType       `json:"id"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Snap        string                 `json:"snap"`
+	Interface   string                 `json:"interface"`
+	Constraints *promptConstraintsJSON `json:"constraints"`
+}
+
+// promptConstraintsJSON is the representation of prompt constraints which is
+// exposed to clients. Only the remaining unsatisfied permissions are included
+// as the requested permissions, since those are the ones to which the client
+// must reply.
+type promptConstraintsJSON struct {
+	Path                 string   `json:"path"`
+	RequestedPermissions []string `json:"requested-permissions"`
+	AvailablePermissions []string `json:"available-permissions"`
+}
+
+func (p *Prompt) MarshalJSON() ([]byte, error) {
+	constraints := &promptConstraintsJSON{
+		Path:                 p.Constraints.path,
+		RequestedPermissions: p.Constraints.remainingPermissions,
+		AvailablePermissions: p.Constraints.availablePermissions,
+	}
+	toMarshal := &promptJSON{
+		ID:          p.ID,
+		Timestamp:   p.Timestamp,
+		Snap:        p.Snap,
+		Interface:   p.Interface,
+		Constraints: constraints,
+	}
+	return json.Marshal(toMarshal)
+}
+
 // promptConstraints store the path which was requested, along with three
 // lists of permissions: the original permissions associated with the request,
 // the remaining unsatisfied permissions (as rules may satisfy some of the
@@ -80,6 +116,17 @@ type promptConstraints struct {
 	originalPermissions []string
 }
 
+// Path returns the path to which the application is requesting access.
+func (pc *promptConstraints) Path() string {
+	return pc.path
+}
+
+// RemainingPermissions returns the permissions which have not yet been
+// satisfied by any rule or reply.
+func (pc *promptConstraints) RemainingPermissions() []string {
+	return pc.remainingPermissions
+}
+
 // equals returns true if the two prompt constraints apply to the same path and
 // were created with the same originally requested permissions. That implies
 // that the request which triggered the creation of the two prompts were
@@ -274,9 +321,14 @@ func (pdb *PromptDB) nextID() (prompting.IDType, error) {
 // added, returns the new prompt and false, indicating the prompt was not
 // merged.
 //
+// The given requestedPermissions are all the permissions included in the
+// listener request, while outstandingPermissions are those which have not
+// already been satisfied by existing rules. The latter must be a subset of the
+// former, and the former is used when sending back a response to the kernel.
+//
 // The caller must ensure that the given permissions are in the order in which
 // they appear in the available permissions list for the given interface.
-func (pdb *PromptDB) AddOrMerge(metadata *prompting.Metadata, path string, permissions []string, listenerReq *listener.Request) (*Prompt, bool, error) {
+func (pdb *PromptDB) AddOrMerge(metadata *prompting.Metadata, path string, requestedPermissions []string, outstandingPermissions []string, listenerReq *listener.Request) (*Prompt, bool, error) {
 	availablePermissions, err := prompting.AvailablePermissions(metadata.Interface)
 	if err != nil {
 		// Error should be impossible, since caller has already validated that
@@ -302,9 +354,9 @@ func (pdb *PromptDB) AddOrMerge(metadata *prompting.Metadata, path string, permi
 
 	constraints := &promptConstraints{
 		path:                 path,
-		remainingPermissions: permissions,
+		remainingPermissions: outstandingPermissions,
 		availablePermissions: availablePermissions,
-		originalPermissions:  permissions,
+		originalPermissions:  requestedPermissions,
 	}
 
 	// Search for an identical existing prompt, merge if found
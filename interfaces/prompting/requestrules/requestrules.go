@@ -0,0 +1,639 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package requestrules implements a persistent store of request rules, which
+// are created when the user replies to a prompt with a lifespan other than
+// "single", and which are used to automatically reply to future requests.
+package requestrules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/patterns"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/strutil"
+)
+
+var (
+	ErrClosed              = errors.New("rule DB has already been closed")
+	ErrNotFound            = errors.New("cannot find rule with the given ID")
+	ErrUserNotAllowed      = errors.New("the given user is not allowed to access the rule with the given ID")
+	ErrNoMatchingRule      = errors.New("no rule matches the given path")
+	ErrPathPatternConflict = errors.New("a rule with conflicting path pattern and permission already exists in the rule DB")
+)
+
+// Rule stores the contents of a request rule.
+type Rule struct {
+	ID          prompting.IDType       `json:"id"`
+	Timestamp   time.Time              `json:"timestamp"`
+	User        uint32                 `json:"user"`
+	Snap        string                 `json:"snap"`
+	Interface   string                 `json:"interface"`
+	Constraints *prompting.Constraints `json:"constraints"`
+	Outcome     prompting.OutcomeType  `json:"outcome"`
+	Lifespan    prompting.LifespanType `json:"lifespan"`
+	Expiration  time.Time              `json:"expiration,omitempty"`
+}
+
+// validate checks that the rule is valid, and that its expiration, if any,
+// is after the given current time.
+func (rule *Rule) validate(currTime time.Time) error {
+	if err := rule.Constraints.ValidateForInterface(rule.Interface); err != nil {
+		return err
+	}
+	if _, err := rule.Outcome.AsBool(); err != nil {
+		return err
+	}
+	if rule.Lifespan == prompting.LifespanSingle {
+		return fmt.Errorf(`cannot create rule with lifespan %q`, rule.Lifespan)
+	}
+	if err := rule.Lifespan.ValidateExpiration(rule.Expiration, currTime); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Expired returns true if the rule has a lifespan of "timespan" and its
+// expiration is not after the given current time.
+func (rule *Rule) Expired(currTime time.Time) bool {
+	switch rule.Lifespan {
+	case prompting.LifespanTimespan:
+		return !currTime.Before(rule.Expiration)
+	}
+	return false
+}
+
+// conflictsWith returns true if the two rules apply to the same user, snap,
+// and interface, share at least one permission, and share at least one
+// expanded path pattern variant, so that a request could be matched by both
+// rules with equal precedence.
+func (rule *Rule) conflictsWith(other *Rule) bool {
+	if rule.User != other.User || rule.Snap != other.Snap || rule.Interface != other.Interface {
+		return false
+	}
+	sharesPermission := false
+	for _, perm := range rule.Constraints.Permissions {
+		if strutil.ListContains(other.Constraints.Permissions, perm) {
+			sharesPermission = true
+			break
+		}
+	}
+	if !sharesPermission {
+		return false
+	}
+	variants := make(map[string]bool, rule.Constraints.PathPattern.NumVariants())
+	rule.Constraints.PathPattern.RenderAllVariants(func(index int, variant patterns.PatternVariant) {
+		variants[variant.String()] = true
+	})
+	conflict := false
+	other.Constraints.PathPattern.RenderAllVariants(func(index int, variant patterns.PatternVariant) {
+		if variants[variant.String()] {
+			conflict = true
+		}
+	})
+	return conflict
+}
+
+// RuleDB stores request rules in memory and persists them to disk whenever
+// the set of rules changes.
+type RuleDB struct {
+	// The rule DB is protected by a RWMutex.
+	mutex sync.RWMutex
+	// maxID is the highest ID which has ever been assigned to a rule.
+	maxID prompting.IDType
+	// rules is the list of all rules in the DB.
+	// If rules is nil, then the rule DB has already been closed.
+	rules []*Rule
+	// indexByID maps from rule ID to the index of the rule in the rules list.
+	indexByID map[prompting.IDType]int
+	// notifyRule is a closure which will be called to record a notice when a
+	// rule is added, patched, removed, or expired.
+	notifyRule func(userID uint32, ruleID prompting.IDType, data map[string]string) error
+}
+
+// rulesDBJSON is the format in which the rule DB is persisted to disk.
+type rulesDBJSON struct {
+	MaxID prompting.IDType `json:"max-id"`
+	Rules []*Rule          `json:"rules"`
+}
+
+func dbPath() string {
+	return filepath.Join(dirs.SnapInterfacesRequestsStateDir, "request-rules.json")
+}
+
+// New creates a new rule DB, loading any existing rules from disk.
+//
+// The given notifyRule closure will be called when a rule is added, patched,
+// removed, or expired. In order to guarantee the order of notices, notifyRule
+// is called with the rule DB lock held, so it should not block for a
+// substantial amount of time (such as to lock and modify snapd state).
+func New(notifyRule func(userID uint32, ruleID prompting.IDType, data map[string]string) error) (*RuleDB, error) {
+	rdb := &RuleDB{
+		notifyRule: notifyRule,
+	}
+	if err := rdb.load(); err != nil {
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// notify records a notice for the given rule, logging any error, since the
+// change to the rule DB has already been made at this point.
+func (rdb *RuleDB) notify(userID uint32, ruleID prompting.IDType, data map[string]string) {
+	if err := rdb.notifyRule(userID, ruleID, data); err != nil {
+		logger.Noticef("cannot record notice for request rule %s: %v", ruleID, err)
+	}
+}
+
+// load reads the stored rules from disk, discarding any which are invalid or
+// expired, and populates the rule DB with the remaining ones.
+//
+// If the stored rules cannot be read or parsed, the rule DB is left empty,
+// and the stored rules are overwritten on the next save.
+func (rdb *RuleDB) load() error {
+	rdb.rules = make([]*Rule, 0)
+	rdb.indexByID = make(map[prompting.IDType]int)
+
+	f, err := os.Open(dbPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot open stored request rules: %w", err)
+	}
+	defer f.Close()
+
+	var wrapped rulesDBJSON
+	if err := json.NewDecoder(f).Decode(&wrapped); err != nil {
+		// Stored rules are malformed, best to start from scratch
+		logger.Noticef("cannot read stored request rules; ignoring them: %v", err)
+		return nil
+	}
+	rdb.maxID = wrapped.MaxID
+
+	currTime := time.Now()
+	expiredRules := false
+	for _, rule := range wrapped.Rules {
+		if rule.ID > rdb.maxID {
+			rdb.maxID = rule.ID
+		}
+		if rule.Expired(currTime) {
+			expiredRules = true
+			rdb.notify(rule.User, rule.ID, map[string]string{"removed": "expired"})
+			continue
+		}
+		if err := rule.validate(currTime); err != nil {
+			logger.Noticef("discarding invalid stored request rule %s: %v", rule.ID, err)
+			continue
+		}
+		if err := rdb.addRule(rule, currTime); err != nil {
+			logger.Noticef("discarding conflicting stored request rule %s: %v", rule.ID, err)
+			continue
+		}
+	}
+	if expiredRules {
+		return rdb.save()
+	}
+	return nil
+}
+
+// save writes the current rules to disk.
+//
+// The caller must ensure that the rule DB mutex is locked.
+func (rdb *RuleDB) save() error {
+	wrapped := &rulesDBJSON{
+		MaxID: rdb.maxID,
+		Rules: rdb.rules,
+	}
+	b, err := json.Marshal(wrapped)
+	if err != nil {
+		// Should not occur, marshalling should always succeed
+		return fmt.Errorf("cannot marshal request rules: %w", err)
+	}
+	if err := os.MkdirAll(dirs.SnapInterfacesRequestsStateDir, 0755); err != nil {
+		return fmt.Errorf("cannot create interfaces requests state directory: %w", err)
+	}
+	return osutil.AtomicWriteFile(dbPath(), b, 0600, 0)
+}
+
+// nextID increments the max ID and returns it.
+//
+// The caller must ensure that the rule DB mutex is locked.
+func (rdb *RuleDB) nextID() prompting.IDType {
+	rdb.maxID++
+	return rdb.maxID
+}
+
+// addRule adds the given rule to the rule DB, first removing any expired rules
+// which conflict with it. If any non-expired rule conflicts with the given
+// rule, returns ErrPathPatternConflict and leaves the rule DB unchanged.
+//
+// The caller must ensure that the rule DB mutex is locked.
+func (rdb *RuleDB) addRule(rule *Rule, currTime time.Time) error {
+	var expired []*Rule
+	for _, existing := range rdb.rules {
+		if !existing.conflictsWith(rule) {
+			continue
+		}
+		if !existing.Expired(currTime) {
+			return fmt.Errorf("%w: %s", ErrPathPatternConflict, existing.ID)
+		}
+		expired = append(expired, existing)
+	}
+	for _, existing := range expired {
+		rdb.removeRuleWithID(existing.ID)
+		rdb.notify(existing.User, existing.ID, map[string]string{"removed": "expired"})
+	}
+	rdb.rules = append(rdb.rules, rule)
+	rdb.indexByID[rule.ID] = len(rdb.rules) - 1
+	return nil
+}
+
+// removeRuleWithID removes the rule with the given ID from the rule DB and
+// returns it.
+//
+// The rule is removed by moving the final rule in the list to the index of
+// the removed rule, truncating the list by one, and updating the index of
+// the moved rule.
+//
+// The caller must ensure that the rule DB mutex is locked.
+func (rdb *RuleDB) removeRuleWithID(id prompting.IDType) (*Rule, error) {
+	index, ok := rdb.indexByID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rule := rdb.rules[index]
+	rdb.rules[index] = rdb.rules[len(rdb.rules)-1]
+	movedID := rdb.rules[index].ID
+	rdb.rules = rdb.rules[:len(rdb.rules)-1]
+	rdb.indexByID[movedID] = index
+	delete(rdb.indexByID, id)
+	return rule, nil
+}
+
+// removeExpired removes all expired rules from the rule DB and records a
+// notice for each one. Returns true if any rule was removed.
+//
+// The caller must ensure that the rule DB mutex is locked.
+func (rdb *RuleDB) removeExpired(currTime time.Time) bool {
+	var expired []*Rule
+	for _, rule := range rdb.rules {
+		if rule.Expired(currTime) {
+			expired = append(expired, rule)
+		}
+	}
+	for _, rule := range expired {
+		rdb.removeRuleWithID(rule.ID)
+		rdb.notify(rule.User, rule.ID, map[string]string{"removed": "expired"})
+	}
+	return len(expired) > 0
+}
+
+// AddRule creates a new rule with the given contents and adds it to the rule
+// DB, then saves the rule DB to disk. If the new rule conflicts with an
+// existing rule, or an error occurs while saving, returns an error and leaves
+// the rule DB unchanged.
+//
+// The given duration must be empty unless the given lifespan is "timespan".
+func (rdb *RuleDB) AddRule(user uint32, snap string, iface string, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*Rule, error) {
+	currTime := time.Now()
+	expiration, err := lifespan.ParseDuration(duration, currTime)
+	if err != nil {
+		return nil, err
+	}
+	rule := &Rule{
+		Timestamp:   currTime,
+		User:        user,
+		Snap:        snap,
+		Interface:   iface,
+		Constraints: constraints,
+		Outcome:     outcome,
+		Lifespan:    lifespan,
+		Expiration:  expiration,
+	}
+	if err := rule.validate(currTime); err != nil {
+		return nil, err
+	}
+
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+
+	if rdb.rules == nil {
+		return nil, ErrClosed
+	}
+
+	prevMaxID := rdb.maxID
+	rule.ID = rdb.nextID()
+	if err := rdb.addRule(rule, currTime); err != nil {
+		rdb.maxID = prevMaxID
+		return nil, err
+	}
+	if err := rdb.save(); err != nil {
+		rdb.removeRuleWithID(rule.ID)
+		rdb.maxID = prevMaxID
+		return nil, err
+	}
+	rdb.notify(user, rule.ID, nil)
+	return rule, nil
+}
+
+// IsPathAllowed checks whether the given path with the given permission is
+// allowed or denied by existing rules for the given user, snap, and interface.
+//
+// If more than one rule matches the path, the rule whose path pattern has the
+// highest precedence determines the outcome. If no rule matches, returns
+// ErrNoMatchingRule.
+func (rdb *RuleDB) IsPathAllowed(user uint32, snap string, iface string, path string, permission string) (bool, error) {
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+
+	if rdb.rules == nil {
+		return false, ErrClosed
+	}
+
+	currTime := time.Now()
+	if rdb.removeExpired(currTime) {
+		if err := rdb.save(); err != nil {
+			logger.Noticef("cannot save request rules after removing expired rules: %v", err)
+		}
+	}
+
+	var matchingVariants []patterns.PatternVariant
+	ruleForVariant := make(map[string]*Rule)
+	for _, rule := range rdb.rules {
+		if rule.User != user || rule.Snap != snap || rule.Interface != iface {
+			continue
+		}
+		if !strutil.ListContains(rule.Constraints.Permissions, permission) {
+			continue
+		}
+		var matchErr error
+		rule.Constraints.PathPattern.RenderAllVariants(func(index int, variant patterns.PatternVariant) {
+			if matchErr != nil {
+				return
+			}
+			matched, err := patterns.PathPatternMatches(variant.String(), path)
+			if err != nil {
+				matchErr = err
+				return
+			}
+			if matched {
+				matchingVariants = append(matchingVariants, variant)
+				ruleForVariant[variant.String()] = rule
+			}
+		})
+		if matchErr != nil {
+			return false, matchErr
+		}
+	}
+	if len(matchingVariants) == 0 {
+		return false, ErrNoMatchingRule
+	}
+	highestPrecedence, err := patterns.HighestPrecedencePattern(matchingVariants, path)
+	if err != nil {
+		return false, err
+	}
+	rule := ruleForVariant[highestPrecedence.String()]
+	return rule.Outcome.AsBool()
+}
+
+// ruleWithID returns the rule with the given ID, checking that it belongs to
+// the given user.
+//
+// The caller must ensure that the rule DB mutex is locked for reading.
+func (rdb *RuleDB) ruleWithID(user uint32, id prompting.IDType) (*Rule, error) {
+	if rdb.rules == nil {
+		return nil, ErrClosed
+	}
+	index, ok := rdb.indexByID[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rule := rdb.rules[index]
+	if rule.User != user {
+		return nil, ErrUserNotAllowed
+	}
+	return rule, nil
+}
+
+// RuleWithID returns the rule with the given ID, provided that it belongs to
+// the given user.
+func (rdb *RuleDB) RuleWithID(user uint32, id prompting.IDType) (*Rule, error) {
+	rdb.mutex.RLock()
+	defer rdb.mutex.RUnlock()
+	return rdb.ruleWithID(user, id)
+}
+
+// Rules returns all rules for the given user, sorted by ID.
+func (rdb *RuleDB) Rules(user uint32) ([]*Rule, error) {
+	return rdb.rulesMatching(func(rule *Rule) bool {
+		return rule.User == user
+	})
+}
+
+// RulesForSnap returns all rules for the given user and snap, sorted by ID.
+func (rdb *RuleDB) RulesForSnap(user uint32, snap string) ([]*Rule, error) {
+	return rdb.rulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Snap == snap
+	})
+}
+
+// RulesForInterface returns all rules for the given user and interface,
+// sorted by ID.
+func (rdb *RuleDB) RulesForInterface(user uint32, iface string) ([]*Rule, error) {
+	return rdb.rulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Interface == iface
+	})
+}
+
+// RulesForSnapInterface returns all rules for the given user, snap, and
+// interface, sorted by ID.
+func (rdb *RuleDB) RulesForSnapInterface(user uint32, snap string, iface string) ([]*Rule, error) {
+	return rdb.rulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Snap == snap && rule.Interface == iface
+	})
+}
+
+func (rdb *RuleDB) rulesMatching(filter func(rule *Rule) bool) ([]*Rule, error) {
+	rdb.mutex.RLock()
+	defer rdb.mutex.RUnlock()
+	if rdb.rules == nil {
+		return nil, ErrClosed
+	}
+	currTime := time.Now()
+	var matching []*Rule
+	for _, rule := range rdb.rules {
+		if rule.Expired(currTime) || !filter(rule) {
+			continue
+		}
+		matching = append(matching, rule)
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].ID < matching[j].ID
+	})
+	return matching, nil
+}
+
+// RemoveRule removes the rule with the given ID from the rule DB, provided
+// that it belongs to the given user, then saves the rule DB to disk.
+func (rdb *RuleDB) RemoveRule(user uint32, id prompting.IDType) (*Rule, error) {
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+	rule, err := rdb.ruleWithID(user, id)
+	if err != nil {
+		return nil, err
+	}
+	rdb.removeRuleWithID(id)
+	if err := rdb.save(); err != nil {
+		// Re-add the rule, which cannot conflict since it was just removed
+		rdb.addRule(rule, time.Now())
+		return nil, err
+	}
+	rdb.notify(user, id, map[string]string{"removed": "removed"})
+	return rule, nil
+}
+
+// RemoveRulesForSnap removes all rules for the given user and snap.
+func (rdb *RuleDB) RemoveRulesForSnap(user uint32, snap string) ([]*Rule, error) {
+	return rdb.removeRulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Snap == snap
+	})
+}
+
+// RemoveRulesForInterface removes all rules for the given user and interface.
+func (rdb *RuleDB) RemoveRulesForInterface(user uint32, iface string) ([]*Rule, error) {
+	return rdb.removeRulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Interface == iface
+	})
+}
+
+// RemoveRulesForSnapInterface removes all rules for the given user, snap, and
+// interface.
+func (rdb *RuleDB) RemoveRulesForSnapInterface(user uint32, snap string, iface string) ([]*Rule, error) {
+	return rdb.removeRulesMatching(func(rule *Rule) bool {
+		return rule.User == user && rule.Snap == snap && rule.Interface == iface
+	})
+}
+
+func (rdb *RuleDB) removeRulesMatching(filter func(rule *Rule) bool) ([]*Rule, error) {
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+	if rdb.rules == nil {
+		return nil, ErrClosed
+	}
+	var removed []*Rule
+	for _, rule := range rdb.rules {
+		if filter(rule) {
+			removed = append(removed, rule)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	for _, rule := range removed {
+		rdb.removeRuleWithID(rule.ID)
+	}
+	if err := rdb.save(); err != nil {
+		for _, rule := range removed {
+			rdb.addRule(rule, time.Now())
+		}
+		return nil, err
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].ID < removed[j].ID
+	})
+	for _, rule := range removed {
+		rdb.notify(rule.User, rule.ID, map[string]string{"removed": "removed"})
+	}
+	return removed, nil
+}
+
+// PatchRule modifies the rule with the given ID by replacing any of its
+// constraints, outcome, or lifespan and duration with the given values, if
+// they are set, then saves the rule DB to disk. If the lifespan is patched,
+// the expiration is recomputed from the given duration.
+//
+// If the patched rule is invalid or conflicts with another rule, returns an
+// error and leaves the rule DB unchanged.
+func (rdb *RuleDB) PatchRule(user uint32, id prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*Rule, error) {
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+	origRule, err := rdb.ruleWithID(user, id)
+	if err != nil {
+		return nil, err
+	}
+	currTime := time.Now()
+	newRule := *origRule
+	newRule.Timestamp = currTime
+	if constraints != nil {
+		newRule.Constraints = constraints
+	}
+	if outcome != prompting.OutcomeUnset {
+		newRule.Outcome = outcome
+	}
+	if lifespan != prompting.LifespanUnset {
+		expiration, err := lifespan.ParseDuration(duration, currTime)
+		if err != nil {
+			return nil, err
+		}
+		newRule.Lifespan = lifespan
+		newRule.Expiration = expiration
+	} else if duration != "" {
+		return nil, fmt.Errorf("cannot patch duration without also specifying lifespan")
+	}
+	if err := newRule.validate(currTime); err != nil {
+		return nil, err
+	}
+
+	rdb.removeRuleWithID(id)
+	if err := rdb.addRule(&newRule, currTime); err != nil {
+		rdb.addRule(origRule, currTime)
+		return nil, err
+	}
+	if err := rdb.save(); err != nil {
+		rdb.removeRuleWithID(id)
+		rdb.addRule(origRule, currTime)
+		return nil, err
+	}
+	rdb.notify(user, id, nil)
+	return &newRule, nil
+}
+
+// Close closes the rule DB, ensuring that all rules have been saved to disk.
+func (rdb *RuleDB) Close() error {
+	rdb.mutex.Lock()
+	defer rdb.mutex.Unlock()
+	if rdb.rules == nil {
+		return ErrClosed
+	}
+	err := rdb.save()
+	rdb.rules = nil
+	rdb.indexByID = nil
+	return err
+}
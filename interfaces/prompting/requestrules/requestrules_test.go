@@ -0,0 +1,385 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package requestrules_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/patterns"
+	"github.com/snapcore/snapd/interfaces/prompting/requestrules"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type noticeInfo struct {
+	userID uint32
+	ruleID prompting.IDType
+	data   map[string]string
+}
+
+type requestrulesSuite struct {
+	defaultUser uint32
+	ruleNotices []*noticeInfo
+}
+
+var _ = Suite(&requestrulesSuite{})
+
+func (s *requestrulesSuite) SetUpTest(c *C) {
+	s.defaultUser = 1000
+	s.ruleNotices = nil
+	dirs.SetRootDir(c.MkDir())
+}
+
+func (s *requestrulesSuite) TearDownTest(c *C) {
+	dirs.SetRootDir("")
+}
+
+func (s *requestrulesSuite) notifyRule(userID uint32, ruleID prompting.IDType, data map[string]string) error {
+	s.ruleNotices = append(s.ruleNotices, &noticeInfo{
+		userID: userID,
+		ruleID: ruleID,
+		data:   data,
+	})
+	return nil
+}
+
+func (s *requestrulesSuite) checkNewNotices(c *C, expected []*noticeInfo) {
+	c.Check(s.ruleNotices, DeepEquals, expected)
+	s.ruleNotices = nil
+}
+
+func mustParseConstraints(c *C, pattern string, permissions []string) *prompting.Constraints {
+	pathPattern, err := patterns.ParsePathPattern(pattern)
+	c.Assert(err, IsNil)
+	return &prompting.Constraints{
+		PathPattern: pathPattern,
+		Permissions: permissions,
+	}
+}
+
+func (s *requestrulesSuite) TestNewEmpty(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+	rules, err := rdb.Rules(s.defaultUser)
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 0)
+	s.checkNewNotices(c, nil)
+}
+
+func (s *requestrulesSuite) TestAddRulePersists(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+
+	constraints := mustParseConstraints(c, "/home/test/Documents/**", []string{"write", "read"})
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", constraints, prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	c.Check(rule.ID, Equals, prompting.IDType(1))
+	// permissions are reordered according to the interface
+	c.Check(rule.Constraints.Permissions, DeepEquals, []string{"read", "write"})
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, nil}})
+
+	c.Assert(rdb.Close(), IsNil)
+
+	data, err := os.ReadFile(filepath.Join(dirs.SnapInterfacesRequestsStateDir, "request-rules.json"))
+	c.Assert(err, IsNil)
+	var stored map[string]json.RawMessage
+	c.Assert(json.Unmarshal(data, &stored), IsNil)
+	c.Check(stored, HasLen, 2)
+
+	rdb, err = requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+	rules, err := rdb.Rules(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+	c.Check(rules[0].ID, Equals, rule.ID)
+	c.Check(rules[0].Snap, Equals, "firefox")
+	c.Check(rules[0].Constraints, DeepEquals, rule.Constraints)
+	c.Check(rules[0].Outcome, Equals, prompting.OutcomeAllow)
+
+	// IDs continue from the stored max ID
+	other, err := rdb.AddRule(s.defaultUser, "thunderbird", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	c.Check(other.ID, Equals, prompting.IDType(2))
+}
+
+func (s *requestrulesSuite) TestNewMalformedIgnored(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapInterfacesRequestsStateDir, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dirs.SnapInterfacesRequestsStateDir, "request-rules.json"), []byte("not json"), 0600), IsNil)
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+	rules, err := rdb.Rules(s.defaultUser)
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 0)
+}
+
+func (s *requestrulesSuite) TestNotifyRuleErrorLogged(c *C) {
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	rdb, err := requestrules.New(func(userID uint32, ruleID prompting.IDType, data map[string]string) error {
+		return fmt.Errorf("boom")
+	})
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	// the rule is still added
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	rules, err := rdb.Rules(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Check(rules, HasLen, 1)
+	c.Check(logbuf.String(), testutil.Contains, fmt.Sprintf("cannot record notice for request rule %s: boom", rule.ID))
+}
+
+func (s *requestrulesSuite) TestAddRuleErrors(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	for _, testCase := range []struct {
+		iface    string
+		perms    []string
+		outcome  prompting.OutcomeType
+		lifespan prompting.LifespanType
+		duration string
+		errStr   string
+	}{
+		{"foo", []string{"read"}, prompting.OutcomeAllow, prompting.LifespanForever, "", "invalid constraints: unsupported interface: foo"},
+		{"home", []string{"create"}, prompting.OutcomeAllow, prompting.LifespanForever, "", `invalid constraints: unsupported permission for home interface: "create"`},
+		{"home", []string{"read"}, prompting.OutcomeUnset, prompting.LifespanForever, "", `internal error: invalid outcome: ""`},
+		{"home", []string{"read"}, prompting.OutcomeAllow, prompting.LifespanSingle, "", `cannot create rule with lifespan "single"`},
+		{"home", []string{"read"}, prompting.OutcomeAllow, prompting.LifespanForever, "10s", `cannot have specified duration when lifespan is "forever": "10s"`},
+		{"home", []string{"read"}, prompting.OutcomeAllow, prompting.LifespanTimespan, "", `cannot have unspecified duration when lifespan is "timespan"`},
+	} {
+		constraints := mustParseConstraints(c, "/home/test/**", testCase.perms)
+		_, err := rdb.AddRule(s.defaultUser, "firefox", testCase.iface, constraints, testCase.outcome, testCase.lifespan, testCase.duration)
+		c.Check(err, ErrorMatches, testCase.errStr)
+	}
+	s.checkNewNotices(c, nil)
+}
+
+func (s *requestrulesSuite) TestAddRuleConflict(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/{foo,bar}", []string{"read", "write"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, nil}})
+
+	_, err = rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/{bar,baz}", []string{"write"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Check(err, ErrorMatches, "a rule with conflicting path pattern and permission already exists in the rule DB: 0000000000000001")
+	c.Check(errors.Is(err, requestrules.ErrPathPatternConflict), Equals, true)
+	s.checkNewNotices(c, nil)
+
+	// Different permission, snap, or user does not conflict
+	_, err = rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/bar", []string{"execute"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Check(err, IsNil)
+	_, err = rdb.AddRule(s.defaultUser, "thunderbird", "home", mustParseConstraints(c, "/home/test/bar", []string{"write"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Check(err, IsNil)
+	_, err = rdb.AddRule(s.defaultUser+1, "firefox", "home", mustParseConstraints(c, "/home/test/bar", []string{"write"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Check(err, IsNil)
+}
+
+func (s *requestrulesSuite) TestIsPathAllowedPrecedence(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	_, err = rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	_, err = rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/.ssh/**", []string{"read"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+
+	allowed, err := rdb.IsPathAllowed(s.defaultUser, "firefox", "home", "/home/test/Documents/foo.txt", "read")
+	c.Check(err, IsNil)
+	c.Check(allowed, Equals, true)
+
+	allowed, err = rdb.IsPathAllowed(s.defaultUser, "firefox", "home", "/home/test/.ssh/id_rsa", "read")
+	c.Check(err, IsNil)
+	c.Check(allowed, Equals, false)
+
+	_, err = rdb.IsPathAllowed(s.defaultUser, "firefox", "home", "/home/test/Documents/foo.txt", "write")
+	c.Check(err, Equals, requestrules.ErrNoMatchingRule)
+
+	_, err = rdb.IsPathAllowed(s.defaultUser, "thunderbird", "home", "/home/test/Documents/foo.txt", "read")
+	c.Check(err, Equals, requestrules.ErrNoMatchingRule)
+}
+
+func (s *requestrulesSuite) TestIsPathAllowedExpired(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanTimespan, "1ms")
+	c.Assert(err, IsNil)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, nil}})
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = rdb.IsPathAllowed(s.defaultUser, "firefox", "home", "/home/test/foo", "read")
+	c.Check(err, Equals, requestrules.ErrNoMatchingRule)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, map[string]string{"removed": "expired"}}})
+
+	_, err = rdb.RuleWithID(s.defaultUser, rule.ID)
+	c.Check(err, Equals, requestrules.ErrNotFound)
+}
+
+func (s *requestrulesSuite) TestRuleWithIDAndRemove(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, nil}})
+
+	_, err = rdb.RuleWithID(s.defaultUser+1, rule.ID)
+	c.Check(err, Equals, requestrules.ErrUserNotAllowed)
+	_, err = rdb.RuleWithID(s.defaultUser, rule.ID+1)
+	c.Check(err, Equals, requestrules.ErrNotFound)
+	found, err := rdb.RuleWithID(s.defaultUser, rule.ID)
+	c.Check(err, IsNil)
+	c.Check(found, Equals, rule)
+
+	_, err = rdb.RemoveRule(s.defaultUser+1, rule.ID)
+	c.Check(err, Equals, requestrules.ErrUserNotAllowed)
+	removed, err := rdb.RemoveRule(s.defaultUser, rule.ID)
+	c.Check(err, IsNil)
+	c.Check(removed, Equals, rule)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, map[string]string{"removed": "removed"}}})
+
+	_, err = rdb.RuleWithID(s.defaultUser, rule.ID)
+	c.Check(err, Equals, requestrules.ErrNotFound)
+}
+
+func (s *requestrulesSuite) TestRulesFilteringAndRemoveMany(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	addRule := func(user uint32, snap, pattern string) *requestrules.Rule {
+		rule, err := rdb.AddRule(user, snap, "home", mustParseConstraints(c, pattern, []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+		c.Assert(err, IsNil)
+		return rule
+	}
+	r1 := addRule(s.defaultUser, "firefox", "/home/test/a")
+	r2 := addRule(s.defaultUser, "thunderbird", "/home/test/b")
+	r3 := addRule(s.defaultUser, "firefox", "/home/test/c")
+	r4 := addRule(s.defaultUser+1, "firefox", "/home/test/d")
+	s.ruleNotices = nil
+
+	rules, err := rdb.Rules(s.defaultUser)
+	c.Check(err, IsNil)
+	c.Check(rules, DeepEquals, []*requestrules.Rule{r1, r2, r3})
+
+	rules, err = rdb.RulesForSnap(s.defaultUser, "firefox")
+	c.Check(err, IsNil)
+	c.Check(rules, DeepEquals, []*requestrules.Rule{r1, r3})
+
+	rules, err = rdb.RulesForInterface(s.defaultUser+1, "home")
+	c.Check(err, IsNil)
+	c.Check(rules, DeepEquals, []*requestrules.Rule{r4})
+
+	rules, err = rdb.RulesForSnapInterface(s.defaultUser, "thunderbird", "home")
+	c.Check(err, IsNil)
+	c.Check(rules, DeepEquals, []*requestrules.Rule{r2})
+
+	removed, err := rdb.RemoveRulesForSnap(s.defaultUser, "firefox")
+	c.Check(err, IsNil)
+	c.Check(removed, DeepEquals, []*requestrules.Rule{r1, r3})
+	s.checkNewNotices(c, []*noticeInfo{
+		{s.defaultUser, r1.ID, map[string]string{"removed": "removed"}},
+		{s.defaultUser, r3.ID, map[string]string{"removed": "removed"}},
+	})
+
+	rules, err = rdb.RulesForSnap(s.defaultUser+1, "firefox")
+	c.Check(err, IsNil)
+	c.Check(rules, DeepEquals, []*requestrules.Rule{r4})
+}
+
+func (s *requestrulesSuite) TestPatchRule(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	defer rdb.Close()
+
+	rule, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	other, err := rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/foo", []string{"write"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	s.ruleNotices = nil
+
+	patched, err := rdb.PatchRule(s.defaultUser, rule.ID, nil, prompting.OutcomeDeny, prompting.LifespanTimespan, "10m")
+	c.Assert(err, IsNil)
+	c.Check(patched.ID, Equals, rule.ID)
+	c.Check(patched.Outcome, Equals, prompting.OutcomeDeny)
+	c.Check(patched.Lifespan, Equals, prompting.LifespanTimespan)
+	c.Check(patched.Expiration.After(time.Now()), Equals, true)
+	c.Check(patched.Constraints, DeepEquals, rule.Constraints)
+	s.checkNewNotices(c, []*noticeInfo{{s.defaultUser, rule.ID, nil}})
+
+	// Patching to conflict with another rule fails and leaves DB unchanged
+	_, err = rdb.PatchRule(s.defaultUser, rule.ID, mustParseConstraints(c, "/home/test/foo", []string{"write"}), prompting.OutcomeUnset, prompting.LifespanUnset, "")
+	c.Check(err, ErrorMatches, "a rule with conflicting path pattern and permission already exists in the rule DB: .*")
+	s.checkNewNotices(c, nil)
+	found, err := rdb.RuleWithID(s.defaultUser, rule.ID)
+	c.Check(err, IsNil)
+	c.Check(found, DeepEquals, patched)
+	found, err = rdb.RuleWithID(s.defaultUser, other.ID)
+	c.Check(err, IsNil)
+	c.Check(found, Equals, other)
+
+	_, err = rdb.PatchRule(s.defaultUser, rule.ID, nil, prompting.OutcomeUnset, prompting.LifespanUnset, "10m")
+	c.Check(err, ErrorMatches, "cannot patch duration without also specifying lifespan")
+
+	_, err = rdb.PatchRule(s.defaultUser+1, rule.ID, nil, prompting.OutcomeAllow, prompting.LifespanUnset, "")
+	c.Check(err, Equals, requestrules.ErrUserNotAllowed)
+}
+
+func (s *requestrulesSuite) TestClose(c *C) {
+	rdb, err := requestrules.New(s.notifyRule)
+	c.Assert(err, IsNil)
+	c.Check(rdb.Close(), IsNil)
+	c.Check(rdb.Close(), Equals, requestrules.ErrClosed)
+
+	_, err = rdb.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Check(err, Equals, requestrules.ErrClosed)
+	_, err = rdb.IsPathAllowed(s.defaultUser, "firefox", "home", "/home/test/foo", "read")
+	c.Check(err, Equals, requestrules.ErrClosed)
+	_, err = rdb.Rules(s.defaultUser)
+	c.Check(err, Equals, requestrules.ErrClosed)
+	_, err = rdb.RemoveRule(s.defaultUser, 1)
+	c.Check(err, Equals, requestrules.ErrClosed)
+}
@@ -42,8 +42,8 @@ type AssertManager struct{}
 func Manager(s *state.State, runner *state.TaskRunner) (*AssertManager, error) {
 	delayedCrossMgrInit()
 
-	runner.AddHandler("validate-snap", doValidateSnap, nil)
-	runner.AddHandler("validate-component", doValidateComponent, nil)
+	runner.AddHandler("validate-snap", doValidateSnap, nil, state.WithRetryPolicy(snapstate.StoreRetryPolicy))
+	runner.AddHandler("validate-component", doValidateComponent, nil, state.WithRetryPolicy(snapstate.StoreRetryPolicy))
 
 	db, err := sysdb.Open()
 	if err != nil {
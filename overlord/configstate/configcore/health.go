@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/naming"
+)
+
+const (
+	healthRestartOnErrorOpt = "resilience.health.restart-on-error"
+	healthRevertOnErrorOpt  = "resilience.health.revert-on-error"
+	healthCheckIntervalOpt  = "resilience.health.check-interval"
+	healthCheckTimeoutOpt   = "resilience.health.check-timeout"
+)
+
+func init() {
+	// add supported configuration of this module
+	supportedConfigurations["core."+healthRestartOnErrorOpt] = true
+	supportedConfigurations["core."+healthRevertOnErrorOpt] = true
+	supportedConfigurations["core."+healthCheckIntervalOpt] = true
+	supportedConfigurations["core."+healthCheckTimeoutOpt] = true
+}
+
+// validateHealthSettings validates the health policies, which are
+// comma separated lists of snap names, for the revert policy each
+// optionally followed by ":<count>" of consecutive error reports, and
+// the settings of the periodic health checks.
+func validateHealthSettings(tr RunTransaction) error {
+	restart, err := coreCfg(tr, healthRestartOnErrorOpt)
+	if err != nil {
+		return err
+	}
+	if restart != "" {
+		for _, instanceName := range strings.Split(restart, ",") {
+			if err := naming.ValidateInstance(instanceName); err != nil {
+				return fmt.Errorf("cannot set %q: %v", healthRestartOnErrorOpt, err)
+			}
+		}
+	}
+
+	revert, err := coreCfg(tr, healthRevertOnErrorOpt)
+	if err != nil {
+		return err
+	}
+	if revert != "" {
+		for _, entry := range strings.Split(revert, ",") {
+			instanceName, count, hasCount := strings.Cut(entry, ":")
+			if err := naming.ValidateInstance(instanceName); err != nil {
+				return fmt.Errorf("cannot set %q: %v", healthRevertOnErrorOpt, err)
+			}
+			if !hasCount {
+				continue
+			}
+			if n, err := strconv.Atoi(count); err != nil || n < 1 {
+				return fmt.Errorf("cannot set %q: invalid error count %q for snap %q", healthRevertOnErrorOpt, count, instanceName)
+			}
+		}
+	}
+
+	interval, err := coreCfg(tr, healthCheckIntervalOpt)
+	if err != nil {
+		return err
+	}
+	if interval != "" {
+		for _, entry := range strings.Split(interval, ",") {
+			// entries without a snap name apply to all snaps
+			value := entry
+			if instanceName, v, hasName := strings.Cut(entry, ":"); hasName {
+				if err := naming.ValidateInstance(instanceName); err != nil {
+					return fmt.Errorf("cannot set %q: %v", healthCheckIntervalOpt, err)
+				}
+				value = v
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < snap.MinHealthCheckInterval {
+				return fmt.Errorf("cannot set %q: interval must be a duration of at least %v, not %q", healthCheckIntervalOpt, snap.MinHealthCheckInterval, value)
+			}
+		}
+	}
+
+	timeout, err := coreCfg(tr, healthCheckTimeoutOpt)
+	if err != nil {
+		return err
+	}
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return fmt.Errorf("cannot set %q: timeout must be a positive duration, not %q", healthCheckTimeoutOpt, timeout)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type healthSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&healthSuite{})
+
+func (s *healthSuite) TestConfigureHealthPolicies(c *C) {
+	for _, conf := range []map[string]interface{}{
+		{"resilience.health.restart-on-error": "foo"},
+		{"resilience.health.restart-on-error": "foo,bar_instance"},
+		{"resilience.health.revert-on-error": "foo"},
+		{"resilience.health.revert-on-error": "foo:5,bar"},
+		{"resilience.health.restart-on-error": "", "resilience.health.revert-on-error": ""},
+		{"resilience.health.check-interval": "1h"},
+		{"resilience.health.check-interval": "30m,foo:5m,bar_instance:2h"},
+		{"resilience.health.check-timeout": "2m"},
+	} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf:  conf,
+		})
+		c.Check(err, IsNil, Commentf("%v", conf))
+	}
+}
+
+func (s *healthSuite) TestConfigureHealthPoliciesInvalid(c *C) {
+	for _, tc := range []struct {
+		conf map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"resilience.health.restart-on-error": "foo,-bar"}, `cannot set "resilience.health.restart-on-error": invalid snap name: "-bar"`},
+		{map[string]interface{}{"resilience.health.restart-on-error": "foo:3"}, `cannot set "resilience.health.restart-on-error": invalid snap name: "foo:3"`},
+		{map[string]interface{}{"resilience.health.revert-on-error": "foo:"}, `cannot set "resilience.health.revert-on-error": invalid error count "" for snap "foo"`},
+		{map[string]interface{}{"resilience.health.revert-on-error": "foo:0"}, `cannot set "resilience.health.revert-on-error": invalid error count "0" for snap "foo"`},
+		{map[string]interface{}{"resilience.health.revert-on-error": "foo:x"}, `cannot set "resilience.health.revert-on-error": invalid error count "x" for snap "foo"`},
+		{map[string]interface{}{"resilience.health.revert-on-error": ":3"}, `cannot set "resilience.health.revert-on-error": invalid snap name: ""`},
+		{map[string]interface{}{"resilience.health.check-interval": "foo"}, `cannot set "resilience.health.check-interval": interval must be a duration of at least 1m0s, not "foo"`},
+		{map[string]interface{}{"resilience.health.check-interval": "30s"}, `cannot set "resilience.health.check-interval": interval must be a duration of at least 1m0s, not "30s"`},
+		{map[string]interface{}{"resilience.health.check-interval": "foo:x"}, `cannot set "resilience.health.check-interval": interval must be a duration of at least 1m0s, not "x"`},
+		{map[string]interface{}{"resilience.health.check-interval": "-foo:5m"}, `cannot set "resilience.health.check-interval": invalid snap name: "-foo"`},
+		{map[string]interface{}{"resilience.health.check-timeout": "0s"}, `cannot set "resilience.health.check-timeout": timeout must be a positive duration, not "0s"`},
+		{map[string]interface{}{"resilience.health.check-timeout": "soon"}, `cannot set "resilience.health.check-timeout": timeout must be a positive duration, not "soon"`},
+	} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf:  tc.conf,
+		})
+		c.Check(err, ErrorMatches, tc.err, Commentf("%v", tc.conf))
+	}
+}
@@ -29,7 +29,9 @@ import (
 var restartRequest = restart.Request
 
 // Trigger a security profile regeneration by restarting snapd if the
-// experimental apparmor-prompting flag changed.
+// experimental apparmor-prompting flag changed. The restart also starts or
+// stops the interfaces requests manager, and with it the apparmor notify
+// listener, according to the new value of the flag.
 func doExperimentalApparmorPromptingDaemonRestart(c RunTransaction, opts *fsOnlyContext) error {
 	st := c.State()
 
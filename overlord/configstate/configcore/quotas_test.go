@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package configcore_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/configcore"
+)
+
+type quotasSuite struct {
+	configcoreSuite
+}
+
+var _ = Suite(&quotasSuite{})
+
+func (s *quotasSuite) TestConfigureUsageThreshold(c *C) {
+	for _, value := range []interface{}{"1", "80", 100, ""} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"quota-groups.usage-threshold": value,
+			},
+		})
+		c.Check(err, IsNil, Commentf("%v", value))
+	}
+}
+
+func (s *quotasSuite) TestConfigureUsageThresholdInvalid(c *C) {
+	for _, value := range []string{"0", "101", "-5", "80%"} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"quota-groups.usage-threshold": value,
+			},
+		})
+		c.Check(err, ErrorMatches, `quota-groups.usage-threshold must be a percentage between 1 and 100, not ".*"`, Commentf(value))
+	}
+}
@@ -23,9 +23,11 @@ package configcore
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/snapcore/snapd/overlord/devicestate"
+	"github.com/snapcore/snapd/snap/naming"
 	"github.com/snapcore/snapd/strutil"
 	"github.com/snapcore/snapd/timeutil"
 )
@@ -43,6 +45,30 @@ func init() {
 	supportedConfigurations["core.refresh.retain"] = true
 	supportedConfigurations["core.refresh.rate-limit"] = true
 	supportedConfigurations["core.refresh.max-inhibition-days"] = true
+	supportedConfigurations["core.refresh.maintenance-window"] = true
+	supportedConfigurations["core.refresh.blackout"] = true
+	// per-snap settings are under refresh.snaps.<snap>., see
+	// validRefreshSnapsOption
+	supportedConfigurations["core.refresh.snaps"] = true
+}
+
+const refreshSnapsPrefix = "core.refresh.snaps."
+
+// validRefreshSnapsOption returns whether the given option is a valid
+// per-snap refresh option, refresh.snaps.<snap>[.<setting>].
+func validRefreshSnapsOption(name string) bool {
+	instanceName, setting, hasSetting := strings.Cut(strings.TrimPrefix(name, refreshSnapsPrefix), ".")
+	if naming.ValidateInstance(instanceName) != nil {
+		return false
+	}
+	if !hasSetting {
+		return true
+	}
+	switch setting {
+	case "maintenance-window", "blackout":
+		return true
+	}
+	return false
 }
 
 func reportOrIgnoreInvalidManageRefreshes(tr RunTransaction, optName string) error {
@@ -149,6 +175,71 @@ func validateRefreshSchedule(tr RunTransaction) error {
 	return err
 }
 
+func validateRefreshWindow(window, blackout string) error {
+	if window != "" {
+		// "managed" is only for refresh.timer
+		schedule, err := timeutil.ParseSchedule(window)
+		if err != nil {
+			return err
+		}
+		// a single time would only allow refreshes for a minute
+		for _, sched := range schedule {
+			for _, span := range sched.ClockSpans {
+				if span.Start == span.End {
+					return fmt.Errorf("cannot use %q in %q: maintenance windows need a time span", span, window)
+				}
+			}
+		}
+	}
+	if blackout != "" {
+		if _, err := timeutil.ParseBlackouts(blackout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRefreshWindows validates the maintenance windows, in the
+// refresh.timer format, and the blackout dates, of the system and of the
+// snaps.
+func validateRefreshWindows(tr RunTransaction) error {
+	window, err := coreCfg(tr, "refresh.maintenance-window")
+	if err != nil {
+		return err
+	}
+	blackout, err := coreCfg(tr, "refresh.blackout")
+	if err != nil {
+		return err
+	}
+	if err := validateRefreshWindow(window, blackout); err != nil {
+		return fmt.Errorf("cannot set refresh window: %v", err)
+	}
+
+	for _, name := range tr.Changes() {
+		if !strings.HasPrefix(name, refreshSnapsPrefix) {
+			continue
+		}
+		instanceName, setting, hasSetting := strings.Cut(strings.TrimPrefix(name, refreshSnapsPrefix), ".")
+		if !hasSetting {
+			// unsetting all the settings of the snap
+			continue
+		}
+		value, err := coreCfg(tr, strings.TrimPrefix(name, "core."))
+		if err != nil {
+			return err
+		}
+		if setting == "maintenance-window" {
+			err = validateRefreshWindow(value, "")
+		} else {
+			err = validateRefreshWindow("", value)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot set refresh window of snap %q: %v", instanceName, err)
+		}
+	}
+	return nil
+}
+
 func validateRefreshRateLimit(tr RunTransaction) error {
 	refreshRateLimit, err := coreCfg(tr, "refresh.rate-limit")
 	if err != nil {
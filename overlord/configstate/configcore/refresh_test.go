@@ -252,3 +252,42 @@ func (s *refreshSuite) TestConfigureRefreshMaxInhibitionDays(c *C) {
 		}
 	}
 }
+
+func (s *refreshSuite) TestConfigureRefreshWindowsHappy(c *C) {
+	err := configcore.Run(classicDev, &mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"refresh.maintenance-window":                "mon-fri,18:00-23:00,,sat-sun",
+			"refresh.blackout":                          "12-20..12-31,2026-04-03",
+			"refresh.snaps.critical.maintenance-window": "sat,02:00-04:00",
+			"refresh.snaps.critical.blackout":           "2026-06-01..2026-06-07",
+			"refresh.snaps.other_instance":              nil,
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *refreshSuite) TestConfigureRefreshWindowsRejected(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"refresh.maintenance-window", "invalid", `cannot set refresh window: cannot parse "invalid": "invalid" is not a valid weekday`},
+		{"refresh.maintenance-window", "managed", `cannot set refresh window: cannot parse "managed": .*`},
+		{"refresh.maintenance-window", "mon-fri,10:00", `cannot set refresh window: cannot use "10:00" in "mon-fri,10:00": maintenance windows need a time span`},
+		{"refresh.maintenance-window", "sat,02:00-04:00,,sun,23:00", `cannot set refresh window: cannot use "23:00" in "sat,02:00-04:00,,sun,23:00": maintenance windows need a time span`},
+		{"refresh.snaps.foo.maintenance-window", "10:00-10:00", `cannot set refresh window of snap "foo": cannot use "10:00" in "10:00-10:00": maintenance windows need a time span`},
+		{"refresh.blackout", "12-32", `cannot set refresh window: cannot parse "12-32": not a valid date`},
+		{"refresh.snaps.foo.maintenance-window", "25:00-26:00", `cannot set refresh window of snap "foo": cannot parse "25:00-26:00": .*`},
+		{"refresh.snaps.foo.blackout", "2026-12-31..2026-12-01", `cannot set refresh window of snap "foo": cannot parse "2026-12-31..2026-12-01": end date is before the start date`},
+		{"refresh.snaps.foo.timer", "00:00-01:00", `cannot set "core.refresh.snaps.foo.timer": unsupported system option`},
+		{"refresh.snaps.Foo.blackout", "12-24", `cannot set "core.refresh.snaps.Foo.blackout": unsupported system option`},
+	} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			changes: map[string]interface{}{
+				t.key: t.value,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf("%s=%s", t.key, t.value))
+	}
+}
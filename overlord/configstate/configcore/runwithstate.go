@@ -59,7 +59,15 @@ func init() {
 	validateOnly := &flags{validatedOnlyStateConfig: true}
 	addWithStateHandler(validateRefreshSchedule, nil, validateOnly)
 	addWithStateHandler(validateRefreshRateLimit, nil, validateOnly)
+	addWithStateHandler(validateRefreshWindows, nil, validateOnly)
 	addWithStateHandler(validateAutomaticSnapshotsExpiration, nil, validateOnly)
+	addWithStateHandler(validateSnapshotsEncryption, nil, validateOnly)
+	addWithStateHandler(validateScheduledSnapshots, nil, validateOnly)
+	addWithStateHandler(validateSnapshotsExportTarget, nil, validateOnly)
+	addWithStateHandler(validateSnapshotsExportSecret, nil, validateOnly)
+	addWithStateHandler(validateQuotaGroupsSettings, nil, validateOnly)
+	addWithStateHandler(validateHealthSettings, nil, validateOnly)
+	addWithStateHandler(validateStorePeerCache, nil, validateOnly)
 
 	// netplan.*
 	addWithStateHandler(validateNetplanSettings, handleNetplanConfiguration, coreOnly)
@@ -165,6 +173,10 @@ func applyHandlers(dev sysconfig.Device, cfg RunTransaction, handlers []configHa
 			if !validCertOption(k) {
 				return fmt.Errorf("cannot set store ssl certificate under name %q: name must only contain word characters or a dash", k)
 			}
+		case strings.HasPrefix(k, refreshSnapsPrefix):
+			if !validRefreshSnapsOption(k) {
+				return fmt.Errorf("cannot set %q: unsupported system option", k)
+			}
 		case isNetplanChange(k):
 			if release.OnClassic {
 				return fmt.Errorf("cannot set netplan configuration on classic")
@@ -21,13 +21,33 @@
 package configcore
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
+
+	"github.com/snapcore/snapd/overlord/snapshotstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/sink"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap/naming"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/timeutil"
 )
 
 func init() {
 	// add supported configuration of this module
 	supportedConfigurations["core.snapshots.automatic.retention"] = true
+	supportedConfigurations["core.snapshots.encryption"] = true
+	supportedConfigurations["core.snapshots.scheduled.timer"] = true
+	supportedConfigurations["core.snapshots.scheduled.snaps"] = true
+	supportedConfigurations["core.snapshots.scheduled.retention.keep-last"] = true
+	supportedConfigurations["core.snapshots.scheduled.retention.keep-daily"] = true
+	supportedConfigurations["core.snapshots.scheduled.retention.keep-weekly"] = true
+	supportedConfigurations["core.snapshots.scheduled.push"] = true
+	supportedConfigurations["core.snapshots.export.target"] = true
+	supportedConfigurations["core.snapshots.export.s3.access-key-id"] = true
+	supportedConfigurations["core.snapshots.export.s3.secret-access-key"] = true
+	supportedConfigurations["core.snapshots.export.s3.region"] = true
 }
 
 func validateAutomaticSnapshotsExpiration(tr RunTransaction) error {
@@ -46,3 +66,146 @@ func validateAutomaticSnapshotsExpiration(tr RunTransaction) error {
 	}
 	return nil
 }
+
+func validateSnapshotsEncryption(tr RunTransaction) error {
+	encryption, err := coreCfg(tr, "snapshots.encryption")
+	if err != nil {
+		return err
+	}
+	switch encryption {
+	case "", "none", "device":
+		return nil
+	default:
+		return fmt.Errorf(`snapshots.encryption can only be set to "device" or "none"`)
+	}
+}
+
+func validateScheduledSnapshots(tr RunTransaction) error {
+	timer, err := coreCfg(tr, "snapshots.scheduled.timer")
+	if err != nil {
+		return err
+	}
+	if timer != "" {
+		if _, err := timeutil.ParseSchedule(timer); err != nil {
+			return fmt.Errorf("snapshots.scheduled.timer cannot be parsed: %v", err)
+		}
+	}
+
+	snaps, err := coreCfg(tr, "snapshots.scheduled.snaps")
+	if err != nil {
+		return err
+	}
+	for _, name := range strutil.CommaSeparatedList(snaps) {
+		if err := naming.ValidateInstance(name); err != nil {
+			return fmt.Errorf("snapshots.scheduled.snaps is invalid: %v", err)
+		}
+	}
+
+	if err := validateBoolFlag(tr, "snapshots.scheduled.push"); err != nil {
+		return err
+	}
+
+	for _, opt := range []string{"keep-last", "keep-daily", "keep-weekly"} {
+		key := "snapshots.scheduled.retention." + opt
+		value, err := coreCfg(tr, key)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("%s must be a non-negative number, not %q", key, value)
+		}
+	}
+	return nil
+}
+
+// s3SecretKey is the option setting the secret access key of S3 sinks. It is
+// kept neither in the configuration nor in the data of the configure task
+// setting it, but only in memory until the configuration is committed, when
+// it is written to a file only root can read.
+const s3SecretKey = "snapshots.export.s3.secret-access-key"
+
+type pendingSecretsKey struct{ taskID string }
+
+// TakeSecrets splits the secret options out of the given patch of the core
+// configuration, so that they don't end up in task data.
+func TakeSecrets(patch map[string]interface{}) (rest, secrets map[string]interface{}) {
+	v, ok := patch[s3SecretKey]
+	if !ok {
+		return patch, nil
+	}
+	switch v.(type) {
+	case string, nil:
+	default:
+		// leave it to be rejected with the rest of the configuration
+		return patch, nil
+	}
+	rest = make(map[string]interface{}, len(patch)-1)
+	for k, v := range patch {
+		if k != s3SecretKey {
+			rest[k] = v
+		}
+	}
+	return rest, map[string]interface{}{s3SecretKey: v}
+}
+
+// KeepSecrets keeps the secret options taken out of the patch of the given
+// configure task in memory until the task commits the configuration.
+// The state needs to be locked by the caller.
+func KeepSecrets(task *state.Task, secrets map[string]interface{}) {
+	task.State().Cache(pendingSecretsKey{task.ID()}, secrets)
+	task.Set("pending-secrets", true)
+}
+
+// PendingSecrets takes the secret options kept for the given configure task
+// and returns a function recording them, to be called once the configuration
+// is committed, or nil if there are none. It fails if they were lost because
+// snapd was restarted in the meantime.
+// The state needs to be locked by the caller.
+func PendingSecrets(task *state.Task) (commit func() error, err error) {
+	var pending bool
+	if err := task.Get("pending-secrets", &pending); err != nil && !errors.Is(err, state.ErrNoState) {
+		return nil, err
+	}
+	if !pending {
+		return nil, nil
+	}
+	st := task.State()
+	secrets, ok := st.Cached(pendingSecretsKey{task.ID()}).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot set secret system options: snapd was restarted before they were applied, please set them again")
+	}
+	st.Cache(pendingSecretsKey{task.ID()}, nil)
+
+	return func() error {
+		if v, ok := secrets[s3SecretKey]; ok {
+			secret, _ := v.(string)
+			return snapshotstate.SetS3SecretAccessKey(secret)
+		}
+		return nil
+	}, nil
+}
+
+func validateSnapshotsExportSecret(tr RunTransaction) error {
+	secret, err := coreCfg(tr, s3SecretKey)
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		return fmt.Errorf("cannot keep %s in the configuration, please set it on its own", s3SecretKey)
+	}
+	return nil
+}
+
+func validateSnapshotsExportTarget(tr RunTransaction) error {
+	target, err := coreCfg(tr, "snapshots.export.target")
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return nil
+	}
+	return sink.Validate(target)
+}
@@ -21,9 +21,13 @@
 package configcore_test
 
 import (
+	"path/filepath"
+
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/configstate/configcore"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type snapshotsSuite struct {
@@ -71,3 +75,154 @@ func (s *refreshSuite) TestConfigureAutomaticSnapshotsExpirationInvalid(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `snapshots.automatic.retention cannot be parsed:.*`)
 }
+
+func (s *snapshotsSuite) TestConfigureSnapshotsEncryption(c *C) {
+	for _, value := range []string{"device", "none", ""} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"snapshots.encryption": value,
+			},
+		})
+		c.Check(err, IsNil, Commentf("%q", value))
+	}
+
+	err := configcore.Run(classicDev, &mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.encryption": "passphrase",
+		},
+	})
+	c.Assert(err, ErrorMatches, `snapshots.encryption can only be set to "device" or "none"`)
+}
+
+func (s *snapshotsSuite) TestConfigureScheduledSnapshots(c *C) {
+	err := configcore.Run(classicDev, &mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.scheduled.timer":                "mon,03:00",
+			"snapshots.scheduled.snaps":                "foo,bar_instance",
+			"snapshots.scheduled.retention.keep-last":  "3",
+			"snapshots.scheduled.retention.keep-daily": 7,
+			// zero keeps none of the weekly snapshots
+			"snapshots.scheduled.retention.keep-weekly": "0",
+		},
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *snapshotsSuite) TestConfigureScheduledSnapshotsInvalid(c *C) {
+	for _, t := range []struct {
+		key, value, err string
+	}{
+		{"snapshots.scheduled.timer", "invalid", `snapshots.scheduled.timer cannot be parsed: .*`},
+		{"snapshots.scheduled.snaps", "foo,-bar", `snapshots.scheduled.snaps is invalid: .*`},
+		{"snapshots.scheduled.retention.keep-weekly", "-1", `snapshots.scheduled.retention.keep-weekly must be a non-negative number, not "-1"`},
+		{"snapshots.scheduled.retention.keep-last", "x", `snapshots.scheduled.retention.keep-last must be a non-negative number, not "x"`},
+		{"snapshots.scheduled.push", "yes", `snapshots.scheduled.push can only be set to 'true' or 'false'`},
+		{"snapshots.export.target", "backups", `unsupported snapshot sink "backups"`},
+		{"snapshots.export.target", "s3://s3.example.com", `snapshot sink "s3://s3.example.com" has no bucket`},
+	} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				t.key: t.value,
+			},
+		})
+		c.Check(err, ErrorMatches, t.err, Commentf(t.key))
+	}
+}
+
+func (s *snapshotsSuite) TestConfigureSnapshotsExport(c *C) {
+	for _, target := range []string{"/media/backups", "file:///media/backups", "s3://s3.example.com/bucket/device"} {
+		err := configcore.Run(classicDev, &mockConf{
+			state: s.state,
+			conf: map[string]interface{}{
+				"snapshots.scheduled.push":          true,
+				"snapshots.export.target":           target,
+				"snapshots.export.s3.access-key-id": "key-id",
+				"snapshots.export.s3.region":        "eu-west-1",
+			},
+		})
+		c.Check(err, IsNil, Commentf(target))
+	}
+}
+
+func (s *snapshotsSuite) TestConfigureSnapshotsExportSecretNotInConfig(c *C) {
+	err := configcore.Run(classicDev, &mockConf{
+		state: s.state,
+		conf: map[string]interface{}{
+			"snapshots.export.s3.secret-access-key": "sekrit",
+		},
+	})
+	c.Check(err, ErrorMatches, `cannot keep snapshots.export.s3.secret-access-key in the configuration, please set it on its own`)
+}
+
+func (s *snapshotsSuite) TestTakeSecrets(c *C) {
+	patch := map[string]interface{}{
+		"snapshots.export.s3.access-key-id":     "key-id",
+		"snapshots.export.s3.secret-access-key": "sekrit",
+	}
+	rest, secrets := configcore.TakeSecrets(patch)
+	c.Check(rest, DeepEquals, map[string]interface{}{
+		"snapshots.export.s3.access-key-id": "key-id",
+	})
+	c.Check(secrets, DeepEquals, map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": "sekrit",
+	})
+	// the given patch is left alone
+	c.Check(patch, HasLen, 2)
+
+	// unsetting is a secret option too
+	rest, secrets = configcore.TakeSecrets(map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": nil,
+	})
+	c.Check(rest, HasLen, 0)
+	c.Check(secrets, DeepEquals, map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": nil,
+	})
+
+	// invalid values are left to be rejected
+	patch = map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": map[string]interface{}{"a": "b"},
+	}
+	rest, secrets = configcore.TakeSecrets(patch)
+	c.Check(rest, DeepEquals, patch)
+	c.Check(secrets, IsNil)
+}
+
+func (s *snapshotsSuite) TestPendingSecrets(c *C) {
+	secretFile := filepath.Join(dirs.SnapDeviceDir, "snapshots-export-s3-secret")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	task := s.state.NewTask("run-hook", "")
+	configcore.KeepSecrets(task, map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": "sekrit",
+	})
+	commit, err := configcore.PendingSecrets(task)
+	c.Assert(err, IsNil)
+	c.Assert(commit, NotNil)
+	// nothing is recorded until committed
+	c.Check(secretFile, testutil.FileAbsent)
+	c.Assert(commit(), IsNil)
+	c.Check(secretFile, testutil.FileEquals, "sekrit")
+
+	// the secret is only kept in memory until taken
+	_, err = configcore.PendingSecrets(task)
+	c.Check(err, ErrorMatches, `cannot set secret system options: snapd was restarted before they were applied, please set them again`)
+
+	task = s.state.NewTask("run-hook", "")
+	configcore.KeepSecrets(task, map[string]interface{}{
+		"snapshots.export.s3.secret-access-key": nil,
+	})
+	commit, err = configcore.PendingSecrets(task)
+	c.Assert(err, IsNil)
+	c.Assert(commit(), IsNil)
+	c.Check(secretFile, testutil.FileAbsent)
+
+	// no secrets
+	commit, err = configcore.PendingSecrets(s.state.NewTask("run-hook", ""))
+	c.Assert(err, IsNil)
+	c.Check(commit, IsNil)
+}
@@ -22,8 +22,10 @@ package configcore
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/osutil"
@@ -32,6 +34,8 @@ import (
 
 func init() {
 	supportedConfigurations["core.store.access"] = true
+	supportedConfigurations["core.store.peer-cache"] = true
+	supportedConfigurations["core.store.peer-cache-interface"] = true
 }
 
 func validateStoreAccess(cfg ConfGetter) error {
@@ -48,6 +52,32 @@ func validateStoreAccess(cfg ConfGetter) error {
 	}
 }
 
+// validateStorePeerCache validates store.peer-cache and
+// store.peer-cache-interface, which are then applied by snapstate.
+//
+// With store.peer-cache set to true, the snaps downloaded from the
+// global store that are not private are served over HTTP, without any
+// authentication, to the other devices of the local network, on all
+// the interfaces unless store.peer-cache-interface is set to the name
+// of the only one to use. Anyone on that network who knows the
+// sha3-384 digest of such a snap can download it from the device, and
+// learn that it has it.
+func validateStorePeerCache(tr RunTransaction) error {
+	if err := validateBoolFlag(tr, "store.peer-cache"); err != nil {
+		return err
+	}
+
+	iface, err := coreCfg(tr, "store.peer-cache-interface")
+	if err != nil {
+		return err
+	}
+	// see dev_valid_name in the kernel
+	if iface != "" && (len(iface) > 15 || iface == "." || iface == ".." || strings.ContainsAny(iface, "/: \t\n")) {
+		return fmt.Errorf("store.peer-cache-interface must be a network interface name, not %q", iface)
+	}
+	return nil
+}
+
 // repairConfig is a set of configuration data that is consumed by the
 // snap-repair command. This struct is duplicated in cmd/snap-repair.
 type repairConfig struct {
@@ -78,6 +78,48 @@ func (s *storeSuite) TestStoreAccessUnhappy(c *C) {
 	c.Assert(err, ErrorMatches, ".*store access can only be set to 'offline'")
 }
 
+func (s *storeSuite) TestStorePeerCache(c *C) {
+	for _, v := range []interface{}{"true", "false", true, false} {
+		err := configcore.Run(coreDev, &mockConf{
+			state: s.state,
+			changes: map[string]interface{}{
+				"store.peer-cache": v,
+			},
+		})
+		c.Check(err, IsNil, Commentf("%v", v))
+	}
+
+	err := configcore.Run(coreDev, &mockConf{
+		state: s.state,
+		changes: map[string]interface{}{
+			"store.peer-cache": "maybe",
+		},
+	})
+	c.Assert(err, ErrorMatches, "store.peer-cache can only be set to 'true' or 'false'")
+}
+
+func (s *storeSuite) TestStorePeerCacheInterface(c *C) {
+	for _, v := range []string{"eth0", "wlp2s0", "br-lan"} {
+		err := configcore.Run(coreDev, &mockConf{
+			state: s.state,
+			changes: map[string]interface{}{
+				"store.peer-cache-interface": v,
+			},
+		})
+		c.Check(err, IsNil, Commentf("%v", v))
+	}
+
+	for _, v := range []string{"..", "eth0/1", "eth 0", "a-very-long-interface"} {
+		err := configcore.Run(coreDev, &mockConf{
+			state: s.state,
+			changes: map[string]interface{}{
+				"store.peer-cache-interface": v,
+			},
+		})
+		c.Check(err, ErrorMatches, `store.peer-cache-interface must be a network interface name, not ".*"`, Commentf("%v", v))
+	}
+}
+
 func (s *storeSuite) TestFilesystemOnlyApply(c *C) {
 	conf := configcore.PlainCoreConfig(map[string]interface{}{
 		"store.access": "offline",
@@ -74,6 +74,15 @@ func Init(st *state.State, hookManager *hookstate.HookManager) error {
 				return nil, nil, err
 			}
 			rt := configcore.NewRunTransaction(ContextTransaction(ctx), task)
+			// secrets are only recorded once the configuration
+			// is committed
+			commitSecrets, err := configcore.PendingSecrets(task)
+			if err != nil {
+				return nil, nil, err
+			}
+			if commitSecrets != nil {
+				ctx.OnDone(commitSecrets)
+			}
 			return dev, rt, nil
 		}()
 		if err != nil {
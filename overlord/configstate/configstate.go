@@ -117,6 +117,11 @@ func Configure(st *state.State, snapName string, patch map[string]interface{}, f
 		// all configure hooks must finish within this timeout
 		Timeout: ConfigureHookTimeout(),
 	}
+	var secrets map[string]interface{}
+	if snapName == "core" {
+		// secrets must not end up in the task data
+		patch, secrets = configcore.TakeSecrets(patch)
+	}
 	var contextData map[string]interface{}
 	if flags&snapstate.UseConfigDefaults != 0 {
 		contextData = map[string]interface{}{"use-defaults": true}
@@ -129,6 +134,9 @@ func Configure(st *state.State, snapName string, patch map[string]interface{}, f
 	}
 
 	task := hookstate.HookTask(st, summary, hooksup, contextData)
+	if len(secrets) > 0 {
+		configcore.KeepSecrets(task, secrets)
+	}
 	return state.NewTaskSet(task)
 }
 
@@ -21,7 +21,9 @@
 package configstate_test
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -392,6 +394,58 @@ func (s *configcoreHijackSuite) TestHijack(c *C) {
 	c.Check(configcoreRan, Equals, true)
 }
 
+func (s *configcoreHijackSuite) TestHijackSecrets(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	defer dirs.SetRootDir("")
+	secretFile := filepath.Join(dirs.SnapDeviceDir, "snapshots-export-s3-secret")
+
+	var fail bool
+	r := configstate.MockConfigcoreRun(func(dev sysconfig.Device, conf configcore.RunTransaction) error {
+		// the secret is not in the configuration
+		c.Check(conf.Changes(), DeepEquals, []string{"core.snapshots.export.s3.access-key-id"})
+		// and not recorded yet
+		c.Check(secretFile, testutil.FileAbsent)
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	defer r()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, fail = range []bool{true, false} {
+		ts := configstate.Configure(s.state, "core", map[string]interface{}{
+			"snapshots.export.s3.access-key-id":     "key-id",
+			"snapshots.export.s3.secret-access-key": "sekrit",
+		}, 0)
+		c.Assert(ts.Tasks(), HasLen, 1)
+		// nor in the task data
+		var hookContext map[string]interface{}
+		c.Assert(ts.Tasks()[0].Get("hook-context", &hookContext), IsNil)
+		c.Check(hookContext, DeepEquals, map[string]interface{}{
+			"patch": map[string]interface{}{"snapshots.export.s3.access-key-id": "key-id"},
+		})
+
+		chg := s.state.NewChange("configure-core", "configure core")
+		chg.AddAll(ts)
+
+		s.state.Unlock()
+		err := s.o.Settle(5 * time.Second)
+		s.state.Lock()
+		c.Assert(err, IsNil)
+
+		if fail {
+			c.Check(chg.Err(), ErrorMatches, `(?s).*boom.*`)
+			c.Check(secretFile, testutil.FileAbsent)
+		} else {
+			c.Check(chg.Err(), IsNil)
+			c.Check(secretFile, testutil.FileEquals, "sekrit")
+		}
+	}
+}
+
 type miscSuite struct{}
 
 func (s *miscSuite) TestRemappingFuncs(c *C) {
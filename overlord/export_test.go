@@ -24,6 +24,7 @@ import (
 
 	"github.com/snapcore/snapd/overlord/configstate"
 	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/ifacestate/apparmorprompting"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/overlord/storecontext"
@@ -33,8 +34,40 @@ import (
 
 var (
 	LockWithTimeout = lockWithTimeout
+
+	ReadStateWithJournal = readState
 )
 
+type StateJournal = stateJournal
+
+// NewStateJournal returns a state journal applying to the state file.
+func NewStateJournal(statePath, path string, syncInterval time.Duration) *StateJournal {
+	return &stateJournal{
+		statePath:    statePath,
+		path:         path,
+		ensureBefore: func(time.Duration) {},
+		syncInterval: syncInterval,
+	}
+}
+
+func (j *stateJournal) Unsynced() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.unsynced
+}
+
+// CompactInterrupted writes the whole state into the state file like a
+// compaction, but stops before emptying the journal.
+func (j *stateJournal) CompactInterrupted(data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.writeState(data)
+}
+
+func MockJournalMaxSize(size int64) (restore func()) {
+	return testutil.Mock(&journalMaxSize, size)
+}
+
 // MockEnsureInterval sets the overlord ensure interval for tests.
 func MockEnsureInterval(d time.Duration) (restore func()) {
 	old := ensureInterval
@@ -101,6 +134,17 @@ func MockConfigstateInit(new func(*state.State, *hookstate.HookManager) error) (
 	}
 }
 
+// MockApparmorpromptingNew mocks apparmorprompting.New as called by overlord.New.
+func MockApparmorpromptingNew(new func(*state.State) *apparmorprompting.InterfacesRequestsManager) (restore func()) {
+	return testutil.Mock(&apparmorpromptingNew, new)
+}
+
+// AddedInterfacesRequestsManager returns the interfaces requests manager of
+// the overlord, whether it's running or not.
+func (o *Overlord) AddedInterfacesRequestsManager() *apparmorprompting.InterfacesRequestsManager {
+	return o.interfacesRequestsMgr
+}
+
 func MockPreseedExitWithError(f func(err error)) (restore func()) {
 	old := preseedExitWithError
 	preseedExitWithError = f
@@ -20,7 +20,16 @@
 package healthstate
 
 import (
+	"context"
+	"os/user"
 	"time"
+
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/systemd"
 )
 
 func MockCheckTimeout(t time.Duration) (restore func()) {
@@ -32,3 +41,63 @@ func MockCheckTimeout(t time.Duration) (restore func()) {
 }
 
 var KnownStatuses = knownStatuses
+
+func MockSnapstateRevert(f func(st *state.State, name string, flags snapstate.Flags, fromChange string) (*state.TaskSet, error)) (restore func()) {
+	old := snapstateRevert
+	snapstateRevert = f
+	return func() {
+		snapstateRevert = old
+	}
+}
+
+func MockServicestateControl(f func(st *state.State, appInfos []*snap.AppInfo, inst *servicestate.Instruction, cu *user.User, flags *servicestate.Flags, context *hookstate.Context) ([]*state.TaskSet, error)) (restore func()) {
+	old := servicestateControl
+	servicestateControl = f
+	return func() {
+		servicestateControl = old
+	}
+}
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func MockRandomDuration(f func(time.Duration) time.Duration) (restore func()) {
+	old := randomDuration
+	randomDuration = f
+	return func() {
+		randomDuration = old
+	}
+}
+
+func MockSnapstateUpdateMany(f func(ctx context.Context, st *state.State, names []string, revOpts []*snapstate.RevisionOptions, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error)) (restore func()) {
+	old := snapstateUpdateMany
+	snapstateUpdateMany = f
+	return func() {
+		snapstateUpdateMany = old
+	}
+}
+
+func MockSnapstateRevertToRevision(f func(st *state.State, name string, rev snap.Revision, flags snapstate.Flags, fromChange string) (*state.TaskSet, error)) (restore func()) {
+	old := snapstateRevertToRevision
+	snapstateRevertToRevision = f
+	return func() {
+		snapstateRevertToRevision = old
+	}
+}
+
+func MockServicesStatus(f func(units []string) ([]*systemd.UnitStatus, error)) (restore func()) {
+	old := servicesStatus
+	servicesStatus = f
+	return func() {
+		servicesStatus = old
+	}
+}
+
+func DoSoakRefreshGroup(m *HealthManager, t *state.Task) error {
+	return m.doSoakRefreshGroup(t, nil)
+}
@@ -0,0 +1,308 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/randutil"
+	"github.com/snapcore/snapd/snap"
+)
+
+var (
+	snapstateRevert     = snapstate.Revert
+	servicestateControl = servicestate.Control
+
+	timeNow        = time.Now
+	randomDuration = randutil.RandomDuration
+)
+
+// checkRetryDelay is how long to wait before trying again to run a
+// periodic health check that conflicted with another change.
+const checkRetryDelay = time.Minute
+
+// HealthManager acts on the health reported by snaps according to
+// their health policies, and runs their periodic health checks.
+type HealthManager struct {
+	state *state.State
+
+	// jitter is the random delay added to the next periodic health
+	// check of each snap, so that checks of different snaps with the
+	// same interval are spread out
+	jitter map[string]time.Duration
+	// notBefore is the earliest time to run the periodic health check
+	// of snaps that never reported their health, or for which it
+	// conflicted with another change
+	notBefore map[string]time.Time
+}
+
+// Manager returns a new HealthManager, registering the check-health
+// hook handler with the hook manager.
+func Manager(st *state.State, hookManager *hookstate.HookManager, runner *state.TaskRunner) *HealthManager {
+	Init(hookManager)
+
+	m := &HealthManager{
+		state:     st,
+		jitter:    make(map[string]time.Duration),
+		notBefore: make(map[string]time.Time),
+	}
+
+	runner.AddHandler("soak-refresh-group", m.doSoakRefreshGroup, nil)
+	snapstate.RegisterAffectedSnapsByKind("soak-refresh-group", refreshGroupAffectedSnaps)
+
+	return m
+}
+
+// Ensure is part of the overlord.StateManager interface.
+func (m *HealthManager) Ensure() error {
+	m.state.Lock()
+	defer m.state.Unlock()
+
+	if err := m.ensureActions(); err != nil {
+		return err
+	}
+	return m.ensurePeriodicChecks()
+}
+
+func (m *HealthManager) ensureActions() error {
+	tracked, err := allTracking(m.state)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(tracked))
+	for name, tracking := range tracked {
+		if tracking.Action != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tracking := tracked[name]
+		if chg := m.state.Change(tracking.Change); chg != nil && !chg.IsReady() {
+			// try again once the change of the previous action is done
+			logger.Debugf("cannot %s snap %q after failed health checks yet: change %s in progress", tracking.Action, name, chg.ID())
+			continue
+		}
+		chg, err := m.act(name, tracking.Action)
+		if isConflict(err) {
+			// try again once the conflicting change is done
+			logger.Debugf("cannot %s snap %q after failed health checks yet: %v", tracking.Action, name, err)
+			continue
+		}
+		if err != nil {
+			logger.Noticef("cannot %s snap %q after failed health checks: %v", tracking.Action, name, err)
+		}
+		if chg != nil {
+			tracking.Change = chg.ID()
+		}
+		tracking.Action = ""
+	}
+	m.state.Set("health-tracking", tracked)
+
+	return nil
+}
+
+func isConflict(err error) bool {
+	var changeConflict *snapstate.ChangeConflictError
+	var serviceConflict *servicestate.ServiceActionConflictError
+	return errors.As(err, &changeConflict) || errors.As(err, &serviceConflict)
+}
+
+// act takes the given action on the snap, returning the change doing
+// it, if any.
+func (m *HealthManager) act(name, action string) (*state.Change, error) {
+	switch action {
+	case revertAction:
+		return m.revert(name)
+	case restartAction:
+		return m.restart(name)
+	default:
+		return nil, fmt.Errorf("internal error: unknown health action %q", action)
+	}
+}
+
+func (m *HealthManager) revert(name string) (*state.Change, error) {
+	ts, err := snapstateRevert(m.state, name, snapstate.Flags{}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	chg := m.state.NewChange("revert-snap", fmt.Sprintf("Revert %q snap after failed health checks", name))
+	chg.AddAll(ts)
+	chg.Set("snap-names", []string{name})
+	logger.Noticef("Reverting snap %q after failed health checks", name)
+
+	return chg, nil
+}
+
+func (m *HealthManager) restart(name string) (*state.Change, error) {
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(m.state, name, &snapst); err != nil {
+		return nil, err
+	}
+	if !snapst.Active {
+		return nil, nil
+	}
+	info, err := snapst.CurrentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var svcs []*snap.AppInfo
+	for _, app := range info.Services() {
+		if app.DaemonScope == snap.SystemDaemon {
+			svcs = append(svcs, app)
+		}
+	}
+	if len(svcs) == 0 {
+		return nil, nil
+	}
+
+	inst := &servicestate.Instruction{
+		Action: "restart",
+		Names:  []string{name},
+		Scope:  client.ScopeSelector{"system"},
+	}
+	tss, err := servicestateControl(m.state, svcs, inst, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	chg := m.state.NewChange("service-control", fmt.Sprintf("Restart services of %q snap after failed health check", name))
+	for _, ts := range tss {
+		chg.AddAll(ts)
+	}
+	chg.Set("snap-names", []string{name})
+	logger.Noticef("Restarting services of snap %q after failed health check", name)
+
+	return chg, nil
+}
+
+// ensurePeriodicChecks runs the check-health hook of the snaps with a
+// check interval once the interval passed since their health was last
+// reported.
+func (m *HealthManager) ensurePeriodicChecks() error {
+	intervals, err := configuredCheckIntervals(m.state)
+	if err != nil {
+		return err
+	}
+	snapStates, err := snapstate.All(m.state)
+	if err != nil {
+		return err
+	}
+	healths, err := All(m.state)
+	if err != nil {
+		return err
+	}
+
+	now := timeNow()
+	var nextCheck time.Time
+	for name, snapst := range snapStates {
+		if !snapst.Active {
+			continue
+		}
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			logger.Debugf("cannot get information of snap %q for its health check: %v", name, err)
+			continue
+		}
+		if info.Hooks["check-health"] == nil {
+			continue
+		}
+		interval := intervals.forSnap(info)
+		if interval == 0 {
+			continue
+		}
+
+		jitter, ok := m.jitter[name]
+		if !ok {
+			jitter = randomDuration(interval / 10)
+			m.jitter[name] = jitter
+		}
+		var due time.Time
+		if health := healths[name]; health != nil {
+			due = health.Timestamp.Add(interval + jitter)
+		}
+		notBefore, ok := m.notBefore[name]
+		if !ok && due.IsZero() {
+			notBefore = now.Add(jitter)
+			m.notBefore[name] = notBefore
+		}
+		if notBefore.After(due) {
+			due = notBefore
+		}
+
+		if !due.After(now) {
+			delete(m.notBefore, name)
+			err := m.runCheck(name, snapst.Current)
+			if isConflict(err) {
+				// the snap is busy, possibly with a health check still
+				// running, try again later
+				logger.Debugf("cannot run periodic health check of snap %q yet: %v", name, err)
+				due = now.Add(checkRetryDelay)
+				m.notBefore[name] = due
+			} else if err != nil {
+				return err
+			} else {
+				// the next check is due once this one reported the
+				// health, with a new jitter
+				delete(m.jitter, name)
+				due = now.Add(interval)
+			}
+		}
+
+		if nextCheck.IsZero() || due.Before(nextCheck) {
+			nextCheck = due
+		}
+	}
+
+	if !nextCheck.IsZero() {
+		m.state.EnsureBefore(nextCheck.Sub(now))
+	}
+	return nil
+}
+
+func (m *HealthManager) runCheck(name string, rev snap.Revision) error {
+	// the snap keeps being checked while watched after being refreshed
+	// as part of a group, as its health is what is watched
+	if err := snapstate.CheckChangeConflictMany(m.state, []string{name}, soakingChange(m.state, name)); err != nil {
+		return err
+	}
+
+	chg := m.state.NewChange("check-health", fmt.Sprintf("Run periodic health check of %q snap", name))
+	chg.AddTask(Hook(m.state, name, rev))
+	chg.Set("snap-names", []string{name})
+
+	return nil
+}
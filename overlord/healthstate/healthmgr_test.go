@@ -0,0 +1,518 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate_test
+
+import (
+	"errors"
+	"os/user"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/healthstate"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type healthMgrSuite struct {
+	testutil.BaseTest
+	state  *state.State
+	runner *state.TaskRunner
+	mgr    *healthstate.HealthManager
+
+	reverted   []string
+	controlled []*servicestate.Instruction
+	services   [][]string
+	revertErr  error
+
+	now    time.Time
+	jitter time.Duration
+}
+
+var _ = check.Suite(&healthMgrSuite{})
+
+const healthMgrSnapYaml = `name: test-snap
+version: 1
+apps:
+  svc:
+    daemon: simple
+  user-svc:
+    daemon: simple
+    daemon-scope: user
+  cmd:
+    command: bin/cmd
+`
+
+func (s *healthMgrSuite) SetUpTest(c *check.C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.state = state.New(nil)
+	s.runner = state.NewTaskRunner(s.state)
+	hookMgr, err := hookstate.Manager(s.state, s.runner)
+	c.Assert(err, check.IsNil)
+	s.mgr = healthstate.Manager(s.state, hookMgr, s.runner)
+
+	s.reverted = nil
+	s.controlled = nil
+	s.services = nil
+	s.revertErr = nil
+	s.now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.jitter = 0
+	s.AddCleanup(healthstate.MockTimeNow(func() time.Time { return s.now }))
+	s.AddCleanup(healthstate.MockRandomDuration(func(max time.Duration) time.Duration {
+		c.Check(s.jitter <= max, check.Equals, true)
+		return s.jitter
+	}))
+	s.AddCleanup(healthstate.MockSnapstateRevert(func(st *state.State, name string, flags snapstate.Flags, fromChange string) (*state.TaskSet, error) {
+		if s.revertErr != nil {
+			return nil, s.revertErr
+		}
+		s.reverted = append(s.reverted, name)
+		return state.NewTaskSet(st.NewTask("fake-revert", "fake revert")), nil
+	}))
+	s.AddCleanup(healthstate.MockServicestateControl(func(st *state.State, appInfos []*snap.AppInfo, inst *servicestate.Instruction, cu *user.User, flags *servicestate.Flags, context *hookstate.Context) ([]*state.TaskSet, error) {
+		c.Check(cu, check.IsNil)
+		var names []string
+		for _, app := range appInfos {
+			names = append(names, app.Name)
+		}
+		s.services = append(s.services, names)
+		s.controlled = append(s.controlled, inst)
+		return []*state.TaskSet{state.NewTaskSet(st.NewTask("fake-control", "fake control"))}, nil
+	}))
+
+	s.mockSnap(c, healthMgrSnapYaml, snap.R(2))
+}
+
+func (s *healthMgrSuite) mockSnap(c *check.C, yaml string, current snap.Revision) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	si1 := &snap.SideInfo{RealName: "test-snap", Revision: snap.R(1)}
+	si2 := &snap.SideInfo{RealName: "test-snap", Revision: snap.R(2)}
+	snaptest.MockSnap(c, yaml, si1)
+	snaptest.MockSnap(c, yaml, si2)
+	snapstate.Set(s.state, "test-snap", &snapstate.SnapState{
+		Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{si1, si2}),
+		Current:  current,
+		Active:   true,
+		SnapType: "app",
+	})
+}
+
+func (s *healthMgrSuite) setConfig(c *check.C, key, value string) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", key, value), check.IsNil)
+	tr.Commit()
+}
+
+func (s *healthMgrSuite) report(c *check.C, status healthstate.HealthStatus) {
+	ctx, err := hookstate.NewContext(nil, s.state, &hookstate.HookSetup{Snap: "test-snap"}, nil, "")
+	c.Assert(err, check.IsNil)
+
+	ctx.Lock()
+	defer ctx.Unlock()
+	ctx.Set("health", &healthstate.HealthState{
+		Revision:  snap.R(2),
+		Timestamp: s.now,
+		Status:    status,
+	})
+	c.Assert(healthstate.SetFromHookContext(ctx), check.IsNil)
+}
+
+func (s *healthMgrSuite) reportAndEnsure(c *check.C, statuses ...healthstate.HealthStatus) {
+	for _, status := range statuses {
+		s.report(c, status)
+		c.Assert(s.mgr.Ensure(), check.IsNil)
+	}
+}
+
+func (s *healthMgrSuite) changes(c *check.C) map[string]string {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chgs := make(map[string]string)
+	for _, chg := range s.state.Changes() {
+		chgs[chg.Kind()] = chg.Summary()
+
+		var snapNames []string
+		c.Check(chg.Get("snap-names", &snapNames), check.IsNil)
+		c.Check(snapNames, check.DeepEquals, []string{"test-snap"})
+	}
+	return chgs
+}
+
+func (s *healthMgrSuite) TestNoPolicyNoAction(c *check.C) {
+	s.reportAndEnsure(c, healthstate.ErrorStatus, healthstate.ErrorStatus, healthstate.ErrorStatus, healthstate.ErrorStatus)
+
+	c.Check(s.reverted, check.HasLen, 0)
+	c.Check(s.controlled, check.HasLen, 0)
+	c.Check(s.changes(c), check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestRestartOnError(c *check.C) {
+	s.setConfig(c, "resilience.health.restart-on-error", "other-snap,test-snap")
+
+	s.reportAndEnsure(c, healthstate.OkayStatus, healthstate.WaitingStatus)
+	c.Check(s.controlled, check.HasLen, 0)
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.DeepEquals, []*servicestate.Instruction{{
+		Action: "restart",
+		Names:  []string{"test-snap"},
+		Scope:  client.ScopeSelector{"system"},
+	}})
+	// only system services are restarted
+	c.Check(s.services, check.DeepEquals, [][]string{{"svc"}})
+	c.Check(s.changes(c), check.DeepEquals, map[string]string{
+		"service-control": `Restart services of "test-snap" snap after failed health check`,
+	})
+
+	// services are not restarted again while the error persists
+	s.reportAndEnsure(c, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.HasLen, 1)
+
+	// but they are once the snap recovered and fails again
+	s.finishChecks(c)
+	s.reportAndEnsure(c, healthstate.OkayStatus, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.HasLen, 2)
+	c.Check(s.reverted, check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestActionWaitsForPreviousChange(c *check.C) {
+	s.setConfig(c, "resilience.health.restart-on-error", "test-snap")
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.HasLen, 1)
+
+	// the snap fails again while its services are still being restarted
+	s.reportAndEnsure(c, healthstate.OkayStatus, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.HasLen, 1)
+
+	// the action is kept until the restart is done
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.controlled, check.HasLen, 1)
+
+	s.finishChecks(c)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.controlled, check.HasLen, 2)
+}
+
+func (s *healthMgrSuite) TestRestartOnErrorFromSnapYaml(c *check.C) {
+	s.mockSnap(c, healthMgrSnapYaml+"health:\n  restart-on-error: true\n", snap.R(2))
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.controlled, check.HasLen, 1)
+	c.Check(s.changes(c), check.DeepEquals, map[string]string{
+		"service-control": `Restart services of "test-snap" snap after failed health check`,
+	})
+}
+
+func (s *healthMgrSuite) TestRevertOnErrorAfterRefresh(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:2")
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+	c.Check(s.changes(c), check.DeepEquals, map[string]string{
+		"revert-snap": `Revert "test-snap" snap after failed health checks`,
+	})
+}
+
+func (s *healthMgrSuite) TestRevertOnErrorDefaultCount(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap")
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+
+	// the count is of consecutive errors
+	s.reportAndEnsure(c, healthstate.WaitingStatus, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+}
+
+func (s *healthMgrSuite) TestRevertOnErrorFromSnapYaml(c *check.C) {
+	s.mockSnap(c, healthMgrSnapYaml+"health:\n  revert-on-error: 1\n", snap.R(2))
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+}
+
+func (s *healthMgrSuite) TestNoRevertAfterOkay(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:1")
+
+	s.reportAndEnsure(c, healthstate.OkayStatus, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+	c.Check(s.changes(c), check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestNoRevertOfRevertedSnap(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:1")
+	s.mockSnap(c, healthMgrSnapYaml, snap.R(1))
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestRevertTakesPrecedence(c *check.C) {
+	s.setConfig(c, "resilience.health.restart-on-error", "test-snap")
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:1")
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+	c.Check(s.controlled, check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestRevisionChangeResetsTracking(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:2")
+
+	s.mockSnap(c, healthMgrSnapYaml, snap.R(1))
+	s.reportAndEnsure(c, healthstate.OkayStatus)
+
+	s.mockSnap(c, healthMgrSnapYaml, snap.R(2))
+	s.reportAndEnsure(c, healthstate.ErrorStatus, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+}
+
+func (s *healthMgrSuite) TestConflictRetried(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:1")
+	s.revertErr = &snapstate.ChangeConflictError{Snap: "test-snap", ChangeKind: "refresh-snap"}
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.reverted, check.HasLen, 0)
+	c.Check(s.changes(c), check.HasLen, 0)
+
+	s.revertErr = nil
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.reverted, check.DeepEquals, []string{"test-snap"})
+
+	// the action is consumed
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.reverted, check.HasLen, 1)
+}
+
+func (s *healthMgrSuite) TestErrorDropsAction(c *check.C) {
+	s.setConfig(c, "resilience.health.revert-on-error", "test-snap:1")
+	s.revertErr = errors.New("boom")
+
+	s.reportAndEnsure(c, healthstate.ErrorStatus)
+	c.Check(s.changes(c), check.HasLen, 0)
+
+	s.revertErr = nil
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.reverted, check.HasLen, 0)
+}
+
+const healthMgrSnapWithHookYaml = healthMgrSnapYaml + `hooks:
+  check-health:
+`
+
+func (s *healthMgrSuite) checkChanges(c *check.C) []*state.Change {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var chgs []*state.Change
+	for _, chg := range s.state.Changes() {
+		if chg.Kind() == "check-health" {
+			chgs = append(chgs, chg)
+		}
+	}
+	return chgs
+}
+
+func (s *healthMgrSuite) finishChecks(c *check.C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	for _, t := range s.state.Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+}
+
+func (s *healthMgrSuite) TestPeriodicCheckNotConfigured(c *check.C) {
+	s.mockSnap(c, healthMgrSnapWithHookYaml, snap.R(2))
+
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestPeriodicCheckNoHook(c *check.C) {
+	s.setConfig(c, "resilience.health.check-interval", "1h")
+
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestPeriodicCheck(c *check.C) {
+	s.mockSnap(c, healthMgrSnapWithHookYaml, snap.R(2))
+	s.setConfig(c, "resilience.health.check-interval", "1h")
+	s.jitter = 5 * time.Minute
+
+	// without a reported health the first check is only delayed by the jitter
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	s.now = s.now.Add(5 * time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	chgs := s.checkChanges(c)
+	c.Assert(chgs, check.HasLen, 1)
+
+	s.state.Lock()
+	c.Check(chgs[0].Summary(), check.Equals, `Run periodic health check of "test-snap" snap`)
+	var snapNames []string
+	c.Check(chgs[0].Get("snap-names", &snapNames), check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"test-snap"})
+	tasks := chgs[0].Tasks()
+	c.Assert(tasks, check.HasLen, 1)
+	c.Check(tasks[0].Kind(), check.Equals, "run-hook")
+	var hooksup hookstate.HookSetup
+	c.Assert(tasks[0].Get("hook-setup", &hooksup), check.IsNil)
+	c.Check(hooksup, check.DeepEquals, hookstate.HookSetup{
+		Snap:     "test-snap",
+		Hook:     "check-health",
+		Revision: snap.R(2),
+		Optional: true,
+		Timeout:  30 * time.Second,
+	})
+	s.state.Unlock()
+
+	// no new check while the previous one is running
+	s.jitter = 3 * time.Minute
+	s.now = s.now.Add(2 * time.Hour)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+
+	// the next check is due an interval (and jitter) after the
+	// health was reported
+	s.finishChecks(c)
+	s.reportAndEnsure(c, healthstate.OkayStatus)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+
+	s.now = s.now.Add(time.Hour + 2*time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+
+	s.now = s.now.Add(time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 2)
+}
+
+func (s *healthMgrSuite) TestPeriodicCheckIntervalPrecedence(c *check.C) {
+	s.mockSnap(c, healthMgrSnapWithHookYaml+"health:\n  check-interval: 10m\n", snap.R(2))
+	s.setConfig(c, "resilience.health.check-interval", "5m")
+	s.reportAndEnsure(c, healthstate.OkayStatus)
+
+	// the interval declared by the snap wins over the one for all snaps
+	s.now = s.now.Add(6 * time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	// but not over the one configured for the snap
+	s.setConfig(c, "resilience.health.check-interval", "5m,test-snap:2h")
+	s.now = s.now.Add(6 * time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	s.setConfig(c, "resilience.health.check-interval", "")
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+}
+
+func (s *healthMgrSuite) TestPeriodicCheckConflict(c *check.C) {
+	s.mockSnap(c, healthMgrSnapWithHookYaml, snap.R(2))
+	s.setConfig(c, "resilience.health.check-interval", "1h")
+
+	s.state.Lock()
+	chg := s.state.NewChange("refresh-snap", "...")
+	t := s.state.NewTask("link-snap", "...")
+	t.Set("snap-setup", &snapstate.SnapSetup{SideInfo: &snap.SideInfo{RealName: "test-snap"}})
+	chg.AddTask(t)
+	s.state.Unlock()
+
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	s.finishChecks(c)
+
+	// retried after a delay
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+}
+
+func (s *healthMgrSuite) TestCheckTimeoutFromConfig(c *check.C) {
+	s.setConfig(c, "resilience.health.check-timeout", "2m")
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	task := healthstate.Hook(s.state, "test-snap", snap.R(2))
+	var hooksup hookstate.HookSetup
+	c.Assert(task.Get("hook-setup", &hooksup), check.IsNil)
+	c.Check(hooksup.Timeout, check.Equals, 2*time.Minute)
+}
+
+func (s *healthMgrSuite) TestHistory(c *check.C) {
+	s.state.Lock()
+	history, err := healthstate.History(s.state, "test-snap")
+	s.state.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Check(history, check.HasLen, 0)
+
+	start := s.now
+	for i := 0; i < 12; i++ {
+		s.report(c, healthstate.OkayStatus)
+		s.now = s.now.Add(time.Minute)
+	}
+
+	s.state.Lock()
+	history, err = healthstate.History(s.state, "test-snap")
+	s.state.Unlock()
+	c.Assert(err, check.IsNil)
+	c.Assert(history, check.HasLen, 10)
+	// the oldest reports are dropped
+	c.Check(history[0].Timestamp.Equal(start.Add(2*time.Minute)), check.Equals, true)
+	c.Check(history[9].Timestamp.Equal(start.Add(11*time.Minute)), check.Equals, true)
+	for _, h := range history {
+		c.Check(h.Status, check.Equals, healthstate.OkayStatus)
+		c.Check(h.Revision, check.Equals, snap.R(2))
+	}
+}
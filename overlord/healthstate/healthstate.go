@@ -50,13 +50,19 @@ func init() {
 }
 
 func Hook(st *state.State, snapName string, snapRev snap.Revision) *state.Task {
+	timeout, err := configuredCheckTimeout(st)
+	if err != nil {
+		logger.Noticef("cannot get the check-health hook timeout, using the default: %v", err)
+		timeout = checkTimeout
+	}
+
 	summary := fmt.Sprintf("Run health check of %q snap", snapName)
 	hooksup := &hookstate.HookSetup{
 		Snap:     snapName,
 		Revision: snapRev,
 		Hook:     "check-health",
 		Optional: true,
-		Timeout:  checkTimeout,
+		Timeout:  timeout,
 	}
 
 	return hookstate.HookTask(st, summary, hooksup, nil)
@@ -177,6 +183,31 @@ func appendHealth(ctx *hookstate.Context, health *HealthState) error {
 	hs[ctx.InstanceName()] = health
 	st.Set("health", hs)
 
+	if err := appendHistory(st, ctx.InstanceName(), health); err != nil {
+		return err
+	}
+
+	return trackHealth(st, ctx.InstanceName(), health)
+}
+
+// maxHistory is the number of health reports kept for each snap.
+const maxHistory = 10
+
+func appendHistory(st *state.State, instanceName string, health *HealthState) error {
+	var history map[string][]*HealthState
+	if err := st.Get("health-history", &history); err != nil {
+		if !errors.Is(err, state.ErrNoState) {
+			return err
+		}
+		history = map[string][]*HealthState{}
+	}
+	snapHistory := append(history[instanceName], health)
+	if len(snapHistory) > maxHistory {
+		snapHistory = snapHistory[len(snapHistory)-maxHistory:]
+	}
+	history[instanceName] = snapHistory
+	st.Set("health-history", history)
+
 	return nil
 }
 
@@ -225,3 +256,13 @@ func Get(st *state.State, snap string) (*HealthState, error) {
 
 	return &health, nil
 }
+
+// History returns the last health reports of the given snap, oldest
+// first.
+func History(st *state.State, snap string) ([]*HealthState, error) {
+	var history map[string][]*HealthState
+	if err := st.Get("health-history", &history); err != nil && !errors.Is(err, state.ErrNoState) {
+		return nil, err
+	}
+	return history[snap], nil
+}
@@ -0,0 +1,255 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+)
+
+const (
+	restartOnErrorOpt = "resilience.health.restart-on-error"
+	revertOnErrorOpt  = "resilience.health.revert-on-error"
+	checkIntervalOpt  = "resilience.health.check-interval"
+	checkTimeoutOpt   = "resilience.health.check-timeout"
+
+	// defaultRevertErrorCount is the number of consecutive error
+	// reports after which a snap listed in revertOnErrorOpt without
+	// an explicit count is reverted.
+	defaultRevertErrorCount = 3
+)
+
+const (
+	restartAction = "restart"
+	revertAction  = "revert"
+)
+
+// healthPolicy is the combination of the health policy declared by
+// the snap and the one set in the core configuration.
+type healthPolicy struct {
+	restart     bool
+	revertAfter int
+}
+
+func snapHealthPolicy(st *state.State, info *snap.Info) (healthPolicy, error) {
+	var policy healthPolicy
+	if info.Health != nil {
+		policy.restart = info.Health.RestartOnError
+		policy.revertAfter = info.Health.RevertOnError
+	}
+
+	tr := config.NewTransaction(st)
+	var restart, revert string
+	if err := tr.Get("core", restartOnErrorOpt, &restart); err != nil && !config.IsNoOption(err) {
+		return policy, err
+	}
+	if err := tr.Get("core", revertOnErrorOpt, &revert); err != nil && !config.IsNoOption(err) {
+		return policy, err
+	}
+
+	name := info.InstanceName()
+	if restart != "" {
+		for _, instanceName := range strings.Split(restart, ",") {
+			if instanceName == name {
+				policy.restart = true
+			}
+		}
+	}
+	if revert != "" {
+		for _, entry := range strings.Split(revert, ",") {
+			instanceName, count, hasCount := strings.Cut(entry, ":")
+			if instanceName != name {
+				continue
+			}
+			policy.revertAfter = defaultRevertErrorCount
+			if hasCount {
+				// the count is validated by configcore
+				if n, err := strconv.Atoi(count); err == nil && n > 0 {
+					policy.revertAfter = n
+				}
+			}
+		}
+	}
+
+	return policy, nil
+}
+
+// checkIntervals holds the configured intervals of the periodic
+// health checks.
+type checkIntervals struct {
+	// all is the interval for the snaps without a more specific one
+	all   time.Duration
+	snaps map[string]time.Duration
+}
+
+func configuredCheckIntervals(st *state.State) (*checkIntervals, error) {
+	var opt string
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", checkIntervalOpt, &opt); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+
+	intervals := &checkIntervals{snaps: make(map[string]time.Duration)}
+	if opt == "" {
+		return intervals, nil
+	}
+	for _, entry := range strings.Split(opt, ",") {
+		// the intervals are validated by configcore
+		instanceName, value, hasName := strings.Cut(entry, ":")
+		if !hasName {
+			value = entry
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			continue
+		}
+		if hasName {
+			intervals.snaps[instanceName] = d
+		} else {
+			intervals.all = d
+		}
+	}
+	return intervals, nil
+}
+
+// forSnap returns the interval of the periodic health checks of the
+// given snap, 0 if it should not be checked periodically. An interval
+// configured for the snap takes precedence over the one declared by
+// the snap, which takes precedence over the one for all snaps.
+func (ci *checkIntervals) forSnap(info *snap.Info) time.Duration {
+	if d, ok := ci.snaps[info.InstanceName()]; ok {
+		return d
+	}
+	if info.Health != nil && info.Health.CheckInterval != 0 {
+		return time.Duration(info.Health.CheckInterval)
+	}
+	return ci.all
+}
+
+func configuredCheckTimeout(st *state.State) (time.Duration, error) {
+	var opt string
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", checkTimeoutOpt, &opt); err != nil && !config.IsNoOption(err) {
+		return 0, err
+	}
+	if opt == "" {
+		return checkTimeout, nil
+	}
+	return time.ParseDuration(opt)
+}
+
+// healthTracking keeps track of the health reports of the current
+// revision of a snap, and of the action pending because of them.
+type healthTracking struct {
+	Revision   snap.Revision `json:"revision"`
+	ErrorCount int           `json:"error-count,omitempty"`
+	SeenOkay   bool          `json:"seen-okay,omitempty"`
+	Action     string        `json:"action,omitempty"`
+	// Change is the ID of the change of the last action taken, which
+	// must be ready before taking another one.
+	Change string `json:"change,omitempty"`
+}
+
+func allTracking(st *state.State) (map[string]*healthTracking, error) {
+	var tracked map[string]*healthTracking
+	if err := st.Get("health-tracking", &tracked); err != nil {
+		if !errors.Is(err, state.ErrNoState) {
+			return nil, err
+		}
+		tracked = map[string]*healthTracking{}
+	}
+	return tracked, nil
+}
+
+// trackHealth records the given health report of the snap and decides
+// whether an action needs to be taken because of it. Reverting is only
+// considered for the latest revision of a snap that never reported
+// being okay, to not revert snaps that broke at runtime, nor revert
+// repeatedly. Services are restarted only when the snap starts
+// reporting an error, to avoid restart loops.
+func trackHealth(st *state.State, instanceName string, health *HealthState) error {
+	var snapst snapstate.SnapState
+	if err := snapstate.Get(st, instanceName, &snapst); err != nil {
+		if errors.Is(err, state.ErrNoState) {
+			return nil
+		}
+		return err
+	}
+	if !snapst.IsInstalled() {
+		return nil
+	}
+
+	tracked, err := allTracking(st)
+	if err != nil {
+		return err
+	}
+	tracking := tracked[instanceName]
+	if tracking == nil || tracking.Revision != snapst.Current {
+		var lastChange string
+		if tracking != nil {
+			lastChange = tracking.Change
+		}
+		tracking = &healthTracking{Revision: snapst.Current, Change: lastChange}
+		tracked[instanceName] = tracking
+	}
+
+	switch health.Status {
+	case OkayStatus:
+		tracking.SeenOkay = true
+		tracking.ErrorCount = 0
+	case ErrorStatus:
+		tracking.ErrorCount++
+	default:
+		tracking.ErrorCount = 0
+	}
+
+	if health.Status == ErrorStatus && tracking.Action == "" {
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return err
+		}
+		policy, err := snapHealthPolicy(st, info)
+		if err != nil {
+			return err
+		}
+
+		seq := snapst.Sequence.Revisions
+		isLatest := len(seq) > 1 && snapst.LastIndex(snapst.Current) == len(seq)-1
+		switch {
+		case policy.revertAfter > 0 && isLatest && !tracking.SeenOkay && tracking.ErrorCount >= policy.revertAfter:
+			tracking.Action = revertAction
+		case policy.restart && tracking.ErrorCount == 1:
+			tracking.Action = restartAction
+		}
+		if tracking.Action != "" {
+			st.EnsureBefore(0)
+		}
+	}
+
+	st.Set("health-tracking", tracked)
+	return nil
+}
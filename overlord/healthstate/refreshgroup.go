@@ -0,0 +1,437 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/progress"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/systemd"
+)
+
+var (
+	snapstateUpdateMany       = snapstate.UpdateMany
+	snapstateRevertToRevision = snapstate.RevertToRevision
+
+	servicesStatus = func(units []string) ([]*systemd.UnitStatus, error) {
+		return systemd.New(systemd.SystemMode, progress.Null).Status(units)
+	}
+)
+
+const (
+	// soakCheckInterval is how often the health of the snaps of a
+	// refresh group is checked during its soak period.
+	soakCheckInterval = time.Minute
+	// finalChecksPollInterval is how often to look whether the
+	// check-health hooks run at the end of the soak period are done.
+	finalChecksPollInterval = 5 * time.Second
+)
+
+// refreshGroup holds the state of snaps refreshed as a group, all of
+// which are reverted if any of them fails its health checks during
+// the soak period following the refresh.
+type refreshGroup struct {
+	// Revisions are the revisions of the snaps before the refresh,
+	// to revert to.
+	Revisions  map[string]snap.Revision `json:"revisions"`
+	SoakPeriod time.Duration            `json:"soak-period"`
+	// SoakStart is when the soak period started, after the snaps
+	// were refreshed.
+	SoakStart time.Time `json:"soak-start"`
+	// Services are the units of the services of the snaps that were
+	// running at the start of the soak period, and must keep running.
+	Services []string `json:"services,omitempty"`
+	// SoakEnded is set once the soak period passed, when the
+	// check-health hooks of the snaps are run a last time.
+	SoakEnded   bool     `json:"soak-ended,omitempty"`
+	FinalChecks []string `json:"final-checks,omitempty"`
+	// Failure is why the snaps are being reverted, once they are
+	// deemed unhealthy, and Reverts are the IDs of the tasks doing it.
+	Failure string   `json:"failure,omitempty"`
+	Reverts []string `json:"reverts,omitempty"`
+}
+
+func (g *refreshGroup) names() []string {
+	names := make([]string, 0, len(g.Revisions))
+	for name := range g.Revisions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RefreshGroup returns the tasks to refresh the given snaps, or all
+// snaps if none is given, as a group. The refreshes are a single
+// transaction, followed by a soak period of the given duration during
+// which the health of the refreshed snaps is watched. If any of them
+// reports an error, fails its check-health hook, or has a service that
+// stops running, all of them are reverted to the revisions they had
+// before the refresh, as part of the same change, which then ends in
+// error.
+// Note that the state must be locked by the caller.
+func RefreshGroup(ctx context.Context, st *state.State, names []string, soakPeriod time.Duration, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+	if soakPeriod <= 0 {
+		return nil, nil, fmt.Errorf("internal error: invalid soak period %s", soakPeriod)
+	}
+	if flags == nil {
+		flags = &snapstate.Flags{}
+	}
+	groupFlags := *flags
+	groupFlags.Transaction = client.TransactionAllSnaps
+
+	// remember the revisions to revert to, before they change
+	snapStates, err := snapstate.All(st)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated, tss, err := snapstateUpdateMany(ctx, st, names, nil, userID, &groupFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(updated) == 0 {
+		return updated, tss, nil
+	}
+
+	group := &refreshGroup{
+		Revisions:  make(map[string]snap.Revision, len(updated)),
+		SoakPeriod: soakPeriod,
+	}
+	for _, name := range updated {
+		snapst := snapStates[name]
+		if snapst == nil {
+			return nil, nil, fmt.Errorf("internal error: cannot find refreshed snap %q", name)
+		}
+		group.Revisions[name] = snapst.Current
+	}
+
+	summary := fmt.Sprintf(i18n.G("Watch health of snaps %s for %s"), strutil.Quoted(updated), soakPeriod)
+	soak := st.NewTask("soak-refresh-group", summary)
+	soak.Set("refresh-group", group)
+	// in its own lane, so that it failing after the reverts are done
+	// does not undo anything else
+	soak.JoinLane(st.NewLane())
+	for _, ts := range tss {
+		soak.WaitAll(ts)
+	}
+
+	return updated, append(tss, state.NewTaskSet(soak)), nil
+}
+
+func refreshGroupAffectedSnaps(t *state.Task) ([]string, error) {
+	var group refreshGroup
+	if err := t.Get("refresh-group", &group); err != nil {
+		return nil, fmt.Errorf("internal error: cannot get refresh group: %v", err)
+	}
+	return group.names(), nil
+}
+
+// soakingChange returns the ID of the change of the refresh group of
+// the snap, if the snaps of the group are only being watched, with
+// nothing else in the change left to run.
+func soakingChange(st *state.State, name string) string {
+	for _, chg := range st.Changes() {
+		if chg.IsReady() {
+			continue
+		}
+		var soak *state.Task
+		busy := false
+		for _, t := range chg.Tasks() {
+			if t.Kind() == "soak-refresh-group" && t.Status() == state.DoingStatus {
+				soak = t
+				continue
+			}
+			if !t.Status().Ready() {
+				busy = true
+				break
+			}
+		}
+		if soak == nil || busy {
+			continue
+		}
+		names, err := refreshGroupAffectedSnaps(soak)
+		if err == nil && strutil.ListContains(names, name) {
+			return chg.ID()
+		}
+	}
+	return ""
+}
+
+func (m *HealthManager) doSoakRefreshGroup(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var group refreshGroup
+	if err := t.Get("refresh-group", &group); err != nil {
+		return err
+	}
+
+	if group.Failure != "" {
+		for _, id := range group.Reverts {
+			if revert := st.Task(id); revert != nil && !revert.Status().Ready() {
+				return &state.Retry{After: finalChecksPollInterval}
+			}
+		}
+		return fmt.Errorf("reverted refreshed snaps %s: %s", strutil.Quoted(group.names()), group.Failure)
+	}
+
+	now := timeNow()
+	if group.SoakStart.IsZero() {
+		services, err := runningServices(st, &group)
+		if err != nil {
+			return err
+		}
+		group.SoakStart = now
+		group.Services = services
+		t.Set("refresh-group", &group)
+		t.Logf("Watching health of snaps %s until %s", strutil.Quoted(group.names()), now.Add(group.SoakPeriod).Format(time.RFC3339))
+	}
+
+	failure, err := refreshGroupFailure(st, &group)
+	if err != nil {
+		return err
+	}
+	if failure != "" {
+		if err := m.revertGroup(t, &group, failure); err != nil {
+			return err
+		}
+		return &state.Retry{After: finalChecksPollInterval}
+	}
+
+	soakEnd := group.SoakStart.Add(group.SoakPeriod)
+	if now.Before(soakEnd) {
+		after := soakCheckInterval
+		if left := soakEnd.Sub(now); left < after {
+			after = left
+		}
+		return &state.Retry{After: after}
+	}
+
+	if !group.SoakEnded {
+		// run the check-health hooks a last time
+		group.SoakEnded = true
+		group.FinalChecks = addFinalChecks(t, &group)
+		t.Set("refresh-group", &group)
+	}
+	for _, id := range group.FinalChecks {
+		if check := st.Task(id); check != nil && !check.Status().Ready() {
+			return &state.Retry{After: finalChecksPollInterval}
+		}
+	}
+
+	t.Logf("Snaps %s stayed healthy for %s", strutil.Quoted(group.names()), group.SoakPeriod)
+	return nil
+}
+
+// runningServices returns the units of the system services of the
+// snaps of the group that are running.
+func runningServices(st *state.State, group *refreshGroup) ([]string, error) {
+	var units []string
+	for _, name := range group.names() {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, name, &snapst); err != nil {
+			return nil, err
+		}
+		if !snapst.Active {
+			continue
+		}
+		info, err := snapst.CurrentInfo()
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range info.Services() {
+			if app.DaemonScope == snap.SystemDaemon {
+				units = append(units, app.ServiceName())
+			}
+		}
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+
+	sts, err := servicesStatus(units)
+	if err != nil {
+		return nil, err
+	}
+	var running []string
+	for _, status := range sts {
+		if status.Active {
+			running = append(running, status.Name)
+		}
+	}
+	return running, nil
+}
+
+// refreshGroupFailure returns why the snaps of the group are deemed
+// unhealthy, if they are.
+func refreshGroupFailure(st *state.State, group *refreshGroup) (string, error) {
+	healths, err := All(st)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range group.names() {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, name, &snapst); err != nil {
+			return "", err
+		}
+		health := healths[name]
+		if health == nil || health.Revision != snapst.Current {
+			continue
+		}
+		if health.Status == ErrorStatus {
+			return fmt.Sprintf("snap %q reported an error: %s", name, health.Message), nil
+		}
+	}
+
+	for _, id := range group.FinalChecks {
+		if check := st.Task(id); check != nil && check.Status() == state.ErrorStatus {
+			var hooksup hookstate.HookSetup
+			check.Get("hook-setup", &hooksup)
+			return fmt.Sprintf("check-health hook of snap %q failed", hooksup.Snap), nil
+		}
+	}
+
+	if len(group.Services) == 0 {
+		return "", nil
+	}
+	sts, err := servicesStatus(group.Services)
+	if err != nil {
+		return "", err
+	}
+	for _, status := range sts {
+		if !status.Active {
+			return fmt.Sprintf("service %q is not running", status.Name), nil
+		}
+	}
+	return "", nil
+}
+
+// addFinalChecks adds to the change of the soak task the check-health
+// hooks of the snaps of the group, returning their task IDs. The hooks
+// are in their own lane, so that one failing does not abort the rest of
+// the change.
+func addFinalChecks(t *state.Task, group *refreshGroup) []string {
+	st := t.State()
+	chg := t.Change()
+	lane := st.NewLane()
+
+	var ids []string
+	for _, name := range group.names() {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, name, &snapst); err != nil || !snapst.Active {
+			continue
+		}
+		info, err := snapst.CurrentInfo()
+		if err != nil || info.Hooks["check-health"] == nil {
+			continue
+		}
+		check := Hook(st, name, snapst.Current)
+		check.JoinLane(lane)
+		chg.AddTask(check)
+		ids = append(ids, check.ID())
+	}
+	if len(ids) > 0 {
+		st.EnsureBefore(0)
+	}
+	return ids
+}
+
+// revertGroup adds to the change of the soak task the reverts of all
+// the snaps of the group to their revisions before the refresh. The
+// soak task fails once they are done.
+func (m *HealthManager) revertGroup(t *state.Task, group *refreshGroup, failure string) error {
+	st := t.State()
+	chg := t.Change()
+
+	var tss []*state.TaskSet
+	var reverted []string
+	for _, name := range group.names() {
+		var snapst snapstate.SnapState
+		if err := snapstate.Get(st, name, &snapst); err != nil {
+			if errors.Is(err, state.ErrNoState) {
+				// removed in the meantime
+				continue
+			}
+			return err
+		}
+		rev := group.Revisions[name]
+		if snapst.Current == rev {
+			continue
+		}
+		ts, err := snapstateRevertToRevision(st, name, rev, snapstate.Flags{}, chg.ID())
+		if isConflict(err) {
+			logger.Debugf("cannot revert refresh group yet: %v", err)
+			return &state.Retry{After: checkRetryDelay}
+		}
+		if err != nil {
+			return fmt.Errorf("cannot revert snap %q: %v", name, err)
+		}
+		tss = append(tss, ts)
+		reverted = append(reverted, name)
+	}
+
+	group.Failure = failure
+	for _, ts := range tss {
+		chg.AddAll(ts)
+		for _, revert := range ts.Tasks() {
+			group.Reverts = append(group.Reverts, revert.ID())
+		}
+	}
+	t.Set("refresh-group", group)
+	if err := clearTrackingActions(st, reverted); err != nil {
+		return err
+	}
+
+	logger.Noticef("Reverting refreshed snaps %s: %s", strutil.Quoted(reverted), failure)
+	t.Logf("Reverting refreshed snaps %s: %s", strutil.Quoted(reverted), failure)
+	st.EnsureBefore(0)
+	return nil
+}
+
+// clearTrackingActions clears the actions pending because of the
+// health of the given snaps, as reverting them supersedes those.
+func clearTrackingActions(st *state.State, names []string) error {
+	tracked, err := allTracking(st)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if tracking := tracked[name]; tracking != nil {
+			tracking.Action = ""
+		}
+	}
+	st.Set("health-tracking", tracked)
+	return nil
+}
@@ -0,0 +1,351 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package healthstate_test
+
+import (
+	"context"
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/overlord/healthstate"
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/systemd"
+)
+
+type revertedTo struct {
+	name       string
+	rev        snap.Revision
+	fromChange string
+}
+
+// startRefreshGroup refreshes test-snap from revision 1 to 2 as a group
+// and returns the task of the soak period, ready to run.
+func (s *healthMgrSuite) startRefreshGroup(c *check.C, yaml string) *state.Task {
+	s.mockSnap(c, yaml, snap.R(1))
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, tss, err := healthstate.RefreshGroup(context.Background(), s.state, nil, 10*time.Minute, 0, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(tss, check.HasLen, 2)
+
+	chg := s.state.NewChange("refresh-snap", "...")
+	for _, ts := range tss {
+		chg.AddAll(ts)
+	}
+	tss[0].Tasks()[0].SetStatus(state.DoneStatus)
+	soak := tss[1].Tasks()[0]
+
+	// the refresh is done
+	s.state.Unlock()
+	s.mockSnap(c, yaml, snap.R(2))
+	s.state.Lock()
+
+	return soak
+}
+
+func (s *healthMgrSuite) mockRefreshGroup(c *check.C) (reverted *[]revertedTo, services map[string]bool) {
+	s.AddCleanup(healthstate.MockSnapstateUpdateMany(func(ctx context.Context, st *state.State, names []string, revOpts []*snapstate.RevisionOptions, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		c.Check(flags.Transaction, check.Equals, client.TransactionAllSnaps)
+		return []string{"test-snap"}, []*state.TaskSet{state.NewTaskSet(st.NewTask("fake-refresh", "fake refresh"))}, nil
+	}))
+
+	reverted = &[]revertedTo{}
+	s.AddCleanup(healthstate.MockSnapstateRevertToRevision(func(st *state.State, name string, rev snap.Revision, flags snapstate.Flags, fromChange string) (*state.TaskSet, error) {
+		*reverted = append(*reverted, revertedTo{name, rev, fromChange})
+		return state.NewTaskSet(st.NewTask("fake-revert", "fake revert")), nil
+	}))
+
+	services = map[string]bool{"snap.test-snap.svc.service": true}
+	s.AddCleanup(healthstate.MockServicesStatus(func(units []string) ([]*systemd.UnitStatus, error) {
+		var sts []*systemd.UnitStatus
+		for _, unit := range units {
+			sts = append(sts, &systemd.UnitStatus{Name: unit, Active: services[unit]})
+		}
+		return sts, nil
+	}))
+
+	return reverted, services
+}
+
+func (s *healthMgrSuite) soak(c *check.C, t *state.Task) (retryAfter time.Duration) {
+	err := healthstate.DoSoakRefreshGroup(s.mgr, t)
+	if retry, ok := err.(*state.Retry); ok {
+		return retry.After
+	}
+	c.Assert(err, check.IsNil)
+	return 0
+}
+
+func (s *healthMgrSuite) TestRefreshGroup(c *check.C) {
+	s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	c.Check(soak.Kind(), check.Equals, "soak-refresh-group")
+	c.Check(soak.Summary(), check.Equals, `Watch health of snaps "test-snap" for 10m0s`)
+	c.Assert(soak.WaitTasks(), check.HasLen, 1)
+	c.Check(soak.WaitTasks()[0].Kind(), check.Equals, "fake-refresh")
+
+	// the snaps cannot be changed during the soak period
+	err := snapstate.CheckChangeConflict(s.state, "test-snap", nil)
+	c.Check(err, check.ErrorMatches, `snap "test-snap" has "refresh-snap" change in progress`)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupPeriodicChecks(c *check.C) {
+	s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYamlWithHook)
+	s.setConfig(c, "resilience.health.check-interval", "1h")
+
+	// the snap is not checked before the refresh is done
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 0)
+
+	// but it is while its health is watched
+	s.state.Lock()
+	soak.SetStatus(state.DoingStatus)
+	s.state.Unlock()
+	s.now = s.now.Add(time.Minute)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+
+	// and not once it's being reverted
+	chgs := s.checkChanges(c)
+	s.state.Lock()
+	for _, t := range chgs[0].Tasks() {
+		t.SetStatus(state.DoneStatus)
+	}
+	soak.Change().AddTask(s.state.NewTask("fake-revert", "fake revert"))
+	s.state.Unlock()
+	s.now = s.now.Add(2 * time.Hour)
+	c.Assert(s.mgr.Ensure(), check.IsNil)
+	c.Check(s.checkChanges(c), check.HasLen, 1)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupNoUpdates(c *check.C) {
+	s.AddCleanup(healthstate.MockSnapstateUpdateMany(func(ctx context.Context, st *state.State, names []string, revOpts []*snapstate.RevisionOptions, userID int, flags *snapstate.Flags) ([]string, []*state.TaskSet, error) {
+		return nil, nil, nil
+	}))
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	updated, tss, err := healthstate.RefreshGroup(context.Background(), s.state, []string{"test-snap"}, time.Hour, 0, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(updated, check.HasLen, 0)
+	c.Check(tss, check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupStaysHealthy(c *check.C) {
+	reverted, _ := s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+
+	s.now = s.now.Add(9*time.Minute + 30*time.Second)
+	c.Check(s.soak(c, soak), check.Equals, 30*time.Second)
+
+	s.report(c, healthstate.OkayStatus)
+	s.now = s.now.Add(30 * time.Second)
+	c.Check(s.soak(c, soak), check.Equals, time.Duration(0))
+	c.Check(*reverted, check.HasLen, 0)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(soak.Change().Tasks(), check.HasLen, 2)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupRevertsOnErrorReport(c *check.C) {
+	reverted, _ := s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+
+	s.report(c, healthstate.ErrorStatus)
+	s.now = s.now.Add(time.Minute)
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+
+	s.state.Lock()
+	chg := soak.Change()
+	c.Check(*reverted, check.DeepEquals, []revertedTo{{"test-snap", snap.R(1), chg.ID()}})
+	var revert *state.Task
+	for _, t := range chg.Tasks() {
+		if t.Kind() == "fake-revert" {
+			revert = t
+		}
+	}
+	c.Assert(revert, check.NotNil)
+	c.Check(revert.WaitTasks(), check.HasLen, 0)
+	c.Check(soak.Log(), check.HasLen, 2)
+	c.Check(soak.Log()[1], check.Matches, `.* INFO Reverting refreshed snaps "test-snap": snap "test-snap" reported an error: `)
+	s.state.Unlock()
+
+	// the soak task waits for the reverts to be done
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+
+	s.state.Lock()
+	revert.SetStatus(state.DoneStatus)
+	s.state.Unlock()
+
+	err := healthstate.DoSoakRefreshGroup(s.mgr, soak)
+	c.Check(err, check.ErrorMatches, `reverted refreshed snaps "test-snap": snap "test-snap" reported an error: `)
+	c.Check(*reverted, check.HasLen, 1)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupRevertChangeError(c *check.C) {
+	s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+	s.runner.AddHandler("fake-revert", func(t *state.Task, _ *tomb.Tomb) error { return nil }, nil)
+
+	s.report(c, healthstate.ErrorStatus)
+	for i := 0; i < 5; i++ {
+		// do not wait for the retries
+		s.state.Lock()
+		soak.At(time.Time{})
+		s.state.Unlock()
+		s.runner.Ensure()
+		s.runner.Wait()
+	}
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	chg := soak.Change()
+	c.Check(soak.Status(), check.Equals, state.ErrorStatus)
+	for _, t := range chg.Tasks() {
+		if t.Kind() == "fake-revert" {
+			// the reverts are not undone
+			c.Check(t.Status(), check.Equals, state.DoneStatus)
+		}
+	}
+	c.Check(chg.Status(), check.Equals, state.ErrorStatus)
+	c.Check(chg.Err(), check.ErrorMatches, `(?s).*reverted refreshed snaps "test-snap": snap "test-snap" reported an error: .*`)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupRevertsWhenServiceStops(c *check.C) {
+	reverted, services := s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+	c.Check(*reverted, check.HasLen, 0)
+
+	services["snap.test-snap.svc.service"] = false
+	s.now = s.now.Add(time.Minute)
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+	c.Check(*reverted, check.HasLen, 1)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(soak.Log()[1], check.Matches, `.* INFO Reverting refreshed snaps "test-snap": service "snap.test-snap.svc.service" is not running`)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupIgnoresServicesNotRunningInitially(c *check.C) {
+	reverted, services := s.mockRefreshGroup(c)
+	services["snap.test-snap.svc.service"] = false
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+	s.now = s.now.Add(10 * time.Minute)
+	c.Check(s.soak(c, soak), check.Equals, time.Duration(0))
+	c.Check(*reverted, check.HasLen, 0)
+}
+
+const healthMgrSnapYamlWithHook = healthMgrSnapYaml + `hooks:
+  check-health:
+`
+
+func (s *healthMgrSuite) TestRefreshGroupFinalChecks(c *check.C) {
+	reverted, _ := s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYamlWithHook)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+	s.now = s.now.Add(10 * time.Minute)
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+
+	s.state.Lock()
+	var hook *state.Task
+	for _, t := range soak.Change().Tasks() {
+		if t.Kind() == "run-hook" {
+			hook = t
+		}
+	}
+	c.Assert(hook, check.NotNil)
+	var hooksup hookstate.HookSetup
+	c.Assert(hook.Get("hook-setup", &hooksup), check.IsNil)
+	c.Check(hooksup.Hook, check.Equals, "check-health")
+	c.Check(hooksup.Revision, check.Equals, snap.R(2))
+	c.Check(hook.Lanes(), check.HasLen, 1)
+	s.state.Unlock()
+
+	// still running
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+
+	s.state.Lock()
+	hook.SetStatus(state.ErrorStatus)
+	chgID := soak.Change().ID()
+	s.state.Unlock()
+
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+	c.Check(*reverted, check.DeepEquals, []revertedTo{{"test-snap", snap.R(1), chgID}})
+}
+
+func (s *healthMgrSuite) TestRefreshGroupFinalChecksPass(c *check.C) {
+	reverted, _ := s.mockRefreshGroup(c)
+	soak := s.startRefreshGroup(c, healthMgrSnapYamlWithHook)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+	s.now = s.now.Add(10 * time.Minute)
+	c.Check(s.soak(c, soak), check.Equals, 5*time.Second)
+
+	s.state.Lock()
+	for _, t := range soak.Change().Tasks() {
+		if t.Kind() == "run-hook" {
+			t.SetStatus(state.DoneStatus)
+		}
+	}
+	s.state.Unlock()
+
+	c.Check(s.soak(c, soak), check.Equals, time.Duration(0))
+	c.Check(*reverted, check.HasLen, 0)
+}
+
+func (s *healthMgrSuite) TestRefreshGroupRevertConflictRetried(c *check.C) {
+	s.mockRefreshGroup(c)
+	s.AddCleanup(healthstate.MockSnapstateRevertToRevision(func(st *state.State, name string, rev snap.Revision, flags snapstate.Flags, fromChange string) (*state.TaskSet, error) {
+		return nil, &snapstate.ChangeConflictError{Snap: name, ChangeKind: "refresh"}
+	}))
+	soak := s.startRefreshGroup(c, healthMgrSnapYaml)
+
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+	s.report(c, healthstate.ErrorStatus)
+	c.Check(s.soak(c, soak), check.Equals, time.Minute)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	c.Check(soak.Change().Tasks(), check.HasLen, 2)
+}
@@ -40,6 +40,7 @@ import (
 	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/testutil"
 )
@@ -557,7 +558,7 @@ plugs:
     interface: registry
     account: %[1]s
     view: network/write-wifi
-    role: manager
+    role: custodian
 `, s.devAccID)
 	info := mockInstalledSnap(c, s.state, snapYaml, "")
 
@@ -589,14 +590,43 @@ slots:
 	c.Assert(err, IsNil)
 }
 
-func (s *registrySuite) TestRegistryGetSingleView(c *C) {
+// setViaView commits the requests directly, without running any registry
+// hooks of the snaps whose views are affected.
+func (s *registrySuite) setViaView(c *C, viewName string, requests map[string]interface{}) {
 	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{
-		"ssid": "my-ssid",
+	defer s.state.Unlock()
+
+	registryAssert, err := assertstate.Registry(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	reg := registryAssert.Registry()
+
+	var databags map[string]map[string]registry.JSONDataBag
+	err = s.state.Get("registry-databags", &databags)
+	if err != nil {
+		c.Assert(err, testutil.ErrorIs, state.ErrNoState)
+	}
+	bag := databags[s.devAccID]["network"]
+	if bag == nil {
+		bag = registry.NewJSONDataBag()
+	}
+
+	tx, err := registry.NewTransaction(reg, func() (registry.JSONDataBag, error) {
+		return bag, nil
+	}, func(bag registry.JSONDataBag) error {
+		s.state.Set("registry-databags", map[string]map[string]registry.JSONDataBag{s.devAccID: {"network": bag}})
+		return nil
 	})
-	s.state.Unlock()
 	c.Assert(err, IsNil)
 
+	c.Assert(registrystate.SetViaViewInTx(tx, reg.View(viewName), requests), IsNil)
+	c.Assert(tx.Commit(), IsNil)
+}
+
+func (s *registrySuite) TestRegistryGetSingleView(c *C) {
+	s.setViaView(c, "write-wifi", map[string]interface{}{
+		"ssid": "my-ssid",
+	})
+
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"get", "--view", ":read-wifi", "ssid"}, 0)
 	c.Assert(err, IsNil)
 	c.Check(string(stdout), Equals, "my-ssid\n")
@@ -604,13 +634,10 @@ func (s *registrySuite) TestRegistryGetSingleView(c *C) {
 }
 
 func (s *registrySuite) TestRegistryGetManyViews(c *C) {
-	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{
+	s.setViaView(c, "write-wifi", map[string]interface{}{
 		"ssid":     "my-ssid",
 		"password": "secret",
 	})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
 
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"get", "--view", ":read-wifi", "ssid", "password"}, 0)
 	c.Assert(err, IsNil)
@@ -623,13 +650,10 @@ func (s *registrySuite) TestRegistryGetManyViews(c *C) {
 }
 
 func (s *registrySuite) TestRegistryGetNoRequest(c *C) {
-	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{
+	s.setViaView(c, "write-wifi", map[string]interface{}{
 		"ssid":     "my-ssid",
 		"password": "secret",
 	})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
 
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"get", "--view", ":read-wifi"}, 0)
 	c.Assert(err, IsNil)
@@ -642,12 +666,9 @@ func (s *registrySuite) TestRegistryGetNoRequest(c *C) {
 }
 
 func (s *registrySuite) TestRegistryGetHappensTransactionally(c *C) {
-	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{
+	s.setViaView(c, "write-wifi", map[string]interface{}{
 		"ssid": "my-ssid",
 	})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
 
 	// registry transaction is created when snapctl runs for the first time
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"get", "--view", ":read-wifi"}, 0)
@@ -658,12 +679,9 @@ func (s *registrySuite) TestRegistryGetHappensTransactionally(c *C) {
 `)
 	c.Check(stderr, IsNil)
 
-	s.state.Lock()
-	err = registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{
+	s.setViaView(c, "write-wifi", map[string]interface{}{
 		"ssid": "other-ssid",
 	})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
 
 	// the new write wasn't reflected because it didn't run in the same transaction
 	stdout, stderr, err = ctlcmd.Run(s.mockContext, []string{"get", "--view", ":read-wifi"}, 0)
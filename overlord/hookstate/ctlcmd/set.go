@@ -234,13 +234,17 @@ func setRegistryValues(ctx *hookstate.Context, plugName string, requests map[str
 		return fmt.Errorf("cannot set registry: %v", err)
 	}
 
+	// only change-view hooks can modify the changes being committed and only
+	// load-view hooks can provide the data being read
+	if !ctx.IsEphemeral() && registrystate.IsRegistryHook(ctx.HookName()) &&
+		!strings.HasPrefix(ctx.HookName(), "change-view-") && !strings.HasPrefix(ctx.HookName(), "load-view-") {
+		return fmt.Errorf(i18n.G("cannot modify registry in %q hook"), ctx.HookName())
+	}
+
 	tx, err := registrystate.RegistryTransaction(ctx, view.Registry())
 	if err != nil {
 		return err
 	}
 
-	// TODO: once we have hooks, check that we don't set values in the wrong hooks
-	// (e.g., "registry-changed" hooks can only read data)
-
 	return registrystate.SetViaViewInTx(tx, view, requests)
 }
@@ -27,12 +27,14 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/ctlcmd"
 	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
 	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
 	"github.com/snapcore/snapd/snap"
 )
 
@@ -509,3 +511,76 @@ func (s *registrySuite) TestRegistrySetExclamationMark(c *C) {
 `)
 	c.Check(stderr, IsNil)
 }
+
+func (s *registrySuite) TestRegistrySetNotAllowedInSaveOrObserveHooks(c *C) {
+	for _, hook := range []string{"save-view-write-wifi", "observe-view-read-wifi"} {
+		s.state.Lock()
+		task := s.state.NewTask("run-hook", "")
+		setup := &hookstate.HookSetup{Snap: "test-snap", Revision: snap.R(1), Hook: hook}
+		s.state.Unlock()
+
+		ctx, err := hookstate.NewContext(task, s.state, setup, s.mockHandler, "")
+		c.Assert(err, IsNil)
+
+		stdout, stderr, err := ctlcmd.Run(ctx, []string{"set", "--view", ":write-wifi", "ssid=other-ssid"}, 0)
+		c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot modify registry in %q hook`, hook))
+		c.Check(stdout, IsNil)
+		c.Check(stderr, IsNil)
+	}
+}
+
+func (s *registrySuite) TestRegistrySetInLoadViewHook(c *C) {
+	s.state.Lock()
+	registryAssert, err := assertstate.Registry(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	reg := registryAssert.Registry()
+
+	tx, err := registry.NewTransaction(reg, func() (registry.JSONDataBag, error) {
+		return registry.NewJSONDataBag(), nil
+	}, func(registry.JSONDataBag) error {
+		err := fmt.Errorf("unexpected commit")
+		c.Error(err)
+		return err
+	})
+	c.Assert(err, IsNil)
+
+	readTask := s.state.NewTask("read-registry-view", "")
+	readTask.Set("registry-transaction", tx)
+
+	task := s.state.NewTask("run-hook", "")
+	task.Set("tx-task", readTask.ID())
+	chg := s.state.NewChange("get-registry-view", "")
+	chg.AddTask(task)
+	chg.AddTask(readTask)
+	setup := &hookstate.HookSetup{Snap: "test-snap", Revision: snap.R(1), Hook: "load-view-write-wifi"}
+	s.state.Unlock()
+
+	ctx, err := hookstate.NewContext(task, s.state, setup, s.mockHandler, "")
+	c.Assert(err, IsNil)
+
+	stdout, stderr, err := ctlcmd.Run(ctx, []string{"set", "--view", ":write-wifi", "ssid=loaded-ssid"}, 0)
+	c.Assert(err, IsNil)
+	c.Check(stdout, IsNil)
+	c.Check(stderr, IsNil)
+
+	ctx.Lock()
+	c.Assert(ctx.Done(), IsNil)
+	ctx.Unlock()
+
+	// the loaded data is kept in the transaction being read
+	s.state.Lock()
+	defer s.state.Unlock()
+	var loadedTx *registry.Transaction
+	c.Assert(readTask.Get("registry-transaction", &loadedTx), IsNil)
+	c.Assert(loadedTx.Resume(reg, func() (registry.JSONDataBag, error) {
+		return registry.NewJSONDataBag(), nil
+	}, nil), IsNil)
+
+	val, err := loadedTx.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "loaded-ssid")
+
+	// but it isn't committed
+	_, err = registrystate.GetViaView(s.state, s.devAccID, "network", "read-wifi", []string{"ssid"})
+	c.Assert(err, ErrorMatches, ".*matching rules don't map to any values")
+}
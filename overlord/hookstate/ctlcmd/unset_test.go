@@ -163,10 +163,7 @@ func (s *unsetSuite) TestCommandWithoutContext(c *C) {
 }
 
 func (s *registrySuite) TestRegistryUnsetManyViews(c *C) {
-	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{"ssid": "my-ssid", "password": "my-secret"})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
+	s.setViaView(c, "write-wifi", map[string]interface{}{"ssid": "my-ssid", "password": "my-secret"})
 
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"unset", "--view", ":write-wifi", "ssid", "password"}, 0)
 	c.Assert(err, IsNil)
@@ -183,10 +180,7 @@ func (s *registrySuite) TestRegistryUnsetManyViews(c *C) {
 }
 
 func (s *registrySuite) TestRegistryUnsetHappensTransactionally(c *C) {
-	s.state.Lock()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "write-wifi", map[string]interface{}{"ssid": "my-ssid"})
-	s.state.Unlock()
-	c.Assert(err, IsNil)
+	s.setViaView(c, "write-wifi", map[string]interface{}{"ssid": "my-ssid"})
 
 	stdout, stderr, err := ctlcmd.Run(s.mockContext, []string{"unset", "--view", ":write-wifi", "ssid"}, 0)
 	c.Assert(err, IsNil)
@@ -0,0 +1,416 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package apparmorprompting ties together the request prompts and request
+// rules databases, handling AppArmor notification requests and replies from
+// prompt clients.
+package apparmorprompting
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/requestprompts"
+	"github.com/snapcore/snapd/interfaces/prompting/requestrules"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/apparmor/notify/listener"
+	"github.com/snapcore/snapd/snap/naming"
+)
+
+var (
+	ErrReplyNotMatchRequestedPath        = errors.New("path pattern in reply constraints does not match originally requested path")
+	ErrReplyNotMatchRequestedPermissions = errors.New("permissions in reply constraints do not include all requested permissions")
+	ErrNoSnapOrInterface                 = errors.New("cannot remove rules without snap or interface")
+)
+
+// requestsListener is the subset of the apparmor notify listener used by the
+// manager, so that it can be replaced in tests.
+type requestsListener interface {
+	Run() error
+	Reqs() <-chan *listener.Request
+	Close() error
+}
+
+var listenerRegister = func() (requestsListener, error) {
+	l, err := listener.Register()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// InterfacesRequestsManager owns the apparmor notify listener and the request
+// prompts and request rules databases, and handles requests and replies
+// concerning them.
+type InterfacesRequestsManager struct {
+	tomb     tomb.Tomb
+	state    *state.State
+	listener requestsListener
+	prompts  *requestprompts.PromptDB
+	rules    *requestrules.RuleDB
+}
+
+// New creates a new interfaces requests manager. The prompt and rule
+// databases are only opened, and the listener for apparmor notify requests
+// only registered, once the manager is started up.
+//
+// The manager is only created if prompting is enabled and supported when
+// snapd starts. Toggling the experimental.apparmor-prompting flag restarts
+// snapd, which is how the listener is started or stopped at runtime, as the
+// security profiles need to be regenerated anyway.
+func New(s *state.State) *InterfacesRequestsManager {
+	return &InterfacesRequestsManager{
+		state: s,
+	}
+}
+
+// StartUp implements StateStarterUp. It opens the prompt and rule databases
+// and registers a listener for apparmor notify requests, which are then
+// handled in the background until the manager is stopped. Notices are
+// recorded in the state whenever a prompt or rule is created, modified, or
+// removed.
+//
+// Failing to start prompting does not prevent snapd from running, requests
+// are then handled by apparmor directly and the manager is not running.
+func (m *InterfacesRequestsManager) StartUp() error {
+	if err := m.start(); err != nil {
+		logger.Noticef("cannot start AppArmor prompting: %v", err)
+	}
+	return nil
+}
+
+func (m *InterfacesRequestsManager) start() error {
+	s := m.state
+	notifyPrompt := func(userID uint32, promptID prompting.IDType, data map[string]string) error {
+		// TODO: add some sort of queue so this doesn't block
+		s.Lock()
+		defer s.Unlock()
+		options := state.AddNoticeOptions{
+			Data: data,
+		}
+		_, err := s.AddNotice(&userID, state.InterfacesRequestsPromptNotice, promptID.String(), &options)
+		return err
+	}
+	notifyRule := func(userID uint32, ruleID prompting.IDType, data map[string]string) error {
+		// TODO: add some sort of queue so this doesn't block
+		s.Lock()
+		defer s.Unlock()
+		options := state.AddNoticeOptions{
+			Data: data,
+		}
+		_, err := s.AddNotice(&userID, state.InterfacesRequestsRuleUpdateNotice, ruleID.String(), &options)
+		return err
+	}
+
+	promptsBackend, err := requestprompts.New(notifyPrompt)
+	if err != nil {
+		return fmt.Errorf("cannot open request prompts backend: %w", err)
+	}
+	rulesBackend, err := requestrules.New(notifyRule)
+	if err != nil {
+		promptsBackend.Close()
+		return fmt.Errorf("cannot open request rules backend: %w", err)
+	}
+
+	listenerBackend, err := listenerRegister()
+	if err != nil {
+		promptsBackend.Close()
+		rulesBackend.Close()
+		return fmt.Errorf("cannot register prompting listener: %w", err)
+	}
+
+	m.listener = listenerBackend
+	m.prompts = promptsBackend
+	m.rules = rulesBackend
+	m.tomb.Go(m.run)
+	return nil
+}
+
+// Running returns whether the manager was started up successfully, and is
+// thus handling requests.
+func (m *InterfacesRequestsManager) Running() bool {
+	return m.listener != nil
+}
+
+func (m *InterfacesRequestsManager) run() error {
+	m.tomb.Go(func() error {
+		err := m.listener.Run()
+		if errors.Is(err, listener.ErrClosed) {
+			return nil
+		}
+		return err
+	})
+
+	for {
+		select {
+		case req, ok := <-m.listener.Reqs():
+			if !ok {
+				// Listener has been closed, either by Stop or due to an error
+				return nil
+			}
+			if err := m.handleListenerReq(req); err != nil {
+				logger.Noticef("cannot handle apparmor notify request: %v", err)
+			}
+		case <-m.tomb.Dying():
+			return nil
+		}
+	}
+}
+
+// Ensure implements StateManager. All the work of the manager happens in
+// response to requests from the listener or from prompt clients, so there is
+// nothing to do here.
+func (m *InterfacesRequestsManager) Ensure() error {
+	return nil
+}
+
+func sendReply(req *listener.Request, allow bool) error {
+	response := &listener.Response{
+		Allow:      allow,
+		Permission: req.Permission(),
+	}
+	return req.Reply(response)
+}
+
+// handleListenerReq checks the given request against existing rules and
+// replies to it if the rules allow or deny it, otherwise creates a new prompt
+// for it, or merges it into an identical existing prompt.
+func (m *InterfacesRequestsManager) handleListenerReq(req *listener.Request) error {
+	userID := req.SubjectUID()
+	label := req.Label()
+	path := req.Path()
+
+	tag, err := naming.ParseSecurityTag(label)
+	if err != nil {
+		logger.Debugf("cannot parse apparmor label as snap security tag: %q", label)
+		return sendReply(req, false)
+	}
+	snap := tag.InstanceName()
+
+	// TODO: derive the interface from the request once apparmor supports
+	// tagging rules with the interface from which they originate.
+	iface := "home"
+
+	permissions, err := prompting.AbstractPermissionsFromAppArmorPermissions(iface, req.Permission())
+	if err != nil {
+		logger.Noticef("cannot handle request for %s: %v", path, err)
+		return sendReply(req, false)
+	}
+
+	outstandingPermissions := make([]string, 0, len(permissions))
+	for _, perm := range permissions {
+		allowed, err := m.rules.IsPathAllowed(userID, snap, iface, path, perm)
+		switch {
+		case errors.Is(err, requestrules.ErrNoMatchingRule):
+			outstandingPermissions = append(outstandingPermissions, perm)
+		case err != nil:
+			logger.Noticef("cannot check request rules for %s: %v", path, err)
+			outstandingPermissions = append(outstandingPermissions, perm)
+		case !allowed:
+			// Any permission denied by a rule means the whole request is
+			// denied.
+			return sendReply(req, false)
+		}
+	}
+	if len(outstandingPermissions) == 0 {
+		return sendReply(req, true)
+	}
+
+	metadata := &prompting.Metadata{
+		User:      userID,
+		Snap:      snap,
+		Interface: iface,
+	}
+	if _, _, err := m.prompts.AddOrMerge(metadata, path, permissions, outstandingPermissions, req); err != nil {
+		// Too many prompts for the user, request has already been denied
+		return err
+	}
+	return nil
+}
+
+// Stop implements StateStopper. It closes the listener, waits for any request
+// currently being handled, and then closes the prompt and rule databases. Any
+// outstanding prompts are cancelled.
+func (m *InterfacesRequestsManager) Stop() {
+	if !m.Running() {
+		return
+	}
+	m.tomb.Kill(nil)
+	if err := m.listener.Close(); err != nil && !errors.Is(err, listener.ErrAlreadyClosed) {
+		logger.Noticef("cannot close prompting listener: %v", err)
+	}
+	if err := m.tomb.Wait(); err != nil {
+		logger.Noticef("prompting listener stopped with error: %v", err)
+	}
+	if err := m.prompts.Close(); err != nil {
+		logger.Noticef("cannot close request prompts backend: %v", err)
+	}
+	if err := m.rules.Close(); err != nil {
+		logger.Noticef("cannot close request rules backend: %v", err)
+	}
+}
+
+// Prompts returns all outstanding prompts for the given user.
+func (m *InterfacesRequestsManager) Prompts(userID uint32) ([]*requestprompts.Prompt, error) {
+	return m.prompts.Prompts(userID)
+}
+
+// PromptWithID returns the prompt with the given ID for the given user.
+func (m *InterfacesRequestsManager) PromptWithID(userID uint32, promptID prompting.IDType) (*requestprompts.Prompt, error) {
+	return m.prompts.PromptWithID(userID, promptID)
+}
+
+// HandleReply checks that the given reply contents are valid for the prompt
+// with the given ID, and if so, replies to that prompt. If the lifespan is not
+// "single", creates a new rule from the reply and resolves any other prompts
+// which are satisfied by that rule.
+//
+// Returns the IDs of any other prompts which were satisfied by the new rule.
+func (m *InterfacesRequestsManager) HandleReply(userID uint32, promptID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) ([]prompting.IDType, error) {
+	prompt, err := m.prompts.PromptWithID(userID, promptID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := constraints.ValidateForInterface(prompt.Interface); err != nil {
+		return nil, err
+	}
+	matches, err := constraints.Match(prompt.Constraints.Path())
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return nil, fmt.Errorf("%w: %q", ErrReplyNotMatchRequestedPath, prompt.Constraints.Path())
+	}
+	if !constraints.ContainPermissions(prompt.Constraints.RemainingPermissions()) {
+		return nil, fmt.Errorf("%w: %q", ErrReplyNotMatchRequestedPermissions, prompt.Constraints.RemainingPermissions())
+	}
+
+	if lifespan == prompting.LifespanSingle {
+		if _, err := lifespan.ParseDuration(duration, prompt.Timestamp); err != nil {
+			return nil, err
+		}
+		if _, err := m.prompts.Reply(userID, promptID, outcome); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// Create the rule before replying, so that an invalid or conflicting
+	// rule leaves the prompt unresolved, and remove it again if the reply
+	// fails.
+	rule, err := m.rules.AddRule(userID, prompt.Snap, prompt.Interface, constraints, outcome, lifespan, duration)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.prompts.Reply(userID, promptID, outcome); err != nil {
+		if _, removeErr := m.rules.RemoveRule(userID, rule.ID); removeErr != nil {
+			logger.Noticef("cannot remove request rule %s after failing to reply to prompt %s: %v", rule.ID, promptID, removeErr)
+		}
+		return nil, err
+	}
+
+	metadata := &prompting.Metadata{
+		User:      userID,
+		Snap:      prompt.Snap,
+		Interface: prompt.Interface,
+	}
+	return m.prompts.HandleNewRule(metadata, constraints, outcome)
+}
+
+// Rules returns all rules for the given user, optionally filtered by the
+// given snap and interface, if they are non-empty.
+func (m *InterfacesRequestsManager) Rules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error) {
+	switch {
+	case snap != "" && iface != "":
+		return m.rules.RulesForSnapInterface(userID, snap, iface)
+	case snap != "":
+		return m.rules.RulesForSnap(userID, snap)
+	case iface != "":
+		return m.rules.RulesForInterface(userID, iface)
+	default:
+		return m.rules.Rules(userID)
+	}
+}
+
+// AddRule creates a new rule with the given contents, then resolves any
+// outstanding prompts which are satisfied by it.
+func (m *InterfacesRequestsManager) AddRule(userID uint32, snap string, iface string, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error) {
+	rule, err := m.rules.AddRule(userID, snap, iface, constraints, outcome, lifespan, duration)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &prompting.Metadata{
+		User:      userID,
+		Snap:      snap,
+		Interface: iface,
+	}
+	if _, err := m.prompts.HandleNewRule(metadata, rule.Constraints, outcome); err != nil {
+		logger.Noticef("cannot handle new rule %s for outstanding prompts: %v", rule.ID, err)
+	}
+	return rule, nil
+}
+
+// RemoveRules removes all rules for the given user which apply to the given
+// snap and/or interface. At least one of snap and interface must be given.
+func (m *InterfacesRequestsManager) RemoveRules(userID uint32, snap string, iface string) ([]*requestrules.Rule, error) {
+	switch {
+	case snap != "" && iface != "":
+		return m.rules.RemoveRulesForSnapInterface(userID, snap, iface)
+	case snap != "":
+		return m.rules.RemoveRulesForSnap(userID, snap)
+	case iface != "":
+		return m.rules.RemoveRulesForInterface(userID, iface)
+	default:
+		return nil, ErrNoSnapOrInterface
+	}
+}
+
+// RuleWithID returns the rule with the given ID for the given user.
+func (m *InterfacesRequestsManager) RuleWithID(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error) {
+	return m.rules.RuleWithID(userID, ruleID)
+}
+
+// PatchRule modifies the rule with the given ID by updating any of its
+// constraints, outcome, or lifespan and duration which are set, then resolves
+// any outstanding prompts which are satisfied by the patched rule.
+func (m *InterfacesRequestsManager) PatchRule(userID uint32, ruleID prompting.IDType, constraints *prompting.Constraints, outcome prompting.OutcomeType, lifespan prompting.LifespanType, duration string) (*requestrules.Rule, error) {
+	rule, err := m.rules.PatchRule(userID, ruleID, constraints, outcome, lifespan, duration)
+	if err != nil {
+		return nil, err
+	}
+	metadata := &prompting.Metadata{
+		User:      userID,
+		Snap:      rule.Snap,
+		Interface: rule.Interface,
+	}
+	if _, err := m.prompts.HandleNewRule(metadata, rule.Constraints, rule.Outcome); err != nil {
+		logger.Noticef("cannot handle patched rule %s for outstanding prompts: %v", rule.ID, err)
+	}
+	return rule, nil
+}
+
+// RemoveRule removes the rule with the given ID for the given user.
+func (m *InterfacesRequestsManager) RemoveRule(userID uint32, ruleID prompting.IDType) (*requestrules.Rule, error) {
+	return m.rules.RemoveRule(userID, ruleID)
+}
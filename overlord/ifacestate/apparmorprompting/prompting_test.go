@@ -0,0 +1,405 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package apparmorprompting_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/interfaces/prompting"
+	"github.com/snapcore/snapd/interfaces/prompting/patterns"
+	"github.com/snapcore/snapd/interfaces/prompting/requestprompts"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/ifacestate/apparmorprompting"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/apparmor/notify"
+	"github.com/snapcore/snapd/sandbox/apparmor/notify/listener"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type fakeListener struct {
+	reqs    chan *listener.Request
+	closed  chan struct{}
+	runErr  error
+	running chan struct{}
+}
+
+func newFakeListener() *fakeListener {
+	return &fakeListener{
+		reqs:    make(chan *listener.Request),
+		closed:  make(chan struct{}),
+		running: make(chan struct{}),
+	}
+}
+
+func (l *fakeListener) Run() error {
+	close(l.running)
+	<-l.closed
+	return l.runErr
+}
+
+func (l *fakeListener) Reqs() <-chan *listener.Request {
+	return l.reqs
+}
+
+func (l *fakeListener) Close() error {
+	select {
+	case <-l.closed:
+		return listener.ErrAlreadyClosed
+	default:
+	}
+	close(l.closed)
+	close(l.reqs)
+	return nil
+}
+
+type apparmorpromptingSuite struct {
+	testutil.BaseTest
+
+	st          *state.State
+	defaultUser uint32
+	listener    *fakeListener
+}
+
+var _ = Suite(&apparmorpromptingSuite{})
+
+func (s *apparmorpromptingSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	s.st = state.New(nil)
+	s.defaultUser = 1000
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+	c.Assert(os.MkdirAll(dirs.SnapRunDir, 0700), IsNil)
+
+	s.listener = newFakeListener()
+	s.AddCleanup(apparmorprompting.MockListenerRegister(func() (apparmorprompting.RequestsListener, error) {
+		return s.listener, nil
+	}))
+}
+
+func (s *apparmorpromptingSuite) newRequest(path string, perms notify.FilePermission) (*listener.Request, chan *listener.Response) {
+	replyChan := make(chan *listener.Response, 1)
+	req := listener.MockRequest(1234, "snap.firefox.firefox", s.defaultUser, path, notify.AA_CLASS_FILE, perms, replyChan)
+	return req, replyChan
+}
+
+func mustParseConstraints(c *C, pattern string, permissions []string) *prompting.Constraints {
+	pathPattern, err := patterns.ParsePathPattern(pattern)
+	c.Assert(err, IsNil)
+	return &prompting.Constraints{
+		PathPattern: pathPattern,
+		Permissions: permissions,
+	}
+}
+
+func (s *apparmorpromptingSuite) checkNotice(c *C, noticeType state.NoticeType, id prompting.IDType) {
+	s.st.Lock()
+	defer s.st.Unlock()
+	notices := s.st.Notices(&state.NoticeFilter{
+		UserID: &s.defaultUser,
+		Types:  []state.NoticeType{noticeType},
+		Keys:   []string{id.String()},
+	})
+	c.Check(notices, HasLen, 1)
+}
+
+func (s *apparmorpromptingSuite) TestNewStop(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	<-s.listener.running
+	c.Check(mgr.Ensure(), IsNil)
+	mgr.Stop()
+
+	// Listener was closed and further calls fail
+	c.Check(s.listener.Close(), Equals, listener.ErrAlreadyClosed)
+	_, err := mgr.Prompts(s.defaultUser)
+	c.Check(err, NotNil)
+	_, err = mgr.Rules(s.defaultUser, "", "")
+	c.Check(err, NotNil)
+}
+
+func (s *apparmorpromptingSuite) TestStartUpListenerRegisterError(c *C) {
+	restore := apparmorprompting.MockListenerRegister(func() (apparmorprompting.RequestsListener, error) {
+		return nil, listener.ErrNotSupported
+	})
+	defer restore()
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	mgr := apparmorprompting.New(s.st)
+	c.Check(mgr.Running(), Equals, false)
+	// failing to start prompting is not an error for snapd
+	c.Assert(mgr.StartUp(), IsNil)
+	c.Check(logbuf.String(), testutil.Contains, "cannot start AppArmor prompting: cannot register prompting listener: ")
+	c.Check(mgr.Running(), Equals, false)
+	// and stopping the manager which isn't running is fine
+	mgr.Stop()
+}
+
+func (s *apparmorpromptingSuite) TestRunHandlesRequests(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	// Request not from a snap is denied
+	req, replyChan := s.newRequest("/home/test/foo", notify.AA_MAY_READ)
+	unconfinedReq := listener.MockRequest(1234, "unconfined", s.defaultUser, "/home/test/foo", notify.AA_CLASS_FILE, notify.AA_MAY_READ, replyChan)
+	s.listener.reqs <- unconfinedReq
+	resp := <-replyChan
+	c.Check(resp.Allow, Equals, false)
+
+	// Request from a snap creates a prompt
+	s.listener.reqs <- req
+	var prompts []*requestprompts.Prompt
+	var err error
+	for i := 0; i < 100; i++ {
+		prompts, err = mgr.Prompts(s.defaultUser)
+		c.Assert(err, IsNil)
+		if len(prompts) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(prompts, HasLen, 1)
+	c.Check(prompts[0].Constraints.Path(), Equals, "/home/test/foo")
+}
+
+func (s *apparmorpromptingSuite) TestListenerErrorStopsRun(c *C) {
+	s.listener.runErr = errors.New("boom")
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	<-s.listener.running
+
+	// Listener closing itself due to an error does not break Stop
+	c.Assert(s.listener.Close(), IsNil)
+	mgr.Stop()
+}
+
+func (s *apparmorpromptingSuite) TestHandleReplyCreatesRule(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	req, replyChan := s.newRequest("/home/test/Documents/foo.txt", notify.AA_MAY_READ)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+
+	prompts, err := mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Assert(prompts, HasLen, 1)
+	prompt := prompts[0]
+	c.Check(prompt.Snap, Equals, "firefox")
+	c.Check(prompt.Interface, Equals, "home")
+	c.Check(prompt.Constraints.Path(), Equals, "/home/test/Documents/foo.txt")
+	c.Check(prompt.Constraints.RemainingPermissions(), DeepEquals, []string{"read"})
+	s.checkNotice(c, state.InterfacesRequestsPromptNotice, prompt.ID)
+
+	// A second identical request is merged
+	req2, replyChan2 := s.newRequest("/home/test/Documents/foo.txt", notify.AA_MAY_READ)
+	c.Assert(mgr.HandleListenerReq(req2), IsNil)
+	prompts, err = mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Check(prompts, HasLen, 1)
+
+	constraints := mustParseConstraints(c, "/home/test/Documents/**", []string{"read"})
+	satisfied, err := mgr.HandleReply(s.defaultUser, prompt.ID, constraints, prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	c.Check(satisfied, HasLen, 0)
+
+	for _, rc := range []chan *listener.Response{replyChan, replyChan2} {
+		resp := <-rc
+		c.Check(resp.Allow, Equals, true)
+	}
+
+	rules, err := mgr.Rules(s.defaultUser, "firefox", "home")
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+	s.checkNotice(c, state.InterfacesRequestsRuleUpdateNotice, rules[0].ID)
+
+	// Subsequent matching requests are handled by the new rule
+	req3, replyChan3 := s.newRequest("/home/test/Documents/bar.txt", notify.AA_MAY_READ|notify.AA_MAY_OPEN)
+	c.Assert(mgr.HandleListenerReq(req3), IsNil)
+	resp := <-replyChan3
+	c.Check(resp.Allow, Equals, true)
+	prompts, err = mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Check(prompts, HasLen, 0)
+}
+
+func (s *apparmorpromptingSuite) TestHandleListenerReqDeniedByRule(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	_, err := mgr.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/.ssh/**", []string{"write"}), prompting.OutcomeDeny, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+
+	req, replyChan := s.newRequest("/home/test/.ssh/id_rsa", notify.AA_MAY_READ|notify.AA_MAY_WRITE)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+	resp := <-replyChan
+	c.Check(resp.Allow, Equals, false)
+
+	prompts, err := mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Check(prompts, HasLen, 0)
+}
+
+func (s *apparmorpromptingSuite) TestHandleListenerReqPartiallySatisfied(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	_, err := mgr.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+
+	req, replyChan := s.newRequest("/home/test/foo", notify.AA_MAY_READ|notify.AA_MAY_WRITE)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+
+	prompts, err := mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Assert(prompts, HasLen, 1)
+	c.Check(prompts[0].Constraints.RemainingPermissions(), DeepEquals, []string{"write"})
+
+	// Adding a rule for the remaining permission satisfies the prompt
+	_, err = mgr.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/foo", []string{"write"}), prompting.OutcomeAllow, prompting.LifespanTimespan, "1h")
+	c.Assert(err, IsNil)
+	resp := <-replyChan
+	c.Check(resp.Allow, Equals, true)
+	prompts, err = mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Check(prompts, HasLen, 0)
+}
+
+func (s *apparmorpromptingSuite) TestHandleListenerReqNotSnap(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	replyChan := make(chan *listener.Response, 1)
+	req := listener.MockRequest(1234, "/usr/bin/foo", s.defaultUser, "/home/test/foo", notify.AA_CLASS_FILE, notify.AA_MAY_READ, replyChan)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+	resp := <-replyChan
+	c.Check(resp.Allow, Equals, false)
+}
+
+func (s *apparmorpromptingSuite) TestHandleReplyErrors(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	req, _ := s.newRequest("/home/test/foo", notify.AA_MAY_READ|notify.AA_MAY_WRITE)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+	prompts, err := mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Assert(prompts, HasLen, 1)
+	id := prompts[0].ID
+
+	_, err = mgr.HandleReply(s.defaultUser, id+1, mustParseConstraints(c, "/home/test/foo", []string{"read", "write"}), prompting.OutcomeAllow, prompting.LifespanSingle, "")
+	c.Check(err, Equals, requestprompts.ErrNotFound)
+
+	_, err = mgr.HandleReply(s.defaultUser, id, mustParseConstraints(c, "/home/test/bar", []string{"read", "write"}), prompting.OutcomeAllow, prompting.LifespanSingle, "")
+	c.Check(errors.Is(err, apparmorprompting.ErrReplyNotMatchRequestedPath), Equals, true)
+
+	_, err = mgr.HandleReply(s.defaultUser, id, mustParseConstraints(c, "/home/test/foo", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanSingle, "")
+	c.Check(errors.Is(err, apparmorprompting.ErrReplyNotMatchRequestedPermissions), Equals, true)
+
+	_, err = mgr.HandleReply(s.defaultUser, id, mustParseConstraints(c, "/home/test/foo", []string{"read", "write"}), prompting.OutcomeAllow, prompting.LifespanSingle, "1h")
+	c.Check(err, ErrorMatches, `cannot have specified duration when lifespan is "single": "1h"`)
+
+	// The prompt is still outstanding
+	_, err = mgr.PromptWithID(s.defaultUser, id)
+	c.Check(err, IsNil)
+
+	// A single reply does not create a rule
+	_, err = mgr.HandleReply(s.defaultUser, id, mustParseConstraints(c, "/home/test/foo", []string{"read", "write"}), prompting.OutcomeDeny, prompting.LifespanSingle, "")
+	c.Check(err, IsNil)
+	rules, err := mgr.Rules(s.defaultUser, "", "")
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 0)
+}
+
+func (s *apparmorpromptingSuite) TestHandleReplyFailureRemovesRule(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	req, replyChan := s.newRequest("/home/test/foo", notify.AA_MAY_READ)
+	c.Assert(mgr.HandleListenerReq(req), IsNil)
+	prompts, err := mgr.Prompts(s.defaultUser)
+	c.Assert(err, IsNil)
+	c.Assert(prompts, HasLen, 1)
+
+	// The listener request was already replied to, so replying to the
+	// prompt fails
+	c.Assert(req.Reply(&listener.Response{Allow: false, Permission: notify.AA_MAY_READ}), IsNil)
+	<-replyChan
+
+	_, err = mgr.HandleReply(s.defaultUser, prompts[0].ID, mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Check(err, Equals, listener.ErrAlreadyReplied)
+
+	// The rule created for the reply was removed again
+	rules, err := mgr.Rules(s.defaultUser, "", "")
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 0)
+}
+
+func (s *apparmorpromptingSuite) TestRuleCRUD(c *C) {
+	mgr := apparmorprompting.New(s.st)
+	c.Assert(mgr.StartUp(), IsNil)
+	defer mgr.Stop()
+
+	rule, err := mgr.AddRule(s.defaultUser, "firefox", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+	other, err := mgr.AddRule(s.defaultUser, "thunderbird", "home", mustParseConstraints(c, "/home/test/**", []string{"read"}), prompting.OutcomeAllow, prompting.LifespanForever, "")
+	c.Assert(err, IsNil)
+
+	found, err := mgr.RuleWithID(s.defaultUser, rule.ID)
+	c.Check(err, IsNil)
+	c.Check(found, Equals, rule)
+
+	rules, err := mgr.Rules(s.defaultUser, "thunderbird", "")
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 1)
+	rules, err = mgr.Rules(s.defaultUser, "", "home")
+	c.Check(err, IsNil)
+	c.Check(rules, HasLen, 2)
+
+	patched, err := mgr.PatchRule(s.defaultUser, rule.ID, nil, prompting.OutcomeDeny, prompting.LifespanUnset, "")
+	c.Check(err, IsNil)
+	c.Check(patched.Outcome, Equals, prompting.OutcomeDeny)
+
+	removed, err := mgr.RemoveRule(s.defaultUser, rule.ID)
+	c.Check(err, IsNil)
+	c.Check(removed.ID, Equals, rule.ID)
+
+	_, err = mgr.RemoveRules(s.defaultUser, "", "")
+	c.Check(err, Equals, apparmorprompting.ErrNoSnapOrInterface)
+
+	removedRules, err := mgr.RemoveRules(s.defaultUser, "thunderbird", "")
+	c.Check(err, IsNil)
+	c.Assert(removedRules, HasLen, 1)
+	c.Check(removedRules[0].ID, Equals, other.ID)
+}
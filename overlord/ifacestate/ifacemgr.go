@@ -246,6 +246,15 @@ func (m *InterfaceManager) Stop() {
 	m.udevMon = nil
 }
 
+// UseAppArmorPrompting returns whether AppArmor prompting is both enabled via
+// the experimental feature flag and supported by the system. The value is
+// determined once per run of snapd, since toggling the flag restarts snapd.
+func (m *InterfaceManager) UseAppArmorPrompting() bool {
+	m.state.Lock()
+	defer m.state.Unlock()
+	return m.useAppArmorPrompting()
+}
+
 // Repository returns the interface repository used internally by the manager.
 //
 // This method has two use-cases:
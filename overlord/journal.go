@@ -0,0 +1,403 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var (
+	// journalMaxSize is the size after which the journal is compacted into
+	// the state file.
+	journalMaxSize int64 = 4 * 1024 * 1024
+	// journalMaxRecordSize bounds the size of a single record when reading
+	// the journal, so that a corrupted length can't exhaust the memory.
+	journalMaxRecordSize uint32 = 256 * 1024 * 1024
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// journalSyncInterval returns the maximum time for which the records appended
+// to the state journal may remain unsynced, as set with
+// SNAPD_STATE_JOURNAL_SYNC_INTERVAL. By default, every checkpoint is synced.
+func journalSyncInterval() (time.Duration, error) {
+	s := os.Getenv("SNAPD_STATE_JOURNAL_SYNC_INTERVAL")
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("cannot parse SNAPD_STATE_JOURNAL_SYNC_INTERVAL %q: expected a non-negative duration", s)
+	}
+	return d, nil
+}
+
+// journalRecordHeaderSize is the size of the header preceding each record in
+// the journal: the length of the record followed by its CRC-32C checksum,
+// both as little endian uint32.
+const journalRecordHeaderSize = 8
+
+// journalHeader is the first record of the journal, identifying the state
+// file the deltas in the journal apply to.
+type journalHeader struct {
+	StateChecksum string `json:"state-checksum"`
+}
+
+func stateChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stateJournal is a state.DeltaBackend that appends the changes to the state
+// to a journal instead of rewriting the whole state file on every checkpoint.
+// The state file keeps the same format, and the journal is compacted into it
+// once it grows too big. The records in the journal are checksummed so a
+// partially written record, from a crash or power loss, is discarded when the
+// journal is read. The journal starts with the checksum of the state file it
+// applies to, so that a journal left behind by a compaction interrupted after
+// writing the state file is discarded instead of replayed on top of it.
+type stateJournal struct {
+	statePath    string
+	path         string
+	ensureBefore func(d time.Duration)
+
+	// stateSum is the checksum of the current state file
+	stateSum string
+
+	// syncInterval is the maximum time appended records may remain unsynced,
+	// which allows batching the syncing of consecutive checkpoints. If zero,
+	// every checkpoint is synced before it returns.
+	syncInterval time.Duration
+
+	mu sync.Mutex
+	f  *os.File
+	// size is the size of the valid records in the journal
+	size      int64
+	syncTimer *time.Timer
+	unsynced  bool
+}
+
+// Checkpoint replaces the state file with the whole state and empties the
+// journal.
+func (j *stateJournal) Checkpoint(data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.compact(data)
+}
+
+// CheckpointDelta appends the delta to the journal, unless the journal grew
+// too big, in which case it's compacted into the state file instead.
+func (j *stateJournal) CheckpointDelta(delta *state.Delta, full func() []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.size >= journalMaxSize || j.stateSum == "" || !osutil.FileExists(j.statePath) {
+		// the journal always applies on top of the state file
+		return j.compact(full())
+	}
+
+	payload, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("cannot marshal state delta: %v", err)
+	}
+
+	if err := j.open(); err != nil {
+		return err
+	}
+
+	var record []byte
+	if j.size == 0 {
+		header, err := json.Marshal(&journalHeader{StateChecksum: j.stateSum})
+		if err != nil {
+			return fmt.Errorf("cannot marshal state journal header: %v", err)
+		}
+		record = appendJournalRecord(record, header)
+	}
+	record = appendJournalRecord(record, payload)
+
+	if _, err := j.f.Write(record); err != nil {
+		// drop any partially written record
+		j.close()
+		return fmt.Errorf("cannot write state journal: %v", err)
+	}
+	j.size += int64(len(record))
+
+	return j.syncLocked()
+}
+
+func appendJournalRecord(buf, payload []byte) []byte {
+	var header [journalRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crc32c))
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+func (j *stateJournal) EnsureBefore(d time.Duration) {
+	j.ensureBefore(d)
+}
+
+// open opens the journal for appending, discarding anything after the valid
+// records.
+func (j *stateJournal) open() error {
+	if j.f != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot open state journal: %v", err)
+	}
+	if err := f.Truncate(j.size); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot truncate state journal: %v", err)
+	}
+	j.f = f
+	return nil
+}
+
+func (j *stateJournal) close() {
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+}
+
+// syncLocked syncs the journal, or schedules it to be synced if syncs are
+// batched. Must be called with the journal's mutex held.
+func (j *stateJournal) syncLocked() error {
+	if j.syncInterval <= 0 {
+		if err := j.f.Sync(); err != nil {
+			return fmt.Errorf("cannot sync state journal: %v", err)
+		}
+		return nil
+	}
+
+	j.unsynced = true
+	if j.syncTimer == nil {
+		j.syncTimer = time.AfterFunc(j.syncInterval, func() {
+			if err := j.Sync(); err != nil {
+				logger.Noticef("cannot sync state journal: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Sync syncs any records appended to the journal but not yet synced.
+func (j *stateJournal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.syncTimer != nil {
+		j.syncTimer.Stop()
+		j.syncTimer = nil
+	}
+
+	if !j.unsynced || j.f == nil {
+		return nil
+	}
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.unsynced = false
+	return nil
+}
+
+// compact writes the whole state into the state file and empties the journal.
+// Must be called with the journal's mutex held.
+func (j *stateJournal) compact(data []byte) error {
+	if err := j.writeState(data); err != nil {
+		return err
+	}
+	return j.empty()
+}
+
+// writeState replaces the state file with the whole state. The journal no
+// longer applies to the new state file, and is discarded if read before
+// being emptied, as its changes are all in the whole state.
+func (j *stateJournal) writeState(data []byte) error {
+	if err := osutil.AtomicWriteFile(j.statePath, data, 0600, 0); err != nil {
+		return err
+	}
+	j.stateSum = stateChecksum(data)
+	return nil
+}
+
+// empty empties the journal, which is then started again with a header for
+// the current state file when the next delta is appended.
+func (j *stateJournal) empty() error {
+	if j.size == 0 && j.f == nil && !osutil.FileExists(j.path) {
+		return nil
+	}
+
+	if err := j.open(); err != nil {
+		return err
+	}
+	if err := j.f.Truncate(0); err != nil {
+		return fmt.Errorf("cannot truncate state journal: %v", err)
+	}
+	if err := j.f.Sync(); err != nil {
+		return fmt.Errorf("cannot sync state journal: %v", err)
+	}
+	j.size = 0
+	j.unsynced = false
+	return nil
+}
+
+// readJournal returns the checksum of the state file the journal applies to,
+// the deltas in the journal and the size of the valid records in it. Reading
+// stops at the first incomplete or corrupted record, which is the result of an
+// interrupted write.
+func readJournal(path string) (stateSum string, deltas []*state.Delta, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil, 0, nil
+		}
+		return "", nil, 0, fmt.Errorf("cannot open state journal: %v", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, journalRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				logger.Noticef("discarding incomplete record at offset %d of state journal", size)
+			}
+			return stateSum, deltas, size, nil
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		if length > journalMaxRecordSize {
+			logger.Noticef("discarding corrupted record at offset %d of state journal", size)
+			return stateSum, deltas, size, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			logger.Noticef("discarding incomplete record at offset %d of state journal", size)
+			return stateSum, deltas, size, nil
+		}
+
+		if crc32.Checksum(payload, crc32c) != checksum {
+			logger.Noticef("discarding corrupted record at offset %d of state journal", size)
+			return stateSum, deltas, size, nil
+		}
+
+		if size == 0 {
+			var header journalHeader
+			if err := json.Unmarshal(payload, &header); err != nil {
+				return "", nil, 0, fmt.Errorf("cannot read state journal header: %v", err)
+			}
+			stateSum = header.StateChecksum
+		} else {
+			var delta state.Delta
+			if err := json.Unmarshal(payload, &delta); err != nil {
+				return "", nil, 0, fmt.Errorf("cannot read state journal record at offset %d: %v", size, err)
+			}
+			deltas = append(deltas, &delta)
+		}
+		size += int64(journalRecordHeaderSize) + int64(length)
+	}
+}
+
+// readState reads the state from r, applying the changes recorded in the
+// journal on top of it. If the backend doesn't use the journal, the whole
+// state is checkpointed through it and the journal is removed, so that
+// switching back to checkpointing the whole state doesn't lose any changes.
+func readState(backend state.Backend, r io.Reader, journalPath string) (*state.State, error) {
+	base, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state: %v", err)
+	}
+	baseSum := stateChecksum(base)
+
+	journalSum, deltas, size, err := readJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 && journalSum != baseSum {
+		// the state file was written by a compaction that was
+		// interrupted before emptying the journal, and already has
+		// all its changes
+		logger.Noticef("discarding state journal not applying to the state file")
+		deltas, size = nil, 0
+	}
+
+	journal, useJournal := backend.(*stateJournal)
+	if useJournal {
+		journal.size = size
+		journal.stateSum = baseSum
+	}
+
+	if len(deltas) == 0 {
+		if !useJournal {
+			if err := os.Remove(journalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("cannot remove state journal: %v", err)
+			}
+		}
+		return state.ReadState(backend, bytes.NewReader(base))
+	}
+
+	entries, err := state.EntriesFromJSON(base)
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		delta.Apply(entries)
+	}
+
+	data, err := entries.JSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if !useJournal {
+		if err := backend.Checkpoint(data); err != nil {
+			return nil, fmt.Errorf("cannot write state from journal: %v", err)
+		}
+		if err := os.Remove(journalPath); err != nil {
+			return nil, fmt.Errorf("cannot remove state journal: %v", err)
+		}
+	}
+
+	return state.ReadState(backend, bytes.NewReader(data))
+}
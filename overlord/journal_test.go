@@ -0,0 +1,343 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package overlord_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type journalSuite struct {
+	testutil.BaseTest
+
+	statePath   string
+	journalPath string
+}
+
+var _ = Suite(&journalSuite{})
+
+func (s *journalSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+
+	dir := c.MkDir()
+	s.statePath = filepath.Join(dir, "state.json")
+	s.journalPath = filepath.Join(dir, "state.journal")
+}
+
+func (s *journalSuite) readState(c *C, backend state.Backend) *state.State {
+	data, err := os.ReadFile(s.statePath)
+	c.Assert(err, IsNil)
+
+	st, err := overlord.ReadStateWithJournal(backend, bytes.NewReader(data), s.journalPath)
+	c.Assert(err, IsNil)
+	return st
+}
+
+func (s *journalSuite) journalSize(c *C) int64 {
+	fi, err := os.Stat(s.journalPath)
+	c.Assert(err, IsNil)
+	return fi.Size()
+}
+
+func (s *journalSuite) TestCheckpointAppendsToJournal(c *C) {
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	st := state.New(j)
+
+	// the first checkpoint writes the whole state as there's no state file
+	st.Lock()
+	st.Set("foo", "bar")
+	st.NewChange("some-change", "...")
+	st.Unlock()
+
+	c.Check(s.statePath, testutil.FileContains, `"foo":"bar"`)
+	c.Check(s.journalPath, testutil.FileAbsent)
+	stateData, err := os.ReadFile(s.statePath)
+	c.Assert(err, IsNil)
+
+	// the next checkpoints only append the changes
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Set("other", 1)
+	st.Unlock()
+
+	c.Check(s.statePath, testutil.FileEquals, stateData)
+	size := s.journalSize(c)
+	c.Check(size > 0, Equals, true)
+
+	// nothing is appended if nothing changed
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Unlock()
+	c.Check(s.journalSize(c), Equals, size)
+
+	st.Lock()
+	st.Set("other", nil)
+	st.Unlock()
+	c.Check(s.journalSize(c) > size, Equals, true)
+
+	// the state is read back with the changes in the journal
+	readSt := s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	defer readSt.Unlock()
+
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "baz")
+
+	var other int
+	c.Check(readSt.Get("other", &other), testutil.ErrorIs, state.ErrNoState)
+	c.Check(readSt.Changes(), HasLen, 1)
+}
+
+func (s *journalSuite) TestCheckpointCompactsJournal(c *C) {
+	restore := overlord.MockJournalMaxSize(1)
+	defer restore()
+
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	st := state.New(j)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Unlock()
+	c.Check(s.journalSize(c) > 0, Equals, true)
+	c.Check(s.statePath, testutil.FileContains, `"foo":"bar"`)
+
+	// the journal is too big so it's compacted into the state file
+	st.Lock()
+	st.Set("foo", "qux")
+	st.Unlock()
+	c.Check(s.journalSize(c), Equals, int64(0))
+	c.Check(s.statePath, testutil.FileContains, `"foo":"qux"`)
+
+	// and it's used again after that
+	st.Lock()
+	st.Set("foo", "quux")
+	st.Unlock()
+	c.Check(s.journalSize(c) > 0, Equals, true)
+
+	readSt := s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	defer readSt.Unlock()
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "quux")
+}
+
+func (s *journalSuite) TestReadDiscardsJournalAfterInterruptedCompact(c *C) {
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	st := state.New(j)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Set("tmp", "value")
+	st.NewChange("some-change", "...")
+	st.Unlock()
+	c.Check(s.journalSize(c) > 0, Equals, true)
+
+	// the change was pruned and the data updated when the state is
+	// compacted, but the journal is not emptied
+	b := new(fakeBackend)
+	newSt := state.New(b)
+	newSt.Lock()
+	newSt.Set("foo", "qux")
+	newSt.Unlock()
+	c.Assert(j.CompactInterrupted(b.data), IsNil)
+	c.Check(s.journalSize(c) > 0, Equals, true)
+
+	readSt := s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "qux")
+	var tmp string
+	c.Check(readSt.Get("tmp", &tmp), testutil.ErrorIs, state.ErrNoState)
+	c.Check(readSt.Changes(), HasLen, 0)
+
+	// the stale journal is replaced when appending new changes
+	readSt.Set("foo", "quux")
+	readSt.Unlock()
+
+	readSt = s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	defer readSt.Unlock()
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "quux")
+	c.Check(readSt.Get("tmp", &tmp), testutil.ErrorIs, state.ErrNoState)
+	c.Check(readSt.Changes(), HasLen, 0)
+}
+
+func (s *journalSuite) testDiscardsInvalidRecords(c *C, corrupt func(data []byte) []byte) {
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	st := state.New(j)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Unlock()
+	goodSize := s.journalSize(c)
+
+	st.Lock()
+	st.Set("foo", "qux")
+	st.Unlock()
+
+	data, err := os.ReadFile(s.journalPath)
+	c.Assert(err, IsNil)
+	c.Assert(os.WriteFile(s.journalPath, append(data[:goodSize], corrupt(data[goodSize:])...), 0600), IsNil)
+
+	j = overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	readSt := s.readState(c, j)
+	readSt.Lock()
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "baz")
+
+	// the invalid record is dropped before appending new ones
+	readSt.Set("foo", "quux")
+	readSt.Unlock()
+
+	readSt = s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	defer readSt.Unlock()
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "quux")
+}
+
+func (s *journalSuite) TestReadDiscardsIncompleteRecord(c *C) {
+	s.testDiscardsInvalidRecords(c, func(record []byte) []byte {
+		return record[:len(record)-3]
+	})
+}
+
+func (s *journalSuite) TestReadDiscardsIncompleteHeader(c *C) {
+	s.testDiscardsInvalidRecords(c, func(record []byte) []byte {
+		return record[:5]
+	})
+}
+
+func (s *journalSuite) TestReadDiscardsCorruptedRecord(c *C) {
+	s.testDiscardsInvalidRecords(c, func(record []byte) []byte {
+		corrupted := append([]byte(nil), record...)
+		corrupted[len(corrupted)-2] ^= 0xff
+		return corrupted
+	})
+}
+
+func (s *journalSuite) TestReadMigratesBackFromJournal(c *C) {
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	st := state.New(j)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Unlock()
+	c.Check(s.statePath, testutil.FileContains, `"foo":"bar"`)
+
+	// reading the state without the journal writes the whole state
+	b := new(fakeBackend)
+	readSt := s.readState(c, b)
+
+	c.Check(string(b.data), testutil.Contains, `"foo":"baz"`)
+	c.Check(s.journalPath, testutil.FileAbsent)
+
+	readSt.Lock()
+	defer readSt.Unlock()
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "baz")
+}
+
+func (s *journalSuite) TestReadFromPlainStateFile(c *C) {
+	// the state was checkpointed whole before using the journal
+	b := new(fakeBackend)
+	st := state.New(b)
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+	c.Assert(os.WriteFile(s.statePath, b.data, 0600), IsNil)
+
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, 0)
+	readSt := s.readState(c, j)
+
+	// only the changes are appended to the journal
+	readSt.Lock()
+	readSt.Set("other", "value")
+	readSt.Unlock()
+	c.Check(s.statePath, testutil.FileEquals, b.data)
+	c.Check(s.journalSize(c) > 0, Equals, true)
+	c.Check(s.journalSize(c) < int64(len(b.data)), Equals, true)
+}
+
+func (s *journalSuite) TestBatchedSync(c *C) {
+	j := overlord.NewStateJournal(s.statePath, s.journalPath, time.Hour)
+	st := state.New(j)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Unlock()
+	c.Check(j.Unsynced(), Equals, false)
+
+	st.Lock()
+	st.Set("foo", "baz")
+	st.Unlock()
+	c.Check(j.Unsynced(), Equals, true)
+
+	c.Assert(j.Sync(), IsNil)
+	c.Check(j.Unsynced(), Equals, false)
+
+	readSt := s.readState(c, overlord.NewStateJournal(s.statePath, s.journalPath, 0))
+	readSt.Lock()
+	defer readSt.Unlock()
+	var foo string
+	c.Assert(readSt.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "baz")
+}
+
+type fakeBackend struct {
+	data []byte
+}
+
+func (b *fakeBackend) Checkpoint(data []byte) error {
+	b.data = data
+	return nil
+}
+
+func (b *fakeBackend) EnsureBefore(d time.Duration) {}
@@ -45,7 +45,9 @@ import (
 	"github.com/snapcore/snapd/overlord/healthstate"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/ifacestate/apparmorprompting"
 	"github.com/snapcore/snapd/overlord/patch"
+	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/restart"
 	"github.com/snapcore/snapd/overlord/servicestate"
 	"github.com/snapcore/snapd/overlord/snapshotstate"
@@ -86,6 +88,8 @@ var pruneTickerC = func(t *time.Ticker) <-chan time.Time {
 // track of all available state managers and related helpers.
 type Overlord struct {
 	stateFLock *osutil.FileLock
+	// stateJournal is set if the state is checkpointed through a journal
+	stateJournal *stateJournal
 
 	stateEng *StateEngine
 	// ensure loop
@@ -111,12 +115,19 @@ type Overlord struct {
 	deviceMgr  *devicestate.DeviceManager
 	cmdMgr     *cmdstate.CommandManager
 	shotMgr    *snapshotstate.SnapshotManager
+	regMgr     *registrystate.RegistryManager
+	healthMgr  *healthstate.HealthManager
+	// interfacesRequestsMgr is only present when AppArmor prompting is
+	// enabled and supported
+	interfacesRequestsMgr *apparmorprompting.InterfacesRequestsManager
 	// proxyConf mediates the http proxy config
 	proxyConf func(req *http.Request) (*url.URL, error)
 }
 
 var storeNew = store.New
 
+var apparmorpromptingNew = apparmorprompting.New
+
 // New creates a new Overlord with all its state managers.
 // It can be provided with an optional restart.Handler.
 func New(restartHandler restart.Handler) (*Overlord, error) {
@@ -124,10 +135,23 @@ func New(restartHandler restart.Handler) (*Overlord, error) {
 		inited: true,
 	}
 
-	backend := &overlordStateBackend{
+	var backend state.Backend = &overlordStateBackend{
 		path:         dirs.SnapStateFile,
 		ensureBefore: o.ensureBefore,
 	}
+	if osutil.GetenvBool("SNAPD_STATE_JOURNAL") {
+		syncInterval, err := journalSyncInterval()
+		if err != nil {
+			return nil, err
+		}
+		o.stateJournal = &stateJournal{
+			statePath:    dirs.SnapStateFile,
+			path:         dirs.SnapStateJournalFile,
+			ensureBefore: o.ensureBefore,
+			syncInterval: syncInterval,
+		}
+		backend = o.stateJournal
+	}
 	s, restartMgr, err := o.loadState(backend, restartHandler)
 	if err != nil {
 		return nil, err
@@ -171,6 +195,11 @@ func New(restartHandler restart.Handler) (*Overlord, error) {
 	}
 	o.addManager(ifaceMgr)
 
+	if ifaceMgr.UseAppArmorPrompting() {
+		// the listener is only started, if possible, on StartUp
+		o.addManager(apparmorpromptingNew(s))
+	}
+
 	deviceMgr, err := devicestate.Manager(s, hookMgr, o.runner, o.newStore)
 	if err != nil {
 		return nil, err
@@ -179,11 +208,12 @@ func New(restartHandler restart.Handler) (*Overlord, error) {
 
 	o.addManager(cmdstate.Manager(s, o.runner))
 	o.addManager(snapshotstate.Manager(s, o.runner))
+	o.addManager(registrystate.Manager(s, hookMgr, o.runner))
 
 	if err := configstateInit(s, hookMgr); err != nil {
 		return nil, err
 	}
-	healthstate.Init(hookMgr)
+	o.addManager(healthstate.Manager(s, hookMgr, o.runner))
 
 	// the shared task runner should be added last!
 	o.stateEng.AddManager(o.runner)
@@ -218,8 +248,14 @@ func (o *Overlord) addManager(mgr StateManager) {
 		o.cmdMgr = x
 	case *snapshotstate.SnapshotManager:
 		o.shotMgr = x
+	case *registrystate.RegistryManager:
+		o.regMgr = x
+	case *healthstate.HealthManager:
+		o.healthMgr = x
 	case *restart.RestartManager:
 		o.restartMgr = x
+	case *apparmorprompting.InterfacesRequestsManager:
+		o.interfacesRequestsMgr = x
 	}
 	o.stateEng.AddManager(mgr)
 }
@@ -289,6 +325,10 @@ func (o *Overlord) loadState(backend state.Backend, restartHandler restart.Handl
 		if !osutil.IsDirectory(stateDir) {
 			return nil, nil, fmt.Errorf("fatal: directory %q must be present", stateDir)
 		}
+		// a journal without a state file to apply to is stale
+		if err := os.Remove(dirs.SnapStateJournalFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, fmt.Errorf("cannot remove stale state journal: %v", err)
+		}
 		s := state.New(backend)
 		restartMgr, err := initRestart(s, curBootID, restartHandler)
 		if err != nil {
@@ -306,7 +346,7 @@ func (o *Overlord) loadState(backend state.Backend, restartHandler restart.Handl
 
 	var s *state.State
 	timings.Run(perfTimings, "read-state", "read snapd state from disk", func(tm timings.Measurer) {
-		s, err = state.ReadState(backend, r)
+		s, err = readState(backend, r, dirs.SnapStateJournalFile)
 	})
 	if err != nil {
 		return nil, nil, err
@@ -509,6 +549,11 @@ func (o *Overlord) Stop() error {
 		err = o.loopTomb.Wait()
 	}
 	o.stateEng.Stop()
+	if o.stateJournal != nil {
+		if err := o.stateJournal.Sync(); err != nil {
+			logger.Noticef("cannot sync state journal: %v", err)
+		}
+	}
 	if o.stateFLock != nil {
 		// This will also unlock the file
 		o.stateFLock.Close()
@@ -676,6 +721,28 @@ func (o *Overlord) SnapshotManager() *snapshotstate.SnapshotManager {
 	return o.shotMgr
 }
 
+// RegistryManager returns the manager responsible for committing registry
+// changes.
+func (o *Overlord) RegistryManager() *registrystate.RegistryManager {
+	return o.regMgr
+}
+
+// HealthManager returns the manager responsible for acting on the health
+// reported by snaps.
+func (o *Overlord) HealthManager() *healthstate.HealthManager {
+	return o.healthMgr
+}
+
+// InterfacesRequestsManager returns the manager responsible for handling
+// AppArmor prompting requests, prompts, and rules, or nil if AppArmor
+// prompting is not running.
+func (o *Overlord) InterfacesRequestsManager() *apparmorprompting.InterfacesRequestsManager {
+	if o.interfacesRequestsMgr == nil || !o.interfacesRequestsMgr.Running() {
+		return nil
+	}
+	return o.interfacesRequestsMgr
+}
+
 // Mock creates an Overlord without any managers and with a backend
 // not using disk. Managers can be added with AddManager. For testing.
 func Mock() *Overlord {
@@ -37,16 +37,20 @@ import (
 
 	"github.com/snapcore/snapd/boot"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/devicestate/devicestatetest"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/ifacestate"
+	"github.com/snapcore/snapd/overlord/ifacestate/apparmorprompting"
 	"github.com/snapcore/snapd/overlord/patch"
 	"github.com/snapcore/snapd/overlord/restart"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
 	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/sandbox/apparmor"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/snapdenv"
 	"github.com/snapcore/snapd/snapdtool"
@@ -124,6 +128,8 @@ func (ovs *overlordSuite) TestNew(c *C) {
 	c.Check(o.DeviceManager(), NotNil)
 	c.Check(o.CommandManager(), NotNil)
 	c.Check(o.SnapshotManager(), NotNil)
+	c.Check(o.RegistryManager(), NotNil)
+	c.Check(o.HealthManager(), NotNil)
 	c.Check(configstateInitCalled, Equals, true)
 
 	o.InterfaceManager().DisableUDevMonitor()
@@ -206,6 +212,54 @@ func (ovs *overlordSuite) TestNewWithGoodState(c *C) {
 	c.Check(got, DeepEquals, expected)
 }
 
+func (ovs *overlordSuite) TestNewWithStateJournal(c *C) {
+	os.Setenv("SNAPD_STATE_JOURNAL", "1")
+	defer os.Unsetenv("SNAPD_STATE_JOURNAL")
+
+	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"patch-sublevel":%d,"patch-sublevel-last-version":%q,"some":"data","refresh-privacy-key":"0123456789ABCDEF"},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level, patch.Sublevel, snapdtool.Version))
+	err := os.WriteFile(dirs.SnapStateFile, fakeState, 0600)
+	c.Assert(err, IsNil)
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+
+	st := o.State()
+	st.Lock()
+	st.Set("some", "other-data")
+	st.Unlock()
+	c.Assert(o.Stop(), IsNil)
+
+	// the change is in the journal
+	c.Check(dirs.SnapStateFile, testutil.FileContains, `"some":"data"`)
+	c.Check(dirs.SnapStateJournalFile, testutil.FileContains, `"data/some":"other-data"`)
+
+	// and is kept when the journal isn't used anymore
+	os.Unsetenv("SNAPD_STATE_JOURNAL")
+	o, err = overlord.New(nil)
+	c.Assert(err, IsNil)
+	defer o.Stop()
+
+	c.Check(dirs.SnapStateFile, testutil.FileContains, `"some":"other-data"`)
+	c.Check(dirs.SnapStateJournalFile, testutil.FileAbsent)
+
+	st = o.State()
+	st.Lock()
+	defer st.Unlock()
+	var some string
+	c.Assert(st.Get("some", &some), IsNil)
+	c.Check(some, Equals, "other-data")
+}
+
+func (ovs *overlordSuite) TestNewWithStateJournalBadSyncInterval(c *C) {
+	os.Setenv("SNAPD_STATE_JOURNAL", "1")
+	defer os.Unsetenv("SNAPD_STATE_JOURNAL")
+	os.Setenv("SNAPD_STATE_JOURNAL_SYNC_INTERVAL", "foo")
+	defer os.Unsetenv("SNAPD_STATE_JOURNAL_SYNC_INTERVAL")
+
+	_, err := overlord.New(nil)
+	c.Assert(err, ErrorMatches, `cannot parse SNAPD_STATE_JOURNAL_SYNC_INTERVAL "foo": expected a non-negative duration`)
+}
+
 func (ovs *overlordSuite) TestNewWithStateSnapmgrUpdate(c *C) {
 	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"some":"data"},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level))
 	err := os.WriteFile(dirs.SnapStateFile, fakeState, 0600)
@@ -274,6 +328,128 @@ func (ovs *overlordSuite) TestNewWithPatches(c *C) {
 	c.Check(b, Equals, true)
 }
 
+func (ovs *overlordSuite) writeStateWithPrompting(c *C, enabled bool) {
+	fakeState := []byte(fmt.Sprintf(`{"data":{"patch-level":%d,"config":{"core":{"experimental":{"apparmor-prompting":%t}}}},"changes":null,"tasks":null,"last-change-id":0,"last-task-id":0,"last-lane-id":0}`, patch.Level, enabled))
+	err := os.WriteFile(dirs.SnapStateFile, fakeState, 0600)
+	c.Assert(err, IsNil)
+}
+
+func (ovs *overlordSuite) testNewWithAppArmorPrompting(c *C, enabled bool) {
+	restore := apparmor.MockFeatures([]string{"policy:permstable32:prompt"}, nil, []string{"prompt"}, nil)
+	defer restore()
+	ovs.writeStateWithPrompting(c, enabled)
+
+	var called bool
+	mgr := &apparmorprompting.InterfacesRequestsManager{}
+	restore = overlord.MockApparmorpromptingNew(func(s *state.State) *apparmorprompting.InterfacesRequestsManager {
+		called = true
+		return mgr
+	})
+	defer restore()
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+	o.InterfaceManager().DisableUDevMonitor()
+	c.Check(called, Equals, enabled)
+	if enabled {
+		c.Check(o.AddedInterfacesRequestsManager(), Equals, mgr)
+	} else {
+		c.Check(o.AddedInterfacesRequestsManager(), IsNil)
+	}
+	// the manager is not running until started up
+	c.Check(o.InterfacesRequestsManager(), IsNil)
+}
+
+func (ovs *overlordSuite) TestNewWithAppArmorPromptingEnabled(c *C) {
+	ovs.testNewWithAppArmorPrompting(c, true)
+}
+
+func (ovs *overlordSuite) TestNewWithAppArmorPromptingDisabled(c *C) {
+	ovs.testNewWithAppArmorPrompting(c, false)
+}
+
+func (ovs *overlordSuite) TestAppArmorPromptingToggledOnRestart(c *C) {
+	restore := apparmor.MockFeatures([]string{"policy:permstable32:prompt"}, nil, []string{"prompt"}, nil)
+	defer restore()
+	ovs.writeStateWithPrompting(c, true)
+
+	restore = overlord.MockApparmorpromptingNew(func(s *state.State) *apparmorprompting.InterfacesRequestsManager {
+		return &apparmorprompting.InterfacesRequestsManager{}
+	})
+	defer restore()
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+	o.InterfaceManager().DisableUDevMonitor()
+	c.Check(o.AddedInterfacesRequestsManager(), NotNil)
+
+	// toggling the flag at runtime restarts snapd (see configcore), and
+	// the manager, and with it the listener, is only there if prompting
+	// is enabled when snapd starts
+	for _, enabled := range []bool{false, true} {
+		st := o.State()
+		st.Lock()
+		tr := config.NewTransaction(st)
+		c.Assert(tr.Set("core", "experimental.apparmor-prompting", enabled), IsNil)
+		tr.Commit()
+		st.Unlock()
+		c.Assert(o.Stop(), IsNil)
+
+		o, err = overlord.New(nil)
+		c.Assert(err, IsNil)
+		o.InterfaceManager().DisableUDevMonitor()
+		if enabled {
+			c.Check(o.AddedInterfacesRequestsManager(), NotNil)
+		} else {
+			c.Check(o.AddedInterfacesRequestsManager(), IsNil)
+		}
+	}
+	c.Assert(o.Stop(), IsNil)
+}
+
+func (ovs *overlordSuite) TestNewWithAppArmorPromptingUnsupported(c *C) {
+	restore := apparmor.MockFeatures(nil, nil, nil, nil)
+	defer restore()
+	ovs.writeStateWithPrompting(c, true)
+
+	restore = overlord.MockApparmorpromptingNew(func(s *state.State) *apparmorprompting.InterfacesRequestsManager {
+		c.Fatalf("unexpected call to apparmorprompting.New")
+		return nil
+	})
+	defer restore()
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+	o.InterfaceManager().DisableUDevMonitor()
+	c.Check(o.InterfacesRequestsManager(), IsNil)
+}
+
+func (ovs *overlordSuite) TestStartUpWithAppArmorPromptingError(c *C) {
+	restore := apparmor.MockFeatures([]string{"policy:permstable32:prompt"}, nil, []string{"prompt"}, nil)
+	defer restore()
+	ovs.writeStateWithPrompting(c, true)
+
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	o, err := overlord.New(nil)
+	c.Assert(err, IsNil)
+	o.InterfaceManager().DisableUDevMonitor()
+	markSeeded(o)
+	// make sure we don't try to talk to the store
+	snapstate.CanAutoRefresh = nil
+	c.Assert(o.AddedInterfacesRequestsManager(), NotNil)
+
+	// Failing to start prompting does not prevent the overlord from
+	// starting
+	c.Assert(o.StartUp(), IsNil)
+	c.Check(o.InterfacesRequestsManager(), IsNil)
+	c.Check(logbuf.String(), testutil.Contains, "cannot start AppArmor prompting: ")
+
+	o.Loop()
+	c.Assert(o.Stop(), IsNil)
+}
+
 func (ovs *overlordSuite) TestNewFailedConfigstate(c *C) {
 	restore := patch.Mock(42, 2, nil)
 	defer restore()
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package registrystate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
+)
+
+// ChangeConflictError is returned when a registry transaction would modify
+// paths that an ongoing change to the same registry is also modifying.
+type ChangeConflictError struct {
+	Account      string
+	RegistryName string
+	Path         string
+	ChangeKind   string
+	ChangeID     string
+}
+
+func (e *ChangeConflictError) Error() string {
+	return fmt.Sprintf("cannot change %q in registry %s/%s: %q change in progress (change %s)", e.Path, e.Account, e.RegistryName, e.ChangeKind, e.ChangeID)
+}
+
+func (e *ChangeConflictError) Is(err error) bool {
+	_, ok := err.(*ChangeConflictError)
+	return ok
+}
+
+// checkChangeConflict returns a ChangeConflictError if an ongoing change has
+// yet to commit a transaction to the same registry that alters paths that
+// overlap with the ones altered by tx.
+func checkChangeConflict(st *state.State, tx *registry.Transaction) error {
+	account, registryName := tx.RegistryInfo()
+	paths := tx.AlteredPaths()
+
+	for _, chg := range st.Changes() {
+		if chg.IsReady() {
+			continue
+		}
+
+		for _, t := range chg.Tasks() {
+			if t.Kind() != "commit-registry-tx" || t.Status().Ready() {
+				continue
+			}
+
+			var otherTx registry.Transaction
+			if err := t.Get("registry-transaction", &otherTx); err != nil {
+				return fmt.Errorf("cannot get registry transaction from task %s: %w", t.ID(), err)
+			}
+
+			otherAccount, otherRegistry := otherTx.RegistryInfo()
+			if otherAccount != account || otherRegistry != registryName {
+				continue
+			}
+
+			for _, path := range paths {
+				for _, otherPath := range otherTx.AlteredPaths() {
+					if pathsOverlap(path, otherPath) {
+						return &ChangeConflictError{
+							Account:      account,
+							RegistryName: registryName,
+							Path:         path,
+							ChangeKind:   chg.Kind(),
+							ChangeID:     chg.ID(),
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// pathsOverlap returns true if the paths are the same or one of them is
+// nested in the other.
+func pathsOverlap(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a == b || strings.HasPrefix(b, a+".")
+}
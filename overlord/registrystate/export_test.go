@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package registrystate
+
+import (
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (m *RegistryManager) DoCommitTransaction(t *state.Task, tomb *tomb.Tomb) error {
+	return m.doCommitTransaction(t, tomb)
+}
+
+func (m *RegistryManager) UndoCommitTransaction(t *state.Task, tomb *tomb.Tomb) error {
+	return m.undoCommitTransaction(t, tomb)
+}
+
+func (m *RegistryManager) DoReadView(t *state.Task, tomb *tomb.Tomb) error {
+	return m.doReadView(t, tomb)
+}
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	return testutil.Mock(&timeNow, f)
+}
+
+func MockMaxDatabagRevisions(max int) (restore func()) {
+	return testutil.Mock(&maxDatabagRevisions, max)
+}
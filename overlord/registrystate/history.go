@@ -0,0 +1,214 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package registrystate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/snapcore/snapd/jsonutil"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
+)
+
+var (
+	timeNow = time.Now
+
+	// maxDatabagRevisions is the number of databag revisions kept in the
+	// history of each registry.
+	maxDatabagRevisions = 10
+)
+
+// Author identifies who or what changed the data of a registry.
+type Author struct {
+	// Snap and Hook identify the snap (and hook, if any) that changed the data
+	// through snapctl.
+	Snap string `json:"snap,omitempty"`
+	Hook string `json:"hook,omitempty"`
+	// UID identifies the user that changed the data through the API.
+	UID *uint32 `json:"uid,omitempty"`
+}
+
+// DatabagRevision holds the data of a registry as it was committed, along
+// with the time and author of the change.
+type DatabagRevision struct {
+	Revision int                  `json:"revision"`
+	Time     time.Time            `json:"time"`
+	Author   Author               `json:"author"`
+	Databag  registry.JSONDataBag `json:"databag"`
+}
+
+// RevisionNotFoundError is returned when reverting to a revision that isn't
+// in the history of a registry.
+type RevisionNotFoundError struct {
+	Account      string
+	RegistryName string
+	Revision     int
+}
+
+func (e *RevisionNotFoundError) Error() string {
+	return fmt.Sprintf("cannot find revision %d of registry %s/%s", e.Revision, e.Account, e.RegistryName)
+}
+
+func (e *RevisionNotFoundError) Is(err error) bool {
+	_, ok := err.(*RevisionNotFoundError)
+	return ok
+}
+
+func getHistories(st *state.State) (map[string]map[string][]*DatabagRevision, error) {
+	var histories map[string]map[string][]*DatabagRevision
+	if err := st.Get("registry-history", &histories); err != nil && !errors.Is(err, state.ErrNoState) {
+		return nil, err
+	}
+	return histories, nil
+}
+
+// addDatabagRevision records the databag as the latest revision of the
+// registry, dropping the oldest revisions if there are too many.
+func addDatabagRevision(st *state.State, account, registryName string, databag registry.JSONDataBag, author Author) error {
+	histories, err := getHistories(st)
+	if err != nil {
+		return err
+	}
+
+	if histories == nil {
+		histories = make(map[string]map[string][]*DatabagRevision)
+	}
+	if histories[account] == nil {
+		histories[account] = make(map[string][]*DatabagRevision)
+	}
+
+	revs := histories[account][registryName]
+	nextRev := 1
+	if len(revs) > 0 {
+		nextRev = revs[len(revs)-1].Revision + 1
+	}
+
+	revs = append(revs, &DatabagRevision{
+		Revision: nextRev,
+		Time:     timeNow(),
+		Author:   author,
+		Databag:  databag,
+	})
+	if len(revs) > maxDatabagRevisions {
+		revs = revs[len(revs)-maxDatabagRevisions:]
+	}
+
+	histories[account][registryName] = revs
+	st.Set("registry-history", histories)
+	return nil
+}
+
+// History returns the revisions kept for the registry's data, from oldest to
+// newest. The last revision holds the current data.
+func History(st *state.State, account, registryName string) ([]*DatabagRevision, error) {
+	histories, err := getHistories(st)
+	if err != nil {
+		return nil, err
+	}
+
+	return histories[account][registryName], nil
+}
+
+// Revert returns a task set that changes the registry's data back to what it
+// was in the specified revision. The changes go through the same hooks as any
+// other change to the registry and are recorded as a new revision once
+// committed.
+func Revert(st *state.State, account, registryName string, revision int, author Author) (*state.TaskSet, error) {
+	revs, err := History(st, account, registryName)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *DatabagRevision
+	for _, rev := range revs {
+		if rev.Revision == revision {
+			target = rev
+			break
+		}
+	}
+
+	if target == nil {
+		return nil, &RevisionNotFoundError{
+			Account:      account,
+			RegistryName: registryName,
+			Revision:     revision,
+		}
+	}
+
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return nil, err
+	}
+	reg := registryAssert.Registry()
+
+	tx, err := newTransaction(st, reg, author)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := bagGetter(st, reg)()
+	if err != nil {
+		return nil, err
+	}
+
+	// replace the top level entries so the transaction only alters paths whose
+	// data is changed
+	for _, key := range sortedKeys(current) {
+		if _, ok := target.Databag[key]; !ok {
+			if err := tx.Unset(key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(target.Databag) {
+		raw := target.Databag[key]
+		if string(raw) == string(current[key]) {
+			continue
+		}
+
+		var value interface{}
+		if err := jsonutil.DecodeWithNumber(bytes.NewReader(raw), &value); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal revision %d of registry %s/%s: %v", revision, account, registryName, err)
+		}
+
+		if err := tx.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkChangeConflict(st, tx); err != nil {
+		return nil, err
+	}
+
+	return createChangeRegistryTasks(st, tx, author)
+}
+
+func sortedKeys(bag registry.JSONDataBag) []string {
+	keys := make([]string, 0, len(bag))
+	for key := range bag {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
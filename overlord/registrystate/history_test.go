@@ -0,0 +1,189 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package registrystate_test
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/registrystate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
+)
+
+// commitViaView sets the values through the view on behalf of the author and
+// commits them as if the change had run. Must be called with the state locked.
+func (s *registryTestSuite) commitViaView(c *C, requests map[string]interface{}, author registrystate.Author) {
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", requests, author)
+	c.Assert(err, IsNil)
+	s.runTasks(c, ts)
+}
+
+// runTasks runs the commit task in the task set. Must be called with the
+// state locked.
+func (s *registryTestSuite) runTasks(c *C, ts *state.TaskSet) {
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Assert(tasks[0].Kind(), Equals, "commit-registry-tx")
+
+	s.state.Unlock()
+	err := s.regMgr.DoCommitTransaction(tasks[0], nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+}
+
+func (s *registryTestSuite) TestHistoryRecordsRevisions(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	restore := registrystate.MockTimeNow(func() time.Time { return now })
+	defer restore()
+
+	revs, err := registrystate.History(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	c.Check(revs, HasLen, 0)
+
+	uid := uint32(1000)
+	s.commitViaView(c, map[string]interface{}{"ssid": "foo"}, registrystate.Author{UID: &uid})
+
+	now = now.Add(time.Hour)
+	s.commitViaView(c, map[string]interface{}{"ssid": "bar"}, registrystate.Author{Snap: "some-snap", Hook: "configure"})
+
+	revs, err = registrystate.History(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	c.Assert(revs, HasLen, 2)
+
+	c.Check(revs[0].Revision, Equals, 1)
+	c.Check(revs[0].Time.Equal(now.Add(-time.Hour)), Equals, true)
+	c.Check(revs[0].Author, DeepEquals, registrystate.Author{UID: &uid})
+	val, err := revs[0].Databag.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "foo")
+
+	c.Check(revs[1].Revision, Equals, 2)
+	c.Check(revs[1].Time.Equal(now), Equals, true)
+	c.Check(revs[1].Author, DeepEquals, registrystate.Author{Snap: "some-snap", Hook: "configure"})
+	val, err = revs[1].Databag.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "bar")
+}
+
+func (s *registryTestSuite) TestHistoryIsBounded(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	restore := registrystate.MockMaxDatabagRevisions(3)
+	defer restore()
+
+	for i := 0; i < 5; i++ {
+		s.commitViaView(c, map[string]interface{}{"ssid": fmt.Sprintf("ssid-%d", i)}, registrystate.Author{})
+	}
+
+	revs, err := registrystate.History(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	c.Assert(revs, HasLen, 3)
+	for i, rev := range revs {
+		c.Check(rev.Revision, Equals, i+3)
+		val, err := rev.Databag.Get("wifi.ssid")
+		c.Assert(err, IsNil)
+		c.Check(val, Equals, fmt.Sprintf("ssid-%d", i+2))
+	}
+}
+
+func (s *registryTestSuite) TestHistoryKeepsOtherRegistries(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	other := registry.NewJSONDataBag()
+	c.Assert(other.Set("foo", "bar"), IsNil)
+	s.state.Set("registry-databags", map[string]map[string]registry.JSONDataBag{"other-acc": {"other": other}})
+
+	s.commitViaView(c, map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+
+	var databags map[string]map[string]registry.JSONDataBag
+	c.Assert(s.state.Get("registry-databags", &databags), IsNil)
+	val, err := databags["other-acc"]["other"].Get("foo")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "bar")
+}
+
+func (s *registryTestSuite) TestRevert(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.commitViaView(c, map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	s.commitViaView(c, map[string]interface{}{"ssid": "bar", "private.a": 1}, registrystate.Author{})
+
+	uid := uint32(0)
+	ts, err := registrystate.Revert(s.state, s.devAccID, "network", 1, registrystate.Author{UID: &uid})
+	c.Assert(err, IsNil)
+
+	// nothing changes until the change runs
+	val, err := registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "bar"})
+
+	var tx registry.Transaction
+	c.Assert(ts.Tasks()[0].Get("registry-transaction", &tx), IsNil)
+	c.Check(tx.AlteredPaths(), DeepEquals, []string{"private", "wifi"})
+
+	s.runTasks(c, ts)
+
+	val, err = registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", nil)
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "foo"})
+
+	// the revert is recorded as a new revision
+	revs, err := registrystate.History(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	c.Assert(revs, HasLen, 3)
+	c.Check(revs[2].Revision, Equals, 3)
+	c.Check(revs[2].Author, DeepEquals, registrystate.Author{UID: &uid})
+	c.Check(revs[2].Databag, DeepEquals, revs[0].Databag)
+}
+
+func (s *registryTestSuite) TestRevertRevisionNotFound(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.commitViaView(c, map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+
+	_, err := registrystate.Revert(s.state, s.devAccID, "network", 2, registrystate.Author{})
+	c.Assert(err, FitsTypeOf, &registrystate.RevisionNotFoundError{})
+	c.Assert(err, ErrorMatches, fmt.Sprintf("cannot find revision 2 of registry %s/network", s.devAccID))
+}
+
+func (s *registryTestSuite) TestRevertConflict(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.commitViaView(c, map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	s.commitViaView(c, map[string]interface{}{"ssid": "bar"}, registrystate.Author{})
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "baz"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("set-registry-view", "")
+	chg.AddAll(ts)
+
+	_, err = registrystate.Revert(s.state, s.devAccID, "network", 1, registrystate.Author{})
+	c.Assert(err, FitsTypeOf, &registrystate.ChangeConflictError{})
+}
@@ -0,0 +1,229 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package registrystate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/registry"
+)
+
+// RegistryManager is responsible for committing registry transactions that
+// involve the snaps whose views are affected by the changes.
+type RegistryManager struct{}
+
+// Manager returns a new RegistryManager.
+func Manager(st *state.State, hookMgr *hookstate.HookManager, runner *state.TaskRunner) *RegistryManager {
+	m := &RegistryManager{}
+
+	runner.AddHandler("commit-registry-tx", m.doCommitTransaction, m.undoCommitTransaction)
+	runner.AddHandler("read-registry-view", m.doReadView, nil)
+
+	hookMgr.Register(regexp.MustCompile("^change-view-[-a-z0-9]+$"), newRegistryHookHandler)
+	hookMgr.Register(regexp.MustCompile("^save-view-[-a-z0-9]+$"), newRegistryHookHandler)
+	hookMgr.Register(regexp.MustCompile("^observe-view-[-a-z0-9]+$"), newRegistryHookHandler)
+	hookMgr.Register(regexp.MustCompile("^load-view-[-a-z0-9]+$"), newRegistryHookHandler)
+
+	return m
+}
+
+// Ensure implements StateManager.Ensure.
+func (m *RegistryManager) Ensure() error {
+	return nil
+}
+
+func (m *RegistryManager) doCommitTransaction(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	tx, err := loadTransaction(st, t)
+	if err != nil {
+		return err
+	}
+
+	// keep the values being overwritten so the commit can be undone if the
+	// change is aborted or fails after this point
+	account, registryName := tx.RegistryInfo()
+	bag, err := getDatabag(st, account, registryName)
+	if err != nil && !errors.Is(err, state.ErrNoState) {
+		return err
+	}
+
+	prevValues := make(map[string]interface{})
+	for _, path := range tx.AlteredPaths() {
+		var value interface{}
+		if bag != nil {
+			value, err = bag.Get(path)
+			if err != nil {
+				if !errors.Is(err, registry.PathError("")) {
+					return err
+				}
+				value = nil
+			}
+		}
+		// a nil value means the path wasn't set
+		prevValues[path] = value
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	t.Set("previous-values", prevValues)
+	return nil
+}
+
+func (m *RegistryManager) undoCommitTransaction(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var prevValues map[string]interface{}
+	if err := t.Get("previous-values", &prevValues); err != nil {
+		if errors.Is(err, state.ErrNoState) {
+			return nil
+		}
+		return err
+	}
+
+	committedTx, err := loadTransaction(st, t)
+	if err != nil {
+		return err
+	}
+
+	account, registryName := committedTx.RegistryInfo()
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return err
+	}
+
+	// the data is restored on behalf of whoever made the undone changes
+	var author Author
+	if err := t.Get("registry-author", &author); err != nil && !errors.Is(err, state.ErrNoState) {
+		return err
+	}
+
+	tx, err := newTransaction(st, registryAssert.Registry(), author)
+	if err != nil {
+		return err
+	}
+
+	// restore outer paths first so nested values are restored on top of them
+	paths := make([]string, 0, len(prevValues))
+	for path := range prevValues {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if value := prevValues[path]; value == nil {
+			err = tx.Unset(path)
+		} else {
+			err = tx.Set(path, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// doReadView gets the values of the requested fields through the view, from
+// the data loaded into the transaction by the custodians' load-view hooks, and
+// stores them in the change's "api-data". The loaded data isn't committed.
+func (m *RegistryManager) doReadView(t *state.Task, _ *tomb.Tomb) error {
+	st := t.State()
+	st.Lock()
+	defer st.Unlock()
+
+	tx, err := loadTransaction(st, t)
+	if err != nil {
+		return err
+	}
+
+	var viewName string
+	if err := t.Get("registry-view", &viewName); err != nil {
+		return err
+	}
+
+	var fields []string
+	if err := t.Get("registry-fields", &fields); err != nil && !errors.Is(err, state.ErrNoState) {
+		return err
+	}
+
+	account, registryName := tx.RegistryInfo()
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return err
+	}
+
+	view := registryAssert.Registry().View(viewName)
+	if view == nil {
+		return fmt.Errorf("cannot find view %q in registry %s/%s", viewName, account, registryName)
+	}
+
+	values, err := GetViaViewInTx(tx, view, fields)
+	if err != nil {
+		return err
+	}
+
+	chg := t.Change()
+	var apiData map[string]interface{}
+	if err := chg.Get("api-data", &apiData); err != nil && !errors.Is(err, state.ErrNoState) {
+		return err
+	}
+	if apiData == nil {
+		apiData = make(map[string]interface{})
+	}
+	apiData["values"] = values
+	chg.Set("api-data", apiData)
+
+	return nil
+}
+
+// registryHookHandler is used for the change-view, save-view, observe-view and
+// load-view hooks. Any changes made by the hooks are kept in the transaction
+// stored in the change's commit or read task (see RegistryTransaction), so
+// there is nothing to do here.
+type registryHookHandler struct{}
+
+func newRegistryHookHandler(*hookstate.Context) hookstate.Handler {
+	return registryHookHandler{}
+}
+
+func (registryHookHandler) Before() error {
+	return nil
+}
+
+func (registryHookHandler) Done() error {
+	return nil
+}
+
+func (registryHookHandler) Error(err error) (bool, error) {
+	return false, nil
+}
@@ -20,21 +20,32 @@ package registrystate
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
+	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/hookstate"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/registry"
+	"github.com/snapcore/snapd/snap"
 )
 
 var assertstateRegistry = assertstate.Registry
 
 // SetViaView finds the view identified by the account, registry and view names
-// and sets the request fields to their respective values.
-func SetViaView(st *state.State, account, registryName, viewName string, requests map[string]interface{}) error {
+// and sets the request fields to their respective values. The changes are
+// committed by the returned task set which also runs the change-view, save-view
+// and observe-view hooks of the snaps whose views are affected by them. If an
+// ongoing change is yet to commit changes to overlapping paths in the same
+// registry, a ChangeConflictError is returned. The author is recorded in the
+// registry's history once the changes are committed.
+func SetViaView(st *state.State, account, registryName, viewName string, requests map[string]interface{}, author Author) (*state.TaskSet, error) {
 	registryAssert, err := assertstateRegistry(st, account, registryName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	reg := registryAssert.Registry()
 
@@ -48,7 +59,7 @@ func SetViaView(st *state.State, account, registryName, viewName string, request
 			}
 		}
 
-		return &registry.NotFoundError{
+		return nil, &registry.NotFoundError{
 			Account:      account,
 			RegistryName: registryName,
 			View:         viewName,
@@ -58,16 +69,20 @@ func SetViaView(st *state.State, account, registryName, viewName string, request
 		}
 	}
 
-	tx, err := newTransaction(st, reg)
+	tx, err := newTransaction(st, reg, author)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = SetViaViewInTx(tx, view, requests); err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := checkChangeConflict(st, tx); err != nil {
+		return nil, err
 	}
 
-	return tx.Commit()
+	return createChangeRegistryTasks(st, tx, author)
 }
 
 // SetViaViewInTx uses the view to set the requests in the transaction's databag.
@@ -111,7 +126,7 @@ func GetViaView(st *state.State, account, registryName, viewName string, fields
 		}
 	}
 
-	tx, err := newTransaction(st, reg)
+	tx, err := newTransaction(st, reg, Author{})
 	if err != nil {
 		return nil, err
 	}
@@ -119,6 +134,84 @@ func GetViaView(st *state.State, account, registryName, viewName string, fields
 	return GetViaViewInTx(tx, view, fields)
 }
 
+// LoadViaView returns a task set that gets the values for the specified fields
+// through the view identified by the account, registry and view names, once
+// the view's custodian snaps have loaded its data through their load-view
+// hooks. The values are stored in the change's "api-data" under "values". If
+// the view's data isn't stored by custodian snaps, or none are connected, no
+// data needs to be loaded and a nil task set is returned, in which case the
+// values can be read directly with GetViaView.
+func LoadViaView(st *state.State, account, registryName, viewName string, fields []string) (*state.TaskSet, error) {
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return nil, err
+	}
+	reg := registryAssert.Registry()
+
+	view := reg.View(viewName)
+	if view == nil {
+		return nil, &registry.NotFoundError{
+			Account:      account,
+			RegistryName: registryName,
+			View:         viewName,
+			Operation:    "get",
+			Requests:     fields,
+			Cause:        "not found",
+		}
+	}
+
+	if !view.CustodianStorage() {
+		return nil, nil
+	}
+
+	plugs, err := connectedPlugs(st, reg, map[string]bool{viewName: true})
+	if err != nil {
+		return nil, err
+	}
+
+	var custodians []registryPlug
+	for _, plug := range plugs {
+		if plug.custodian {
+			custodians = append(custodians, plug)
+		}
+	}
+	if len(custodians) == 0 {
+		return nil, nil
+	}
+
+	tx, err := newTransaction(st, reg, Author{})
+	if err != nil {
+		return nil, err
+	}
+
+	summary := fmt.Sprintf(i18n.G("Get values from registry view %s/%s/%s"), account, registryName, viewName)
+	readTask := st.NewTask("read-registry-view", summary)
+	readTask.Set("registry-transaction", tx)
+	readTask.Set("registry-view", viewName)
+	readTask.Set("registry-fields", fields)
+
+	ts := state.NewTaskSet()
+	var prev *state.Task
+	for _, plug := range custodians {
+		hooksup := &hookstate.HookSetup{
+			Snap: plug.snap,
+			Hook: "load-view-" + plug.plug,
+		}
+		summary := fmt.Sprintf(i18n.G("Run hook %s of snap %q"), hooksup.Hook, hooksup.Snap)
+		t := hookstate.HookTask(st, summary, hooksup, nil)
+		t.Set("tx-task", readTask.ID())
+		if prev != nil {
+			t.WaitFor(prev)
+		}
+		ts.AddTask(t)
+		prev = t
+	}
+
+	readTask.WaitFor(prev)
+	ts.AddTask(readTask)
+	return ts, nil
+}
+
 // GetViaViewInTx uses the view to get values for the fields from the databag
 // in the transaction.
 func GetViaViewInTx(tx *registry.Transaction, view *registry.View, fields []string) (interface{}, error) {
@@ -162,11 +255,12 @@ func GetViaViewInTx(tx *registry.Transaction, view *registry.View, fields []stri
 }
 
 // newTransaction returns a transaction configured to read and write
-// databags from state as needed.
-func newTransaction(st *state.State, reg *registry.Registry) (*registry.Transaction, error) {
+// databags from state as needed. Committed databags are recorded in the
+// registry's history as changed by the author.
+func newTransaction(st *state.State, reg *registry.Registry, author Author) (*registry.Transaction, error) {
 	getter := bagGetter(st, reg)
 	setter := func(bag registry.JSONDataBag) error {
-		return updateDatabags(st, bag, reg)
+		return updateDatabags(st, bag, reg, author)
 	}
 
 	tx, err := registry.NewTransaction(reg, getter, setter)
@@ -203,7 +297,7 @@ func getDatabag(st *state.State, account, registryName string) (registry.JSONDat
 	return databags[account][registryName], nil
 }
 
-func updateDatabags(st *state.State, databag registry.JSONDataBag, reg *registry.Registry) error {
+func updateDatabags(st *state.State, databag registry.JSONDataBag, reg *registry.Registry, author Author) error {
 	account := reg.Account
 	registryName := reg.Name
 
@@ -211,15 +305,210 @@ func updateDatabags(st *state.State, databag registry.JSONDataBag, reg *registry
 	err := st.Get("registry-databags", &databags)
 	if err != nil && !errors.Is(err, state.ErrNoState) {
 		return err
-	} else if errors.Is(err, &state.NoStateError{}) || databags[account] == nil || databags[account][registryName] == nil {
-		databags = map[string]map[string]registry.JSONDataBag{
-			account: {registryName: registry.NewJSONDataBag()},
-		}
+	}
+
+	if databags == nil {
+		databags = make(map[string]map[string]registry.JSONDataBag)
+	}
+	if databags[account] == nil {
+		databags[account] = make(map[string]registry.JSONDataBag)
 	}
 
 	databags[account][registryName] = databag
 	st.Set("registry-databags", databags)
-	return nil
+
+	return addDatabagRevision(st, account, registryName, databag, author)
+}
+
+type registryPlug struct {
+	snap      string
+	plug      string
+	custodian bool
+	// custodianStorage is true if the plug's view is stored by its custodians
+	custodianStorage bool
+}
+
+// affectedPlugs returns the connected registry plugs whose views are
+// affected by the transaction's changes, sorted by snap and plug name.
+func affectedPlugs(st *state.State, tx *registry.Transaction) ([]registryPlug, error) {
+	account, registryName := tx.RegistryInfo()
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return nil, err
+	}
+	reg := registryAssert.Registry()
+
+	affectedViews := make(map[string]bool)
+	for _, view := range reg.GetViewsAffectedByPaths(tx.AlteredPaths()) {
+		affectedViews[view.Name] = true
+	}
+	if len(affectedViews) == 0 {
+		return nil, nil
+	}
+
+	return connectedPlugs(st, reg, affectedViews)
+}
+
+// connectedPlugs returns the connected plugs of the registry's views in the
+// views set, sorted by snap and plug name.
+func connectedPlugs(st *state.State, reg *registry.Registry, views map[string]bool) ([]registryPlug, error) {
+	account, registryName := reg.Account, reg.Name
+	repo := ifacerepo.Get(st)
+	var plugs []registryPlug
+	for _, plug := range repo.AllPlugs("registry") {
+		plugAccount, plugRegistry, plugView, err := snap.RegistryPlugAttrs(plug)
+		if err != nil {
+			return nil, err
+		}
+
+		if plugAccount != account || plugRegistry != registryName || !views[plugView] {
+			continue
+		}
+
+		conns, err := repo.Connected(plug.Snap.InstanceName(), plug.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(conns) == 0 {
+			continue
+		}
+
+		var role string
+		if err := plug.Attr("role", &role); err != nil && !errors.Is(err, snap.AttributeNotFoundError{}) {
+			return nil, err
+		}
+
+		var custodianStorage bool
+		if view := reg.View(plugView); view != nil {
+			custodianStorage = view.CustodianStorage()
+		}
+
+		plugs = append(plugs, registryPlug{
+			snap:             plug.Snap.InstanceName(),
+			plug:             plug.Name,
+			custodian:        role == "custodian",
+			custodianStorage: custodianStorage,
+		})
+	}
+
+	sort.Slice(plugs, func(i, j int) bool {
+		if plugs[i].snap != plugs[j].snap {
+			return plugs[i].snap < plugs[j].snap
+		}
+		return plugs[i].plug < plugs[j].plug
+	})
+	return plugs, nil
+}
+
+// createChangeRegistryTasks returns the tasks needed to commit the transaction
+// while involving the snaps whose views are affected by it: the change-view
+// hooks of custodian snaps can modify or reject the pending changes, their
+// save-view hooks then persist them, after which the changes are committed and
+// the other snaps are notified through their observe-view hooks. The save-view
+// hooks are required for custodians that store the view's data. If a
+// change-view or save-view hook fails, the changes aren't committed and the
+// save-view hooks that already ran are run again to persist the previous data.
+// If no snap is affected, the task set only commits the changes.
+func createChangeRegistryTasks(st *state.State, tx *registry.Transaction, author Author) (*state.TaskSet, error) {
+	plugs, err := affectedPlugs(st, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	account, registryName := tx.RegistryInfo()
+	ts := state.NewTaskSet()
+	commitTask := st.NewTask("commit-registry-tx", fmt.Sprintf(i18n.G("Commit changes to registry %s/%s"), account, registryName))
+	commitTask.Set("registry-transaction", tx)
+	commitTask.Set("registry-author", author)
+
+	var prev *state.Task
+	addTask := func(t *state.Task) {
+		if prev != nil {
+			t.WaitFor(prev)
+		}
+		ts.AddTask(t)
+		prev = t
+	}
+
+	addHookTask := func(plug registryPlug, hookPrefix string, optional, undo, ignoreError bool) {
+		hooksup := &hookstate.HookSetup{
+			Snap:        plug.snap,
+			Hook:        hookPrefix + plug.plug,
+			Optional:    optional,
+			IgnoreError: ignoreError,
+		}
+		var undoHooksup *hookstate.HookSetup
+		if undo {
+			undoHooksup = hooksup
+		}
+		summary := fmt.Sprintf(i18n.G("Run hook %s of snap %q"), hooksup.Hook, hooksup.Snap)
+		t := hookstate.HookTaskWithUndo(st, summary, hooksup, undoHooksup, nil)
+		t.Set("tx-task", commitTask.ID())
+		addTask(t)
+	}
+
+	for _, plug := range plugs {
+		if plug.custodian {
+			addHookTask(plug, "change-view-", true, false, false)
+		}
+	}
+
+	for _, plug := range plugs {
+		if plug.custodian {
+			// custodians that store the view's data must persist the changes
+			addHookTask(plug, "save-view-", !plug.custodianStorage, true, false)
+		}
+	}
+
+	addTask(commitTask)
+
+	for _, plug := range plugs {
+		if !plug.custodian {
+			// the changes are already committed so observers can't fail them
+			addHookTask(plug, "observe-view-", true, false, true)
+		}
+	}
+
+	return ts, nil
+}
+
+// loadTransaction returns the transaction stored in the task of a registry
+// change that commits or reads it, ready to be read, modified or committed.
+func loadTransaction(st *state.State, txTask *state.Task) (*registry.Transaction, error) {
+	var tx registry.Transaction
+	if err := txTask.Get("registry-transaction", &tx); err != nil {
+		return nil, fmt.Errorf("cannot get registry transaction from task %s: %w", txTask.ID(), err)
+	}
+
+	account, registryName := tx.RegistryInfo()
+	registryAssert, err := assertstateRegistry(st, account, registryName)
+	if err != nil {
+		return nil, err
+	}
+	reg := registryAssert.Registry()
+
+	var author Author
+	if err := txTask.Get("registry-author", &author); err != nil && !errors.Is(err, state.ErrNoState) {
+		return nil, err
+	}
+
+	setter := func(bag registry.JSONDataBag) error {
+		return updateDatabags(st, bag, reg, author)
+	}
+	if err := tx.Resume(reg, bagGetter(st, reg), setter); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// IsRegistryHook returns true if the hook is one of the change-view, save-view,
+// observe-view or load-view hooks run as part of a registry change.
+func IsRegistryHook(hookName string) bool {
+	return strings.HasPrefix(hookName, "change-view-") ||
+		strings.HasPrefix(hookName, "load-view-") ||
+		strings.HasPrefix(hookName, "save-view-") ||
+		strings.HasPrefix(hookName, "observe-view-")
 }
 
 type cachedRegistryTx struct {
@@ -228,8 +517,12 @@ type cachedRegistryTx struct {
 }
 
 // RegistryTransaction returns the registry.Transaction cached in the context
-// or creates one and caches it, if none existed. The context must be locked by
-// the caller.
+// or creates one and caches it, if none existed. If the context belongs to a
+// hook run as part of a registry change, the transaction is the one being
+// committed (or, for load-view hooks, read) by that change, and any
+// modifications are stored back into it once the hook is done. When the hook
+// is being undone, the transaction holds the data committed before the
+// change. The context must be locked by the caller.
 func RegistryTransaction(ctx *hookstate.Context, reg *registry.Registry) (*registry.Transaction, error) {
 	key := cachedRegistryTx{
 		account:  reg.Account,
@@ -240,7 +533,48 @@ func RegistryTransaction(ctx *hookstate.Context, reg *registry.Registry) (*regis
 		return tx, nil
 	}
 
-	tx, err := newTransaction(ctx.State(), reg)
+	st := ctx.State()
+	if task, ok := ctx.Task(); ok && IsRegistryHook(ctx.HookName()) {
+		var txTaskID string
+		if err := task.Get("tx-task", &txTaskID); err != nil {
+			return nil, fmt.Errorf("internal error: cannot get transaction task of registry hook: %v", err)
+		}
+		txTask := st.Task(txTaskID)
+		if txTask == nil {
+			return nil, fmt.Errorf("internal error: cannot find transaction task %s of registry hook", txTaskID)
+		}
+
+		if task.Status() == state.UndoingStatus {
+			// the changes were not committed, so the stored data is the
+			// previous data that should be persisted again
+			tx, err := newTransaction(st, reg, Author{Snap: ctx.InstanceName(), Hook: ctx.HookName()})
+			if err != nil {
+				return nil, err
+			}
+			ctx.Cache(key, tx)
+			return tx, nil
+		}
+
+		tx, err := loadTransaction(st, txTask)
+		if err != nil {
+			return nil, err
+		}
+
+		txAccount, txRegistry := tx.RegistryInfo()
+		if txAccount != reg.Account || txRegistry != reg.Name {
+			return nil, fmt.Errorf("cannot access registry %s/%s: hook is part of a change to registry %s/%s", reg.Account, reg.Name, txAccount, txRegistry)
+		}
+
+		ctx.OnDone(func() error {
+			txTask.Set("registry-transaction", tx)
+			return nil
+		})
+
+		ctx.Cache(key, tx)
+		return tx, nil
+	}
+
+	tx, err := newTransaction(st, reg, Author{Snap: ctx.InstanceName(), Hook: ctx.HookName()})
 	if err != nil {
 		return nil, err
 	}
@@ -20,25 +20,33 @@ package registrystate_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/snapcore/snapd/asserts"
 	"github.com/snapcore/snapd/asserts/assertstest"
+	"github.com/snapcore/snapd/interfaces"
+	"github.com/snapcore/snapd/interfaces/ifacetest"
 	"github.com/snapcore/snapd/overlord"
 	"github.com/snapcore/snapd/overlord/assertstate"
 	"github.com/snapcore/snapd/overlord/assertstate/assertstatetest"
 	"github.com/snapcore/snapd/overlord/hookstate"
 	"github.com/snapcore/snapd/overlord/hookstate/hooktest"
+	"github.com/snapcore/snapd/overlord/ifacestate/ifacerepo"
 	"github.com/snapcore/snapd/overlord/registrystate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/registry"
 	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/snap/snaptest"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type registryTestSuite struct {
-	state *state.State
+	state  *state.State
+	repo   *interfaces.Repository
+	regMgr *registrystate.RegistryManager
 
 	devAccID string
 }
@@ -89,6 +97,13 @@ func (s *registryTestSuite) SetUpTest(c *C) {
 					map[string]interface{}{"request": "private.{placeholder}", "storage": "private.{placeholder}"},
 				},
 			},
+			"wifi-stored": map[string]interface{}{
+				"storage": "custodian",
+				"rules": []interface{}{
+					map[string]interface{}{"request": "ssid", "storage": "wifi.ssid"},
+					map[string]interface{}{"request": "status", "storage": "wifi.status", "access": "read"},
+				},
+			},
 		},
 		"timestamp": "2030-11-06T09:16:26Z",
 	}
@@ -118,6 +133,76 @@ func (s *registryTestSuite) SetUpTest(c *C) {
 	c.Assert(assertstate.Add(s.state, as), IsNil)
 
 	s.devAccID = devAccKey.AccountID()
+
+	runner := state.NewTaskRunner(s.state)
+	hookMgr, err := hookstate.Manager(s.state, runner)
+	c.Assert(err, IsNil)
+	s.regMgr = registrystate.Manager(s.state, hookMgr, runner)
+
+	s.repo = interfaces.NewRepository()
+	ifacerepo.Replace(s.state, s.repo)
+	c.Assert(s.repo.AddInterface(&ifacetest.TestInterface{InterfaceName: "registry"}), IsNil)
+
+	const coreYaml = `name: core
+version: 1.0
+type: os
+slots:
+  registry-slot:
+    interface: registry
+`
+	s.addSnap(c, coreYaml)
+}
+
+func (s *registryTestSuite) addSnap(c *C, snapYaml string) *snap.Info {
+	info := snaptest.MockInfo(c, snapYaml, nil)
+	appSet, err := interfaces.NewSnapAppSet(info, nil)
+	c.Assert(err, IsNil)
+	c.Assert(s.repo.AddAppSet(appSet), IsNil)
+	return info
+}
+
+func (s *registryTestSuite) addRegistrySnap(c *C, name, plug, role string, connect bool) {
+	s.addRegistryViewSnap(c, name, plug, "wifi-setup", role, connect)
+}
+
+func (s *registryTestSuite) addRegistryViewSnap(c *C, name, plug, view, role string, connect bool) {
+	snapYaml := fmt.Sprintf(`name: %s
+version: 1
+plugs:
+  %s:
+    interface: registry
+    account: %s
+    view: network/%s
+`, name, plug, s.devAccID, view)
+	if role != "" {
+		snapYaml += fmt.Sprintf("    role: %s\n", role)
+	}
+	s.addSnap(c, snapYaml)
+
+	if connect {
+		ref := &interfaces.ConnRef{
+			PlugRef: interfaces.PlugRef{Snap: name, Name: plug},
+			SlotRef: interfaces.SlotRef{Snap: "core", Name: "registry-slot"},
+		}
+		_, err := s.repo.Connect(ref, nil, nil, nil, nil, nil)
+		c.Assert(err, IsNil)
+	}
+}
+
+// setViaView sets the values through the view and commits them as if the
+// change had run. Must be called with the state locked.
+func (s *registryTestSuite) setViaView(c *C, view string, requests map[string]interface{}) {
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", view, requests, registrystate.Author{})
+	c.Assert(err, IsNil)
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Assert(tasks[0].Kind(), Equals, "commit-registry-tx")
+
+	s.state.Unlock()
+	err = s.regMgr.DoCommitTransaction(tasks[0], nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
 }
 
 func (s *registryTestSuite) TestGetView(c *C) {
@@ -158,11 +243,10 @@ func (s *registryTestSuite) TestSetView(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"})
-	c.Assert(err, IsNil)
+	s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": "foo"})
 
 	var databags map[string]map[string]registry.JSONDataBag
-	err = s.state.Get("registry-databags", &databags)
+	err := s.state.Get("registry-databags", &databags)
 	c.Assert(err, IsNil)
 
 	val, err := databags[s.devAccID]["network"].Get("wifi.ssid")
@@ -174,11 +258,11 @@ func (s *registryTestSuite) TestSetNotFound(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"foo": "bar"})
+	_, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"foo": "bar"}, registrystate.Author{})
 	c.Assert(err, FitsTypeOf, &registry.NotFoundError{})
 	c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot set "foo" in registry view %s/network/wifi-setup: no matching write rule`, s.devAccID))
 
-	err = registrystate.SetViaView(s.state, s.devAccID, "network", "other-view", map[string]interface{}{"foo": "bar"})
+	_, err = registrystate.SetViaView(s.state, s.devAccID, "network", "other-view", map[string]interface{}{"foo": "bar"}, registrystate.Author{})
 	c.Assert(err, FitsTypeOf, &registry.NotFoundError{})
 	c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot set "foo" in registry view %s/network/other-view: not found`, s.devAccID))
 }
@@ -188,11 +272,9 @@ func (s *registryTestSuite) TestUnsetView(c *C) {
 	defer s.state.Unlock()
 
 	databag := registry.NewJSONDataBag()
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"})
-	c.Assert(err, IsNil)
+	s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": "foo"})
 
-	err = registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": nil})
-	c.Assert(err, IsNil)
+	s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": nil})
 
 	val, err := databag.Get("wifi.ssid")
 	c.Assert(err, FitsTypeOf, registry.PathError(""))
@@ -218,8 +300,7 @@ func (s *registryTestSuite) TestRegistrystateSetWithExistingState(c *C) {
 	c.Assert(ok, Equals, true)
 	c.Assert(resultsMap["ssid"], Equals, "bar")
 
-	err = registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "baz"})
-	c.Assert(err, IsNil)
+	s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": "baz"})
 
 	err = s.state.Get("registry-databags", &databags)
 	c.Assert(err, IsNil)
@@ -257,11 +338,10 @@ func (s *registryTestSuite) TestRegistrystateSetWithNoState(c *C) {
 	for _, tc := range testcases {
 		s.state.Set("registry-databags", tc.state)
 
-		err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "bar"})
-		c.Assert(err, IsNil)
+		s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": "bar"})
 
 		var databags map[string]map[string]registry.JSONDataBag
-		err = s.state.Get("registry-databags", &databags)
+		err := s.state.Get("registry-databags", &databags)
 		c.Assert(err, IsNil)
 
 		value, err := databags[s.devAccID]["network"].Get("wifi.ssid")
@@ -274,7 +354,7 @@ func (s *registryTestSuite) TestRegistrystateGetEntireView(c *C) {
 	s.state.Lock()
 	defer s.state.Unlock()
 
-	err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{
+	s.setViaView(c, "wifi-setup", map[string]interface{}{
 		"ssids":    []interface{}{"foo", "bar"},
 		"password": "pass",
 		"private": map[string]interface{}{
@@ -282,7 +362,6 @@ func (s *registryTestSuite) TestRegistrystateGetEntireView(c *C) {
 			"b": 2,
 		},
 	})
-	c.Assert(err, IsNil)
 
 	res, err := registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", nil)
 	c.Assert(err, IsNil)
@@ -366,3 +445,472 @@ func (s *registryTestSuite) TestRegistryTransaction(c *C) {
 		ctx.Unlock()
 	}
 }
+
+func (s *registryTestSuite) TestSetViaViewCreatesHookTasks(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.addRegistrySnap(c, "custodian-snap", "setup-wifi", "custodian", true)
+	s.addRegistrySnap(c, "observer-snap", "watch-wifi", "", true)
+	s.addRegistrySnap(c, "disconnected-snap", "watch-wifi", "", false)
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	c.Assert(ts, NotNil)
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 4)
+	commitTask := tasks[2]
+	c.Check(commitTask.Kind(), Equals, "commit-registry-tx")
+	c.Check(commitTask.Summary(), Equals, fmt.Sprintf("Commit changes to registry %s/network", s.devAccID))
+
+	expected := []struct {
+		snap    string
+		hook    string
+		undo    bool
+		ignored bool
+	}{
+		{snap: "custodian-snap", hook: "change-view-setup-wifi"},
+		{snap: "custodian-snap", hook: "save-view-setup-wifi", undo: true},
+		{},
+		{snap: "observer-snap", hook: "observe-view-watch-wifi", ignored: true},
+	}
+	for i, t := range tasks {
+		if i > 0 {
+			c.Check(t.WaitTasks(), DeepEquals, []*state.Task{tasks[i-1]})
+		}
+		if t == commitTask {
+			continue
+		}
+
+		c.Check(t.Kind(), Equals, "run-hook")
+		var hooksup hookstate.HookSetup
+		c.Assert(t.Get("hook-setup", &hooksup), IsNil)
+		c.Check(hooksup.Snap, Equals, expected[i].snap)
+		c.Check(hooksup.Hook, Equals, expected[i].hook)
+		c.Check(hooksup.Optional, Equals, true)
+		c.Check(hooksup.IgnoreError, Equals, expected[i].ignored)
+
+		var undoHooksup hookstate.HookSetup
+		err := t.Get("undo-hook-setup", &undoHooksup)
+		if expected[i].undo {
+			c.Assert(err, IsNil)
+			c.Check(undoHooksup, DeepEquals, hooksup)
+		} else {
+			c.Check(err, testutil.ErrorIs, state.ErrNoState)
+		}
+
+		var txTaskID string
+		c.Assert(t.Get("tx-task", &txTaskID), IsNil)
+		c.Check(txTaskID, Equals, commitTask.ID())
+	}
+
+	// the changes are not committed until the commit task runs
+	var databags map[string]map[string]registry.JSONDataBag
+	err = s.state.Get("registry-databags", &databags)
+	c.Assert(err, testutil.ErrorIs, state.ErrNoState)
+
+	var tx registry.Transaction
+	c.Assert(commitTask.Get("registry-transaction", &tx), IsNil)
+	c.Check(tx.AlteredPaths(), DeepEquals, []string{"wifi.ssid"})
+}
+
+func (s *registryTestSuite) TestSetViaViewOnlyObservers(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.addRegistrySnap(c, "observer-snap", "watch-wifi", "", true)
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	c.Assert(ts, NotNil)
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 2)
+	c.Check(tasks[0].Kind(), Equals, "commit-registry-tx")
+	c.Check(tasks[1].Kind(), Equals, "run-hook")
+	c.Check(tasks[1].WaitTasks(), DeepEquals, []*state.Task{tasks[0]})
+}
+
+func (s *registryTestSuite) TestSetViaViewNoSnapsOnlyCommits(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// not connected so it isn't involved
+	s.addRegistrySnap(c, "custodian-snap", "setup-wifi", "custodian", false)
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Kind(), Equals, "commit-registry-tx")
+
+	// nothing is committed until the task runs
+	_, err = registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, FitsTypeOf, &registry.NotFoundError{})
+
+	s.state.Unlock()
+	err = s.regMgr.DoCommitTransaction(tasks[0], nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	val, err := registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "foo"})
+}
+
+func (s *registryTestSuite) TestSetViaViewConflict(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("set-registry-view", "")
+	chg.AddAll(ts)
+
+	// writing to the same path conflicts with the ongoing change
+	_, err = registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "bar"}, registrystate.Author{})
+	c.Assert(err, FitsTypeOf, &registrystate.ChangeConflictError{})
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot change "wifi.ssid" in registry %s/network: "set-registry-view" change in progress \(change %s\)`, s.devAccID, chg.ID()))
+
+	// other paths can be written
+	_, err = registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"password": "secret"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+
+	// once the changes are committed, there's no conflict
+	ts.Tasks()[0].SetStatus(state.DoneStatus)
+	_, err = registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "bar"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+}
+
+func (s *registryTestSuite) TestUndoCommitTransaction(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.setViaView(c, "wifi-setup", map[string]interface{}{"ssid": "old"})
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "new", "ssids": []interface{}{"new"}}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	commitTask := ts.Tasks()[0]
+
+	s.state.Unlock()
+	err = s.regMgr.DoCommitTransaction(commitTask, nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	val, err := registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid", "ssids"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "new", "ssids": []interface{}{"new"}})
+
+	s.state.Unlock()
+	err = s.regMgr.UndoCommitTransaction(commitTask, nil)
+	s.state.Lock()
+	c.Assert(err, IsNil)
+
+	// the previous values are restored and the new ones removed
+	val, err = registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid", "ssids"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "old"})
+}
+
+func (s *registryTestSuite) setupRegistryChange(c *C) (commitTask *state.Task, hookTask func(hook string) *hookstate.Context) {
+	s.addRegistrySnap(c, "custodian-snap", "setup-wifi", "custodian", true)
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("set-registry-view", "")
+	chg.AddAll(ts)
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 3)
+
+	// the returned function must be called without holding the state lock
+	hookTask = func(hook string) *hookstate.Context {
+		s.state.Lock()
+		defer s.state.Unlock()
+		for _, t := range tasks {
+			var hooksup hookstate.HookSetup
+			if t.Get("hook-setup", &hooksup) == nil && hooksup.Hook == hook {
+				ctx, err := hookstate.NewContext(t, s.state, &hooksup, hooktest.NewMockHandler(), "")
+				c.Assert(err, IsNil)
+				return ctx
+			}
+		}
+		c.Fatalf("no task for hook %s", hook)
+		return nil
+	}
+	return tasks[2], hookTask
+}
+
+func (s *registryTestSuite) networkRegistry(c *C) *registry.Registry {
+	s.state.Lock()
+	defer s.state.Unlock()
+	registryAssert, err := assertstate.Registry(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	return registryAssert.Registry()
+}
+
+func (s *registryTestSuite) TestRegistryTransactionInChangeViewHook(c *C) {
+	s.state.Lock()
+	commitTask, hookCtx := s.setupRegistryChange(c)
+	s.state.Unlock()
+	reg := s.networkRegistry(c)
+
+	ctx := hookCtx("change-view-setup-wifi")
+	ctx.Lock()
+	tx, err := registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, IsNil)
+
+	// the hook sees the pending changes and can modify them
+	val, err := tx.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "foo")
+	c.Assert(tx.Set("wifi.ssid", "bar"), IsNil)
+
+	// nothing is committed when the hook is done, but the changes are stored
+	c.Assert(ctx.Done(), IsNil)
+	var databags map[string]map[string]registry.JSONDataBag
+	err = s.state.Get("registry-databags", &databags)
+	c.Assert(err, testutil.ErrorIs, state.ErrNoState)
+	ctx.Unlock()
+
+	// a new context for the same hook gets the modified transaction
+	ctx = hookCtx("change-view-setup-wifi")
+	ctx.Lock()
+	tx, err = registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, IsNil)
+	val, err = tx.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "bar")
+	ctx.Unlock()
+
+	// the changes are committed by the commit task
+	err = s.regMgr.DoCommitTransaction(commitTask, nil)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	val, err = registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "bar"})
+}
+
+func (s *registryTestSuite) TestRegistryTransactionInUndoneSaveViewHook(c *C) {
+	s.state.Lock()
+	databag := registry.NewJSONDataBag()
+	c.Assert(databag.Set("wifi.ssid", "old"), IsNil)
+	s.state.Set("registry-databags", map[string]map[string]registry.JSONDataBag{s.devAccID: {"network": databag}})
+	_, hookCtx := s.setupRegistryChange(c)
+	s.state.Unlock()
+	reg := s.networkRegistry(c)
+
+	ctx := hookCtx("save-view-setup-wifi")
+	ctx.Lock()
+	tx, err := registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, IsNil)
+	val, err := tx.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "foo")
+	ctx.Unlock()
+
+	// when undoing, the hook sees the previously committed data
+	ctx = hookCtx("save-view-setup-wifi")
+	ctx.Lock()
+	defer ctx.Unlock()
+	task, _ := ctx.Task()
+	task.SetStatus(state.UndoingStatus)
+	tx, err = registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, IsNil)
+	val, err = tx.Get("wifi.ssid")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "old")
+	c.Check(tx.AlteredPaths(), HasLen, 0)
+}
+
+func (s *registryTestSuite) TestRegistryTransactionInHookOtherRegistry(c *C) {
+	s.state.Lock()
+	_, hookCtx := s.setupRegistryChange(c)
+	s.state.Unlock()
+
+	reg, err := registry.New("acc", "other", map[string]interface{}{
+		"bar": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "foo", "storage": "foo"},
+			},
+		},
+	}, registry.NewJSONSchema())
+	c.Assert(err, IsNil)
+
+	ctx := hookCtx("change-view-setup-wifi")
+	ctx.Lock()
+	defer ctx.Unlock()
+	_, err = registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, ErrorMatches, fmt.Sprintf("cannot access registry acc/other: hook is part of a change to registry %s/network", s.devAccID))
+}
+
+func (s *registryTestSuite) TestCommitTransactionFailsValidation(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	commitTask, _ := s.setupRegistryChange(c)
+
+	var tx registry.Transaction
+	c.Assert(commitTask.Get("registry-transaction", &tx), IsNil)
+	// sneak in a value that doesn't match the schema
+	registryAssert, err := assertstate.Registry(s.state, s.devAccID, "network")
+	c.Assert(err, IsNil)
+	c.Assert(tx.Resume(registryAssert.Registry(), nil, nil), IsNil)
+	c.Assert(tx.Set("wifi.ssid", 1), IsNil)
+	commitTask.Set("registry-transaction", &tx)
+
+	s.state.Unlock()
+	err = s.regMgr.DoCommitTransaction(commitTask, nil)
+	s.state.Lock()
+	c.Assert(err, ErrorMatches, `.*expected string type but value was number`)
+
+	_, err = registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, FitsTypeOf, &registry.NotFoundError{})
+}
+
+func (s *registryTestSuite) TestSetViaViewCustodianStorageRequiresSaveHook(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.addRegistryViewSnap(c, "custodian-snap", "store-wifi", "wifi-stored", "custodian", true)
+	s.addRegistrySnap(c, "other-custodian-snap", "setup-wifi", "custodian", true)
+
+	ts, err := registrystate.SetViaView(s.state, s.devAccID, "network", "wifi-setup", map[string]interface{}{"ssid": "foo"}, registrystate.Author{})
+	c.Assert(err, IsNil)
+
+	var saveHooks []hookstate.HookSetup
+	for _, t := range ts.Tasks() {
+		var hooksup hookstate.HookSetup
+		if t.Get("hook-setup", &hooksup) == nil && strings.HasPrefix(hooksup.Hook, "save-view-") {
+			saveHooks = append(saveHooks, hooksup)
+		}
+	}
+
+	c.Assert(saveHooks, HasLen, 2)
+	c.Check(saveHooks[0].Snap, Equals, "custodian-snap")
+	c.Check(saveHooks[0].Hook, Equals, "save-view-store-wifi")
+	// the snap stores the view's data so it must save it
+	c.Check(saveHooks[0].Optional, Equals, false)
+	c.Check(saveHooks[1].Snap, Equals, "other-custodian-snap")
+	c.Check(saveHooks[1].Hook, Equals, "save-view-setup-wifi")
+	c.Check(saveHooks[1].Optional, Equals, true)
+}
+
+func (s *registryTestSuite) TestLoadViaViewNotNeeded(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the view's data is stored by snapd
+	s.addRegistrySnap(c, "custodian-snap", "setup-wifi", "custodian", true)
+	ts, err := registrystate.LoadViaView(s.state, s.devAccID, "network", "wifi-setup", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(ts, IsNil)
+
+	// there are no connected custodians to load the view's data
+	s.addRegistryViewSnap(c, "observer-snap", "watch-wifi", "wifi-stored", "", true)
+	s.addRegistryViewSnap(c, "disconnected-snap", "store-wifi", "wifi-stored", "custodian", false)
+	ts, err = registrystate.LoadViaView(s.state, s.devAccID, "network", "wifi-stored", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(ts, IsNil)
+}
+
+func (s *registryTestSuite) TestLoadViaViewNotFound(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := registrystate.LoadViaView(s.state, s.devAccID, "network", "other-view", []string{"ssid"})
+	c.Assert(err, FitsTypeOf, &registry.NotFoundError{})
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot get "ssid" in registry view %s/network/other-view: not found`, s.devAccID))
+}
+
+func (s *registryTestSuite) TestLoadViaView(c *C) {
+	s.state.Lock()
+	databag := registry.NewJSONDataBag()
+	c.Assert(databag.Set("wifi.ssid", "stale"), IsNil)
+	s.state.Set("registry-databags", map[string]map[string]registry.JSONDataBag{s.devAccID: {"network": databag}})
+
+	s.addRegistryViewSnap(c, "custodian-snap", "store-wifi", "wifi-stored", "custodian", true)
+	s.addRegistryViewSnap(c, "other-custodian-snap", "store-wifi", "wifi-stored", "custodian", true)
+	s.addRegistryViewSnap(c, "observer-snap", "watch-wifi", "wifi-stored", "", true)
+
+	ts, err := registrystate.LoadViaView(s.state, s.devAccID, "network", "wifi-stored", []string{"ssid", "status"})
+	c.Assert(err, IsNil)
+	c.Assert(ts, NotNil)
+	chg := s.state.NewChange("get-registry-view", "")
+	chg.AddAll(ts)
+
+	tasks := ts.Tasks()
+	c.Assert(tasks, HasLen, 3)
+	readTask := tasks[2]
+	c.Check(readTask.Kind(), Equals, "read-registry-view")
+	c.Check(readTask.Summary(), Equals, fmt.Sprintf("Get values from registry view %s/network/wifi-stored", s.devAccID))
+	c.Check(readTask.WaitTasks(), DeepEquals, []*state.Task{tasks[1]})
+	c.Check(tasks[1].WaitTasks(), DeepEquals, []*state.Task{tasks[0]})
+
+	for i, snapName := range []string{"custodian-snap", "other-custodian-snap"} {
+		t := tasks[i]
+		c.Check(t.Kind(), Equals, "run-hook")
+		var hooksup hookstate.HookSetup
+		c.Assert(t.Get("hook-setup", &hooksup), IsNil)
+		c.Check(hooksup.Snap, Equals, snapName)
+		c.Check(hooksup.Hook, Equals, "load-view-store-wifi")
+		c.Check(hooksup.Optional, Equals, false)
+
+		var txTaskID string
+		c.Assert(t.Get("tx-task", &txTaskID), IsNil)
+		c.Check(txTaskID, Equals, readTask.ID())
+	}
+
+	// the custodians load the data into the transaction
+	var hooksup hookstate.HookSetup
+	c.Assert(tasks[0].Get("hook-setup", &hooksup), IsNil)
+	s.state.Unlock()
+
+	reg := s.networkRegistry(c)
+	ctx, err := hookstate.NewContext(tasks[0], s.state, &hooksup, hooktest.NewMockHandler(), "")
+	c.Assert(err, IsNil)
+	ctx.Lock()
+	tx, err := registrystate.RegistryTransaction(ctx, reg)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Set("wifi.ssid", "loaded"), IsNil)
+	c.Assert(tx.Set("wifi.status", "connected"), IsNil)
+	c.Assert(ctx.Done(), IsNil)
+	ctx.Unlock()
+
+	err = s.regMgr.DoReadView(readTask, nil)
+	c.Assert(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	var apiData map[string]interface{}
+	c.Assert(chg.Get("api-data", &apiData), IsNil)
+	c.Check(apiData, DeepEquals, map[string]interface{}{
+		"values": map[string]interface{}{"ssid": "loaded", "status": "connected"},
+	})
+
+	// the loaded data isn't committed
+	val, err := registrystate.GetViaView(s.state, s.devAccID, "network", "wifi-stored", []string{"ssid"})
+	c.Assert(err, IsNil)
+	c.Check(val, DeepEquals, map[string]interface{}{"ssid": "stale"})
+}
+
+func (s *registryTestSuite) TestReadViewNotFound(c *C) {
+	s.state.Lock()
+	s.addRegistryViewSnap(c, "custodian-snap", "store-wifi", "wifi-stored", "custodian", true)
+
+	ts, err := registrystate.LoadViaView(s.state, s.devAccID, "network", "wifi-stored", []string{"ssid"})
+	c.Assert(err, IsNil)
+	chg := s.state.NewChange("get-registry-view", "")
+	chg.AddAll(ts)
+	readTask := ts.Tasks()[1]
+	s.state.Unlock()
+
+	// the custodian didn't load any data
+	err = s.regMgr.DoReadView(readTask, nil)
+	c.Assert(err, ErrorMatches, fmt.Sprintf(`cannot get "ssid" in registry view %s/network/wifi-stored: matching rules don't map to any values`, s.devAccID))
+}
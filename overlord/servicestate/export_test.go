@@ -20,6 +20,8 @@
 package servicestate
 
 import (
+	"time"
+
 	tomb "gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/overlord/state"
@@ -76,3 +78,33 @@ func MockResourcesCheckFeatureRequirements(f func(*quota.Resources) error) (rest
 	resourcesCheckFeatureRequirements = f
 	return r
 }
+
+func MockNftAvailable(available bool) (restore func()) {
+	r := testutil.Backup(&nftAvailable)
+	nftAvailable = func() bool {
+		return available
+	}
+	return r
+}
+
+func (m *ServiceManager) EnsureQuotaUsageSampled() error {
+	return m.ensureQuotaUsageSampled()
+}
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	r := testutil.Backup(&timeNow)
+	timeNow = f
+	return r
+}
+
+func MockQuotaUsageHistorySize(size int) (restore func()) {
+	r := testutil.Backup(&quotaUsageHistorySize)
+	quotaUsageHistorySize = size
+	return r
+}
+
+func MockQuotaGroupUsage(f func(grp *quota.Group) (usage, limits map[string]uint64, err error)) (restore func()) {
+	r := testutil.Backup(&quotaGroupUsage)
+	quotaGroupUsage = f
+	return r
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/i18n"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/servicestate/internal"
 	"github.com/snapcore/snapd/overlord/snapstate"
@@ -65,6 +66,12 @@ var resourcesCheckFeatureRequirements = func(r *quota.Resources) error {
 	return r.CheckFeatureRequirements()
 }
 
+// nftAvailable returns whether the command used to enforce network quotas is
+// available.
+var nftAvailable = func() bool {
+	return osutil.IsExecutable(quota.NftCommand)
+}
+
 func quotaGroupsAvailable(st *state.State) error {
 	// check if the systemd version is too old
 	if systemdVersionError != nil {
@@ -98,6 +105,21 @@ func verifyQuotaRequirements(st *state.State, resourceLimits quota.Resources) er
 	// MemoryLimit requires systemd 211, so it's covered by the initial check
 	// CPUQuota requires systemd 213, so no further checks need to be done
 	// TasksMax requires systemd 228, so no further checks need to be done
+	// IOReadBandwidthMax and friends require systemd 230, so they are also covered
+
+	// MemorySwapMax requires systemd 232, MemoryHigh a little less
+	if resourceLimits.Memory != nil && (resourceLimits.Memory.High != 0 || resourceLimits.Memory.SwapMax != nil) {
+		if err := systemd.EnsureAtLeast(232); err != nil {
+			return fmt.Errorf("cannot use memory high or swap quota with incompatible systemd: %v", err)
+		}
+	}
+
+	// OOMPolicy requires systemd 243
+	if resourceLimits.Memory != nil && resourceLimits.Memory.OOMPolicy != "" {
+		if err := systemd.EnsureAtLeast(243); err != nil {
+			return fmt.Errorf("cannot use memory oom policy %q with incompatible systemd: %v", resourceLimits.Memory.OOMPolicy, err)
+		}
+	}
 
 	// AllowedCPUs requires systemd 243, so we need to verify the version here
 	if resourceLimits.CPUSet != nil {
@@ -117,6 +139,22 @@ func verifyQuotaRequirements(st *state.State, resourceLimits quota.Resources) er
 			return err
 		}
 	}
+
+	// Network quotas need IPAccounting, which requires systemd 235, and nft
+	// to enforce the limits
+	if resourceLimits.Network != nil {
+		if err := systemd.EnsureAtLeast(235); err != nil {
+			return fmt.Errorf("cannot use network quota with incompatible systemd: %v", err)
+		}
+		if !nftAvailable() {
+			return fmt.Errorf("cannot use network quota: %s is not available", quota.NftCommand)
+		}
+
+		// To use network quotas, the quota-group experimental features must be enabled.
+		if err := isExperimentalQuotasAvailable(st, "network"); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -137,6 +175,11 @@ type CreateQuotaOptions struct {
 
 	// ResourceLimits is the resource limits to be used for the quota group.
 	ResourceLimits quota.Resources
+
+	// UserApps is whether the apps of the snaps in the quota group, including
+	// their user daemons, are subject to the limits of the group too, and
+	// not only their services.
+	UserApps bool
 }
 
 // CreateQuota attempts to create the specified quota group with the specified
@@ -160,6 +203,9 @@ func CreateQuota(st *state.State, name string, createOpts CreateQuotaOptions) (*
 	if len(createOpts.Snaps) > 0 && len(createOpts.Services) > 0 {
 		return nil, fmt.Errorf("cannot mix services and snaps in the same quota group")
 	}
+	if createOpts.UserApps && len(createOpts.Services) > 0 {
+		return nil, fmt.Errorf("cannot create quota group %q: user apps are not supported for groups of individual services", name)
+	}
 
 	// validate the resource limits for the group
 	if err := createOpts.ResourceLimits.Validate(); err != nil {
@@ -198,6 +244,9 @@ func CreateQuota(st *state.State, name string, createOpts CreateQuotaOptions) (*
 		AddServices:    createOpts.Services,
 		ParentName:     createOpts.ParentName,
 	}
+	if createOpts.UserApps {
+		qc.UserApps = &createOpts.UserApps
+	}
 
 	ts := state.NewTaskSet()
 
@@ -273,6 +322,11 @@ type UpdateQuotaOptions struct {
 	// NewResourceLimits is the new resource limits to be used for the quota group. A
 	// limit is only changed if the corresponding limit is != nil.
 	NewResourceLimits quota.Resources
+
+	// UserApps, if not nil, is whether the apps of the snaps in the quota
+	// group are subject to the limits of the group too, and not only their
+	// services.
+	UserApps *bool
 }
 
 // UpdateQuota updates the quota as per the options.
@@ -307,6 +361,9 @@ func UpdateQuota(st *state.State, name string, updateOpts UpdateQuotaOptions) (*
 	if err := groupEnsureOnlySnapsOrServices(updateOpts.AddSnaps, updateOpts.AddServices, grp); err != nil {
 		return nil, err
 	}
+	if updateOpts.UserApps != nil && *updateOpts.UserApps && (len(grp.Services) > 0 || len(updateOpts.AddServices) > 0) {
+		return nil, fmt.Errorf("cannot update group %q: user apps are not supported for groups of individual services", name)
+	}
 
 	// now ensure that all of the snaps mentioned in AddSnaps exist as snaps and
 	// that they aren't already in an existing quota group
@@ -335,6 +392,7 @@ func UpdateQuota(st *state.State, name string, updateOpts UpdateQuotaOptions) (*
 		ResourceLimits: updateOpts.NewResourceLimits,
 		AddSnaps:       updateOpts.AddSnaps,
 		AddServices:    updateOpts.AddServices,
+		UserApps:       updateOpts.UserApps,
 	}
 
 	ts := state.NewTaskSet()
@@ -144,7 +144,8 @@ func checkQuotaState(c *C, st *state.State, exp map[string]quotaGroupState) {
 func shouldMentionSlice(resources quota.Resources) bool {
 	if resources.Memory == nil && resources.CPU == nil &&
 		resources.CPUSet == nil && resources.Threads == nil &&
-		resources.Journal == nil {
+		resources.Journal == nil && resources.IO == nil &&
+		resources.Network == nil {
 		return false
 	}
 	return true
@@ -186,6 +187,9 @@ func checkSliceState(c *C, sliceName string, resources quota.Resources) {
 	if resources.Threads != nil {
 		c.Assert(sliceFileName, testutil.FileContains, fmt.Sprintf("\nThreadsMax=%d\n", resources.Threads.Limit))
 	}
+	if resources.Network != nil {
+		c.Assert(sliceFileName, testutil.FileContains, "\nIPAccounting=true\n")
+	}
 }
 
 func systemctlCallsForSliceStart(name string) []expectedSystemctl {
@@ -337,6 +341,10 @@ func (s *quotaControlSuite) TestCreateQuotaPerQuotaSystemdTooOld(c *C) {
 
 		{quota.NewResourcesBuilder().WithCPUSet([]int{0, 1}).Build(), 243, `cannot use the cpu-set quota with incompatible systemd: systemd version 242 is too old \(expected at least 243\)`},
 		{quota.NewResourcesBuilder().WithJournalSize(quantity.SizeGiB).Build(), 245, `cannot use journal quota with incompatible systemd: systemd version 244 is too old \(expected at least 245\)`},
+		{quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(), 235, `cannot use network quota with incompatible systemd: systemd version 234 is too old \(expected at least 235\)`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemorySwapMax(quantity.SizeGiB).Build(), 232, `cannot use memory high or swap quota with incompatible systemd: systemd version 231 is too old \(expected at least 232\)`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillProcess).Build(), 243, `cannot use memory oom policy "kill-process" with incompatible systemd: systemd version 242 is too old \(expected at least 243\)`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build(), 243, `cannot use memory oom policy "kill-group" with incompatible systemd: systemd version 242 is too old \(expected at least 243\)`},
 	}
 
 	for _, t := range tests {
@@ -380,6 +388,65 @@ func (s *quotaControlSuite) TestCreateQuotaJournalEnabled(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *quotaControlSuite) TestCreateQuotaNetworkNoNft(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	r := servicestate.MockNftAvailable(false)
+	defer r()
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.quota-groups", true)
+	tr.Commit()
+
+	_, err := servicestate.CreateQuota(s.state, "foo", servicestate.CreateQuotaOptions{
+		ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+	})
+	c.Assert(err, ErrorMatches, `cannot use network quota: /usr/sbin/nft is not available`)
+}
+
+func (s *quotaControlSuite) TestCreateQuotaNetworkNotEnabled(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	r := servicestate.MockNftAvailable(true)
+	defer r()
+
+	_, err := servicestate.CreateQuota(s.state, "foo", servicestate.CreateQuotaOptions{
+		ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+	})
+	c.Assert(err, ErrorMatches, `network quota options are experimental - test it by setting 'experimental.quota-groups' to true`)
+}
+
+func (s *quotaControlSuite) TestCreateQuotaNetworkEnabled(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	r := servicestate.MockNftAvailable(true)
+	defer r()
+
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "experimental.quota-groups", true)
+	tr.Commit()
+
+	_, err := servicestate.CreateQuota(s.state, "foo", servicestate.CreateQuotaOptions{
+		ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *quotaControlSuite) TestCreateQuotaUserAppsWithServicesFails(c *C) {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	_, err := servicestate.CreateQuota(s.state, "foo", servicestate.CreateQuotaOptions{
+		Services:       []string{"test-snap.svc1"},
+		ResourceLimits: quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build(),
+		UserApps:       true,
+	})
+	c.Assert(err, ErrorMatches, `cannot create quota group "foo": user apps are not supported for groups of individual services`)
+}
+
 func (s *quotaControlSuite) TestCreateQuotaPrecond(c *C) {
 	st := s.state
 	st.Lock()
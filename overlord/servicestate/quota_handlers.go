@@ -73,6 +73,11 @@ type QuotaControlAction struct {
 	// support moving quota groups from one parent to another, but that is
 	// currently not supported.
 	ParentName string `json:"parent-name,omitempty"`
+
+	// UserApps, if set, is whether the apps of the snaps in the quota group,
+	// and not only their services, are subject to the limits of the group,
+	// for either the "create" or the "update" actions.
+	UserApps *bool `json:"user-apps,omitempty"`
 }
 
 func (m *ServiceManager) doQuotaControl(t *state.Task, _ *tomb.Tomb) error {
@@ -414,6 +419,13 @@ func quotaCreate(st *state.State, action QuotaControlAction, allGrps map[string]
 	if err != nil {
 		return nil, nil, false, err
 	}
+	if action.UserApps != nil && *action.UserApps {
+		grp.UserApps = true
+		allGrps, err = internal.PatchQuotas(st, grp)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
 	refreshProfiles := grp.JournalLimit != nil
 	return grp, allGrps, refreshProfiles, nil
 }
@@ -548,6 +560,9 @@ func quotaUpdate(st *state.State, action QuotaControlAction, allGrps map[string]
 	// append snap list and service list in the group
 	grp.Snaps = append(grp.Snaps, action.AddSnaps...)
 	grp.Services = append(grp.Services, action.AddServices...)
+	if action.UserApps != nil {
+		grp.UserApps = *action.UserApps
+	}
 
 	// store the current status of journal quota, if it changes we need
 	// to refresh the profiles for the snaps in the groups
@@ -764,6 +779,24 @@ func ensureSnapServicesForGroup(st *state.State, t *state.Task, grp *quota.Group
 				serviceName := fmt.Sprintf("systemd-journald@%s", grp.JournalNamespaceName())
 				journalsToRestart = append(journalsToRestart, serviceName)
 			}
+
+		case "network":
+			// the network quota rules are loaded when the services
+			// are started, so all the services in the quota group need
+			// restarting when they are added or modified
+			for info := range snapSvcMap {
+				for _, app := range info.Apps {
+					if app.IsService() {
+						markAppForRestart(info, app)
+					}
+				}
+			}
+
+		case "user-slice", "user-apps":
+			// the instances of systemd of the users are reloaded by
+			// EnsureSnapServices, and apps started from now on are
+			// placed in the slice of the group, so there is nothing
+			// more to do
 		}
 	}
 	if err := wrappers.EnsureSnapServices(snapSvcMap, ensureOpts, collectModifiedUnits, meterLocked); err != nil {
@@ -322,6 +322,75 @@ func (s *quotaHandlersSuite) TestDoQuotaControlUpdate(c *C) {
 	})
 }
 
+func (s *quotaHandlersSuite) TestDoQuotaControlUserApps(c *C) {
+	r := s.mockSystemctlCalls(c, join(
+		// CreateQuota for foo-group
+		systemctlCallsForCreateQuota("foo-group", "test-snap"),
+		// the updates only affect the slices of the users, which are
+		// reloaded through the session agents
+	))
+	defer r()
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// setup the snap so it exists
+	snapstate.Set(s.state, "test-snap", s.testSnapState)
+	snaptest.MockSnapCurrent(c, testYaml, s.testSnapSideInfo)
+
+	userApps := true
+	qcs := servicestate.QuotaControlAction{
+		Action:         "create",
+		QuotaName:      "foo-group",
+		ResourceLimits: quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build(),
+		AddSnaps:       []string{"test-snap"},
+		UserApps:       &userApps,
+	}
+
+	err := s.callDoQuotaControl(&qcs)
+	c.Assert(err, IsNil)
+
+	grp, err := servicestate.GetQuota(st, "foo-group")
+	c.Assert(err, IsNil)
+	c.Check(grp.UserApps, Equals, true)
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foo\\x2dgroup.slice"), testutil.FileContains, "MemoryMax=1073741824\n")
+	c.Check(quota.UserAppsSliceFile("test-snap"), testutil.FileEquals, "snap.foo\\x2dgroup.slice\n")
+
+	// updating the limits does not change whether user apps are included
+	qcs = servicestate.QuotaControlAction{
+		Action:         "update",
+		QuotaName:      "foo-group",
+		ResourceLimits: quota.NewResourcesBuilder().WithCPUPercentage(50).Build(),
+	}
+	r = s.mockSystemctlCalls(c, []expectedSystemctl{{expArgs: []string{"daemon-reload"}}})
+	defer r()
+	err = s.callDoQuotaControl(&qcs)
+	c.Assert(err, IsNil)
+
+	grp, err = servicestate.GetQuota(st, "foo-group")
+	c.Assert(err, IsNil)
+	c.Check(grp.UserApps, Equals, true)
+
+	// and then turn it off
+	userApps = false
+	qcs = servicestate.QuotaControlAction{
+		Action:    "update",
+		QuotaName: "foo-group",
+		UserApps:  &userApps,
+	}
+	r = s.mockSystemctlCalls(c, nil)
+	defer r()
+	err = s.callDoQuotaControl(&qcs)
+	c.Assert(err, IsNil)
+
+	grp, err = servicestate.GetQuota(st, "foo-group")
+	c.Assert(err, IsNil)
+	c.Check(grp.UserApps, Equals, false)
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foo\\x2dgroup.slice"), testutil.FileAbsent)
+	c.Check(quota.UserAppsSliceFile("test-snap"), testutil.FileAbsent)
+}
+
 func (s *quotaHandlersSuite) TestDoQuotaControlUpdateRestartOK(c *C) {
 	// test a situation where because of restart the task is reentered
 	r := s.mockSystemctlCalls(c, join(
@@ -2107,6 +2176,76 @@ func (s *quotaHandlersSuite) TestQuotaUpdateChangeMemLimit(c *C) {
 	c.Assert(err, ErrorMatches, "cannot update limits for group \"foo\": cannot decrease memory limit, remove and re-create it to decrease the limit")
 }
 
+func (s *quotaHandlersSuite) TestQuotaUpdateChangeMemHighAndSwapLimits(c *C) {
+	r := s.mockSystemctlCalls(c, join(
+		// CreateQuota for foo
+		systemctlCallsForCreateQuota("foo", "test-snap"),
+
+		// UpdateQuota for foo - an existing slice was changed, so all we need
+		// to is daemon-reload
+		[]expectedSystemctl{{expArgs: []string{"daemon-reload"}}},
+		[]expectedSystemctl{{expArgs: []string{"daemon-reload"}}},
+	))
+	defer r()
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// setup the snap so it exists
+	snapstate.Set(s.state, "test-snap", s.testSnapState)
+	snaptest.MockSnapCurrent(c, testYaml, s.testSnapSideInfo)
+
+	// create a quota group
+	qc := servicestate.QuotaControlAction{
+		Action:         "create",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeGiB / 2).Build(),
+		AddSnaps:       []string{"test-snap"},
+	}
+
+	err := s.callDoQuotaControl(&qc)
+	c.Assert(err, IsNil)
+
+	// the high limit can be decreased, and the swap limit set, without
+	// giving the memory limit again
+	qc2 := servicestate.QuotaControlAction{
+		Action:         "update",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithMemoryHigh(quantity.SizeGiB / 4).WithMemorySwapMax(quantity.SizeMiB).Build(),
+	}
+	err = s.callDoQuotaControl(&qc2)
+	c.Assert(err, IsNil)
+
+	checkQuotaState(c, st, map[string]quotaGroupState{
+		"foo": {
+			ResourceLimits: quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeGiB / 4).WithMemorySwapMax(quantity.SizeMiB).Build(),
+			Snaps:          []string{"test-snap"},
+		},
+	})
+	sliceFile := filepath.Join(dirs.SnapServicesDir, "snap.foo.slice")
+	c.Check(sliceFile, testutil.FileContains, "MemoryHigh=268435456\nMemorySwapMax=1048576\n")
+
+	// a zero swap limit disables swap
+	qcNoSwap := servicestate.QuotaControlAction{
+		Action:         "update",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithMemorySwapMax(0).Build(),
+	}
+	err = s.callDoQuotaControl(&qcNoSwap)
+	c.Assert(err, IsNil)
+	c.Check(sliceFile, testutil.FileContains, "MemoryHigh=268435456\nMemorySwapMax=0\n")
+
+	// but the high limit cannot go above the memory limit
+	qc3 := servicestate.QuotaControlAction{
+		Action:         "update",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithMemoryHigh(quantity.SizeGiB * 2).Build(),
+	}
+	err = s.callDoQuotaControl(&qc3)
+	c.Assert(err, ErrorMatches, `cannot update limits for group "foo": memory high limit 2 GiB is larger than the memory limit 1 GiB`)
+}
+
 func (s *quotaHandlersSuite) TestQuotaUpdateJournalQuotaNotAllowedForServices(c *C) {
 	r := s.mockSystemctlCalls(c, join(
 		// CreateQuota for foo
@@ -2298,6 +2437,72 @@ func (s *quotaHandlersSuite) TestAddJournalQuota(c *C) {
 	})
 }
 
+func (s *quotaHandlersSuite) TestUpdateNetworkQuota(c *C) {
+	r := s.mockSystemctlCalls(c, join(
+		// CreateQuota for foo
+		systemctlCallsForCreateQuota("foo", "test-snap"),
+
+		// UpdateQuota for foo only changes the network rules, which are
+		// loaded when the services start
+		systemctlCallsForServiceRestart("test-snap"),
+	))
+	defer r()
+
+	st := s.state
+	st.Lock()
+	defer st.Unlock()
+
+	// setup the snap so it exists
+	snapstate.Set(s.state, "test-snap", s.testSnapState)
+	snaptest.MockSnapCurrent(c, testYaml, s.testSnapSideInfo)
+
+	qc := servicestate.QuotaControlAction{
+		Action:         "create",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+		AddSnaps:       []string{"test-snap"},
+	}
+	qcs := []*servicestate.QuotaControlAction{&qc}
+
+	chg := st.NewChange("quota-control-tasks", "...")
+	t := st.NewTask("quota-control", "...")
+	t.Set("quota-control-actions", &qcs)
+	chg.AddTask(t)
+
+	st.Unlock()
+	defer s.se.Stop()
+	err := s.o.Settle(5 * time.Second)
+	st.Lock()
+	c.Check(err, IsNil)
+
+	rulesFile := filepath.Join(dirs.SnapNetworkQuotaDir, "snap.foo.nft")
+	c.Check(rulesFile, testutil.FileContains, "limit rate over 1048576 bytes/second")
+
+	qc = servicestate.QuotaControlAction{
+		Action:         "update",
+		QuotaName:      "foo",
+		ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(2 * quantity.SizeMiB).Build(),
+	}
+	qcs = []*servicestate.QuotaControlAction{&qc}
+
+	chg = st.NewChange("quota-control-tasks", "...")
+	t = st.NewTask("quota-control", "...")
+	t.Set("quota-control-actions", &qcs)
+	chg.AddTask(t)
+
+	st.Unlock()
+	err = s.o.Settle(5 * time.Second)
+	st.Lock()
+	c.Check(err, IsNil)
+	c.Check(rulesFile, testutil.FileContains, "limit rate over 2097152 bytes/second")
+	checkQuotaState(c, st, map[string]quotaGroupState{
+		"foo": {
+			ResourceLimits: quota.NewResourcesBuilder().WithNetworkEgressBandwidth(2 * quantity.SizeMiB).Build(),
+			Snaps:          []string{"test-snap"},
+		},
+	})
+}
+
 func (s *quotaHandlersSuite) TestUpdateJournalQuota(c *C) {
 	r := s.mockSystemctlCalls(c, join(
 		[]expectedSystemctl{{expArgs: []string{"daemon-reload"}}},
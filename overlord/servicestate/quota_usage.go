@@ -0,0 +1,318 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap/quota"
+)
+
+var (
+	timeNow = time.Now
+
+	// quotaUsageSampleInterval is how often the usage of the quota groups
+	// is sampled.
+	quotaUsageSampleInterval = time.Minute
+	// quotaUsageHistorySize is how many samples are kept per quota group,
+	// a day worth of them with the default interval.
+	quotaUsageHistorySize = 24 * 60
+)
+
+// QuotaUsageWindows are the windows of time over which the statistics of the
+// usage of quota groups are reported.
+var QuotaUsageWindows = []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour}
+
+// defaultQuotaUsageThreshold is the percentage of a limit above which a
+// quota-threshold notice is recorded, unless
+// quota-groups.usage-threshold is set.
+const defaultQuotaUsageThreshold = 90
+
+// quotaUsageSample holds the usage of the limited resources of a quota group
+// at some point in time, by resource.
+type quotaUsageSample struct {
+	time  time.Time
+	usage map[string]uint64
+}
+
+// quotaUsageHistory is a ring buffer of the most recent usage samples of a
+// quota group.
+type quotaUsageHistory struct {
+	samples []quotaUsageSample
+	next    int
+	// exceeded holds the resources whose usage was above the threshold
+	// in the last sample, so that notices are only recorded when they go
+	// above it
+	exceeded map[string]bool
+}
+
+func newQuotaUsageHistory(size int) *quotaUsageHistory {
+	return &quotaUsageHistory{
+		samples:  make([]quotaUsageSample, 0, size),
+		exceeded: make(map[string]bool),
+	}
+}
+
+func (h *quotaUsageHistory) add(sample quotaUsageSample) {
+	if len(h.samples) < cap(h.samples) {
+		h.samples = append(h.samples, sample)
+		return
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+}
+
+// stats returns the statistics of the usage of the resource over the samples
+// taken after the given time.
+func (h *quotaUsageHistory) stats(resource string, since time.Time) (st QuotaUsageStats) {
+	var sum uint64
+	for _, sample := range h.samples {
+		if !sample.time.After(since) {
+			continue
+		}
+		usage, ok := sample.usage[resource]
+		if !ok {
+			continue
+		}
+		if st.Samples == 0 || usage < st.Min {
+			st.Min = usage
+		}
+		if usage > st.Max {
+			st.Max = usage
+		}
+		sum += usage
+		st.Samples++
+	}
+	if st.Samples > 0 {
+		st.Avg = sum / uint64(st.Samples)
+	}
+	return st
+}
+
+// QuotaUsageStats are the statistics of the usage of a resource of a quota
+// group over a window of time.
+type QuotaUsageStats struct {
+	Window  time.Duration
+	Samples int
+	Min     uint64
+	Avg     uint64
+	Max     uint64
+}
+
+// quotaGroupUsage returns the current usage of the resources of the quota
+// group which have a limit, with their limits, by resource. Only the memory
+// and threads are sampled, the CPU, IO and network limits being on rates of
+// usage, which cannot be sampled from the current usage.
+var quotaGroupUsage = func(grp *quota.Group) (usage, limits map[string]uint64, err error) {
+	usage = make(map[string]uint64)
+	limits = make(map[string]uint64)
+	if grp.MemoryLimit != 0 {
+		mem, err := grp.CurrentMemoryUsage()
+		if err != nil {
+			return nil, nil, err
+		}
+		usage["memory"] = uint64(mem)
+		limits["memory"] = uint64(grp.MemoryLimit)
+	}
+	if grp.ThreadLimit != 0 {
+		threads, err := grp.CurrentTaskUsage()
+		if err != nil {
+			return nil, nil, err
+		}
+		usage["threads"] = uint64(threads)
+		limits["threads"] = uint64(grp.ThreadLimit)
+	}
+	return usage, limits, nil
+}
+
+// quotaUsageThreshold returns the percentage of a limit above which a
+// quota-threshold notice is recorded.
+// The state needs to be locked by the caller.
+func quotaUsageThreshold(st *state.State) (int, error) {
+	// numbers are stored as json.Number, but "snap set" can leave a
+	// string too
+	var val interface{}
+	if err := config.NewTransaction(st).Get("core", "quota-groups.usage-threshold", &val); err != nil {
+		if config.IsNoOption(err) {
+			return defaultQuotaUsageThreshold, nil
+		}
+		return 0, err
+	}
+	var str string
+	switch v := val.(type) {
+	case json.Number:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return 0, fmt.Errorf("quota-groups.usage-threshold has unexpected type %T", val)
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 || n > 100 {
+		return 0, fmt.Errorf("quota-groups.usage-threshold must be a percentage between 1 and 100, not %q", str)
+	}
+	return n, nil
+}
+
+// ensureQuotaUsageSampled samples the usage of the quota groups once every
+// quotaUsageSampleInterval, and records a quota-threshold notice for each
+// resource of a group whose usage went above the threshold percentage of its
+// limit. The next sample is scheduled as long as there are groups with
+// sampled resources.
+func (m *ServiceManager) ensureQuotaUsageSampled() error {
+	now := timeNow()
+	if elapsed := now.Sub(m.lastUsageSample); elapsed < quotaUsageSampleInterval {
+		m.usageMu.Lock()
+		sampling := len(m.usageHistory) != 0
+		m.usageMu.Unlock()
+		if sampling {
+			m.state.EnsureBefore(quotaUsageSampleInterval - elapsed)
+		}
+		return nil
+	}
+	m.lastUsageSample = now
+
+	m.state.Lock()
+	allGrps, err := AllQuotas(m.state)
+	if err != nil && !errors.Is(err, state.ErrNoState) {
+		m.state.Unlock()
+		return err
+	}
+	threshold, err := quotaUsageThreshold(m.state)
+	m.state.Unlock()
+	if err != nil {
+		return err
+	}
+
+	type exceededLimit struct {
+		group, resource string
+		usage, limit    uint64
+	}
+	var exceeded []exceededLimit
+
+	m.usageMu.Lock()
+	for name := range m.usageHistory {
+		if _, ok := allGrps[name]; !ok {
+			delete(m.usageHistory, name)
+		}
+	}
+	names := make([]string, 0, len(allGrps))
+	for name := range allGrps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		usage, limits, err := quotaGroupUsage(allGrps[name])
+		if err != nil {
+			logger.Debugf("cannot sample usage of quota group %q: %v", name, err)
+			continue
+		}
+		if len(usage) == 0 {
+			continue
+		}
+		history := m.usageHistory[name]
+		if history == nil {
+			history = newQuotaUsageHistory(quotaUsageHistorySize)
+			m.usageHistory[name] = history
+		}
+		history.add(quotaUsageSample{time: now, usage: usage})
+
+		for resource, limit := range limits {
+			above := usage[resource]*100 > limit*uint64(threshold)
+			if above && !history.exceeded[resource] {
+				exceeded = append(exceeded, exceededLimit{name, resource, usage[resource], limit})
+			}
+			history.exceeded[resource] = above
+		}
+	}
+	sampling := len(m.usageHistory) != 0
+	m.usageMu.Unlock()
+
+	if sampling {
+		m.state.EnsureBefore(quotaUsageSampleInterval)
+	}
+
+	if len(exceeded) == 0 {
+		return nil
+	}
+
+	sort.Slice(exceeded, func(i, j int) bool {
+		if exceeded[i].group != exceeded[j].group {
+			return exceeded[i].group < exceeded[j].group
+		}
+		return exceeded[i].resource < exceeded[j].resource
+	})
+
+	m.state.Lock()
+	defer m.state.Unlock()
+	for _, e := range exceeded {
+		_, err := m.state.AddNotice(nil, state.QuotaThresholdNotice, e.group, &state.AddNoticeOptions{
+			Data: map[string]string{
+				"resource":  e.resource,
+				"usage":     strconv.FormatUint(e.usage, 10),
+				"limit":     strconv.FormatUint(e.limit, 10),
+				"threshold": strconv.Itoa(threshold),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QuotaUsageHistory returns the statistics of the sampled usage of the
+// resources of the quota group over each of QuotaUsageWindows, by resource.
+// Only the memory and threads of the group are sampled, if they have a limit,
+// and nothing is returned for a group that wasn't sampled yet.
+func (m *ServiceManager) QuotaUsageHistory(name string) map[string][]QuotaUsageStats {
+	m.usageMu.Lock()
+	defer m.usageMu.Unlock()
+
+	history := m.usageHistory[name]
+	if history == nil || len(history.samples) == 0 {
+		return nil
+	}
+
+	now := timeNow()
+	resources := make(map[string]bool)
+	for _, sample := range history.samples {
+		for resource := range sample.usage {
+			resources[resource] = true
+		}
+	}
+	stats := make(map[string][]QuotaUsageStats, len(resources))
+	for resource := range resources {
+		for _, window := range QuotaUsageWindows {
+			st := history.stats(resource, now.Add(-window))
+			st.Window = window
+			stats[resource] = append(stats[resource], st)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,280 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package servicestate_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/gadget/quantity"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/servicestate"
+	"github.com/snapcore/snapd/overlord/servicestate/servicestatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap/quota"
+)
+
+type quotaUsageSuite struct {
+	baseServiceMgrTestSuite
+
+	now   time.Time
+	usage map[string]map[string]uint64
+}
+
+var _ = Suite(&quotaUsageSuite{})
+
+func (s *quotaUsageSuite) SetUpTest(c *C) {
+	s.baseServiceMgrTestSuite.SetUpTest(c)
+
+	// well after the manager was created
+	s.now = time.Now().Add(time.Hour)
+	s.AddCleanup(servicestate.MockTimeNow(func() time.Time { return s.now }))
+
+	s.usage = make(map[string]map[string]uint64)
+	s.AddCleanup(servicestate.MockQuotaGroupUsage(func(grp *quota.Group) (usage, limits map[string]uint64, err error) {
+		usage = make(map[string]uint64)
+		limits = make(map[string]uint64)
+		if grp.MemoryLimit != 0 {
+			usage["memory"] = s.usage[grp.Name]["memory"]
+			limits["memory"] = uint64(grp.MemoryLimit)
+		}
+		if grp.ThreadLimit != 0 {
+			usage["threads"] = s.usage[grp.Name]["threads"]
+			limits["threads"] = uint64(grp.ThreadLimit)
+		}
+		return usage, limits, nil
+	}))
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	err := servicestatetest.MockQuotaInState(s.state, "foo", "", []string{"test-snap"}, nil, quota.NewResourcesBuilder().WithMemoryLimit(1000*quantity.SizeKiB).Build())
+	c.Assert(err, IsNil)
+	err = servicestatetest.MockQuotaInState(s.state, "bar", "", []string{"test-snap2"}, nil, quota.NewResourcesBuilder().WithThreadLimit(100).Build())
+	c.Assert(err, IsNil)
+}
+
+func (s *quotaUsageSuite) sample(c *C, fooMemory, barThreads uint64) {
+	s.usage["foo"] = map[string]uint64{"memory": fooMemory}
+	s.usage["bar"] = map[string]uint64{"threads": barThreads}
+	c.Assert(s.mgr.EnsureQuotaUsageSampled(), IsNil)
+	s.now = s.now.Add(time.Minute)
+}
+
+func (s *quotaUsageSuite) thresholdNotices(c *C) []map[string]interface{} {
+	s.state.Lock()
+	defer s.state.Unlock()
+	var notices []map[string]interface{}
+	for _, n := range s.state.Notices(&state.NoticeFilter{Types: []state.NoticeType{state.QuotaThresholdNotice}}) {
+		buf, err := json.Marshal(n)
+		c.Assert(err, IsNil)
+		var m map[string]interface{}
+		c.Assert(json.Unmarshal(buf, &m), IsNil)
+		notices = append(notices, m)
+	}
+	return notices
+}
+
+func (s *quotaUsageSuite) TestUsageHistory(c *C) {
+	c.Check(s.mgr.QuotaUsageHistory("foo"), IsNil)
+
+	// an hour of samples, then five more minutes of them
+	for i := 0; i < 60; i++ {
+		s.sample(c, 1000, 10)
+	}
+	for i := 0; i < 5; i++ {
+		s.sample(c, uint64(2000+i*1000), uint64(20+i))
+	}
+	s.now = s.now.Add(-time.Minute + time.Second)
+
+	c.Check(s.mgr.QuotaUsageHistory("foo"), DeepEquals, map[string][]servicestate.QuotaUsageStats{
+		"memory": {
+			{Window: 5 * time.Minute, Samples: 5, Min: 2000, Avg: 4000, Max: 6000},
+			{Window: time.Hour, Samples: 60, Min: 1000, Avg: 1250, Max: 6000},
+			{Window: 24 * time.Hour, Samples: 65, Min: 1000, Avg: 1230, Max: 6000},
+		},
+	})
+	c.Check(s.mgr.QuotaUsageHistory("bar"), DeepEquals, map[string][]servicestate.QuotaUsageStats{
+		"threads": {
+			{Window: 5 * time.Minute, Samples: 5, Min: 20, Avg: 22, Max: 24},
+			{Window: time.Hour, Samples: 60, Min: 10, Avg: 11, Max: 24},
+			{Window: 24 * time.Hour, Samples: 65, Min: 10, Avg: 10, Max: 24},
+		},
+	})
+	c.Check(s.mgr.QuotaUsageHistory("unknown"), IsNil)
+}
+
+func (s *quotaUsageSuite) TestUsageHistoryBounded(c *C) {
+	restore := servicestate.MockQuotaUsageHistorySize(3)
+	defer restore()
+
+	for i := 1; i <= 5; i++ {
+		s.sample(c, uint64(i*100), 1)
+	}
+
+	stats := s.mgr.QuotaUsageHistory("foo")
+	c.Check(stats["memory"][0], DeepEquals, servicestate.QuotaUsageStats{
+		Window: 5 * time.Minute, Samples: 3, Min: 300, Avg: 400, Max: 500,
+	})
+}
+
+func (s *quotaUsageSuite) TestUsageSampledOncePerInterval(c *C) {
+	calls := 0
+	restore := servicestate.MockQuotaGroupUsage(func(grp *quota.Group) (usage, limits map[string]uint64, err error) {
+		calls++
+		return map[string]uint64{"memory": 1}, map[string]uint64{"memory": 10}, nil
+	})
+	defer restore()
+
+	c.Assert(s.mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(calls, Equals, 2)
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(s.mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(calls, Equals, 2)
+	s.now = s.now.Add(30 * time.Second)
+	c.Assert(s.mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(calls, Equals, 4)
+}
+
+func (s *quotaUsageSuite) TestUsageSampleErrorSkipsGroup(c *C) {
+	restore := servicestate.MockQuotaGroupUsage(func(grp *quota.Group) (usage, limits map[string]uint64, err error) {
+		if grp.Name == "foo" {
+			return nil, nil, fmt.Errorf("boom")
+		}
+		return map[string]uint64{"threads": 1}, map[string]uint64{"threads": 10}, nil
+	})
+	defer restore()
+
+	c.Assert(s.mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(s.mgr.QuotaUsageHistory("foo"), IsNil)
+	c.Check(s.mgr.QuotaUsageHistory("bar"), HasLen, 1)
+}
+
+func (s *quotaUsageSuite) TestUsageHistoryOfRemovedGroupsDropped(c *C) {
+	s.sample(c, 1000, 10)
+	c.Check(s.mgr.QuotaUsageHistory("bar"), HasLen, 1)
+
+	s.state.Lock()
+	s.state.Set("quotas", map[string]*quota.Group{
+		"foo": {Name: "foo", MemoryLimit: 1000 * quantity.SizeKiB, Snaps: []string{"test-snap"}},
+	})
+	s.state.Unlock()
+
+	s.sample(c, 1000, 10)
+	c.Check(s.mgr.QuotaUsageHistory("bar"), IsNil)
+	c.Check(s.mgr.QuotaUsageHistory("foo"), HasLen, 1)
+}
+
+func (s *quotaUsageSuite) TestThresholdNotices(c *C) {
+	// 1000KiB of memory and 100 threads, with a default threshold of 90%
+	s.sample(c, 900*1024, 90)
+	c.Check(s.thresholdNotices(c), HasLen, 0)
+
+	s.sample(c, 901*1024, 50)
+	notices := s.thresholdNotices(c)
+	c.Assert(notices, HasLen, 1)
+	c.Check(notices[0]["key"], Equals, "foo")
+	c.Check(notices[0]["occurrences"], Equals, 1.0)
+	c.Check(notices[0]["last-data"], DeepEquals, map[string]interface{}{
+		"resource":  "memory",
+		"usage":     "922624",
+		"limit":     "1024000",
+		"threshold": "90",
+	})
+
+	// staying above the threshold is not reported again
+	s.sample(c, 950*1024, 50)
+	notices = s.thresholdNotices(c)
+	c.Assert(notices, HasLen, 1)
+	c.Check(notices[0]["occurrences"], Equals, 1.0)
+
+	// but going above it again is
+	s.sample(c, 100*1024, 50)
+	s.sample(c, 1000*1024, 50)
+	notices = s.thresholdNotices(c)
+	c.Assert(notices, HasLen, 1)
+	c.Check(notices[0]["occurrences"], Equals, 2.0)
+}
+
+func (s *quotaUsageSuite) TestThresholdNoticesConfigured(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "quota-groups.usage-threshold", "50")
+	tr.Commit()
+	s.state.Unlock()
+
+	s.sample(c, 100*1024, 51)
+	notices := s.thresholdNotices(c)
+	c.Assert(notices, HasLen, 1)
+	c.Check(notices[0]["key"], Equals, "bar")
+	c.Check(notices[0]["last-data"], DeepEquals, map[string]interface{}{
+		"resource":  "threads",
+		"usage":     "51",
+		"limit":     "100",
+		"threshold": "50",
+	})
+}
+
+func (s *quotaUsageSuite) TestThresholdInvalid(c *C) {
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "quota-groups.usage-threshold", "150")
+	tr.Commit()
+	s.state.Unlock()
+
+	err := s.mgr.EnsureQuotaUsageSampled()
+	c.Check(err, ErrorMatches, `quota-groups.usage-threshold must be a percentage between 1 and 100, not "150"`)
+}
+
+type ensureBeforeBackend struct {
+	ensureBefore []time.Duration
+}
+
+func (b *ensureBeforeBackend) Checkpoint(data []byte) error { return nil }
+
+func (b *ensureBeforeBackend) EnsureBefore(d time.Duration) {
+	b.ensureBefore = append(b.ensureBefore, d)
+}
+
+func (s *quotaUsageSuite) TestNextSampleScheduled(c *C) {
+	b := &ensureBeforeBackend{}
+	st := state.New(b)
+	mgr := servicestate.Manager(st, state.NewTaskRunner(st))
+
+	// nothing to sample
+	c.Assert(mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(b.ensureBefore, HasLen, 0)
+
+	st.Lock()
+	err := servicestatetest.MockQuotaInState(st, "foo", "", []string{"test-snap"}, nil, quota.NewResourcesBuilder().WithMemoryLimit(1000*quantity.SizeKiB).Build())
+	st.Unlock()
+	c.Assert(err, IsNil)
+
+	s.now = s.now.Add(time.Minute)
+	c.Assert(mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(b.ensureBefore, DeepEquals, []time.Duration{time.Minute})
+
+	// an earlier ensure pass keeps the next sample scheduled
+	s.now = s.now.Add(20 * time.Second)
+	c.Assert(mgr.EnsureQuotaUsageSampled(), IsNil)
+	c.Check(b.ensureBefore, DeepEquals, []time.Duration{time.Minute, 40 * time.Second})
+}
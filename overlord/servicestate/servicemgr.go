@@ -26,6 +26,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/snapcore/snapd/dirs"
@@ -48,13 +49,20 @@ type ServiceManager struct {
 	state *state.State
 
 	ensuredSnapSvcs bool
+
+	usageMu         sync.Mutex
+	usageHistory    map[string]*quotaUsageHistory
+	lastUsageSample time.Time
 }
 
 // Manager returns a new service manager.
 func Manager(st *state.State, runner *state.TaskRunner) *ServiceManager {
 	delayedCrossMgrInit()
 	m := &ServiceManager{
-		state: st,
+		state:        st,
+		usageHistory: make(map[string]*quotaUsageHistory),
+		// the first sample is taken one interval after startup
+		lastUsageSample: timeNow(),
 	}
 	// TODO: undo handler
 	runner.AddHandler("service-control", m.doServiceControl, nil)
@@ -204,6 +212,9 @@ func (m *ServiceManager) Ensure() error {
 	if err := m.ensureSnapServicesUpdated(); err != nil {
 		return err
 	}
+	if err := m.ensureQuotaUsageSampled(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -301,12 +301,51 @@ func EstimateSnapshotSize(si *snap.Info, usernames []string, dirOpts *dirs.SnapD
 	return total, nil
 }
 
+// SaveOptions holds the options to save a snapshot with.
+type SaveOptions struct {
+	// Incremental keeps the data of the archives in chunks shared with
+	// the other incremental snapshots, so that only the data that
+	// changed since previous snapshots takes up more space.
+	Incremental bool
+	// Key, if set, is used to encrypt the archives.
+	Key *Key
+}
+
 // Save a snapshot
 func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, dynSnapshotOpts *snap.SnapshotOptions, dirOpts *dirs.SnapDirOptions) (*client.Snapshot, error) {
+	return SaveWithOptions(ctx, id, si, cfg, usernames, dynSnapshotOpts, dirOpts, nil)
+}
+
+// SaveIncremental saves a snapshot like Save, but keeps the data of its
+// archives in chunks shared with the other incremental snapshots.
+func SaveIncremental(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, dynSnapshotOpts *snap.SnapshotOptions, dirOpts *dirs.SnapDirOptions) (*client.Snapshot, error) {
+	return SaveWithOptions(ctx, id, si, cfg, usernames, dynSnapshotOpts, dirOpts, &SaveOptions{Incremental: true})
+}
+
+// SaveWithOptions saves a snapshot like Save, with the given options.
+func SaveWithOptions(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, dynSnapshotOpts *snap.SnapshotOptions, dirOpts *dirs.SnapDirOptions, opts *SaveOptions) (*client.Snapshot, error) {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	if opts.Incremental && opts.Key != nil {
+		// encrypted data doesn't deduplicate
+		return nil, fmt.Errorf("cannot save an encrypted snapshot incrementally")
+	}
+
 	if err := os.MkdirAll(dirs.SnapshotsDir, 0700); err != nil {
 		return nil, err
 	}
 
+	if opts.Incremental {
+		// keep the chunks from being collected until the snapshot
+		// referencing them is complete
+		lock, err := lockChunks(false)
+		if err != nil {
+			return nil, fmt.Errorf("cannot lock snapshot chunks: %v", err)
+		}
+		defer lock.Close()
+	}
+
 	snapshot := &client.Snapshot{
 		SetID:    id,
 		Snap:     si.InstanceName(),
@@ -323,6 +362,9 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 		Conf:     cfg,
 		// Note: Auto is no longer set in the Snapshot.
 	}
+	if opts.Key != nil {
+		snapshot.Encryption = opts.Key.encryption()
+	}
 
 	snapshotOptions, err := snapReadSnapshotYaml(si)
 	if err != nil {
@@ -346,7 +388,7 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 	defer w.Close() // note this does not close the file descriptor (that's done by hand on the atomic writer, above)
 	savingUserData := false
 	baseDataDir := snap.BaseDataDir(si.InstanceName())
-	if err := addSnapDirToZip(ctx, snapshot, w, "root", archiveName, baseDataDir, savingUserData, snapshotOptions.Exclude); err != nil {
+	if err := addSnapDirToZip(ctx, snapshot, w, "root", archiveName, baseDataDir, savingUserData, snapshotOptions.Exclude, opts); err != nil {
 		return nil, err
 	}
 
@@ -358,7 +400,7 @@ func Save(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interfac
 	savingUserData = true
 	for _, usr := range users {
 		snapDataDir := filepath.Dir(si.UserDataDir(usr.HomeDir, dirOpts))
-		if err := addSnapDirToZip(ctx, snapshot, w, usr.Username, userArchiveName(usr), snapDataDir, savingUserData, snapshotOptions.Exclude); err != nil {
+		if err := addSnapDirToZip(ctx, snapshot, w, usr.Username, userArchiveName(usr), snapDataDir, savingUserData, snapshotOptions.Exclude, opts); err != nil {
 			return nil, err
 		}
 	}
@@ -399,7 +441,7 @@ var isTesting = snapdenv.Testing()
 // addSnapDirToZip adds the 'common' and the 'rev' revisioned dir under 'snapDir'
 // to the snapshot. If one doesn't exist, it's ignored. If none exists, the
 // operation is skipped.
-func addSnapDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username, entry, snapDir string, savingUserData bool, excludePaths []string) error {
+func addSnapDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username, entry, snapDir string, savingUserData bool, excludePaths []string, opts *SaveOptions) error {
 	paths, err := pathsForSnapshot(snapDir, snapshot)
 	if err != nil {
 		return err
@@ -442,24 +484,43 @@ func addSnapDirToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writ
 		expExcludePaths = append(expExcludePaths, expandedPath)
 	}
 
-	return addToZip(ctx, snapshot, w, username, entry, paths, expExcludePaths)
+	return addToZip(ctx, snapshot, w, username, entry, paths, expExcludePaths, opts)
 }
 
 // addToZip adds 'paths' to the snapshot. tar will change into the paths' parent
 // directory before creating the archive so that parent dirs are not added.
-func addToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username, entry string, paths []string, excludePaths []string) error {
-	archiveWriter, err := w.CreateHeader(&zip.FileHeader{Name: entry})
-	if err != nil {
-		return err
+// If incremental, the archive is added to the chunk store and only the list
+// of its chunks is added to the snapshot.
+func addToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, username, entry string, paths []string, excludePaths []string, opts *SaveOptions) error {
+	if opts == nil {
+		opts = &SaveOptions{}
+	}
+	incremental := opts.Incremental
+
+	var archiveWriter io.Writer
+	var chunks *chunkWriter
+	if incremental {
+		// the chunks are compressed separately
+		chunks = &chunkWriter{}
+		archiveWriter = chunks
+	} else {
+		var err error
+		archiveWriter, err = w.CreateHeader(&zip.FileHeader{Name: entry})
+		if err != nil {
+			return err
+		}
 	}
 
 	tarArgs := []string{
 		"--create",
-		"--sparse", "--gzip",
+		"--sparse",
 		"--format", "gnu",
 		"--anchored",
 		"--no-wildcards-match-slash",
 	}
+	if !incremental {
+		tarArgs = append(tarArgs, "--gzip")
+	}
 
 	for _, path := range excludePaths {
 		tarArgs = append(tarArgs, fmt.Sprintf("--exclude=%s", path))
@@ -475,7 +536,21 @@ func addToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, use
 	hasher := crypto.SHA3_384.New()
 
 	cmd := tarAsUser(username, tarArgs...)
-	cmd.Stdout = io.MultiWriter(archiveWriter, hasher, &sz)
+	var enc *encryptingWriter
+	switch {
+	case incremental:
+		chunks.out = io.MultiWriter(hasher, &sz)
+		cmd.Stdout = chunks
+	case opts.Key != nil:
+		var err error
+		enc, err = newEncryptingWriter(io.MultiWriter(archiveWriter, hasher, &sz), opts.Key)
+		if err != nil {
+			return err
+		}
+		cmd.Stdout = enc
+	default:
+		cmd.Stdout = io.MultiWriter(archiveWriter, hasher, &sz)
+	}
 
 	// keep (at most) the last 5 non-empty lines of what 'tar' writes to stderr
 	// (those are the most likely contain the reason for fatal errors)
@@ -504,6 +579,20 @@ func addToZip(ctx context.Context, snapshot *client.Snapshot, w *zip.Writer, use
 		return fmt.Errorf("tar failed: %v", err)
 	}
 
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	if incremental {
+		if err := chunks.flush(); err != nil {
+			return err
+		}
+		if err := addChunkIndexToZip(w, entry, chunks.refs); err != nil {
+			return err
+		}
+	}
+
 	snapshot.SHA3_384[entry] = fmt.Sprintf("%x", hasher.Sum(nil))
 	snapshot.Size += sz.Size()
 
@@ -933,6 +1022,9 @@ type SnapshotExport struct {
 
 	// cached size, needs to be calculated with CalculateSize
 	size int64
+
+	// date of the export, the current time if unset
+	date time.Time
 }
 
 // NewSnapshotExport will return a SnapshotExport structure. It must be
@@ -958,6 +1050,22 @@ func NewSnapshotExport(ctx context.Context, setID uint64) (se *SnapshotExport, e
 		if reader.SetID == setID {
 			snapshotSet.Snapshots = append(snapshotSet.Snapshots, &reader.Snapshot)
 
+			incremental, err := reader.IsIncremental()
+			if err != nil {
+				return err
+			}
+			if incremental {
+				// the export must not depend on the chunk
+				// store, so export a copy of the snapshot
+				// with its chunks put back together
+				f, err := reader.selfContained(ctx)
+				if err != nil {
+					return fmt.Errorf("cannot assemble snapshot %q: %v", reader.Name(), err)
+				}
+				snapshotFiles = append(snapshotFiles, f)
+				return nil
+			}
+
 			// Duplicate the file descriptor of the reader
 			// we were handed as Iter() closes those as
 			// soon as this unnamed returns. We re-package
@@ -1018,6 +1126,20 @@ func (se *SnapshotExport) Size() int64 {
 	return se.size
 }
 
+// SetDate makes the export use the given date for all its timestamps,
+// instead of the current time, so that it's streamed identically every
+// time.
+func (se *SnapshotExport) SetDate(date time.Time) {
+	se.date = date.UTC()
+}
+
+func (se *SnapshotExport) now() time.Time {
+	if !se.date.IsZero() {
+		return se.date
+	}
+	return timeNow()
+}
+
 func (se *SnapshotExport) Close() {
 	for _, f := range se.snapshotFiles {
 		f.Close()
@@ -1045,7 +1167,7 @@ func (se *SnapshotExport) StreamTo(w io.Writer) error {
 		Name:     "content.json",
 		Size:     int64(len(h)),
 		Mode:     0640,
-		ModTime:  timeNow(),
+		ModTime:  se.now(),
 	}
 	if err := tw.WriteHeader(hdr); err != nil {
 		return err
@@ -1071,6 +1193,9 @@ func (se *SnapshotExport) StreamTo(w io.Writer) error {
 		if err != nil {
 			return fmt.Errorf("symlink: %v", stat.Name())
 		}
+		if !se.date.IsZero() {
+			hdr.ModTime = se.date
+		}
 		if err = tw.WriteHeader(hdr); err != nil {
 			return fmt.Errorf("cannot write header for %v: %v", stat.Name(), err)
 		}
@@ -1095,7 +1220,7 @@ func (se *SnapshotExport) StreamTo(w io.Writer) error {
 	// validate the archive is complete
 	meta := exportMetadata{
 		Format: 1,
-		Date:   timeNow(),
+		Date:   se.now(),
 		Files:  files,
 	}
 	metaDataBuf, err := json.Marshal(&meta)
@@ -1107,7 +1232,7 @@ func (se *SnapshotExport) StreamTo(w io.Writer) error {
 		Name:     "export.json",
 		Size:     int64(len(metaDataBuf)),
 		Mode:     0640,
-		ModTime:  timeNow(),
+		ModTime:  se.now(),
 	}
 	if err := tw.WriteHeader(hdr); err != nil {
 		return err
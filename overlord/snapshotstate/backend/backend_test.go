@@ -568,8 +568,8 @@ func (s *snapshotSuite) TestAddDirToZipBails(c *check.C) {
 	defer restore()
 	savingUserData := false
 	// note as the zip is nil this would panic if it didn't bail
-	c.Check(backend.AddSnapDirToZip(nil, snapshot, nil, "", "an/entry", filepath.Join(s.root, "nonexistent"), savingUserData, nil), check.IsNil)
-	c.Check(backend.AddSnapDirToZip(nil, snapshot, nil, "", "an/entry", "/etc/passwd", savingUserData, nil), check.IsNil)
+	c.Check(backend.AddSnapDirToZip(nil, snapshot, nil, "", "an/entry", filepath.Join(s.root, "nonexistent"), savingUserData, nil, nil), check.IsNil)
+	c.Check(backend.AddSnapDirToZip(nil, snapshot, nil, "", "an/entry", "/etc/passwd", savingUserData, nil, nil), check.IsNil)
 	c.Check(buf.String(), check.Matches, "(?m).* is does not exist.*")
 }
 
@@ -585,7 +585,7 @@ func (s *snapshotSuite) TestAddDirToZipTarFails(c *check.C) {
 	var buf bytes.Buffer
 	z := zip.NewWriter(&buf)
 	savingUserData := false
-	c.Assert(backend.AddSnapDirToZip(ctx, &client.Snapshot{Revision: rev}, z, "", "an/entry", s.root, savingUserData, nil), check.ErrorMatches, ".* context canceled")
+	c.Assert(backend.AddSnapDirToZip(ctx, &client.Snapshot{Revision: rev}, z, "", "an/entry", s.root, savingUserData, nil, nil), check.ErrorMatches, ".* context canceled")
 }
 
 func (s *snapshotSuite) TestAddDirToZip(c *check.C) {
@@ -602,7 +602,7 @@ func (s *snapshotSuite) TestAddDirToZip(c *check.C) {
 		Revision: rev,
 	}
 	savingUserData := false
-	c.Assert(backend.AddSnapDirToZip(context.Background(), snapshot, z, "", "an/entry", s.root, savingUserData, nil), check.IsNil)
+	c.Assert(backend.AddSnapDirToZip(context.Background(), snapshot, z, "", "an/entry", s.root, savingUserData, nil, nil), check.IsNil)
 	z.Close() // write out the central directory
 
 	c.Check(snapshot.SHA3_384, check.HasLen, 1)
@@ -680,14 +680,19 @@ func (s *snapshotSuite) TestAddDirToZipExclusions(c *check.C) {
 	} {
 		testLabel := check.Commentf("%s/%v", testData.excludes, testData.savingUserData)
 
-		err := backend.AddSnapDirToZip(context.Background(), snapshot, z, "", "an/entry", s.root, testData.savingUserData, testData.excludes)
+		err := backend.AddSnapDirToZip(context.Background(), snapshot, z, "", "an/entry", s.root, testData.savingUserData, testData.excludes, nil)
 		c.Check(err, check.ErrorMatches, "tar failed.*")
 		c.Check(tarArgs, check.DeepEquals, testData.expectedArgs, testLabel)
 	}
 }
 
 func (s *snapshotSuite) TestHappyRoundtrip(c *check.C) {
-	s.testHappyRoundtrip(c, "marker")
+	s.testHappyRoundtrip(c, "marker", backend.Save)
+}
+
+func (s *snapshotSuite) TestHappyRoundtripIncremental(c *check.C) {
+	defer backend.MockChunkSizes(512, 2048, 8)()
+	s.testHappyRoundtrip(c, "marker", backend.SaveIncremental)
 }
 
 func (s *snapshotSuite) TestHappyRoundtripNoCommon(c *check.C) {
@@ -696,7 +701,7 @@ func (s *snapshotSuite) TestHappyRoundtripNoCommon(c *check.C) {
 			c.Assert(os.RemoveAll(t.dir), check.IsNil)
 		}
 	}
-	s.testHappyRoundtrip(c, "marker")
+	s.testHappyRoundtrip(c, "marker", backend.Save)
 }
 
 func (s *snapshotSuite) TestHappyRoundtripNoRev(c *check.C) {
@@ -705,10 +710,12 @@ func (s *snapshotSuite) TestHappyRoundtripNoRev(c *check.C) {
 			c.Assert(os.RemoveAll(t.dir), check.IsNil)
 		}
 	}
-	s.testHappyRoundtrip(c, "../common/marker")
+	s.testHappyRoundtrip(c, "../common/marker", backend.Save)
 }
 
-func (s *snapshotSuite) testHappyRoundtrip(c *check.C, marker string) {
+type saveFunc func(context.Context, uint64, *snap.Info, map[string]interface{}, []string, *snap.SnapshotOptions, *dirs.SnapDirOptions) (*client.Snapshot, error)
+
+func (s *snapshotSuite) testHappyRoundtrip(c *check.C, marker string, save saveFunc) {
 	if os.Geteuid() == 0 {
 		c.Skip("this test cannot run as root (runuser will fail)")
 	}
@@ -732,7 +739,7 @@ func (s *snapshotSuite) testHappyRoundtrip(c *check.C, marker string) {
 		return statSnapshotOpts, nil
 	})()
 
-	shw, err := backend.Save(context.TODO(), shID, info, cfg, []string{"snapuser"}, dynSnapshotOpts, nil)
+	shw, err := save(context.TODO(), shID, info, cfg, []string{"snapuser"}, dynSnapshotOpts, nil)
 	c.Assert(err, check.IsNil)
 	c.Check(shw.SetID, check.Equals, shID)
 	c.Check(shw.Snap, check.Equals, info.InstanceName())
@@ -756,6 +763,10 @@ func (s *snapshotSuite) testHappyRoundtrip(c *check.C, marker string) {
 	shr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
 	c.Assert(err, check.IsNil)
 	defer shr.Close()
+	if shr.IsEncrypted() {
+		// as saved by TestHappyRoundtripEncrypted
+		c.Assert(shr.Unlock("sekrit"), check.IsNil)
+	}
 
 	for label, sh := range map[string]*client.Snapshot{"open": &shr.Snapshot, "list": shs[0].Snapshots[0]} {
 		comm := check.Commentf("%q", label)
@@ -773,12 +784,18 @@ func (s *snapshotSuite) testHappyRoundtrip(c *check.C, marker string) {
 	c.Check(shr.Name(), check.Equals, filepath.Join(dirs.SnapshotsDir, "12_hello-snap_v1.33_42.zip"))
 	c.Check(shr.Check(context.TODO(), nil), check.IsNil)
 
+	chunksDir := filepath.Join(dirs.SnapshotsDir, "chunks")
 	newroot := c.MkDir()
 	c.Assert(os.MkdirAll(filepath.Join(newroot, "home/snapuser"), 0755), check.IsNil)
 	dirs.SetRootDir(newroot)
+	if osutil.IsDirectory(chunksDir) {
+		// incremental snapshots need the chunks saved under the old root
+		c.Assert(os.MkdirAll(dirs.SnapshotsDir, 0700), check.IsNil)
+		c.Assert(os.Symlink(chunksDir, filepath.Join(dirs.SnapshotsDir, "chunks")), check.IsNil)
+	}
 
 	var diff = func() *exec.Cmd {
-		cmd := exec.Command("diff", "-urN", "-x*.zip", s.root, newroot)
+		cmd := exec.Command("diff", "-urN", "-x*.zip", "-xchunks", s.root, newroot)
 		// cmd.Stdout = os.Stdout
 		// cmd.Stderr = os.Stderr
 		return cmd
@@ -858,7 +875,7 @@ func (s *snapshotSuite) TestRestoreRoundtripDifferentRevision(c *check.C) {
 	dirs.SetRootDir(newroot)
 
 	var diff = func() *exec.Cmd {
-		cmd := exec.Command("diff", "-urN", "-x*.zip", s.root, newroot)
+		cmd := exec.Command("diff", "-urN", "-x*.zip", "-xchunks", s.root, newroot)
 		// cmd.Stdout = os.Stdout
 		// cmd.Stderr = os.Stderr
 		return cmd
@@ -1175,6 +1192,130 @@ func (s *snapshotSuite) TestImportExportRoundtrip(c *check.C) {
 	c.Check(rdr.IsValid(), check.Equals, true)
 }
 
+func chunkFiles(c *check.C) []string {
+	chunks, err := filepath.Glob(filepath.Join(dirs.SnapshotsDir, "chunks", "*", "*"))
+	c.Assert(err, check.IsNil)
+	return chunks
+}
+
+func (s *snapshotSuite) TestSaveIncrementalSharesChunks(c *check.C) {
+	defer backend.MockChunkSizes(512, 2048, 8)()
+
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw1, err := backend.SaveIncremental(ctx, 12, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(hashkeys(shw1), check.DeepEquals, []string{"archive.tgz", "user/snapuser.tgz"})
+	chunks := chunkFiles(c)
+	c.Check(len(chunks) > 2, check.Equals, true)
+
+	// the same data doesn't add any chunk
+	shw2, err := backend.SaveIncremental(ctx, 13, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(chunkFiles(c), check.DeepEquals, chunks)
+	c.Check(shw2.SHA3_384, check.DeepEquals, shw1.SHA3_384)
+
+	// the snapshots don't hold the data themselves, but can be checked
+	for _, shw := range []*client.Snapshot{shw1, shw2} {
+		rdr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+		c.Assert(err, check.IsNil)
+		incremental, err := rdr.IsIncremental()
+		c.Check(err, check.IsNil)
+		c.Check(incremental, check.Equals, true)
+		c.Check(rdr.Check(ctx, nil), check.IsNil)
+		rdr.Close()
+	}
+
+	// chunks are kept while any snapshot refers to them
+	c.Assert(os.Remove(backend.Filename(shw1)), check.IsNil)
+	c.Assert(backend.CollectChunks(ctx), check.IsNil)
+	c.Check(chunkFiles(c), check.DeepEquals, chunks)
+
+	c.Assert(os.Remove(backend.Filename(shw2)), check.IsNil)
+	c.Assert(backend.CollectChunks(ctx), check.IsNil)
+	c.Check(chunkFiles(c), check.HasLen, 0)
+}
+
+func (s *snapshotSuite) TestSaveIncrementalMissingChunk(c *check.C) {
+	defer backend.MockChunkSizes(512, 2048, 8)()
+
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.SaveIncremental(ctx, 12, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+	chunks := chunkFiles(c)
+	c.Assert(chunks, check.Not(check.HasLen), 0)
+	c.Assert(os.Remove(chunks[0]), check.IsNil)
+
+	rdr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	defer rdr.Close()
+	c.Check(rdr.Check(ctx, nil), check.ErrorMatches, "cannot open snapshot chunk: .*")
+}
+
+func (s *snapshotSuite) TestCollectChunksWhileSaving(c *check.C) {
+	defer backend.MockChunkSizes(512, 2048, 8)()
+
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.SaveIncremental(ctx, 12, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(os.Remove(backend.Filename(shw)), check.IsNil)
+	chunks := chunkFiles(c)
+
+	// a snapshot being saved holds a shared lock on the chunks
+	lock, err := osutil.NewFileLock(filepath.Join(dirs.SnapshotsDir, "chunks", ".lock"))
+	c.Assert(err, check.IsNil)
+	defer lock.Close()
+	c.Assert(lock.ReadLock(), check.IsNil)
+
+	c.Assert(backend.CollectChunks(ctx), check.IsNil)
+	c.Check(chunkFiles(c), check.DeepEquals, chunks)
+
+	lock.Unlock()
+	c.Assert(backend.CollectChunks(ctx), check.IsNil)
+	c.Check(chunkFiles(c), check.HasLen, 0)
+}
+
+func (s *snapshotSuite) TestImportExportRoundtripIncremental(c *check.C) {
+	defer backend.MockChunkSizes(512, 2048, 8)()
+
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+
+	shw, err := backend.SaveIncremental(ctx, 12, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	export, err := backend.NewSnapshotExport(ctx, shw.SetID)
+	c.Assert(err, check.IsNil)
+	defer export.Close()
+	c.Assert(export.Init(), check.IsNil)
+	buf := bytes.NewBuffer(nil)
+	c.Assert(export.StreamTo(buf), check.IsNil)
+	c.Check(buf.Len(), check.Equals, int(export.Size()))
+
+	// the export doesn't need the chunks
+	c.Assert(os.Remove(backend.Filename(shw)), check.IsNil)
+	c.Assert(backend.CollectChunks(ctx), check.IsNil)
+	c.Assert(chunkFiles(c), check.HasLen, 0)
+
+	names, err := backend.Import(ctx, 123, buf, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"hello-snap"})
+
+	rdr, err := backend.Open(filepath.Join(dirs.SnapshotsDir, "123_hello-snap_v1.33_42.zip"), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	defer rdr.Close()
+	c.Check(rdr.SHA3_384, check.DeepEquals, shw.SHA3_384)
+	incremental, err := rdr.IsIncremental()
+	c.Check(err, check.IsNil)
+	c.Check(incremental, check.Equals, false)
+	c.Check(rdr.Check(ctx, nil), check.IsNil)
+}
+
 func (s *snapshotSuite) TestEstimateSnapshotSize(c *check.C) {
 
 	for _, t := range []struct {
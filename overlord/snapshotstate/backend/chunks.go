@@ -0,0 +1,424 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// Incremental snapshots don't store their archives in the snapshot file
+// itself. The (uncompressed) tar stream is split into chunks on boundaries
+// that depend on its content, so that data that didn't change between
+// snapshots results in the same chunks, and each chunk is compressed on its
+// own and kept in a content-addressed store shared by all snapshots. The
+// snapshot file keeps the list of chunks making up each archive instead.
+//
+// As the concatenation of the compressed chunks is itself a valid
+// (multi-member) gzip stream, the data of an archive and its hash are the same
+// whether it is stored in chunks or not.
+
+const (
+	chunksDirName    = "chunks"
+	chunksLockName   = ".lock"
+	chunkIndexSuffix = ".chunks"
+)
+
+var (
+	// chunks are cut where the rolling hash of the data has its lowest
+	// chunkAvgBits bits unset, but are never smaller than chunkMinSize nor
+	// bigger than chunkMaxSize
+	chunkMinSize = 256 * 1024
+	chunkMaxSize = 8 * 1024 * 1024
+	chunkAvgBits = uint(20)
+)
+
+// gearTable holds the random values used by the rolling hash. It must not
+// change, or data saved after the change won't share chunks with data saved
+// before it.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64
+	x := uint64(0x736e617073686f74)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunkRef refers to a chunk in the chunk store.
+type chunkRef struct {
+	SHA3_384 string `json:"sha3-384"`
+	Size     int64  `json:"size"`
+}
+
+func chunksDir() string {
+	return filepath.Join(dirs.SnapshotsDir, chunksDirName)
+}
+
+func chunkPath(sum string) string {
+	return filepath.Join(chunksDir(), sum[:2], sum)
+}
+
+func chunkIndexName(entry string) string {
+	return entry + chunkIndexSuffix
+}
+
+// lockChunks takes a lock on the chunk store, shared when adding chunks to
+// it and exclusive when removing chunks from it.
+func lockChunks(exclusive bool) (*osutil.FileLock, error) {
+	if err := os.MkdirAll(chunksDir(), 0700); err != nil {
+		return nil, err
+	}
+	lock, err := osutil.NewFileLockWithMode(filepath.Join(chunksDir(), chunksLockName), 0600)
+	if err != nil {
+		return nil, err
+	}
+	if exclusive {
+		err = lock.TryLock()
+	} else {
+		err = lock.ReadLock()
+	}
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// chunkWriter splits the data written to it into chunks which it adds to the
+// chunk store.
+type chunkWriter struct {
+	buf  []byte
+	hash uint64
+
+	refs []chunkRef
+	// out receives the compressed chunks, in order
+	out io.Writer
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	mask := uint64(1)<<chunkAvgBits - 1
+	for i, b := range p {
+		w.buf = append(w.buf, b)
+		w.hash = (w.hash << 1) + gearTable[b]
+		if len(w.buf) >= chunkMaxSize || (len(w.buf) >= chunkMinSize && w.hash&mask == 0) {
+			if err := w.flush(); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// flush adds the buffered data to the chunk store as a new chunk.
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var compressed bytes.Buffer
+	// the compressed data must only depend on the uncompressed data, which
+	// is the case with an empty header
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(w.buf); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	hasher := crypto.SHA3_384.New()
+	hasher.Write(compressed.Bytes())
+	sum := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	path := chunkPath(sum)
+	if !osutil.FileExists(path) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := osutil.AtomicWriteFile(path, compressed.Bytes(), 0600, 0); err != nil {
+			return fmt.Errorf("cannot write snapshot chunk: %v", err)
+		}
+	}
+
+	if _, err := w.out.Write(compressed.Bytes()); err != nil {
+		return err
+	}
+	w.refs = append(w.refs, chunkRef{SHA3_384: sum, Size: int64(compressed.Len())})
+	w.buf = w.buf[:0]
+	w.hash = 0
+	return nil
+}
+
+// chunksReader reads the data of the chunks, in order.
+type chunksReader struct {
+	refs []chunkRef
+	cur  *os.File
+}
+
+func (r *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.refs) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(chunkPath(r.refs[0].SHA3_384))
+			if err != nil {
+				return 0, fmt.Errorf("cannot open snapshot chunk: %v", err)
+			}
+			r.cur = f
+			r.refs = r.refs[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *chunksReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// chunkRefs returns the chunks making up the entry of the snapshot, or nil if
+// the entry is stored in the snapshot itself.
+func chunkRefs(f *os.File, entry string) ([]chunkRef, error) {
+	if ok, err := zipHasMember(f, chunkIndexName(entry)); err != nil || !ok {
+		return nil, err
+	}
+
+	body, _, err := zipMember(f, chunkIndexName(entry))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var refs []chunkRef
+	if err := json.NewDecoder(body).Decode(&refs); err != nil {
+		return nil, fmt.Errorf("cannot read chunks of snapshot entry %q: %v", entry, err)
+	}
+	if refs == nil {
+		refs = []chunkRef{}
+	}
+	return refs, nil
+}
+
+// entryReader returns a reader for the data of the entry, either from the
+// snapshot itself or from the chunk store.
+func (r *Reader) entryReader(entry string) (body io.ReadCloser, size int64, err error) {
+	refs, err := chunkRefs(r.File, entry)
+	if err != nil {
+		return nil, -1, err
+	}
+	if refs == nil {
+		return zipMember(r.File, entry)
+	}
+
+	for _, ref := range refs {
+		size += ref.Size
+	}
+	return &chunksReader{refs: refs}, size, nil
+}
+
+// IsIncremental returns whether the archives of the snapshot are kept in the
+// chunk store rather than in the snapshot itself.
+func (r *Reader) IsIncremental() (bool, error) {
+	for entry := range r.SHA3_384 {
+		if refs, err := chunkRefs(r.File, entry); err != nil || refs != nil {
+			return refs != nil, err
+		}
+	}
+	return false, nil
+}
+
+// selfContained returns a copy of the snapshot with the data of its archives
+// taken from the chunk store, as if it had not been saved incrementally. The
+// copy isn't linked in the filesystem, but its name is the snapshot's.
+func (r *Reader) selfContained(ctx context.Context) (*os.File, error) {
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	fi, err := r.Stat()
+	if err != nil {
+		return nil, err
+	}
+	arch, err := zip.NewReader(r.File, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dirs.SnapshotsDir, ".export")
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	if err := os.Remove(tmp.Name()); err != nil {
+		return nil, err
+	}
+
+	w := zip.NewWriter(tmp)
+	for _, fh := range arch.File {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if !strings.HasSuffix(fh.Name, chunkIndexSuffix) {
+			if err := w.Copy(fh); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		entry := strings.TrimSuffix(fh.Name, chunkIndexSuffix)
+		body, _, err := r.entryReader(entry)
+		if err != nil {
+			return nil, err
+		}
+		err = copyToZip(w, entry, body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("cannot copy snapshot entry %q: %v", entry, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	// give the copy the snapshot's name
+	fd, err := syscall.Dup(int(tmp.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot duplicate descriptor: %v", err)
+	}
+	return os.NewFile(uintptr(fd), r.Name()), nil
+}
+
+func copyToZip(w *zip.Writer, entry string, r io.Reader) error {
+	ew, err := w.CreateHeader(&zip.FileHeader{Name: entry})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ew, r)
+	return err
+}
+
+// addChunkIndexToZip adds the entry to the snapshot as the list of the chunks
+// its data was split into by the chunkWriter.
+func addChunkIndexToZip(w *zip.Writer, entry string, refs []chunkRef) error {
+	if refs == nil {
+		refs = []chunkRef{}
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	return copyToZip(w, chunkIndexName(entry), bytes.NewReader(data))
+}
+
+// CollectChunks removes the chunks that aren't referenced by any snapshot
+// from the chunk store. Chunks are kept while incremental snapshots are being
+// saved, in which case they'll be collected the next time.
+func CollectChunks(ctx context.Context) error {
+	if exists, _, _ := osutil.DirExists(chunksDir()); !exists {
+		return nil
+	}
+
+	lock, err := lockChunks(true)
+	if err == osutil.ErrAlreadyLocked {
+		logger.Debugf("Not removing unused snapshot chunks while snapshots are being saved.")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	refCounts := make(map[string]int)
+	broken := false
+	err = Iter(ctx, func(r *Reader) error {
+		if r.Broken != "" {
+			// there is no telling which chunks it refers to
+			broken = true
+			return nil
+		}
+		for entry := range r.SHA3_384 {
+			refs, err := chunkRefs(r.File, entry)
+			if err != nil {
+				return fmt.Errorf("cannot read chunks of snapshot %q: %v", r.Name(), err)
+			}
+			for _, ref := range refs {
+				refCounts[ref.SHA3_384]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if broken {
+		logger.Noticef("Not removing unused snapshot chunks while there are broken snapshots.")
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(chunksDir(), "*", "*"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range paths {
+		if refCounts[filepath.Base(path)] > 0 {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return newMultiError("cannot remove unused snapshot chunks", errs)
+	}
+	return nil
+}
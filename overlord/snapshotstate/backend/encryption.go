@@ -0,0 +1,359 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+)
+
+// The archives of encrypted snapshots are encrypted with AES-256-GCM, in
+// segments so that they can be streamed. The stream starts with a random
+// nonce prefix, and the nonce of each segment is that prefix followed by the
+// segment counter and by a flag marking the last segment, so that segments
+// cannot be reordered, dropped or truncated unnoticed.
+
+const (
+	keySize          = 32
+	saltSize         = 16
+	noncePrefixSize  = 7
+	segmentSize      = 64 * 1024
+	deviceKeyName    = "snapshots.key"
+	keyCheckMessage  = "snapd snapshot key check"
+	lastSegmentFlag  = 1
+	sealedSegmentMax = segmentSize + 16
+)
+
+var (
+	// ErrNoKey is returned when the key of an encrypted snapshot isn't
+	// available.
+	ErrNoKey = errors.New("snapshot is encrypted and its key is not available")
+	// ErrWrongKey is returned when the key of an encrypted snapshot
+	// doesn't match the one it was encrypted with.
+	ErrWrongKey = errors.New("snapshot was encrypted with a different key")
+)
+
+// passphraseKeyDerivation derives keys from passphrases (using argon2id).
+var passphraseKeyDerivation = func(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, keySize)
+}
+
+// A Key is used to encrypt and decrypt the archives of snapshots.
+type Key struct {
+	scheme string
+	salt   []byte
+	key    []byte
+}
+
+// DeviceKey returns the key bound to this device, creating it if needed.
+//
+// The key is not sealed: it's a random secret in a file only root can read,
+// in the same data directory as the snapshots. It thus protects the snapshots
+// copied off the device, not those on it, unless the data partition is itself
+// encrypted as with full disk encryption.
+func DeviceKey() (*Key, error) {
+	key, err := readDeviceKey()
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	secret := make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dirs.SnapDeviceDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := osutil.AtomicWriteFile(deviceKeyPath(), secret, 0600, 0); err != nil {
+		return nil, fmt.Errorf("cannot store snapshot key: %v", err)
+	}
+	return &Key{scheme: client.SnapshotEncryptionDevice, key: secret}, nil
+}
+
+func deviceKeyPath() string {
+	return filepath.Join(dirs.SnapDeviceDir, deviceKeyName)
+}
+
+func readDeviceKey() (*Key, error) {
+	secret, err := os.ReadFile(deviceKeyPath())
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != keySize {
+		return nil, fmt.Errorf("invalid snapshot key in %q", deviceKeyPath())
+	}
+	return &Key{scheme: client.SnapshotEncryptionDevice, key: secret}, nil
+}
+
+// PassphraseKey returns a new key derived from the given passphrase.
+func PassphraseKey(passphrase string) (*Key, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &Key{
+		scheme: client.SnapshotEncryptionPassphrase,
+		salt:   salt,
+		key:    passphraseKeyDerivation(passphrase, salt),
+	}, nil
+}
+
+// encryption returns the description of the encryption with the key, as
+// recorded in the metadata of the snapshots.
+func (k *Key) encryption() *client.SnapshotEncryption {
+	return &client.SnapshotEncryption{
+		Scheme:   k.scheme,
+		Salt:     k.salt,
+		KeyCheck: k.check(),
+	}
+}
+
+// check returns a value that tells whether a key is the one a snapshot was
+// encrypted with, without revealing anything about the key.
+func (k *Key) check() string {
+	mac := hmac.New(sha3.New384, k.key)
+	mac.Write([]byte(keyCheckMessage))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+func (k *Key) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted returns whether the archives of the snapshot are encrypted.
+func (r *Reader) IsEncrypted() bool {
+	return r.Encryption != nil
+}
+
+// Unlock makes the snapshot use the key derived from the passphrase to
+// decrypt its archives.
+func (r *Reader) Unlock(passphrase string) error {
+	if r.Encryption == nil || r.Encryption.Scheme != client.SnapshotEncryptionPassphrase {
+		return fmt.Errorf("snapshot is not encrypted with a passphrase")
+	}
+	key := &Key{
+		scheme: client.SnapshotEncryptionPassphrase,
+		salt:   r.Encryption.Salt,
+		key:    passphraseKeyDerivation(passphrase, r.Encryption.Salt),
+	}
+	if !hmac.Equal([]byte(key.check()), []byte(r.Encryption.KeyCheck)) {
+		return fmt.Errorf("cannot unlock snapshot: wrong passphrase")
+	}
+	r.key = key
+	return nil
+}
+
+// decryptionKey returns the key to decrypt the archives of the snapshot
+// with, or nil if they aren't encrypted.
+func (r *Reader) decryptionKey() (*Key, error) {
+	if r.Encryption == nil || r.key != nil {
+		return r.key, nil
+	}
+	switch r.Encryption.Scheme {
+	case client.SnapshotEncryptionDevice:
+		key, err := readDeviceKey()
+		if os.IsNotExist(err) {
+			return nil, ErrNoKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !hmac.Equal([]byte(key.check()), []byte(r.Encryption.KeyCheck)) {
+			return nil, ErrWrongKey
+		}
+		r.key = key
+		return key, nil
+	case client.SnapshotEncryptionPassphrase:
+		return nil, ErrNoKey
+	default:
+		return nil, fmt.Errorf("unsupported snapshot encryption scheme %q", r.Encryption.Scheme)
+	}
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[noncePrefixSize+4] = lastSegmentFlag
+	}
+	return nonce
+}
+
+// encryptingWriter encrypts the data written to it into out. It must be
+// closed for the last segment to be written.
+type encryptingWriter struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	out     io.Writer
+}
+
+func newEncryptingWriter(out io.Writer, key *Key) (*encryptingWriter, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encryptingWriter{aead: aead, prefix: prefix, out: out}, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// keep a full segment around until more data comes, as it
+		// might be the last one
+		if len(w.buf) == segmentSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := segmentSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (w *encryptingWriter) seal(last bool) error {
+	if w.counter == ^uint32(0) {
+		return fmt.Errorf("snapshot archive too big to encrypt")
+	}
+	sealed := w.aead.Seal(nil, segmentNonce(w.prefix, w.counter, last), w.buf, nil)
+	if _, err := w.out.Write(sealed); err != nil {
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last segment.
+func (w *encryptingWriter) Close() error {
+	return w.seal(true)
+}
+
+// decryptingReader decrypts the data read from in.
+type decryptingReader struct {
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	in      io.Reader
+	// next holds the first byte of the next segment, to tell whether
+	// the current segment is the last one
+	next []byte
+	buf  []byte
+	done bool
+}
+
+func newDecryptingReader(in io.Reader, key *Key) (*decryptingReader, error) {
+	aead, err := key.aead()
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(in, prefix); err != nil {
+		return nil, fmt.Errorf("cannot read encrypted archive: %v", noEOF(err))
+	}
+	return &decryptingReader{aead: aead, prefix: prefix, in: in}, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (r *decryptingReader) open() error {
+	// read one more byte than a segment to know if this is the last one
+	sealed := make([]byte, sealedSegmentMax+1)
+	copy(sealed, r.next)
+	n, err := io.ReadFull(r.in, sealed[len(r.next):])
+	n += len(r.next)
+	last := false
+	switch err {
+	case nil:
+		r.next = []byte{sealed[sealedSegmentMax]}
+		sealed = sealed[:sealedSegmentMax]
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+		sealed = sealed[:n]
+	default:
+		return err
+	}
+
+	plain, err := r.aead.Open(sealed[:0], segmentNonce(r.prefix, r.counter, last), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt snapshot archive: %v", err)
+	}
+	r.counter++
+	r.buf = plain
+	r.done = last
+	return nil
+}
@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type encryptionSuite struct {
+	testutil.BaseTest
+}
+
+var _ = check.Suite(&encryptionSuite{})
+
+func (s *encryptionSuite) SetUpTest(c *check.C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+}
+
+func encrypt(c *check.C, data []byte, key *backend.Key) []byte {
+	var buf bytes.Buffer
+	w, err := backend.NewEncryptingWriter(&buf, key)
+	c.Assert(err, check.IsNil)
+	// write in odd sizes
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := w.Write(data[:n])
+		c.Assert(err, check.IsNil)
+		data = data[n:]
+	}
+	c.Assert(w.Close(), check.IsNil)
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, key *backend.Key) ([]byte, error) {
+	r, err := backend.NewDecryptingReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (s *encryptionSuite) TestRoundtrip(c *check.C) {
+	key, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+
+	for _, size := range []int{0, 1, backend.SegmentSize - 1, backend.SegmentSize, backend.SegmentSize + 1, 3*backend.SegmentSize + 42} {
+		comm := check.Commentf("%d", size)
+		data := bytes.Repeat([]byte{'x'}, size)
+		encrypted := encrypt(c, data, key)
+		c.Check(bytes.Contains(encrypted, []byte("xxxx")), check.Equals, false, comm)
+
+		decrypted, err := decrypt(encrypted, key)
+		c.Assert(err, check.IsNil, comm)
+		c.Check(decrypted, check.DeepEquals, data, comm)
+	}
+}
+
+func (s *encryptionSuite) TestTampered(c *check.C) {
+	key, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+	data := bytes.Repeat([]byte{'x'}, 2*backend.SegmentSize)
+	encrypted := encrypt(c, data, key)
+
+	// modified
+	modified := append([]byte(nil), encrypted...)
+	modified[len(modified)/2] ^= 1
+	_, err = decrypt(modified, key)
+	c.Check(err, check.ErrorMatches, "cannot decrypt snapshot archive: .*")
+
+	// truncated, right after a segment
+	_, err = decrypt(encrypted[:len(encrypted)/2], key)
+	c.Check(err, check.ErrorMatches, "cannot decrypt snapshot archive: .*")
+
+	// truncated before the first segment
+	_, err = decrypt(encrypted[:3], key)
+	c.Check(err, check.ErrorMatches, "cannot read encrypted archive: unexpected EOF")
+
+	// another key
+	c.Assert(os.Remove(filepath.Join(dirs.SnapDeviceDir, "snapshots.key")), check.IsNil)
+	otherKey, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+	_, err = decrypt(encrypted, otherKey)
+	c.Check(err, check.ErrorMatches, "cannot decrypt snapshot archive: .*")
+}
+
+func (s *encryptionSuite) TestDeviceKey(c *check.C) {
+	key1, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+	st, err := os.Stat(filepath.Join(dirs.SnapDeviceDir, "snapshots.key"))
+	c.Assert(err, check.IsNil)
+	c.Check(st.Size(), check.Equals, int64(32))
+	c.Check(st.Mode().Perm(), check.Equals, os.FileMode(0600))
+
+	// the same key is used after that
+	key2, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+	data, err := decrypt(encrypt(c, []byte("hello"), key1), key2)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "hello")
+}
+
+func (s *snapshotSuite) TestSaveEncryptedDevice(c *check.C) {
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+	key, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+
+	shw, err := backend.SaveWithOptions(ctx, 12, info, nil, []string{"snapuser"}, nil, nil, &backend.SaveOptions{Key: key})
+	c.Assert(err, check.IsNil)
+	c.Assert(shw.Encryption, check.NotNil)
+	c.Check(shw.Encryption.Scheme, check.Equals, client.SnapshotEncryptionDevice)
+	c.Check(shw.Encryption.Salt, check.IsNil)
+	c.Check(shw.Encryption.KeyCheck, check.Not(check.Equals), "")
+
+	rdr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	defer rdr.Close()
+	c.Check(rdr.IsEncrypted(), check.Equals, true)
+	c.Check(rdr.Encryption, check.DeepEquals, shw.Encryption)
+	c.Check(rdr.Check(ctx, nil), check.IsNil)
+
+	// without the key only the hashsums are checked
+	c.Assert(os.Remove(filepath.Join(dirs.SnapDeviceDir, "snapshots.key")), check.IsNil)
+	rdr2, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	defer rdr2.Close()
+	c.Check(rdr2.Check(ctx, nil), check.IsNil)
+	_, err = rdr2.Restore(ctx, snap.R(0), nil, func(string, ...interface{}) {}, nil)
+	c.Check(err, check.ErrorMatches, `cannot decrypt snapshot ".*": snapshot is encrypted and its key is not available`)
+
+	// and not with another key
+	_, err = backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+	_, err = rdr2.Restore(ctx, snap.R(0), nil, func(string, ...interface{}) {}, nil)
+	c.Check(err, check.ErrorMatches, `cannot decrypt snapshot ".*": snapshot was encrypted with a different key`)
+}
+
+func (s *snapshotSuite) TestHappyRoundtripEncrypted(c *check.C) {
+	key, err := backend.PassphraseKey("sekrit")
+	c.Assert(err, check.IsNil)
+	s.testHappyRoundtrip(c, "marker", func(ctx context.Context, id uint64, si *snap.Info, cfg map[string]interface{}, usernames []string, dynSnapshotOpts *snap.SnapshotOptions, dirOpts *dirs.SnapDirOptions) (*client.Snapshot, error) {
+		return backend.SaveWithOptions(ctx, id, si, cfg, usernames, dynSnapshotOpts, dirOpts, &backend.SaveOptions{Key: key})
+	})
+}
+
+func (s *snapshotSuite) TestSaveEncryptedPassphrase(c *check.C) {
+	ctx := context.TODO()
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+	key, err := backend.PassphraseKey("sekrit")
+	c.Assert(err, check.IsNil)
+
+	shw, err := backend.SaveWithOptions(ctx, 12, info, nil, []string{"snapuser"}, nil, nil, &backend.SaveOptions{Key: key})
+	c.Assert(err, check.IsNil)
+	c.Assert(shw.Encryption, check.NotNil)
+	c.Check(shw.Encryption.Scheme, check.Equals, client.SnapshotEncryptionPassphrase)
+	c.Check(shw.Encryption.Salt, check.HasLen, 16)
+
+	rdr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	defer rdr.Close()
+	_, err = rdr.Restore(ctx, snap.R(0), nil, func(string, ...interface{}) {}, nil)
+	c.Check(err, check.ErrorMatches, `cannot decrypt snapshot ".*": snapshot is encrypted and its key is not available`)
+
+	c.Check(rdr.Unlock("wrong"), check.ErrorMatches, "cannot unlock snapshot: wrong passphrase")
+	c.Assert(rdr.Unlock("sekrit"), check.IsNil)
+	c.Check(rdr.Check(ctx, nil), check.IsNil)
+}
+
+func (s *snapshotSuite) TestSaveEncryptedIncremental(c *check.C) {
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42)}, Version: "v1.33"}
+	key, err := backend.DeviceKey()
+	c.Assert(err, check.IsNil)
+
+	_, err = backend.SaveWithOptions(context.TODO(), 12, info, nil, nil, nil, nil, &backend.SaveOptions{Key: key, Incremental: true})
+	c.Check(err, check.ErrorMatches, "cannot save an encrypted snapshot incrementally")
+}
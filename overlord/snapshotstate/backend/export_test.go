@@ -20,6 +20,7 @@
 package backend
 
 import (
+	"io"
 	"os"
 	"os/exec"
 	"os/user"
@@ -41,6 +42,8 @@ var (
 	NewMultiError = newMultiError
 
 	AddSnapDirToZip = addSnapDirToZip
+
+	SegmentSize = segmentSize
 )
 
 func MockIsTesting(newIsTesting bool) func() {
@@ -135,6 +138,22 @@ func MockSnapshot(setID uint64, snapName string, revision snap.Revision, size in
 	}
 }
 
+func MockChunkSizes(min, max int, avgBits uint) (restore func()) {
+	oldMin, oldMax, oldAvgBits := chunkMinSize, chunkMaxSize, chunkAvgBits
+	chunkMinSize, chunkMaxSize, chunkAvgBits = min, max, avgBits
+	return func() {
+		chunkMinSize, chunkMaxSize, chunkAvgBits = oldMin, oldMax, oldAvgBits
+	}
+}
+
+func NewEncryptingWriter(out io.Writer, key *Key) (io.WriteCloser, error) {
+	return newEncryptingWriter(out, key)
+}
+
+func NewDecryptingReader(in io.Reader, key *Key) (io.Reader, error) {
+	return newDecryptingReader(in, key)
+}
+
 func MockFilepathGlob(new func(pattern string) (matches []string, err error)) (restore func()) {
 	oldFilepathGlob := filepathGlob
 	filepathGlob = new
@@ -0,0 +1,292 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/osutil/sys"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/strutil"
+)
+
+// ValidatePathPattern checks that the pattern can be used to select the data
+// of snapshots to restore: a shell pattern, as accepted by filepath.Match,
+// relative to the data directories of a snap, as in "common/*.conf".
+func ValidatePathPattern(pattern string) error {
+	if pattern == "" || filepath.IsAbs(pattern) || filepath.Clean(pattern) != pattern {
+		return fmt.Errorf("invalid snapshot path pattern %q: must be a clean relative path", pattern)
+	}
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == ".." {
+			return fmt.Errorf("invalid snapshot path pattern %q: must not refer to parent directories", pattern)
+		}
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid snapshot path pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// pathSelection selects the data to restore, and where to.
+type pathSelection struct {
+	patterns []string
+	// target, if not empty, is the directory the data is restored
+	// under instead of into place
+	target string
+}
+
+// matches returns whether the path, relative to the data directories of a
+// snap, is matched by one of the patterns.
+func (sel *pathSelection) matches(rel string) bool {
+	for _, pattern := range sel.patterns {
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// targetDir returns the directory under the target the data of the entry
+// is restored into: the system data goes right into the target, and that of
+// the users under users/<username>.
+func (sel *pathSelection) targetDir(username string, isUser bool) string {
+	if !isUser {
+		return sel.target
+	}
+	return filepath.Join(sel.target, "users", username)
+}
+
+// moveMatching moves the matching files and directories unpacked in
+// sourceDir to targetDir, renaming the revision directory they are under from
+// fromRevdir to toRevdir. Directories are moved with all of their contents.
+// Whatever is in the way is moved aside. It returns how many files and
+// directories were moved.
+func (sel *pathSelection) moveMatching(rs *RestoreState, sourceDir, targetDir, fromRevdir, toRevdir string, uid sys.UserID, gid sys.GroupID) (int, error) {
+	var matching []string
+	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == sourceDir {
+			return nil
+		}
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		if !sel.matches(rel) {
+			return nil
+		}
+		matching = append(matching, rel)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, rel := range matching {
+		dstRel := rel
+		if first, rest, _ := strings.Cut(rel, "/"); first == fromRevdir {
+			dstRel = filepath.Join(toRevdir, rest)
+		}
+		dst := filepath.Join(targetDir, dstRel)
+		if err := mkdirAllRecorded(rs, filepath.Dir(dst), uid, gid); err != nil {
+			return 0, err
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			rsfn := restoreStateFilename(dst)
+			if err := os.Rename(dst, rsfn); err != nil {
+				return 0, err
+			}
+			rs.Moved = append(rs.Moved, rsfn)
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err := os.Rename(filepath.Join(sourceDir, rel), dst); err != nil {
+			return 0, err
+		}
+		rs.Created = append(rs.Created, dst)
+	}
+
+	return len(matching), nil
+}
+
+// mkdirAllRecorded creates the directory and its missing parents, and
+// registers the topmost one it created in the RestoreState.
+func mkdirAllRecorded(rs *RestoreState, dir string, uid sys.UserID, gid sys.GroupID) error {
+	topmost := ""
+	for d := filepath.Clean(dir); d != "/" && d != "."; d = filepath.Dir(d) {
+		exists, isDir, err := osutil.DirExists(d)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if !isDir {
+				return fmt.Errorf("cannot restore snapshot into %q: not a directory", d)
+			}
+			break
+		}
+		topmost = d
+	}
+	if topmost == "" {
+		return nil
+	}
+	if err := osutil.MkdirAllChown(dir, 0755, uid, gid); err != nil {
+		return err
+	}
+	rs.Created = append(rs.Created, topmost)
+	return nil
+}
+
+// RestorePaths restores only the files and directories of the data of the
+// snapshot that match the given patterns (see ValidatePathPattern), leaving
+// the rest of the data of the snap alone; matching directories are restored
+// with all of their contents.
+//
+// If target is empty the data is restored into place, like Restore does.
+// Otherwise it's restored under target, the system data of the snap right
+// into it and that of the users into users/<username>, keeping the revision
+// of the snapshot unless current is set.
+func (r *Reader) RestorePaths(ctx context.Context, current snap.Revision, usernames []string, patterns []string, target string, logf Logf, opts *dirs.SnapDirOptions) (rs *RestoreState, e error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("internal error: no paths to restore")
+	}
+	for _, pattern := range patterns {
+		if err := ValidatePathPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	if target != "" && !filepath.IsAbs(target) {
+		return nil, fmt.Errorf("cannot restore snapshot into %q: not an absolute path", target)
+	}
+	sel := &pathSelection{patterns: patterns, target: target}
+	return r.restore(ctx, current, usernames, sel, logf, opts)
+}
+
+// Contents lists the files and directories in the data of the snapshot,
+// for the given users if non-empty, without restoring them.
+func (r *Reader) Contents(ctx context.Context, usernames []string) ([]client.SnapshotFile, error) {
+	sort.Strings(usernames)
+	key, err := r.decryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt snapshot %q: %v", r.Name(), err)
+	}
+
+	entries := make([]string, 0, len(r.SHA3_384))
+	for entry := range r.SHA3_384 {
+		entries = append(entries, entry)
+	}
+	// the system data first, then that of the users by name
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i] == archiveName || entries[j] == archiveName {
+			return entries[i] == archiveName && entries[j] != archiveName
+		}
+		return entries[i] < entries[j]
+	})
+
+	var files []client.SnapshotFile
+	for _, entry := range entries {
+		var username string
+		if isUserArchive(entry) {
+			username = entryUsername(entry)
+			if len(usernames) > 0 && !strutil.SortedListContains(usernames, username) {
+				continue
+			}
+		} else if entry != archiveName {
+			continue
+		}
+
+		entryFiles, err := r.entryContents(ctx, entry, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list contents of snapshot %q entry %q: %v", r.Name(), entry, err)
+		}
+		for i := range entryFiles {
+			entryFiles[i].Snap = r.Snap
+			entryFiles[i].User = username
+		}
+		files = append(files, entryFiles...)
+	}
+
+	return files, nil
+}
+
+func (r *Reader) entryContents(ctx context.Context, entry string, key *Key) ([]client.SnapshotFile, error) {
+	body, _, err := r.entryReader(entry)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var data io.Reader = body
+	if key != nil {
+		if data, err = newDecryptingReader(data, key); err != nil {
+			return nil, err
+		}
+	}
+	gz, err := gzip.NewReader(data)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var files []client.SnapshotFile
+	tr := tar.NewReader(gz)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." {
+			continue
+		}
+		files = append(files, client.SnapshotFile{
+			Path: name,
+			Mode: hdr.FileInfo().Mode(),
+			Size: hdr.Size,
+			Time: hdr.ModTime,
+			Link: hdr.Linkname,
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,212 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package backend_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+func (s *snapshotSuite) TestValidatePathPattern(c *check.C) {
+	for _, pattern := range []string{"common/foo.conf", "42/*", "*", "x1/[a-z]*.db", "common"} {
+		c.Check(backend.ValidatePathPattern(pattern), check.IsNil, check.Commentf(pattern))
+	}
+	for pattern, err := range map[string]string{
+		"":               `invalid snapshot path pattern "": must be a clean relative path`,
+		"/common/foo":    `invalid snapshot path pattern "/common/foo": must be a clean relative path`,
+		"common//foo":    `invalid snapshot path pattern "common//foo": must be a clean relative path`,
+		"common/foo/":    `invalid snapshot path pattern "common/foo/": must be a clean relative path`,
+		"../common":      `invalid snapshot path pattern "../common": must not refer to parent directories`,
+		"common/[a-":     `invalid snapshot path pattern "common/\[a-": syntax error in pattern`,
+		"./common/*.foo": `invalid snapshot path pattern "./common/\*.foo": must be a clean relative path`,
+	} {
+		c.Check(backend.ValidatePathPattern(pattern), check.ErrorMatches, err, check.Commentf(pattern))
+	}
+}
+
+// saveForFiles saves a snapshot of the data set up by SetUpTest, and opens it;
+// the caller needs to close it.
+func (s *snapshotSuite) saveForFiles(c *check.C) *backend.Reader {
+	if os.Geteuid() == 0 {
+		c.Skip("this test cannot run as root (runuser will fail)")
+	}
+	info := &snap.Info{SideInfo: snap.SideInfo{RealName: "hello-snap", Revision: snap.R(42), SnapID: "hello-id"}, Version: "v1.33"}
+	shw, err := backend.Save(context.TODO(), 12, info, nil, []string{"snapuser"}, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	shr, err := backend.Open(backend.Filename(shw), backend.ExtractFnameSetID)
+	c.Assert(err, check.IsNil)
+	return shr
+}
+
+func (s *snapshotSuite) TestContents(c *check.C) {
+	shr := s.saveForFiles(c)
+	defer shr.Close()
+
+	files, err := shr.Contents(context.TODO(), nil)
+	c.Assert(err, check.IsNil)
+	var listed []string
+	for _, f := range files {
+		c.Check(f.Snap, check.Equals, "hello-snap")
+		if f.Mode.IsDir() {
+			c.Check(f.Size, check.Equals, int64(0))
+		}
+		listed = append(listed, f.User+":"+f.Path)
+	}
+	c.Check(listed, testutil.DeepUnsortedMatches, []string{
+		":42", ":42/foo", ":common", ":common/bar",
+		"snapuser:42", "snapuser:42/ufoo", "snapuser:common", "snapuser:common/ubar",
+	})
+	// the system data is first
+	c.Check(files[0].User, check.Equals, "")
+	for _, f := range files {
+		if f.Path == "42/foo" {
+			c.Check(f.Size, check.Equals, int64(len("versioned system canary\n")))
+			c.Check(f.Mode.IsRegular(), check.Equals, true)
+		}
+	}
+
+	files, err = shr.Contents(context.TODO(), []string{"someone-else"})
+	c.Assert(err, check.IsNil)
+	listed = nil
+	for _, f := range files {
+		listed = append(listed, f.User+":"+f.Path)
+	}
+	c.Check(listed, testutil.DeepUnsortedMatches, []string{":42", ":42/foo", ":common", ":common/bar"})
+}
+
+func (s *snapshotSuite) TestRestorePathsInPlace(c *check.C) {
+	shr := s.saveForFiles(c)
+	defer shr.Close()
+
+	si := snap.MinimalPlaceInfo("hello-snap", snap.R(42))
+	homeDir := filepath.Join(dirs.GlobalRootDir, "home/snapuser")
+	foo := filepath.Join(si.DataDir(), "foo")
+	bar := filepath.Join(si.CommonDataDir(), "bar")
+	ufoo := filepath.Join(si.UserDataDir(homeDir, nil), "ufoo")
+	for _, fn := range []string{foo, bar, ufoo} {
+		c.Assert(os.WriteFile(fn, []byte("corrupted\n"), 0644), check.IsNil)
+	}
+	// restored files get their parent directories back
+	c.Assert(os.RemoveAll(si.UserCommonDataDir(homeDir, nil)), check.IsNil)
+	ubar := filepath.Join(si.UserCommonDataDir(homeDir, nil), "ubar")
+
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, format)
+	}
+	rs, err := shr.RestorePaths(context.TODO(), snap.R(0), nil, []string{"42/f*", "common/ubar"}, "", logf, nil)
+	c.Assert(err, check.IsNil)
+	// both entries have matching data
+	c.Check(logged, check.HasLen, 0)
+
+	c.Check(foo, testutil.FileEquals, "versioned system canary\n")
+	c.Check(bar, testutil.FileEquals, "corrupted\n")
+	c.Check(ufoo, testutil.FileEquals, "corrupted\n")
+	c.Check(ubar, testutil.FileEquals, "common user canary\n")
+
+	// and it can be undone
+	rs.Revert()
+	c.Check(foo, testutil.FileEquals, "corrupted\n")
+	c.Check(ubar, testutil.FileAbsent)
+	c.Check(filepath.Dir(ubar), testutil.FileAbsent)
+	c.Check(si.DataDir(), testutil.FilePresent)
+	entries, err := os.ReadDir(si.DataDir())
+	c.Assert(err, check.IsNil)
+	c.Check(entries, check.HasLen, 1)
+}
+
+func (s *snapshotSuite) TestRestorePathsCurrentAndCleanup(c *check.C) {
+	shr := s.saveForFiles(c)
+	defer shr.Close()
+
+	si := snap.MinimalPlaceInfo("hello-snap", snap.R(43))
+	c.Assert(os.MkdirAll(si.DataDir(), 0755), check.IsNil)
+	foo := filepath.Join(si.DataDir(), "foo")
+	c.Assert(os.WriteFile(foo, []byte("corrupted\n"), 0644), check.IsNil)
+
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	rs, err := shr.RestorePaths(context.TODO(), snap.R(43), []string{"snapuser"}, []string{"42/foo"}, "", logf, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(logged, check.DeepEquals, []string{
+		`No data matching "42/foo" in entry "user/snapuser.tgz" of snapshot "` + backend.Filename(&shr.Snapshot) + `".`,
+	})
+	c.Check(foo, testutil.FileEquals, "versioned system canary\n")
+	c.Assert(rs.Moved, check.HasLen, 1)
+	c.Check(rs.Moved[0], testutil.FileEquals, "corrupted\n")
+
+	rs.Cleanup()
+	c.Check(foo, testutil.FileEquals, "versioned system canary\n")
+	c.Check(rs.Moved[0], testutil.FileAbsent)
+}
+
+func (s *snapshotSuite) TestRestorePathsTarget(c *check.C) {
+	shr := s.saveForFiles(c)
+	defer shr.Close()
+
+	si := snap.MinimalPlaceInfo("hello-snap", snap.R(42))
+	bar := filepath.Join(si.CommonDataDir(), "bar")
+	c.Assert(os.WriteFile(bar, []byte("corrupted\n"), 0644), check.IsNil)
+
+	target := filepath.Join(c.MkDir(), "restored")
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	rs, err := shr.RestorePaths(context.TODO(), snap.R(0), nil, []string{"common", "common/nothing-*"}, target, logf, nil)
+	c.Assert(err, check.IsNil)
+	c.Check(logged, check.HasLen, 0)
+
+	// the data in place is left alone
+	c.Check(bar, testutil.FileEquals, "corrupted\n")
+	c.Check(filepath.Join(target, "common/bar"), testutil.FileEquals, "common system canary\n")
+	c.Check(filepath.Join(target, "users/snapuser/common/ubar"), testutil.FileEquals, "common user canary\n")
+	c.Check(filepath.Join(target, "42"), testutil.FileAbsent)
+	entries, err := os.ReadDir(target)
+	c.Assert(err, check.IsNil)
+	c.Check(entries, check.HasLen, 2)
+
+	rs.Revert()
+	c.Check(target, testutil.FileAbsent)
+}
+
+func (s *snapshotSuite) TestRestorePathsErrors(c *check.C) {
+	shr := s.saveForFiles(c)
+	defer shr.Close()
+
+	_, err := shr.RestorePaths(context.TODO(), snap.R(0), nil, nil, "", nil, nil)
+	c.Check(err, check.ErrorMatches, "internal error: no paths to restore")
+	_, err = shr.RestorePaths(context.TODO(), snap.R(0), nil, []string{"../foo"}, "", nil, nil)
+	c.Check(err, check.ErrorMatches, `invalid snapshot path pattern "../foo": must not refer to parent directories`)
+	_, err = shr.RestorePaths(context.TODO(), snap.R(0), nil, []string{"common"}, "relative", nil, nil)
+	c.Check(err, check.ErrorMatches, `cannot restore snapshot into "relative": not an absolute path`)
+}
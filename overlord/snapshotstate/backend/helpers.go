@@ -64,6 +64,31 @@ func zipMember(f *os.File, member string) (r io.ReadCloser, sz int64, err error)
 	return nil, -1, fmt.Errorf("missing archive member %q", member)
 }
 
+// zipHasMember returns whether the 'f' zip file has a 'member' file.
+func zipHasMember(f *os.File, member string) (bool, error) {
+	if _, err := f.Seek(0, 0); err != nil {
+		return false, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	arch, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return false, err
+	}
+
+	for _, fh := range arch.File {
+		if fh.Name == member {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func userArchiveName(usr *user.User) string {
 	return filepath.Join(userArchivePrefix, usr.Username+userArchiveSuffix)
 }
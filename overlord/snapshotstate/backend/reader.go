@@ -50,6 +50,9 @@ const ExtractFnameSetID = 0
 type Reader struct {
 	*os.File
 	client.Snapshot
+
+	// key decrypts the archives of encrypted snapshots
+	key *Key
 }
 
 // Open a Snapshot given its full filename.
@@ -142,20 +145,30 @@ func Open(fn string, setID uint64) (reader *Reader, e error) {
 	return reader, nil
 }
 
-func (r *Reader) checkOne(ctx context.Context, entry string, hasher hash.Hash) error {
-	body, reportedSize, err := zipMember(r.File, entry)
+func (r *Reader) checkOne(ctx context.Context, entry string, hasher hash.Hash, key *Key) error {
+	body, reportedSize, err := r.entryReader(entry)
 	if err != nil {
 		return err
 	}
 	defer body.Close()
 
 	expectedHash := r.SHA3_384[entry]
-	readSize, err := io.Copy(io.MultiWriter(osutil.ContextWriter(ctx), hasher), body)
-	if err != nil {
+	var sz osutil.Sizer
+	var data io.Reader = io.TeeReader(body, io.MultiWriter(hasher, &sz))
+	if key != nil {
+		// the data is also authenticated when decrypting it
+		if data, err = newDecryptingReader(data, key); err != nil {
+			return fmt.Errorf("snapshot entry %q: %v", entry, err)
+		}
+	}
+	if _, err := io.Copy(osutil.ContextWriter(ctx), data); err != nil {
+		if key != nil {
+			return fmt.Errorf("snapshot entry %q: %v", entry, err)
+		}
 		return err
 	}
 
-	if readSize != reportedSize {
+	if readSize := sz.Size(); readSize != reportedSize {
 		return fmt.Errorf("snapshot entry %q size (%d) different from actual (%d)", entry, reportedSize, readSize)
 	}
 
@@ -165,10 +178,18 @@ func (r *Reader) checkOne(ctx context.Context, entry string, hasher hash.Hash) e
 	return nil
 }
 
-// Check that the data contained in the snapshot matches its hashsums.
+// Check that the data contained in the snapshot matches its hashsums. The
+// data of encrypted snapshots is also decrypted if their key is available.
 func (r *Reader) Check(ctx context.Context, usernames []string) error {
 	sort.Strings(usernames)
 
+	key, err := r.decryptionKey()
+	if err == ErrNoKey || err == ErrWrongKey {
+		logger.Debugf("In checking snapshot %q, only checking the hashsums of the encrypted data: %v.", r.Name(), err)
+	} else if err != nil {
+		return err
+	}
+
 	hasher := crypto.SHA3_384.New()
 	for entry := range r.SHA3_384 {
 		if len(usernames) > 0 && isUserArchive(entry) {
@@ -179,7 +200,7 @@ func (r *Reader) Check(ctx context.Context, usernames []string) error {
 			}
 		}
 
-		if err := r.checkOne(ctx, entry, hasher); err != nil {
+		if err := r.checkOne(ctx, entry, hasher, key); err != nil {
 			return err
 		}
 		hasher.Reset()
@@ -197,6 +218,10 @@ type Logf func(format string, args ...interface{})
 // or the one in the snapshot) with that contained in the snapshot. It keeps
 // track of the old data in the task so it can be undone (or cleaned up).
 func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames []string, logf Logf, opts *dirs.SnapDirOptions) (rs *RestoreState, e error) {
+	return r.restore(ctx, current, usernames, nil, logf, opts)
+}
+
+func (r *Reader) restore(ctx context.Context, current snap.Revision, usernames []string, sel *pathSelection, logf Logf, opts *dirs.SnapDirOptions) (rs *RestoreState, e error) {
 	rs = &RestoreState{}
 	defer func() {
 		if e != nil {
@@ -207,6 +232,10 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 	}()
 
 	sort.Strings(usernames)
+	key, err := r.decryptionKey()
+	if err != nil {
+		return rs, fmt.Errorf("cannot decrypt snapshot %q: %v", r.Name(), err)
+	}
 	isRoot := sys.Geteuid() == 0
 	si := snap.MinimalPlaceInfo(r.Snap, r.Revision)
 	hasher := crypto.SHA3_384.New()
@@ -274,6 +303,13 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 			}
 		}
 		parent, revdir := filepath.Split(dest)
+		if sel != nil && sel.target != "" {
+			// created as root, unlike the data directories
+			parent = sel.targetDir(username, isUser)
+			if err := mkdirAllRecorded(rs, parent, sys.UserID(osutil.NoChown), sys.GroupID(osutil.NoChown)); err != nil {
+				return rs, err
+			}
+		}
 
 		exists, isDir, err := osutil.DirExists(parent)
 		if err != nil {
@@ -310,14 +346,19 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 
 		logger.Debugf("Restoring %q from %q into %q.", entry, r.Name(), tempdir)
 
-		body, expectedSize, err := zipMember(r.File, entry)
+		body, expectedSize, err := r.entryReader(entry)
 		if err != nil {
 			return rs, err
 		}
 
 		expectedHash := r.SHA3_384[entry]
 
-		tr := io.TeeReader(body, io.MultiWriter(hasher, &sz))
+		var tr io.Reader = io.TeeReader(body, io.MultiWriter(hasher, &sz))
+		if key != nil {
+			if tr, err = newDecryptingReader(tr, key); err != nil {
+				return rs, fmt.Errorf("snapshot %q entry %q: %v", r.Name(), entry, err)
+			}
+		}
 
 		// resist the temptation of using archive/tar unless it's proven
 		// that calling out to tar has issues -- there are a lot of
@@ -354,6 +395,25 @@ func (r *Reader) Restore(ctx context.Context, current snap.Revision, usernames [
 				r.Name(), entry, expectedHash, actualHash)
 		}
 
+		if sel != nil {
+			// only the selected files are moved into place, with the
+			// same renaming of the revision directory as below
+			toRevdir := revdir
+			if curdir != "" {
+				toRevdir = curdir
+			}
+			n, err := sel.moveMatching(rs, tempdir, parent, revdir, toRevdir, uid, gid)
+			if err != nil {
+				return rs, err
+			}
+			if n == 0 {
+				logf("No data matching %s in entry %q of snapshot %q.", strutil.Quoted(sel.patterns), entry, r.Name())
+			}
+			sz.Reset()
+			hasher.Reset()
+			continue
+		}
+
 		if curdir != "" && curdir != revdir {
 			// rename it in tempdir
 			// this is where we assume the current revision can read the snapshot revision's data
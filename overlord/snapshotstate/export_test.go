@@ -28,6 +28,7 @@ import (
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapshotstate/sink"
 	"github.com/snapcore/snapd/overlord/snapstate"
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
@@ -45,9 +46,11 @@ var (
 	CleanupRestore             = cleanupRestore
 	DoCheck                    = doCheck
 	DoForget                   = doForget
+	DoPush                     = doPush
 	SaveExpiration             = saveExpiration
 	ExpiredSnapshotSets        = expiredSnapshotSets
 	RemoveSnapshotState        = removeSnapshotState
+	CachedPassphrases          = cachedPassphrases
 
 	SetSnapshotOpInProgress = setSnapshotOpInProgress
 
@@ -75,6 +78,38 @@ func MockOsRemove(f func(string) error) (restore func()) {
 	}
 }
 
+func MockBackendCollectChunks(f func(context.Context) error) (restore func()) {
+	old := backendCollectChunks
+	backendCollectChunks = f
+	return func() {
+		backendCollectChunks = old
+	}
+}
+
+func MockBackendDeviceKey(f func() (*backend.Key, error)) (restore func()) {
+	old := backendDeviceKey
+	backendDeviceKey = f
+	return func() {
+		backendDeviceKey = old
+	}
+}
+
+func MockBackendPassphraseKey(f func(string) (*backend.Key, error)) (restore func()) {
+	old := backendPassphraseKey
+	backendPassphraseKey = f
+	return func() {
+		backendPassphraseKey = old
+	}
+}
+
+func MockBackendUnlock(f func(*backend.Reader, string) error) (restore func()) {
+	old := backendUnlock
+	backendUnlock = f
+	return func() {
+		backendUnlock = old
+	}
+}
+
 func MockSnapstateAll(f func(*state.State) (map[string]*snapstate.SnapState, error)) (restore func()) {
 	old := snapstateAll
 	snapstateAll = f
@@ -131,6 +166,22 @@ func MockBackendRestore(f func(*backend.Reader, context.Context, snap.Revision,
 	}
 }
 
+func MockBackendRestorePaths(f func(*backend.Reader, context.Context, snap.Revision, []string, []string, string, backend.Logf, *dirs.SnapDirOptions) (*backend.RestoreState, error)) (restore func()) {
+	old := backendRestorePaths
+	backendRestorePaths = f
+	return func() {
+		backendRestorePaths = old
+	}
+}
+
+func MockBackendContents(f func(*backend.Reader, context.Context, []string) ([]client.SnapshotFile, error)) (restore func()) {
+	old := backendContents
+	backendContents = f
+	return func() {
+		backendContents = old
+	}
+}
+
 func MockBackendCheck(f func(*backend.Reader, context.Context, []string) error) (restore func()) {
 	old := backendCheck
 	backendCheck = f
@@ -215,3 +266,23 @@ func MockGetSnapDirOptions(f func(*state.State, string) (*dirs.SnapDirOptions, e
 		getSnapDirOpts = old
 	}
 }
+
+func MockTimeNow(f func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = f
+	return func() {
+		timeNow = old
+	}
+}
+
+func SetsToForget(st *state.State, keepLast, keepDaily, keepWeekly int) (map[uint64]bool, error) {
+	return setsToForget(st, retentionPolicy{keepLast: keepLast, keepDaily: keepDaily, keepWeekly: keepWeekly})
+}
+
+func MockNewSink(f func(string, *sink.Credentials) (sink.Sink, error)) (restore func()) {
+	old := newSink
+	newSink = f
+	return func() {
+		newSink = old
+	}
+}
@@ -0,0 +1,291 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/osutil"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate/sink"
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+var newSink = sink.New
+
+// ErrNoSink is returned when pushing snapshot sets without a sink being
+// configured.
+var ErrNoSink = errors.New("no snapshot sink configured (see snapshots.export.target)")
+
+// PushRetryPolicy is the retry policy of the tasks pushing snapshot sets to
+// sinks; as uploads are resumed, retrying doesn't start over.
+var PushRetryPolicy = state.RetryPolicy{
+	MaxAttempts: 10,
+	Backoff:     time.Minute,
+	MaxBackoff:  30 * time.Minute,
+	Jitter:      0.2,
+	RetryOn:     sink.IsTransient,
+}
+
+// pushState is the state of the push of a snapshot set to a sink, kept so
+// that it can be resumed.
+type pushState struct {
+	// Name is the name of the export in the sink
+	Name string `json:"name"`
+	// Date is the date of the export, so that it's the same when resumed
+	Date time.Time `json:"date"`
+	// Size and SHA3_384 are those of the export, once pushed and
+	// verified
+	Size     int64  `json:"size,omitempty"`
+	SHA3_384 string `json:"sha3-384,omitempty"`
+}
+
+// s3SecretFile returns the file the secret access key of S3 sinks is kept
+// in, readable only by root and outside of the state, so that it's neither
+// in the configuration nor in the data of the tasks setting it.
+func s3SecretFile() string {
+	return filepath.Join(dirs.SnapDeviceDir, "snapshots-export-s3-secret")
+}
+
+// SetS3SecretAccessKey sets the secret access key used with S3 sinks, or
+// removes it if empty.
+func SetS3SecretAccessKey(secret string) error {
+	if secret == "" {
+		if err := os.Remove(s3SecretFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dirs.SnapDeviceDir, 0755); err != nil {
+		return err
+	}
+	return osutil.AtomicWriteFile(s3SecretFile(), []byte(secret), 0600, 0)
+}
+
+// configuredSink returns the sink snapshot sets are pushed to, or nil if
+// none is configured.
+// The state needs to be locked by the caller.
+func configuredSink(st *state.State) (sink.Sink, error) {
+	tr := config.NewTransaction(st)
+	var target string
+	if err := tr.Get("core", "snapshots.export.target", &target); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+	if target == "" {
+		return nil, nil
+	}
+
+	var creds sink.Credentials
+	for _, opt := range []struct {
+		key string
+		val *string
+	}{
+		{"snapshots.export.s3.access-key-id", &creds.AccessKeyID},
+		{"snapshots.export.s3.region", &creds.Region},
+	} {
+		if err := tr.Get("core", opt.key, opt.val); err != nil && !config.IsNoOption(err) {
+			return nil, err
+		}
+	}
+	secret, err := os.ReadFile(s3SecretFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	creds.SecretAccessKey = string(secret)
+	return newSink(target, &creds)
+}
+
+// pushAfterScheduled returns whether scheduled snapshot sets are pushed to
+// the configured sink once saved.
+// The state needs to be locked by the caller.
+func pushAfterScheduled(st *state.State) (bool, error) {
+	var push interface{}
+	err := config.NewTransaction(st).Get("core", "snapshots.scheduled.push", &push)
+	if err != nil && !config.IsNoOption(err) {
+		return false, err
+	}
+	// the value is a string if set with "snap set --string"
+	return push == true || push == "true", nil
+}
+
+func newPushTask(st *state.State, setID uint64, s sink.Sink) *state.Task {
+	desc := fmt.Sprintf("Export snapshot set #%d to %s", setID, s)
+	task := st.NewTask("push-snapshot", desc)
+	task.Set("snapshot-setup", &snapshotSetup{SetID: setID})
+	return task
+}
+
+// Push creates a taskset for exporting the snapshot set to the configured
+// sink.
+// Note that the state must be locked by the caller.
+func Push(st *state.State, setID uint64) (snapsFound []string, ts *state.TaskSet, err error) {
+	// push needs to conflict with forget
+	if err := checkSnapshotConflict(st, setID, "forget-snapshot"); err != nil {
+		return nil, nil, err
+	}
+
+	summaries, err := snapSummariesInSnapshotSet(setID, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := configuredSink(st)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s == nil {
+		return nil, nil, ErrNoSink
+	}
+
+	return summaries.snapNames(), state.NewTaskSet(newPushTask(st, setID, s)), nil
+}
+
+func doPush(task *state.Task, tomb *tomb.Tomb) error {
+	st := task.State()
+	st.Lock()
+	defer st.Unlock()
+
+	var snapshot snapshotSetup
+	if err := task.Get("snapshot-setup", &snapshot); err != nil {
+		return taskGetErrMsg(task, err, "snapshot")
+	}
+	var push pushState
+	if err := task.Get("push-state", &push); err != nil {
+		if !errors.Is(err, state.ErrNoState) {
+			return taskGetErrMsg(task, err, "push")
+		}
+		push.Date = timeNow().UTC().Truncate(time.Second)
+		push.Name = fmt.Sprintf("snapshot-%d-%s.snapshot", snapshot.SetID, push.Date.Format("20060102T150405Z"))
+		task.Set("push-state", &push)
+	}
+
+	s, err := configuredSink(st)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return fmt.Errorf("cannot export snapshot set #%d: %v", snapshot.SetID, ErrNoSink)
+	}
+
+	ctx := tomb.Context(nil)
+	st.Unlock()
+	size, sum, err := pushExport(ctx, snapshot.SetID, push, s)
+	st.Lock()
+	if err != nil {
+		return err
+	}
+
+	push.Size = size
+	push.SHA3_384 = sum
+	task.Set("push-state", &push)
+	task.Logf("Exported snapshot set #%d to %s as %q", snapshot.SetID, s, push.Name)
+	return nil
+}
+
+// skipWriter discards the first skip bytes written to it, and writes the
+// rest to w.
+type skipWriter struct {
+	skip int64
+	w    io.Writer
+}
+
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if sw.skip >= int64(n) {
+		sw.skip -= int64(n)
+		return n, nil
+	}
+	p = p[sw.skip:]
+	sw.skip = 0
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// pushExport streams the export of the snapshot set to the sink, resuming
+// where a previous attempt stopped, and verifies the data stored by the sink
+// against the export. Errors from the sink are wrapped, so that the transient
+// ones get retried.
+func pushExport(ctx context.Context, setID uint64, push pushState, s sink.Sink) (size int64, sum string, err error) {
+	se, err := backendNewSnapshotExport(ctx, setID)
+	if err != nil {
+		return 0, "", err
+	}
+	defer se.Close()
+	se.SetDate(push.Date)
+
+	stored, complete, err := s.Stored(ctx, push.Name)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot get the state of %q on %s: %w", push.Name, s, err)
+	}
+
+	hasher := crypto.SHA3_384.New()
+	var sizer osutil.Sizer
+	if complete {
+		// only verify what's there
+		if err := se.StreamTo(io.MultiWriter(hasher, &sizer)); err != nil {
+			return 0, "", fmt.Errorf("cannot export snapshot set #%d: %v", setID, err)
+		}
+	} else {
+		pr, pw := io.Pipe()
+		streamed := make(chan error, 1)
+		go func() {
+			err := se.StreamTo(io.MultiWriter(hasher, &sizer, &skipWriter{skip: stored, w: pw}))
+			pw.CloseWithError(err)
+			streamed <- err
+		}()
+		err := s.Append(ctx, push.Name, stored, pr)
+		// unblock the export if the sink gave up early
+		pr.CloseWithError(fmt.Errorf("export to snapshot sink interrupted"))
+		if streamErr := <-streamed; streamErr != nil && err == nil {
+			return 0, "", fmt.Errorf("cannot export snapshot set #%d: %v", setID, streamErr)
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("cannot upload %q to %s: %w", push.Name, s, err)
+		}
+		if sizer.Size() < stored {
+			return 0, "", fmt.Errorf("cannot resume upload of %q to %s: more data is stored than exported", push.Name, s)
+		}
+		if err := s.Finish(ctx, push.Name); err != nil {
+			return 0, "", fmt.Errorf("cannot complete upload of %q to %s: %w", push.Name, s, err)
+		}
+	}
+
+	sum = fmt.Sprintf("%x", hasher.Sum(nil))
+	storedSum, err := sink.Hash(ctx, s, push.Name)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot verify %q on %s: %w", push.Name, s, err)
+	}
+	if storedSum != sum {
+		return 0, "", fmt.Errorf("cannot verify %q on %s: stored data has hash %s, expected %s", push.Name, s, storedSum, sum)
+	}
+	return sizer.Size(), sum, nil
+}
@@ -0,0 +1,363 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate_test
+
+import (
+	"archive/zip"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapshotstate/sink"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// writeTestSnapshot writes a minimal valid snapshot of the snap in the set.
+func writeTestSnapshot(c *check.C, setID uint64, snapName string) {
+	f, err := os.Create(filepath.Join(dirs.SnapshotsDir, fmt.Sprintf("%d_%s_1.0_1.zip", setID, snapName)))
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	zipW := zip.NewWriter(f)
+
+	archive, err := zipW.Create("archive.tgz")
+	c.Assert(err, check.IsNil)
+	hasher := crypto.SHA3_384.New()
+	_, err = io.MultiWriter(archive, hasher).Write([]byte(snapName))
+	c.Assert(err, check.IsNil)
+
+	meta, err := zipW.Create("meta.json")
+	c.Assert(err, check.IsNil)
+	snapshot := &client.Snapshot{
+		SetID:    setID,
+		Snap:     snapName,
+		Revision: snap.R(1),
+		Version:  "1.0",
+		Time:     time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC),
+		SHA3_384: map[string]string{"archive.tgz": fmt.Sprintf("%x", hasher.Sum(nil))},
+		Size:     int64(len(snapName)),
+	}
+	hasher = crypto.SHA3_384.New()
+	c.Assert(json.NewEncoder(io.MultiWriter(meta, hasher)).Encode(snapshot), check.IsNil)
+
+	metaHash, err := zipW.Create("meta.sha3_384")
+	c.Assert(err, check.IsNil)
+	fmt.Fprintf(metaHash, "%x\n", hasher.Sum(nil))
+	c.Assert(zipW.Close(), check.IsNil)
+}
+
+func (snapshotSuite) TestPushNoSink(c *check.C) {
+	writeTestSnapshot(c, 42, "foo")
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	_, _, err := snapshotstate.Push(st, 42)
+	c.Check(err, check.Equals, snapshotstate.ErrNoSink)
+}
+
+func (snapshotSuite) TestPushNotFound(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.export.target": c.MkDir(),
+	})
+
+	_, _, err := snapshotstate.Push(st, 42)
+	c.Check(err, check.Equals, client.ErrSnapshotSetNotFound)
+}
+
+func (snapshotSuite) TestPushConflictsWithForget(c *check.C) {
+	writeTestSnapshot(c, 42, "foo")
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.export.target": c.MkDir(),
+	})
+	chg := st.NewChange("forget-snapshot", "...")
+	tsk := st.NewTask("forget-snapshot", "...")
+	tsk.SetStatus(state.DoingStatus)
+	tsk.Set("snapshot-setup", map[string]int{"set-id": 42})
+	chg.AddTask(tsk)
+
+	_, _, err := snapshotstate.Push(st, 42)
+	c.Check(err, check.ErrorMatches, `cannot operate on snapshot set #42 while change "1" is in progress`)
+}
+
+func (snapshotSuite) TestSetS3SecretAccessKey(c *check.C) {
+	secretFile := filepath.Join(dirs.SnapDeviceDir, "snapshots-export-s3-secret")
+	c.Assert(snapshotstate.SetS3SecretAccessKey("sekrit"), check.IsNil)
+	c.Check(secretFile, testutil.FileEquals, "sekrit")
+	fi, err := os.Stat(secretFile)
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Mode().Perm(), check.Equals, os.FileMode(0600))
+
+	c.Assert(snapshotstate.SetS3SecretAccessKey(""), check.IsNil)
+	c.Check(secretFile, testutil.FileAbsent)
+	// removing it again is fine
+	c.Assert(snapshotstate.SetS3SecretAccessKey(""), check.IsNil)
+}
+
+func (snapshotSuite) TestPush(c *check.C) {
+	writeTestSnapshot(c, 42, "foo")
+	writeTestSnapshot(c, 42, "bar")
+
+	var creds *sink.Credentials
+	defer snapshotstate.MockNewSink(func(target string, cr *sink.Credentials) (sink.Sink, error) {
+		c.Check(target, check.Equals, "s3://s3.example.com/bucket")
+		creds = cr
+		return sink.New(target, cr)
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.export.target":           "s3://s3.example.com/bucket",
+		"snapshots.export.s3.access-key-id": "key-id",
+	})
+	c.Assert(snapshotstate.SetS3SecretAccessKey("sekrit"), check.IsNil)
+
+	snapNames, ts, err := snapshotstate.Push(st, 42)
+	c.Assert(err, check.IsNil)
+	c.Check(snapNames, check.DeepEquals, []string{"bar", "foo"})
+	c.Check(creds, check.DeepEquals, &sink.Credentials{AccessKeyID: "key-id", SecretAccessKey: "sekrit"})
+	tasks := ts.Tasks()
+	c.Assert(tasks, check.HasLen, 1)
+	c.Check(tasks[0].Kind(), check.Equals, "push-snapshot")
+	c.Check(tasks[0].Summary(), check.Equals, `Export snapshot set #42 to S3 bucket "bucket" at s3.example.com`)
+	var snapshot map[string]interface{}
+	c.Assert(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot, check.DeepEquals, map[string]interface{}{
+		"set-id":  42.,
+		"snap":    "",
+		"current": "unset",
+	})
+}
+
+// failingSink fails appending after failAfter bytes, and corrupts the data
+// read back if corrupt is set.
+type failingSink struct {
+	sink.Sink
+	failAfter int64
+	corrupt   bool
+}
+
+var errSinkGone = errors.New("sink went away")
+
+func (s *failingSink) Append(ctx context.Context, name string, offset int64, r io.Reader) error {
+	if s.failAfter < 0 {
+		return s.Sink.Append(ctx, name, offset, r)
+	}
+	if err := s.Sink.Append(ctx, name, offset, io.LimitReader(r, s.failAfter)); err != nil {
+		return err
+	}
+	return errSinkGone
+}
+
+func (s *failingSink) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if s.corrupt {
+		return io.NopCloser(strings.NewReader("corrupted")), nil
+	}
+	return s.Sink.Open(ctx, name)
+}
+
+func (snapshotSuite) setupPush(c *check.C, target sink.Sink) (*state.State, *state.Task) {
+	writeTestSnapshot(c, 42, "foo")
+
+	defer snapshotstate.MockNewSink(func(string, *sink.Credentials) (sink.Sink, error) {
+		return target, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.export.target": "/srv/backups",
+	})
+	_, ts, err := snapshotstate.Push(st, 42)
+	c.Assert(err, check.IsNil)
+	return st, ts.Tasks()[0]
+}
+
+func (s snapshotSuite) TestDoPush(c *check.C) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 5, time.UTC)
+	defer snapshotstate.MockTimeNow(func() time.Time { return now })()
+
+	dir := c.MkDir()
+	dirSink, err := sink.New(dir, nil)
+	c.Assert(err, check.IsNil)
+	fs := &failingSink{Sink: dirSink, failAfter: 1000}
+	st, task := s.setupPush(c, fs)
+	defer snapshotstate.MockNewSink(func(string, *sink.Credentials) (sink.Sink, error) {
+		return fs, nil
+	})()
+
+	// the first attempt is interrupted
+	err = snapshotstate.DoPush(task, &tomb.Tomb{})
+	c.Assert(err, check.ErrorMatches, `cannot upload "snapshot-42-20240506T120000Z.snapshot" to directory ".*": sink went away`)
+	c.Check(errors.Is(err, errSinkGone), check.Equals, true)
+	fi, err := os.Stat(filepath.Join(dir, "snapshot-42-20240506T120000Z.snapshot.part"))
+	c.Assert(err, check.IsNil)
+	c.Check(fi.Size(), check.Equals, int64(1000))
+
+	// the retry resumes it, and the same export is pushed even if it's
+	// later
+	now = now.Add(time.Hour)
+	fs.failAfter = -1
+	c.Assert(snapshotstate.DoPush(task, &tomb.Tomb{}), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	var push map[string]interface{}
+	c.Assert(task.Get("push-state", &push), check.IsNil)
+	c.Check(push["name"], check.Equals, "snapshot-42-20240506T120000Z.snapshot")
+	c.Check(push["date"], check.Equals, "2024-05-06T12:00:00Z")
+	c.Check(task.Log(), check.HasLen, 1)
+	c.Check(task.Log()[0], check.Matches, `.* Exported snapshot set #42 to directory ".*" as "snapshot-42-20240506T120000Z.snapshot"`)
+
+	// the pushed data is a complete export that can be imported back
+	exported := filepath.Join(dir, "snapshot-42-20240506T120000Z.snapshot")
+	fi, err = os.Stat(exported)
+	c.Assert(err, check.IsNil)
+	c.Check(push["size"], check.Equals, float64(fi.Size()))
+	sum, err := sink.Hash(context.Background(), dirSink, "snapshot-42-20240506T120000Z.snapshot")
+	c.Assert(err, check.IsNil)
+	c.Check(push["sha3-384"], check.Equals, sum)
+
+	// the export streamed in one go is the same as the resumed one
+	se, err := backend.NewSnapshotExport(context.Background(), 42)
+	c.Assert(err, check.IsNil)
+	defer se.Close()
+	se.SetDate(time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC))
+	h := crypto.SHA3_384.New()
+	c.Assert(se.StreamTo(h), check.IsNil)
+	c.Check(fmt.Sprintf("%x", h.Sum(nil)), check.Equals, sum)
+}
+
+func (s snapshotSuite) TestDoPushAlreadyComplete(c *check.C) {
+	dir := c.MkDir()
+	dirSink, err := sink.New(dir, nil)
+	c.Assert(err, check.IsNil)
+	fs := &failingSink{Sink: dirSink, failAfter: -1}
+	_, task := s.setupPush(c, fs)
+	defer snapshotstate.MockNewSink(func(string, *sink.Credentials) (sink.Sink, error) {
+		return fs, nil
+	})()
+
+	c.Assert(snapshotstate.DoPush(task, &tomb.Tomb{}), check.IsNil)
+
+	// nothing is uploaded again
+	fs.failAfter = 0
+	c.Assert(snapshotstate.DoPush(task, &tomb.Tomb{}), check.IsNil)
+}
+
+func (s snapshotSuite) TestDoPushVerifyFails(c *check.C) {
+	dirSink, err := sink.New(c.MkDir(), nil)
+	c.Assert(err, check.IsNil)
+	fs := &failingSink{Sink: dirSink, failAfter: -1, corrupt: true}
+	_, task := s.setupPush(c, fs)
+	defer snapshotstate.MockNewSink(func(string, *sink.Credentials) (sink.Sink, error) {
+		return fs, nil
+	})()
+
+	err = snapshotstate.DoPush(task, &tomb.Tomb{})
+	c.Check(err, check.ErrorMatches, `cannot verify "snapshot-42-.*.snapshot" on directory ".*": stored data has hash [0-9a-f]+, expected [0-9a-f]+`)
+}
+
+func (s snapshotSuite) TestDoPushNoSink(c *check.C) {
+	dirSink, err := sink.New(c.MkDir(), nil)
+	c.Assert(err, check.IsNil)
+	st, task := s.setupPush(c, dirSink)
+
+	st.Lock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.export.target": "",
+	})
+	st.Unlock()
+
+	err = snapshotstate.DoPush(task, &tomb.Tomb{})
+	c.Check(err, check.ErrorMatches, `cannot export snapshot set #42: no snapshot sink configured \(see snapshots.export.target\)`)
+}
+
+func (snapshotSuite) TestEnsureScheduledSnapshotsPush(c *check.C) {
+	defer snapshotstate.MockSnapstateCheckChangeConflictMany(func(*state.State, []string, string) error {
+		return nil
+	})()
+
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	st.Lock()
+	snapstate.Set(st, "foo", &snapstate.SnapState{
+		Active: true,
+		Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{
+			{RealName: "foo", Revision: snap.R(1)},
+		}),
+		Current: snap.R(1),
+	})
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.scheduled.timer": "00:00-24:00",
+		"snapshots.scheduled.push":  true,
+		"snapshots.export.target":   "/srv/backups",
+	})
+	st.Set("last-scheduled-snapshot", time.Now().AddDate(0, 0, -3))
+	st.Unlock()
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	chgs := st.Changes()
+	c.Assert(chgs, check.HasLen, 1)
+	c.Check(chgs[0].Summary(), check.Equals, `Save and export scheduled snapshot set #1 of "foo"`)
+	tasks := chgs[0].Tasks()
+	c.Assert(tasks, check.HasLen, 2)
+	save, push := tasks[0], tasks[1]
+	c.Check(save.Kind(), check.Equals, "save-snapshot")
+	c.Check(push.Kind(), check.Equals, "push-snapshot")
+	c.Check(push.Summary(), check.Equals, `Export snapshot set #1 to directory "/srv/backups"`)
+	c.Check(push.WaitTasks(), check.DeepEquals, []*state.Task{save})
+	c.Check(save.Lanes(), check.DeepEquals, []int{0})
+	c.Check(push.Lanes(), check.HasLen, 1)
+	c.Check(push.Lanes()[0], check.Not(check.Equals), 0)
+}
@@ -0,0 +1,369 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/strutil"
+	"github.com/snapcore/snapd/timeutil"
+)
+
+var (
+	timeNow = time.Now
+
+	// maximum time between scheduled snapshots, whatever the schedule
+	maxScheduledSnapshotInterval = time.Hour * 24 * 62
+
+	// number of scheduled snapshot sets kept if no retention is configured
+	defaultScheduledSnapshotsKeepLast = 7
+)
+
+// ScheduledSnapshotChangeKind is the kind of the changes saving scheduled
+// snapshot sets.
+const ScheduledSnapshotChangeKind = "scheduled-snapshot"
+
+// snapshotSchedule is the configuration of scheduled snapshots.
+type snapshotSchedule struct {
+	timer     string
+	schedule  []*timeutil.Schedule
+	snaps     []string
+	retention retentionPolicy
+}
+
+// retentionPolicy says which scheduled snapshot sets to keep: the keepLast
+// most recent ones, the most recent one of each of the keepDaily last days
+// and the most recent one of each of the keepWeekly last weeks.
+type retentionPolicy struct {
+	keepLast   int
+	keepDaily  int
+	keepWeekly int
+}
+
+// scheduledSnapshotsConfig returns the configuration of scheduled snapshots,
+// or nil if they are disabled.
+func scheduledSnapshotsConfig(st *state.State) (*snapshotSchedule, error) {
+	tr := config.NewTransaction(st)
+
+	var timer string
+	if err := tr.Get("core", "snapshots.scheduled.timer", &timer); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+	if timer == "" {
+		return nil, nil
+	}
+	schedule, err := timeutil.ParseSchedule(timer)
+	if err != nil {
+		return nil, fmt.Errorf("snapshots.scheduled.timer cannot be parsed: %v", err)
+	}
+
+	var snaps string
+	if err := tr.Get("core", "snapshots.scheduled.snaps", &snaps); err != nil && !config.IsNoOption(err) {
+		return nil, err
+	}
+
+	sched := &snapshotSchedule{
+		timer:    timer,
+		schedule: schedule,
+		snaps:    strutil.CommaSeparatedList(snaps),
+	}
+	for _, opt := range []struct {
+		name string
+		val  *int
+	}{
+		{"keep-last", &sched.retention.keepLast},
+		{"keep-daily", &sched.retention.keepDaily},
+		{"keep-weekly", &sched.retention.keepWeekly},
+	} {
+		if *opt.val, err = retentionOption(tr, opt.name); err != nil {
+			return nil, err
+		}
+	}
+	if sched.retention == (retentionPolicy{}) {
+		sched.retention.keepLast = defaultScheduledSnapshotsKeepLast
+	}
+
+	return sched, nil
+}
+
+func retentionOption(tr *config.Transaction, name string) (int, error) {
+	key := "snapshots.scheduled.retention." + name
+	// numbers are stored as json.Number, but "snap set" can leave a
+	// string too
+	var val interface{}
+	if err := tr.Get("core", key, &val); err != nil {
+		if config.IsNoOption(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var str string
+	switch v := val.(type) {
+	case json.Number:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return 0, fmt.Errorf("%s has unexpected type %T", key, val)
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, not %q", key, str)
+	}
+	return n, nil
+}
+
+// lastScheduledSnapshot returns when the last scheduled snapshot set was
+// taken.
+func lastScheduledSnapshot(st *state.State) (time.Time, error) {
+	var last time.Time
+	if err := st.Get("last-scheduled-snapshot", &last); err != nil && !errors.Is(err, state.ErrNoState) {
+		return time.Time{}, err
+	}
+	return last, nil
+}
+
+func scheduledSnapshotInProgress(st *state.State) bool {
+	for _, chg := range st.Changes() {
+		if chg.Kind() == ScheduledSnapshotChangeKind && !chg.IsReady() {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureScheduledSnapshots saves a snapshot set when the schedule says so,
+// and forgets the scheduled snapshot sets the retention policy doesn't keep.
+func (mgr *SnapshotManager) ensureScheduledSnapshots() error {
+	st := mgr.state
+	st.Lock()
+	defer st.Unlock()
+
+	sched, err := scheduledSnapshotsConfig(st)
+	if err != nil {
+		return err
+	}
+	if sched == nil {
+		mgr.nextScheduledSnapshot = time.Time{}
+		return nil
+	}
+
+	if scheduledSnapshotInProgress(st) {
+		return nil
+	}
+
+	if err := mgr.applyRetention(sched.retention); err != nil {
+		return err
+	}
+
+	now := timeNow()
+	if mgr.nextScheduledSnapshot.IsZero() || mgr.lastSnapshotSchedule != sched.timer {
+		last, err := lastScheduledSnapshot(st)
+		if err != nil {
+			return err
+		}
+		if last.IsZero() {
+			// count from when the schedule was first seen
+			last = now
+			st.Set("last-scheduled-snapshot", last)
+		}
+		mgr.nextScheduledSnapshot = now.Add(timeutil.Next(sched.schedule, last, maxScheduledSnapshotInterval))
+		mgr.lastSnapshotSchedule = sched.timer
+		logger.Debugf("Next scheduled snapshot set for %s.", mgr.nextScheduledSnapshot.Format(time.RFC3339))
+	}
+	if mgr.nextScheduledSnapshot.After(now) {
+		return nil
+	}
+
+	// whatever happens, wait for the next time in the schedule
+	st.Set("last-scheduled-snapshot", now)
+	mgr.nextScheduledSnapshot = time.Time{}
+
+	snaps, err := installedScheduledSnaps(st, sched.snaps)
+	if err != nil {
+		return err
+	}
+	if len(sched.snaps) > 0 && len(snaps) == 0 {
+		logger.Noticef("Cannot save scheduled snapshot: none of the snaps to snapshot are installed.")
+		return nil
+	}
+
+	encryption, err := configuredEncryption(st)
+	if err != nil {
+		return err
+	}
+	setID, snaps, ts, err := save(st, snaps, nil, nil, encryption, true)
+	if err != nil {
+		var conflictErr *snapstate.ChangeConflictError
+		if errors.As(err, &conflictErr) {
+			logger.Noticef("Cannot save scheduled snapshot: %v", err)
+			return nil
+		}
+		return err
+	}
+
+	summary := fmt.Sprintf("Save scheduled snapshot set #%d of %s", setID, strutil.Quoted(snaps))
+	push, err := pushAfterScheduled(st)
+	if err != nil {
+		return err
+	}
+	if push {
+		s, err := configuredSink(st)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			logger.Noticef("Not exporting scheduled snapshot set #%d: snapshots.export.target is not set.", setID)
+		} else {
+			pushTask := newPushTask(st, setID, s)
+			pushTask.WaitAll(ts)
+			// failing to export must not undo the saving
+			pushTask.JoinLane(st.NewLane())
+			ts.AddTask(pushTask)
+			summary = fmt.Sprintf("Save and export scheduled snapshot set #%d of %s", setID, strutil.Quoted(snaps))
+		}
+	}
+	chg := st.NewChange(ScheduledSnapshotChangeKind, summary)
+	chg.AddAll(ts)
+	chg.Set("snap-names", snaps)
+	chg.Set("api-data", map[string]interface{}{"snap-names": snaps})
+	st.EnsureBefore(0)
+
+	return nil
+}
+
+// installedScheduledSnaps returns the snaps to snapshot that are installed,
+// or nil if all of them should be.
+func installedScheduledSnaps(st *state.State, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	installed, err := snapstateAll(st)
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := installed[name]; !ok {
+			logger.Noticef("Not saving scheduled snapshot of snap %q: snap is not installed.", name)
+			continue
+		}
+		snaps = append(snaps, name)
+	}
+	return snaps, nil
+}
+
+// saveScheduled records in the state that the given snapshot set was saved
+// on schedule at the given time.
+// The state needs to be locked by the caller.
+func saveScheduled(st *state.State, setID uint64, tm time.Time) error {
+	return saveSnapshotState(st, setID, &snapshotState{ScheduledTime: &tm})
+}
+
+// setsToForget returns the scheduled snapshot sets from the state that the
+// retention policy doesn't keep.
+// The state needs to be locked by the caller.
+func setsToForget(st *state.State, policy retentionPolicy) (map[uint64]bool, error) {
+	var snapshots map[uint64]*snapshotState
+	if err := st.Get("snapshots", &snapshots); err != nil {
+		if errors.Is(err, state.ErrNoState) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type scheduledSet struct {
+		id uint64
+		tm time.Time
+	}
+	var sets []scheduledSet
+	for setID, snapshotSet := range snapshots {
+		if snapshotSet.ScheduledTime != nil {
+			sets = append(sets, scheduledSet{id: setID, tm: *snapshotSet.ScheduledTime})
+		}
+	}
+	// most recent first
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].tm.Equal(sets[j].tm) {
+			return sets[i].id > sets[j].id
+		}
+		return sets[i].tm.After(sets[j].tm)
+	})
+
+	keep := make(map[uint64]bool, len(sets))
+	for i := 0; i < len(sets) && i < policy.keepLast; i++ {
+		keep[sets[i].id] = true
+	}
+	keepOnePer := func(n int, period func(time.Time) string) {
+		seen := make(map[string]bool, n)
+		for _, set := range sets {
+			if len(seen) == n {
+				break
+			}
+			p := period(set.tm)
+			if !seen[p] {
+				seen[p] = true
+				keep[set.id] = true
+			}
+		}
+	}
+	keepOnePer(policy.keepDaily, func(tm time.Time) string {
+		return tm.Local().Format("2006-01-02")
+	})
+	keepOnePer(policy.keepWeekly, func(tm time.Time) string {
+		year, week := tm.Local().ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+
+	forget := make(map[uint64]bool)
+	for _, set := range sets {
+		if !keep[set.id] {
+			forget[set.id] = true
+		}
+	}
+	return forget, nil
+}
+
+// applyRetention forgets the scheduled snapshot sets that the retention
+// policy doesn't keep.
+// The state needs to be locked by the caller.
+func (mgr *SnapshotManager) applyRetention(policy retentionPolicy) error {
+	sets, err := setsToForget(mgr.state, policy)
+	if err != nil {
+		return fmt.Errorf("internal error: cannot determine scheduled snapshots to forget: %v", err)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	if err := mgr.forgetSnapshotSets(sets); err != nil {
+		return fmt.Errorf("cannot forget scheduled snapshots: %v", err)
+	}
+	collectChunks()
+	return nil
+}
@@ -0,0 +1,324 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapshotstate_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/tomb.v2"
+
+	"github.com/snapcore/snapd/client"
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapshotstate"
+	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/testutil"
+	"github.com/snapcore/snapd/timeutil"
+)
+
+func setScheduledSnapshotsConfig(st *state.State, conf map[string]interface{}) {
+	tr := config.NewTransaction(st)
+	for k, v := range conf {
+		tr.Set("core", k, v)
+	}
+	tr.Commit()
+}
+
+func (snapshotSuite) TestEnsureScheduledSnapshotsDisabled(c *check.C) {
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 0)
+	var last time.Time
+	c.Check(st.Get("last-scheduled-snapshot", &last), testutil.ErrorIs, state.ErrNoState)
+}
+
+func (snapshotSuite) TestEnsureScheduledSnapshotsFirstTime(c *check.C) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	defer snapshotstate.MockTimeNow(func() time.Time { return now })()
+	// the next time in the schedule is computed against the same clock
+	defer timeutil.MockTimeNow(func() time.Time { return now })()
+
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	st.Lock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.scheduled.timer": "03:00",
+	})
+	st.Unlock()
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	// the schedule starts from when it's first seen
+	c.Check(st.Changes(), check.HasLen, 0)
+	var last time.Time
+	c.Assert(st.Get("last-scheduled-snapshot", &last), check.IsNil)
+	c.Check(last.Equal(now), check.Equals, true)
+}
+
+func (snapshotSuite) TestEnsureScheduledSnapshots(c *check.C) {
+	defer snapshotstate.MockSnapstateCheckChangeConflictMany(func(_ *state.State, names []string, _ string) error {
+		c.Check(names, check.DeepEquals, []string{"foo"})
+		return nil
+	})()
+
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	st.Lock()
+	for _, name := range []string{"foo", "bar"} {
+		snapstate.Set(st, name, &snapstate.SnapState{
+			Active: true,
+			Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{
+				{RealName: name, Revision: snap.R(1)},
+			}),
+			Current: snap.R(1),
+		})
+	}
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.scheduled.timer": "00:00-24:00",
+		"snapshots.scheduled.snaps": "foo,not-installed",
+	})
+	last := time.Now().AddDate(0, 0, -3)
+	st.Set("last-scheduled-snapshot", last)
+	st.Unlock()
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	chgs := st.Changes()
+	c.Assert(chgs, check.HasLen, 1)
+	chg := chgs[0]
+	c.Check(chg.Kind(), check.Equals, snapshotstate.ScheduledSnapshotChangeKind)
+	c.Check(chg.Summary(), check.Equals, `Save scheduled snapshot set #1 of "foo"`)
+	tasks := chg.Tasks()
+	c.Assert(tasks, check.HasLen, 1)
+	c.Check(tasks[0].Kind(), check.Equals, "save-snapshot")
+	var snapshot map[string]interface{}
+	c.Assert(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot, check.DeepEquals, map[string]interface{}{
+		"set-id":    1.,
+		"snap":      "foo",
+		"current":   "unset",
+		"scheduled": true,
+	})
+	var newLast time.Time
+	c.Assert(st.Get("last-scheduled-snapshot", &newLast), check.IsNil)
+	c.Check(newLast.After(last), check.Equals, true)
+	st.Unlock()
+
+	// nothing new while the scheduled snapshot is in progress
+	c.Assert(mgr.Ensure(), check.IsNil)
+	st.Lock()
+	defer st.Unlock()
+	c.Check(st.Changes(), check.HasLen, 1)
+}
+
+func (snapshotSuite) TestDoSaveScheduled(c *check.C) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	defer snapshotstate.MockTimeNow(func() time.Time { return now })()
+	defer snapshotstate.MockSnapstateCurrentInfo(func(_ *state.State, snapname string) (*snap.Info, error) {
+		return &snap.Info{SideInfo: snap.SideInfo{RealName: snapname, Revision: snap.R(1)}}, nil
+	})()
+	defer snapshotstate.MockConfigGetSnapConfig(func(*state.State, string) (*json.RawMessage, error) {
+		return nil, nil
+	})()
+	defer snapshotstate.MockBackendSave(func(context.Context, uint64, *snap.Info, map[string]interface{}, []string, *snap.SnapshotOptions, *dirs.SnapDirOptions) (*client.Snapshot, error) {
+		return nil, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	task := st.NewTask("save-snapshot", "...")
+	task.Set("snapshot-setup", map[string]interface{}{
+		"set-id":    42,
+		"snap":      "a-snap",
+		"scheduled": true,
+	})
+	st.Unlock()
+
+	c.Assert(snapshotstate.DoSave(task, &tomb.Tomb{}), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	var snapshots map[uint64]interface{}
+	c.Assert(st.Get("snapshots", &snapshots), check.IsNil)
+	c.Check(snapshots, check.DeepEquals, map[uint64]interface{}{
+		42: map[string]interface{}{
+			"expiry-time":    "0001-01-01T00:00:00Z",
+			"scheduled-time": "2024-05-06T12:00:00Z",
+		},
+	})
+
+	// scheduled snapshots don't expire
+	expired, err := snapshotstate.ExpiredSnapshotSets(st, now.AddDate(1, 0, 0))
+	c.Assert(err, check.IsNil)
+	c.Check(expired, check.HasLen, 0)
+}
+
+func (snapshotSuite) TestSetsToForget(c *check.C) {
+	oldLocal := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = oldLocal }()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	sets, err := snapshotstate.SetsToForget(st, 1, 0, 0)
+	c.Assert(err, check.IsNil)
+	c.Check(sets, check.HasLen, 0)
+
+	st.Set("snapshots", map[uint64]interface{}{
+		// week 14
+		1: map[string]interface{}{"scheduled-time": "2024-04-01T12:00:00Z"},
+		// week 15
+		2: map[string]interface{}{"scheduled-time": "2024-04-08T12:00:00Z"},
+		3: map[string]interface{}{"scheduled-time": "2024-04-14T12:00:00Z"},
+		// week 16
+		4: map[string]interface{}{"scheduled-time": "2024-04-15T10:00:00Z"},
+		5: map[string]interface{}{"scheduled-time": "2024-04-15T12:00:00Z"},
+		6: map[string]interface{}{"scheduled-time": "2024-04-16T12:00:00Z"},
+		// not scheduled
+		7: map[string]interface{}{"expiry-time": "2024-04-17T12:00:00Z"},
+	})
+
+	for _, t := range []struct {
+		keepLast, keepDaily, keepWeekly int
+		forget                          []uint64
+	}{
+		{1, 0, 0, []uint64{1, 2, 3, 4, 5}},
+		{10, 0, 0, nil},
+		{0, 2, 0, []uint64{1, 2, 3, 4}},
+		{0, 0, 3, []uint64{2, 4, 5}},
+		{2, 0, 2, []uint64{1, 2, 4}},
+		{0, 0, 0, []uint64{1, 2, 3, 4, 5, 6}},
+	} {
+		sets, err := snapshotstate.SetsToForget(st, t.keepLast, t.keepDaily, t.keepWeekly)
+		c.Assert(err, check.IsNil)
+		var forget []uint64
+		for setID := range sets {
+			forget = append(forget, setID)
+		}
+		sort.Slice(forget, func(i, j int) bool { return forget[i] < forget[j] })
+		c.Check(forget, check.DeepEquals, t.forget, check.Commentf("%+v", t))
+	}
+}
+
+func (snapshotSuite) TestEnsureScheduledSnapshotsRetention(c *check.C) {
+	dir := c.MkDir()
+	fakeIter := func(_ context.Context, f func(*backend.Reader) error) error {
+		for _, setID := range []uint64{1, 2, 3} {
+			for _, name := range []string{"foo", "bar"} {
+				shotfile, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d_%s.zip", setID, name)))
+				c.Assert(err, check.IsNil)
+				defer shotfile.Close()
+				c.Assert(f(&backend.Reader{
+					Snapshot: client.Snapshot{SetID: setID, Snap: name},
+					File:     shotfile,
+				}), check.IsNil)
+			}
+		}
+		return nil
+	}
+	defer snapshotstate.MockBackendIter(fakeIter)()
+	var removed []string
+	defer snapshotstate.MockOsRemove(func(name string) error {
+		removed = append(removed, filepath.Base(name))
+		return nil
+	})()
+
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+
+	st.Lock()
+	setScheduledSnapshotsConfig(st, map[string]interface{}{
+		"snapshots.scheduled.timer":               "03:00",
+		"snapshots.scheduled.retention.keep-last": 1,
+	})
+	st.Set("last-scheduled-snapshot", time.Now())
+	st.Set("snapshots", map[uint64]interface{}{
+		1: map[string]interface{}{"scheduled-time": "2024-04-01T12:00:00Z"},
+		2: map[string]interface{}{"scheduled-time": "2024-04-02T12:00:00Z"},
+		3: map[string]interface{}{"scheduled-time": "2024-04-03T12:00:00Z"},
+	})
+	st.Unlock()
+
+	c.Assert(mgr.Ensure(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	sort.Strings(removed)
+	c.Check(removed, check.DeepEquals, []string{"1_bar.zip", "1_foo.zip", "2_bar.zip", "2_foo.zip"})
+	var snapshots map[uint64]interface{}
+	c.Assert(st.Get("snapshots", &snapshots), check.IsNil)
+	c.Check(snapshots, check.HasLen, 1)
+	c.Check(snapshots[3], check.NotNil)
+	c.Check(st.Changes(), check.HasLen, 0)
+}
+
+func (snapshotSuite) TestListSetsScheduledFlag(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	st.Set("snapshots", map[uint64]interface{}{
+		1: map[string]interface{}{"scheduled-time": "2024-04-01T12:00:00Z"},
+	})
+
+	defer snapshotstate.MockBackendList(func(ctx context.Context, setID uint64, snapNames []string) ([]client.SnapshotSet, error) {
+		return []client.SnapshotSet{
+			{ID: 1, Snapshots: []*client.Snapshot{{Snap: "foo", SetID: 1}, {Snap: "bar", SetID: 1}}},
+			{ID: 2, Snapshots: []*client.Snapshot{{Snap: "foo", SetID: 2}}},
+		}, nil
+	})()
+
+	sets, err := snapshotstate.List(context.TODO(), st, 0, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(sets, check.HasLen, 2)
+	for _, sset := range sets {
+		for _, snapshot := range sset.Snapshots {
+			c.Check(snapshot.Scheduled, check.Equals, sset.ID == 1)
+			c.Check(snapshot.Auto, check.Equals, false)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/snapcore/snapd/osutil"
+)
+
+// dirSink stores the objects as files in a local directory, which can be a
+// mounted network filesystem. Incomplete objects have a .part suffix.
+type dirSink struct {
+	dir string
+}
+
+func newDirSink(dir string) *dirSink {
+	return &dirSink{dir: filepath.Clean(dir)}
+}
+
+func (s *dirSink) String() string {
+	return fmt.Sprintf("directory %q", s.dir)
+}
+
+func (s *dirSink) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *dirSink) partPath(name string) string {
+	return s.path(name) + ".part"
+}
+
+func (s *dirSink) Stored(ctx context.Context, name string) (size int64, complete bool, err error) {
+	if err := validName(name); err != nil {
+		return 0, false, err
+	}
+	if fi, err := os.Stat(s.path(name)); err == nil {
+		return fi.Size(), true, nil
+	} else if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+	fi, err := os.Stat(s.partPath(name))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return fi.Size(), false, nil
+}
+
+func (s *dirSink) Append(ctx context.Context, name string, offset int64, r io.Reader) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if !osutil.IsDirectory(s.dir) {
+		return fmt.Errorf("cannot use %s: not a directory", s)
+	}
+	f, err := os.OpenFile(s.partPath(name), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// drop whatever was written after the data known to be stored
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	// stop copying once the context is done
+	if _, err := io.Copy(io.MultiWriter(osutil.ContextWriter(ctx), f), r); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *dirSink) Finish(ctx context.Context, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if err := os.Rename(s.partPath(name), s.path(name)); err != nil {
+		return err
+	}
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (s *dirSink) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	return os.Open(s.path(name))
+}
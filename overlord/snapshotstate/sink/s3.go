@@ -0,0 +1,486 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/httputil"
+)
+
+var (
+	// size of the parts of multipart uploads; S3 needs all but the last
+	// one to be at least 5MiB
+	s3PartSize = 16 * 1024 * 1024
+
+	timeNow = time.Now
+)
+
+const defaultS3Region = "us-east-1"
+
+// s3Sink stores the objects in a bucket of an S3-compatible endpoint, using
+// path-style requests. Objects are uploaded in parts with multipart uploads,
+// which are resumed from their last complete part, unless they are smaller
+// than a part, as S3 cannot complete multipart uploads without parts; those
+// are uploaded with a single request instead.
+type s3Sink struct {
+	client   *http.Client
+	scheme   string
+	host     string
+	bucket   string
+	prefix   string
+	creds    Credentials
+	uploads  map[string]*s3Upload
+	partSize int
+}
+
+// s3Upload is an upload in progress; the multipart upload is only initiated
+// once a whole part has been buffered in pending.
+type s3Upload struct {
+	id      string
+	parts   []s3Part
+	pending []byte
+}
+
+func (u *s3Upload) size() (size int64) {
+	for _, part := range u.parts {
+		size += part.Size
+	}
+	return size + int64(len(u.pending))
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+	Size       int64  `xml:"Size,omitempty"`
+}
+
+func newS3Sink(u *url.URL, creds *Credentials) (*s3Sink, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("snapshot sink %q has no endpoint", u.Redacted())
+	}
+	path := strings.Trim(u.Path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("snapshot sink %q has no bucket", u.Redacted())
+	}
+	bucket, prefix, _ := strings.Cut(path, "/")
+
+	scheme := "https"
+	if u.Scheme == "s3+http" {
+		scheme = "http"
+	}
+	s := &s3Sink{
+		client:   httputil.NewHTTPClient(&httputil.ClientOptions{}),
+		scheme:   scheme,
+		host:     u.Host,
+		bucket:   bucket,
+		prefix:   prefix,
+		uploads:  make(map[string]*s3Upload),
+		partSize: s3PartSize,
+	}
+	if creds != nil {
+		s.creds = *creds
+	}
+	if s.creds.Region == "" {
+		s.creds.Region = defaultS3Region
+	}
+	return s, nil
+}
+
+func (s *s3Sink) String() string {
+	return fmt.Sprintf("S3 bucket %q at %s", s.bucket, s.host)
+}
+
+func (s *s3Sink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// s3Error is an error response from the endpoint.
+type s3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed: %s", http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("S3 request failed: %s: %s", e.Code, e.Message)
+}
+
+// IsTransient returns whether the error from a sink is the kind of network
+// or server error after which it's sensible to retry.
+func IsTransient(err error) bool {
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) {
+		return s3Err.StatusCode >= 500 || s3Err.StatusCode == http.StatusTooManyRequests
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		if httputil.ShouldRetryError(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// awsEscape escapes s as needed by AWS signatures, which is stricter than
+// what net/url does.
+func awsEscape(s string, escapeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !escapeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign signs the request with AWS signature version 4.
+func (s *s3Sink) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.creds.AccessKeyID == "" {
+		// anonymous access
+		return
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.creds.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.SecretAccessKey), date)
+	key = hmacSHA256(key, s.creds.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// do sends a signed request about the object with the given key, or about
+// the bucket if key is empty. Responses other than 2xx are turned into
+// errors, and the body of the response must be closed by the caller
+// otherwise.
+func (s *s3Sink) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+	rawURL := fmt.Sprintf("%s://%s%s", s.scheme, s.host, awsEscape(path, false))
+	if len(query) > 0 {
+		rawURL += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(payloadHash[:]), timeNow())
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode/100 != 2 {
+		defer rsp.Body.Close()
+		s3Err := &s3Error{StatusCode: rsp.StatusCode}
+		if method != "HEAD" {
+			// best effort
+			xml.NewDecoder(io.LimitReader(rsp.Body, 64*1024)).Decode(s3Err)
+		}
+		return nil, s3Err
+	}
+	return rsp, nil
+}
+
+// doXML sends a request like do, and decodes its XML response into v.
+func (s *s3Sink) doXML(ctx context.Context, method, key string, query url.Values, body []byte, v interface{}) error {
+	rsp, err := s.do(ctx, method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	// some errors come with a 200 status
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	var s3Err s3Error
+	if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+		s3Err.StatusCode = http.StatusInternalServerError
+		return &s3Err
+	}
+	if v == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("cannot decode S3 response: %v", err)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var s3Err *s3Error
+	return errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound
+}
+
+func (s *s3Sink) Stored(ctx context.Context, name string) (size int64, complete bool, err error) {
+	if err := validName(name); err != nil {
+		return 0, false, err
+	}
+	key := s.key(name)
+
+	rsp, err := s.do(ctx, "HEAD", key, nil, nil)
+	if err == nil {
+		rsp.Body.Close()
+		return rsp.ContentLength, true, nil
+	}
+	if !isNotFound(err) {
+		return 0, false, err
+	}
+
+	var uploads struct {
+		Uploads []struct {
+			Key      string `xml:"Key"`
+			UploadID string `xml:"UploadId"`
+		} `xml:"Upload"`
+	}
+	query := url.Values{"uploads": {""}, "prefix": {key}}
+	if err := s.doXML(ctx, "GET", "", query, nil, &uploads); err != nil {
+		return 0, false, err
+	}
+	var upload *s3Upload
+	for _, u := range uploads.Uploads {
+		if u.Key == key {
+			upload = &s3Upload{id: u.UploadID}
+			break
+		}
+	}
+	if upload == nil {
+		delete(s.uploads, name)
+		return 0, false, nil
+	}
+
+	var parts struct {
+		Parts []s3Part `xml:"Part"`
+	}
+	if err := s.doXML(ctx, "GET", key, url.Values{"uploadId": {upload.id}}, nil, &parts); err != nil {
+		return 0, false, err
+	}
+	sort.Slice(parts.Parts, func(i, j int) bool { return parts.Parts[i].PartNumber < parts.Parts[j].PartNumber })
+	// only resume from contiguous parts
+	for i, part := range parts.Parts {
+		if part.PartNumber != i+1 {
+			break
+		}
+		upload.parts = append(upload.parts, part)
+	}
+	s.uploads[name] = upload
+	return upload.size(), false, nil
+}
+
+func (s *s3Sink) Append(ctx context.Context, name string, offset int64, r io.Reader) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	key := s.key(name)
+
+	upload := s.uploads[name]
+	if upload == nil {
+		if offset != 0 {
+			return fmt.Errorf("internal error: cannot resume upload of %q to %s without Stored", name, s)
+		}
+		upload = &s3Upload{}
+		s.uploads[name] = upload
+	}
+	if offset != upload.size() {
+		return fmt.Errorf("internal error: cannot resume upload of %q to %s at %d, uploaded %d", name, s, offset, upload.size())
+	}
+	if upload.pending == nil {
+		upload.pending = make([]byte, 0, s.partSize)
+	}
+
+	for {
+		// the pending data is only ever a whole part here if uploading
+		// it failed before
+		n, err := io.ReadFull(r, upload.pending[len(upload.pending):s.partSize])
+		upload.pending = upload.pending[:len(upload.pending)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the last part is uploaded by Finish
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.uploadPart(ctx, key, upload); err != nil {
+			return err
+		}
+	}
+}
+
+// uploadPart uploads the pending data as the next part of the multipart
+// upload, initiating it first if needed.
+func (s *s3Sink) uploadPart(ctx context.Context, key string, upload *s3Upload) error {
+	if upload.id == "" {
+		var initiated struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := s.doXML(ctx, "POST", key, url.Values{"uploads": {""}}, nil, &initiated); err != nil {
+			return err
+		}
+		upload.id = initiated.UploadID
+	}
+
+	partNumber := len(upload.parts) + 1
+	query := url.Values{
+		"partNumber": {strconv.Itoa(partNumber)},
+		"uploadId":   {upload.id},
+	}
+	rsp, err := s.do(ctx, "PUT", key, query, upload.pending)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	upload.parts = append(upload.parts, s3Part{
+		PartNumber: partNumber,
+		ETag:       rsp.Header.Get("ETag"),
+		Size:       int64(len(upload.pending)),
+	})
+	upload.pending = upload.pending[:0]
+	return nil
+}
+
+func (s *s3Sink) Finish(ctx context.Context, name string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	upload := s.uploads[name]
+	if upload == nil {
+		return fmt.Errorf("internal error: no upload of %q to %s to finish", name, s)
+	}
+	key := s.key(name)
+
+	if upload.id == "" {
+		// smaller than a part, possibly empty
+		rsp, err := s.do(ctx, "PUT", key, nil, upload.pending)
+		if err != nil {
+			return err
+		}
+		rsp.Body.Close()
+		delete(s.uploads, name)
+		return nil
+	}
+	if len(upload.pending) > 0 {
+		if err := s.uploadPart(ctx, key, upload); err != nil {
+			return err
+		}
+	}
+
+	type completePart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	complete := struct {
+		XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+		Parts   []completePart `xml:"Part"`
+	}{}
+	for _, part := range upload.parts {
+		complete.Parts = append(complete.Parts, completePart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+	body, err := xml.Marshal(&complete)
+	if err != nil {
+		return err
+	}
+	if err := s.doXML(ctx, "POST", key, url.Values{"uploadId": {upload.id}}, body, nil); err != nil {
+		return err
+	}
+	delete(s.uploads, name)
+	return nil
+}
+
+func (s *s3Sink) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := validName(name); err != nil {
+		return nil, err
+	}
+	rsp, err := s.do(ctx, "GET", s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Body, nil
+}
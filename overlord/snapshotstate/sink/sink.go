@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package sink implements the targets snapshot sets can be exported to, so
+// that they're backed up off the device.
+package sink
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	// register SHA3 with crypto
+	_ "golang.org/x/crypto/sha3"
+)
+
+// A Sink stores exported snapshot sets. Objects are written to sinks
+// incrementally, so that interrupted uploads can be resumed.
+type Sink interface {
+	// Stored returns how much of the data of the named object is stored
+	// already, and whether the object is complete.
+	Stored(ctx context.Context, name string) (size int64, complete bool, err error)
+	// Append adds the data read from r to the named incomplete object,
+	// of which offset bytes are stored already.
+	Append(ctx context.Context, name string, offset int64, r io.Reader) error
+	// Finish makes the incomplete object complete.
+	Finish(ctx context.Context, name string) error
+	// Open returns a reader for the data of the complete object.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// String returns a description of the sink, without credentials.
+	String() string
+}
+
+// Credentials are the credentials to access the sinks that need them.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+}
+
+// New returns the sink for the given target, which is either the path of a
+// local directory (a file: URL or an absolute path), or the s3: URL
+// (s3+http: for plain HTTP) of an S3-compatible endpoint, bucket and prefix,
+// as in s3://example.com/bucket/prefix.
+func New(target string, creds *Credentials) (Sink, error) {
+	if filepath.IsAbs(target) {
+		return newDirSink(target), nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse snapshot sink %q: %v", target, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if !filepath.IsAbs(u.Path) || u.Host != "" {
+			return nil, fmt.Errorf("snapshot sink %q is not the path of a local directory", target)
+		}
+		return newDirSink(u.Path), nil
+	case "s3", "s3+http":
+		return newS3Sink(u, creds)
+	default:
+		return nil, fmt.Errorf("unsupported snapshot sink %q", target)
+	}
+}
+
+// Validate checks that the target can be used to create a sink with New.
+func Validate(target string) error {
+	_, err := New(target, &Credentials{})
+	return err
+}
+
+// Hash returns the SHA3-384 of the data of the complete object, which is
+// read back from the sink.
+func Hash(ctx context.Context, s Sink, name string) (string, error) {
+	r, err := s.Open(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := crypto.SHA3_384.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("cannot read %q from %s: %v", name, s, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func validName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return fmt.Errorf("invalid snapshot sink object name %q", name)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sink_test
+
+import (
+	"context"
+	"crypto"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/snapshotstate/sink"
+	"github.com/snapcore/snapd/testutil"
+)
+
+// tie gocheck into testing
+func TestSink(t *testing.T) { check.TestingT(t) }
+
+type sinkSuite struct {
+	testutil.BaseTest
+}
+
+var _ = check.Suite(&sinkSuite{})
+
+func (s *sinkSuite) TestNew(c *check.C) {
+	for _, t := range []struct {
+		target, desc, err string
+	}{
+		{target: "/media/backup", desc: `directory "/media/backup"`},
+		{target: "file:///media/backup/", desc: `directory "/media/backup"`},
+		{target: "s3://s3.example.com/bucket/some/prefix", desc: `S3 bucket "bucket" at s3.example.com`},
+		{target: "s3+http://localhost:9000/bucket", desc: `S3 bucket "bucket" at localhost:9000`},
+		{target: "media/backup", err: `unsupported snapshot sink "media/backup"`},
+		{target: "file://host/media", err: `snapshot sink "file://host/media" is not the path of a local directory`},
+		{target: "s3:///bucket", err: `snapshot sink "s3:///bucket" has no endpoint`},
+		{target: "s3://s3.example.com/", err: `snapshot sink "s3://s3.example.com/" has no bucket`},
+		{target: "sftp://example.com/backup", err: `unsupported snapshot sink "sftp://example.com/backup"`},
+	} {
+		sk, err := sink.New(t.target, nil)
+		if t.err != "" {
+			c.Check(err, check.ErrorMatches, t.err, check.Commentf(t.target))
+			c.Check(sink.Validate(t.target), check.ErrorMatches, t.err)
+			continue
+		}
+		c.Assert(err, check.IsNil, check.Commentf(t.target))
+		c.Check(sk.String(), check.Equals, t.desc)
+		c.Check(sink.Validate(t.target), check.IsNil)
+	}
+}
+
+// failingReader returns an error after returning n bytes from r.
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errInterrupted
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func (s *sinkSuite) testResume(c *check.C, target string) {
+	ctx := context.Background()
+	data := "0123456789abcdefghij"
+
+	sk, err := sink.New(target, &sink.Credentials{AccessKeyID: "key-id", SecretAccessKey: "sekrit"})
+	c.Assert(err, check.IsNil)
+
+	size, complete, err := sk.Stored(ctx, "foo.snapshot")
+	c.Assert(err, check.IsNil)
+	c.Check(size, check.Equals, int64(0))
+	c.Check(complete, check.Equals, false)
+
+	err = sk.Append(ctx, "foo.snapshot", 0, &failingReader{r: strings.NewReader(data), n: 9})
+	c.Assert(err, check.Equals, errInterrupted)
+
+	// resume with a new sink, as when the task is retried
+	sk, err = sink.New(target, &sink.Credentials{AccessKeyID: "key-id", SecretAccessKey: "sekrit"})
+	c.Assert(err, check.IsNil)
+	size, complete, err = sk.Stored(ctx, "foo.snapshot")
+	c.Assert(err, check.IsNil)
+	c.Check(complete, check.Equals, false)
+	c.Assert(size > 0 && size <= 9, check.Equals, true, check.Commentf("%d", size))
+
+	c.Assert(sk.Append(ctx, "foo.snapshot", size, strings.NewReader(data[size:])), check.IsNil)
+	c.Assert(sk.Finish(ctx, "foo.snapshot"), check.IsNil)
+
+	size, complete, err = sk.Stored(ctx, "foo.snapshot")
+	c.Assert(err, check.IsNil)
+	c.Check(size, check.Equals, int64(len(data)))
+	c.Check(complete, check.Equals, true)
+
+	r, err := sk.Open(ctx, "foo.snapshot")
+	c.Assert(err, check.IsNil)
+	stored, err := io.ReadAll(r)
+	r.Close()
+	c.Assert(err, check.IsNil)
+	c.Check(string(stored), check.Equals, data)
+
+	h := crypto.SHA3_384.New()
+	h.Write([]byte(data))
+	sum, err := sink.Hash(ctx, sk, "foo.snapshot")
+	c.Assert(err, check.IsNil)
+	c.Check(sum, check.Equals, fmt.Sprintf("%x", h.Sum(nil)))
+}
+
+func (s *sinkSuite) TestDirSinkResume(c *check.C) {
+	dir := c.MkDir()
+	s.testResume(c, dir)
+
+	c.Check(filepath.Join(dir, "foo.snapshot"), testutil.FileEquals, "0123456789abcdefghij")
+	c.Check(filepath.Join(dir, "foo.snapshot.part"), testutil.FileAbsent)
+}
+
+func (s *sinkSuite) TestDirSinkAppendTruncates(c *check.C) {
+	dir := c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "foo.snapshot.part"), []byte("0123xxxx"), 0600), check.IsNil)
+
+	sk, err := sink.New(dir, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(sk.Append(context.Background(), "foo.snapshot", 4, strings.NewReader("4567")), check.IsNil)
+	c.Check(filepath.Join(dir, "foo.snapshot.part"), testutil.FileEquals, "01234567")
+}
+
+func (s *sinkSuite) TestDirSinkErrors(c *check.C) {
+	sk, err := sink.New(filepath.Join(c.MkDir(), "missing"), nil)
+	c.Assert(err, check.IsNil)
+	err = sk.Append(context.Background(), "foo.snapshot", 0, strings.NewReader("data"))
+	c.Check(err, check.ErrorMatches, `cannot use directory ".*/missing": not a directory`)
+
+	_, _, err = sk.Stored(context.Background(), "../foo")
+	c.Check(err, check.ErrorMatches, `invalid snapshot sink object name "../foo"`)
+}
+
+// fakeS3 is a minimal stand-in for an S3-compatible endpoint.
+type fakeS3 struct {
+	mu      sync.Mutex
+	c       *check.C
+	bucket  string
+	objects map[string][]byte
+	uploads map[string]*fakeUpload
+	nextID  int
+	// initiated counts the multipart uploads initiated
+	initiated int
+	// fail makes the next requests fail with the given status
+	fail int
+}
+
+type fakeUpload struct {
+	key   string
+	parts map[int][]byte
+}
+
+func newFakeS3(c *check.C, bucket string) *fakeS3 {
+	return &fakeS3{
+		c:       c,
+		bucket:  bucket,
+		objects: make(map[string][]byte),
+		uploads: make(map[string]*fakeUpload),
+	}
+}
+
+func (f *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, strings.ToLower(code))
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := f.c
+	c.Check(r.Header.Get("Authorization"), check.Matches,
+		`AWS4-HMAC-SHA256 Credential=key-id/\d{8}/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=[0-9a-f]{64}`)
+	c.Check(r.Header.Get("x-amz-date"), check.Matches, `\d{8}T\d{6}Z`)
+	c.Check(r.Header.Get("x-amz-content-sha256"), check.Matches, `[0-9a-f]{64}`)
+
+	if f.fail != 0 {
+		f.writeError(w, f.fail, "SlowDown")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != f.bucket {
+		f.writeError(w, 404, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+	body, err := io.ReadAll(r.Body)
+	c.Assert(err, check.IsNil)
+
+	switch {
+	case key == "" && r.Method == "GET" && query.Has("uploads"):
+		fmt.Fprintf(w, "<ListMultipartUploadsResult>")
+		for id, upload := range f.uploads {
+			if strings.HasPrefix(upload.key, query.Get("prefix")) {
+				fmt.Fprintf(w, "<Upload><Key>%s</Key><UploadId>%s</UploadId></Upload>", upload.key, id)
+			}
+		}
+		fmt.Fprintf(w, "</ListMultipartUploadsResult>")
+	case r.Method == "POST" && query.Has("uploads"):
+		f.initiated++
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = &fakeUpload{key: key, parts: make(map[int][]byte)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == "PUT" && query.Has("uploadId"):
+		upload := f.uploads[query.Get("uploadId")]
+		if upload == nil {
+			f.writeError(w, 404, "NoSuchUpload")
+			return
+		}
+		n, err := strconv.Atoi(query.Get("partNumber"))
+		c.Assert(err, check.IsNil)
+		upload.parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, n))
+	case r.Method == "GET" && query.Has("uploadId"):
+		upload := f.uploads[query.Get("uploadId")]
+		if upload == nil {
+			f.writeError(w, 404, "NoSuchUpload")
+			return
+		}
+		fmt.Fprintf(w, "<ListPartsResult>")
+		for n, data := range upload.parts {
+			fmt.Fprintf(w, `<Part><PartNumber>%d</PartNumber><ETag>"etag-%d"</ETag><Size>%d</Size></Part>`, n, n, len(data))
+		}
+		fmt.Fprintf(w, "</ListPartsResult>")
+	case r.Method == "POST" && query.Has("uploadId"):
+		id := query.Get("uploadId")
+		upload := f.uploads[id]
+		if upload == nil {
+			f.writeError(w, 404, "NoSuchUpload")
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		c.Assert(xml.Unmarshal(body, &complete), check.IsNil)
+		var data []byte
+		for i, part := range complete.Parts {
+			c.Assert(part.PartNumber, check.Equals, i+1)
+			c.Assert(part.ETag, check.Equals, fmt.Sprintf(`"etag-%d"`, i+1))
+			data = append(data, upload.parts[part.PartNumber]...)
+		}
+		f.objects[upload.key] = data
+		delete(f.uploads, id)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == "PUT":
+		f.objects[key] = body
+	case r.Method == "HEAD" || r.Method == "GET":
+		data, ok := f.objects[key]
+		if !ok {
+			f.writeError(w, 404, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	default:
+		c.Fatalf("unexpected request %s %s", r.Method, r.URL)
+	}
+}
+
+func (s *sinkSuite) TestS3SinkResume(c *check.C) {
+	defer sink.MockS3PartSize(4)()
+
+	fake := newFakeS3(c, "bucket")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	target := "s3+http://" + strings.TrimPrefix(server.URL, "http://") + "/bucket/backups/device"
+	s.testResume(c, target)
+
+	var keys []string
+	for key := range fake.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	c.Check(keys, check.DeepEquals, []string{"backups/device/foo.snapshot"})
+	c.Check(fake.uploads, check.HasLen, 0)
+}
+
+func (s *sinkSuite) TestS3SinkSmallObjects(c *check.C) {
+	defer sink.MockS3PartSize(4)()
+
+	fake := newFakeS3(c, "bucket")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	target := "s3+http://" + strings.TrimPrefix(server.URL, "http://") + "/bucket"
+	sk, err := sink.New(target, &sink.Credentials{AccessKeyID: "key-id", SecretAccessKey: "sekrit"})
+	c.Assert(err, check.IsNil)
+
+	// objects smaller than a part, including empty ones, are uploaded
+	// with a single request
+	ctx := context.Background()
+	for name, data := range map[string]string{"empty.snapshot": "", "small.snapshot": "abc"} {
+		_, _, err := sk.Stored(ctx, name)
+		c.Assert(err, check.IsNil)
+		c.Assert(sk.Append(ctx, name, 0, strings.NewReader(data)), check.IsNil)
+		c.Assert(sk.Finish(ctx, name), check.IsNil)
+
+		size, complete, err := sk.Stored(ctx, name)
+		c.Assert(err, check.IsNil)
+		c.Check(size, check.Equals, int64(len(data)))
+		c.Check(complete, check.Equals, true)
+		c.Check(string(fake.objects[name]), check.Equals, data)
+	}
+	c.Check(fake.initiated, check.Equals, 0)
+
+	// while a multipart upload of exactly one part has no last part to
+	// upload when finishing
+	c.Assert(sk.Append(ctx, "part.snapshot", 0, strings.NewReader("abcd")), check.IsNil)
+	c.Assert(sk.Finish(ctx, "part.snapshot"), check.IsNil)
+	c.Check(string(fake.objects["part.snapshot"]), check.Equals, "abcd")
+	c.Check(fake.initiated, check.Equals, 1)
+	c.Check(fake.uploads, check.HasLen, 0)
+}
+
+func (s *sinkSuite) TestS3SinkErrors(c *check.C) {
+	defer sink.MockTimeNow(func() time.Time { return time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC) })()
+
+	fake := newFakeS3(c, "bucket")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	target := "s3+http://" + strings.TrimPrefix(server.URL, "http://") + "/other-bucket"
+	sk, err := sink.New(target, &sink.Credentials{AccessKeyID: "key-id", SecretAccessKey: "sekrit"})
+	c.Assert(err, check.IsNil)
+
+	c.Assert(sk.Append(context.Background(), "foo.snapshot", 0, strings.NewReader("data")), check.IsNil)
+	err = sk.Finish(context.Background(), "foo.snapshot")
+	c.Check(err, check.ErrorMatches, "S3 request failed: NoSuchBucket: nosuchbucket")
+	c.Check(sink.IsTransient(err), check.Equals, false)
+
+	fake.fail = 503
+	_, _, err = sk.Stored(context.Background(), "foo.snapshot")
+	c.Check(err, check.ErrorMatches, "S3 request failed: Service Unavailable")
+	c.Check(sink.IsTransient(fmt.Errorf("cannot upload: %w", err)), check.Equals, true)
+}
@@ -30,6 +30,7 @@ import (
 
 	"github.com/snapcore/snapd/client"
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/features"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
@@ -39,19 +40,26 @@ import (
 )
 
 var (
-	osRemove             = os.Remove
-	snapstateCurrentInfo = snapstate.CurrentInfo
-	configGetSnapConfig  = config.GetSnapConfig
-	configSetSnapConfig  = config.SetSnapConfig
-	backendOpen          = backend.Open
-	backendSave          = backend.Save
-	backendImport        = backend.Import
-	backendRestore       = (*backend.Reader).Restore // TODO: look into using an interface instead
-	backendCheck         = (*backend.Reader).Check
-	backendRevert        = (*backend.RestoreState).Revert // ditto
-	backendCleanup       = (*backend.RestoreState).Cleanup
+	osRemove               = os.Remove
+	snapstateCurrentInfo   = snapstate.CurrentInfo
+	configGetSnapConfig    = config.GetSnapConfig
+	configSetSnapConfig    = config.SetSnapConfig
+	backendOpen            = backend.Open
+	backendSave            = backend.Save
+	backendSaveWithOptions = backend.SaveWithOptions
+	backendDeviceKey       = backend.DeviceKey
+	backendPassphraseKey   = backend.PassphraseKey
+	backendUnlock          = (*backend.Reader).Unlock
+	backendImport          = backend.Import
+	backendRestore         = (*backend.Reader).Restore // TODO: look into using an interface instead
+	backendRestorePaths    = (*backend.Reader).RestorePaths
+	backendContents        = (*backend.Reader).Contents
+	backendCheck           = (*backend.Reader).Check
+	backendRevert          = (*backend.RestoreState).Revert // ditto
+	backendCleanup         = (*backend.RestoreState).Cleanup
 
 	backendCleanupAbandonedImports = backend.CleanupAbandonedImports
+	backendCollectChunks           = backend.CollectChunks
 
 	autoExpirationInterval = time.Hour * 24 // interval between forgetExpiredSnapshots runs as part of Ensure()
 
@@ -63,6 +71,9 @@ type SnapshotManager struct {
 	state *state.State
 
 	lastForgetExpiredSnapshotTime time.Time
+
+	lastSnapshotSchedule  string
+	nextScheduledSnapshot time.Time
 }
 
 // Manager returns a new SnapshotManager
@@ -74,6 +85,7 @@ func Manager(st *state.State, runner *state.TaskRunner) *SnapshotManager {
 	runner.AddHandler("check-snapshot", doCheck, nil)
 	runner.AddHandler("restore-snapshot", doRestore, undoRestore)
 	runner.AddHandler("cleanup-after-restore", doCleanupAfterRestore, nil)
+	runner.AddHandler("push-snapshot", doPush, nil, state.WithRetryPolicy(PushRetryPolicy))
 
 	manager := &SnapshotManager{
 		state: st,
@@ -87,19 +99,46 @@ func Manager(st *state.State, runner *state.TaskRunner) *SnapshotManager {
 func (mgr *SnapshotManager) Ensure() error {
 	// process expired snapshots once a day.
 	if time.Now().After(mgr.lastForgetExpiredSnapshotTime.Add(autoExpirationInterval)) {
-		return mgr.forgetExpiredSnapshots()
+		if err := mgr.forgetExpiredSnapshots(); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return mgr.ensureScheduledSnapshots()
 }
 
 func (mgr *SnapshotManager) StartUp() error {
 	if _, err := backendCleanupAbandonedImports(); err != nil {
 		logger.Noticef("cannot cleanup incomplete imports: %v", err)
 	}
+
+	mgr.state.Lock()
+	defer mgr.state.Unlock()
+	mgr.state.AddChangeStatusChangedHandler(dropChangePassphrases)
 	return nil
 }
 
+// dropChangePassphrases drops the passphrases provided for the snapshot sets
+// the change operated on once it's ready, so that they're only kept in memory
+// while needed.
+func dropChangePassphrases(chg *state.Change, old, new state.Status) {
+	if !new.Ready() {
+		return
+	}
+	for _, t := range chg.Tasks() {
+		switch t.Kind() {
+		case "save-snapshot", "check-snapshot", "restore-snapshot":
+		default:
+			continue
+		}
+		var snapshot snapshotSetup
+		if err := t.Get("snapshot-setup", &snapshot); err != nil {
+			continue
+		}
+		dropPassphrase(chg.State(), snapshot.SetID)
+	}
+}
+
 func (mgr *SnapshotManager) forgetExpiredSnapshots() error {
 	mgr.state.Lock()
 	defer mgr.state.Unlock()
@@ -113,15 +152,37 @@ func (mgr *SnapshotManager) forgetExpiredSnapshots() error {
 		return nil
 	}
 
-	err = backendIter(context.TODO(), func(r *backend.Reader) error {
+	if err := mgr.forgetSnapshotSets(sets); err != nil {
+		return fmt.Errorf("cannot process expired snapshots: %v", err)
+	}
+
+	collectChunks()
+
+	// only reset time if there are no sets left because of conflicts
+	if len(sets) == 0 {
+		mgr.lastForgetExpiredSnapshotTime = time.Now()
+	}
+
+	return nil
+}
+
+// forgetSnapshotSets removes the snapshots of the given sets from the state
+// and the disk; the sets that cannot be forgotten because of conflicting
+// operations are left in the map, to be retried later.
+// The state needs to be locked by the caller.
+func (mgr *SnapshotManager) forgetSnapshotSets(sets map[uint64]bool) error {
+	// sets can have several snapshots, so only take them off the map once
+	// they have all been seen
+	found := make(map[uint64]bool, len(sets))
+	err := backendIter(context.TODO(), func(r *backend.Reader) error {
 		// forget needs to conflict with check and restore
 		if err := checkSnapshotConflict(mgr.state, r.SetID, "export-snapshot",
-			"check-snapshot", "restore-snapshot"); err != nil {
+			"check-snapshot", "restore-snapshot", "push-snapshot"); err != nil {
 			// there is a conflict, do nothing and we will retry this set on next Ensure().
 			return nil
 		}
 		if sets[r.SetID] {
-			delete(sets, r.SetID)
+			found[r.SetID] = true
 			// remove from state first: in case removeSnapshotState succeeds but osRemove fails we will never attempt
 			// to automatically remove this snapshot again and will leave it on the disk (so the user can still try to remove it manually);
 			// this is better than the other way around where a failing osRemove would be retried forever because snapshot would never
@@ -135,22 +196,15 @@ func (mgr *SnapshotManager) forgetExpiredSnapshots() error {
 		}
 		return nil
 	})
-
-	if err != nil {
-		return fmt.Errorf("cannot process expired snapshots: %v", err)
+	for setID := range found {
+		delete(sets, setID)
 	}
-
-	// only reset time if there are no sets left because of conflicts
-	if len(sets) == 0 {
-		mgr.lastForgetExpiredSnapshotTime = time.Now()
-	}
-
-	return nil
+	return err
 }
 
 func (SnapshotManager) affectedSnaps(t *state.Task) ([]string, error) {
-	if k := t.Kind(); k == "check-snapshot" || k == "forget-snapshot" {
-		// check and forget don't affect snaps
+	if k := t.Kind(); k == "check-snapshot" || k == "forget-snapshot" || k == "push-snapshot" {
+		// check, forget and push don't affect snaps
 		// (this could also be written k != save && k != restore, but it's safer this way around)
 		return nil, nil
 	}
@@ -170,6 +224,18 @@ type snapshotSetup struct {
 	Filename string                `json:"filename,omitempty"`
 	Current  snap.Revision         `json:"current"`
 	Auto     bool                  `json:"auto,omitempty"`
+	// Incremental is set if the snapshot is saved deduplicated
+	// against the previous ones
+	Incremental bool `json:"incremental,omitempty"`
+	// Encryption is the scheme the snapshot is encrypted with, if any
+	Encryption string `json:"encryption,omitempty"`
+	// Scheduled is set if the snapshot is saved on schedule
+	Scheduled bool `json:"scheduled,omitempty"`
+	// Paths, if set, are the patterns selecting the data to restore
+	Paths []string `json:"paths,omitempty"`
+	// Target, if set, is the directory the selected data is restored
+	// under instead of into place
+	Target string `json:"target,omitempty"`
 }
 
 func filename(setID uint64, si *snap.Info) string {
@@ -198,6 +264,14 @@ func prepareSave(task *state.Task) (snapshot *snapshotSetup, cur *snap.Info, cfg
 	}
 	// updating snapshot-setup with the filename, for use in undo
 	snapshot.Filename = filename(snapshot.SetID, cur)
+	if snapshot.Encryption == "" {
+		// encrypted data doesn't deduplicate
+		tr := config.NewTransaction(st)
+		snapshot.Incremental, err = features.Flag(tr, features.IncrementalSnapshots)
+		if err != nil && !config.IsNoOption(err) {
+			return nil, nil, nil, err
+		}
+	}
 	task.Set("snapshot-setup", &snapshot)
 
 	cfg, err = unmarshalSnapConfig(st, snapshot.Snap)
@@ -215,6 +289,11 @@ func prepareSave(task *state.Task) (snapshot *snapshotSetup, cur *snap.Info, cfg
 			return nil, nil, nil, err
 		}
 	}
+	if snapshot.Scheduled {
+		if err := saveScheduled(st, snapshot.SetID, timeNow()); err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
 	return snapshot, cur, cfg, nil
 }
@@ -228,12 +307,13 @@ func doSave(task *state.Task, tomb *tomb.Tomb) error {
 
 	st.Lock()
 	opts, err := getSnapDirOpts(st, snapshot.Snap)
+	passphrase := cachedPassphrases(st)[snapshot.SetID]
 	st.Unlock()
 	if err != nil {
 		return err
 	}
 
-	_, err = backendSave(tomb.Context(nil), snapshot.SetID, cur, cfg, snapshot.Users, snapshot.Options, opts)
+	err = saveSnapshot(tomb.Context(nil), snapshot, cur, cfg, opts, passphrase)
 	if err != nil {
 		st.Lock()
 		defer st.Unlock()
@@ -242,6 +322,56 @@ func doSave(task *state.Task, tomb *tomb.Tomb) error {
 	return err
 }
 
+// saveSnapshot saves the snapshot with the backend, incrementally or
+// encrypted as set up.
+func saveSnapshot(ctx context.Context, snapshot *snapshotSetup, cur *snap.Info, cfg map[string]interface{}, dirOpts *dirs.SnapDirOptions, passphrase string) error {
+	if !snapshot.Incremental && snapshot.Encryption == "" {
+		_, err := backendSave(ctx, snapshot.SetID, cur, cfg, snapshot.Users, snapshot.Options, dirOpts)
+		return err
+	}
+
+	saveOpts := &backend.SaveOptions{Incremental: snapshot.Incremental}
+	switch snapshot.Encryption {
+	case "":
+		// not encrypted
+	case client.SnapshotEncryptionDevice:
+		key, err := backendDeviceKey()
+		if err != nil {
+			return fmt.Errorf("cannot get snapshot encryption key: %v", err)
+		}
+		saveOpts.Key = key
+	case client.SnapshotEncryptionPassphrase:
+		if passphrase == "" {
+			// it's only kept in memory
+			return fmt.Errorf("cannot encrypt snapshot: passphrase is no longer available")
+		}
+		key, err := backendPassphraseKey(passphrase)
+		if err != nil {
+			return fmt.Errorf("cannot get snapshot encryption key: %v", err)
+		}
+		saveOpts.Key = key
+	default:
+		return fmt.Errorf("internal error: unknown snapshot encryption scheme %q", snapshot.Encryption)
+	}
+	_, err := backendSaveWithOptions(ctx, snapshot.SetID, cur, cfg, snapshot.Users, snapshot.Options, dirOpts, saveOpts)
+	return err
+}
+
+// unlockSnapshot unlocks the snapshot with the passphrase provided for its
+// set, if it's encrypted with one.
+func unlockSnapshot(st *state.State, setID uint64, reader *backend.Reader) error {
+	if !reader.IsEncrypted() || reader.Encryption.Scheme != client.SnapshotEncryptionPassphrase {
+		return nil
+	}
+	st.Lock()
+	passphrase, ok := cachedPassphrases(st)[setID]
+	st.Unlock()
+	if !ok {
+		return nil
+	}
+	return backendUnlock(reader, passphrase)
+}
+
 // prepareRestore does the steps of doRestore that require the state lock
 // before the backend Restore call.
 func prepareRestore(task *state.Task) (snapshot *snapshotSetup, oldCfg map[string]interface{}, reader *backend.Reader, err error) {
@@ -305,6 +435,10 @@ func doRestore(task *state.Task, tomb *tomb.Tomb) error {
 	defer reader.Close()
 
 	st := task.State()
+	if err := unlockSnapshot(st, snapshot.SetID, reader); err != nil {
+		return err
+	}
+
 	logf := func(format string, args ...interface{}) {
 		st.Lock()
 		defer st.Unlock()
@@ -318,6 +452,19 @@ func doRestore(task *state.Task, tomb *tomb.Tomb) error {
 		return err
 	}
 
+	if len(snapshot.Paths) > 0 {
+		// only files are restored, the config is left alone
+		restoreState, err := backendRestorePaths(reader, tomb.Context(nil), snapshot.Current, snapshot.Users, snapshot.Paths, snapshot.Target, logf, opts)
+		if err != nil {
+			return err
+		}
+		st.Lock()
+		defer st.Unlock()
+		restoreState.Config = oldCfg
+		task.Set("restore-state", restoreState)
+		return nil
+	}
+
 	restoreState, err := backendRestore(reader, tomb.Context(nil), snapshot.Current, snapshot.Users, logf, opts)
 	if err != nil {
 		return err
@@ -433,6 +580,10 @@ func doCheck(task *state.Task, tomb *tomb.Tomb) error {
 	}
 	defer reader.Close()
 
+	if err := unlockSnapshot(st, snapshot.SetID, reader); err != nil {
+		return err
+	}
+
 	return backendCheck(reader, tomb.Context(nil), snapshot.Users)
 }
 
@@ -453,12 +604,29 @@ func doForget(task *state.Task, _ *tomb.Tomb) error {
 		return fmt.Errorf("internal error: task %s (%s) snapshot info is missing the filename", task.ID(), task.Kind())
 	}
 
-	// in case it's an automatic snapshot, remove the set also from the state (automatic snapshots have just one snap per set).
+	// in case it's an automatic or scheduled snapshot, remove the set also from the state (automatic snapshots have just one snap per set).
 	if err := removeSnapshotState(st, snapshot.SetID); err != nil {
 		return fmt.Errorf("internal error: cannot remove state of snapshot set %d: %v", snapshot.SetID, err)
 	}
+	dropPassphrase(st, snapshot.SetID)
 
-	return osRemove(snapshot.Filename)
+	if err := osRemove(snapshot.Filename); err != nil {
+		return err
+	}
+
+	// collecting the chunks can take a while
+	st.Unlock()
+	defer st.Lock()
+	collectChunks()
+	return nil
+}
+
+// collectChunks removes the chunks no longer used by any snapshot; failing
+// to do so only wastes some space until the next time.
+func collectChunks() {
+	if err := backendCollectChunks(context.TODO()); err != nil {
+		logger.Noticef("Cannot remove unused snapshot chunks: %v", err)
+	}
 }
 
 func delayedCrossMgrInit() {
@@ -475,3 +643,11 @@ func MockBackendSave(f func(context.Context, uint64, *snap.Info, map[string]inte
 		backendSave = old
 	}
 }
+
+func MockBackendSaveWithOptions(f func(context.Context, uint64, *snap.Info, map[string]interface{}, []string, *snap.SnapshotOptions, *dirs.SnapDirOptions, *backend.SaveOptions) (*client.Snapshot, error)) (restore func()) {
+	old := backendSaveWithOptions
+	backendSaveWithOptions = f
+	return func() {
+		backendSaveWithOptions = old
+	}
+}
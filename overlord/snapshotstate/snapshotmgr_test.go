@@ -35,6 +35,7 @@ import (
 	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/overlord"
+	"github.com/snapcore/snapd/overlord/configstate/config"
 	"github.com/snapcore/snapd/overlord/snapshotstate"
 	"github.com/snapcore/snapd/overlord/snapshotstate/backend"
 	"github.com/snapcore/snapd/overlord/state"
@@ -55,11 +56,42 @@ func (snapshotSuite) TestManager(c *check.C) {
 		"check-snapshot",
 		"cleanup-after-restore",
 		"forget-snapshot",
+		"push-snapshot",
 		"restore-snapshot",
 		"save-snapshot",
 	})
 }
 
+func (snapshotSuite) TestPassphrasesDroppedWhenChangeReady(c *check.C) {
+	defer snapshotstate.MockBackendCleanupAbandonedImports(func() (int, error) {
+		return 0, nil
+	})()
+
+	st := state.New(nil)
+	runner := state.NewTaskRunner(st)
+	mgr := snapshotstate.Manager(st, runner)
+	c.Assert(mgr.StartUp(), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	chg := st.NewChange("restore-snapshot", "...")
+	for _, snapName := range []string{"a-snap", "b-snap"} {
+		t := st.NewTask("restore-snapshot", "...")
+		t.Set("snapshot-setup", map[string]interface{}{"set-id": 42, "snap": snapName})
+		chg.AddTask(t)
+	}
+	snapshotstate.ProvidePassphrase(st, 42, "sekrit")
+	snapshotstate.ProvidePassphrase(st, 43, "other")
+
+	// the passphrase is kept while the change needs it
+	chg.Tasks()[0].SetStatus(state.DoneStatus)
+	c.Check(snapshotstate.CachedPassphrases(st), check.DeepEquals, map[uint64]string{42: "sekrit", 43: "other"})
+
+	chg.Tasks()[1].SetStatus(state.ErrorStatus)
+	c.Assert(chg.IsReady(), check.Equals, true)
+	c.Check(snapshotstate.CachedPassphrases(st), check.DeepEquals, map[uint64]string{43: "other"})
+}
+
 func mockFakeSnapshot(c *check.C) (restore func()) {
 	shotfile, err := os.Create(filepath.Join(c.MkDir(), "foo.zip"))
 	c.Assert(err, check.IsNil)
@@ -357,6 +389,107 @@ func (snapshotSuite) TestDoSaveGetsSnapDirOpts(c *check.C) {
 	c.Check(checkOpts, check.Equals, true)
 }
 
+func (snapshotSuite) TestDoSaveIncremental(c *check.C) {
+	defer snapshotstate.MockSnapstateCurrentInfo(func(_ *state.State, snapname string) (*snap.Info, error) {
+		return &snap.Info{SideInfo: snap.SideInfo{RealName: snapname, Revision: snap.R(1)}, Version: "1.0"}, nil
+	})()
+	defer snapshotstate.MockBackendSave(func(context.Context, uint64, *snap.Info, map[string]interface{}, []string, *snap.SnapshotOptions, *dirs.SnapDirOptions) (*client.Snapshot, error) {
+		c.Error("unexpected call to backend.Save")
+		return nil, nil
+	})()
+	var saved bool
+	defer snapshotstate.MockBackendSaveWithOptions(func(_ context.Context, id uint64, si *snap.Info, _ map[string]interface{}, usernames []string, _ *snap.SnapshotOptions, _ *dirs.SnapDirOptions, opts *backend.SaveOptions) (*client.Snapshot, error) {
+		c.Check(id, check.Equals, uint64(42))
+		c.Check(si.InstanceName(), check.Equals, "a-snap")
+		c.Check(usernames, check.DeepEquals, []string{"a-user"})
+		c.Check(opts, check.DeepEquals, &backend.SaveOptions{Incremental: true})
+		saved = true
+		return nil, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "experimental.incremental-snapshots", true)
+	tr.Commit()
+	task := st.NewTask("save-snapshot", "...")
+	task.Set("snapshot-setup", map[string]interface{}{
+		"set-id": 42,
+		"snap":   "a-snap",
+		"users":  []string{"a-user"},
+	})
+	st.Unlock()
+
+	err := snapshotstate.DoSave(task, &tomb.Tomb{})
+	c.Assert(err, check.IsNil)
+	c.Check(saved, check.Equals, true)
+
+	st.Lock()
+	defer st.Unlock()
+	var snapshot map[string]interface{}
+	c.Assert(task.Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot["incremental"], check.Equals, true)
+}
+
+func (snapshotSuite) TestDoSaveEncrypted(c *check.C) {
+	defer snapshotstate.MockSnapstateCurrentInfo(func(_ *state.State, snapname string) (*snap.Info, error) {
+		return &snap.Info{SideInfo: snap.SideInfo{RealName: snapname, Revision: snap.R(1)}, Version: "1.0"}, nil
+	})()
+	deviceKey := &backend.Key{}
+	defer snapshotstate.MockBackendDeviceKey(func() (*backend.Key, error) {
+		return deviceKey, nil
+	})()
+	passphraseKey := &backend.Key{}
+	defer snapshotstate.MockBackendPassphraseKey(func(passphrase string) (*backend.Key, error) {
+		c.Check(passphrase, check.Equals, "sekrit")
+		return passphraseKey, nil
+	})()
+	var key *backend.Key
+	defer snapshotstate.MockBackendSaveWithOptions(func(_ context.Context, _ uint64, _ *snap.Info, _ map[string]interface{}, _ []string, _ *snap.SnapshotOptions, _ *dirs.SnapDirOptions, opts *backend.SaveOptions) (*client.Snapshot, error) {
+		// encrypted snapshots are never incremental
+		c.Check(opts.Incremental, check.Equals, false)
+		key = opts.Key
+		return nil, nil
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	tr := config.NewTransaction(st)
+	tr.Set("core", "experimental.incremental-snapshots", true)
+	tr.Commit()
+	st.Unlock()
+
+	for _, t := range []struct {
+		encryption string
+		passphrase string
+		key        *backend.Key
+		err        string
+	}{
+		{encryption: "device", key: deviceKey},
+		{encryption: "passphrase", passphrase: "sekrit", key: passphraseKey},
+		{encryption: "passphrase", err: "cannot encrypt snapshot: passphrase is no longer available"},
+	} {
+		key = nil
+		st.Lock()
+		snapshotstate.CachedPassphrases(st)[42] = t.passphrase
+		task := st.NewTask("save-snapshot", "...")
+		task.Set("snapshot-setup", map[string]interface{}{
+			"set-id":     42,
+			"snap":       "a-snap",
+			"encryption": t.encryption,
+		})
+		st.Unlock()
+
+		err := snapshotstate.DoSave(task, &tomb.Tomb{})
+		if t.err != "" {
+			c.Check(err, check.ErrorMatches, t.err)
+			continue
+		}
+		c.Assert(err, check.IsNil)
+		c.Check(key, check.Equals, t.key)
+	}
+}
+
 func (snapshotSuite) TestDoSaveFailsWithNoSnap(c *check.C) {
 	defer snapshotstate.MockSnapstateCurrentInfo(func(*state.State, string) (*snap.Info, error) {
 		return nil, errors.New("bzzt")
@@ -639,6 +772,48 @@ func (rs *readerSuite) TestDoRestore(c *check.C) {
 	c.Check(v, check.DeepEquals, map[string]interface{}{"config": map[string]interface{}{"old": "conf"}})
 }
 
+func (rs *readerSuite) TestDoRestorePaths(c *check.C) {
+	st := rs.task.State()
+	st.Lock()
+	rs.task.Set("snapshot-setup", map[string]interface{}{
+		"snap":     "a-snap",
+		"filename": "/some/1_file.zip",
+		"users":    []string{"a-user"},
+		"current":  "2",
+		"paths":    []string{"common/*.conf"},
+		"target":   "/srv/restored",
+	})
+	st.Unlock()
+
+	defer snapshotstate.MockConfigGetSnapConfig(func(*state.State, string) (*json.RawMessage, error) {
+		rs.calls = append(rs.calls, "get config")
+		buf := json.RawMessage(`{"old": "conf"}`)
+		return &buf, nil
+	})()
+	defer snapshotstate.MockBackendRestorePaths(func(_ *backend.Reader, _ context.Context, current snap.Revision, users []string, paths []string, target string, _ backend.Logf, _ *dirs.SnapDirOptions) (*backend.RestoreState, error) {
+		rs.calls = append(rs.calls, "restore paths")
+		c.Check(current, check.Equals, snap.R(2))
+		c.Check(users, check.DeepEquals, []string{"a-user"})
+		c.Check(paths, check.DeepEquals, []string{"common/*.conf"})
+		c.Check(target, check.Equals, "/srv/restored")
+		return &backend.RestoreState{Created: []string{"/srv/restored/common/foo.conf"}}, nil
+	})()
+
+	err := snapshotstate.DoRestore(rs.task, &tomb.Tomb{})
+	c.Assert(err, check.IsNil)
+	// the config is left alone
+	c.Check(rs.calls, check.DeepEquals, []string{"get config", "open", "restore paths"})
+
+	st.Lock()
+	defer st.Unlock()
+	var v map[string]interface{}
+	rs.task.Get("restore-state", &v)
+	c.Check(v, check.DeepEquals, map[string]interface{}{
+		"created": []interface{}{"/srv/restored/common/foo.conf"},
+		"config":  map[string]interface{}{"old": "conf"},
+	})
+}
+
 func (rs *readerSuite) TestDoRestoreNoConfig(c *check.C) {
 	defer snapshotstate.MockConfigGetSnapConfig(func(_ *state.State, snapname string) (*json.RawMessage, error) {
 		rs.calls = append(rs.calls, "get config")
@@ -838,6 +1013,44 @@ func (rs *readerSuite) TestDoCheck(c *check.C) {
 	c.Check(rs.calls, check.DeepEquals, []string{"open", "check"})
 }
 
+func (rs *readerSuite) TestDoCheckAndRestoreUnlockSnapshot(c *check.C) {
+	defer snapshotstate.MockBackendOpen(func(string, uint64) (*backend.Reader, error) {
+		rs.calls = append(rs.calls, "open")
+		return &backend.Reader{
+			Snapshot: client.Snapshot{Encryption: &client.SnapshotEncryption{Scheme: "passphrase"}},
+		}, nil
+	})()
+	defer snapshotstate.MockBackendCheck(func(*backend.Reader, context.Context, []string) error {
+		rs.calls = append(rs.calls, "check")
+		return nil
+	})()
+	unlockErr := errors.New("cannot unlock snapshot: wrong passphrase")
+	defer snapshotstate.MockBackendUnlock(func(_ *backend.Reader, passphrase string) error {
+		rs.calls = append(rs.calls, "unlock "+passphrase)
+		return unlockErr
+	})()
+
+	// without a passphrase the snapshot is left locked
+	c.Assert(snapshotstate.DoCheck(rs.task, &tomb.Tomb{}), check.IsNil)
+	c.Check(rs.calls, check.DeepEquals, []string{"open", "check"})
+
+	st := rs.task.State()
+	st.Lock()
+	snapshotstate.ProvidePassphrase(st, 0, "sekrit")
+	st.Unlock()
+
+	rs.calls = nil
+	c.Check(snapshotstate.DoCheck(rs.task, &tomb.Tomb{}), check.ErrorMatches, "cannot unlock snapshot: wrong passphrase")
+	c.Check(snapshotstate.DoRestore(rs.task, &tomb.Tomb{}), check.ErrorMatches, "cannot unlock snapshot: wrong passphrase")
+	c.Check(rs.calls, check.DeepEquals, []string{"open", "unlock sekrit", "get config", "open", "unlock sekrit"})
+
+	unlockErr = nil
+	rs.calls = nil
+	c.Check(snapshotstate.DoCheck(rs.task, &tomb.Tomb{}), check.IsNil)
+	c.Check(snapshotstate.DoRestore(rs.task, &tomb.Tomb{}), check.IsNil)
+	c.Check(rs.calls, check.DeepEquals, []string{"open", "unlock sekrit", "check", "get config", "open", "unlock sekrit", "restore", "set config"})
+}
+
 func (rs *readerSuite) TestDoRemove(c *check.C) {
 	defer snapshotstate.MockOsRemove(func(filename string) error {
 		c.Check(filename, check.Equals, "/some/1_file.zip")
@@ -849,6 +1062,39 @@ func (rs *readerSuite) TestDoRemove(c *check.C) {
 	c.Check(rs.calls, check.DeepEquals, []string{"remove"})
 }
 
+func (rs *readerSuite) TestDoForgetDropsPassphrase(c *check.C) {
+	st := rs.task.State()
+	st.Lock()
+	snapshotstate.ProvidePassphrase(st, 0, "sekrit")
+	snapshotstate.ProvidePassphrase(st, 1, "other")
+	st.Unlock()
+
+	c.Assert(snapshotstate.DoForget(rs.task, &tomb.Tomb{}), check.IsNil)
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(snapshotstate.CachedPassphrases(st), check.DeepEquals, map[uint64]string{1: "other"})
+}
+
+func (rs *readerSuite) TestDoForgetCollectsChunks(c *check.C) {
+	defer snapshotstate.MockOsRemove(func(filename string) error {
+		rs.calls = append(rs.calls, "remove")
+		return nil
+	})()
+	defer snapshotstate.MockBackendCollectChunks(func(context.Context) error {
+		rs.calls = append(rs.calls, "collect")
+		return errors.New("bzzt")
+	})()
+	logbuf, restore := logger.MockLogger()
+	defer restore()
+
+	// failing to collect the chunks doesn't fail the forget
+	err := snapshotstate.DoForget(rs.task, &tomb.Tomb{})
+	c.Assert(err, check.IsNil)
+	c.Check(rs.calls, check.DeepEquals, []string{"remove", "collect"})
+	c.Check(logbuf.String(), testutil.Contains, "Cannot remove unused snapshot chunks: bzzt")
+}
+
 func (rs *readerSuite) TestDoForgetRemovesAutomaticSnapshotExpiry(c *check.C) {
 	defer snapshotstate.MockOsRemove(func(filename string) error {
 		return nil
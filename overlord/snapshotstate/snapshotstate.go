@@ -25,6 +25,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"sort"
 	"time"
 
@@ -53,6 +54,8 @@ var (
 
 type snapshotState struct {
 	ExpiryTime time.Time `json:"expiry-time"`
+	// ScheduledTime is set for the snapshot sets saved on schedule
+	ScheduledTime *time.Time `json:"scheduled-time,omitempty"`
 }
 
 func newSnapshotSetID(st *state.State) (uint64, error) {
@@ -154,6 +157,14 @@ func AutomaticSnapshotExpiration(st *state.State) (time.Duration, error) {
 // saveExpiration saves expiration date of the given snapshot set, in the state.
 // The state needs to be locked by the caller.
 func saveExpiration(st *state.State, setID uint64, expiryTime time.Time) error {
+	return saveSnapshotState(st, setID, &snapshotState{
+		ExpiryTime: expiryTime,
+	})
+}
+
+// saveSnapshotState saves the state of the given snapshot set.
+// The state needs to be locked by the caller.
+func saveSnapshotState(st *state.State, setID uint64, snapshotSet *snapshotState) error {
 	var snapshots map[uint64]*json.RawMessage
 	err := st.Get("snapshots", &snapshots)
 	if err != nil && !errors.Is(err, state.ErrNoState) {
@@ -162,9 +173,7 @@ func saveExpiration(st *state.State, setID uint64, expiryTime time.Time) error {
 	if snapshots == nil {
 		snapshots = make(map[uint64]*json.RawMessage)
 	}
-	data, err := json.Marshal(&snapshotState{
-		ExpiryTime: expiryTime,
-	})
+	data, err := json.Marshal(snapshotSet)
 	if err != nil {
 		return err
 	}
@@ -207,7 +216,7 @@ func expiredSnapshotSets(st *state.State, cutoffTime time.Time) (map[uint64]bool
 
 	expired := make(map[uint64]bool)
 	for setID, snapshotSet := range snapshots {
-		if snapshotSet.ExpiryTime.Before(cutoffTime) {
+		if !snapshotSet.ExpiryTime.IsZero() && snapshotSet.ExpiryTime.Before(cutoffTime) {
 			expired[setID] = true
 		}
 	}
@@ -320,21 +329,60 @@ func List(ctx context.Context, st *state.State, setID uint64, snapNames []string
 		return nil, err
 	}
 
-	// decorate all snapshots with "auto" flag if we have expiry time set for them.
+	// decorate all snapshots with "auto" flag if we have expiry time set for them,
+	// and with "scheduled" flag if they were saved on schedule.
 	for _, sset := range sets {
-		// at the moment we only keep records with expiry time so checking non-zero
-		// expiry-time is not strictly necessary, but it makes it future-proof in case
-		// we add more attributes to these entries.
-		if snapshotState, ok := snapshots[sset.ID]; ok && !snapshotState.ExpiryTime.IsZero() {
-			for _, snapshot := range sset.Snapshots {
+		snapshotState, ok := snapshots[sset.ID]
+		if !ok {
+			continue
+		}
+		for _, snapshot := range sset.Snapshots {
+			if !snapshotState.ExpiryTime.IsZero() {
 				snapshot.Auto = true
 			}
+			if snapshotState.ScheduledTime != nil {
+				snapshot.Scheduled = true
+			}
 		}
 	}
 
 	return sets, nil
 }
 
+// Contents lists the files and directories in the data of the snapshots in
+// the set, for the given snaps and users if non-empty, without restoring
+// them.
+// Note that the state must not be locked by the caller.
+func Contents(ctx context.Context, st *state.State, setID uint64, snapNames []string, users []string) ([]client.SnapshotFile, error) {
+	summaries, err := snapSummariesInSnapshotSet(setID, snapNames)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []client.SnapshotFile{}
+	for _, summary := range summaries {
+		snapFiles, err := snapshotContents(ctx, st, setID, summary.filename, users)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, snapFiles...)
+	}
+	return files, nil
+}
+
+func snapshotContents(ctx context.Context, st *state.State, setID uint64, filename string, users []string) ([]client.SnapshotFile, error) {
+	reader, err := backendOpen(filename, backend.ExtractFnameSetID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open snapshot: %v", err)
+	}
+	defer reader.Close()
+
+	if err := unlockSnapshot(st, setID, reader); err != nil {
+		return nil, err
+	}
+	return backendContents(reader, ctx, users)
+}
+
 // Import a given snapshot ID from an exported snapshot
 func Import(ctx context.Context, st *state.State, r io.Reader) (setID uint64, snapNames []string, err error) {
 	st.Lock()
@@ -381,6 +429,75 @@ func Import(ctx context.Context, st *state.State, r io.Reader) (setID uint64, sn
 // Save creates a taskset for taking snapshots of snaps' data.
 // Note that the state must be locked by the caller.
 func Save(st *state.State, instanceNames []string, users []string, options map[string]*snap.SnapshotOptions) (setID uint64, snapsSaved []string, ts *state.TaskSet, err error) {
+	encryption, err := configuredEncryption(st)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return save(st, instanceNames, users, options, encryption, false)
+}
+
+// SaveWithPassphrase creates a taskset for taking snapshots of snaps' data,
+// encrypted with a key derived from the passphrase. The passphrase is only
+// kept in memory, see ProvidePassphrase.
+// Note that the state must be locked by the caller.
+func SaveWithPassphrase(st *state.State, instanceNames []string, users []string, options map[string]*snap.SnapshotOptions, passphrase string) (setID uint64, snapsSaved []string, ts *state.TaskSet, err error) {
+	if passphrase == "" {
+		return 0, nil, nil, fmt.Errorf("cannot encrypt snapshots with an empty passphrase")
+	}
+	setID, snapsSaved, ts, err = save(st, instanceNames, users, options, client.SnapshotEncryptionPassphrase, false)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	ProvidePassphrase(st, setID, passphrase)
+	return setID, snapsSaved, ts, nil
+}
+
+type passphrasesKey struct{}
+
+// cachedPassphrases returns the passphrases provided for snapshot sets.
+func cachedPassphrases(st *state.State) map[uint64]string {
+	passphrases, _ := st.Cached(passphrasesKey{}).(map[uint64]string)
+	if passphrases == nil {
+		passphrases = make(map[uint64]string)
+		st.Cache(passphrasesKey{}, passphrases)
+	}
+	return passphrases
+}
+
+// ProvidePassphrase makes the passphrase available to the operations on the
+// snapshots of the set encrypted with it. Passphrases are only kept in memory
+// until the operations are done or the set is forgotten, so they need
+// providing again for later operations and after a restart.
+// Note that the state must be locked by the caller.
+func ProvidePassphrase(st *state.State, setID uint64, passphrase string) {
+	cachedPassphrases(st)[setID] = passphrase
+}
+
+// dropPassphrase forgets the passphrase provided for the snapshot set.
+// Note that the state must be locked by the caller.
+func dropPassphrase(st *state.State, setID uint64) {
+	delete(cachedPassphrases(st), setID)
+}
+
+// configuredEncryption returns the scheme new snapshots are to be encrypted
+// with as configured, if any.
+func configuredEncryption(st *state.State) (string, error) {
+	var encryption string
+	tr := config.NewTransaction(st)
+	if err := tr.Get("core", "snapshots.encryption", &encryption); err != nil && !config.IsNoOption(err) {
+		return "", err
+	}
+	switch encryption {
+	case "", "none":
+		return "", nil
+	case client.SnapshotEncryptionDevice:
+		return encryption, nil
+	default:
+		return "", fmt.Errorf("unsupported snapshots.encryption %q", encryption)
+	}
+}
+
+func save(st *state.State, instanceNames []string, users []string, options map[string]*snap.SnapshotOptions, encryption string, scheduled bool) (setID uint64, snapsSaved []string, ts *state.TaskSet, err error) {
 	if len(instanceNames) == 0 {
 		instanceNames, err = allActiveSnapNames(st)
 		if err != nil {
@@ -413,13 +530,18 @@ func Save(st *state.State, instanceNames []string, users []string, options map[s
 
 	for _, name := range instanceNames {
 		desc := fmt.Sprintf("Save data of snap %q in snapshot set #%d", name, setID)
+		if scheduled {
+			desc = fmt.Sprintf("Save data of snap %q in scheduled snapshot set #%d", name, setID)
+		}
 		task := st.NewTask("save-snapshot", desc)
 
 		snapshot := snapshotSetup{
-			SetID:   setID,
-			Snap:    name,
-			Users:   users,
-			Options: options[name],
+			SetID:      setID,
+			Snap:       name,
+			Users:      users,
+			Options:    options[name],
+			Encryption: encryption,
+			Scheduled:  scheduled,
 		}
 
 		task.Set("snapshot-setup", &snapshot)
@@ -448,6 +570,10 @@ func AutomaticSnapshot(st *state.State, snapName string) (ts *state.TaskSet, err
 	if expiration == 0 {
 		return nil, snapstate.ErrNothingToDo
 	}
+	encryption, err := configuredEncryption(st)
+	if err != nil {
+		return nil, err
+	}
 	setID, err := newSnapshotSetID(st)
 	if err != nil {
 		return nil, err
@@ -457,9 +583,10 @@ func AutomaticSnapshot(st *state.State, snapName string) (ts *state.TaskSet, err
 	desc := fmt.Sprintf("Save data of snap %q in automatic snapshot set #%d", snapName, setID)
 	task := st.NewTask("save-snapshot", desc)
 	snapshot := snapshotSetup{
-		SetID: setID,
-		Snap:  snapName,
-		Auto:  true,
+		SetID:      setID,
+		Snap:       snapName,
+		Auto:       true,
+		Encryption: encryption,
 	}
 	task.Set("snapshot-setup", &snapshot)
 	ts.AddTask(task)
@@ -470,6 +597,39 @@ func AutomaticSnapshot(st *state.State, snapName string) (ts *state.TaskSet, err
 // Restore creates a taskset for restoring a snapshot's data.
 // Note that the state must be locked by the caller.
 func Restore(st *state.State, setID uint64, snapNames []string, users []string) (snapsFound []string, ts *state.TaskSet, err error) {
+	return restore(st, setID, snapNames, users, nil, "")
+}
+
+// RestoreFiles creates a taskset for restoring only the files and
+// directories of a snapshot's data that match the given patterns, which are
+// relative to the data directories of the snaps, as in "common/*.conf". The
+// data is restored into place, or under target if not empty. The config of
+// the snaps is left alone.
+// Note that the state must be locked by the caller.
+func RestoreFiles(st *state.State, setID uint64, snapNames []string, users []string, paths []string, target string) (snapsFound []string, ts *state.TaskSet, err error) {
+	if err := ValidateRestoreFiles(paths, target); err != nil {
+		return nil, nil, err
+	}
+	return restore(st, setID, snapNames, users, paths, target)
+}
+
+// ValidateRestoreFiles checks the patterns and target given to RestoreFiles.
+func ValidateRestoreFiles(paths []string, target string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("cannot restore files of snapshot: no paths given")
+	}
+	for _, path := range paths {
+		if err := backend.ValidatePathPattern(path); err != nil {
+			return err
+		}
+	}
+	if target != "" && !filepath.IsAbs(target) {
+		return fmt.Errorf("cannot restore files of snapshot into %q: not an absolute path", target)
+	}
+	return nil
+}
+
+func restore(st *state.State, setID uint64, snapNames []string, users []string, paths []string, target string) (snapsFound []string, ts *state.TaskSet, err error) {
 	summaries, err := snapSummariesInSnapshotSet(setID, snapNames)
 	if err != nil {
 		return nil, nil, err
@@ -494,7 +654,8 @@ func Restore(st *state.State, setID uint64, snapNames []string, users []string)
 
 	for _, summary := range summaries {
 		var current snap.Revision
-		if snapst, ok := all[summary.snap]; ok {
+		// data restored elsewhere keeps the layout of the snapshot
+		if snapst, ok := all[summary.snap]; ok && target == "" {
 			info, err := snapst.CurrentInfo()
 			if err != nil {
 				// how?
@@ -512,6 +673,12 @@ func Restore(st *state.State, setID uint64, snapNames []string, users []string)
 		}
 
 		desc := fmt.Sprintf("Restore data of snap %q from snapshot set #%d", summary.snap, setID)
+		switch {
+		case target != "":
+			desc = fmt.Sprintf("Restore files of snap %q from snapshot set #%d into %q", summary.snap, setID, target)
+		case len(paths) > 0:
+			desc = fmt.Sprintf("Restore files of snap %q from snapshot set #%d", summary.snap, setID)
+		}
 		task := st.NewTask("restore-snapshot", desc)
 		snapshot := snapshotSetup{
 			SetID:    setID,
@@ -519,6 +686,8 @@ func Restore(st *state.State, setID uint64, snapNames []string, users []string)
 			Users:    users,
 			Filename: summary.filename,
 			Current:  current,
+			Paths:    paths,
+			Target:   target,
 		}
 		task.Set("snapshot-setup", &snapshot)
 		// see the note about snapshots not using lanes, above.
@@ -572,9 +741,9 @@ func Check(st *state.State, setID uint64, snapNames []string, users []string) (s
 // Forget creates a taskset for deletinig a snapshot.
 // Note that the state must be locked by the caller.
 func Forget(st *state.State, setID uint64, snapNames []string) (snapsFound []string, ts *state.TaskSet, err error) {
-	// forget needs to conflict with check, restore, import and export.
+	// forget needs to conflict with check, restore, import, export and push.
 	if err := checkSnapshotConflict(st, setID, "export-snapshot",
-		"check-snapshot", "restore-snapshot"); err != nil {
+		"check-snapshot", "restore-snapshot", "push-snapshot"); err != nil {
 		return nil, nil, err
 	}
 
@@ -597,6 +597,69 @@ func (s snapshotSuite) TestSaveOneSnap(c *check.C) {
 	})
 }
 
+func (snapshotSuite) TestSaveEncryptedDevice(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	snapstate.Set(st, "a-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{
+			{RealName: "a-snap", Revision: snap.R(1)},
+		}),
+		Current: snap.R(1),
+	})
+	tr := config.NewTransaction(st)
+	tr.Set("core", "snapshots.encryption", "device")
+	tr.Commit()
+
+	_, _, taskset, err := snapshotstate.Save(st, []string{"a-snap"}, nil, nil)
+	c.Assert(err, check.IsNil)
+	tasks := taskset.Tasks()
+	c.Assert(tasks, check.HasLen, 1)
+	var snapshot map[string]interface{}
+	c.Check(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot["encryption"], check.Equals, "device")
+
+	tr = config.NewTransaction(st)
+	tr.Set("core", "snapshots.encryption", "foo")
+	tr.Commit()
+	_, _, _, err = snapshotstate.Save(st, []string{"a-snap"}, nil, nil)
+	c.Assert(err, check.ErrorMatches, `unsupported snapshots.encryption "foo"`)
+}
+
+func (snapshotSuite) TestSaveWithPassphrase(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	snapstate.Set(st, "a-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{
+			{RealName: "a-snap", Revision: snap.R(1)},
+		}),
+		Current: snap.R(1),
+	})
+
+	_, _, _, err := snapshotstate.SaveWithPassphrase(st, []string{"a-snap"}, nil, nil, "")
+	c.Assert(err, check.ErrorMatches, "cannot encrypt snapshots with an empty passphrase")
+
+	setID, saved, taskset, err := snapshotstate.SaveWithPassphrase(st, []string{"a-snap"}, nil, nil, "sekrit")
+	c.Assert(err, check.IsNil)
+	c.Check(saved, check.DeepEquals, []string{"a-snap"})
+	tasks := taskset.Tasks()
+	c.Assert(tasks, check.HasLen, 1)
+	var snapshot map[string]interface{}
+	c.Check(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot["encryption"], check.Equals, "passphrase")
+	c.Check(snapshotstate.CachedPassphrases(st), check.DeepEquals, map[uint64]string{setID: "sekrit"})
+
+	// the passphrase is not kept in the state
+	data, err := json.Marshal(st)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Not(testutil.Contains), "sekrit")
+}
+
 func (snapshotSuite) TestSaveIntegration(c *check.C) {
 	if os.Geteuid() == 0 {
 		c.Skip("this test cannot run as root (runuser will fail)")
@@ -1198,6 +1261,111 @@ func (snapshotSuite) TestRestore(c *check.C) {
 	})
 }
 
+func (snapshotSuite) TestRestoreFiles(c *check.C) {
+	shotfile, err := os.Create(filepath.Join(c.MkDir(), "yadda.zip"))
+	c.Assert(err, check.IsNil)
+	defer shotfile.Close()
+	defer snapshotstate.MockBackendIter(func(_ context.Context, f func(*backend.Reader) error) error {
+		return f(&backend.Reader{
+			Snapshot: client.Snapshot{SetID: 42, Snap: "a-snap"},
+			File:     shotfile,
+		})
+	})()
+
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+	snapstate.Set(st, "a-snap", &snapstate.SnapState{
+		Active: true,
+		Sequence: snapstatetest.NewSequenceFromSnapSideInfos([]*snap.SideInfo{
+			{RealName: "a-snap", Revision: snap.R(2)},
+		}),
+		Current: snap.R(2),
+	})
+
+	found, taskset, err := snapshotstate.RestoreFiles(st, 42, nil, nil, []string{"common/*.conf"}, "")
+	c.Assert(err, check.IsNil)
+	c.Check(found, check.DeepEquals, []string{"a-snap"})
+	tasks := taskset.Tasks()
+	c.Assert(tasks, check.HasLen, 2)
+	c.Check(tasks[0].Summary(), check.Equals, `Restore files of snap "a-snap" from snapshot set #42`)
+	var snapshot map[string]interface{}
+	c.Check(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot, check.DeepEquals, map[string]interface{}{
+		"set-id":   42.,
+		"snap":     "a-snap",
+		"filename": shotfile.Name(),
+		"current":  "2",
+		"paths":    []interface{}{"common/*.conf"},
+	})
+
+	// restored elsewhere, the data keeps the revision of the snapshot
+	_, taskset, err = snapshotstate.RestoreFiles(st, 42, nil, []string{"a-user"}, []string{"common"}, "/srv/restored")
+	c.Assert(err, check.IsNil)
+	tasks = taskset.Tasks()
+	c.Assert(tasks, check.HasLen, 2)
+	c.Check(tasks[0].Summary(), check.Equals, `Restore files of snap "a-snap" from snapshot set #42 into "/srv/restored"`)
+	c.Check(tasks[0].Get("snapshot-setup", &snapshot), check.IsNil)
+	c.Check(snapshot, check.DeepEquals, map[string]interface{}{
+		"set-id":   42.,
+		"snap":     "a-snap",
+		"filename": shotfile.Name(),
+		"users":    []interface{}{"a-user"},
+		"current":  "unset",
+		"paths":    []interface{}{"common"},
+		"target":   "/srv/restored",
+	})
+}
+
+func (snapshotSuite) TestRestoreFilesErrors(c *check.C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	_, _, err := snapshotstate.RestoreFiles(st, 42, nil, nil, nil, "")
+	c.Check(err, check.ErrorMatches, `cannot restore files of snapshot: no paths given`)
+	_, _, err = snapshotstate.RestoreFiles(st, 42, nil, nil, []string{"/etc/passwd"}, "")
+	c.Check(err, check.ErrorMatches, `invalid snapshot path pattern "/etc/passwd": must be a clean relative path`)
+	_, _, err = snapshotstate.RestoreFiles(st, 42, nil, nil, []string{"common"}, "restored")
+	c.Check(err, check.ErrorMatches, `cannot restore files of snapshot into "restored": not an absolute path`)
+}
+
+func (snapshotSuite) TestContents(c *check.C) {
+	shotfile, err := os.Create(filepath.Join(c.MkDir(), "yadda.zip"))
+	c.Assert(err, check.IsNil)
+	defer shotfile.Close()
+	defer snapshotstate.MockBackendIter(func(_ context.Context, f func(*backend.Reader) error) error {
+		for _, snapName := range []string{"a-snap", "b-snap"} {
+			if err := f(&backend.Reader{
+				Snapshot: client.Snapshot{SetID: 42, Snap: snapName},
+				File:     shotfile,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})()
+	defer snapshotstate.MockBackendOpen(func(filename string, setID uint64) (*backend.Reader, error) {
+		c.Check(filename, check.Equals, shotfile.Name())
+		return &backend.Reader{Snapshot: client.Snapshot{SetID: 42, Snap: "b-snap"}}, nil
+	})()
+	defer snapshotstate.MockBackendContents(func(r *backend.Reader, _ context.Context, users []string) ([]client.SnapshotFile, error) {
+		c.Check(users, check.DeepEquals, []string{"a-user"})
+		return []client.SnapshotFile{{Snap: r.Snap, Path: "common"}, {Snap: r.Snap, Path: "common/foo.conf"}}, nil
+	})()
+
+	st := state.New(nil)
+	files, err := snapshotstate.Contents(context.Background(), st, 42, []string{"b-snap"}, []string{"a-user"})
+	c.Assert(err, check.IsNil)
+	c.Check(files, check.DeepEquals, []client.SnapshotFile{
+		{Snap: "b-snap", Path: "common"},
+		{Snap: "b-snap", Path: "common/foo.conf"},
+	})
+
+	_, err = snapshotstate.Contents(context.Background(), st, 43, nil, nil)
+	c.Check(err, check.Equals, client.ErrSnapshotSetNotFound)
+}
+
 func (snapshotSuite) TestRestoreIntegration(c *check.C) {
 	testRestoreIntegration(c, dirs.UserHomeSnapDir, nil)
 }
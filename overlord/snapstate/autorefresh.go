@@ -277,6 +277,31 @@ func (m *autoRefresh) canRefreshRespectingMetered(now, lastRefresh time.Time) (c
 	return false, nil
 }
 
+// canRefreshRespectingWindows returns whether the refresh windows of the
+// system or of some snaps allow auto-refreshes now, otherwise it moves the
+// next refresh to when they do.
+func (m *autoRefresh) canRefreshRespectingWindows(now, lastRefresh time.Time) (can bool, err error) {
+	ws, err := getRefreshWindows(m.state)
+	if err != nil {
+		return false, err
+	}
+	if ws.allowsAny(now) {
+		return true, nil
+	}
+
+	if !lastRefresh.IsZero() && now.Sub(lastRefresh) >= maxPostponement {
+		logger.Noticef("Auto refresh is outside of refresh windows, but pending for too long (%d days). Trying to refresh now.", int(maxPostponement.Hours()/24))
+		return true, nil
+	}
+
+	m.nextRefresh = ws.nextAllowedAny(now)
+	if m.nextRefresh.IsZero() {
+		m.nextRefresh = lastRefresh.Add(maxPostponement)
+	}
+	logger.Debugf("Auto refresh is outside of refresh windows, postponed to %s.", m.nextRefresh.Format(time.RFC3339))
+	return false, nil
+}
+
 func isStoreOnline(s *state.State) (bool, error) {
 	tr := config.NewTransaction(s)
 
@@ -356,6 +381,12 @@ func (m *autoRefresh) Ensure() (err error) {
 		}
 		logger.Debugf("Next refresh scheduled for %s.", m.nextRefresh.Format(time.RFC3339))
 	}
+	// attempt the auto-refreshes deferred by refresh windows when they
+	// are allowed, if sooner
+	if deferred := deferredRefresh(m.state); !deferred.IsZero() && deferred.Before(m.nextRefresh) {
+		m.nextRefresh = deferred
+		logger.Debugf("Next refresh of snaps deferred by refresh windows scheduled for %s.", m.nextRefresh.Format(time.RFC3339))
+	}
 
 	held, holdTime, err := m.isRefreshHeld()
 	if err != nil {
@@ -382,6 +413,14 @@ func (m *autoRefresh) Ensure() (err error) {
 		// or operation
 		if !m.nextRefresh.After(now) {
 			var can bool
+			can, err = m.canRefreshRespectingWindows(now, lastRefresh)
+			if err != nil {
+				return err
+			}
+			if !can {
+				return nil
+			}
+
 			can, err = m.canRefreshRespectingMetered(now, lastRefresh)
 			if err != nil {
 				return err
@@ -589,6 +628,10 @@ func (m *autoRefresh) launchAutoRefresh() error {
 	}
 	m.lastRefreshAttempt = now
 
+	// deferred auto-refreshes are attempted now, they are recorded
+	// again if still deferred
+	m.state.Cache(deferredRefreshKey{}, nil)
+
 	perfTimings := timings.New(map[string]string{"ensure": "auto-refresh"})
 	tm := perfTimings.StartSpan("auto-refresh", "query store and setup auto-refresh change")
 	defer func() {
@@ -29,6 +29,7 @@ import (
 	"github.com/snapcore/snapd/overlord/state"
 	"github.com/snapcore/snapd/snap"
 	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/peercache"
 	"github.com/snapcore/snapd/testutil"
 	userclient "github.com/snapcore/snapd/usersession/client"
 	"github.com/snapcore/snapd/wrappers"
@@ -236,6 +237,35 @@ func MockLastRefreshSchedule(ar *autoRefresh, schedule string) {
 	ar.lastRefreshSchedule = schedule
 }
 
+// refresh windows
+var (
+	DeferredRefresh = deferredRefresh
+	RefreshOverdue  = refreshOverdue
+	MaxPostponement = maxPostponement
+)
+
+func RefreshWindowAllows(window, blackout string, t time.Time) bool {
+	return newRefreshWindow(window, blackout).allows(t)
+}
+
+func RefreshWindowNextAllowed(window, blackout string, t time.Time) time.Time {
+	return newRefreshWindow(window, blackout).nextAllowed(t)
+}
+
+type SharingPeer = sharingPeer
+
+var NewPeerCache = newPeerCache
+
+var RecordShareableBlob = recordShareableBlob
+
+func MockNewPeer(f func(cacheDir string, opts *peercache.Options) SharingPeer) (restore func()) {
+	old := newPeer
+	newPeer = f
+	return func() {
+		newPeer = old
+	}
+}
+
 func MockCatalogRefreshNextRefresh(cr *catalogRefresh, when time.Time) {
 	cr.nextCatalogRefresh = when
 }
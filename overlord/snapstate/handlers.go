@@ -812,6 +812,9 @@ func (m *SnapManager) doDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 	st.Lock()
 	t.Set("snap-setup", snapsup)
 	perfTimings.Save(st)
+	if err := recordShareableBlob(st, t, snapsup); err != nil {
+		logger.Debugf("cannot record snap %q as shareable with peers: %v", snapsup.InstanceName(), err)
+	}
 	st.Unlock()
 
 	return nil
@@ -878,6 +881,9 @@ func (m *SnapManager) doPreDownloadSnap(t *state.Task, tomb *tomb.Tomb) error {
 		return err
 	}
 	perfTimings.Save(st)
+	if err := recordShareableBlob(st, t, snapsup); err != nil {
+		logger.Debugf("cannot record snap %q as shareable with peers: %v", snapsup.InstanceName(), err)
+	}
 
 	var waitingTasks []string
 	if err := t.Get("waiting-tasks", &waitingTasks); err != nil && !errors.Is(err, &state.NoStateError{}) {
@@ -1071,6 +1077,23 @@ func continueInhibitedAutoRefresh(st *state.State, snapName string) error {
 		return fmt.Errorf("cannot get refresh-candidates for %q: not found", snapName)
 	}
 
+	// the snap may have been closed outside of its refresh window, leave
+	// it to a later auto-refresh then
+	ws, err := getRefreshWindows(st)
+	if err != nil {
+		return err
+	}
+	var snapst SnapState
+	if err := Get(st, snapName, &snapst); err != nil {
+		return err
+	}
+	if ws.defers(st, snapName, &snapst, timeNow()) {
+		logger.Noticef("Auto-refresh of %q is outside of its refresh window, deferring it", snapName)
+		// let the auto-refresh pick up when to attempt it again
+		st.EnsureBefore(0)
+		return nil
+	}
+
 	flags := &Flags{IsAutoRefresh: true, IsContinuedAutoRefresh: true}
 	tss, err := autoRefreshPhase2(st, []*refreshCandidate{hint}, flags, "")
 	if err != nil {
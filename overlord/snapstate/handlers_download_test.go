@@ -20,7 +20,14 @@
 package snapstate_test
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	. "gopkg.in/check.v1"
 
@@ -137,6 +144,7 @@ func (s *downloadSnapSuite) TestDoDownloadSnapNormal(c *C) {
 		SideInfo: si,
 		DownloadInfo: &snap.DownloadInfo{
 			DownloadURL: "http://some-url.com/snap",
+			Sha3_384:    "sha3-of-foo",
 		},
 	})
 	chg := s.state.NewChange("sample", "...")
@@ -152,6 +160,11 @@ func (s *downloadSnapSuite) TestDoDownloadSnapNormal(c *C) {
 
 	c.Assert(chg.Err(), IsNil)
 
+	// the snap can be shared with peers
+	var shareable []string
+	c.Assert(s.state.Get("peer-cache-shareable", &shareable), IsNil)
+	c.Check(shareable, DeepEquals, []string{"sha3-of-foo"})
+
 	// only the download endpoint of the store was hit
 	c.Assert(s.fakeBackend.ops, DeepEquals, fakeOps{
 		{
@@ -175,6 +188,59 @@ func (s *downloadSnapSuite) TestDoDownloadSnapNormal(c *C) {
 	})
 }
 
+func (s *downloadSnapSuite) TestDoDownloadSnapRetriesTransientErrors(c *C) {
+	s.state.Lock()
+
+	s.fakeStore.downloadError = map[string]error{
+		"foo": &store.DownloadError{Code: 503, URL: &url.URL{Host: "some-url.com"}},
+	}
+
+	t := s.state.NewTask("download-snap", "test")
+	t.Set("snap-setup", &snapstate.SnapSetup{
+		SideInfo: &snap.SideInfo{
+			RealName: "foo",
+			SnapID:   "mySnapID",
+			Revision: snap.R(11),
+		},
+		DownloadInfo: &snap.DownloadInfo{
+			DownloadURL: "http://some-url.com/snap",
+		},
+	})
+	chg := s.state.NewChange("sample", "...")
+	chg.AddTask(t)
+
+	s.state.Unlock()
+
+	s.se.Ensure()
+	s.se.Wait()
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// the download is retried later
+	c.Check(t.Status(), Equals, state.DoingStatus)
+	c.Check(t.DoingRetries(), Equals, 1)
+	c.Check(t.AtTime().IsZero(), Equals, false)
+	c.Check(strings.Join(t.Log(), ""), Matches, `.* attempt 1 failed, will retry in .*: received an unexpected http response code \(503\).*`)
+}
+
+func (s *downloadSnapSuite) TestIsTransientStoreError(c *C) {
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{&store.DownloadError{Code: 404}, false},
+		{&store.DownloadError{Code: 500}, true},
+		{fmt.Errorf("cannot download: %w", &store.DownloadError{Code: 502}), true},
+		{&net.OpError{Op: "read", Err: &os.SyscallError{Err: syscall.ECONNRESET}}, true},
+		{fmt.Errorf("cannot fetch: %w", &url.Error{Err: &net.OpError{Op: "read", Err: &os.SyscallError{Err: syscall.ECONNRESET}}}), true},
+	} {
+		c.Check(snapstate.IsTransientStoreError(tc.err), Equals, tc.transient, Commentf("%v", tc.err))
+	}
+}
+
 func (s *downloadSnapSuite) TestDoDownloadSnapWithDeviceContext(c *C) {
 	s.state.Lock()
 
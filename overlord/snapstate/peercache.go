@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/peercache"
+)
+
+// sharingPeer is the part of peercache.Peer used here.
+type sharingPeer interface {
+	Start() error
+	Stop() error
+	store.PeerFinder
+}
+
+var newPeer = func(cacheDir string, opts *peercache.Options) sharingPeer {
+	return peercache.New(cacheDir, opts)
+}
+
+// peerCache shares the downloaded snaps with the peers of the local
+// network and makes the store try them first when downloading, if
+// enabled with the store.peer-cache option. Only the snaps that anyone
+// can download from the global store are shared, as the peers are not
+// authenticated.
+type peerCache struct {
+	state *state.State
+
+	peer sharingPeer
+	// iface is the network interface the peer shares the snaps on,
+	// all of them if empty
+	iface string
+	// startFailed avoids retrying to start the peer until the options
+	// change
+	startFailed bool
+
+	mu sync.Mutex
+	// shareable holds the sha3-384 digests of the downloaded snaps
+	// that can be shared
+	shareable map[string]bool
+}
+
+func newPeerCache(st *state.State) *peerCache {
+	return &peerCache{state: st}
+}
+
+// peerCacheOptions returns whether sharing the downloaded snaps with
+// peers is enabled, and the network interface to share them on.
+func peerCacheOptions(st *state.State) (enabled bool, iface string, err error) {
+	tr := config.NewTransaction(st)
+
+	// the option can be set both as a string and as a bool
+	var enabledOpt interface{}
+	if err := tr.GetMaybe("core", "store.peer-cache", &enabledOpt); err != nil {
+		return false, "", err
+	}
+	if fmt.Sprintf("%v", enabledOpt) != "true" {
+		return false, "", nil
+	}
+	if err := tr.GetMaybe("core", "store.peer-cache-interface", &iface); err != nil {
+		return false, "", err
+	}
+	enabled, err = isStoreOnline(st)
+	return enabled, iface, err
+}
+
+func shareableBlobs(st *state.State) ([]string, error) {
+	var blobs []string
+	if err := st.Get("peer-cache-shareable", &blobs); err != nil && !errors.Is(err, state.ErrNoState) {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// recordShareableBlob records that the snap downloaded by the task can
+// be shared with peers, if it was downloaded from the global store and
+// is not private. Blobs no longer in the download cache are forgotten.
+func recordShareableBlob(st *state.State, t *state.Task, snapsup *SnapSetup) error {
+	if snapsup.DownloadInfo == nil || snapsup.DownloadInfo.Sha3_384 == "" || snapsup.SideInfo == nil || snapsup.SideInfo.Private {
+		return nil
+	}
+	deviceCtx, err := DeviceCtx(st, t, nil)
+	if err != nil {
+		return err
+	}
+	if deviceCtx.Model().Store() != "" {
+		// a brand store
+		return nil
+	}
+
+	blobs, err := shareableBlobs(st)
+	if err != nil {
+		return err
+	}
+	kept := []string{snapsup.DownloadInfo.Sha3_384}
+	for _, sha3_384 := range blobs {
+		if sha3_384 == kept[0] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dirs.SnapDownloadCacheDir, sha3_384)); err == nil {
+			kept = append(kept, sha3_384)
+		}
+	}
+	st.Set("peer-cache-shareable", kept)
+	return nil
+}
+
+// isShareable returns whether the blob can be served to peers.
+func (pc *peerCache) isShareable(sha3_384 string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.shareable[sha3_384]
+}
+
+type peerFinderSetter interface {
+	SetPeerFinder(store.PeerFinder)
+}
+
+func (pc *peerCache) setPeerFinder(peers store.PeerFinder) {
+	sto, ok := cachedStore(pc.state).(peerFinderSetter)
+	if ok {
+		sto.SetPeerFinder(peers)
+	}
+}
+
+// Ensure starts or stops sharing the downloaded snaps according to the
+// store.peer-cache option.
+func (pc *peerCache) Ensure() error {
+	pc.state.Lock()
+	defer pc.state.Unlock()
+
+	enabled, iface, err := peerCacheOptions(pc.state)
+	if err != nil {
+		return err
+	}
+	if iface != pc.iface {
+		// start again on the new interface
+		pc.startFailed = false
+		if pc.peer != nil {
+			pc.stop()
+		}
+		pc.iface = iface
+	}
+
+	blobs, err := shareableBlobs(pc.state)
+	if err != nil {
+		return err
+	}
+	shareable := make(map[string]bool, len(blobs))
+	for _, sha3_384 := range blobs {
+		shareable[sha3_384] = true
+	}
+	pc.mu.Lock()
+	pc.shareable = shareable
+	pc.mu.Unlock()
+
+	switch {
+	case enabled && pc.peer == nil && !pc.startFailed:
+		peer := newPeer(dirs.SnapDownloadCacheDir, &peercache.Options{
+			Interface: pc.iface,
+			Shareable: pc.isShareable,
+		})
+		if err := peer.Start(); err != nil {
+			logger.Noticef("Cannot share downloaded snaps with peers: %v", err)
+			pc.startFailed = true
+			return nil
+		}
+		pc.peer = peer
+		pc.setPeerFinder(peer)
+	case !enabled:
+		pc.startFailed = false
+		if pc.peer != nil {
+			pc.stop()
+		}
+	}
+	return nil
+}
+
+func (pc *peerCache) stop() {
+	pc.setPeerFinder(nil)
+	if err := pc.peer.Stop(); err != nil {
+		logger.Noticef("Cannot stop sharing downloaded snaps with peers: %v", err)
+	}
+	pc.peer = nil
+}
+
+// Stop stops sharing the downloaded snaps.
+func (pc *peerCache) Stop() {
+	pc.state.Lock()
+	defer pc.state.Unlock()
+
+	if pc.peer != nil {
+		pc.stop()
+	}
+}
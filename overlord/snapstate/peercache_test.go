@@ -0,0 +1,262 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+	"github.com/snapcore/snapd/overlord/snapstate/snapstatetest"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/snap"
+	"github.com/snapcore/snapd/store"
+	"github.com/snapcore/snapd/store/peercache"
+	"github.com/snapcore/snapd/store/storetest"
+	"github.com/snapcore/snapd/testutil"
+)
+
+type peerFinderStore struct {
+	storetest.Store
+
+	peers store.PeerFinder
+}
+
+func (s *peerFinderStore) SetPeerFinder(peers store.PeerFinder) {
+	s.peers = peers
+}
+
+type fakeSharingPeer struct {
+	cacheDir string
+	opts     *peercache.Options
+	startErr error
+	ops      []string
+}
+
+func (p *fakeSharingPeer) Start() error {
+	p.ops = append(p.ops, "start")
+	return p.startErr
+}
+
+func (p *fakeSharingPeer) Stop() error {
+	p.ops = append(p.ops, "stop")
+	return nil
+}
+
+func (p *fakeSharingPeer) Find(ctx context.Context, sha3_384 string) ([]string, error) {
+	return nil, nil
+}
+
+type peerCacheTestSuite struct {
+	testutil.BaseTest
+
+	state *state.State
+	store *peerFinderStore
+	peers []*fakeSharingPeer
+}
+
+var _ = Suite(&peerCacheTestSuite{})
+
+func (s *peerCacheTestSuite) SetUpTest(c *C) {
+	s.BaseTest.SetUpTest(c)
+	dirs.SetRootDir(c.MkDir())
+	s.AddCleanup(func() { dirs.SetRootDir("") })
+
+	s.state = state.New(nil)
+	s.store = &peerFinderStore{}
+	s.state.Lock()
+	snapstate.ReplaceStore(s.state, s.store)
+	s.state.Unlock()
+
+	s.peers = nil
+	s.AddCleanup(snapstate.MockNewPeer(func(cacheDir string, opts *peercache.Options) snapstate.SharingPeer {
+		p := &fakeSharingPeer{cacheDir: cacheDir, opts: opts}
+		s.peers = append(s.peers, p)
+		return p
+	}))
+}
+
+func (s *peerCacheTestSuite) setPeerCache(c *C, value interface{}) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "store.peer-cache", value), IsNil)
+	tr.Commit()
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheDisabledByDefault(c *C) {
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+
+	c.Check(s.peers, HasLen, 0)
+	c.Check(s.store.peers, IsNil)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheEnableDisable(c *C) {
+	pc := snapstate.NewPeerCache(s.state)
+
+	s.setPeerCache(c, true)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 1)
+	c.Check(s.peers[0].cacheDir, Equals, dirs.SnapDownloadCacheDir)
+	c.Check(s.peers[0].ops, DeepEquals, []string{"start"})
+	c.Check(s.store.peers, Equals, s.peers[0])
+
+	// nothing changes while enabled
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers, HasLen, 1)
+	c.Check(s.peers[0].ops, DeepEquals, []string{"start"})
+
+	s.setPeerCache(c, false)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers[0].ops, DeepEquals, []string{"start", "stop"})
+	c.Check(s.store.peers, IsNil)
+
+	// enabling again starts a new peer
+	s.setPeerCache(c, true)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 2)
+	c.Check(s.store.peers, Equals, s.peers[1])
+
+	pc.Stop()
+	c.Check(s.peers[1].ops, DeepEquals, []string{"start", "stop"})
+	c.Check(s.store.peers, IsNil)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheStoreOffline(c *C) {
+	s.setPeerCache(c, true)
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "store.access", "offline"), IsNil)
+	tr.Commit()
+	s.state.Unlock()
+
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers, HasLen, 0)
+	c.Check(s.store.peers, IsNil)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheStartError(c *C) {
+	s.AddCleanup(snapstate.MockNewPeer(func(cacheDir string, opts *peercache.Options) snapstate.SharingPeer {
+		p := &fakeSharingPeer{cacheDir: cacheDir, opts: opts, startErr: errors.New("boom")}
+		s.peers = append(s.peers, p)
+		return p
+	}))
+	s.setPeerCache(c, true)
+
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers, HasLen, 1)
+	c.Check(s.store.peers, IsNil)
+
+	// not retried until the option is toggled
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers, HasLen, 1)
+
+	s.setPeerCache(c, false)
+	c.Assert(pc.Ensure(), IsNil)
+	s.setPeerCache(c, true)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(s.peers, HasLen, 2)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheInterface(c *C) {
+	s.setPeerCache(c, true)
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 1)
+	c.Check(s.peers[0].opts.Interface, Equals, "")
+
+	// the peer is started again on the configured interface
+	s.state.Lock()
+	tr := config.NewTransaction(s.state)
+	c.Assert(tr.Set("core", "store.peer-cache-interface", "eth1"), IsNil)
+	tr.Commit()
+	s.state.Unlock()
+
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 2)
+	c.Check(s.peers[0].ops, DeepEquals, []string{"start", "stop"})
+	c.Check(s.peers[1].ops, DeepEquals, []string{"start"})
+	c.Check(s.peers[1].opts.Interface, Equals, "eth1")
+	c.Check(s.store.peers, Equals, s.peers[1])
+}
+
+func (s *peerCacheTestSuite) recordBlob(c *C, sha3_384 string, private bool) {
+	c.Assert(os.MkdirAll(dirs.SnapDownloadCacheDir, 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dirs.SnapDownloadCacheDir, sha3_384), nil, 0644), IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	snapsup := &snapstate.SnapSetup{
+		SideInfo:     &snap.SideInfo{RealName: "foo", Private: private},
+		DownloadInfo: &snap.DownloadInfo{Sha3_384: sha3_384},
+	}
+	c.Assert(snapstate.RecordShareableBlob(s.state, s.state.NewTask("download-snap", "..."), snapsup), IsNil)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheShareable(c *C) {
+	s.AddCleanup(snapstatetest.MockDeviceModel(DefaultModel()))
+
+	s.recordBlob(c, "public-1", false)
+	s.recordBlob(c, "private", true)
+	s.recordBlob(c, "public-2", false)
+	// blobs gone from the cache are forgotten
+	c.Assert(os.Remove(filepath.Join(dirs.SnapDownloadCacheDir, "public-1")), IsNil)
+	s.recordBlob(c, "public-3", false)
+
+	s.setPeerCache(c, true)
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 1)
+	shareable := s.peers[0].opts.Shareable
+	c.Check(shareable("public-1"), Equals, false)
+	c.Check(shareable("private"), Equals, false)
+	c.Check(shareable("public-2"), Equals, true)
+	c.Check(shareable("public-3"), Equals, true)
+	c.Check(shareable("other"), Equals, false)
+
+	// newly recorded blobs are shared after the next ensure
+	s.recordBlob(c, "public-4", false)
+	c.Check(shareable("public-4"), Equals, false)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Check(shareable("public-4"), Equals, true)
+}
+
+func (s *peerCacheTestSuite) TestPeerCacheBrandStoreNotShareable(c *C) {
+	s.AddCleanup(snapstatetest.MockDeviceModel(MakeModel(map[string]interface{}{
+		"store": "my-brand-store",
+	})))
+
+	s.recordBlob(c, "brand", false)
+
+	s.setPeerCache(c, true)
+	pc := snapstate.NewPeerCache(s.state)
+	c.Assert(pc.Ensure(), IsNil)
+	c.Assert(s.peers, HasLen, 1)
+	c.Check(s.peers[0].opts.Shareable("brand"), Equals, false)
+}
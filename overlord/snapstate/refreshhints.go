@@ -96,6 +96,10 @@ func (r *refreshHints) refresh() error {
 	if err != nil {
 		return err
 	}
+	// snaps outside of their refresh window are not candidates for now
+	if err := plan.filterOutsideRefreshWindows(r.state); err != nil {
+		return err
+	}
 	deviceCtx, err := DeviceCtxFromState(r.state, nil)
 	if err != nil {
 		return err
@@ -156,6 +160,15 @@ func (r *refreshHints) Ensure() error {
 	if !needsUpdate {
 		return nil
 	}
+
+	// like auto-refreshes, only query the store inside a refresh window
+	ws, err := getRefreshWindows(r.state)
+	if err != nil {
+		return err
+	}
+	if !ws.allowsAny(timeNow()) {
+		return nil
+	}
 	return r.refresh()
 }
 
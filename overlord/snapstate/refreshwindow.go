@@ -0,0 +1,329 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/state"
+	"github.com/snapcore/snapd/timeutil"
+)
+
+// refreshWindow is when auto-refreshes may happen, inside a maintenance
+// window if any and outside of blackout dates.
+type refreshWindow struct {
+	window    string
+	schedule  []*timeutil.Schedule
+	blackout  string
+	blackouts []*timeutil.Blackout
+}
+
+func newRefreshWindow(window, blackout string) *refreshWindow {
+	w := &refreshWindow{}
+	if window != "" {
+		schedule, err := timeutil.ParseSchedule(window)
+		if err != nil {
+			// log instead of fail in order not to prevent auto-refreshes
+			logger.Noticef("cannot use refresh maintenance window %q: %v", window, err)
+		} else {
+			w.window, w.schedule = window, schedule
+		}
+	}
+	if blackout != "" {
+		blackouts, err := timeutil.ParseBlackouts(blackout)
+		if err != nil {
+			logger.Noticef("cannot use refresh blackout %q: %v", blackout, err)
+		} else {
+			w.blackout, w.blackouts = blackout, blackouts
+		}
+	}
+	return w
+}
+
+// isZero returns whether auto-refreshes may happen any time.
+func (w *refreshWindow) isZero() bool {
+	return len(w.schedule) == 0 && len(w.blackouts) == 0
+}
+
+// allows returns whether auto-refreshes may happen at t.
+func (w *refreshWindow) allows(t time.Time) bool {
+	if timeutil.InBlackout(w.blackouts, t) != nil {
+		return false
+	}
+	return len(w.schedule) == 0 || timeutil.Includes(w.schedule, t)
+}
+
+// nextAllowed returns the earliest time from t on at which
+// auto-refreshes may happen, or the zero time if there is none within
+// maxPostponement.
+func (w *refreshWindow) nextAllowed(t time.Time) time.Time {
+	limit := t.Add(maxPostponement)
+	for t.Before(limit) {
+		if b := timeutil.InBlackout(w.blackouts, t); b != nil {
+			t = b.Until(t)
+			continue
+		}
+		if len(w.schedule) == 0 {
+			return t
+		}
+		next := timeutil.NextIncluded(w.schedule, t, limit.Sub(t))
+		if next.IsZero() || timeutil.InBlackout(w.blackouts, next) == nil {
+			return next
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// refreshWindows are the refresh windows of the system and of the snaps
+// with their own.
+type refreshWindows struct {
+	system *refreshWindow
+	// snaps holds the effective windows of the snaps with their own
+	// window or blackout, which apply on top of the system blackout
+	snaps map[string]*refreshWindow
+}
+
+type snapRefreshWindowConf struct {
+	MaintenanceWindow string `json:"maintenance-window"`
+	Blackout          string `json:"blackout"`
+}
+
+func getRefreshWindows(st *state.State) (*refreshWindows, error) {
+	tr := config.NewTransaction(st)
+
+	var window, blackout string
+	if err := tr.GetMaybe("core", "refresh.maintenance-window", &window); err != nil {
+		return nil, err
+	}
+	if err := tr.GetMaybe("core", "refresh.blackout", &blackout); err != nil {
+		return nil, err
+	}
+	var snapsConf map[string]snapRefreshWindowConf
+	if err := tr.GetMaybe("core", "refresh.snaps", &snapsConf); err != nil {
+		return nil, err
+	}
+
+	ws := &refreshWindows{
+		system: newRefreshWindow(window, blackout),
+	}
+	for name, conf := range snapsConf {
+		if conf.MaintenanceWindow == "" && conf.Blackout == "" {
+			continue
+		}
+		snapWindow := conf.MaintenanceWindow
+		if snapWindow == "" {
+			snapWindow = window
+		}
+		var snapBlackouts []string
+		for _, b := range []string{blackout, conf.Blackout} {
+			if b != "" {
+				snapBlackouts = append(snapBlackouts, b)
+			}
+		}
+		if ws.snaps == nil {
+			ws.snaps = make(map[string]*refreshWindow)
+		}
+		ws.snaps[name] = newRefreshWindow(snapWindow, strings.Join(snapBlackouts, ","))
+	}
+	return ws, nil
+}
+
+// forSnap returns the refresh window of the given snap.
+func (ws *refreshWindows) forSnap(instanceName string) *refreshWindow {
+	if w, ok := ws.snaps[instanceName]; ok {
+		return w
+	}
+	return ws.system
+}
+
+func (ws *refreshWindows) all() []*refreshWindow {
+	all := []*refreshWindow{ws.system}
+	for _, w := range ws.snaps {
+		all = append(all, w)
+	}
+	return all
+}
+
+// isZero returns whether auto-refreshes of any snap may happen any time.
+func (ws *refreshWindows) isZero() bool {
+	for _, w := range ws.all() {
+		if !w.isZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// allowsAny returns whether auto-refreshes of at least some snaps may
+// happen at t.
+func (ws *refreshWindows) allowsAny(t time.Time) bool {
+	for _, w := range ws.all() {
+		if w.allows(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextAllowedAny returns the earliest time from t on at which
+// auto-refreshes of at least some snaps may happen, or the zero time if
+// there is none within maxPostponement.
+func (ws *refreshWindows) nextAllowedAny(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws.all() {
+		n := w.nextAllowed(t)
+		if !n.IsZero() && (next.IsZero() || n.Before(next)) {
+			next = n
+		}
+	}
+	return next
+}
+
+// defers returns whether the refresh window of the snap defers its
+// auto-refresh at the given time, recording when to attempt it again.
+// Overdue snaps are not deferred.
+func (ws *refreshWindows) defers(st *state.State, instanceName string, snapst *SnapState, now time.Time) bool {
+	w := ws.forSnap(instanceName)
+	if w.allows(now) {
+		return false
+	}
+	if refreshOverdue(snapst, now) {
+		logger.Noticef("Auto-refresh of %q is outside of its refresh window, but pending for too long (%d days). Refreshing now.", instanceName, int(maxPostponement.Hours()/24))
+		return false
+	}
+	deferRefreshUntil(st, w.nextAllowed(now))
+	return true
+}
+
+// refreshOverdue returns whether the snap was last refreshed so long ago
+// that it must be refreshed regardless of refresh windows.
+func refreshOverdue(snapst *SnapState, now time.Time) bool {
+	if snapst.LastRefreshTime == nil {
+		return false
+	}
+	return now.Sub(*snapst.LastRefreshTime) >= maxPostponement
+}
+
+type deferredRefreshKey struct{}
+
+// deferRefreshUntil records that auto-refreshes of some snaps were
+// deferred by their refresh windows until the given time, to attempt
+// them then. The earliest time is kept until cleared when launching an
+// auto-refresh.
+func deferRefreshUntil(st *state.State, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if prev := deferredRefresh(st); !prev.IsZero() && prev.Before(t) {
+		return
+	}
+	st.Cache(deferredRefreshKey{}, t)
+}
+
+// deferredRefresh returns when auto-refreshes deferred by refresh
+// windows should be attempted, if any.
+func deferredRefresh(st *state.State) time.Time {
+	t, _ := st.Cached(deferredRefreshKey{}).(time.Time)
+	return t
+}
+
+// filterOutsideRefreshWindows removes from the plan the targets whose
+// refresh windows do not allow auto-refreshing them now, unless they
+// are overdue, and records when to attempt them again.
+func (p *updatePlan) filterOutsideRefreshWindows(st *state.State) error {
+	ws, err := getRefreshWindows(st)
+	if err != nil {
+		return err
+	}
+	if ws.isZero() {
+		return nil
+	}
+
+	now := timeNow()
+	var deferred []string
+	p.filter(func(t target) (bool, error) {
+		name := t.info.InstanceName()
+		if ws.defers(st, name, &t.snapst, now) {
+			deferred = append(deferred, name)
+			return false, nil
+		}
+		return true, nil
+	})
+	if len(deferred) > 0 {
+		sort.Strings(deferred)
+		logger.Debugf("Auto-refresh of %s deferred by refresh windows", strings.Join(deferred, ", "))
+	}
+	return nil
+}
+
+// RefreshWindowStatus describes when auto-refreshes may happen.
+type RefreshWindowStatus struct {
+	// MaintenanceWindow is the schedule outside of which auto-refreshes
+	// do not happen, if any.
+	MaintenanceWindow string
+	// Blackout holds the dates on which auto-refreshes do not happen,
+	// if any.
+	Blackout string
+	// Open is whether auto-refreshes may happen now.
+	Open bool
+	// InBlackout is whether auto-refreshes do not happen now because
+	// of a blackout.
+	InBlackout bool
+	// NextOpen is when auto-refreshes may happen next, if not now.
+	NextOpen time.Time
+}
+
+func (w *refreshWindow) status(now time.Time) *RefreshWindowStatus {
+	status := &RefreshWindowStatus{
+		MaintenanceWindow: w.window,
+		Blackout:          w.blackout,
+		Open:              w.allows(now),
+		InBlackout:        timeutil.InBlackout(w.blackouts, now) != nil,
+	}
+	if !status.Open {
+		status.NextOpen = w.nextAllowed(now)
+	}
+	return status
+}
+
+// refreshWindowsStatus returns the status of the system refresh window,
+// nil if there is none, and of the snaps with their own.
+func refreshWindowsStatus(st *state.State) (system *RefreshWindowStatus, snaps map[string]*RefreshWindowStatus, err error) {
+	ws, err := getRefreshWindows(st)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := timeNow()
+	if !ws.system.isZero() {
+		system = ws.system.status(now)
+	}
+	for name, w := range ws.snaps {
+		if snaps == nil {
+			snaps = make(map[string]*RefreshWindowStatus, len(ws.snaps))
+		}
+		snaps[name] = w.status(now)
+	}
+	return system, snaps, nil
+}
@@ -0,0 +1,216 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snapstate_test
+
+import (
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/configstate/config"
+	"github.com/snapcore/snapd/overlord/snapstate"
+)
+
+type refreshWindowSuite struct{}
+
+var _ = Suite(&refreshWindowSuite{})
+
+func (s *refreshWindowSuite) TestAllowsNextAllowed(c *C) {
+	// 2026-10-14 is a Wednesday
+	at := func(month time.Month, day int, clock string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("2026-%02d-%02d %s", month, day, clock), time.Local)
+		c.Assert(err, IsNil)
+		return t
+	}
+
+	for i, t := range []struct {
+		window, blackout string
+		t                time.Time
+		allows           bool
+		next             time.Time
+	}{
+		// no restrictions
+		{"", "", at(10, 14, "10:00"), true, at(10, 14, "10:00")},
+		// inside and outside of the window
+		{"mon-fri,18:00-23:00", "", at(10, 14, "19:00"), true, at(10, 14, "19:00")},
+		{"mon-fri,18:00-23:00", "", at(10, 14, "10:00"), false, at(10, 14, "18:00")},
+		{"mon-fri,18:00-23:00", "", at(10, 16, "23:30"), false, at(10, 19, "18:00")},
+		// blackouts
+		{"", "10-14..10-15", at(10, 14, "10:00"), false, at(10, 16, "00:00")},
+		{"", "2026-10-13", at(10, 14, "10:00"), true, at(10, 14, "10:00")},
+		// the window opens during the blackout
+		{"mon-fri,18:00-23:00", "2026-10-14..2026-10-15", at(10, 14, "10:00"), false, at(10, 16, "18:00")},
+		{"mon-fri,18:00-23:00", "10-15", at(10, 14, "23:30"), false, at(10, 16, "18:00")},
+		// never within the maximum postponement
+		{"", "01-01..12-31", at(10, 14, "10:00"), false, time.Time{}},
+	} {
+		comment := Commentf("#%d: %q %q at %s", i, t.window, t.blackout, t.t)
+		c.Check(snapstate.RefreshWindowAllows(t.window, t.blackout, t.t), Equals, t.allows, comment)
+		next := snapstate.RefreshWindowNextAllowed(t.window, t.blackout, t.t)
+		c.Check(next.Equal(t.next), Equals, true, Commentf("#%d: got %s expected %s", i, next, t.next))
+	}
+}
+
+// clockWindow returns a maintenance window of the given duration starting
+// after the given delay from now.
+func clockWindow(delay, duration time.Duration) (window string, start time.Time) {
+	start = time.Now().Add(delay).Truncate(time.Minute)
+	end := start.Add(duration)
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", start.Hour(), start.Minute(), end.Hour(), end.Minute()), start
+}
+
+func (s *autoRefreshTestSuite) TestRefreshWindowClosedPostponesRefresh(c *C) {
+	window, start := clockWindow(2*time.Hour, time.Hour)
+
+	s.state.Lock()
+	s.state.Set("last-refresh", time.Now().Add(-12*time.Hour))
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.maintenance-window", window)
+	tr.Commit()
+	s.state.Unlock()
+
+	af := snapstate.NewAutoRefresh(s.state)
+	err := af.Ensure()
+	c.Check(err, IsNil)
+
+	// no refresh, postponed until the window opens
+	c.Check(s.store.ops, HasLen, 0)
+	c.Check(af.NextRefresh().Equal(start), Equals, true, Commentf("%s != %s", af.NextRefresh(), start))
+}
+
+func (s *autoRefreshTestSuite) TestRefreshBlackoutPostponesRefresh(c *C) {
+	lastRefresh := time.Now().Add(-12 * time.Hour)
+	s.state.Lock()
+	s.state.Set("last-refresh", lastRefresh)
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.blackout", "01-01..12-31")
+	tr.Commit()
+	s.state.Unlock()
+
+	af := snapstate.NewAutoRefresh(s.state)
+	err := af.Ensure()
+	c.Check(err, IsNil)
+
+	// no refresh, and the blackout never ends so refresh when overdue
+	c.Check(s.store.ops, HasLen, 0)
+	c.Check(af.NextRefresh().Equal(lastRefresh.Add(snapstate.MaxPostponement)), Equals, true)
+}
+
+func (s *autoRefreshTestSuite) TestRefreshBlackoutOverdue(c *C) {
+	s.addRefreshableSnap("foo")
+
+	s.state.Lock()
+	s.state.Set("last-refresh", time.Now().Add(-snapstate.MaxPostponement-time.Hour))
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.blackout", "01-01..12-31")
+	tr.Commit()
+
+	// foo is overdue
+	var snapst snapstate.SnapState
+	c.Assert(snapstate.Get(s.state, "foo", &snapst), IsNil)
+	lastFooRefresh := time.Now().Add(-snapstate.MaxPostponement - time.Hour)
+	snapst.LastRefreshTime = &lastFooRefresh
+	snapstate.Set(s.state, "foo", &snapst)
+	s.state.Unlock()
+
+	af := snapstate.NewAutoRefresh(s.state)
+	err := af.Ensure()
+	c.Check(err, IsNil)
+	c.Check(s.store.ops, DeepEquals, []string{"list-refresh"})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+	chgs := s.state.Changes()
+	c.Assert(chgs, HasLen, 1)
+	var snapNames []string
+	c.Assert(chgs[0].Get("snap-names", &snapNames), IsNil)
+	c.Check(snapNames, DeepEquals, []string{"foo"})
+}
+
+func (s *autoRefreshTestSuite) TestRefreshWindowPerSnap(c *C) {
+	s.addRefreshableSnap("foo", "bar")
+	window, start := clockWindow(2*time.Hour, time.Hour)
+
+	s.state.Lock()
+	s.state.Set("last-refresh", time.Now().Add(-12*time.Hour))
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.maintenance-window", window)
+	tr.Set("core", "refresh.snaps.foo.maintenance-window", "00:00-24:00")
+	tr.Commit()
+	s.state.Unlock()
+
+	af := snapstate.NewAutoRefresh(s.state)
+	err := af.Ensure()
+	c.Check(err, IsNil)
+	c.Check(s.store.ops, DeepEquals, []string{"list-refresh"})
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	// only foo is refreshed
+	chgs := s.state.Changes()
+	c.Assert(chgs, HasLen, 1)
+	var snapNames []string
+	c.Assert(chgs[0].Get("snap-names", &snapNames), IsNil)
+	c.Check(snapNames, DeepEquals, []string{"foo"})
+
+	// bar is attempted again when the system window opens
+	deferred := snapstate.DeferredRefresh(s.state)
+	c.Check(deferred.Equal(start), Equals, true, Commentf("%s != %s", deferred, start))
+}
+
+func (s *autoRefreshTestSuite) TestRefreshWindowPerSnapBlackout(c *C) {
+	s.addRefreshableSnap("foo", "bar")
+
+	s.state.Lock()
+	s.state.Set("last-refresh", time.Now().Add(-12*time.Hour))
+	tr := config.NewTransaction(s.state)
+	tr.Set("core", "refresh.snaps.foo.blackout", "01-01..12-31")
+	tr.Commit()
+	s.state.Unlock()
+
+	af := snapstate.NewAutoRefresh(s.state)
+	err := af.Ensure()
+	c.Check(err, IsNil)
+
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	chgs := s.state.Changes()
+	c.Assert(chgs, HasLen, 1)
+	var snapNames []string
+	c.Assert(chgs[0].Get("snap-names", &snapNames), IsNil)
+	c.Check(snapNames, DeepEquals, []string{"bar"})
+	c.Check(snapstate.DeferredRefresh(s.state).IsZero(), Equals, true)
+
+	// foo is not a refresh candidate either
+	var candidates map[string]*snapstate.RefreshCandidate
+	c.Assert(s.state.Get("refresh-candidates", &candidates), IsNil)
+	c.Check(candidates, HasLen, 1)
+	c.Check(candidates["bar"], NotNil)
+}
+
+func (s *refreshWindowSuite) TestRefreshOverdue(c *C) {
+	// snaps never refreshed are not overdue
+	c.Check(snapstate.RefreshOverdue(&snapstate.SnapState{}, time.Now()), Equals, false)
+	old := time.Now().Add(-snapstate.MaxPostponement)
+	c.Check(snapstate.RefreshOverdue(&snapstate.SnapState{LastRefreshTime: &old}, time.Now()), Equals, true)
+}
@@ -32,6 +32,7 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/dirs"
+	"github.com/snapcore/snapd/httputil"
 	"github.com/snapcore/snapd/i18n"
 	"github.com/snapcore/snapd/logger"
 	"github.com/snapcore/snapd/osutil"
@@ -57,6 +58,32 @@ var (
 	snapdTransitionDelayWithRandomness = 3*time.Hour + randutil.RandomDuration(4*time.Hour)
 )
 
+// StoreRetryPolicy is the retry policy shared by the tasks that download
+// from or otherwise talk to the store, so that they are retried consistently
+// when they fail because of transient network or store errors.
+var StoreRetryPolicy = state.RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     30 * time.Second,
+	MaxBackoff:  5 * time.Minute,
+	Jitter:      0.2,
+	RetryOn:     IsTransientStoreError,
+}
+
+// IsTransientStoreError returns whether the error, or any error it wraps, is
+// a transient network error or a server error from the store, after which
+// it's sensible to retry.
+func IsTransientStoreError(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if httputil.ShouldRetryError(err) {
+			return true
+		}
+		if dlErr, ok := err.(*store.DownloadError); ok && dlErr.Code >= 500 {
+			return true
+		}
+	}
+	return false
+}
+
 // SnapManager is responsible for the installation and removal of snaps.
 type SnapManager struct {
 	state   *state.State
@@ -65,6 +92,7 @@ type SnapManager struct {
 	autoRefresh    *autoRefresh
 	refreshHints   *refreshHints
 	catalogRefresh *catalogRefresh
+	peerCache      *peerCache
 
 	preseed bool
 
@@ -763,6 +791,7 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 		autoRefresh:                newAutoRefresh(st),
 		refreshHints:               newRefreshHints(st),
 		catalogRefresh:             newCatalogRefresh(st),
+		peerCache:                  newPeerCache(st),
 		preseed:                    preseed,
 		ensuredMountsUpdated:       false,
 		ensuredDesktopFilesUpdated: false,
@@ -793,7 +822,7 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 	// remove anything that is not referenced anymore
 	runner.AddHandler("prerequisites", m.doPrerequisites, nil)
 	runner.AddHandler("prepare-snap", m.doPrepareSnap, m.undoPrepareSnap)
-	runner.AddHandler("download-snap", m.doDownloadSnap, m.undoPrepareSnap)
+	runner.AddHandler("download-snap", m.doDownloadSnap, m.undoPrepareSnap, state.WithRetryPolicy(StoreRetryPolicy))
 	runner.AddHandler("mount-snap", m.doMountSnap, m.undoMountSnap)
 	runner.AddHandler("unlink-current-snap", m.doUnlinkCurrentSnap, m.undoUnlinkCurrentSnap)
 	runner.AddHandler("copy-snap-data", m.doCopySnapData, m.undoCopySnapData)
@@ -838,11 +867,11 @@ func Manager(st *state.State, runner *state.TaskRunner) (*SnapManager, error) {
 	runner.AddHandler("migrate-snap-home", m.doMigrateSnapHome, m.undoMigrateSnapHome)
 	// no undo for now since it's last task in valset auto-resolution change
 	runner.AddHandler("enforce-validation-sets", m.doEnforceValidationSets, nil)
-	runner.AddHandler("pre-download-snap", m.doPreDownloadSnap, nil)
+	runner.AddHandler("pre-download-snap", m.doPreDownloadSnap, nil, state.WithRetryPolicy(StoreRetryPolicy))
 
 	// component tasks
 	runner.AddHandler("prepare-component", m.doPrepareComponent, nil)
-	runner.AddHandler("download-component", m.doDownloadComponent, nil)
+	runner.AddHandler("download-component", m.doDownloadComponent, nil, state.WithRetryPolicy(StoreRetryPolicy))
 	runner.AddHandler("mount-component", m.doMountComponent, m.undoMountComponent)
 	runner.AddHandler("unlink-current-component", m.doUnlinkCurrentComponent, m.undoUnlinkCurrentComponent)
 	runner.AddHandler("link-component", m.doLinkComponent, m.undoLinkComponent)
@@ -883,8 +912,10 @@ func (m *SnapManager) StartUp() error {
 }
 
 // Stop implements StateStopper. It will unregister the change callback
-// handler from state.
+// handler from state and stop sharing downloaded snaps with peers.
 func (m *SnapManager) Stop() {
+	m.peerCache.Stop()
+
 	st := m.state
 	st.Lock()
 	defer st.Unlock()
@@ -954,6 +985,13 @@ func (m *SnapManager) LastRefresh() (time.Time, error) {
 	return m.autoRefresh.LastRefresh()
 }
 
+// RefreshWindows returns the status of the refresh window of the system,
+// nil if there is none, and of the snaps with their own.
+// The caller should be holding the state lock.
+func (m *SnapManager) RefreshWindows() (system *RefreshWindowStatus, snaps map[string]*RefreshWindowStatus, err error) {
+	return refreshWindowsStatus(m.state)
+}
+
 // RefreshSchedule returns the current refresh schedule as a string suitable for
 // display to a user and a flag indicating whether the schedule is a legacy one.
 // The caller should be holding the state lock.
@@ -1594,6 +1632,7 @@ func (m *SnapManager) Ensure() error {
 		m.autoRefresh.Ensure(),
 		m.refreshHints.Ensure(),
 		m.catalogRefresh.Ensure(),
+		m.peerCache.Ensure(),
 		m.localInstallCleanup(),
 		m.ensureVulnerableSnapConfineVersionsRemovedOnClassic(),
 		m.ensureMountsUpdated(),
@@ -2635,6 +2635,9 @@ func autoRefreshPhase1(ctx context.Context, st *state.State, forGatingSnap strin
 		// of errors?
 		return nil, nil, err
 	}
+	if err := plan.filterOutsideRefreshWindows(st); err != nil {
+		return nil, nil, err
+	}
 	deviceCtx, err := DeviceCtxFromState(st, nil)
 	if err != nil {
 		return nil, nil, err
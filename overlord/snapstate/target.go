@@ -1012,6 +1012,12 @@ func UpdateWithGoal(ctx context.Context, st *state.State, goal UpdateGoal, filte
 		return nil, nil, err
 	}
 
+	if opts.Flags.IsAutoRefresh && plan.refreshAll() {
+		if err := plan.filterOutsideRefreshWindows(st); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// save the candidates so the auto-refresh can be continued if it's inhibited
 	// by a running snap.
 	if opts.Flags.IsAutoRefresh {
@@ -0,0 +1,230 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+// A DeltaBackend is a Backend that persists only the entries of the state
+// that changed since the last checkpoint, instead of the whole state.
+type DeltaBackend interface {
+	Backend
+	// CheckpointDelta persists the changes in the delta. The full function
+	// returns the serialized state (in the same format as the data passed
+	// to Checkpoint) and can be used to compact the persisted changes.
+	CheckpointDelta(delta *Delta, full func() []byte) error
+}
+
+// Delta holds the entries of the state that were modified or removed since
+// the last checkpoint. Each change, task, warning, notice and data entry is a
+// separate entry, keyed by its kind and identifier (e.g. "task/12").
+type Delta struct {
+	Set     map[string]json.RawMessage `json:"set,omitempty"`
+	Removed []string                   `json:"removed,omitempty"`
+}
+
+// Empty returns whether the delta has no changes.
+func (d *Delta) Empty() bool {
+	return len(d.Set) == 0 && len(d.Removed) == 0
+}
+
+// Apply applies the changes in the delta to the entries.
+func (d *Delta) Apply(entries Entries) {
+	for _, key := range d.Removed {
+		delete(entries, key)
+	}
+	for key, value := range d.Set {
+		entries[key] = value
+	}
+}
+
+// Entries maps the keys of the separately serialized entries of the state to
+// their values.
+type Entries map[string]json.RawMessage
+
+const (
+	dataEntryPrefix    = "data/"
+	changeEntryPrefix  = "change/"
+	taskEntryPrefix    = "task/"
+	warningEntryPrefix = "warning/"
+	noticeEntryPrefix  = "notice/"
+	metaEntryKey       = "meta"
+)
+
+type stateMeta struct {
+	LastChangeId int `json:"last-change-id"`
+	LastTaskId   int `json:"last-task-id"`
+	LastLaneId   int `json:"last-lane-id"`
+	LastNoticeId int `json:"last-notice-id"`
+
+	LastNoticeTimestamp time.Time `json:"last-notice-timestamp,omitempty"`
+}
+
+// rawState has the same format as marshalledState but keeps the values
+// serialized.
+type rawState struct {
+	Data     map[string]json.RawMessage `json:"data"`
+	Changes  map[string]json.RawMessage `json:"changes"`
+	Tasks    map[string]json.RawMessage `json:"tasks"`
+	Warnings []json.RawMessage          `json:"warnings,omitempty"`
+	Notices  []json.RawMessage          `json:"notices,omitempty"`
+
+	stateMeta
+}
+
+func mustMarshal(kind string, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// this shouldn't happen, because the actual delicate serializing happens at various Set()s
+		logger.Panicf("internal error: could not marshal %s for checkpointing: %v", kind, err)
+	}
+	return data
+}
+
+// checkpointEntries returns the entries of the state, each serialized
+// separately.
+func (s *State) checkpointEntries() Entries {
+	entries := make(Entries, len(s.data)+len(s.changes)+len(s.tasks)+len(s.warnings)+len(s.notices)+1)
+	for key, value := range s.data {
+		entries[dataEntryPrefix+key] = *value
+	}
+	for id, chg := range s.changes {
+		entries[changeEntryPrefix+id] = mustMarshal("change", chg)
+	}
+	for id, t := range s.tasks {
+		entries[taskEntryPrefix+id] = mustMarshal("task", t)
+	}
+	for _, w := range s.flattenWarnings() {
+		entries[warningEntryPrefix+w.message] = mustMarshal("warning", w)
+	}
+	for _, n := range s.flattenNotices(nil) {
+		entries[noticeEntryPrefix+n.id] = mustMarshal("notice", n)
+	}
+	entries[metaEntryKey] = mustMarshal("state", stateMeta{
+		LastChangeId:        s.lastChangeId,
+		LastTaskId:          s.lastTaskId,
+		LastLaneId:          s.lastLaneId,
+		LastNoticeId:        s.lastNoticeId,
+		LastNoticeTimestamp: s.lastNoticeTimestamp,
+	})
+	return entries
+}
+
+// deltaBetween returns the changes needed to turn the old entries into the
+// new ones.
+func deltaBetween(old, new Entries) *Delta {
+	delta := &Delta{Set: make(map[string]json.RawMessage)}
+	for key, value := range new {
+		if oldValue, ok := old[key]; !ok || !bytes.Equal(oldValue, value) {
+			delta.Set[key] = value
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			delta.Removed = append(delta.Removed, key)
+		}
+	}
+	sort.Strings(delta.Removed)
+	return delta
+}
+
+// EntriesFromJSON splits the serialized state into its separate entries.
+func EntriesFromJSON(data []byte) (Entries, error) {
+	var raw rawState
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cannot read state: %v", err)
+	}
+
+	entries := make(Entries, len(raw.Data)+len(raw.Changes)+len(raw.Tasks)+len(raw.Warnings)+len(raw.Notices)+1)
+	for key, value := range raw.Data {
+		entries[dataEntryPrefix+key] = value
+	}
+	for id, chg := range raw.Changes {
+		entries[changeEntryPrefix+id] = chg
+	}
+	for id, t := range raw.Tasks {
+		entries[taskEntryPrefix+id] = t
+	}
+	for _, w := range raw.Warnings {
+		var jw jsonWarning
+		if err := json.Unmarshal(w, &jw); err != nil {
+			return nil, fmt.Errorf("cannot read state warning: %v", err)
+		}
+		entries[warningEntryPrefix+jw.Message] = w
+	}
+	for _, n := range raw.Notices {
+		var jn jsonNotice
+		if err := json.Unmarshal(n, &jn); err != nil {
+			return nil, fmt.Errorf("cannot read state notice: %v", err)
+		}
+		entries[noticeEntryPrefix+jn.ID] = n
+	}
+	entries[metaEntryKey] = mustMarshal("state", raw.stateMeta)
+	return entries, nil
+}
+
+// JSON returns the state made of the entries, serialized in the same format
+// used to checkpoint it whole.
+func (entries Entries) JSON() ([]byte, error) {
+	raw := rawState{
+		Data:    make(map[string]json.RawMessage),
+		Changes: make(map[string]json.RawMessage),
+		Tasks:   make(map[string]json.RawMessage),
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	// keep warnings and notices in a stable order
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := entries[key]
+		switch {
+		case key == metaEntryKey:
+			if err := json.Unmarshal(value, &raw.stateMeta); err != nil {
+				return nil, fmt.Errorf("cannot read state entry %q: %v", key, err)
+			}
+		case strings.HasPrefix(key, dataEntryPrefix):
+			raw.Data[strings.TrimPrefix(key, dataEntryPrefix)] = value
+		case strings.HasPrefix(key, changeEntryPrefix):
+			raw.Changes[strings.TrimPrefix(key, changeEntryPrefix)] = value
+		case strings.HasPrefix(key, taskEntryPrefix):
+			raw.Tasks[strings.TrimPrefix(key, taskEntryPrefix)] = value
+		case strings.HasPrefix(key, warningEntryPrefix):
+			raw.Warnings = append(raw.Warnings, value)
+		case strings.HasPrefix(key, noticeEntryPrefix):
+			raw.Notices = append(raw.Notices, value)
+		default:
+			return nil, fmt.Errorf("cannot read state: unknown entry %q", key)
+		}
+	}
+
+	return json.Marshal(raw)
+}
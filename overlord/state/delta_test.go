@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package state_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/overlord/state"
+)
+
+type deltaSuite struct{}
+
+var _ = Suite(&deltaSuite{})
+
+type fakeDeltaBackend struct {
+	fakeStateBackend
+
+	deltas []*state.Delta
+	fulls  [][]byte
+	error  error
+}
+
+func (b *fakeDeltaBackend) CheckpointDelta(delta *state.Delta, full func() []byte) error {
+	if b.error != nil {
+		return b.error
+	}
+	b.deltas = append(b.deltas, delta)
+	b.fulls = append(b.fulls, full())
+	return nil
+}
+
+func deltaKeys(delta *state.Delta) []string {
+	keys := make([]string, 0, len(delta.Set))
+	for key := range delta.Set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (ds *deltaSuite) TestCheckpointDelta(c *C) {
+	b := new(fakeDeltaBackend)
+	st := state.New(b)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Set("baz", 1)
+	chg := st.NewChange("some-change", "...")
+	t := st.NewTask("some-task", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	c.Assert(b.deltas, HasLen, 1)
+	c.Check(b.checkpoints, HasLen, 0)
+	// adding the change also recorded a change-update notice
+	c.Check(deltaKeys(b.deltas[0]), DeepEquals, []string{"change/1", "data/baz", "data/foo", "meta", "notice/1", "task/1"})
+	c.Check(b.deltas[0].Removed, HasLen, 0)
+
+	// only the modified entries are checkpointed
+	st.Lock()
+	st.Set("foo", "other")
+	st.Set("baz", nil)
+	st.Unlock()
+
+	c.Assert(b.deltas, HasLen, 2)
+	c.Check(deltaKeys(b.deltas[1]), DeepEquals, []string{"data/foo"})
+	c.Check(string(b.deltas[1].Set["data/foo"]), Equals, `"other"`)
+	c.Check(b.deltas[1].Removed, DeepEquals, []string{"data/baz"})
+
+	st.Lock()
+	t.SetStatus(state.DoingStatus)
+	st.Unlock()
+
+	c.Assert(b.deltas, HasLen, 3)
+	c.Check(b.deltas[2].Set["task/1"], NotNil)
+	c.Check(b.deltas[2].Set["data/foo"], IsNil)
+
+	// setting the same values doesn't checkpoint anything
+	st.Lock()
+	st.Set("foo", "other")
+	st.Unlock()
+	c.Check(b.deltas, HasLen, 3)
+
+	// the full state can be read back
+	st2, err := state.ReadState(nil, bytes.NewReader(b.fulls[2]))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+
+	var foo string
+	c.Assert(st2.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "other")
+	c.Check(st2.Get("baz", new(int)), ErrorMatches, `no state entry for key "baz"`)
+	c.Assert(st2.Tasks(), HasLen, 1)
+	c.Check(st2.Tasks()[0].Status(), Equals, state.DoingStatus)
+}
+
+func (ds *deltaSuite) TestCheckpointDeltaFailureRetried(c *C) {
+	restore := state.MockCheckpointRetryDelay(time.Millisecond, 20*time.Millisecond)
+	defer restore()
+
+	b := &fakeDeltaBackend{error: errors.New("boom")}
+	st := state.New(b)
+
+	st.Lock()
+	st.Set("foo", "bar")
+	c.Check(func() { st.Unlock() }, Panics, "cannot checkpoint even after 20ms of retries every 1ms: boom")
+
+	b.error = nil
+	st.Lock()
+	st.Set("baz", "qux")
+	st.Unlock()
+
+	// the failed changes are checkpointed as well
+	c.Assert(b.deltas, HasLen, 1)
+	c.Check(deltaKeys(b.deltas[0]), DeepEquals, []string{"data/baz", "data/foo", "meta"})
+}
+
+func (ds *deltaSuite) TestReadStateSeedsCheckpointedEntries(c *C) {
+	b := new(fakeStateBackend)
+	st := state.New(b)
+	st.Lock()
+	st.Set("foo", "bar")
+	st.Warnf("some warning")
+	st.Unlock()
+	c.Assert(b.checkpoints, HasLen, 1)
+
+	db := new(fakeDeltaBackend)
+	st2, err := state.ReadState(db, bytes.NewReader(b.checkpoints[0]))
+	c.Assert(err, IsNil)
+
+	st2.Lock()
+	st2.Set("other", "value")
+	st2.Unlock()
+
+	c.Assert(db.deltas, HasLen, 1)
+	c.Check(deltaKeys(db.deltas[0]), DeepEquals, []string{"data/other"})
+}
+
+func (ds *deltaSuite) TestEntriesRoundTrip(c *C) {
+	b := new(fakeStateBackend)
+	st := state.New(b)
+	st.Lock()
+	st.Set("foo", "bar")
+	chg := st.NewChange("some-change", "...")
+	chg.AddTask(st.NewTask("some-task", "..."))
+	st.Warnf("some warning")
+	_, err := st.AddNotice(nil, state.ChangeUpdateNotice, "1", nil)
+	c.Assert(err, IsNil)
+	st.Unlock()
+
+	entries, err := state.EntriesFromJSON(b.checkpoints[len(b.checkpoints)-1])
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 6)
+	for _, key := range []string{"data/foo", "change/1", "task/1", "warning/some warning", "notice/1", "meta"} {
+		c.Check(entries[key], NotNil, Commentf("missing %s", key))
+	}
+
+	delta := &state.Delta{
+		Set:     map[string]json.RawMessage{"data/foo": json.RawMessage(`"baz"`)},
+		Removed: []string{"warning/some warning"},
+	}
+	delta.Apply(entries)
+
+	data, err := entries.JSON()
+	c.Assert(err, IsNil)
+
+	st2, err := state.ReadState(nil, bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	st2.Lock()
+	defer st2.Unlock()
+
+	var foo string
+	c.Assert(st2.Get("foo", &foo), IsNil)
+	c.Check(foo, Equals, "baz")
+	c.Check(st2.Changes(), HasLen, 1)
+	c.Check(st2.Tasks(), HasLen, 1)
+	c.Check(st2.AllWarnings(), HasLen, 0)
+	c.Check(st2.Notices(nil), HasLen, 1)
+	c.Check(st2.NewChange("other", "...").ID(), Equals, "2")
+}
+
+func (ds *deltaSuite) TestEntriesJSONUnknownEntry(c *C) {
+	entries := state.Entries{"foo/bar": json.RawMessage(`{}`)}
+	_, err := entries.JSON()
+	c.Check(err, ErrorMatches, `cannot read state: unknown entry "foo/bar"`)
+}
@@ -57,6 +57,14 @@ func (t *Task) AccumulateUndoingTime(duration time.Duration) {
 	t.accumulateUndoingTime(duration)
 }
 
+func (t *Task) AddRetry(undoing bool) {
+	t.addRetry(undoing)
+}
+
+func (p *RetryPolicy) RetryAfter(retries int) time.Duration {
+	return p.retryAfter(retries)
+}
+
 var (
 	DefaultWarningExpireAfter = defaultWarningExpireAfter
 	DefaultWarningRepeatAfter = defaultWarningRepeatAfter
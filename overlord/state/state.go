@@ -103,6 +103,9 @@ type State struct {
 	noticeCond *sync.Cond
 
 	modified bool
+	// checkpointed holds the entries persisted by the last checkpoint when
+	// the backend is a DeltaBackend
+	checkpointed Entries
 
 	cache map[interface{}]interface{}
 
@@ -258,11 +261,37 @@ func (s *State) Unlock() {
 		return
 	}
 
-	data := s.checkpointData()
+	var checkpoint func() error
+	if db, ok := s.backend.(DeltaBackend); ok {
+		entries := s.checkpointEntries()
+		delta := deltaBetween(s.checkpointed, entries)
+		full := func() []byte {
+			data, err := entries.JSON()
+			if err != nil {
+				logger.Panicf("internal error: could not marshal state for checkpointing: %v", err)
+			}
+			return data
+		}
+		checkpoint = func() error {
+			if !delta.Empty() {
+				if err := db.CheckpointDelta(delta, full); err != nil {
+					return err
+				}
+			}
+			s.checkpointed = entries
+			return nil
+		}
+	} else {
+		data := s.checkpointData()
+		checkpoint = func() error {
+			return s.backend.Checkpoint(data)
+		}
+	}
+
 	var err error
 	start := time.Now()
 	for time.Since(start) <= unlockCheckpointRetryMaxTime {
-		if err = s.backend.Checkpoint(data); err == nil {
+		if err = checkpoint(); err == nil {
 			s.modified = false
 			return
 		}
@@ -602,6 +631,12 @@ func ReadState(backend Backend, r io.Reader) (*State, error) {
 		return nil, fmt.Errorf("cannot read state: %s", err)
 	}
 	s.backend = backend
+	if _, ok := backend.(DeltaBackend); ok {
+		// the read state is what's persisted so far
+		s.checkpointed = s.checkpointEntries()
+	} else {
+		s.checkpointed = make(Entries)
+	}
 	s.noticeCond = sync.NewCond(s)
 	s.modified = false
 	s.cache = make(map[interface{}]interface{})
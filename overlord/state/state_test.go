@@ -577,6 +577,7 @@ func (ss *stateSuite) TestEmptyStateDataAndCheckpointReadAndSet(c *C) {
 		"tasks",
 		"warnings",
 		"notices",
+		"checkpointed",
 		"cache",
 		"pendingChangeByAttr",
 		"taskHandlers",
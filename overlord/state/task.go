@@ -59,11 +59,15 @@ type Task struct {
 	readyTime time.Time
 
 	// TODO: add:
-	// {,Un}DoingRetries - number of retries
 	// Retry{,Un}DoingTimes - time spend to figure out a retry is needed
 	doingTime   time.Duration
 	undoingTime time.Duration
 
+	// {,un}doingRetries count the failed runs of the handlers that were
+	// retried according to the retry policy of the task kind
+	doingRetries   int
+	undoingRetries int
+
 	atTime time.Time
 }
 
@@ -100,6 +104,9 @@ type marshalledTask struct {
 	DoingTime   time.Duration `json:"doing-time,omitempty"`
 	UndoingTime time.Duration `json:"undoing-time,omitempty"`
 
+	DoingRetries   int `json:"doing-retries,omitempty"`
+	UndoingRetries int `json:"undoing-retries,omitempty"`
+
 	AtTime *time.Time `json:"at-time,omitempty"`
 }
 
@@ -135,6 +142,9 @@ func (t *Task) MarshalJSON() ([]byte, error) {
 		DoingTime:   t.doingTime,
 		UndoingTime: t.undoingTime,
 
+		DoingRetries:   t.doingRetries,
+		UndoingRetries: t.undoingRetries,
+
 		AtTime: atTime,
 	})
 }
@@ -181,6 +191,8 @@ func (t *Task) UnmarshalJSON(data []byte) error {
 	}
 	t.doingTime = unmarshalled.DoingTime
 	t.undoingTime = unmarshalled.UndoingTime
+	t.doingRetries = unmarshalled.DoingRetries
+	t.undoingRetries = unmarshalled.UndoingRetries
 	return nil
 }
 
@@ -405,6 +417,36 @@ func (t *Task) UndoingTime() time.Duration {
 	return t.undoingTime
 }
 
+// DoingRetries returns how many times running the do handler of the task
+// failed and was retried according to the retry policy of its kind.
+func (t *Task) DoingRetries() int {
+	t.state.reading()
+	return t.doingRetries
+}
+
+// UndoingRetries returns how many times running the undo handler of the task
+// failed and was retried according to the retry policy of its kind.
+func (t *Task) UndoingRetries() int {
+	t.state.reading()
+	return t.undoingRetries
+}
+
+func (t *Task) retries(undoing bool) int {
+	if undoing {
+		return t.undoingRetries
+	}
+	return t.doingRetries
+}
+
+func (t *Task) addRetry(undoing bool) {
+	t.state.writing()
+	if undoing {
+		t.undoingRetries++
+	} else {
+		t.doingRetries++
+	}
+}
+
 const (
 	// Messages logged in tasks are guaranteed to use the time formatted
 	// per RFC3339 plus the following strings as a prefix, so these may
@@ -334,6 +334,25 @@ func (ts *taskSuite) TestTaskMarshalsDoingUndoingTime(c *C) {
 	c.Assert(string(d), testutil.Contains, `"undoing-time":654321`)
 }
 
+func (ts *taskSuite) TestTaskMarshalsRetries(c *C) {
+	st := state.New(nil)
+	st.Lock()
+	defer st.Unlock()
+
+	t := st.NewTask("download", "1...")
+	t.AddRetry(false)
+	t.AddRetry(false)
+	t.AddRetry(true)
+	c.Check(t.DoingRetries(), Equals, 2)
+	c.Check(t.UndoingRetries(), Equals, 1)
+
+	d, err := t.MarshalJSON()
+	c.Assert(err, IsNil)
+
+	c.Assert(string(d), testutil.Contains, `"doing-retries":2`)
+	c.Assert(string(d), testutil.Contains, `"undoing-retries":1`)
+}
+
 func (ts *taskSuite) TestTaskWaitFor(c *C) {
 	st := state.New(nil)
 	st.Lock()
@@ -466,6 +485,7 @@ func (cs *taskSuite) TestMethodEntrance(c *C) {
 		func() { t1.JoinLane(1) },
 		func() { t1.AccumulateDoingTime(1) },
 		func() { t1.AccumulateUndoingTime(2) },
+		func() { t1.AddRetry(false) },
 	}
 
 	reads := []func(){
@@ -482,6 +502,8 @@ func (cs *taskSuite) TestMethodEntrance(c *C) {
 		func() { t1.Lanes() },
 		func() { t1.DoingTime() },
 		func() { t1.UndoingTime() },
+		func() { t1.DoingRetries() },
+		func() { t1.UndoingRetries() },
 	}
 
 	for i, f := range reads {
@@ -20,12 +20,14 @@
 package state
 
 import (
+	"math"
 	"sync"
 	"time"
 
 	"gopkg.in/tomb.v2"
 
 	"github.com/snapcore/snapd/logger"
+	"github.com/snapcore/snapd/randutil"
 )
 
 // HandlerFunc is the type of function for the handlers
@@ -63,6 +65,65 @@ func (r *Wait) Error() string {
 	return "task set to wait, manual action required"
 }
 
+// RetryPolicy controls how tasks of a kind are retried when their handlers
+// fail, instead of putting the task in ErrorStatus right away.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a handler is run before
+	// the task errors out. Zero means retrying indefinitely.
+	MaxAttempts int
+	// Backoff is how long to wait before the first retry. The wait is
+	// doubled for every further retry, up to MaxBackoff if set, and
+	// otherwise as long as it doesn't overflow.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the wait by which a retry is randomly
+	// postponed further, so that many tasks failing at the same time
+	// don't retry at the same time.
+	Jitter float64
+	// RetryOn returns whether the error returned by a handler should be
+	// retried. If nil, all errors are retried.
+	RetryOn func(err error) bool
+}
+
+// retryAfter returns how long to wait before retrying a task that was
+// already retried the given number of times.
+func (p *RetryPolicy) retryAfter(retries int) time.Duration {
+	after := p.Backoff
+	for i := 0; i < retries; i++ {
+		if p.MaxBackoff > 0 && after >= p.MaxBackoff {
+			break
+		}
+		if after > math.MaxInt64/2 {
+			break
+		}
+		after *= 2
+	}
+	if p.MaxBackoff > 0 && after > p.MaxBackoff {
+		after = p.MaxBackoff
+	}
+	if jitter := time.Duration(float64(after) * p.Jitter); jitter > 0 {
+		if jitter > math.MaxInt64-after {
+			jitter = math.MaxInt64 - after
+		}
+		after += randutil.RandomDuration(jitter)
+	}
+	return after
+}
+
+// HandlerOption sets optional behavior for running the tasks of a kind,
+// see AddHandler.
+type HandlerOption func(h *handlerPair)
+
+// WithRetryPolicy sets the policy for retrying the tasks of a kind when their
+// do or undo handlers fail. The retries of each handler are counted
+// separately. Handlers can still return a *Retry to be retried regardless of
+// the policy.
+func WithRetryPolicy(policy RetryPolicy) HandlerOption {
+	return func(h *handlerPair) {
+		h.retry = &policy
+	}
+}
+
 type blockedFunc func(t *Task, running []*Task) bool
 
 // TaskRunner controls the running of goroutines to execute known task kinds.
@@ -88,6 +149,7 @@ type TaskRunner struct {
 
 type handlerPair struct {
 	do, undo HandlerFunc
+	retry    *RetryPolicy
 }
 
 type optionalHandler struct {
@@ -112,17 +174,21 @@ func (r *TaskRunner) OnTaskError(f func(err error)) {
 
 // AddHandler registers the functions to concurrently call for doing and
 // undoing tasks of the given kind. The undo handler may be nil.
-func (r *TaskRunner) AddHandler(kind string, do, undo HandlerFunc) {
+func (r *TaskRunner) AddHandler(kind string, do, undo HandlerFunc, opts ...HandlerOption) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.handlers[kind] = handlerPair{do, undo}
+	handler := handlerPair{do: do, undo: undo}
+	for _, opt := range opts {
+		opt(&handler)
+	}
+	r.handlers[kind] = handler
 }
 
 // AddOptionalHandler register functions for doing and undoing tasks that match
 // the given predicate if no explicit handler was registered for the task kind.
 func (r *TaskRunner) AddOptionalHandler(match func(t *Task) bool, do, undo HandlerFunc) {
-	r.optional = append(r.optional, optionalHandler{match, handlerPair{do, undo}})
+	r.optional = append(r.optional, optionalHandler{match, handlerPair{do: do, undo: undo}})
 }
 
 func (r *TaskRunner) handlerPair(t *Task) handlerPair {
@@ -187,6 +253,7 @@ func (r *TaskRunner) AddBlocked(pred func(t *Task, running []*Task) bool) {
 func (r *TaskRunner) run(t *Task) {
 	var handler HandlerFunc
 	var accuRuntime func(dur time.Duration)
+	var undoing bool
 	switch t.Status() {
 	case DoStatus:
 		t.SetStatus(DoingStatus)
@@ -201,6 +268,7 @@ func (r *TaskRunner) run(t *Task) {
 	case UndoingStatus:
 		handler = r.handlerPair(t).undo
 		accuRuntime = t.accumulateUndoingTime
+		undoing = true
 
 	default:
 		panic("internal error: attempted to run task in status " + t.Status().String())
@@ -246,6 +314,8 @@ func (r *TaskRunner) run(t *Task) {
 				// we are shutting down, errors might be due
 				// to cancellations, to be safe retry
 				err = &Retry{}
+			} else if retry := r.policyRetry(t, undoing, err); retry != nil {
+				err = retry
 			}
 		}
 
@@ -306,6 +376,30 @@ func (r *TaskRunner) run(t *Task) {
 	})
 }
 
+// policyRetry returns a *Retry if the error returned by a handler of the task
+// should be retried according to the retry policy of its kind, and records
+// the retry in the task.
+func (r *TaskRunner) policyRetry(t *Task, undoing bool, err error) *Retry {
+	policy := r.handlerPair(t).retry
+	if policy == nil || t.Status() == AbortStatus {
+		return nil
+	}
+	if policy.RetryOn != nil && !policy.RetryOn(err) {
+		return nil
+	}
+
+	retries := t.retries(undoing)
+	if policy.MaxAttempts > 0 && retries+1 >= policy.MaxAttempts {
+		t.Logf("giving up after %d attempts", retries+1)
+		return nil
+	}
+
+	after := policy.retryAfter(retries)
+	t.addRetry(undoing)
+	t.Logf("attempt %d failed, will retry in %s: %v", retries+1, after.Round(time.Second), err)
+	return &Retry{After: after, Reason: err.Error()}
+}
+
 func (r *TaskRunner) clean(t *Task) {
 	if !t.Change().IsReady() {
 		// Whole Change is not ready so don't run cleanups yet.
@@ -22,6 +22,7 @@ package state_test
 import (
 	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strconv"
 	"strings"
@@ -1328,3 +1329,214 @@ func (ts *taskRunnerSuite) TestErrorCallbackNotCalled(c *C) {
 	c.Check(t1.Status(), Equals, state.DoneStatus)
 	c.Check(called, Equals, false)
 }
+
+var errTransient = errors.New("transient error")
+
+func (ts *taskRunnerSuite) TestRetryPolicy(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	calls := 0
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	}, nil, state.WithRetryPolicy(state.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Minute,
+		MaxBackoff:  90 * time.Second,
+	}))
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	now := time.Now()
+	restore := state.MockTime(now)
+	defer restore()
+
+	for i, after := range []time.Duration{time.Minute, 90 * time.Second} {
+		r.Ensure()
+		r.Wait()
+
+		st.Lock()
+		c.Check(t.Status(), Equals, state.DoingStatus)
+		c.Check(t.DoingRetries(), Equals, i+1)
+		c.Check(t.AtTime().Equal(now.Add(after)), Equals, true)
+		c.Check(t.Log()[i], Matches, fmt.Sprintf(`.* attempt %d failed, will retry in %s: transient error`, i+1, after))
+		st.Unlock()
+
+		now = now.Add(after)
+		state.MockTime(now)
+	}
+
+	r.Ensure()
+	r.Wait()
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(calls, Equals, 3)
+	c.Check(t.Status(), Equals, state.DoneStatus)
+	c.Check(t.DoingRetries(), Equals, 2)
+	c.Check(t.UndoingRetries(), Equals, 0)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyGivesUp(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	calls := 0
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		calls++
+		return errTransient
+	}, nil, state.WithRetryPolicy(state.RetryPolicy{MaxAttempts: 2}))
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	for i := 0; i < 2; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(calls, Equals, 2)
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(t.DoingRetries(), Equals, 1)
+	c.Assert(t.Log(), HasLen, 3)
+	c.Check(t.Log()[1], Matches, `.* giving up after 2 attempts`)
+	c.Check(t.Log()[2], Matches, `.* ERROR transient error`)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyRetryOn(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	errFatal := errors.New("fatal error")
+	errs := []error{errTransient, errFatal}
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}, nil, state.WithRetryPolicy(state.RetryPolicy{
+		RetryOn: func(err error) bool { return err == errTransient },
+	}))
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t := st.NewTask("download", "...")
+	chg.AddTask(t)
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+
+	st.Lock()
+	c.Check(t.Status(), Equals, state.DoingStatus)
+	c.Check(t.DoingRetries(), Equals, 1)
+	st.Unlock()
+
+	r.Ensure()
+	r.Wait()
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(errs, HasLen, 0)
+	c.Check(t.Status(), Equals, state.ErrorStatus)
+	c.Check(t.DoingRetries(), Equals, 1)
+	c.Check(strings.Join(t.Log(), ""), Matches, `.*fatal error`)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyUndo(c *C) {
+	sb := &stateBackend{}
+	st := state.New(sb)
+	r := state.NewTaskRunner(st)
+	defer r.Stop()
+
+	undoCalls := 0
+	r.AddHandler("download", func(t *state.Task, _ *tomb.Tomb) error {
+		return nil
+	}, func(t *state.Task, _ *tomb.Tomb) error {
+		undoCalls++
+		if undoCalls == 1 {
+			return errTransient
+		}
+		return nil
+	}, state.WithRetryPolicy(state.RetryPolicy{MaxAttempts: 3}))
+	r.AddHandler("fail", func(t *state.Task, _ *tomb.Tomb) error {
+		return errors.New("boom")
+	}, nil)
+
+	st.Lock()
+	chg := st.NewChange("install", "...")
+	t1 := st.NewTask("download", "...")
+	t2 := st.NewTask("fail", "...")
+	t2.WaitFor(t1)
+	chg.AddTask(t1)
+	chg.AddTask(t2)
+	st.Unlock()
+
+	// the undo is retried at the next ensure
+	for i := 0; i < 5; i++ {
+		r.Ensure()
+		r.Wait()
+	}
+
+	st.Lock()
+	defer st.Unlock()
+	c.Check(t1.Status(), Equals, state.UndoneStatus)
+	c.Check(t1.DoingRetries(), Equals, 0)
+	c.Check(t1.UndoingRetries(), Equals, 1)
+	c.Check(undoCalls, Equals, 2)
+	c.Check(t2.Status(), Equals, state.ErrorStatus)
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyRetryAfter(c *C) {
+	policy := state.RetryPolicy{
+		Backoff:    10 * time.Second,
+		MaxBackoff: time.Minute,
+	}
+	for retries, after := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute} {
+		c.Check(policy.RetryAfter(retries), Equals, after)
+	}
+	c.Check(policy.RetryAfter(1000), Equals, time.Minute)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		after := policy.RetryAfter(1)
+		c.Check(after >= 20*time.Second && after < 30*time.Second, Equals, true, Commentf("%s", after))
+	}
+}
+
+func (ts *taskRunnerSuite) TestRetryPolicyRetryAfterNoMaxBackoff(c *C) {
+	policy := state.RetryPolicy{Backoff: time.Second}
+	c.Check(policy.RetryAfter(3), Equals, 8*time.Second)
+
+	// the wait stops growing instead of overflowing
+	prev := policy.RetryAfter(0)
+	for retries := 1; retries < 200; retries++ {
+		after := policy.RetryAfter(retries)
+		c.Assert(after >= prev, Equals, true, Commentf("%d retries: %s", retries, after))
+		prev = after
+	}
+	c.Check(prev > time.Duration(math.MaxInt64/2), Equals, true)
+
+	policy.Jitter = 1
+	for retries := 60; retries < 70; retries++ {
+		c.Check(policy.RetryAfter(retries) > 0, Equals, true)
+	}
+}
@@ -132,6 +132,23 @@ func badRequestErrorFrom(v *View, operation, request, errMsg string, args ...int
 	}
 }
 
+// ConflictError is returned when committing a transaction that modified a path
+// whose value was changed by another transaction in the meantime.
+type ConflictError struct {
+	Account      string
+	RegistryName string
+	Path         string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("cannot commit changes to registry %s/%s: %q was modified concurrently", e.Account, e.RegistryName, e.Path)
+}
+
+func (e *ConflictError) Is(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
 // DataBag controls access to the registry data storage.
 type DataBag interface {
 	Get(path string) (interface{}, error)
@@ -234,6 +251,18 @@ func New(account string, registryName string, views map[string]interface{}, sche
 			return nil, fmt.Errorf("cannot define view %q: %w", name, err)
 		}
 
+		if storageRaw, ok := viewMap["storage"]; ok {
+			storage, ok := storageRaw.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot define view %q: view storage must be a string but got %T", name, storageRaw)
+			}
+
+			if storage != "custodian" {
+				return nil, fmt.Errorf(`cannot define view %q: unsupported view storage %q (only "custodian" is supported)`, name, storage)
+			}
+			view.custodianStorage = true
+		}
+
 		registry.views[name] = view
 	}
 
@@ -441,17 +470,57 @@ func (d *Registry) View(view string) *View {
 	return d.views[view]
 }
 
+// GetViewsAffectedByPaths returns all the views in the registry that have
+// rules whose storage paths are equal to, nested in or a prefix of any of the
+// given storage paths. Placeholders in the rules match any subkey.
+func (d *Registry) GetViewsAffectedByPaths(paths []string) []*View {
+	var views []*View
+	for _, view := range d.views {
+		if view.affectedByPaths(paths) {
+			views = append(views, view)
+		}
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
 // View carries access rules for a particular view in a registry.
 type View struct {
 	Name     string
 	rules    []*viewRule
 	registry *Registry
+
+	// custodianStorage is true if the view's data is stored externally by the
+	// custodian snaps of the view.
+	custodianStorage bool
 }
 
 func (v *View) Registry() *Registry {
 	return v.registry
 }
 
+// CustodianStorage returns true if the view's data is stored by its custodian
+// snaps, which must load the data before it's read and save it before it's
+// committed.
+func (v *View) CustodianStorage() bool {
+	return v.custodianStorage
+}
+
+func (v *View) affectedByPaths(paths []string) bool {
+	for _, path := range paths {
+		pathSubkeys := strings.Split(path, ".")
+		for _, rule := range v.rules {
+			if rule.storageOverlaps(pathSubkeys) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type expandedMatch struct {
 	// storagePath is dot-separated storage path without unfilled placeholders.
 	storagePath string
@@ -561,6 +630,19 @@ func (v *View) Set(databag DataBag, request string, value interface{}) error {
 		return badRequestErrorFrom(v, "set", request, err.Error())
 	}
 
+	// check the values against the schemas at their storage paths before
+	// writing anything so errors can refer to the exact path
+	for _, match := range expandedMatches {
+		if match.value == nil {
+			// the path will be unset
+			continue
+		}
+
+		if err := validateAtStoragePath(v.registry.Schema, match.storagePath, match.value); err != nil {
+			return badRequestErrorFrom(v, "set", request, err.Error())
+		}
+	}
+
 	for _, match := range expandedMatches {
 		if err := databag.Set(match.storagePath, match.value); err != nil {
 			return err
@@ -575,6 +657,12 @@ func (v *View) Set(databag DataBag, request string, value interface{}) error {
 		// this is a bit of a waste. Maybe cache the result so we only do the first
 		// validation and then in registrystate on Commit
 		if err := v.registry.Schema.Validate(data); err != nil {
+			var valErr *ValidationError
+			if errors.As(err, &valErr) {
+				// the value may be valid but break a constraint of an outer
+				// element (e.g., a "unique" array)
+				return badRequestErrorFrom(v, "set", request, err.Error())
+			}
 			return fmt.Errorf(`cannot write data: %w`, err)
 		}
 	}
@@ -645,6 +733,75 @@ func (v *View) matchWriteRequest(request string) ([]requestMatch, error) {
 	return matches, nil
 }
 
+// validateAtStoragePath checks that the value can be stored at the storage
+// path, according to the schemas that the path may have. Validation errors
+// refer to the full path of the offending element.
+func validateAtStoragePath(schema Schema, path string, value interface{}) error {
+	pathParts := strings.Split(path, ".")
+	schemas, err := schema.SchemaAt(pathParts)
+	if err != nil {
+		var serr *schemaAtError
+		if errors.As(err, &serr) {
+			subPath := strings.Join(pathParts[:len(pathParts)-serr.left], ".")
+			return fmt.Errorf(`storage path %q is invalid after %q: %w`, path, subPath, serr.err)
+		}
+		return err
+	}
+
+	// nil values in maps are used to unset entries so they're not stored
+	data, err := json.Marshal(removeNilEntries(value))
+	if err != nil {
+		return err
+	}
+
+	var pathSchema Schema
+	if len(schemas) == 1 {
+		pathSchema = schemas[0]
+	} else {
+		pathSchema = &alternativesSchema{schemas: schemas}
+	}
+
+	if err := pathSchema.Validate(data); err != nil {
+		var valErr *ValidationError
+		if errors.As(err, &valErr) {
+			fullPath := make([]interface{}, 0, len(pathParts)+len(valErr.Path))
+			for _, part := range pathParts {
+				fullPath = append(fullPath, part)
+			}
+			valErr.Path = append(fullPath, valErr.Path...)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// removeNilEntries returns a copy of the value without any map entries with
+// nil values.
+func removeNilEntries(value interface{}) interface{} {
+	switch typedVal := value.(type) {
+	case map[string]interface{}:
+		newMap := make(map[string]interface{}, len(typedVal))
+		for k, v := range typedVal {
+			if v == nil {
+				continue
+			}
+			newMap[k] = removeNilEntries(v)
+		}
+		return newMap
+
+	case []interface{}:
+		newList := make([]interface{}, 0, len(typedVal))
+		for _, v := range typedVal {
+			newList = append(newList, removeNilEntries(v))
+		}
+		return newList
+
+	default:
+		return value
+	}
+}
+
 // checkSchemaMismatch checks whether the rules accept compatible schema types.
 // If not, then no data can satisfy these rules and the view should be rejected.
 func checkSchemaMismatch(schema Schema, rules []*viewRule) error {
@@ -1201,6 +1358,17 @@ func (p *viewRule) storagePath(placeholders map[string]string) (string, error) {
 	return sb.String(), nil
 }
 
+// storageOverlaps returns true if the rule's storage path and the given path
+// subkeys are equal or if one is a prefix of the other.
+func (p *viewRule) storageOverlaps(pathSubkeys []string) bool {
+	for i := 0; i < len(p.storage) && i < len(pathSubkeys); i++ {
+		if lit, ok := p.storage[i].(literal); ok && string(lit) != pathSubkeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (p viewRule) isReadable() bool {
 	return p.access == readWrite || p.access == read
 }
@@ -1566,9 +1734,14 @@ func (s JSONSchema) Validate(jsonData []byte) error {
 	return json.Unmarshal(jsonData, &data)
 }
 
-// SchemaAt always returns the JSONSchema.
+// SchemaAt returns the JSONSchema for the top level and a schema accepting any
+// value for nested paths.
 func (v JSONSchema) SchemaAt(path []string) ([]Schema, error) {
-	return []Schema{v}, nil
+	if len(path) == 0 {
+		return []Schema{v}, nil
+	}
+
+	return []Schema{&anySchema{}}, nil
 }
 
 func (v JSONSchema) Type() SchemaType {
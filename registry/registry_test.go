@@ -1476,7 +1476,7 @@ func (s *viewSuite) TestSetValidateError(c *C) {
 	c.Assert(view, NotNil)
 
 	err = view.Set(databag, "bar", "baz")
-	c.Assert(err, ErrorMatches, "cannot write data: expected error")
+	c.Assert(err, ErrorMatches, `cannot set "bar" in registry view acc/registry/foo: expected error`)
 }
 
 func (s *viewSuite) TestSetOverwriteValueWithNewLevel(c *C) {
@@ -1537,6 +1537,129 @@ func (s *viewSuite) TestSetValidatesDataWithSchemaPass(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *viewSuite) TestSetRejectsValueAgainstStorageSchema(c *C) {
+	schema, err := registry.ParseSchema([]byte(`{
+	"schema": {
+		"port": {
+			"type": "int",
+			"min": 1,
+			"max": 65535
+		},
+		"mode": {
+			"type": "string",
+			"choices": ["auto", "manual"]
+		},
+		"interfaces": {
+			"values": {
+				"schema": {
+					"name": {
+						"type": "string",
+						"pattern": "^[a-z0-9]+$"
+					},
+					"dns": {
+						"type": "array",
+						"values": "string",
+						"unique": true
+					}
+				}
+			}
+		}
+	}
+}`))
+	c.Assert(err, IsNil)
+
+	reg, err := registry.New("acc", "registry", map[string]interface{}{
+		"foo": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "port", "storage": "port"},
+				map[string]interface{}{"request": "mode", "storage": "mode"},
+				map[string]interface{}{"request": "interfaces.{iface}", "storage": "interfaces.{iface}"},
+			},
+		},
+	}, schema)
+	c.Assert(err, IsNil)
+
+	view := reg.View("foo")
+	c.Assert(view, NotNil)
+
+	type testcase struct {
+		request string
+		value   interface{}
+		err     string
+	}
+
+	for _, tc := range []testcase{
+		{
+			request: "port",
+			value:   70000,
+			err:     `cannot set "port" in registry view acc/registry/foo: cannot accept element in "port": 70000 is greater than the allowed maximum 65535`,
+		},
+		{
+			request: "mode",
+			value:   "off",
+			err:     `cannot set "mode" in registry view acc/registry/foo: cannot accept element in "mode": string "off" is not one of the allowed choices`,
+		},
+		{
+			request: "interfaces.eth0",
+			value:   map[string]interface{}{"name": "Eth0"},
+			err:     `cannot set "interfaces.eth0" in registry view acc/registry/foo: cannot accept element in "interfaces.eth0.name": expected string matching \^\[a-z0-9\]\+\$ but value was "Eth0"`,
+		},
+		{
+			request: "interfaces.eth0",
+			value:   map[string]interface{}{"dns": []interface{}{"1.1.1.1", "1.1.1.1"}},
+			err:     `cannot set "interfaces.eth0" in registry view acc/registry/foo: cannot accept element in "interfaces.eth0.dns": cannot accept duplicate values for array with "unique" constraint`,
+		},
+		{
+			request: "interfaces.eth0",
+			value:   map[string]interface{}{"dns": []interface{}{1}},
+			err:     `cannot set "interfaces.eth0" in registry view acc/registry/foo: cannot accept element in "interfaces.eth0.dns\[0\]": expected string type but value was number`,
+		},
+	} {
+		cmt := Commentf("request %q", tc.request)
+		databag := registry.NewJSONDataBag()
+
+		err := view.Set(databag, tc.request, tc.value)
+		c.Assert(err, ErrorMatches, tc.err, cmt)
+		c.Assert(errors.Is(err, &registry.BadRequestError{}), Equals, true, cmt)
+
+		// nothing was written
+		data, err := databag.Data()
+		c.Assert(err, IsNil, cmt)
+		c.Assert(string(data), Equals, "{}", cmt)
+	}
+}
+
+func (s *viewSuite) TestSetRejectsUnexpectedMapKey(c *C) {
+	schema, err := registry.ParseSchema([]byte(`{
+	"schema": {
+		"wifi": {
+			"schema": {
+				"ssid": "string"
+			}
+		},
+		"other": "any"
+	}
+}`))
+	c.Assert(err, IsNil)
+
+	reg, err := registry.New("acc", "registry", map[string]interface{}{
+		"foo": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "wifi", "storage": "wifi"},
+			},
+		},
+	}, schema)
+	c.Assert(err, IsNil)
+
+	view := reg.View("foo")
+	c.Assert(view, NotNil)
+
+	databag := registry.NewJSONDataBag()
+	err = view.Set(databag, "wifi", map[string]interface{}{"psk": "secret"})
+	c.Assert(err, ErrorMatches, `cannot set "wifi" in registry view acc/registry/foo: cannot accept element in "wifi": map contains unexpected key "psk"`)
+	c.Assert(errors.Is(err, &registry.BadRequestError{}), Equals, true)
+}
+
 func (s *viewSuite) TestSetPreCheckValueFailsIncompatibleTypes(c *C) {
 	type schemaType struct {
 		schemaStr string
@@ -2487,3 +2610,98 @@ func (*viewSuite) TestSetEnforcesNestednessLimit(c *C) {
 	})
 	c.Assert(err, ErrorMatches, `cannot set "foo" in registry view acc/foo/bar: value cannot have more than 2 nested levels`)
 }
+
+func (s *viewSuite) TestGetViewsAffectedByPaths(c *C) {
+	reg, err := registry.New("acc", "reg", map[string]interface{}{
+		"view-1": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "a", "storage": "a.b"},
+			},
+		},
+		"view-2": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "c.{foo}", "storage": "c.{foo}.d"},
+			},
+		},
+		"view-3": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "e", "storage": "e"},
+				map[string]interface{}{"request": "a", "storage": "a"},
+			},
+		},
+	}, registry.NewJSONSchema())
+	c.Assert(err, IsNil)
+
+	viewNames := func(views []*registry.View) []string {
+		var names []string
+		for _, v := range views {
+			names = append(names, v.Name)
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		paths []string
+		views []string
+	}{
+		// exact match and prefixes in both directions
+		{paths: []string{"a.b"}, views: []string{"view-1", "view-3"}},
+		{paths: []string{"a"}, views: []string{"view-1", "view-3"}},
+		{paths: []string{"a.b.c"}, views: []string{"view-1", "view-3"}},
+		{paths: []string{"a.x"}, views: []string{"view-3"}},
+		// placeholders match any subkey
+		{paths: []string{"c.foo.d"}, views: []string{"view-2"}},
+		{paths: []string{"c"}, views: []string{"view-2"}},
+		{paths: []string{"c.foo.e"}, views: nil},
+		{paths: []string{"e", "c.bar"}, views: []string{"view-2", "view-3"}},
+		{paths: []string{"f"}, views: nil},
+	} {
+		views := reg.GetViewsAffectedByPaths(tc.paths)
+		c.Check(viewNames(views), DeepEquals, tc.views, Commentf("%v", tc.paths))
+	}
+}
+
+func (*viewSuite) TestViewCustodianStorage(c *C) {
+	reg, err := registry.New("acc", "registry", map[string]interface{}{
+		"foo": map[string]interface{}{
+			"storage": "custodian",
+			"rules": []interface{}{
+				map[string]interface{}{"request": "foo", "storage": "foo"},
+			},
+		},
+		"bar": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "bar", "storage": "bar"},
+			},
+		},
+	}, registry.NewJSONSchema())
+	c.Assert(err, IsNil)
+
+	c.Check(reg.View("foo").CustodianStorage(), Equals, true)
+	c.Check(reg.View("bar").CustodianStorage(), Equals, false)
+}
+
+func (*viewSuite) TestViewCustodianStorageWrongValue(c *C) {
+	type test struct {
+		val interface{}
+		err string
+	}
+
+	for _, t := range []test{
+		{val: "snapd", err: `unsupported view storage "snapd" \(only "custodian" is supported\)`},
+		{val: "", err: `unsupported view storage "" \(only "custodian" is supported\)`},
+		{val: 1, err: `view storage must be a string but got int`},
+		{val: []interface{}{"custodian"}, err: `view storage must be a string but got \[\]interface {}`},
+	} {
+		reg, err := registry.New("acc", "registry", map[string]interface{}{
+			"foo": map[string]interface{}{
+				"storage": t.val,
+				"rules": []interface{}{
+					map[string]interface{}{"request": "foo", "storage": "foo"},
+				},
+			},
+		}, registry.NewJSONSchema())
+		c.Check(err, ErrorMatches, `cannot define view "foo": `+t.err)
+		c.Check(reg, IsNil)
+	}
+}
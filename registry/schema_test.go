@@ -1500,6 +1500,25 @@ func (*schemaSuite) TestArrayRejectsNull(c *C) {
 	c.Assert(err, ErrorMatches, `cannot accept element in "foo": cannot accept null value for "array" type`)
 }
 
+func (*schemaSuite) TestNullableNotSupported(c *C) {
+	// null values unset data so they're never stored, "nullable" is
+	// ignored like other unsupported keywords
+	schemaStr := []byte(`{
+	"schema": {
+		"foo": {
+			"type": "string",
+			"nullable": true
+		}
+	}
+}`)
+
+	schema, err := registry.ParseSchema(schemaStr)
+	c.Assert(err, IsNil)
+
+	err = schema.Validate([]byte(`{"foo": null}`))
+	c.Assert(err, ErrorMatches, `cannot accept element in "foo": cannot accept null value for "string" type`)
+}
+
 func (*schemaSuite) TestBooleanHappy(c *C) {
 	schemaStr := []byte(`{
 	"schema": {
@@ -19,6 +19,10 @@
 package registry
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
 	"sync"
 )
 
@@ -118,6 +122,10 @@ func (t *Transaction) Commit() error {
 	// the transaction
 	pristine = pristine.Copy()
 
+	if err := t.checkConflicts(pristine); err != nil {
+		return err
+	}
+
 	if err := applyDeltas(pristine, t.deltas); err != nil {
 		return err
 	}
@@ -144,6 +152,33 @@ func (t *Transaction) Commit() error {
 	return nil
 }
 
+// checkConflicts returns a ConflictError if any path modified by the
+// transaction has a different value in the latest databag than it had when
+// the transaction was created, meaning it was changed by another transaction.
+func (t *Transaction) checkConflicts(latest JSONDataBag) error {
+	checked := make(map[string]bool)
+	for _, delta := range t.deltas {
+		for path := range delta {
+			if checked[path] {
+				continue
+			}
+			checked[path] = true
+
+			// errors just mean the path isn't set in the databag
+			origValue, _ := t.pristine.Get(path)
+			latestValue, _ := latest.Get(path)
+			if !reflect.DeepEqual(origValue, latestValue) {
+				return &ConflictError{
+					Account:      t.registry.Account,
+					RegistryName: t.registry.Name,
+					Path:         path,
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func applyDeltas(bag JSONDataBag, deltas []map[string]interface{}) error {
 	// changes must be applied in the order they were written
 	for _, delta := range deltas {
@@ -168,3 +203,85 @@ func applyDeltas(bag JSONDataBag, deltas []map[string]interface{}) error {
 func (t *Transaction) Data() ([]byte, error) {
 	return t.pristine.Data()
 }
+
+// AlteredPaths returns the storage paths modified by the transaction's
+// uncommitted changes, in the order in which they were first modified.
+func (t *Transaction) AlteredPaths() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var paths []string
+	seen := make(map[string]bool)
+	for _, delta := range t.deltas {
+		for path := range delta {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+type marshalledTransaction struct {
+	Account  string                   `json:"account"`
+	Registry string                   `json:"registry"`
+	Pristine JSONDataBag              `json:"pristine,omitempty"`
+	Deltas   []map[string]interface{} `json:"deltas,omitempty"`
+}
+
+// MarshalJSON encodes the transaction's registry, the data it was created
+// from and its uncommitted changes, so that it can be stored and resumed.
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return json.Marshal(marshalledTransaction{
+		Account:  t.registry.Account,
+		Registry: t.registry.Name,
+		Pristine: t.pristine,
+		Deltas:   t.deltas,
+	})
+}
+
+// UnmarshalJSON decodes a transaction encoded by MarshalJSON. The decoded
+// transaction must be resumed with Resume before it can be used.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var mtx marshalledTransaction
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as they were written
+	dec.UseNumber()
+	if err := dec.Decode(&mtx); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.registry = &Registry{Account: mtx.Account, Name: mtx.Registry}
+	t.pristine = mtx.Pristine
+	if t.pristine == nil {
+		t.pristine = NewJSONDataBag()
+	}
+	t.deltas = mtx.Deltas
+	t.modified = nil
+	t.appliedDeltas = 0
+	return nil
+}
+
+// Resume sets the registry and the databag accessors of a transaction decoded
+// with UnmarshalJSON. The registry must be the one the transaction was
+// created for.
+func (t *Transaction) Resume(reg *Registry, readDatabag DatabagRead, writeDatabag DatabagWrite) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.registry == nil || t.registry.Account != reg.Account || t.registry.Name != reg.Name {
+		return fmt.Errorf("cannot resume transaction for registry %s/%s", reg.Account, reg.Name)
+	}
+
+	t.registry = reg
+	t.readDatabag = readDatabag
+	t.writeDatabag = writeDatabag
+	return nil
+}
@@ -19,6 +19,7 @@
 package registry_test
 
 import (
+	"encoding/json"
 	"errors"
 
 	"github.com/snapcore/snapd/registry"
@@ -56,6 +57,8 @@ func (w *witnessReadWriter) read() (registry.JSONDataBag, error) {
 func (w *witnessReadWriter) write(bag registry.JSONDataBag) error {
 	w.writeCalled++
 	w.writtenDatabag = bag
+	// model the storage so later reads see the written data
+	w.bag = bag
 	return nil
 }
 
@@ -212,6 +215,29 @@ func (s *transactionTestSuite) TestCommittedIncludesRecentWrites(c *C) {
 	c.Assert(value, Equals, "baz")
 }
 
+func (s *transactionTestSuite) TestCommitConflictingWrite(c *C) {
+	databag := registry.NewJSONDataBag()
+	err := databag.Set("foo", "bar")
+	c.Assert(err, IsNil)
+
+	witness := &witnessReadWriter{bag: databag}
+	reg := newRegistry(c, registry.NewJSONSchema())
+	tx, err := registry.NewTransaction(reg, witness.read, witness.write)
+	c.Assert(err, IsNil)
+
+	err = tx.Set("foo", "baz")
+	c.Assert(err, IsNil)
+
+	// another transaction changes the same path after this one was created
+	err = databag.Set("foo", "other")
+	c.Assert(err, IsNil)
+
+	err = tx.Commit()
+	c.Assert(err, ErrorMatches, `cannot commit changes to registry my-account/my-reg: "foo" was modified concurrently`)
+	c.Assert(errors.Is(err, &registry.ConflictError{}), Equals, true)
+	c.Assert(witness.writeCalled, Equals, 0)
+}
+
 func (s *transactionTestSuite) TestCommittedIncludesPreviousCommit(c *C) {
 	var databag registry.JSONDataBag
 	readBag := func() (registry.JSONDataBag, error) {
@@ -384,3 +410,62 @@ func txData(c *C, tx *registry.Transaction) string {
 	c.Assert(err, IsNil)
 	return string(data)
 }
+
+func (s *transactionTestSuite) TestAlteredPaths(c *C) {
+	witness := &witnessReadWriter{bag: registry.NewJSONDataBag()}
+	reg := newRegistry(c, registry.NewJSONSchema())
+	tx, err := registry.NewTransaction(reg, witness.read, witness.write)
+	c.Assert(err, IsNil)
+	c.Check(tx.AlteredPaths(), HasLen, 0)
+
+	c.Assert(tx.Set("foo", "bar"), IsNil)
+	c.Assert(tx.Set("baz.qux", "bar"), IsNil)
+	c.Assert(tx.Unset("foo"), IsNil)
+	c.Check(tx.AlteredPaths(), DeepEquals, []string{"foo", "baz.qux"})
+
+	c.Assert(tx.Commit(), IsNil)
+	c.Check(tx.AlteredPaths(), HasLen, 0)
+}
+
+func (s *transactionTestSuite) TestMarshalResume(c *C) {
+	bag := registry.NewJSONDataBag()
+	c.Assert(bag.Set("foo", "old"), IsNil)
+	witness := &witnessReadWriter{bag: bag}
+	reg := newRegistry(c, registry.NewJSONSchema())
+	tx, err := registry.NewTransaction(reg, witness.read, witness.write)
+	c.Assert(err, IsNil)
+
+	c.Assert(tx.Set("foo", "bar"), IsNil)
+	c.Assert(tx.Set("num", 12345678901234567), IsNil)
+	c.Assert(tx.Unset("other"), IsNil)
+
+	data, err := json.Marshal(tx)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"account":"my-account","registry":"my-reg","pristine":{"foo":"old"},"deltas":[{"foo":"bar"},{"num":12345678901234567},{"other":null}]}`)
+
+	var restored registry.Transaction
+	c.Assert(json.Unmarshal(data, &restored), IsNil)
+
+	otherReg, err := registry.New("my-account", "other-reg", map[string]interface{}{
+		"my-view": map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{"request": "foo", "storage": "foo"},
+			},
+		},
+	}, registry.NewJSONSchema())
+	c.Assert(err, IsNil)
+	err = restored.Resume(otherReg, witness.read, witness.write)
+	c.Assert(err, ErrorMatches, "cannot resume transaction for registry my-account/other-reg")
+
+	c.Assert(restored.Resume(reg, witness.read, witness.write), IsNil)
+	c.Check(restored.AlteredPaths(), DeepEquals, []string{"foo", "num", "other"})
+
+	val, err := restored.Get("foo")
+	c.Assert(err, IsNil)
+	c.Check(val, Equals, "bar")
+
+	c.Assert(restored.Commit(), IsNil)
+	data, err = witness.writtenDatabag.Data()
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, `{"foo":"bar","num":12345678901234567}`)
+}
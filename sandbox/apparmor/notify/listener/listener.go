@@ -112,6 +112,23 @@ func newRequest(msg *notify.MsgNotificationFile) (*Request, error) {
 	}, nil
 }
 
+// MockRequest returns a new request with the given contents, such that any
+// reply to the request is written to the given channel. This function is
+// exported for use in tests of packages which handle listener requests.
+func MockRequest(pid uint32, label string, subjectUID uint32, path string, class notify.MediationClass, permission any, replyChan chan *Response) *Request {
+	return &Request{
+		pid:        pid,
+		label:      label,
+		subjectUID: subjectUID,
+
+		path:       path,
+		class:      class,
+		permission: permission,
+
+		replyChan: replyChan,
+	}
+}
+
 // PID returns the PID of the process which triggered the request.
 func (r *Request) PID() uint32 {
 	return r.pid
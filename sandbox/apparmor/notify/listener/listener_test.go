@@ -69,6 +69,23 @@ func (*listenerSuite) TestReply(c *C) {
 	c.Assert(resp, Equals, response)
 }
 
+func (*listenerSuite) TestMockRequest(c *C) {
+	rc := make(chan *listener.Response, 1)
+	req := listener.MockRequest(1234, "snap.foo.bar", 1000, "/home/test/foo", notify.AA_CLASS_FILE, notify.AA_MAY_READ, rc)
+	c.Check(req.PID(), Equals, uint32(1234))
+	c.Check(req.Label(), Equals, "snap.foo.bar")
+	c.Check(req.SubjectUID(), Equals, uint32(1000))
+	c.Check(req.Path(), Equals, "/home/test/foo")
+	c.Check(req.Class(), Equals, notify.AA_CLASS_FILE)
+	c.Check(req.Permission(), Equals, notify.AA_MAY_READ)
+	response := &listener.Response{
+		Allow:      true,
+		Permission: notify.AA_MAY_READ,
+	}
+	c.Assert(req.Reply(response), IsNil)
+	c.Check(<-rc, Equals, response)
+}
+
 func (*listenerSuite) TestBadReply(c *C) {
 	rc := make(chan *listener.Response, 1)
 	req := listener.FakeRequestWithClassAndReplyChan(notify.AA_CLASS_FILE, rc)
@@ -87,7 +87,7 @@ func MockCgroupProcessPathInTrackingCgroup(fn func(pid int) (string, error)) fun
 	}
 }
 
-func MockDoCreateTransientScope(fn func(conn *dbus.Conn, unitName string, pid int) error) func() {
+func MockDoCreateTransientScope(fn func(conn *dbus.Conn, unitName string, pid int, slice string) error) func() {
 	old := doCreateTransientScope
 	doCreateTransientScope = fn
 	return func() {
@@ -28,6 +28,9 @@ type TrackingOptions struct {
 	// AllowSessionBus controls if CreateTransientScopeForTracking will
 	// consider using the session bus for making the request.
 	AllowSessionBus bool
+	// Slice, if not empty, is the slice the transient scope is placed in,
+	// instead of the default one of the instance of systemd.
+	Slice string
 }
 
 // CreateTransientScopeForTracking puts the current process in a transient scope.
@@ -99,7 +102,7 @@ func CreateTransientScopeForTracking(securityTag string, opts *TrackingOptions)
 	start := time.Now()
 tryAgain:
 	// Create a transient scope by talking to systemd over DBus.
-	if err := doCreateTransientScope(conn, unitName, pid); err != nil {
+	if err := doCreateTransientScope(conn, unitName, pid, opts.Slice); err != nil {
 		switch err {
 		case errDBusUnknownMethod:
 			return ErrCannotTrackProcess
@@ -252,9 +255,10 @@ var (
 // the associated systemd job path.
 //
 // The scope is created by asking systemd via the specified DBus connection.
-// The unit name and the PID to attach are provided as well. The DBus method
-// call is performed outside confinement established by snap-confine.
-func startTransientScope(conn *dbus.Conn, unitName string, pid int) (job dbus.ObjectPath, err error) {
+// The unit name, the PID to attach and optionally the slice to place the unit
+// in are provided as well. The DBus method call is performed outside
+// confinement established by snap-confine.
+func startTransientScope(conn *dbus.Conn, unitName string, pid int, slice string) (job dbus.ObjectPath, err error) {
 	// Documentation of StartTransientUnit is available at
 	// https://www.freedesktop.org/wiki/Software/systemd/dbus/
 	//
@@ -310,6 +314,9 @@ func startTransientScope(conn *dbus.Conn, unitName string, pid int) (job dbus.Ob
 	// Here we choose "fail" to match systemd-run.
 	mode := "fail"
 	properties := []property{{"PIDs", []uint{uint(pid)}}}
+	if slice != "" {
+		properties = append(properties, property{"Slice", slice})
+	}
 	aux := []auxUnit(nil)
 	systemd := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
 	call := systemd.Call(
@@ -354,15 +361,15 @@ func startTransientScope(conn *dbus.Conn, unitName string, pid int) (job dbus.Ob
 // doCreateTransientScopeOpportunisticSync creates a transient scope with a
 // given unit name asking systemd to move the provided pid to that scope, does
 // not wait for the systemd job to complete
-func doCreateTransientScopeNoSync(conn *dbus.Conn, unitName string, pid int) error {
-	_, err := startTransientScope(conn, unitName, pid)
+func doCreateTransientScopeNoSync(conn *dbus.Conn, unitName string, pid int, slice string) error {
+	_, err := startTransientScope(conn, unitName, pid, slice)
 	return err
 }
 
 // doCreateTransientScopeOpportunisticSync creates a transient scope with a
 // given unit name asking systemd to move the provided pid to that scope, and
 // waits for the systemd job to finish
-func doCreateTransientScopeJobRemovedSync(conn *dbus.Conn, unitName string, pid int) error {
+func doCreateTransientScopeJobRemovedSync(conn *dbus.Conn, unitName string, pid int, slice string) error {
 	// set up a watch for JobRemoved signals, so that we'll know when our
 	// request has completed
 	jobRemoveMatch := []dbus.MatchOption{
@@ -440,7 +447,7 @@ func doCreateTransientScopeJobRemovedSync(conn *dbus.Conn, unitName string, pid
 			}
 		}
 	}()
-	job, err := startTransientScope(conn, unitName, pid)
+	job, err := startTransientScope(conn, unitName, pid, slice)
 	if err != nil {
 		return err
 	}
@@ -461,9 +468,10 @@ func doCreateTransientScopeJobRemovedSync(conn *dbus.Conn, unitName string, pid
 // doCreateTransientScope creates a systemd transient scope with specified properties.
 //
 // The scope is created by asking systemd via the specified DBus connection.
-// The unit name and the PID to attach are provided as well. The DBus method
-// call is performed outside confinement established by snap-confine.
-var doCreateTransientScope = func(conn *dbus.Conn, unitName string, pid int) error {
+// The unit name, the PID to attach and optionally the slice to place the unit
+// in are provided as well. The DBus method call is performed outside
+// confinement established by snap-confine.
+var doCreateTransientScope = func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 	// in theory we could use a single implementation that sync with job
 	// removed signal and inspects the result, however some older
 	// distributions sport an unpatched and broken version of systemd, which
@@ -477,9 +485,9 @@ var doCreateTransientScope = func(conn *dbus.Conn, unitName string, pid int) err
 		// when using cgroup v2, we absolutely must be sure that the
 		// tracking group has been created, otherwise we risk
 		// establishing a device cgroup filtering in the wrong group
-		return doCreateTransientScopeJobRemovedSync(conn, unitName, pid)
+		return doCreateTransientScopeJobRemovedSync(conn, unitName, pid, slice)
 	}
-	return doCreateTransientScopeNoSync(conn, unitName, pid)
+	return doCreateTransientScopeNoSync(conn, unitName, pid, slice)
 }
 
 // The source of the bytes generated here is the same as that of
@@ -166,7 +166,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyNotRootGeneric
 	defer restore()
 
 	// Pretend that attempting to create a transient scope fails with a canned error.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		return fmt.Errorf("cannot create transient scope for testing")
 	})
 	defer restore()
@@ -177,7 +177,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyNotRootGeneric
 
 	// Calling StartTransientUnit fails with org.freedesktop.DBus.UnknownMethod error.
 	// This is possible on old systemd or on deputy systemd.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		return cgroup.ErrDBusUnknownMethod
 	})
 	defer restore()
@@ -190,7 +190,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyNotRootGeneric
 	// Calling StartTransientUnit fails with org.freedesktop.DBus.Spawn.ChildExited error.
 	// This is possible where we try to activate socket activate session bus
 	// but it's not available OR when we try to socket activate systemd --user.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		return cgroup.ErrDBusSpawnChildExited
 	})
 	defer restore()
@@ -228,7 +228,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyRootFallback(c
 	// Calling StartTransientUnit fails on the session and then works on the system bus.
 	// This test emulates a root user falling back from the session bus to the system bus.
 	n := 0
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		n++
 		switch n {
 		case 1:
@@ -256,6 +256,44 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyRootFallback(c
 	c.Assert(err, IsNil)
 }
 
+func (s *trackingSuite) TestCreateTransientScopeForTrackingInSlice(c *C) {
+	// Pretend that refresh app awareness is enabled
+	enableFeatures(c, features.RefreshAppAwareness)
+
+	restore := dbusutil.MockConnections(dbustest.StubConnection, dbustest.StubConnection)
+	defer restore()
+
+	restore = cgroup.MockOsGetuid(12345)
+	defer restore()
+	restore = cgroup.MockOsGetpid(312123)
+	defer restore()
+
+	uuid := "cc98cd01-6a25-46bd-b71b-82069b71b770"
+	restore = cgroup.MockRandomUUID(func() (string, error) {
+		return uuid, nil
+	})
+	defer restore()
+
+	// The slice is passed on to systemd.
+	n := 0
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
+		n++
+		c.Check(unitName, Equals, "snap.pkg.app-"+uuid+".scope")
+		c.Check(slice, Equals, "snap.foo.slice")
+		return nil
+	})
+	defer restore()
+
+	restore = cgroup.MockCgroupProcessPathInTrackingCgroup(func(pid int) (string, error) {
+		return "/user.slice/user-12345.slice/user@12345.service/snap.foo.slice/snap.pkg.app-" + uuid + ".scope", nil
+	})
+	defer restore()
+
+	err := cgroup.CreateTransientScopeForTracking("snap.pkg.app", &cgroup.TrackingOptions{AllowSessionBus: true, Slice: "snap.foo.slice"})
+	c.Assert(err, IsNil)
+	c.Check(n, Equals, 1)
+}
+
 func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyRootFailedFallback(c *C) {
 	// Pretend that refresh app awareness is enabled
 	enableFeatures(c, features.RefreshAppAwareness)
@@ -282,7 +320,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyRootFailedFall
 	defer restore()
 
 	// Calling StartTransientUnit fails so that we try to use the system bus as fallback.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		return cgroup.ErrDBusSpawnChildExited
 	})
 	defer restore()
@@ -325,7 +363,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingUnhappyNoDBus(c *C) {
 	defer restore()
 
 	// Calling StartTransientUnit is not attempted without a DBus connection.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		c.Error("test sequence violated")
 		return fmt.Errorf("test was not expected to create a transient scope")
 	})
@@ -372,7 +410,7 @@ func (s *trackingSuite) TestCreateTransientScopeForTrackingSilentlyFails(c *C) {
 	// version is < 238 and when the calling user is in a hierarchy that is
 	// owned by another user. One example is a user logging in remotely over
 	// ssh.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		return nil
 	})
 	defer restore()
@@ -421,7 +459,7 @@ func (s *trackingSuite) TestCreateTransientScopeForRootOnSystemBus(c *C) {
 	// Pretend that attempting to create a transient scope succeeds.  Measure
 	// the bus used and the unit name provided by the caller.  Note that the
 	// call was made on the system bus, as requested by TrackingOptions below.
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		c.Assert(conn, Equals, systemBus)
 		c.Assert(unitName, Equals, "snap.pkg.app-"+uuid+".scope")
 		return nil
@@ -470,7 +508,7 @@ func (s *trackingSuite) testCreateTransientScopeConfirm(c *C, tc testTransientSc
 	c.Assert(err, IsNil)
 	restore = dbusutil.MockOnlySessionBusAvailable(sessionBus)
 	defer restore()
-	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int) error {
+	restore = cgroup.MockDoCreateTransientScope(func(conn *dbus.Conn, unitName string, pid int, slice string) error {
 		escapedTag, err := systemd.SecurityTagToUnitName(tc.securityTag)
 		c.Assert(err, IsNil)
 
@@ -574,6 +612,10 @@ func checkSystemSignalUnsubscribe(c *C, msg *dbus.Message) *dbus.Message {
 }
 
 func checkAndRespondToStartTransientUnit(c *C, msg *dbus.Message, scopeName string, pid int) *dbus.Message {
+	return checkAndRespondToStartTransientUnitInSlice(c, msg, scopeName, pid, "")
+}
+
+func checkAndRespondToStartTransientUnitInSlice(c *C, msg *dbus.Message, scopeName string, pid int, slice string) *dbus.Message {
 	// XXX: Those types might live in a package somewhere
 	type Property struct {
 		Name  string
@@ -595,12 +637,16 @@ func checkAndRespondToStartTransientUnit(c *C, msg *dbus.Message, scopeName stri
 		dbus.FieldMember:      dbus.MakeVariant("StartTransientUnit"),
 		dbus.FieldSignature:   dbus.MakeVariant(requestSig),
 	})
+	properties := [][]interface{}{
+		{"PIDs", dbus.MakeVariant([]uint32{uint32(pid)})},
+	}
+	if slice != "" {
+		properties = append(properties, []interface{}{"Slice", dbus.MakeVariant(slice)})
+	}
 	c.Check(msg.Body, DeepEquals, []interface{}{
 		scopeName,
 		"fail",
-		[][]interface{}{
-			{"PIDs", dbus.MakeVariant([]uint32{uint32(pid)})},
-		},
+		properties,
 		[][]interface{}{},
 	})
 
@@ -832,7 +878,7 @@ func (s *trackingSuite) TestDoCreateTransientScopeHappyCgroupV2(c *C) {
 
 	c.Assert(err, IsNil)
 	defer conn.Close()
-	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123)
+	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "")
 	c.Assert(err, IsNil)
 }
 
@@ -850,7 +896,25 @@ func (s *trackingSuite) TestDoCreateTransientScopeHappyCgroupV1(c *C) {
 	})
 	c.Assert(err, IsNil)
 	defer conn.Close()
-	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123)
+	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "")
+	c.Assert(err, IsNil)
+}
+
+func (s *trackingSuite) TestDoCreateTransientScopeInSlice(c *C) {
+	restore := cgroup.MockVersion(cgroup.V1, nil)
+	defer restore()
+
+	conn, err := dbustest.Connection(func(msg *dbus.Message, n int) ([]*dbus.Message, error) {
+		c.Logf("message: %v", msg)
+		switch n {
+		case 0:
+			return []*dbus.Message{checkAndRespondToStartTransientUnitInSlice(c, msg, "foo.scope", 312123, "snap.foo.slice")}, nil
+		}
+		return nil, fmt.Errorf("unexpected message #%d: %s", n, msg)
+	})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "snap.foo.slice")
 	c.Assert(err, IsNil)
 }
 
@@ -877,7 +941,7 @@ func (s *trackingSuite) TestDoCreateTransientScopeForwardedErrors(c *C) {
 		})
 		c.Assert(err, IsNil)
 		defer conn.Close()
-		err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123)
+		err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "")
 		c.Assert(strings.HasSuffix(err.Error(), fmt.Sprintf(" [%s]", t.dbusError)), Equals, true, Commentf("%q ~ %s", err, t.dbusError))
 		c.Check(err, ErrorMatches, t.msg+" .*")
 	}
@@ -900,7 +964,7 @@ func (s *trackingSuite) TestDoCreateTransientScopeClashingScopeName(c *C) {
 	})
 	c.Assert(err, IsNil)
 	defer conn.Close()
-	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123)
+	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "")
 	c.Assert(err, ErrorMatches, "cannot create transient scope: scope .* clashed: .*")
 }
 
@@ -920,7 +984,7 @@ func (s *trackingSuite) TestDoCreateTransientScopeOtherDBusErrors(c *C) {
 	})
 	c.Assert(err, IsNil)
 	defer conn.Close()
-	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123)
+	err = cgroup.DoCreateTransientScope(conn, "foo.scope", 312123, "")
 	c.Assert(err, ErrorMatches, `cannot create transient scope: DBus error "org.example.BadHairDay": \[\]`)
 }
 
@@ -39,6 +39,10 @@ var supportedHooks = []*HookType{
 	NewHookType(regexp.MustCompile("^check-health$")),
 	NewHookType(regexp.MustCompile("^fde-setup$")),
 	NewHookType(regexp.MustCompile("^gate-auto-refresh$")),
+	NewHookType(regexp.MustCompile("^change-view-[-a-z0-9]+$")),
+	NewHookType(regexp.MustCompile("^save-view-[-a-z0-9]+$")),
+	NewHookType(regexp.MustCompile("^observe-view-[-a-z0-9]+$")),
+	NewHookType(regexp.MustCompile("^load-view-[-a-z0-9]+$")),
 }
 
 var supportedComponentHooks = []*HookType{
@@ -444,10 +444,28 @@ type Info struct {
 	// OriginalLinks is a map links keys to link lists
 	OriginalLinks map[string][]string
 
+	// Health describes what snapd should do when the snap reports
+	// an error from its check-health hook.
+	Health *HealthPolicy
+
 	// Categories this snap is in.
 	Categories []CategoryInfo
 }
 
+// HealthPolicy describes the actions snapd takes when a snap reports
+// an error health status.
+type HealthPolicy struct {
+	// RestartOnError requests the services of the snap to be
+	// restarted when it starts reporting an error.
+	RestartOnError bool
+	// RevertOnError is the number of consecutive error reports after
+	// a refresh that cause the snap to be reverted, 0 means never.
+	RevertOnError int
+	// CheckInterval is the interval at which the check-health hook
+	// of the snap is run periodically, 0 means it is not.
+	CheckInterval timeout.Timeout
+}
+
 // StoreAccount holds information about a store account, for example of snap
 // publisher.
 type StoreAccount struct {
@@ -56,11 +56,18 @@ type snapYaml struct {
 	SystemUsernames map[string]interface{}   `yaml:"system-usernames,omitempty"`
 	Links           map[string][]string      `yaml:"links,omitempty"`
 	Components      map[string]componentYaml `yaml:"components,omitempty"`
+	Health          *healthYaml              `yaml:"health,omitempty"`
 
 	// TypoLayouts is used to detect the use of the incorrect plural form of "layout"
 	TypoLayouts typoDetector `yaml:"layouts,omitempty"`
 }
 
+type healthYaml struct {
+	RestartOnError bool            `yaml:"restart-on-error,omitempty"`
+	RevertOnError  int             `yaml:"revert-on-error,omitempty"`
+	CheckInterval  timeout.Timeout `yaml:"check-interval,omitempty"`
+}
+
 type typoDetector struct {
 	Hint string
 }
@@ -308,6 +315,14 @@ func infoSkeletonFromSnapYaml(y snapYaml) *Info {
 		OriginalLinks:       make(map[string][]string),
 	}
 
+	if y.Health != nil {
+		snap.Health = &HealthPolicy{
+			RestartOnError: y.Health.RestartOnError,
+			RevertOnError:  y.Health.RevertOnError,
+			CheckInterval:  y.Health.CheckInterval,
+		}
+	}
+
 	sort.Strings(snap.Assumes)
 
 	return snap
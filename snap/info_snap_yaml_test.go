@@ -2175,6 +2175,31 @@ links:
 	}
 }
 
+func (s *YamlSuite) TestSnapYamlHealth(c *C) {
+	info, err := snap.InfoFromSnapYaml([]byte(`name: my-snap
+version: 1.0
+
+health:
+  restart-on-error: true
+  revert-on-error: 3
+  check-interval: 10m
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.Health, DeepEquals, &snap.HealthPolicy{
+		RestartOnError: true,
+		RevertOnError:  3,
+		CheckInterval:  timeout.Timeout(10 * time.Minute),
+	})
+}
+
+func (s *YamlSuite) TestSnapYamlNoHealth(c *C) {
+	info, err := snap.InfoFromSnapYaml([]byte(`name: my-snap
+version: 1.0
+`))
+	c.Assert(err, IsNil)
+	c.Check(info.Health, IsNil)
+}
+
 func (s *YamlSuite) TestUnmarshalComponents(c *C) {
 	info, err := snap.InfoFromSnapYaml([]byte(`
 name: snap
@@ -24,9 +24,11 @@ package quota
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"time"
 
 	// TODO: move this to snap/quantity? or similar
@@ -83,6 +85,19 @@ type GroupQuotaJournal struct {
 	RatePeriod time.Duration `json:"rate-period,omitempty"`
 }
 
+// GroupQuotaIO contains the block IO limits of the group for a device. The
+// bandwidths are in bytes per second, and a zero value means no limit.
+type GroupQuotaIO struct {
+	// Device is the path of the block device, or of a file on the
+	// filesystem it backs, as accepted by systemd.
+	Device string `json:"device"`
+
+	ReadBandwidth  quantity.Size `json:"read-bandwidth,omitempty"`
+	WriteBandwidth quantity.Size `json:"write-bandwidth,omitempty"`
+	ReadIOPS       int           `json:"read-iops,omitempty"`
+	WriteIOPS      int           `json:"write-iops,omitempty"`
+}
+
 // Group is a quota group of snaps, services or sub-groups that are all subject
 // to specific resource quotas. The only quota resource types currently
 // supported is memory, but this can be expanded in the future.
@@ -109,6 +124,22 @@ type Group struct {
 	// ExhaustionBehavior. MemoryLimit is expressed in bytes.
 	MemoryLimit quantity.Size `json:"memory-limit,omitempty"`
 
+	// MemoryHighLimit is the limit of memory above which the processes in
+	// the group are throttled and have their memory reclaimed, instead of
+	// being killed. It is below MemoryLimit, and expressed in bytes.
+	MemoryHighLimit quantity.Size `json:"memory-high-limit,omitempty"`
+
+	// MemorySwapLimit is the limit of swap space available to the processes
+	// in the group, expressed in bytes. There is no limit if it is nil, and
+	// no swap can be used if it is zero.
+	MemorySwapLimit *quantity.Size `json:"memory-swap-limit,omitempty"`
+
+	// OOMPolicy is what is killed when the memory limit is reached, either
+	// the whole service or group with OOMPolicyKillGroup, or only the
+	// process chosen by the kernel with OOMPolicyKillProcess. If not set,
+	// that of the parent group applies.
+	OOMPolicy string `json:"oom-policy,omitempty"`
+
 	// CPULimit is the quotas for the cpu and consists of a couple of nubs.
 	// It is possible to control the percentage of the cpu available for the group
 	// and which cores (requires cgroupsv2) are allowed to be used.
@@ -124,6 +155,15 @@ type Group struct {
 	// journald.
 	JournalLimit *GroupQuotaJournal `json:"journal-limit,omitempty"`
 
+	// IOLimits are the block IO limits of the group, per device. Processes
+	// in the group are throttled once the limits are reached.
+	IOLimits []GroupQuotaIO `json:"io-limits,omitempty"`
+
+	// NetworkEgressLimit is the limit of the outgoing network traffic of the
+	// processes in the group, in bytes per second. Packets beyond the limit
+	// are dropped. The limit applies to the group and all of its sub-groups.
+	NetworkEgressLimit quantity.Size `json:"network-egress-limit,omitempty"`
+
 	// ParentGroup is the the parent group that this group is a child of. If it
 	// is empty, then this is a "root" quota group.
 	ParentGroup string `json:"parent-group,omitempty"`
@@ -141,6 +181,13 @@ type Group struct {
 	// parent quota group. If both this and Snaps is empty then the underlying slice may not
 	// exist on the system.
 	Services []string `json:"services,omitempty"`
+
+	// UserApps is set when the apps of the snaps in the group, including
+	// their user daemons, are subject to the limits of the group too, and
+	// not only their services. They are placed in a slice of the group with
+	// the same limits in the instance of systemd of each user, so the limits
+	// apply per user.
+	UserApps bool `json:"user-apps,omitempty"`
 }
 
 // NewGroup creates a new top quota group with the given name and memory limit.
@@ -165,6 +212,15 @@ func (grp *Group) GetQuotaResources() Resources {
 	if grp.MemoryLimit != 0 {
 		resourcesBuilder.WithMemoryLimit(grp.MemoryLimit)
 	}
+	if grp.MemoryHighLimit != 0 {
+		resourcesBuilder.WithMemoryHigh(grp.MemoryHighLimit)
+	}
+	if grp.MemorySwapLimit != nil {
+		resourcesBuilder.WithMemorySwapMax(*grp.MemorySwapLimit)
+	}
+	if grp.OOMPolicy != "" {
+		resourcesBuilder.WithOOMPolicy(grp.OOMPolicy)
+	}
 	if grp.CPULimit != nil {
 		if grp.CPULimit.Count != 0 {
 			resourcesBuilder.WithCPUCount(grp.CPULimit.Count)
@@ -192,6 +248,23 @@ func (grp *Group) GetQuotaResources() Resources {
 			resourcesBuilder.WithJournalRate(grp.JournalLimit.RateCount, grp.JournalLimit.RatePeriod)
 		}
 	}
+	for _, io := range grp.IOLimits {
+		if io.ReadBandwidth != 0 {
+			resourcesBuilder.WithIOReadBandwidth(io.Device, io.ReadBandwidth)
+		}
+		if io.WriteBandwidth != 0 {
+			resourcesBuilder.WithIOWriteBandwidth(io.Device, io.WriteBandwidth)
+		}
+		if io.ReadIOPS != 0 {
+			resourcesBuilder.WithIOReadIOPS(io.Device, io.ReadIOPS)
+		}
+		if io.WriteIOPS != 0 {
+			resourcesBuilder.WithIOWriteIOPS(io.Device, io.WriteIOPS)
+		}
+	}
+	if grp.NetworkEgressLimit != 0 {
+		resourcesBuilder.WithNetworkEgressBandwidth(grp.NetworkEgressLimit)
+	}
 	return resourcesBuilder.Build()
 }
 
@@ -244,6 +317,30 @@ func (grp *Group) CurrentTaskUsage() (int, error) {
 	return int(count), nil
 }
 
+// CurrentNetworkEgressUsage returns the number of bytes sent over the network
+// by the processes of the quota group since its slice was started. For quota
+// groups which do not yet have a backing systemd slice on the system (i.e.
+// quota groups without any snaps in them), the usage is reported as 0.
+func (grp *Group) CurrentNetworkEgressUsage() (quantity.Size, error) {
+	sysd := systemd.New(systemd.SystemMode, progress.Null)
+
+	// check if this group is actually active, it could not physically exist yet
+	// since it has no snaps in it
+	isActive, err := sysd.IsActive(grp.SliceFileName())
+	if err != nil {
+		return 0, err
+	}
+	if !isActive {
+		return 0, nil
+	}
+
+	egress, err := sysd.CurrentNetworkEgress(grp.SliceFileName())
+	if err != nil {
+		return 0, err
+	}
+	return egress, nil
+}
+
 // SliceFileName returns the name of the slice file that should be used for this
 // quota group. This name will include all of the group's parents in the name.
 // For example, a group named "bar" that is a child of the "foo" group will have
@@ -274,6 +371,106 @@ func (grp *Group) SliceFileName() string {
 	return buf.String()
 }
 
+// SliceCgroupPath returns the path of the cgroup of the slice of the group,
+// relative to the root of the cgroup hierarchy, as in
+// "snap.foo.slice/snap.foo-bar.slice" for a sub-group bar of group foo.
+func (grp *Group) SliceCgroupPath() string {
+	sliceNames := []string{grp.SliceFileName()}
+	for parentGrp := grp.parentGroup; parentGrp != nil; parentGrp = parentGrp.parentGroup {
+		sliceNames = append([]string{parentGrp.SliceFileName()}, sliceNames...)
+	}
+	return strings.Join(sliceNames, "/")
+}
+
+// NetworkQuotaGroups returns the groups with a network quota that the group
+// is subject to, which are the group itself and its parent groups, starting
+// with the outermost one.
+func (grp *Group) NetworkQuotaGroups() []*Group {
+	var grps []*Group
+	for g := grp; g != nil; g = g.parentGroup {
+		if g.NetworkEgressLimit != 0 {
+			grps = append([]*Group{g}, grps...)
+		}
+	}
+	return grps
+}
+
+// NetworkQuotaSet returns true if the group is subject to a network quota,
+// either set on the group itself or on any of its parent groups.
+func (grp *Group) NetworkQuotaSet() bool {
+	return len(grp.NetworkQuotaGroups()) != 0
+}
+
+// NetworkTableName returns the name of the netfilter table with the rules
+// enforcing the network quota of the group.
+func (grp *Group) NetworkTableName() string {
+	return fmt.Sprintf("snap-quota-%s", grp.Name)
+}
+
+// NftCommand is the command used to load the netfilter rules enforcing the
+// network quotas.
+var NftCommand = "/usr/sbin/nft"
+
+// NetworkRulesFile returns the full path to the file with the netfilter rules
+// enforcing the network quotas the group is subject to.
+func (grp *Group) NetworkRulesFile() string {
+	return filepath.Join(dirs.SnapNetworkQuotaDir, fmt.Sprintf("snap.%s.nft", grp.Name))
+}
+
+// UserSliceFile returns the full path to the slice unit of the group for the
+// instances of systemd of the users, used when the group includes user apps.
+func (grp *Group) UserSliceFile() string {
+	return filepath.Join(dirs.SnapUserServicesDir, grp.SliceFileName())
+}
+
+// UserSliceNeeded returns true if the slice unit of the group is needed in
+// the instances of systemd of the users, which is the case when the group or
+// any of its sub-groups includes user apps, so that the limits of the outer
+// groups apply too.
+func (grp *Group) UserSliceNeeded() bool {
+	if grp.UserApps {
+		return true
+	}
+	for _, sub := range grp.subGroups {
+		if sub.UserSliceNeeded() {
+			return true
+		}
+	}
+	return false
+}
+
+// UserAppsSliceFile returns the full path to the file recording the name of
+// the slice the apps of the snap are placed in, when the snap is in a group
+// including user apps.
+func UserAppsSliceFile(instanceName string) string {
+	return filepath.Join(dirs.SnapUserAppsQuotaDir, instanceName)
+}
+
+// UserAppsSlice returns the name of the slice the apps of the snap are placed
+// in, or an empty string if the snap is not in a group including user apps.
+func UserAppsSlice(instanceName string) (string, error) {
+	content, err := os.ReadFile(UserAppsSliceFile(instanceName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// EffectiveOOMPolicy returns the memory OOM policy that applies to the
+// group, which is that of the closest group up the tree that has one, or an
+// empty string if none does.
+func (grp *Group) EffectiveOOMPolicy() string {
+	for g := grp; g != nil; g = g.parentGroup {
+		if g.OOMPolicy != "" {
+			return g.OOMPolicy
+		}
+	}
+	return ""
+}
+
 // JournalQuotaSet returns true if the group is subject to
 // a journal quota. This should only be used in cases where the caller
 // is interested in knowing if a quota group is affected by a journal
@@ -366,6 +563,62 @@ type groupQuotaAllocations struct {
 
 	CPUSetLimit              []int
 	CPUSetReservedByChildren []int
+
+	IOLimits             map[string]ioAllocation
+	IOReservedByChildren map[string]ioAllocation
+
+	NetworkEgressLimit              quantity.Size
+	NetworkEgressReservedByChildren quantity.Size
+}
+
+const (
+	ioReadBandwidth = iota
+	ioWriteBandwidth
+	ioReadIOPS
+	ioWriteIOPS
+	ioLimitCount
+)
+
+var ioLimitNames = [ioLimitCount]string{"read bandwidth", "write bandwidth", "read iops", "write iops"}
+
+// ioAllocation holds the IO limits of a device, indexed by ioReadBandwidth
+// etc, so that all of them can be accounted for in the same way.
+type ioAllocation [ioLimitCount]int64
+
+func newIOAllocation(dev ResourceIODevice) ioAllocation {
+	return ioAllocation{
+		ioReadBandwidth:  int64(dev.ReadBandwidth),
+		ioWriteBandwidth: int64(dev.WriteBandwidth),
+		ioReadIOPS:       int64(dev.ReadIOPS),
+		ioWriteIOPS:      int64(dev.WriteIOPS),
+	}
+}
+
+func fmtIOLimit(kind int, value int64) string {
+	if kind == ioReadBandwidth || kind == ioWriteBandwidth {
+		return quantity.Size(value).IECString() + "/s"
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// getLocalIOAllocations returns the IO limits of the group by device, or nil
+// if there are none.
+func (grp *Group) getLocalIOAllocations() map[string]ioAllocation {
+	if len(grp.IOLimits) == 0 {
+		return nil
+	}
+	allocs := make(map[string]ioAllocation, len(grp.IOLimits))
+	for _, io := range grp.IOLimits {
+		allocs[io.Device] = newIOAllocation(ResourceIODevice(io))
+	}
+	return allocs
 }
 
 func max(a, b int) int {
@@ -449,6 +702,9 @@ func (grp *Group) getQuotaAllocations(allQuotas map[string]*groupQuotaAllocation
 		CPULimit:     grp.getCurrentCPUAllocation(),
 		ThreadsLimit: grp.ThreadLimit,
 		CPUSetLimit:  grp.GetLocalCPUSetQuota(),
+		IOLimits:     grp.getLocalIOAllocations(),
+
+		NetworkEgressLimit: grp.NetworkEgressLimit,
 	}
 
 	// sliceUniqueAndSort sorts an array of ints in ascending order and removes duplicates
@@ -477,6 +733,26 @@ func (grp *Group) getQuotaAllocations(allQuotas map[string]*groupQuotaAllocation
 		limits.MemoryReservedByChildren += maxq(subGroupLimits.MemoryLimit, subGroupLimits.MemoryReservedByChildren)
 		limits.CPUReservedByChildren += max(subGroupLimits.CPULimit, subGroupLimits.CPUReservedByChildren)
 		limits.ThreadsReservedByChildren += max(subGroupLimits.ThreadsLimit, subGroupLimits.ThreadsReservedByChildren)
+		limits.NetworkEgressReservedByChildren += maxq(subGroupLimits.NetworkEgressLimit, subGroupLimits.NetworkEgressReservedByChildren)
+
+		// The same goes for the IO limits, for each of the devices.
+		devices := make(map[string]bool)
+		for device := range subGroupLimits.IOLimits {
+			devices[device] = true
+		}
+		for device := range subGroupLimits.IOReservedByChildren {
+			devices[device] = true
+		}
+		if len(devices) > 0 && limits.IOReservedByChildren == nil {
+			limits.IOReservedByChildren = make(map[string]ioAllocation, len(devices))
+		}
+		for device := range devices {
+			reserved := limits.IOReservedByChildren[device]
+			for kind := range reserved {
+				reserved[kind] += max64(subGroupLimits.IOLimits[device][kind], subGroupLimits.IOReservedByChildren[device][kind])
+			}
+			limits.IOReservedByChildren[device] = reserved
+		}
 
 		// We need to merge the allowed CPUs lists, but we need to make sure that the list is unique, since cpu cores
 		// can be reused between sub-groups.
@@ -715,6 +991,160 @@ func (grp *Group) validateThreadResourceFit(allQuotas map[string]*groupQuotaAllo
 	return nil
 }
 
+// validateNetworkResourceFit verifies that the new network egress limit doesn't conflict with the current reserved
+// limit of the group, and if not locates the nearest parent group that has a network quota, and then verifies
+// if that group has any space available, in the same way as validateMemoryResourceFit does.
+func (grp *Group) validateNetworkResourceFit(allQuotas map[string]*groupQuotaAllocations, egressLimit quantity.Size) error {
+
+	// make sure current usage does not exceed the new limit, we can avoid any
+	// recursive descent as we already have counted up the usage of our children.
+	currentLimits := allQuotas[grp.Name]
+	egressReserved := grp.NetworkEgressLimit
+	if currentLimits != nil {
+		if currentLimits.NetworkEgressReservedByChildren > egressLimit {
+			return fmt.Errorf("group network egress limit of %s/s is too small to fit current subgroup usage of %s/s",
+				egressLimit.IECString(), currentLimits.NetworkEgressReservedByChildren.IECString())
+		}
+
+		// if we are reducing the limit, then we don't need to check upper parents,
+		// as we can assume it will fit by this point
+		if egressLimit < grp.NetworkEgressLimit {
+			return nil
+		}
+
+		egressReserved = maxq(egressReserved, currentLimits.NetworkEgressReservedByChildren)
+	}
+
+	// now we check parents up the tree to make sure we also fit with any
+	// previous usage limits of our parents.
+	parent := grp.parentGroup
+	for parent != nil {
+		limits := allQuotas[parent.Name]
+		if limits != nil && limits.NetworkEgressLimit != 0 {
+			// We need to take into account that we might have a matching limit in this group, and thus we account
+			// for some of the reserved bandwidth. So subtract that.
+			egressAvailable := limits.NetworkEgressLimit - (limits.NetworkEgressReservedByChildren - egressReserved)
+			if egressLimit > egressAvailable {
+				return fmt.Errorf("sub-group network egress limit of %s/s is too large to fit inside group %q remaining quota space %s/s",
+					egressLimit.IECString(), parent.Name, egressAvailable.IECString())
+			}
+			break
+		}
+		parent = parent.parentGroup
+	}
+	return nil
+}
+
+// validateMemoryCapsFit verifies that the new memory high and swap limits
+// are not larger than those of the closest parent group that has them, and
+// not smaller than those of the sub-groups. Unlike the memory limit, these
+// limits are not reserved by the sub-groups, as they are only caps on the
+// usage.
+func (grp *Group) validateMemoryCapsFit(memLimits *ResourceMemory) error {
+	// the memory high limit is unset when zero
+	highCap := func(high quantity.Size) *quantity.Size {
+		if high == 0 {
+			return nil
+		}
+		return &high
+	}
+	caps := []struct {
+		name     string
+		limit    *quantity.Size
+		groupCap func(g *Group) *quantity.Size
+	}{
+		{"high", highCap(memLimits.High), func(g *Group) *quantity.Size { return highCap(g.MemoryHighLimit) }},
+		{"swap", memLimits.SwapMax, func(g *Group) *quantity.Size { return g.MemorySwapLimit }},
+	}
+	for _, c := range caps {
+		if c.limit == nil {
+			continue
+		}
+		limit := *c.limit
+		for parent := grp.parentGroup; parent != nil; parent = parent.parentGroup {
+			if parentCap := c.groupCap(parent); parentCap != nil {
+				if limit > *parentCap {
+					return fmt.Errorf("sub-group memory %s limit of %s is too large to fit inside group %q memory %s limit of %s",
+						c.name, limit.IECString(), parent.Name, c.name, parentCap.IECString())
+				}
+				break
+			}
+		}
+		var err error
+		grp.visitSubGroups(func(sub *Group) bool {
+			if subCap := c.groupCap(sub); subCap != nil && *subCap > limit {
+				err = fmt.Errorf("group memory %s limit of %s is too small to fit sub-group %q memory %s limit of %s",
+					c.name, limit.IECString(), sub.Name, c.name, subCap.IECString())
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitSubGroups calls visit for all the sub-groups of the group,
+// recursively, as long as it returns true.
+func (grp *Group) visitSubGroups(visit func(sub *Group) bool) bool {
+	for _, sub := range grp.subGroups {
+		if !visit(sub) || !sub.visitSubGroups(visit) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateIOResourceFit verifies that the new IO limits of each device don't
+// conflict with the current reserved IO limits of the group, and if not
+// locates the nearest parent group that has a matching IO limit for the device,
+// and then verifies if that group has any space available, in the same way as
+// validateThreadResourceFit does.
+func (grp *Group) validateIOResourceFit(allQuotas map[string]*groupQuotaAllocations, ioLimits *ResourceIO) error {
+	localLimits := grp.getLocalIOAllocations()
+	currentLimits := allQuotas[grp.Name]
+	for _, dev := range ioLimits.Devices {
+		requested := newIOAllocation(dev)
+		for kind, limit := range requested {
+			if limit == 0 {
+				continue
+			}
+
+			ioReserved := localLimits[dev.Device][kind]
+			if currentLimits != nil {
+				reservedByChildren := currentLimits.IOReservedByChildren[dev.Device][kind]
+				if reservedByChildren > limit {
+					return fmt.Errorf("group io %s limit of %s for device %q is too small to fit current subgroup usage of %s",
+						ioLimitNames[kind], fmtIOLimit(kind, limit), dev.Device, fmtIOLimit(kind, reservedByChildren))
+				}
+
+				// if we are reducing the limit, then we don't need to
+				// check upper parents
+				if limit < ioReserved {
+					continue
+				}
+
+				ioReserved = max64(ioReserved, reservedByChildren)
+			}
+
+			for parent := grp.parentGroup; parent != nil; parent = parent.parentGroup {
+				limits := allQuotas[parent.Name]
+				if limits == nil || limits.IOLimits[dev.Device][kind] == 0 {
+					continue
+				}
+				available := limits.IOLimits[dev.Device][kind] - (limits.IOReservedByChildren[dev.Device][kind] - ioReserved)
+				if limit > available {
+					return fmt.Errorf("sub-group io %s limit of %s for device %q is too large to fit inside group %q remaining quota space %s",
+						ioLimitNames[kind], fmtIOLimit(kind, limit), dev.Device, parent.Name, fmtIOLimit(kind, available))
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
 // validateQuotasFit verifies that the given group's current limits fits correctly
 // into the group's parent group's limits. This is done in multiple steps, where the first
 // one is to get a statistics for the upper-most parent group, to get a combined overview
@@ -732,7 +1162,14 @@ func (grp *Group) validateQuotasFit(resourceLimits Resources) error {
 	// for each limit we want to set, we need to find the closes parent
 	// limit that matches it, and then verify against it's usage if we have room
 	if resourceLimits.Memory != nil {
-		if err := grp.validateMemoryResourceFit(allQuotas, resourceLimits.Memory.Limit); err != nil {
+		// a zero limit keeps the current one when changing the other
+		// memory limits
+		if resourceLimits.Memory.Limit != 0 {
+			if err := grp.validateMemoryResourceFit(allQuotas, resourceLimits.Memory.Limit); err != nil {
+				return err
+			}
+		}
+		if err := grp.validateMemoryCapsFit(resourceLimits.Memory); err != nil {
 			return err
 		}
 	}
@@ -751,6 +1188,16 @@ func (grp *Group) validateQuotasFit(resourceLimits Resources) error {
 			return err
 		}
 	}
+	if resourceLimits.IO != nil {
+		if err := grp.validateIOResourceFit(allQuotas, resourceLimits.IO); err != nil {
+			return err
+		}
+	}
+	if resourceLimits.Network != nil {
+		if err := grp.validateNetworkResourceFit(allQuotas, resourceLimits.Network.EgressBandwidth); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -769,7 +1216,19 @@ func (grp *Group) UpdateQuotaLimits(resourceLimits Resources) error {
 	}
 
 	if resourceLimits.Memory != nil {
-		grp.MemoryLimit = resourceLimits.Memory.Limit
+		if resourceLimits.Memory.Limit != 0 {
+			grp.MemoryLimit = resourceLimits.Memory.Limit
+		}
+		if resourceLimits.Memory.High != 0 {
+			grp.MemoryHighLimit = resourceLimits.Memory.High
+		}
+		if resourceLimits.Memory.SwapMax != nil {
+			swapMax := *resourceLimits.Memory.SwapMax
+			grp.MemorySwapLimit = &swapMax
+		}
+		if resourceLimits.Memory.OOMPolicy != "" {
+			grp.OOMPolicy = resourceLimits.Memory.OOMPolicy
+		}
 	}
 	if resourceLimits.CPU != nil {
 		grp.CPULimit = &GroupQuotaCPU{
@@ -799,6 +1258,21 @@ func (grp *Group) UpdateQuotaLimits(resourceLimits Resources) error {
 			grp.JournalLimit.RatePeriod = resourceLimits.Journal.Rate.Period
 		}
 	}
+	if resourceLimits.IO != nil {
+		// limits of devices, or of a device, that are not given are kept
+		ioLimits := currentLimits.IO
+		if ioLimits == nil {
+			ioLimits = &ResourceIO{}
+		}
+		ioLimits.merge(resourceLimits.IO)
+		grp.IOLimits = make([]GroupQuotaIO, 0, len(ioLimits.Devices))
+		for _, dev := range ioLimits.Devices {
+			grp.IOLimits = append(grp.IOLimits, GroupQuotaIO(dev))
+		}
+	}
+	if resourceLimits.Network != nil {
+		grp.NetworkEgressLimit = resourceLimits.Network.EgressBandwidth
+	}
 	return nil
 }
 
@@ -836,6 +1310,12 @@ func (grp *Group) validate() error {
 	if len(grp.Services) > 0 && grp.JournalLimit != nil {
 		return fmt.Errorf("journal quota is not supported for individual services")
 	}
+
+	// The user apps of a snap follow the snap, so individual services cannot
+	// bring them along.
+	if len(grp.Services) > 0 && grp.UserApps {
+		return fmt.Errorf("user apps are not supported for groups of individual services")
+	}
 	return nil
 }
 
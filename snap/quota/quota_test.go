@@ -22,11 +22,14 @@ package quota_test
 import (
 	"fmt"
 	"math"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	. "gopkg.in/check.v1"
 
+	"github.com/snapcore/snapd/dirs"
 	"github.com/snapcore/snapd/gadget/quantity"
 	"github.com/snapcore/snapd/snap/quota"
 	"github.com/snapcore/snapd/systemd"
@@ -609,6 +612,18 @@ func (ts *quotaTestSuite) TestResolveCrossReferences(c *C) {
 			err:     `group "foogroup" is invalid: journal quota is not supported for individual services`,
 			comment: "setting a journal quota for a group with services is not allowed",
 		},
+		{
+			grps: map[string]*quota.Group{
+				"foogroup": {
+					Name:        "foogroup",
+					MemoryLimit: quantity.SizeMiB,
+					Services:    []string{"snap.svc"},
+					UserApps:    true,
+				},
+			},
+			err:     `group "foogroup" is invalid: user apps are not supported for groups of individual services`,
+			comment: "including user apps in a group with services is not allowed",
+		},
 	}
 
 	for _, t := range tt {
@@ -1492,6 +1507,276 @@ func (ts *quotaTestSuite) TestAddingNewMiddleParentThreadLimits(c *C) {
 	c.Check(err, IsNil)
 }
 
+func (ts *quotaTestSuite) TestIOLimitsSetAndUpdatedCorrectly(c *C) {
+	grp, err := quota.NewGroup("groot", quota.NewResourcesBuilder().
+		WithIOReadBandwidth("/dev/sda", 10*quantity.SizeMiB).
+		WithIOWriteIOPS("/dev/sda", 100).
+		Build())
+	c.Assert(err, IsNil)
+	c.Check(grp.IOLimits, DeepEquals, []quota.GroupQuotaIO{
+		{Device: "/dev/sda", ReadBandwidth: 10 * quantity.SizeMiB, WriteIOPS: 100},
+	})
+
+	// limits not given are kept, and can be decreased
+	err = grp.UpdateQuotaLimits(quota.NewResourcesBuilder().
+		WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).
+		WithIOReadIOPS("/dev/mmcblk0", 50).
+		Build())
+	c.Assert(err, IsNil)
+	c.Check(grp.IOLimits, DeepEquals, []quota.GroupQuotaIO{
+		{Device: "/dev/sda", ReadBandwidth: quantity.SizeMiB, WriteIOPS: 100},
+		{Device: "/dev/mmcblk0", ReadIOPS: 50},
+	})
+	c.Check(grp.GetQuotaResources(), DeepEquals, quota.NewResourcesBuilder().
+		WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).
+		WithIOWriteIOPS("/dev/sda", 100).
+		WithIOReadIOPS("/dev/mmcblk0", 50).
+		Build())
+}
+
+func (ts *quotaTestSuite) TestNestingOfIOLimits(c *C) {
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().
+		WithIOWriteBandwidth("/dev/sda", 10*quantity.SizeMiB).
+		WithIOWriteIOPS("/dev/sda", 100).
+		Build())
+	c.Assert(err, IsNil)
+
+	// limits of other devices or kinds are not constrained by the parent
+	subgrp1, err := grp1.NewSubGroup("io-sub1", quota.NewResourcesBuilder().
+		WithIOWriteBandwidth("/dev/sda", 6*quantity.SizeMiB).
+		WithIOReadBandwidth("/dev/sda", 20*quantity.SizeMiB).
+		WithIOWriteBandwidth("/dev/sdb", 20*quantity.SizeMiB).
+		Build())
+	c.Assert(err, IsNil)
+
+	cpusub, err := grp1.NewSubGroup("cpu-sub", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+
+	// the siblings together cannot go above the limit of the parent, also
+	// when nested further
+	_, err = cpusub.NewSubGroup("io-sub2", quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", 5*quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group io write bandwidth limit of 5 MiB/s for device "/dev/sda" is too large to fit inside group "groot" remaining quota space 4 MiB/s`)
+	subgrp2, err := cpusub.NewSubGroup("io-sub2", quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", 4*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	_, err = subgrp2.NewSubGroup("io-sub3", quota.NewResourcesBuilder().WithIOWriteIOPS("/dev/sda", 101).Build())
+	c.Check(err, ErrorMatches, `sub-group io write iops limit of 101 for device "/dev/sda" is too large to fit inside group "groot" remaining quota space 100`)
+
+	allReservations := grp1.InspectInternalQuotaAllocations()
+	c.Check(allReservations["groot"].IOReservedByChildren, HasLen, 2)
+
+	// a sub-group can only grow into what is left
+	err = subgrp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", 7*quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group io write bandwidth limit of 7 MiB/s for device "/dev/sda" is too large to fit inside group "groot" remaining quota space 6 MiB/s`)
+
+	// and the parent cannot go below what its children use
+	err = grp1.QuotaUpdateCheck(quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", 8*quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `group io write bandwidth limit of 8 MiB/s for device "/dev/sda" is too small to fit current subgroup usage of 10 MiB/s`)
+	err = cpusub.QuotaUpdateCheck(quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `group io write bandwidth limit of 1 MiB/s for device "/dev/sda" is too small to fit current subgroup usage of 4 MiB/s`)
+	err = grp1.QuotaUpdateCheck(quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", 20*quantity.SizeMiB).Build())
+	c.Check(err, IsNil)
+}
+
+func (ts *quotaTestSuite) TestNestingOfNetworkLimits(c *C) {
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(10*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(grp1.NetworkEgressLimit, Equals, 10*quantity.SizeMiB)
+
+	subgrp1, err := grp1.NewSubGroup("net-sub1", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(6*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	cpusub, err := grp1.NewSubGroup("cpu-sub", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+
+	// the siblings together cannot go above the limit of the parent, also
+	// when nested further
+	_, err = cpusub.NewSubGroup("net-sub2", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(5*quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group network egress limit of 5 MiB/s is too large to fit inside group "groot" remaining quota space 4 MiB/s`)
+	_, err = cpusub.NewSubGroup("net-sub2", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(4*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	allReservations := grp1.InspectInternalQuotaAllocations()
+	c.Check(allReservations["groot"].NetworkEgressReservedByChildren, Equals, 10*quantity.SizeMiB)
+	c.Check(allReservations["cpu-sub"].NetworkEgressReservedByChildren, Equals, 4*quantity.SizeMiB)
+
+	// a sub-group can only grow into what is left
+	err = subgrp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithNetworkEgressBandwidth(7 * quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group network egress limit of 7 MiB/s is too large to fit inside group "groot" remaining quota space 6 MiB/s`)
+
+	// and the parent cannot go below what its children use
+	err = grp1.QuotaUpdateCheck(quota.NewResourcesBuilder().WithNetworkEgressBandwidth(8 * quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `group network egress limit of 8 MiB/s is too small to fit current subgroup usage of 10 MiB/s`)
+
+	// but it can be decreased otherwise
+	err = subgrp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(subgrp1.NetworkEgressLimit, Equals, quantity.SizeMiB)
+}
+
+func (ts *quotaTestSuite) TestNestingOfMemoryHighAndSwapLimits(c *C) {
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(512*quantity.SizeMiB).WithMemorySwapMax(256*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(grp1.MemoryHighLimit, Equals, 512*quantity.SizeMiB)
+	c.Assert(grp1.MemorySwapLimit, NotNil)
+	c.Check(*grp1.MemorySwapLimit, Equals, 256*quantity.SizeMiB)
+
+	cpusub, err := grp1.NewSubGroup("cpu-sub", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+
+	// the limits of the closest parent that has them apply, and they are
+	// not reserved by the siblings
+	_, err = cpusub.NewSubGroup("mem-sub1", quota.NewResourcesBuilder().WithMemoryLimit(512*quantity.SizeMiB).WithMemoryHigh(384*quantity.SizeMiB).WithMemorySwapMax(512*quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group memory swap limit of 512 MiB is too large to fit inside group "groot" memory swap limit of 256 MiB`)
+	subgrp1, err := cpusub.NewSubGroup("mem-sub1", quota.NewResourcesBuilder().WithMemoryLimit(256*quantity.SizeMiB).WithMemoryHigh(128*quantity.SizeMiB).WithMemorySwapMax(256*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	_, err = grp1.NewSubGroup("mem-sub2", quota.NewResourcesBuilder().WithMemoryLimit(512*quantity.SizeMiB).WithMemoryHigh(256*quantity.SizeMiB).WithMemorySwapMax(256*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	// the limits of a sub-group can be changed on their own
+	err = subgrp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithMemoryHigh(64 * quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(subgrp1.MemoryLimit, Equals, 256*quantity.SizeMiB)
+	c.Check(subgrp1.MemoryHighLimit, Equals, 64*quantity.SizeMiB)
+
+	// but not go above the limit
+	err = subgrp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithMemoryHigh(384 * quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `memory high limit 384 MiB is larger than the memory limit 256 MiB`)
+
+	// and the parent cannot go below what its children have
+	err = grp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithMemorySwapMax(128 * quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `group memory swap limit of 128 MiB is too small to fit sub-group "mem-sub1" memory swap limit of 256 MiB`)
+	err = grp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithMemoryHigh(128 * quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `group memory high limit of 128 MiB is too small to fit sub-group "mem-sub2" memory high limit of 256 MiB`)
+}
+
+func (ts *quotaTestSuite) TestMemorySwapLimitZero(c *C) {
+	// a zero swap limit is kept, as it disables swap
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemorySwapMax(0).Build())
+	c.Assert(err, IsNil)
+	c.Assert(grp1.MemorySwapLimit, NotNil)
+	c.Check(*grp1.MemorySwapLimit, Equals, quantity.Size(0))
+	c.Check(grp1.GetQuotaResources().Memory.SwapMax, DeepEquals, grp1.MemorySwapLimit)
+
+	// and no sub-group can use swap then
+	_, err = grp1.NewSubGroup("mem-sub1", quota.NewResourcesBuilder().WithMemoryLimit(512*quantity.SizeMiB).WithMemorySwapMax(quantity.SizeMiB).Build())
+	c.Check(err, ErrorMatches, `sub-group memory swap limit of 1 MiB is too large to fit inside group "groot" memory swap limit of 0 B`)
+	subgrp1, err := grp1.NewSubGroup("mem-sub1", quota.NewResourcesBuilder().WithMemoryLimit(512*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(subgrp1.MemorySwapLimit, IsNil)
+
+	// swap can be allowed again with a non-zero limit
+	err = grp1.UpdateQuotaLimits(quota.NewResourcesBuilder().WithMemorySwapMax(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(*grp1.MemorySwapLimit, Equals, quantity.SizeMiB)
+}
+
+func (ts *quotaTestSuite) TestEffectiveOOMPolicy(c *C) {
+	grp, err := quota.NewGroup("foo", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build())
+	c.Assert(err, IsNil)
+	sub, err := grp.NewSubGroup("bar", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+	subsub, err := sub.NewSubGroup("baz", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).WithOOMPolicy(quota.OOMPolicyKillProcess).Build())
+	c.Assert(err, IsNil)
+	c.Check(grp.EffectiveOOMPolicy(), Equals, quota.OOMPolicyKillGroup)
+	c.Check(sub.EffectiveOOMPolicy(), Equals, quota.OOMPolicyKillGroup)
+	c.Check(subsub.EffectiveOOMPolicy(), Equals, quota.OOMPolicyKillProcess)
+
+	other, err := quota.NewGroup("other", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build())
+	c.Assert(err, IsNil)
+	c.Check(other.EffectiveOOMPolicy(), Equals, "")
+}
+
+func (ts *quotaTestSuite) TestNetworkQuotaGroups(c *C) {
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(10*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	cpusub, err := grp1.NewSubGroup("cpu-sub", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+	netsub, err := cpusub.NewSubGroup("net-sub", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	other, err := quota.NewGroup("other", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+
+	c.Check(grp1.NetworkQuotaGroups(), DeepEquals, []*quota.Group{grp1})
+	c.Check(cpusub.NetworkQuotaGroups(), DeepEquals, []*quota.Group{grp1})
+	c.Check(netsub.NetworkQuotaGroups(), DeepEquals, []*quota.Group{grp1, netsub})
+	c.Check(netsub.NetworkQuotaSet(), Equals, true)
+	c.Check(other.NetworkQuotaGroups(), HasLen, 0)
+	c.Check(other.NetworkQuotaSet(), Equals, false)
+
+	c.Check(grp1.SliceCgroupPath(), Equals, "snap.groot.slice")
+	c.Check(netsub.SliceCgroupPath(), Equals, `snap.groot.slice/snap.groot-cpu\x2dsub.slice/snap.groot-cpu\x2dsub-net\x2dsub.slice`)
+	c.Check(netsub.NetworkTableName(), Equals, "snap-quota-net-sub")
+	c.Check(netsub.NetworkRulesFile(), Equals, filepath.Join(dirs.SnapNetworkQuotaDir, "snap.net-sub.nft"))
+}
+
+func (ts *quotaTestSuite) TestUserApps(c *C) {
+	dirs.SetRootDir(c.MkDir())
+	defer dirs.SetRootDir("")
+
+	grp1, err := quota.NewGroup("groot", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build())
+	c.Assert(err, IsNil)
+	sub, err := grp1.NewSubGroup("sub", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	other, err := quota.NewGroup("other", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build())
+	c.Assert(err, IsNil)
+
+	c.Check(grp1.UserSliceNeeded(), Equals, false)
+	c.Check(sub.UserSliceNeeded(), Equals, false)
+
+	// the slices of the parents are needed too
+	sub.UserApps = true
+	c.Check(grp1.UserSliceNeeded(), Equals, true)
+	c.Check(sub.UserSliceNeeded(), Equals, true)
+	c.Check(other.UserSliceNeeded(), Equals, false)
+
+	c.Check(sub.UserSliceFile(), Equals, filepath.Join(dirs.SnapUserServicesDir, "snap.groot-sub.slice"))
+
+	slice, err := quota.UserAppsSlice("some-snap")
+	c.Assert(err, IsNil)
+	c.Check(slice, Equals, "")
+
+	c.Assert(os.MkdirAll(dirs.SnapUserAppsQuotaDir, 0755), IsNil)
+	c.Assert(os.WriteFile(quota.UserAppsSliceFile("some-snap"), []byte("snap.groot-sub.slice\n"), 0644), IsNil)
+	slice, err = quota.UserAppsSlice("some-snap")
+	c.Assert(err, IsNil)
+	c.Check(slice, Equals, "snap.groot-sub.slice")
+}
+
+func (ts *quotaTestSuite) TestCurrentNetworkEgressUsage(c *C) {
+	systemctlCalls := 0
+	r := systemd.MockSystemctl(func(args ...string) ([]byte, error) {
+		systemctlCalls++
+		switch systemctlCalls {
+		case 1:
+			// the slice does not exist yet
+			c.Assert(args, DeepEquals, []string{"is-active", "snap.group.slice"})
+			return []byte("inactive"), systemctlInactiveServiceError{}
+		case 2:
+			c.Assert(args, DeepEquals, []string{"is-active", "snap.group.slice"})
+			return []byte("active"), nil
+		case 3:
+			c.Assert(args, DeepEquals, []string{"show", "--property", "IPEgressBytes", "snap.group.slice"})
+			return []byte("IPEgressBytes=1048576"), nil
+		default:
+			c.Errorf("too many systemctl calls (%d) (current call is %+v)", systemctlCalls, args)
+			return []byte("broken test"), fmt.Errorf("broken test")
+		}
+	})
+	defer r()
+
+	grp, err := quota.NewGroup("group", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	egress, err := grp.CurrentNetworkEgressUsage()
+	c.Assert(err, IsNil)
+	c.Check(egress, Equals, quantity.Size(0))
+
+	egress, err = grp.CurrentNetworkEgressUsage()
+	c.Assert(err, IsNil)
+	c.Check(egress, Equals, quantity.SizeMiB)
+	c.Check(systemctlCalls, Equals, 3)
+}
+
 func (ts *quotaTestSuite) TestCombinedCpuPercentageWithCpuSetLimits(c *C) {
 	// mock the CPU count to be above 2
 	restore := quota.MockRuntimeNumCPU(func() int { return 4 })
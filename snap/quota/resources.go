@@ -21,9 +21,11 @@ package quota
 
 import (
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/snapcore/snapd/gadget/quantity"
+	"github.com/snapcore/snapd/osutil"
 	"github.com/snapcore/snapd/sandbox/cgroup"
 )
 
@@ -39,8 +41,31 @@ func init() {
 	cgroupCheckMemoryCgroupErr = cgroup.CheckMemoryCgroup()
 }
 
+// Memory OOM policies, deciding what is killed when the memory limit of a
+// group is reached.
+const (
+	// OOMPolicyKillGroup kills all the processes of the service of the
+	// group the process chosen by the kernel OOM killer belongs to.
+	OOMPolicyKillGroup = "kill-group"
+	// OOMPolicyKillProcess only kills the process chosen by the kernel
+	// OOM killer.
+	OOMPolicyKillProcess = "kill-process"
+)
+
+// ResourceMemory represents the memory limits. Limit is the hard limit, while
+// High is the limit above which the processes are throttled and reclaimed
+// from, and SwapMax is the limit of swap usage. A zero value for High means no
+// limit, while a nil SwapMax means no limit and a zero one no swap.
 type ResourceMemory struct {
-	Limit quantity.Size `json:"limit"`
+	Limit     quantity.Size  `json:"limit"`
+	High      quantity.Size  `json:"high,omitempty"`
+	SwapMax   *quantity.Size `json:"swap-max,omitempty"`
+	OOMPolicy string         `json:"oom-policy,omitempty"`
+}
+
+// unsetExceptLimit returns true if only the hard limit is set, if at all.
+func (mem *ResourceMemory) unsetExceptLimit() bool {
+	return mem.High == 0 && mem.SwapMax == nil && mem.OOMPolicy == ""
 }
 
 type ResourceCPU struct {
@@ -75,6 +100,42 @@ type ResourceJournal struct {
 	Rate *ResourceJournalRate `json:"rate,omitempty"`
 }
 
+// ResourceIODevice represents the IO limits for a single block device. The
+// bandwidths are in bytes per second, and a zero value means no limit.
+type ResourceIODevice struct {
+	// Device is the path of the block device, or of a file on the
+	// filesystem it backs.
+	Device         string        `json:"device"`
+	ReadBandwidth  quantity.Size `json:"read-bandwidth,omitempty"`
+	WriteBandwidth quantity.Size `json:"write-bandwidth,omitempty"`
+	ReadIOPS       int           `json:"read-iops,omitempty"`
+	WriteIOPS      int           `json:"write-iops,omitempty"`
+}
+
+func (dev *ResourceIODevice) unset() bool {
+	return dev.ReadBandwidth == 0 && dev.WriteBandwidth == 0 && dev.ReadIOPS == 0 && dev.WriteIOPS == 0
+}
+
+type ResourceIO struct {
+	Devices []ResourceIODevice `json:"devices"`
+}
+
+// device returns the limits for the given device, or nil if there are none.
+func (io *ResourceIO) device(device string) *ResourceIODevice {
+	for i := range io.Devices {
+		if io.Devices[i].Device == device {
+			return &io.Devices[i]
+		}
+	}
+	return nil
+}
+
+// ResourceNetwork represents the network limits, the egress bandwidth is in
+// bytes per second.
+type ResourceNetwork struct {
+	EgressBandwidth quantity.Size `json:"egress-bandwidth"`
+}
+
 // Resources are built up of multiple quota limits. Each quota limit is a pointer
 // value to indicate that their presence may be optional, and because we want to detect
 // whenever someone changes a limit to '0' explicitly.
@@ -84,6 +145,8 @@ type Resources struct {
 	CPUSet  *ResourceCPUSet  `json:"cpu-set,omitempty"`
 	Threads *ResourceThreads `json:"thread,omitempty"`
 	Journal *ResourceJournal `json:"journal,omitempty"`
+	IO      *ResourceIO      `json:"io,omitempty"`
+	Network *ResourceNetwork `json:"network,omitempty"`
 }
 
 const (
@@ -106,6 +169,28 @@ func (qr *Resources) validateMemoryQuota() error {
 	if qr.Memory.Limit == 0 {
 		return fmt.Errorf("memory quota must have a limit set")
 	}
+	return validateMemorySettings(qr.Memory)
+}
+
+// validateMemorySettings verifies the memory limits other than the hard one,
+// against the given hard limit.
+func validateMemorySettings(mem *ResourceMemory) error {
+	if mem.High != 0 {
+		if mem.High <= memoryLimitMin {
+			return fmt.Errorf("memory high limit %d is too small: size must be larger than %s",
+				mem.High, memoryLimitMin.IECString())
+		}
+		if mem.High > mem.Limit {
+			return fmt.Errorf("memory high limit %s is larger than the memory limit %s",
+				mem.High.IECString(), mem.Limit.IECString())
+		}
+	}
+	switch mem.OOMPolicy {
+	case "", OOMPolicyKillGroup, OOMPolicyKillProcess:
+	default:
+		return fmt.Errorf("invalid memory oom policy %q, must be %q or %q",
+			mem.OOMPolicy, OOMPolicyKillGroup, OOMPolicyKillProcess)
+	}
 	return nil
 }
 
@@ -186,6 +271,38 @@ func (qr *Resources) validateJournalQuota() error {
 	return nil
 }
 
+func (qr *Resources) validateIOQuota() error {
+	if len(qr.IO.Devices) == 0 {
+		return fmt.Errorf("io quota must have limits for at least one device")
+	}
+
+	seen := make(map[string]bool, len(qr.IO.Devices))
+	for _, dev := range qr.IO.Devices {
+		if !filepath.IsAbs(dev.Device) || filepath.Clean(dev.Device) != dev.Device {
+			return fmt.Errorf("invalid io quota device %q: must be a clean absolute path", dev.Device)
+		}
+		if seen[dev.Device] {
+			return fmt.Errorf("io quota has more than one set of limits for device %q", dev.Device)
+		}
+		seen[dev.Device] = true
+
+		if dev.ReadBandwidth < 0 || dev.WriteBandwidth < 0 || dev.ReadIOPS < 0 || dev.WriteIOPS < 0 {
+			return fmt.Errorf("io quota for device %q must not have negative limits", dev.Device)
+		}
+		if dev.unset() {
+			return fmt.Errorf("io quota for device %q must have a limit set", dev.Device)
+		}
+	}
+	return nil
+}
+
+func (qr *Resources) validateNetworkQuota() error {
+	if qr.Network.EgressBandwidth == 0 {
+		return fmt.Errorf("network quota must have an egress bandwidth limit set")
+	}
+	return nil
+}
+
 // CheckFeatureRequirements checks if the current system meets the
 // requirements for the given resource request.
 //
@@ -202,6 +319,41 @@ func (qr *Resources) CheckFeatureRequirements() error {
 	if qr.Memory != nil && cgroupCheckMemoryCgroupErr != nil {
 		return fmt.Errorf("cannot use memory quota: %v", cgroupCheckMemoryCgroupErr)
 	}
+	if qr.Memory != nil && (qr.Memory.High != 0 || qr.Memory.SwapMax != nil) {
+		if cgroupVerErr != nil {
+			return cgroupVerErr
+		}
+		// memory.high and memory.swap.max only exist with the unified
+		// memory controller
+		if cgroupVer < 2 {
+			return fmt.Errorf("cannot use memory high or swap limits with cgroup version %d", cgroupVer)
+		}
+	}
+	if qr.IO != nil {
+		if cgroupVerErr != nil {
+			return cgroupVerErr
+		}
+		// systemd only supports the IO*Max settings with the unified
+		// io controller
+		if cgroupVer < 2 {
+			return fmt.Errorf("cannot use io quota with cgroup version %d", cgroupVer)
+		}
+	}
+	if qr.Network != nil {
+		if cgroupVerErr != nil {
+			return cgroupVerErr
+		}
+		// the traffic of the group is matched by its cgroup, which
+		// netfilter can only do with the unified hierarchy
+		if cgroupVer < 2 {
+			return fmt.Errorf("cannot use network quota with cgroup version %d", cgroupVer)
+		}
+		// the rules of the group are loaded with nft before any of
+		// its services start
+		if !osutil.IsExecutable(NftCommand) {
+			return fmt.Errorf("cannot use network quota: %s is not available", NftCommand)
+		}
+	}
 
 	return nil
 }
@@ -251,6 +403,18 @@ func (qr *Resources) Validate() error {
 			return err
 		}
 	}
+
+	if qr.IO != nil {
+		if err := qr.validateIOQuota(); err != nil {
+			return err
+		}
+	}
+
+	if qr.Network != nil {
+		if err := qr.validateNetworkQuota(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -261,7 +425,15 @@ func (qr *Resources) Validate() error {
 // We also require memory limits are above 640kB.
 func (qr *Resources) ValidateChange(newLimits Resources) error {
 	// Check that the memory limit is not being decreased
-	if newLimits.Memory != nil {
+	if newLimits.Memory != nil && newLimits.Memory.Limit == 0 && qr.Memory != nil && !newLimits.Memory.unsetExceptLimit() {
+		// only the other memory limits are changed, the hard limit is
+		// kept, and the high limit still needs to be below it
+		mem := *newLimits.Memory
+		mem.Limit = qr.Memory.Limit
+		if err := validateMemorySettings(&mem); err != nil {
+			return err
+		}
+	} else if newLimits.Memory != nil {
 		if qr.Memory != nil && newLimits.Memory.Limit == 0 {
 			return fmt.Errorf("cannot remove memory limit from quota group")
 		}
@@ -278,6 +450,15 @@ func (qr *Resources) ValidateChange(newLimits Resources) error {
 		if qr.Memory != nil && newLimits.Memory.Limit < qr.Memory.Limit {
 			return fmt.Errorf("cannot decrease memory limit, remove and re-create it to decrease the limit")
 		}
+
+		// the high limit which is kept needs to be below the new limit
+		mem := *newLimits.Memory
+		if qr.Memory != nil && mem.High == 0 {
+			mem.High = qr.Memory.High
+		}
+		if err := validateMemorySettings(&mem); err != nil {
+			return err
+		}
 	}
 
 	// Check that the cpu limit is not being removed, and we want to verify the new limit
@@ -347,6 +528,20 @@ func (qr *Resources) ValidateChange(newLimits Resources) error {
 		// rate-limit for the group, overriding the journal default which is 10000/30s
 	}
 
+	// Verify io limits are not being removed; limits for devices not
+	// mentioned are kept, and so are the limits of a device that are not
+	// set. IO limits can be decreased, as the io controller throttles
+	// instead of killing processes.
+	if newLimits.IO != nil && len(newLimits.IO.Devices) == 0 && qr.IO != nil {
+		return fmt.Errorf("cannot remove io limits from quota group")
+	}
+
+	// Verify the network limit is not being removed, like with io limits it
+	// can be decreased as the traffic is throttled.
+	if qr.Network != nil && newLimits.Network != nil && newLimits.Network.EgressBandwidth == 0 {
+		return fmt.Errorf("cannot remove network limit from quota group")
+	}
+
 	return nil
 }
 
@@ -354,7 +549,8 @@ func (qr *Resources) ValidateChange(newLimits Resources) error {
 func (qr *Resources) clone() Resources {
 	var resourcesCopy Resources
 	if qr.Memory != nil {
-		resourcesCopy.Memory = &ResourceMemory{Limit: qr.Memory.Limit}
+		memCopy := *qr.Memory
+		resourcesCopy.Memory = &memCopy
 	}
 	if qr.CPU != nil {
 		resourcesCopy.CPU = &ResourceCPU{Count: qr.CPU.Count, Percentage: qr.CPU.Percentage}
@@ -374,13 +570,24 @@ func (qr *Resources) clone() Resources {
 			resourcesCopy.Journal.Rate = &ResourceJournalRate{Count: qr.Journal.Rate.Count, Period: qr.Journal.Rate.Period}
 		}
 	}
+	if qr.IO != nil {
+		resourcesCopy.IO = &ResourceIO{
+			Devices: append([]ResourceIODevice(nil), qr.IO.Devices...),
+		}
+	}
+	if qr.Network != nil {
+		resourcesCopy.Network = &ResourceNetwork{EgressBandwidth: qr.Network.EgressBandwidth}
+	}
 	return resourcesCopy
 }
 
 // changeInternal applies each new limit provided
 func (qr *Resources) changeInternal(newLimits Resources) {
 	if newLimits.Memory != nil {
-		qr.Memory = newLimits.Memory
+		if qr.Memory == nil {
+			qr.Memory = &ResourceMemory{}
+		}
+		qr.Memory.merge(newLimits.Memory)
 	}
 	if newLimits.CPU != nil {
 		qr.CPU = newLimits.CPU
@@ -402,6 +609,57 @@ func (qr *Resources) changeInternal(newLimits Resources) {
 			qr.Journal.Rate = newLimits.Journal.Rate
 		}
 	}
+	if newLimits.IO != nil {
+		if qr.IO == nil {
+			qr.IO = &ResourceIO{}
+		}
+		qr.IO.merge(newLimits.IO)
+	}
+	if newLimits.Network != nil {
+		qr.Network = newLimits.Network
+	}
+}
+
+// merge applies the memory limits that are set in newLimits, the ones that are
+// not set are kept.
+func (mem *ResourceMemory) merge(newLimits *ResourceMemory) {
+	if newLimits.Limit != 0 {
+		mem.Limit = newLimits.Limit
+	}
+	if newLimits.High != 0 {
+		mem.High = newLimits.High
+	}
+	if newLimits.SwapMax != nil {
+		swapMax := *newLimits.SwapMax
+		mem.SwapMax = &swapMax
+	}
+	if newLimits.OOMPolicy != "" {
+		mem.OOMPolicy = newLimits.OOMPolicy
+	}
+}
+
+// merge applies the limits that are set in newLimits, adding the devices
+// that have no limits yet.
+func (io *ResourceIO) merge(newLimits *ResourceIO) {
+	for _, newDev := range newLimits.Devices {
+		dev := io.device(newDev.Device)
+		if dev == nil {
+			io.Devices = append(io.Devices, newDev)
+			continue
+		}
+		if newDev.ReadBandwidth != 0 {
+			dev.ReadBandwidth = newDev.ReadBandwidth
+		}
+		if newDev.WriteBandwidth != 0 {
+			dev.WriteBandwidth = newDev.WriteBandwidth
+		}
+		if newDev.ReadIOPS != 0 {
+			dev.ReadIOPS = newDev.ReadIOPS
+		}
+		if newDev.WriteIOPS != 0 {
+			dev.WriteIOPS = newDev.WriteIOPS
+		}
+	}
 }
 
 // Change updates the current quota limits with the new limits. Additional verification
@@ -29,6 +29,15 @@ type ResourcesBuilder struct {
 	MemoryLimit    quantity.Size
 	MemoryLimitSet bool
 
+	MemoryHigh    quantity.Size
+	MemoryHighSet bool
+
+	MemorySwapMax    quantity.Size
+	MemorySwapMaxSet bool
+
+	OOMPolicy    string
+	OOMPolicySet bool
+
 	CPUCount    int
 	CPUCountSet bool
 
@@ -49,6 +58,12 @@ type ResourcesBuilder struct {
 	JournalRateCountLimit  int
 	JournalRatePeriodLimit time.Duration
 	JournalRateSet         bool
+
+	IOLimits    []ResourceIODevice
+	IOLimitsSet bool
+
+	NetworkEgressLimit    quantity.Size
+	NetworkEgressLimitSet bool
 }
 
 func (rb *ResourcesBuilder) WithMemoryLimit(limit quantity.Size) *ResourcesBuilder {
@@ -57,6 +72,24 @@ func (rb *ResourcesBuilder) WithMemoryLimit(limit quantity.Size) *ResourcesBuild
 	return rb
 }
 
+func (rb *ResourcesBuilder) WithMemoryHigh(limit quantity.Size) *ResourcesBuilder {
+	rb.MemoryHigh = limit
+	rb.MemoryHighSet = true
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithMemorySwapMax(limit quantity.Size) *ResourcesBuilder {
+	rb.MemorySwapMax = limit
+	rb.MemorySwapMaxSet = true
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithOOMPolicy(policy string) *ResourcesBuilder {
+	rb.OOMPolicy = policy
+	rb.OOMPolicySet = true
+	return rb
+}
+
 func (rb *ResourcesBuilder) WithCPUCount(count int) *ResourcesBuilder {
 	rb.CPUCount = count
 	rb.CPUCountSet = true
@@ -99,11 +132,55 @@ func (rb *ResourcesBuilder) WithJournalRate(count int, period time.Duration) *Re
 	return rb
 }
 
+// ioDevice returns the limits of the given device, adding them if needed.
+func (rb *ResourcesBuilder) ioDevice(device string) *ResourceIODevice {
+	rb.IOLimitsSet = true
+	for i := range rb.IOLimits {
+		if rb.IOLimits[i].Device == device {
+			return &rb.IOLimits[i]
+		}
+	}
+	rb.IOLimits = append(rb.IOLimits, ResourceIODevice{Device: device})
+	return &rb.IOLimits[len(rb.IOLimits)-1]
+}
+
+func (rb *ResourcesBuilder) WithIOReadBandwidth(device string, limit quantity.Size) *ResourcesBuilder {
+	rb.ioDevice(device).ReadBandwidth = limit
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithIOWriteBandwidth(device string, limit quantity.Size) *ResourcesBuilder {
+	rb.ioDevice(device).WriteBandwidth = limit
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithIOReadIOPS(device string, limit int) *ResourcesBuilder {
+	rb.ioDevice(device).ReadIOPS = limit
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithIOWriteIOPS(device string, limit int) *ResourcesBuilder {
+	rb.ioDevice(device).WriteIOPS = limit
+	return rb
+}
+
+func (rb *ResourcesBuilder) WithNetworkEgressBandwidth(limit quantity.Size) *ResourcesBuilder {
+	rb.NetworkEgressLimit = limit
+	rb.NetworkEgressLimitSet = true
+	return rb
+}
+
 func (rb *ResourcesBuilder) Build() Resources {
 	var quotaResources Resources
-	if rb.MemoryLimitSet {
+	if rb.MemoryLimitSet || rb.MemoryHighSet || rb.MemorySwapMaxSet || rb.OOMPolicySet {
 		quotaResources.Memory = &ResourceMemory{
-			Limit: rb.MemoryLimit,
+			Limit:     rb.MemoryLimit,
+			High:      rb.MemoryHigh,
+			OOMPolicy: rb.OOMPolicy,
+		}
+		if rb.MemorySwapMaxSet {
+			swapMax := rb.MemorySwapMax
+			quotaResources.Memory.SwapMax = &swapMax
 		}
 	}
 	if rb.CPUCountSet || rb.CPUPercentageSet {
@@ -136,6 +213,16 @@ func (rb *ResourcesBuilder) Build() Resources {
 			}
 		}
 	}
+	if rb.IOLimitsSet {
+		quotaResources.IO = &ResourceIO{
+			Devices: append([]ResourceIODevice(nil), rb.IOLimits...),
+		}
+	}
+	if rb.NetworkEgressLimitSet {
+		quotaResources.Network = &ResourceNetwork{
+			EgressBandwidth: rb.NetworkEgressLimit,
+		}
+	}
 	return quotaResources
 }
 
@@ -21,6 +21,7 @@ package quota_test
 
 import (
 	"fmt"
+	"path/filepath"
 	"reflect"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 
 	"github.com/snapcore/snapd/gadget/quantity"
 	"github.com/snapcore/snapd/snap/quota"
+	"github.com/snapcore/snapd/testutil"
 )
 
 type resourcesTestSuite struct{}
@@ -50,6 +52,20 @@ func (s *resourcesTestSuite) TestQuotaValidationFails(c *C) {
 		{quota.NewResourcesBuilder().WithJournalRate(0, 1).Build(), `journal quota must have a period of at least 1 microsecond \(minimum resolution\)`},
 		{quota.NewResourcesBuilder().WithJournalRate(1, time.Nanosecond).Build(), `journal quota must have a period of at least 1 microsecond \(minimum resolution\)`},
 		{quota.NewResourcesBuilder().WithJournalSize(0).Build(), `journal size quota must have a limit set`},
+		{quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", 0).Build(), `io quota for device "/dev/sda" must have a limit set`},
+		{quota.NewResourcesBuilder().WithIOReadIOPS("/dev/sda", -1).Build(), `io quota for device "/dev/sda" must not have negative limits`},
+		{quota.NewResourcesBuilder().WithIOWriteIOPS("sda", 100).Build(), `invalid io quota device "sda": must be a clean absolute path`},
+		{quota.NewResourcesBuilder().WithIOWriteIOPS("/dev/../sda", 100).Build(), `invalid io quota device "/dev/../sda": must be a clean absolute path`},
+		{quota.Resources{IO: &quota.ResourceIO{}}, `io quota must have limits for at least one device`},
+		{quota.Resources{IO: &quota.ResourceIO{Devices: []quota.ResourceIODevice{
+			{Device: "/dev/sda", ReadIOPS: 10},
+			{Device: "/dev/sda", WriteIOPS: 10},
+		}}}, `io quota has more than one set of limits for device "/dev/sda"`},
+		{quota.NewResourcesBuilder().WithNetworkEgressBandwidth(0).Build(), `network quota must have an egress bandwidth limit set`},
+		{quota.NewResourcesBuilder().WithMemoryHigh(quantity.SizeMiB).Build(), `memory quota must have a limit set`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).WithMemoryHigh(quantity.SizeGiB).Build(), `memory high limit 1 GiB is larger than the memory limit 1 MiB`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeKiB).Build(), `memory high limit 1024 is too small: size must be larger than 640 KiB`},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy("kill-all").Build(), `invalid memory oom policy "kill-all", must be "kill-group" or "kill-process"`},
 	}
 
 	for _, t := range tests {
@@ -69,6 +85,46 @@ func (s *resourcesTestSuite) TestResourceCheckFeatureRequirementsCgroupv1(c *C)
 	// cpu set with cgroup v1 is not supported
 	bad := quota.NewResourcesBuilder().WithCPUSet([]int{0, 1}).Build()
 	c.Check(bad.CheckFeatureRequirements(), ErrorMatches, "cannot use CPU set with cgroup version 1")
+
+	// and neither are io limits
+	bad = quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", quantity.SizeMiB).Build()
+	c.Check(bad.CheckFeatureRequirements(), ErrorMatches, "cannot use io quota with cgroup version 1")
+
+	// nor network limits
+	bad = quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build()
+	c.Check(bad.CheckFeatureRequirements(), ErrorMatches, "cannot use network quota with cgroup version 1")
+
+	// nor memory high or swap limits, unlike the oom policy
+	bad = quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemorySwapMax(quantity.SizeMiB).Build()
+	c.Check(bad.CheckFeatureRequirements(), ErrorMatches, "cannot use memory high or swap limits with cgroup version 1")
+	bad = quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).Build()
+	c.Check(bad.CheckFeatureRequirements(), ErrorMatches, "cannot use memory high or swap limits with cgroup version 1")
+	good = quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build()
+	c.Check(good.CheckFeatureRequirements(), IsNil)
+}
+
+func (s *resourcesTestSuite) TestResourceCheckFeatureRequirementsIOCgroupv2(c *C) {
+	r := quota.MockCgroupVer(2)
+	defer r()
+
+	good := quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", quantity.SizeMiB).Build()
+	c.Check(good.CheckFeatureRequirements(), IsNil)
+
+	nft := testutil.MockCommand(c, "nft", "")
+	defer nft.Restore()
+	restore := testutil.Backup(&quota.NftCommand)
+	defer restore()
+	quota.NftCommand = nft.Exe()
+
+	good = quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build()
+	c.Check(good.CheckFeatureRequirements(), IsNil)
+
+	// the rules cannot be loaded without nft
+	quota.NftCommand = filepath.Join(c.MkDir(), "nft")
+	c.Check(good.CheckFeatureRequirements(), ErrorMatches, `cannot use network quota: .*/nft is not available`)
+
+	good = quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).WithMemorySwapMax(quantity.SizeMiB).Build()
+	c.Check(good.CheckFeatureRequirements(), IsNil)
 }
 
 func (s *resourcesTestSuite) TestResourceCheckFeatureRequirementsCgroupv1Err(c *C) {
@@ -95,6 +151,11 @@ func (s *resourcesTestSuite) TestQuotaValidationPasses(c *C) {
 		{quota.NewResourcesBuilder().WithJournalSize(quantity.SizeMiB).Build()},
 		{quota.NewResourcesBuilder().WithJournalRate(1, time.Microsecond).Build()},
 		{quota.NewResourcesBuilder().WithJournalNamespace().Build()},
+		{quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).WithIOWriteIOPS("/dev/mmcblk0", 100).Build()},
+		{quota.NewResourcesBuilder().WithIOReadIOPS("/dev/disk/by-path/pci-0000:00:1f.2-ata-1", 100).Build()},
+		{quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build()},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).WithMemorySwapMax(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build()},
+		{quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).WithOOMPolicy(quota.OOMPolicyKillProcess).Build()},
 	}
 
 	for _, t := range tests {
@@ -205,6 +266,36 @@ func (s *resourcesTestSuite) TestQuotaChangeValidationFails(c *C) {
 			quota.NewResourcesBuilder().WithJournalSize(5 * quantity.SizeGiB).Build(),
 			`journal size quota must be smaller than 4 GiB`,
 		},
+		{
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).Build(),
+			quota.Resources{IO: &quota.ResourceIO{}},
+			`cannot remove io limits from quota group`,
+		},
+		{
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sdb", 0).Build(),
+			`io quota for device "/dev/sdb" must have a limit set`,
+		},
+		{
+			quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithNetworkEgressBandwidth(0).Build(),
+			`cannot remove network limit from quota group`,
+		},
+		{
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryHigh(quantity.SizeGiB).Build(),
+			`memory high limit 1 GiB is larger than the memory limit 1 MiB`,
+		},
+		{
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(512 * quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy("stop").Build(),
+			`invalid memory oom policy "stop", must be "kill-group" or "kill-process"`,
+		},
+		{
+			quota.NewResourcesBuilder().WithCPUCount(1).WithCPUPercentage(100).Build(),
+			quota.NewResourcesBuilder().WithMemorySwapMax(quantity.SizeGiB).Build(),
+			`memory limit 0 is too small: size must be larger than 640 KiB`,
+		},
 	}
 
 	for _, t := range tests {
@@ -303,6 +394,35 @@ func (s *resourcesTestSuite) TestQuotaChangeValidationPasses(c *C) {
 			quota.NewResourcesBuilder().WithJournalNamespace().Build(),
 			quota.NewResourcesBuilder().WithCPUCount(4).WithCPUPercentage(25).WithJournalNamespace().Build(),
 		},
+		{
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).WithIOReadIOPS("/dev/sda", 100).Build(),
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeKiB).WithIOWriteIOPS("/dev/sda", 50).WithIOReadIOPS("/dev/sdb", 10).Build(),
+			quota.NewResourcesBuilder().WithIOReadBandwidth("/dev/sda", quantity.SizeKiB).WithIOReadIOPS("/dev/sda", 100).WithIOWriteIOPS("/dev/sda", 50).WithIOReadIOPS("/dev/sdb", 10).Build(),
+		},
+		{
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build(),
+			quota.NewResourcesBuilder().WithIOWriteBandwidth("/dev/sda", quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithIOWriteBandwidth("/dev/sda", quantity.SizeMiB).Build(),
+		},
+		{
+			// network limits can be decreased
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithNetworkEgressBandwidth(quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeKiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithNetworkEgressBandwidth(quantity.SizeKiB).Build(),
+		},
+		{
+			// the other memory limits can be changed without the
+			// memory limit, which is kept
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemorySwapMax(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build(),
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).WithMemorySwapMax(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build(),
+		},
+		{
+			// and the high limit can be decreased
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(512 * quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryHigh(quantity.SizeMiB).Build(),
+			quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithMemoryHigh(quantity.SizeMiB).Build(),
+		},
 	}
 
 	for _, t := range tests {
@@ -337,6 +457,19 @@ func (s *resourcesTestSuite) TestResourceCloneComplete(c *C) {
 	}
 }
 
+func (s *resourcesTestSuite) TestResourceBuilderWithIOLimits(c *C) {
+	r := quota.NewResourcesBuilder().
+		WithIOReadBandwidth("/dev/sda", quantity.SizeMiB).
+		WithIOWriteIOPS("/dev/mmcblk0", 100).
+		WithIOWriteBandwidth("/dev/sda", quantity.SizeKiB).
+		Build()
+	c.Assert(r.IO, NotNil)
+	c.Check(r.IO.Devices, DeepEquals, []quota.ResourceIODevice{
+		{Device: "/dev/sda", ReadBandwidth: quantity.SizeMiB, WriteBandwidth: quantity.SizeKiB},
+		{Device: "/dev/mmcblk0", WriteIOPS: 100},
+	})
+}
+
 func (s *resourcesTestSuite) TestResourceBuilerWithJournalNamespaceOnly(c *C) {
 	r := quota.NewResourcesBuilder().WithJournalNamespace().Build()
 	c.Assert(r.Journal, NotNil)
@@ -29,6 +29,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/snapcore/snapd/osutil"
@@ -449,9 +450,31 @@ func Validate(info *Info) error {
 		return err
 	}
 
+	// Ensure the health policy is valid
+	if err := validateHealthPolicy(info.Health); err != nil {
+		return err
+	}
+
 	return ValidateLayoutAll(info)
 }
 
+// MinHealthCheckInterval is the shortest interval at which the
+// check-health hook of a snap can be run periodically.
+const MinHealthCheckInterval = time.Minute
+
+func validateHealthPolicy(health *HealthPolicy) error {
+	if health == nil {
+		return nil
+	}
+	if health.RevertOnError < 0 {
+		return fmt.Errorf("invalid health policy: revert-on-error cannot be negative, got %d", health.RevertOnError)
+	}
+	if interval := time.Duration(health.CheckInterval); interval != 0 && interval < MinHealthCheckInterval {
+		return fmt.Errorf("invalid health policy: check-interval cannot be less than %v, got %v", MinHealthCheckInterval, interval)
+	}
+	return nil
+}
+
 // ValidateBase validates the base field.
 func ValidateBase(info *Info) error {
 	// validate that bases do not have base fields
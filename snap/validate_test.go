@@ -1771,6 +1771,35 @@ apps:
 	}
 }
 
+func (s *ValidateSuite) TestValidateHealthPolicy(c *C) {
+	meta := `
+name: foo
+version: 1.0
+`
+	for i, tc := range []struct {
+		meta string
+		err  string
+	}{
+		{meta, ""},
+		{meta + "health:\n  restart-on-error: true\n", ""},
+		{meta + "health:\n  revert-on-error: 2\n", ""},
+		{meta + "health:\n  revert-on-error: -1\n", `invalid health policy: revert-on-error cannot be negative, got -1`},
+		{meta + "health:\n  check-interval: 1h\n", ""},
+		{meta + "health:\n  check-interval: 30s\n", `invalid health policy: check-interval cannot be less than 1m0s, got 30s`},
+	} {
+		c.Logf("tc #%v", i)
+		info, err := InfoFromSnapYaml([]byte(tc.meta))
+		c.Assert(err, IsNil)
+
+		err = Validate(info)
+		if tc.err == "" {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, tc.err)
+		}
+	}
+}
+
 func (s *validateSuite) TestValidateDescription(c *C) {
 	for _, s := range []string{
 		"xx", // boringest ASCII
@@ -0,0 +1,61 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package peercache
+
+type DNSMessage = dnsMessage
+type DNSTXT = dnsTXT
+
+var (
+	UnpackDNSMessage = unpackDNSMessage
+	BlobName         = blobName
+)
+
+func NewDNSQuery(names []string, unicast bool) *DNSMessage {
+	return &dnsMessage{questions: names, unicast: unicast}
+}
+
+func NewDNSResponse(answers ...DNSTXT) *DNSMessage {
+	return &dnsMessage{response: true, answers: answers}
+}
+
+func NewDNSTXT(name string, ttl uint32, txt ...string) DNSTXT {
+	return dnsTXT{name: name, ttl: ttl, txt: txt}
+}
+
+func (m *DNSMessage) Pack() ([]byte, error) {
+	return m.pack()
+}
+
+func (m *DNSMessage) Response() bool      { return m.response }
+func (m *DNSMessage) Questions() []string { return m.questions }
+func (m *DNSMessage) Unicast() bool       { return m.unicast }
+func (m *DNSMessage) Answers() []DNSTXT   { return m.answers }
+
+func (p *Peer) MDNSLocalAddr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.mdnsConn.LocalAddr().String()
+}
+
+func (p *Peer) LocalIP() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.localIP.String()
+}
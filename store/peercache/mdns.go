@@ -0,0 +1,238 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package peercache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This implements the small subset of the DNS message format (RFC 1035)
+// needed to query for and advertise snap blobs via multicast DNS (RFC
+// 6762): questions and answers for TXT records.
+
+const (
+	dnsTypeTXT = 16
+	dnsTypeANY = 255
+	dnsClassIN = 1
+
+	// dnsClassTopBit is the unicast-response bit of the class of mDNS
+	// questions, and the cache-flush bit of the class of mDNS answers
+	dnsClassTopBit = 1 << 15
+
+	// dnsFlagsResponse are the flags of an authoritative response
+	dnsFlagsResponse = 0x8400
+
+	dnsHeaderLen = 12
+	// maxDNSNameLen is the maximum length of an encoded name
+	maxDNSNameLen = 255
+	// maxDNSLabelLen is the maximum length of a label of a name
+	maxDNSLabelLen = 63
+)
+
+var errDNSShort = errors.New("dns message too short")
+
+type dnsTXT struct {
+	name string
+	ttl  uint32
+	txt  []string
+}
+
+type dnsMessage struct {
+	response bool
+	// questions are the names of the TXT (or ANY) records queried
+	questions []string
+	// unicast is whether a unicast response was requested
+	unicast bool
+	answers []dnsTXT
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendDNSName(buf []byte, name string) ([]byte, error) {
+	if len(name)+2 > maxDNSNameLen {
+		return nil, fmt.Errorf("dns name too long: %q", name)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > maxDNSLabelLen {
+			return nil, fmt.Errorf("invalid dns name %q", name)
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	return append(buf, 0), nil
+}
+
+func (m *dnsMessage) pack() ([]byte, error) {
+	buf := make([]byte, dnsHeaderLen, 512)
+	if m.response {
+		binary.BigEndian.PutUint16(buf[2:], dnsFlagsResponse)
+	}
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answers)))
+
+	var err error
+	for _, q := range m.questions {
+		if buf, err = appendDNSName(buf, q); err != nil {
+			return nil, err
+		}
+		class := uint16(dnsClassIN)
+		if m.unicast {
+			class |= dnsClassTopBit
+		}
+		buf = appendUint16(buf, dnsTypeTXT)
+		buf = appendUint16(buf, class)
+	}
+	for _, a := range m.answers {
+		if buf, err = appendDNSName(buf, a.name); err != nil {
+			return nil, err
+		}
+		var rdata []byte
+		for _, s := range a.txt {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("dns txt string too long: %q", s)
+			}
+			rdata = append(rdata, byte(len(s)))
+			rdata = append(rdata, s...)
+		}
+		buf = appendUint16(buf, dnsTypeTXT)
+		buf = appendUint16(buf, dnsClassIN|dnsClassTopBit)
+		buf = appendUint32(buf, a.ttl)
+		buf = appendUint16(buf, uint16(len(rdata)))
+		buf = append(buf, rdata...)
+	}
+	return buf, nil
+}
+
+// readDNSName reads the possibly compressed name at offset off of msg,
+// returning it lowercased and the offset right after it.
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	// bound the number of pointers followed to not loop forever
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			name := strings.ToLower(strings.Join(labels, "."))
+			if len(name)+2 > maxDNSNameLen {
+				return "", 0, errors.New("dns name too long")
+			}
+			return name, end, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if jumps++; jumps > 10 {
+				return "", 0, errors.New("too many dns name compression pointers")
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		case l > maxDNSLabelLen:
+			return "", 0, fmt.Errorf("invalid dns label length %d", l)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func unpackDNSMessage(msg []byte) (*dnsMessage, error) {
+	if len(msg) < dnsHeaderLen {
+		return nil, errDNSShort
+	}
+	m := &dnsMessage{
+		response: binary.BigEndian.Uint16(msg[2:])&0x8000 != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := dnsHeaderLen
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, errDNSShort
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		class := binary.BigEndian.Uint16(msg[next+2:])
+		off = next + 4
+		if class&^dnsClassTopBit != dnsClassIN || (typ != dnsTypeTXT && typ != dnsTypeANY) {
+			continue
+		}
+		m.questions = append(m.questions, name)
+		if class&dnsClassTopBit != 0 {
+			m.unicast = true
+		}
+	}
+	for i := 0; i < ancount; i++ {
+		name, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSShort
+		}
+		typ := binary.BigEndian.Uint16(msg[next:])
+		class := binary.BigEndian.Uint16(msg[next+2:])
+		ttl := binary.BigEndian.Uint32(msg[next+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[next+8:]))
+		rdata := next + 10
+		off = rdata + rdlen
+		if off > len(msg) {
+			return nil, errDNSShort
+		}
+		if class&^dnsClassTopBit != dnsClassIN || typ != dnsTypeTXT {
+			continue
+		}
+		var txt []string
+		for p := rdata; p < off; {
+			l := int(msg[p])
+			if p+1+l > off {
+				return nil, errDNSShort
+			}
+			txt = append(txt, string(msg[p+1:p+1+l]))
+			p += 1 + l
+		}
+		m.answers = append(m.answers, dnsTXT{name: name, ttl: ttl, txt: txt})
+	}
+	return m, nil
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package peercache_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/store/peercache"
+)
+
+type mdnsSuite struct{}
+
+var _ = Suite(&mdnsSuite{})
+
+func (s *mdnsSuite) TestQueryRoundTrip(c *C) {
+	buf, err := peercache.NewDNSQuery([]string{"foo.bar._snap-blob._tcp.local"}, true).Pack()
+	c.Assert(err, IsNil)
+
+	msg, err := peercache.UnpackDNSMessage(buf)
+	c.Assert(err, IsNil)
+	c.Check(msg.Response(), Equals, false)
+	c.Check(msg.Unicast(), Equals, true)
+	c.Check(msg.Questions(), DeepEquals, []string{"foo.bar._snap-blob._tcp.local"})
+	c.Check(msg.Answers(), HasLen, 0)
+}
+
+func (s *mdnsSuite) TestResponseRoundTrip(c *C) {
+	answer := peercache.NewDNSTXT("foo.bar._snap-blob._tcp.local", 120, "port=1234", "other")
+	buf, err := peercache.NewDNSResponse(answer).Pack()
+	c.Assert(err, IsNil)
+
+	msg, err := peercache.UnpackDNSMessage(buf)
+	c.Assert(err, IsNil)
+	c.Check(msg.Response(), Equals, true)
+	c.Check(msg.Questions(), HasLen, 0)
+	c.Check(msg.Answers(), DeepEquals, []peercache.DNSTXT{answer})
+}
+
+func (s *mdnsSuite) TestUnpackCompressedAndOtherRecords(c *C) {
+	buf := []byte{
+		0, 0, 0x84, 0, // id, flags
+		0, 0, 0, 2, // no questions, 2 answers
+		0, 0, 0, 0,
+		// A record for Foo.local
+		3, 'F', 'o', 'o', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0, 1, 0, 1, 0, 0, 0, 120, 0, 4, 127, 0, 0, 1,
+		// TXT record for bar.foo.local, compressed
+		3, 'b', 'a', 'r', 0xC0, 12,
+		0, 16, 0x80, 1, 0, 0, 0, 60, 0, 4, 3, 'x', '=', '1',
+	}
+	msg, err := peercache.UnpackDNSMessage(buf)
+	c.Assert(err, IsNil)
+	c.Check(msg.Answers(), DeepEquals, []peercache.DNSTXT{
+		peercache.NewDNSTXT("bar.foo.local", 60, "x=1"),
+	})
+}
+
+func (s *mdnsSuite) TestUnpackErrors(c *C) {
+	for _, buf := range [][]byte{
+		nil,
+		{0, 0, 0, 0, 0, 1},
+		// truncated question
+		{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'f', 'o'},
+		// compression loop
+		{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 16, 0, 1},
+		// truncated answer rdata
+		{0, 0, 0x84, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 16, 0, 1, 0, 0, 0, 1, 0, 10, 1},
+	} {
+		_, err := peercache.UnpackDNSMessage(buf)
+		c.Check(err, NotNil, Commentf("%v", buf))
+	}
+}
+
+func (s *mdnsSuite) TestPackInvalidName(c *C) {
+	_, err := peercache.NewDNSQuery([]string{"foo..local"}, false).Pack()
+	c.Check(err, ErrorMatches, `invalid dns name "foo..local"`)
+
+	long := make([]byte, 64)
+	for i := range long {
+		long[i] = 'a'
+	}
+	_, err = peercache.NewDNSQuery([]string{string(long) + ".local"}, false).Pack()
+	c.Check(err, ErrorMatches, `invalid dns name .*`)
+}
@@ -0,0 +1,443 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package peercache shares the snap blobs of the download cache with
+// the other devices of the local network, and finds the devices
+// offering a given blob.
+//
+// Devices advertise the blobs they have via multicast DNS: a query
+// for the TXT record of <sha3-384>._snap-blob._tcp.local (with the
+// digest split in two labels) is answered by the devices having the
+// blob with the port on which they serve it over HTTP. Blobs are only
+// served to who knows their digest, and whoever downloads them must
+// verify the digest before using them. There is no authentication of
+// the peers, so only the blobs that can be downloaded by anyone must be
+// shared.
+package peercache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snapcore/snapd/logger"
+)
+
+const (
+	// DefaultMDNSAddr is the mDNS multicast address.
+	DefaultMDNSAddr = "224.0.0.251:5353"
+	// DefaultQueryTimeout is how long to wait for answers from peers
+	// by default.
+	DefaultQueryTimeout = 500 * time.Millisecond
+
+	blobService = "_snap-blob._tcp.local"
+	blobsPath   = "/v1/blobs/"
+	// blobTTL is the TTL in seconds of the advertisement of a blob
+	blobTTL = 120
+	// sha3_384Len is the length of an hex encoded sha3-384 digest
+	sha3_384Len = 96
+
+	maxMessageSize = 9000
+)
+
+// Options holds the optional settings of a Peer.
+type Options struct {
+	// MDNSAddr is the address where the peers are queried and where
+	// the queries are answered, DefaultMDNSAddr if unset. A unicast
+	// address can be used for testing.
+	MDNSAddr string
+	// HTTPAddr is the address on which the blobs are served, any
+	// port on all interfaces, or on Interface, if unset.
+	HTTPAddr string
+	// Interface is the name of the network interface on which the
+	// blobs are shared and looked for, all of them if unset.
+	Interface string
+	// QueryTimeout is how long to wait for answers from peers,
+	// DefaultQueryTimeout if unset.
+	QueryTimeout time.Duration
+	// Shareable returns whether the blob with the given sha3-384
+	// digest can be served to peers. No blob is served if unset.
+	Shareable func(sha3_384 string) bool
+}
+
+// Peer shares the blobs of a download cache directory with the other
+// peers of the local network, and finds the peers offering a blob.
+type Peer struct {
+	cacheDir     string
+	mdnsAddr     string
+	httpAddr     string
+	iface        string
+	queryTimeout time.Duration
+	shareable    func(sha3_384 string) bool
+
+	mu         sync.Mutex
+	mdnsConn   *net.UDPConn
+	httpServer *http.Server
+	port       int
+	// localIP is the address of the interface the blobs are shared
+	// on, if any
+	localIP net.IP
+	wg      sync.WaitGroup
+}
+
+// New returns a Peer sharing the blobs of the given download cache
+// directory once started.
+func New(cacheDir string, opts *Options) *Peer {
+	if opts == nil {
+		opts = &Options{}
+	}
+	p := &Peer{
+		cacheDir:     cacheDir,
+		mdnsAddr:     opts.MDNSAddr,
+		httpAddr:     opts.HTTPAddr,
+		iface:        opts.Interface,
+		queryTimeout: opts.QueryTimeout,
+		shareable:    opts.Shareable,
+	}
+	if p.mdnsAddr == "" {
+		p.mdnsAddr = DefaultMDNSAddr
+	}
+	if p.shareable == nil {
+		p.shareable = func(string) bool { return false }
+	}
+	if p.queryTimeout == 0 {
+		p.queryTimeout = DefaultQueryTimeout
+	}
+	return p
+}
+
+func validDigest(sha3_384 string) bool {
+	if len(sha3_384) != sha3_384Len {
+		return false
+	}
+	for _, c := range sha3_384 {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func blobName(sha3_384 string) string {
+	return sha3_384[:sha3_384Len/2] + "." + sha3_384[sha3_384Len/2:] + "." + blobService
+}
+
+func digestFromBlobName(name string) (string, bool) {
+	labels := strings.TrimSuffix(strings.TrimSuffix(name, "."), "."+blobService)
+	if labels == name {
+		return "", false
+	}
+	sha3_384 := strings.Replace(labels, ".", "", 1)
+	if !validDigest(sha3_384) {
+		return "", false
+	}
+	return sha3_384, true
+}
+
+// interfaceIPv4 returns the first IPv4 address of the interface.
+func interfaceIPv4(ifi *net.Interface) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address")
+}
+
+// Start starts serving the blobs over HTTP and answering the mDNS
+// queries for them.
+func (p *Peer) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.httpServer != nil {
+		return errors.New("internal error: peer cache already started")
+	}
+
+	var ifi *net.Interface
+	var localIP net.IP
+	httpAddr := p.httpAddr
+	if p.iface != "" {
+		var err error
+		ifi, err = net.InterfaceByName(p.iface)
+		if err == nil {
+			localIP, err = interfaceIPv4(ifi)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot use interface %q: %v", p.iface, err)
+		}
+		if httpAddr == "" {
+			httpAddr = net.JoinHostPort(localIP.String(), "0")
+		}
+	}
+	if httpAddr == "" {
+		httpAddr = ":0"
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", p.mdnsAddr)
+	if err != nil {
+		return err
+	}
+	var conn *net.UDPConn
+	if addr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp4", ifi, addr)
+	} else {
+		conn, err = net.ListenUDP("udp4", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot listen for mDNS queries: %v", err)
+	}
+
+	l, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("cannot listen for blob requests: %v", err)
+	}
+
+	srv := &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	p.mdnsConn, p.httpServer, p.port, p.localIP = conn, srv, port, localIP
+
+	p.wg.Add(2)
+	go func() {
+		defer p.wg.Done()
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Noticef("cannot serve blobs to peers: %v", err)
+		}
+	}()
+	go func() {
+		defer p.wg.Done()
+		p.answerQueries(conn, port)
+	}()
+
+	return nil
+}
+
+// Stop stops sharing the blobs.
+func (p *Peer) Stop() error {
+	p.mu.Lock()
+	conn, srv := p.mdnsConn, p.httpServer
+	p.mdnsConn, p.httpServer = nil, nil
+	p.mu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+
+	err := srv.Close()
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	p.wg.Wait()
+	return err
+}
+
+// Port returns the port on which the blobs are served, or 0 if the
+// peer is not started.
+func (p *Peer) Port() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.httpServer == nil {
+		return 0
+	}
+	return p.port
+}
+
+func (p *Peer) blobPath(sha3_384 string) string {
+	return filepath.Join(p.cacheDir, sha3_384)
+}
+
+// hasBlob returns whether the blob is in the cache and can be shared.
+func (p *Peer) hasBlob(sha3_384 string) bool {
+	if !p.shareable(sha3_384) {
+		return false
+	}
+	fi, err := os.Stat(p.blobPath(sha3_384))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+func (p *Peer) answerQueries(conn *net.UDPConn, port int) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Noticef("cannot read mDNS query: %v", err)
+			}
+			return
+		}
+		msg, err := unpackDNSMessage(buf[:n])
+		if err != nil || msg.response {
+			continue
+		}
+
+		var answers []dnsTXT
+		for _, name := range msg.questions {
+			sha3_384, ok := digestFromBlobName(name)
+			if !ok || !p.hasBlob(sha3_384) {
+				continue
+			}
+			answers = append(answers, dnsTXT{
+				name: name,
+				ttl:  blobTTL,
+				txt:  []string{"port=" + strconv.Itoa(port)},
+			})
+		}
+		if len(answers) == 0 {
+			continue
+		}
+
+		resp, err := (&dnsMessage{response: true, answers: answers}).pack()
+		if err != nil {
+			logger.Noticef("cannot pack mDNS answer: %v", err)
+			continue
+		}
+		// the queries ask for unicast answers, which also are the
+		// only ones legacy (non port 5353) queriers get
+		if _, err := conn.WriteToUDP(resp, src); err != nil {
+			logger.Debugf("cannot answer mDNS query from %s: %v", src, err)
+		}
+	}
+}
+
+// ServeHTTP serves the shareable blobs of the cache.
+func (p *Peer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sha3_384 := strings.TrimPrefix(r.URL.Path, blobsPath)
+	if sha3_384 == r.URL.Path || !validDigest(sha3_384) || !p.shareable(sha3_384) {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(p.blobPath(sha3_384))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, sha3_384, fi.ModTime(), f)
+}
+
+// Find returns the URLs from which the peers answering within the
+// query timeout offer the blob with the given sha3-384 digest.
+func (p *Peer) Find(ctx context.Context, sha3_384 string) ([]string, error) {
+	if !validDigest(sha3_384) {
+		return nil, fmt.Errorf("invalid sha3-384 digest %q", sha3_384)
+	}
+	addr, err := net.ResolveUDPAddr("udp4", p.mdnsAddr)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	localIP := p.localIP
+	p.mu.Unlock()
+	var laddr *net.UDPAddr
+	if localIP != nil {
+		laddr = &net.UDPAddr{IP: localIP}
+	}
+	conn, err := net.ListenUDP("udp4", laddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	name := blobName(sha3_384)
+	query, err := (&dnsMessage{questions: []string{name}, unicast: true}).pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(query, addr); err != nil {
+		return nil, fmt.Errorf("cannot query peers: %v", err)
+	}
+
+	deadline := time.Now().Add(p.queryTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+		msg, err := unpackDNSMessage(buf[:n])
+		if err != nil || !msg.response {
+			continue
+		}
+		for _, a := range msg.answers {
+			if a.name != name {
+				continue
+			}
+			port := txtValue(a.txt, "port")
+			if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+				continue
+			}
+			u := fmt.Sprintf("http://%s%s%s", net.JoinHostPort(src.IP.String(), port), blobsPath, sha3_384)
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func txtValue(txt []string, key string) string {
+	for _, kv := range txt {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
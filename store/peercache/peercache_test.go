@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package peercache_test
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "golang.org/x/crypto/sha3"
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/store/peercache"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type peerCacheSuite struct {
+	cacheDir string
+	sha3_384 string
+	shared   map[string]bool
+	peer     *peercache.Peer
+}
+
+var _ = Suite(&peerCacheSuite{})
+
+func digest(content string) string {
+	h := crypto.SHA3_384.New()
+	h.Write([]byte(content))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+func (s *peerCacheSuite) SetUpTest(c *C) {
+	s.cacheDir = c.MkDir()
+	s.sha3_384 = digest("snap blob")
+	c.Assert(os.WriteFile(filepath.Join(s.cacheDir, s.sha3_384), []byte("snap blob"), 0600), IsNil)
+	s.shared = map[string]bool{s.sha3_384: true}
+
+	s.peer = peercache.New(s.cacheDir, &peercache.Options{
+		MDNSAddr:  "127.0.0.1:0",
+		HTTPAddr:  "127.0.0.1:0",
+		Shareable: func(sha3_384 string) bool { return s.shared[sha3_384] },
+	})
+	c.Assert(s.peer.Start(), IsNil)
+}
+
+func (s *peerCacheSuite) TearDownTest(c *C) {
+	c.Check(s.peer.Stop(), IsNil)
+}
+
+func (s *peerCacheSuite) finder(c *C) *peercache.Peer {
+	return peercache.New(c.MkDir(), &peercache.Options{
+		MDNSAddr:     s.peer.MDNSLocalAddr(),
+		QueryTimeout: 200 * time.Millisecond,
+	})
+}
+
+func (s *peerCacheSuite) TestBlobName(c *C) {
+	c.Check(peercache.BlobName(s.sha3_384), Equals, s.sha3_384[:48]+"."+s.sha3_384[48:]+"._snap-blob._tcp.local")
+}
+
+func (s *peerCacheSuite) TestFindAndDownload(c *C) {
+	urls, err := s.finder(c).Find(context.Background(), s.sha3_384)
+	c.Assert(err, IsNil)
+	c.Assert(urls, DeepEquals, []string{
+		fmt.Sprintf("http://127.0.0.1:%d/v1/blobs/%s", s.peer.Port(), s.sha3_384),
+	})
+
+	resp, err := http.Get(urls[0])
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+	content, err := io.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "snap blob")
+}
+
+func (s *peerCacheSuite) TestFindNotShared(c *C) {
+	urls, err := s.finder(c).Find(context.Background(), digest("other blob"))
+	c.Assert(err, IsNil)
+	c.Check(urls, HasLen, 0)
+}
+
+func (s *peerCacheSuite) TestNotShareable(c *C) {
+	private := digest("private blob")
+	c.Assert(os.WriteFile(filepath.Join(s.cacheDir, private), []byte("private blob"), 0600), IsNil)
+
+	urls, err := s.finder(c).Find(context.Background(), private)
+	c.Assert(err, IsNil)
+	c.Check(urls, HasLen, 0)
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/v1/blobs/%s", s.peer.Port(), private))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 404)
+}
+
+func (s *peerCacheSuite) TestNothingShareableByDefault(c *C) {
+	peer := peercache.New(s.cacheDir, &peercache.Options{
+		MDNSAddr: "127.0.0.1:0",
+		HTTPAddr: "127.0.0.1:0",
+	})
+	c.Assert(peer.Start(), IsNil)
+	defer peer.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/v1/blobs/%s", peer.Port(), s.sha3_384))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 404)
+}
+
+func (s *peerCacheSuite) TestInterface(c *C) {
+	peer := peercache.New(s.cacheDir, &peercache.Options{
+		MDNSAddr:  "127.0.0.1:0",
+		Interface: "lo",
+		Shareable: func(sha3_384 string) bool { return true },
+	})
+	c.Assert(peer.Start(), IsNil)
+	defer peer.Stop()
+	c.Check(peer.LocalIP(), Equals, "127.0.0.1")
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/v1/blobs/%s", peer.Port(), s.sha3_384))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 200)
+
+	unknown := peercache.New(s.cacheDir, &peercache.Options{
+		MDNSAddr:  "127.0.0.1:0",
+		Interface: "no-such-iface",
+	})
+	c.Check(unknown.Start(), ErrorMatches, `cannot use interface "no-such-iface": .*`)
+}
+
+func (s *peerCacheSuite) TestFindInvalidDigest(c *C) {
+	_, err := s.finder(c).Find(context.Background(), "../../etc/passwd")
+	c.Check(err, ErrorMatches, `invalid sha3-384 digest "../../etc/passwd"`)
+}
+
+func (s *peerCacheSuite) TestFindCancelled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.finder(c).Find(ctx, s.sha3_384)
+	c.Check(err, Equals, context.Canceled)
+}
+
+func (s *peerCacheSuite) TestServeErrors(c *C) {
+	base := fmt.Sprintf("http://127.0.0.1:%d", s.peer.Port())
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "/v1/blobs/" + digest("other blob"), 404},
+		{"GET", "/v1/blobs/..%2F..%2Fetc%2Fpasswd", 404},
+		{"GET", "/v1/other/" + s.sha3_384, 404},
+		{"POST", "/v1/blobs/" + s.sha3_384, 405},
+		{"HEAD", "/v1/blobs/" + s.sha3_384, 200},
+	} {
+		req, err := http.NewRequest(tc.method, base+tc.path, nil)
+		c.Assert(err, IsNil)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, Equals, tc.status, Commentf("%s %s", tc.method, tc.path))
+	}
+}
+
+func (s *peerCacheSuite) TestStartStop(c *C) {
+	c.Check(s.peer.Start(), ErrorMatches, "internal error: peer cache already started")
+
+	c.Assert(s.peer.Stop(), IsNil)
+	c.Check(s.peer.Port(), Equals, 0)
+	// stopping again is fine
+	c.Check(s.peer.Stop(), IsNil)
+
+	c.Assert(s.peer.Start(), IsNil)
+	c.Check(s.peer.Port(), Not(Equals), 0)
+}
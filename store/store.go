@@ -165,6 +165,9 @@ type Store struct {
 	suggestedCurrency string
 
 	cacher downloadCache
+	// peers, if set, are tried before the store for downloads,
+	// protected by mu
+	peers PeerFinder
 
 	proxy              func(*http.Request) (*url.URL, error)
 	proxyConnectHeader http.Header
@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -208,6 +209,14 @@ func (s *Store) Download(ctx context.Context, name string, targetPath string, do
 		return nil
 	}
 
+	if peers := s.peerFinder(); peers != nil && downloadInfo.Sha3_384 != "" {
+		err := downloadFromPeers(ctx, peers, name, targetPath, downloadInfo, pbar)
+		if err == nil {
+			return s.cacher.Put(downloadInfo.Sha3_384, targetPath)
+		}
+		logger.Debugf("Cannot download %q from peers, using the store: %v", name, err)
+	}
+
 	if s.useDeltas() {
 		logger.Debugf("Available deltas returned by store: %v", downloadInfo.Deltas)
 
@@ -768,3 +777,119 @@ func (s *Store) SetCacheDownloads(fileCount int) {
 		s.cacher = &nullCache{}
 	}
 }
+
+// PeerFinder finds the peers of the local network offering a snap blob.
+type PeerFinder interface {
+	// Find returns the URLs from which peers offer the blob with the
+	// given sha3-384 digest.
+	Find(ctx context.Context, sha3_384 string) ([]string, error)
+}
+
+// SetPeerFinder sets the finder of peers to try downloading from before
+// the store, or unsets it if nil. Blobs from peers are only kept if
+// their sha3-384 matches downloadInfo.Sha3_384, as given by the store;
+// no assertion is checked here, that is left to the validate-snap step
+// done after the download, against the snap-revision assertion.
+func (s *Store) SetPeerFinder(peers PeerFinder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers = peers
+}
+
+func (s *Store) peerFinder() PeerFinder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peers
+}
+
+var errNoPeers = errors.New("no peer offers the snap")
+
+// peerHTTPClient is the client for downloading from peers, which are
+// on the local network and so not reached via any proxy.
+var peerHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+		}).DialContext,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+func downloadFromPeers(ctx context.Context, peers PeerFinder, name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter) error {
+	urls, err := peers.Find(ctx, downloadInfo.Sha3_384)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return errNoPeers
+	}
+	for _, u := range urls {
+		err := downloadFromPeer(ctx, u, name, targetPath, downloadInfo, pbar)
+		if err == nil {
+			logger.Noticef("Downloaded %q from peer %s", name, u)
+			return nil
+		}
+		logger.Debugf("Cannot download %q from peer %s: %v", name, u, err)
+		if cancelled(ctx) {
+			return err
+		}
+	}
+	return fmt.Errorf("cannot download from any of %d peers", len(urls))
+}
+
+func downloadFromPeer(ctx context.Context, peerURL, name, targetPath string, downloadInfo *snap.DownloadInfo, pbar progress.Meter) (err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", peerURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := peerHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return &DownloadError{Code: resp.StatusCode, URL: resp.Request.URL}
+	}
+
+	partialPath := targetPath + ".peer"
+	w, err := os.OpenFile(partialPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(partialPath)
+		}
+	}()
+
+	if pbar == nil {
+		pbar = progress.Null
+	}
+	var body io.Reader = resp.Body
+	if downloadInfo.Size > 0 {
+		// do not let a peer fill up the disk
+		body = io.LimitReader(resp.Body, downloadInfo.Size+1)
+	}
+	h := crypto.SHA3_384.New()
+	pbar.Start(name, float64(downloadInfo.Size))
+	n, err := io.Copy(io.MultiWriter(w, h, pbar), body)
+	pbar.Finished()
+	if err != nil {
+		return err
+	}
+	if downloadInfo.Size > 0 && n != downloadInfo.Size {
+		return fmt.Errorf("size mismatch for %q: got %d but expected %d", name, n, downloadInfo.Size)
+	}
+	actualSha3 := fmt.Sprintf("%x", h.Sum(nil))
+	if actualSha3 != downloadInfo.Sha3_384 {
+		return HashError{name, actualSha3, downloadInfo.Sha3_384}
+	}
+	if err := w.Sync(); err != nil {
+		return err
+	}
+
+	return os.Rename(partialPath, targetPath)
+}
@@ -758,6 +758,128 @@ func (s *storeDownloadSuite) TestDownloadCacheMiss(c *C) {
 	c.Check(obs.puts, DeepEquals, []string{fmt.Sprintf("the-snaps-sha3_384:%s", path)})
 }
 
+type fakePeerFinder struct {
+	urls  []string
+	err   error
+	finds []string
+}
+
+func (f *fakePeerFinder) Find(ctx context.Context, sha3_384 string) ([]string, error) {
+	f.finds = append(f.finds, sha3_384)
+	return f.urls, f.err
+}
+
+func (s *storeDownloadSuite) TestDownloadFromPeer(c *C) {
+	expectedContent := []byte("I was downloaded from a peer")
+	h := crypto.SHA3_384.New()
+	h.Write(expectedContent)
+	expectedSha3 := fmt.Sprintf("%x", h.Sum(nil))
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/v1/blobs/"+expectedSha3)
+		w.Write(expectedContent)
+	}))
+	defer peer.Close()
+
+	obs := &cacheObserver{inCache: map[string]bool{}}
+	restore := s.store.MockCacher(obs)
+	defer restore()
+
+	restore = store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		c.Fatalf("download from the store should not be called when a peer has the snap")
+		return nil
+	})
+	defer restore()
+
+	finder := &fakePeerFinder{urls: []string{
+		peer.URL + "/v1/blobs/" + expectedSha3,
+	}}
+	s.store.SetPeerFinder(finder)
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.Sha3_384 = expectedSha3
+	snap.Size = int64(len(expectedContent))
+
+	path := filepath.Join(c.MkDir(), "downloaded-file")
+	err := s.store.Download(s.ctx, "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+
+	c.Check(path, testutil.FileEquals, expectedContent)
+	c.Check(path+".peer", testutil.FileAbsent)
+	c.Check(finder.finds, DeepEquals, []string{expectedSha3})
+	c.Check(obs.puts, DeepEquals, []string{fmt.Sprintf("%s:%s", expectedSha3, path)})
+}
+
+func (s *storeDownloadSuite) TestDownloadFromPeerHashMismatchFallsBack(c *C) {
+	expectedContent := []byte("I was downloaded")
+	h := crypto.SHA3_384.New()
+	h.Write(expectedContent)
+	expectedSha3 := fmt.Sprintf("%x", h.Sum(nil))
+
+	badPeerHits := 0
+	badPeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		badPeerHits++
+		// same size but different content
+		w.Write([]byte("I was tampered!!"))
+	}))
+	defer badPeer.Close()
+	gonePeer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer gonePeer.Close()
+
+	downloadWasCalled := false
+	restore := store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		downloadWasCalled = true
+		c.Check(url, Equals, "URL")
+		w.Write(expectedContent)
+		return nil
+	})
+	defer restore()
+
+	s.store.SetPeerFinder(&fakePeerFinder{urls: []string{
+		badPeer.URL + "/v1/blobs/" + expectedSha3,
+		gonePeer.URL + "/v1/blobs/" + expectedSha3,
+	}})
+
+	snap := &snap.Info{}
+	snap.RealName = "foo"
+	snap.DownloadURL = "URL"
+	snap.Sha3_384 = expectedSha3
+	snap.Size = int64(len(expectedContent))
+
+	path := filepath.Join(c.MkDir(), "downloaded-file")
+	err := s.store.Download(s.ctx, "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+
+	c.Check(badPeerHits, Equals, 1)
+	c.Check(downloadWasCalled, Equals, true)
+	c.Check(path, testutil.FileEquals, expectedContent)
+	c.Check(path+".peer", testutil.FileAbsent)
+}
+
+func (s *storeDownloadSuite) TestDownloadPeersNotFoundFallsBack(c *C) {
+	downloadWasCalled := false
+	restore := store.MockDownload(func(ctx context.Context, name, sha3, url string, user *auth.UserState, s *store.Store, w io.ReadWriteSeeker, resume int64, pbar progress.Meter, dlOpts *store.DownloadOptions) error {
+		downloadWasCalled = true
+		return nil
+	})
+	defer restore()
+
+	finder := &fakePeerFinder{err: fmt.Errorf("boom")}
+	s.store.SetPeerFinder(finder)
+
+	snap := &snap.Info{}
+	snap.Sha3_384 = "the-snaps-sha3_384"
+
+	path := filepath.Join(c.MkDir(), "downloaded-file")
+	err := s.store.Download(s.ctx, "foo", path, &snap.DownloadInfo, nil, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(finder.finds, DeepEquals, []string{"the-snaps-sha3_384"})
+	c.Check(downloadWasCalled, Equals, true)
+}
+
 func (s *storeDownloadSuite) TestDownloadStreamOK(c *C) {
 	expectedContent := []byte("I was downloaded")
 	restore := store.MockDoDownloadReq(func(ctx context.Context, url *url.URL, cdnHeader string, resume int64, s *store.Store, user *auth.UserState) (*http.Response, error) {
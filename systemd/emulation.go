@@ -112,6 +112,10 @@ func (s *emulation) CurrentTasksCount(unit string) (uint64, error) {
 	return 0, &notImplementedError{"CurrentTasksCount"}
 }
 
+func (s *emulation) CurrentNetworkEgress(unit string) (quantity.Size, error) {
+	return 0, &notImplementedError{"CurrentNetworkEgress"}
+}
+
 func (s *emulation) IsEnabled(service string) (bool, error) {
 	return false, &notImplementedError{"IsEnabled"}
 }
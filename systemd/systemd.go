@@ -447,6 +447,9 @@ type Systemd interface {
 	// threads if enabled, etc) part of the unit, which can be a service or a
 	// slice.
 	CurrentTasksCount(unit string) (uint64, error)
+	// CurrentNetworkEgress returns the number of bytes sent over the network
+	// by the processes of the unit, which needs IP accounting enabled.
+	CurrentNetworkEgress(unit string) (quantity.Size, error)
 	// Run a command
 	Run(command []string, opts *RunOptions) ([]byte, error)
 	// Set log level for the system
@@ -893,8 +896,9 @@ func (s *systemd) getPropertyUintValue(unit, key string) (uint64, error) {
 	}
 
 	// if the unit is inactive or doesn't exist, the value can be reported as
-	// "[not set]"
-	if valStr == "[not set]" {
+	// "[not set]", and accounting values as "[no data]" when the accounting
+	// is not enabled for the unit
+	if valStr == "[not set]" || valStr == "[no data]" {
 		return 0, errNotSet
 	}
 
@@ -932,6 +936,19 @@ func (s *systemd) CurrentMemoryUsage(unit string) (quantity.Size, error) {
 	return quantity.Size(memBytes), nil
 }
 
+func (s *systemd) CurrentNetworkEgress(unit string) (quantity.Size, error) {
+	egressBytes, err := s.getPropertyUintValue(unit, "IPEgressBytes")
+	if err != nil && err != errNotSet {
+		return 0, err
+	}
+
+	if err == errNotSet {
+		return 0, fmt.Errorf("network egress usage unavailable")
+	}
+
+	return quantity.Size(egressBytes), nil
+}
+
 func (s *systemd) InactiveEnterTimestamp(unit string) (time.Time, error) {
 	timeStr, err := s.getPropertyStringValue(unit, "InactiveEnterTimestamp")
 	if err != nil {
@@ -2485,6 +2485,27 @@ func (s *SystemdTestSuite) TestCurrentUsageFamilyHappy(c *C) {
 	})
 }
 
+func (s *SystemdTestSuite) TestCurrentNetworkEgress(c *C) {
+	s.outs = [][]byte{
+		[]byte(`IPEgressBytes=123456`),
+		[]byte(`IPEgressBytes=[no data]`),
+		[]byte(`IPEgressBytes=lots`),
+	}
+	sysd := New(SystemMode, s.rep)
+	egress, err := sysd.CurrentNetworkEgress("snap.foo.slice")
+	c.Assert(err, IsNil)
+	c.Check(egress, Equals, quantity.Size(123456))
+	_, err = sysd.CurrentNetworkEgress("snap.foo.slice")
+	c.Check(err, ErrorMatches, "network egress usage unavailable")
+	_, err = sysd.CurrentNetworkEgress("snap.foo.slice")
+	c.Check(err, ErrorMatches, `invalid property value from systemd for IPEgressBytes: cannot parse "lots" as an integer`)
+	c.Check(s.argses, DeepEquals, [][]string{
+		{"show", "--property", "IPEgressBytes", "snap.foo.slice"},
+		{"show", "--property", "IPEgressBytes", "snap.foo.slice"},
+		{"show", "--property", "IPEgressBytes", "snap.foo.slice"},
+	})
+}
+
 func (s *SystemdTestSuite) TestInactiveEnterTimestampZero(c *C) {
 	s.outs = [][]byte{
 		[]byte(`InactiveEnterTimestamp=`),
@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timeutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Blackout is a span of whole days, in local time, during which an
+// event must not happen. The span is either between specific dates or
+// recurs every year.
+type Blackout struct {
+	// start and end are the first and last day of the span, for
+	// yearly spans only their month and day are relevant
+	start date
+	end   date
+	// yearly is set for spans recurring every year, which can cross
+	// the end of the year
+	yearly bool
+}
+
+type date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+func dateOf(t time.Time) date {
+	y, m, d := t.Date()
+	return date{Year: y, Month: m, Day: d}
+}
+
+// ordinal returns a number growing with the date, ignoring the year
+// if yearly is set.
+func (d date) ordinal(yearly bool) int {
+	n := int(d.Month)*100 + d.Day
+	if !yearly {
+		n += d.Year * 10000
+	}
+	return n
+}
+
+func (d date) format(yearly bool) string {
+	if yearly {
+		return fmt.Sprintf("%02d-%02d", d.Month, d.Day)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+func (b *Blackout) String() string {
+	start := b.start.format(b.yearly)
+	if b.start == b.end {
+		return start
+	}
+	return start + ".." + b.end.format(b.yearly)
+}
+
+// Includes returns whether t falls inside the blackout.
+func (b *Blackout) Includes(t time.Time) bool {
+	d := dateOf(t).ordinal(b.yearly)
+	start, end := b.start.ordinal(b.yearly), b.end.ordinal(b.yearly)
+	if start <= end {
+		return start <= d && d <= end
+	}
+	// yearly span crossing the end of the year
+	return d >= start || d <= end
+}
+
+// Until returns the end of the blackout including t, that is the
+// start of the day following its last one in the location of t. The
+// result is meaningless if t is not included in the blackout.
+func (b *Blackout) Until(t time.Time) time.Time {
+	end := b.end
+	if b.yearly {
+		end.Year = t.Year()
+		if dateOf(t).ordinal(true) > end.ordinal(true) {
+			// the span crosses the end of the year
+			end.Year++
+		}
+	}
+	return time.Date(end.Year, end.Month, end.Day+1, 0, 0, 0, 0, t.Location())
+}
+
+func parseBlackoutDate(s string) (d date, yearly bool, err error) {
+	layout := "2006-01-02"
+	if strings.Count(s, "-") == 1 {
+		layout = "01-02"
+		yearly = true
+	}
+	// the year 0 used for dates without one is a leap year, so 02-29
+	// is accepted
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return d, false, fmt.Errorf("cannot parse %q: not a valid date", s)
+	}
+	d = dateOf(t)
+	if yearly {
+		d.Year = 0
+	}
+	return d, yearly, nil
+}
+
+func parseBlackout(s string) (*Blackout, error) {
+	startStr, endStr, isSpan := strings.Cut(s, "..")
+	if !isSpan {
+		endStr = startStr
+	}
+
+	start, yearly, err := parseBlackoutDate(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, endYearly, err := parseBlackoutDate(endStr)
+	if err != nil {
+		return nil, err
+	}
+	if yearly != endYearly {
+		return nil, fmt.Errorf("cannot parse %q: dates with and without a year cannot be mixed", s)
+	}
+	if !yearly && end.ordinal(false) < start.ordinal(false) {
+		return nil, fmt.Errorf("cannot parse %q: end date is before the start date", s)
+	}
+
+	return &Blackout{start: start, end: end, yearly: yearly}, nil
+}
+
+// ParseBlackouts parses a comma separated list of blackout spans,
+// each being a date or two dates separated by "..", inclusive. Dates
+// are in the form YYYY-MM-DD, or MM-DD for spans recurring every
+// year, e.g.:
+//
+// 2026-04-03 (the 3rd of April 2026)
+// 12-20..01-05 (from the 20th of December to the 5th of January, every year)
+// 2026-12-20..2026-12-31,2027-04-03
+func ParseBlackouts(spec string) ([]*Blackout, error) {
+	var blackouts []*Blackout
+	for _, s := range strings.Split(spec, ",") {
+		b, err := parseBlackout(s)
+		if err != nil {
+			return nil, err
+		}
+		blackouts = append(blackouts, b)
+	}
+	return blackouts, nil
+}
+
+// InBlackout returns the blackout including t, if any.
+func InBlackout(blackouts []*Blackout, t time.Time) *Blackout {
+	for _, b := range blackouts {
+		if b.Includes(t) {
+			return b
+		}
+	}
+	return nil
+}
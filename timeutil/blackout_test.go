@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2026 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timeutil_test
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/snapcore/snapd/timeutil"
+)
+
+type blackoutSuite struct{}
+
+var _ = Suite(&blackoutSuite{})
+
+func (ts *blackoutSuite) TestParseBlackoutsHappy(c *C) {
+	for _, t := range []struct {
+		in  string
+		out []string
+	}{
+		{"2026-04-03", []string{"2026-04-03"}},
+		{"2026-04-03..2026-04-03", []string{"2026-04-03"}},
+		{"2026-12-20..2027-01-05", []string{"2026-12-20..2027-01-05"}},
+		{"12-20..12-31", []string{"12-20..12-31"}},
+		{"12-20..01-05", []string{"12-20..01-05"}},
+		{"02-29", []string{"02-29"}},
+		{"12-24,2026-04-03..2026-04-06", []string{"12-24", "2026-04-03..2026-04-06"}},
+	} {
+		blackouts, err := timeutil.ParseBlackouts(t.in)
+		c.Assert(err, IsNil, Commentf("%q", t.in))
+		var out []string
+		for _, b := range blackouts {
+			out = append(out, b.String())
+		}
+		c.Check(out, DeepEquals, t.out, Commentf("%q", t.in))
+	}
+}
+
+func (ts *blackoutSuite) TestParseBlackoutsUnhappy(c *C) {
+	for _, t := range []struct {
+		in  string
+		err string
+	}{
+		{"", `cannot parse "": not a valid date`},
+		{"2026-4-3", `cannot parse "2026-4-3": not a valid date`},
+		{"2026-02-30", `cannot parse "2026-02-30": not a valid date`},
+		{"13-01", `cannot parse "13-01": not a valid date`},
+		{"12-20..", `cannot parse "": not a valid date`},
+		{"2026-12-20..12-31", `cannot parse "2026-12-20..12-31": dates with and without a year cannot be mixed`},
+		{"2027-01-05..2026-12-20", `cannot parse "2027-01-05..2026-12-20": end date is before the start date`},
+		{"12-24,,12-31", `cannot parse "": not a valid date`},
+	} {
+		_, err := timeutil.ParseBlackouts(t.in)
+		c.Check(err, ErrorMatches, t.err, Commentf("%q", t.in))
+	}
+}
+
+func (ts *blackoutSuite) TestBlackoutIncludesUntil(c *C) {
+	loc := time.FixedZone("test", 3*60*60)
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 12, 30, 0, 0, loc)
+	}
+	midnight := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+
+	for _, t := range []struct {
+		spec     string
+		t        time.Time
+		included bool
+		until    time.Time
+	}{
+		{"2026-12-20..2026-12-31", day(2026, 12, 19), false, time.Time{}},
+		{"2026-12-20..2026-12-31", day(2026, 12, 20), true, midnight(2027, 1, 1)},
+		{"2026-12-20..2026-12-31", day(2026, 12, 31), true, midnight(2027, 1, 1)},
+		{"2026-12-20..2026-12-31", day(2027, 12, 25), false, time.Time{}},
+		{"2026-04-03", midnight(2026, 4, 3), true, midnight(2026, 4, 4)},
+		{"12-20..12-31", day(2030, 12, 25), true, midnight(2031, 1, 1)},
+		{"12-20..12-31", day(2030, 1, 1), false, time.Time{}},
+		{"12-20..01-05", day(2030, 12, 25), true, midnight(2031, 1, 6)},
+		{"12-20..01-05", day(2031, 1, 5), true, midnight(2031, 1, 6)},
+		{"12-20..01-05", day(2031, 1, 6), false, time.Time{}},
+		{"02-29", day(2028, 2, 29), true, midnight(2028, 3, 1)},
+		{"02-29", day(2027, 3, 1), false, time.Time{}},
+	} {
+		blackouts, err := timeutil.ParseBlackouts(t.spec)
+		c.Assert(err, IsNil)
+		c.Assert(blackouts, HasLen, 1)
+		b := blackouts[0]
+
+		comment := Commentf("%s at %s", t.spec, t.t)
+		c.Check(b.Includes(t.t), Equals, t.included, comment)
+		if t.included {
+			c.Check(b.Until(t.t).Equal(t.until), Equals, true, comment)
+		}
+	}
+}
+
+func (ts *blackoutSuite) TestInBlackout(c *C) {
+	blackouts, err := timeutil.ParseBlackouts("12-24..12-26,2026-04-03")
+	c.Assert(err, IsNil)
+
+	c.Check(timeutil.InBlackout(blackouts, time.Date(2026, 4, 3, 10, 0, 0, 0, time.UTC)), Equals, blackouts[1])
+	c.Check(timeutil.InBlackout(blackouts, time.Date(2026, 12, 25, 10, 0, 0, 0, time.UTC)), Equals, blackouts[0])
+	c.Check(timeutil.InBlackout(blackouts, time.Date(2026, 4, 4, 10, 0, 0, 0, time.UTC)), IsNil)
+	c.Check(timeutil.InBlackout(nil, time.Date(2026, 4, 4, 10, 0, 0, 0, time.UTC)), IsNil)
+}
@@ -901,3 +901,40 @@ func Includes(schedule []*Schedule, t time.Time) bool {
 	}
 	return false
 }
+
+// NextIncluded returns the earliest time from t on that falls inside the
+// time range covered by a schedule, or the zero time if there is none
+// within maxDuration.
+func NextIncluded(schedule []*Schedule, t time.Time, maxDuration time.Duration) time.Time {
+	if Includes(schedule, t) {
+		return t
+	}
+
+	var next time.Time
+	limit := t.Add(maxDuration)
+	for _, sched := range schedule {
+		tspans := sched.flattenedClockSpans()
+		// move in 24h jumps like in Schedule.Next, starting the day
+		// before to catch spans crossing midnight
+		for day := t.Add(-24 * time.Hour); day.Before(limit); day = day.Add(24 * time.Hour) {
+			if !next.IsZero() && day.After(next) {
+				break
+			}
+			var found bool
+			for _, tspan := range tspans {
+				start := tspan.Window(day).Start
+				if start.Before(t) || start.After(limit) || !sched.Includes(start) {
+					continue
+				}
+				if next.IsZero() || start.Before(next) {
+					next = start
+				}
+				found = true
+			}
+			if found {
+				break
+			}
+		}
+	}
+	return next
+}
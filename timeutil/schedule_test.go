@@ -20,6 +20,7 @@
 package timeutil_test
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -1274,3 +1275,41 @@ func (ts *timeutilSuite) TestMonthNext(c *C) {
 	}
 
 }
+
+func (ts *timeutilSuite) TestNextIncluded(c *C) {
+	const week = 7 * 24 * time.Hour
+	// 2026-10-14 is a Wednesday
+	at := func(day int, clock string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", fmt.Sprintf("2026-10-%02d %s", day, clock), time.Local)
+		c.Assert(err, IsNil)
+		return t
+	}
+
+	for i, t := range []struct {
+		schedule string
+		t        time.Time
+		max      time.Duration
+		next     time.Time
+	}{
+		// included already
+		{"mon-fri,18:00-23:00", at(14, "19:00"), week, at(14, "19:00")},
+		// later the same day
+		{"mon-fri,18:00-23:00", at(14, "10:00"), week, at(14, "18:00")},
+		// the next day
+		{"mon-fri,18:00-23:00", at(14, "23:30"), week, at(15, "18:00")},
+		// after the weekend
+		{"mon-fri,18:00-23:00", at(16, "23:30"), week, at(19, "18:00")},
+		// earliest of multiple schedules
+		{"sat,02:00-04:00,,wed,20:00-21:00", at(14, "10:00"), week, at(14, "20:00")},
+		{"sat,02:00-04:00,,wed,20:00-21:00", at(14, "21:30"), week, at(17, "02:00")},
+		// span crossing midnight
+		{"22:00-02:00", at(14, "21:00"), week, at(14, "22:00")},
+		// beyond the limit
+		{"sat,02:00-04:00", at(14, "10:00"), 24 * time.Hour, time.Time{}},
+	} {
+		sched, err := timeutil.ParseSchedule(t.schedule)
+		c.Assert(err, IsNil)
+		next := timeutil.NextIncluded(sched, t.t, t.max)
+		c.Check(next.Equal(t.next), Equals, true, Commentf("#%d: %s from %s: got %s, expected %s", i, t.schedule, t.t, next, t.next))
+	}
+}
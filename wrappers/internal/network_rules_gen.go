@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2024 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/snapcore/snapd/snap/quota"
+)
+
+// GenerateQuotaNetworkRulesFile generates the netfilter rules, to be loaded
+// with nft -f, enforcing the network quotas the group is subject to, or nil if
+// there are none.
+//
+// There is one table per group with a network quota, with a rule dropping the
+// outgoing packets of the processes in the cgroup of the slice of the group
+// beyond the limit. The tables of the parent groups are included as well, as
+// the rules refer to the cgroups as they are when the rules are loaded, and
+// the cgroups of the parent groups may have been re-created too.
+func GenerateQuotaNetworkRulesFile(grp *quota.Group) []byte {
+	netGrps := grp.NetworkQuotaGroups()
+	if len(netGrps) == 0 {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "# Network quota rules for snap quota group %s\n", grp.Name)
+	for _, netGrp := range netGrps {
+		table := netGrp.NetworkTableName()
+		cgroupPath := netGrp.SliceCgroupPath()
+		level := strings.Count(cgroupPath, "/") + 1
+		// declaring the table before deleting it makes sure the deletion
+		// does not fail when loading the rules for the first time
+		template := `
+table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+	chain egress {
+		type filter hook output priority 0; policy accept;
+		socket cgroupv2 level %[2]d "%[3]s" limit rate over %[4]d bytes/second burst %[4]d bytes drop
+	}
+}
+`
+		fmt.Fprintf(&buf, template, table, level, cgroupPath, netGrp.NetworkEgressLimit)
+	}
+	return buf.Bytes()
+}
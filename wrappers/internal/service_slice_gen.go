@@ -70,9 +70,17 @@ MemoryAccounting=true
 		valuesTemplate := `MemoryMax=%[1]d
 # for compatibility with older versions of systemd
 MemoryLimit=%[1]d
-
 `
 		fmt.Fprintf(buf, valuesTemplate, grp.MemoryLimit)
+		// the other memory limits are only set along with the memory
+		// limit
+		if grp.MemoryHighLimit != 0 {
+			fmt.Fprintf(buf, "MemoryHigh=%d\n", grp.MemoryHighLimit)
+		}
+		if grp.MemorySwapLimit != nil {
+			fmt.Fprintf(buf, "MemorySwapMax=%d\n", *grp.MemorySwapLimit)
+		}
+		buf.WriteString("\n")
 	}
 	return buf.String()
 }
@@ -90,6 +98,46 @@ TasksAccounting=true
 	return buf.String()
 }
 
+func formatIOGroupSlice(grp *quota.Group) string {
+	// unlike for the other resources, io accounting is only enabled when
+	// needed, as it is not free on every kind of device
+	if len(grp.IOLimits) == 0 {
+		return ""
+	}
+	header := `
+# Always enable io accounting, so the following io limits have an effect
+IOAccounting=true
+`
+	buf := bytes.NewBufferString(header)
+	for _, io := range grp.IOLimits {
+		if io.ReadBandwidth != 0 {
+			fmt.Fprintf(buf, "IOReadBandwidthMax=%s %d\n", io.Device, io.ReadBandwidth)
+		}
+		if io.WriteBandwidth != 0 {
+			fmt.Fprintf(buf, "IOWriteBandwidthMax=%s %d\n", io.Device, io.WriteBandwidth)
+		}
+		if io.ReadIOPS != 0 {
+			fmt.Fprintf(buf, "IOReadIOPSMax=%s %d\n", io.Device, io.ReadIOPS)
+		}
+		if io.WriteIOPS != 0 {
+			fmt.Fprintf(buf, "IOWriteIOPSMax=%s %d\n", io.Device, io.WriteIOPS)
+		}
+	}
+	return buf.String()
+}
+
+func formatNetworkGroupSlice(grp *quota.Group) string {
+	// the limit itself is enforced by the netfilter rules of the group,
+	// the accounting provides the usage
+	if grp.NetworkEgressLimit == 0 {
+		return ""
+	}
+	return `
+# Always enable ip accounting, to keep track of the network usage
+IPAccounting=true
+`
+}
+
 // GenerateQuotaSliceUnitFile generates a systemd slice unit definition for the
 // specified quota group.
 func GenerateQuotaSliceUnitFile(grp *quota.Group) []byte {
@@ -98,6 +146,8 @@ func GenerateQuotaSliceUnitFile(grp *quota.Group) []byte {
 	cpuOptions := formatCpuGroupSlice(grp)
 	memoryOptions := formatMemoryGroupSlice(grp)
 	taskOptions := formatTaskGroupSlice(grp)
+	ioOptions := formatIOGroupSlice(grp)
+	networkOptions := formatNetworkGroupSlice(grp)
 	template := `[Unit]
 Description=Slice for snap quota group %[1]s
 Before=slices.target
@@ -107,6 +157,30 @@ X-Snappy=yes
 `
 
 	fmt.Fprintf(&buf, template, grp.Name)
-	fmt.Fprint(&buf, cpuOptions, memoryOptions, taskOptions)
+	fmt.Fprint(&buf, cpuOptions, memoryOptions, taskOptions, ioOptions, networkOptions)
+	return buf.Bytes()
+}
+
+// GenerateQuotaUserSliceUnitFile generates a systemd slice unit definition
+// for the instances of systemd of the users, for the specified quota group
+// including user apps. The network quota only applies to the system slice
+// of the group, as its rules refer to it.
+func GenerateQuotaUserSliceUnitFile(grp *quota.Group) []byte {
+	buf := bytes.Buffer{}
+
+	cpuOptions := formatCpuGroupSlice(grp)
+	memoryOptions := formatMemoryGroupSlice(grp)
+	taskOptions := formatTaskGroupSlice(grp)
+	ioOptions := formatIOGroupSlice(grp)
+	template := `[Unit]
+Description=Slice for the user apps of snap quota group %[1]s
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+`
+
+	fmt.Fprintf(&buf, template, grp.Name)
+	fmt.Fprint(&buf, cpuOptions, memoryOptions, taskOptions, ioOptions)
 	return buf.Bytes()
 }
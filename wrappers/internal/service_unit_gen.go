@@ -134,6 +134,9 @@ X-Snappy=yes
 
 [Service]
 EnvironmentFile=-/etc/environment
+{{- if .NetworkRulesFile}}
+ExecStartPre={{.NftCommand}} -f {{.NetworkRulesFile}}
+{{- end}}
 ExecStart={{.App.LauncherCommand}}
 SyslogIdentifier={{.App.Snap.InstanceName}}.{{.App.Name}}
 Restart={{.Restart}}
@@ -175,6 +178,9 @@ KillSignal={{.KillSignal}}
 {{- if .OOMAdjustScore }}
 OOMScoreAdjust={{.OOMAdjustScore}}
 {{- end}}
+{{- if .OOMPolicy}}
+OOMPolicy={{.OOMPolicy}}
+{{- end}}
 {{- if .InterfaceServiceSnippets}}
 {{.InterfaceServiceSnippets}}
 {{- end}}
@@ -251,12 +257,15 @@ WantedBy={{.ServicesTarget}}
 		KillMode                 string
 		KillSignal               string
 		OOMAdjustScore           int
+		OOMPolicy                string
 		BusName                  string
 		Before                   []string
 		After                    []string
 		InterfaceServiceSnippets string
 		SliceUnit                string
 		LogNamespace             string
+		NftCommand               string
+		NetworkRulesFile         string
 
 		Home    string
 		EnvVars string
@@ -301,9 +310,22 @@ WantedBy={{.ServicesTarget}}
 	// check the quota group slice
 	if opts.QuotaGroup != nil {
 		wrapperData.SliceUnit = opts.QuotaGroup.SliceFileName()
+		switch opts.QuotaGroup.EffectiveOOMPolicy() {
+		case quota.OOMPolicyKillGroup:
+			wrapperData.OOMPolicy = "kill"
+		case quota.OOMPolicyKillProcess:
+			wrapperData.OOMPolicy = "continue"
+		}
 		if opts.QuotaGroup.JournalQuotaSet() {
 			wrapperData.LogNamespace = opts.QuotaGroup.JournalNamespaceName()
 		}
+		// the network quota rules refer to the cgroup of the system
+		// slice, so they are (re)loaded whenever a system service is
+		// started; user services can neither load them nor match them
+		if opts.QuotaGroup.NetworkQuotaSet() && appInfo.DaemonScope == snap.SystemDaemon {
+			wrapperData.NftCommand = quota.NftCommand
+			wrapperData.NetworkRulesFile = opts.QuotaGroup.NetworkRulesFile()
+		}
 	}
 
 	// Add extra "After" targets
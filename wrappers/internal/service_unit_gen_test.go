@@ -741,6 +741,74 @@ WantedBy=multi-user.target
 `, mountUnitPrefix, mountUnitPrefix))
 }
 
+func (s *serviceUnitGenSuite) TestQuotaGroupOOMPolicy(c *C) {
+	service := &snap.AppInfo{
+		Snap: &snap.Info{
+			SuggestedName: "snap",
+			Version:       "0.3.4",
+			SideInfo:      snap.SideInfo{Revision: snap.R(44)},
+		},
+		Name:        "app",
+		Command:     "bin/foo start",
+		Daemon:      "simple",
+		DaemonScope: snap.SystemDaemon,
+	}
+
+	grp, err := quota.NewGroup("foo", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillGroup).Build())
+	c.Assert(err, IsNil)
+	// the policy of the parent applies unless set
+	sub, err := grp.NewSubGroup("foosub", quota.NewResourcesBuilder().WithCPUPercentage(50).Build())
+	c.Assert(err, IsNil)
+	other, err := quota.NewGroup("bar", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).WithOOMPolicy(quota.OOMPolicyKillProcess).Build())
+	c.Assert(err, IsNil)
+
+	tests := []struct {
+		grp       *quota.Group
+		oomPolicy string
+	}{
+		{grp, "OOMPolicy=kill\n"},
+		{sub, "OOMPolicy=kill\n"},
+		{other, "OOMPolicy=continue\n"},
+	}
+	for _, t := range tests {
+		opts := &internal.SnapServicesUnitOptions{QuotaGroup: t.grp}
+		generatedWrapper, err := internal.GenerateSnapServiceUnitFile(service, opts)
+		c.Assert(err, IsNil)
+		c.Check(string(generatedWrapper), testutil.Contains, "Type=simple\n"+t.oomPolicy+"Slice="+t.grp.SliceFileName()+"\n")
+	}
+}
+
+func (s *serviceUnitGenSuite) TestQuotaGroupNetworkRules(c *C) {
+	service := &snap.AppInfo{
+		Snap: &snap.Info{
+			SuggestedName: "snap",
+			Version:       "0.3.4",
+			SideInfo:      snap.SideInfo{Revision: snap.R(44)},
+		},
+		Name:        "app",
+		Command:     "bin/foo start",
+		Daemon:      "simple",
+		DaemonScope: snap.SystemDaemon,
+	}
+
+	grp, err := quota.NewGroup("foo", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	opts := &internal.SnapServicesUnitOptions{QuotaGroup: grp}
+	generatedWrapper, err := internal.GenerateSnapServiceUnitFile(service, opts)
+	c.Assert(err, IsNil)
+	c.Check(string(generatedWrapper), testutil.Contains, "\nExecStartPre="+quota.NftCommand+" -f "+grp.NetworkRulesFile()+"\nExecStart=/usr/bin/snap run snap.app\n")
+	c.Check(string(generatedWrapper), testutil.Contains, "\nSlice=snap.foo.slice\n")
+
+	// the rules are only loaded by system services, user services can
+	// neither load them nor be matched by them
+	service.DaemonScope = snap.UserDaemon
+	generatedWrapper, err = internal.GenerateSnapServiceUnitFile(service, opts)
+	c.Assert(err, IsNil)
+	c.Check(string(generatedWrapper), Not(testutil.Contains), "ExecStartPre=")
+	c.Check(string(generatedWrapper), testutil.Contains, "\nSlice=snap.foo.slice\n")
+}
+
 func (s *serviceUnitGenSuite) TestQuotaGroupLogNamespace(c *C) {
 	service := &snap.AppInfo{
 		Snap: &snap.Info{
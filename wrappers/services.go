@@ -23,6 +23,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"sort"
@@ -420,6 +421,26 @@ func tryFileUpdate(path string, desiredContent []byte) (old *osutil.MemoryFileSt
 	}
 }
 
+// tryFileRemove removes the file at path, if it exists, and returns its old
+// state so that it can be restored.
+func tryFileRemove(path string) (old *osutil.MemoryFileState, removed bool, err error) {
+	st, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, false, err
+	}
+	return &osutil.MemoryFileState{Content: b, Mode: st.Mode()}, true, nil
+}
+
 type SnapServiceOptions struct {
 	// VitalityRank is the rank of all services in the specified snap used by
 	// the OOM killer when OOM conditions are reached.
@@ -726,6 +747,84 @@ func (es *ensureSnapServicesContext) ensureSnapSlices(quotaGroups *quota.QuotaGr
 		if err := handleSliceModification(grp, path, content); err != nil {
 			return err
 		}
+
+		if err := es.ensureUserSlice(grp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureUserSlice writes the slice unit of the group for the instances of
+// systemd of the users if the group or any of its sub-groups includes user
+// apps, or removes it otherwise.
+func (es *ensureSnapServicesContext) ensureUserSlice(grp *quota.Group) error {
+	path := grp.UserSliceFile()
+	var content []byte
+	var old *osutil.MemoryFileState
+	var modified bool
+	var err error
+	if grp.UserSliceNeeded() {
+		content = internal.GenerateQuotaUserSliceUnitFile(grp)
+		old, modified, err = tryFileUpdate(path, content)
+	} else {
+		old, modified, err = tryFileRemove(path)
+	}
+	if err != nil {
+		return err
+	}
+	if !modified {
+		return nil
+	}
+
+	if es.observeChange != nil {
+		var oldContent []byte
+		if old != nil {
+			oldContent = old.Content
+		}
+		es.observeChange(nil, grp, "user-slice", grp.Name, string(oldContent), string(content))
+	}
+	es.modifiedUnits[path] = old
+	es.userDaemonReloadNeeded = true
+	return nil
+}
+
+// ensureUserAppsSlices records the slice the apps of each of the snaps are
+// placed in when their quota group includes user apps, for "snap run" to pick
+// it up, or removes the record otherwise.
+func (es *ensureSnapServicesContext) ensureUserAppsSlices() error {
+	for s, snapSvcOpts := range es.snaps {
+		var grp *quota.Group
+		if snapSvcOpts != nil {
+			grp = snapSvcOpts.QuotaGroup
+		}
+
+		path := quota.UserAppsSliceFile(s.InstanceName())
+		var content []byte
+		var old *osutil.MemoryFileState
+		var modified bool
+		var err error
+		if grp != nil && grp.UserApps {
+			content = []byte(grp.SliceFileName() + "\n")
+			old, modified, err = tryFileUpdate(path, content)
+		} else {
+			old, modified, err = tryFileRemove(path)
+		}
+		if err != nil {
+			return err
+		}
+		if !modified {
+			continue
+		}
+
+		if es.observeChange != nil {
+			var oldContent []byte
+			if old != nil {
+				oldContent = old.Content
+			}
+			es.observeChange(nil, grp, "user-apps", s.InstanceName(), string(oldContent), string(content))
+		}
+		es.modifiedUnits[path] = old
 	}
 	return nil
 }
@@ -817,6 +916,35 @@ func (es *ensureSnapServicesContext) ensureJournalQuotaServiceUnits(quotaGroups
 	return nil
 }
 
+// ensureNetworkQuotaRules takes care of writing the files with the netfilter
+// rules for all groups subject to network quotas.
+func (es *ensureSnapServicesContext) ensureNetworkQuotaRules(quotaGroups *quota.QuotaGroupSet) error {
+	for _, grp := range quotaGroups.AllQuotaGroups() {
+		if !grp.NetworkQuotaSet() {
+			continue
+		}
+
+		path := grp.NetworkRulesFile()
+		content := internal.GenerateQuotaNetworkRulesFile(grp)
+		old, fileModified, err := tryFileUpdate(path, content)
+		if err != nil {
+			return err
+		}
+
+		if fileModified {
+			if es.observeChange != nil {
+				var oldContent []byte
+				if old != nil {
+					oldContent = old.Content
+				}
+				es.observeChange(nil, grp, "network", grp.Name, string(oldContent), string(content))
+			}
+			es.modifiedUnits[path] = old
+		}
+	}
+	return nil
+}
+
 // EnsureSnapServices will ensure that the specified snap services' file states
 // are up to date with the specified options and infos. It will add new services
 // if those units don't already exist, but it does not delete existing service
@@ -872,6 +1000,14 @@ func EnsureSnapServices(snaps map[*snap.Info]*SnapServiceOptions, opts *EnsureSn
 		return err
 	}
 
+	if err := context.ensureNetworkQuotaRules(quotaGroups); err != nil {
+		return err
+	}
+
+	if err := context.ensureUserAppsSlices(); err != nil {
+		return err
+	}
+
 	return context.reloadModified()
 }
 
@@ -1066,6 +1202,35 @@ func RemoveQuotaGroup(grp *quota.Group, inter Interacter) error {
 			return err
 		}
 	}
+
+	// remove the slice file of the group for the users, if it includes user
+	// apps
+	err = os.Remove(grp.UserSliceFile())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := userDaemonReload(); err != nil {
+			return err
+		}
+	}
+
+	// remove the network quota rules, and the rules of the group that are
+	// loaded, if any
+	if err := os.Remove(grp.NetworkRulesFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if grp.NetworkEgressLimit != 0 && !osutil.IsExecutable(quota.NftCommand) {
+		// without nft the rules could not be loaded either
+		logger.Debugf("cannot delete network quota rules of group %q: %s is not available", grp.Name, quota.NftCommand)
+	} else if grp.NetworkEgressLimit != 0 {
+		cmd := exec.Command(quota.NftCommand, "delete", "table", "inet", grp.NetworkTableName())
+		if output, err := cmd.CombinedOutput(); err != nil {
+			// the rules are not loaded when none of the services
+			// of the group were started since boot
+			logger.Debugf("cannot delete network quota rules of group %q: %v", grp.Name, osutil.OutputErr(output, err))
+		}
+	}
 	return nil
 }
 
@@ -1151,6 +1316,11 @@ func RemoveSnapServices(s *snap.Info, inter Interacter) error {
 		}
 	}
 
+	// remove the record of the slice the apps of the snap are placed in
+	if err := os.Remove(quota.UserAppsSliceFile(s.InstanceName())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
 	// only reload if we actually had services
 	if removedSystem {
 		if err := systemSysd.DaemonReload(); err != nil {
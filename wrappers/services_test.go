@@ -430,6 +430,187 @@ TasksAccounting=true
 	c.Assert(svcFile, testutil.FileEquals, svcContent)
 }
 
+func (s *servicesTestSuite) TestEnsureSnapServicesWithIOQuotas(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+	svcFile := filepath.Join(dirs.GlobalRootDir, "/etc/systemd/system/snap.hello-snap.svc1.service")
+
+	resourceLimits := quota.NewResourcesBuilder().
+		WithIOReadBandwidth("/dev/mmcblk0", 10*quantity.SizeMiB).
+		WithIOWriteBandwidth("/dev/mmcblk0", 5*quantity.SizeMiB).
+		WithIOWriteIOPS("/dev/mmcblk0", 200).
+		WithIOReadIOPS("/dev/sda", 1000).
+		Build()
+	grp, err := quota.NewGroup("foogroup", resourceLimits)
+	c.Assert(err, IsNil)
+
+	m := map[*snap.Info]*wrappers.SnapServiceOptions{
+		info: {QuotaGroup: grp},
+	}
+
+	sliceContent := `[Unit]
+Description=Slice for snap quota group foogroup
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+# Always enable cpu accounting, so the following cpu quota options have an effect
+CPUAccounting=true
+
+# Always enable memory accounting otherwise the MemoryMax setting does nothing.
+MemoryAccounting=true
+# Always enable task accounting in order to be able to count the processes/
+# threads, etc for a slice
+TasksAccounting=true
+
+# Always enable io accounting, so the following io limits have an effect
+IOAccounting=true
+IOReadBandwidthMax=/dev/mmcblk0 10485760
+IOWriteBandwidthMax=/dev/mmcblk0 5242880
+IOWriteIOPSMax=/dev/mmcblk0 200
+IOReadIOPSMax=/dev/sda 1000
+`
+
+	err = wrappers.EnsureSnapServices(m, nil, nil, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"daemon-reload"},
+	})
+
+	c.Check(svcFile, testutil.FileContains, "Slice=snap.foogroup.slice\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.foogroup.slice"), testutil.FileEquals, sliceContent)
+}
+
+func (s *servicesTestSuite) TestEnsureSnapServicesWithMemoryHighSwapAndOOMPolicyQuotas(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+	svcFile := filepath.Join(dirs.GlobalRootDir, "/etc/systemd/system/snap.hello-snap.svc1.service")
+
+	resourceLimits := quota.NewResourcesBuilder().
+		WithMemoryLimit(quantity.SizeGiB).
+		WithMemoryHigh(512 * quantity.SizeMiB).
+		WithMemorySwapMax(256 * quantity.SizeMiB).
+		WithOOMPolicy(quota.OOMPolicyKillGroup).
+		Build()
+	grp, err := quota.NewGroup("foogroup", resourceLimits)
+	c.Assert(err, IsNil)
+
+	m := map[*snap.Info]*wrappers.SnapServiceOptions{
+		info: {QuotaGroup: grp},
+	}
+
+	sliceContent := `[Unit]
+Description=Slice for snap quota group foogroup
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+# Always enable cpu accounting, so the following cpu quota options have an effect
+CPUAccounting=true
+
+# Always enable memory accounting otherwise the MemoryMax setting does nothing.
+MemoryAccounting=true
+MemoryMax=1073741824
+# for compatibility with older versions of systemd
+MemoryLimit=1073741824
+MemoryHigh=536870912
+MemorySwapMax=268435456
+
+# Always enable task accounting in order to be able to count the processes/
+# threads, etc for a slice
+TasksAccounting=true
+`
+
+	err = wrappers.EnsureSnapServices(m, nil, nil, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"daemon-reload"},
+	})
+
+	c.Check(svcFile, testutil.FileContains, "OOMPolicy=kill\nSlice=snap.foogroup.slice\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.foogroup.slice"), testutil.FileEquals, sliceContent)
+}
+
+func (s *servicesTestSuite) TestEnsureSnapServicesWithNetworkQuotas(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+	svcFile := filepath.Join(dirs.GlobalRootDir, "/etc/systemd/system/snap.hello-snap.svc1.service")
+
+	grp, err := quota.NewGroup("foogroup", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(2*quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	sub, err := grp.NewSubGroup("foosub", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	m := map[*snap.Info]*wrappers.SnapServiceOptions{
+		info: {QuotaGroup: sub},
+	}
+
+	sliceContent := `[Unit]
+Description=Slice for snap quota group foosub
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+# Always enable cpu accounting, so the following cpu quota options have an effect
+CPUAccounting=true
+
+# Always enable memory accounting otherwise the MemoryMax setting does nothing.
+MemoryAccounting=true
+# Always enable task accounting in order to be able to count the processes/
+# threads, etc for a slice
+TasksAccounting=true
+
+# Always enable ip accounting, to keep track of the network usage
+IPAccounting=true
+`
+
+	rulesContent := `# Network quota rules for snap quota group foosub
+
+table inet snap-quota-foogroup
+delete table inet snap-quota-foogroup
+table inet snap-quota-foogroup {
+	chain egress {
+		type filter hook output priority 0; policy accept;
+		socket cgroupv2 level 1 "snap.foogroup.slice" limit rate over 2097152 bytes/second burst 2097152 bytes drop
+	}
+}
+
+table inet snap-quota-foosub
+delete table inet snap-quota-foosub
+table inet snap-quota-foosub {
+	chain egress {
+		type filter hook output priority 0; policy accept;
+		socket cgroupv2 level 2 "snap.foogroup.slice/snap.foogroup-foosub.slice" limit rate over 1048576 bytes/second burst 1048576 bytes drop
+	}
+}
+`
+
+	var networkChanges []string
+	observe := func(app *snap.AppInfo, grp *quota.Group, unitType string, name, old, new string) {
+		if unitType == "network" {
+			networkChanges = append(networkChanges, name)
+		}
+	}
+
+	err = wrappers.EnsureSnapServices(m, nil, observe, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"daemon-reload"},
+	})
+
+	rulesFile := filepath.Join(dirs.SnapNetworkQuotaDir, "snap.foosub.nft")
+	c.Check(svcFile, testutil.FileContains, "\nExecStartPre=/usr/sbin/nft -f "+rulesFile+"\nExecStart=/usr/bin/snap run hello-snap.svc1\n")
+	c.Check(svcFile, testutil.FileContains, "Slice=snap.foogroup-foosub.slice\n")
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.foogroup-foosub.slice"), testutil.FileEquals, sliceContent)
+	c.Check(filepath.Join(dirs.SnapServicesDir, "snap.foogroup.slice"), testutil.FileContains, "IPAccounting=true\n")
+	c.Check(rulesFile, testutil.FileEquals, rulesContent)
+	c.Check(filepath.Join(dirs.SnapNetworkQuotaDir, "snap.foogroup.nft"), testutil.FileContains, "# Network quota rules for snap quota group foogroup\n")
+	c.Check(networkChanges, testutil.DeepUnsortedMatches, []string{"foogroup", "foosub"})
+
+	// nothing changes the second time around
+	networkChanges = nil
+	err = wrappers.EnsureSnapServices(m, nil, observe, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(networkChanges, HasLen, 0)
+}
+
 func (s *servicesTestSuite) TestEnsureSnapServicesWithZeroCpuCountAndCpuSetQuotas(c *C) {
 	// Another special case, if the cpu count is zero it needs to automatically scale as the
 	// previous test, but only up the maximum allowed provided in the cpu-set. So in this test
@@ -1543,6 +1724,156 @@ MemoryLimit=1024
 	c.Assert(sliceFile, testutil.FileAbsent)
 }
 
+func (s *servicesTestSuite) TestEnsureSnapServicesWithUserAppsQuotas(c *C) {
+	info := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
+
+	grp, err := quota.NewGroup("foogroup", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build())
+	c.Assert(err, IsNil)
+	sub, err := grp.NewSubGroup("foosub", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+	sub.UserApps = true
+
+	m := map[*snap.Info]*wrappers.SnapServiceOptions{
+		info: {QuotaGroup: sub},
+	}
+
+	userSliceContent := `[Unit]
+Description=Slice for the user apps of snap quota group foosub
+Before=slices.target
+X-Snappy=yes
+
+[Slice]
+# Always enable cpu accounting, so the following cpu quota options have an effect
+CPUAccounting=true
+
+# Always enable memory accounting otherwise the MemoryMax setting does nothing.
+MemoryAccounting=true
+MemoryMax=1048576
+# for compatibility with older versions of systemd
+MemoryLimit=1048576
+
+# Always enable task accounting in order to be able to count the processes/
+# threads, etc for a slice
+TasksAccounting=true
+`
+
+	var changes []string
+	observe := func(app *snap.AppInfo, grp *quota.Group, unitType string, name, old, new string) {
+		if unitType == "user-slice" || unitType == "user-apps" {
+			changes = append(changes, unitType+":"+name)
+		}
+	}
+
+	err = wrappers.EnsureSnapServices(m, nil, observe, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"daemon-reload"},
+		{"--user", "daemon-reload"},
+	})
+
+	// the slices of the group and its parent are written for the users
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foogroup-foosub.slice"), testutil.FileEquals, userSliceContent)
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foogroup.slice"), testutil.FileContains, "MemoryMax=1073741824\n")
+	c.Check(filepath.Join(dirs.SnapUserAppsQuotaDir, "hello-snap"), testutil.FileEquals, "snap.foogroup-foosub.slice\n")
+	c.Check(changes, testutil.DeepUnsortedMatches, []string{"user-slice:foogroup", "user-slice:foosub", "user-apps:hello-snap"})
+
+	slice, err := quota.UserAppsSlice("hello-snap")
+	c.Assert(err, IsNil)
+	c.Check(slice, Equals, "snap.foogroup-foosub.slice")
+
+	// nothing changes the second time around
+	s.sysdLog = nil
+	changes = nil
+	err = wrappers.EnsureSnapServices(m, nil, observe, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, HasLen, 0)
+	c.Check(changes, HasLen, 0)
+
+	// and all is removed once the group no longer includes user apps
+	sub.UserApps = false
+	err = wrappers.EnsureSnapServices(m, nil, observe, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"--user", "daemon-reload"},
+	})
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foogroup-foosub.slice"), testutil.FileAbsent)
+	c.Check(filepath.Join(dirs.SnapUserServicesDir, "snap.foogroup.slice"), testutil.FileAbsent)
+	c.Check(filepath.Join(dirs.SnapUserAppsQuotaDir, "hello-snap"), testutil.FileAbsent)
+	c.Check(changes, testutil.DeepUnsortedMatches, []string{"user-slice:foogroup", "user-slice:foosub", "user-apps:hello-snap"})
+
+	slice, err = quota.UserAppsSlice("hello-snap")
+	c.Assert(err, IsNil)
+	c.Check(slice, Equals, "")
+}
+
+func (s *servicesTestSuite) TestRemoveQuotaGroupWithUserApps(c *C) {
+	grp, err := quota.NewGroup("foogroup", quota.NewResourcesBuilder().WithMemoryLimit(quantity.SizeGiB).Build())
+	c.Assert(err, IsNil)
+	grp.UserApps = true
+
+	userSliceFile := filepath.Join(dirs.SnapUserServicesDir, "snap.foogroup.slice")
+	c.Assert(os.MkdirAll(dirs.SnapUserServicesDir, 0755), IsNil)
+	c.Assert(os.WriteFile(userSliceFile, []byte("[Slice]\n"), 0644), IsNil)
+
+	err = wrappers.RemoveQuotaGroup(grp, progress.Null)
+	c.Assert(err, IsNil)
+
+	c.Check(userSliceFile, testutil.FileAbsent)
+	c.Check(s.sysdLog, DeepEquals, [][]string{
+		{"--user", "daemon-reload"},
+	})
+}
+
+func (s *servicesTestSuite) TestRemoveQuotaGroupWithNetworkQuota(c *C) {
+	nft := testutil.MockCommand(c, "nft", "")
+	defer nft.Restore()
+	r := testutil.Backup(&quota.NftCommand)
+	defer r()
+	quota.NftCommand = nft.Exe()
+
+	grp, err := quota.NewGroup("foogroup", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	rulesFile := filepath.Join(dirs.SnapNetworkQuotaDir, "snap.foogroup.nft")
+	c.Assert(os.MkdirAll(dirs.SnapNetworkQuotaDir, 0755), IsNil)
+	c.Assert(os.WriteFile(rulesFile, []byte("# rules"), 0644), IsNil)
+
+	err = wrappers.RemoveQuotaGroup(grp, progress.Null)
+	c.Assert(err, IsNil)
+
+	c.Check(rulesFile, testutil.FileAbsent)
+	c.Check(nft.Calls(), DeepEquals, [][]string{
+		{"nft", "delete", "table", "inet", "snap-quota-foogroup"},
+	})
+
+	// the rules not being loaded is not an error
+	nftFail := testutil.MockCommand(c, "nft", "echo 'No such file or directory'; exit 1")
+	defer nftFail.Restore()
+	quota.NftCommand = nftFail.Exe()
+
+	err = wrappers.RemoveQuotaGroup(grp, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(nftFail.Calls(), HasLen, 1)
+}
+
+func (s *servicesTestSuite) TestRemoveQuotaGroupWithNetworkQuotaNoNft(c *C) {
+	r := testutil.Backup(&quota.NftCommand)
+	defer r()
+	quota.NftCommand = filepath.Join(c.MkDir(), "nft")
+
+	grp, err := quota.NewGroup("foogroup", quota.NewResourcesBuilder().WithNetworkEgressBandwidth(quantity.SizeMiB).Build())
+	c.Assert(err, IsNil)
+
+	rulesFile := filepath.Join(dirs.SnapNetworkQuotaDir, "snap.foogroup.nft")
+	c.Assert(os.MkdirAll(dirs.SnapNetworkQuotaDir, 0755), IsNil)
+	c.Assert(os.WriteFile(rulesFile, []byte("# rules"), 0644), IsNil)
+
+	// the rules could not have been loaded without nft
+	err = wrappers.RemoveQuotaGroup(grp, progress.Null)
+	c.Assert(err, IsNil)
+	c.Check(rulesFile, testutil.FileAbsent)
+}
+
 func (s *servicesTestSuite) TestEnsureSnapServicesWithSubGroupQuotaGroupsForSnaps(c *C) {
 	info1 := snaptest.MockSnap(c, packageHello, &snap.SideInfo{Revision: snap.R(12)})
 	info2 := snaptest.MockSnap(c, `